@@ -0,0 +1,12 @@
+// Command elephant-mock serves the Elephant Tracker API from memory, without
+// MongoDB, with scriptable responses for the end-to-end tests of clients,
+// see package tracker.
+package main
+
+import (
+	"github.com/rhcarvalho/elephant-tracker/tracker"
+)
+
+func main() {
+	tracker.MockMain()
+}
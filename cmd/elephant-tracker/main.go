@@ -0,0 +1,11 @@
+// Command elephant-tracker serves the Elephant Tracker API, see package
+// tracker for the API and the subcommands.
+package main
+
+import (
+	"github.com/rhcarvalho/elephant-tracker/tracker"
+)
+
+func main() {
+	tracker.Main()
+}
@@ -0,0 +1,8 @@
+// Package trackerpb is the Go code of the gRPC interface of ../tracker.proto,
+// generated by protoc-gen-go and protoc-gen-go-grpc, from the root of the
+// repository, with:
+//
+//	protoc -I proto --go_out=proto/trackerpb --go_opt=paths=source_relative \
+//		--go-grpc_out=proto/trackerpb --go-grpc_opt=paths=source_relative \
+//		proto/tracker.proto
+package trackerpb
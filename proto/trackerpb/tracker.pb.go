@@ -0,0 +1,761 @@
+// Contract of the gRPC interface of Elephant Tracker, mirroring API v1 as
+// documented in doc.go, served at grpc.host and grpc.port, see the README.
+// The Go code in trackerpb is generated from it.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: tracker.proto
+
+package trackerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type NewInstallationRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	MachineId      string                 `protobuf:"bytes,1,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
+	XmppvoxVersion string                 `protobuf:"bytes,2,opt,name=xmppvox_version,json=xmppvoxVersion,proto3" json:"xmppvox_version,omitempty"`
+	DosvoxInfo     map[string]string      `protobuf:"bytes,3,rep,name=dosvox_info,json=dosvoxInfo,proto3" json:"dosvox_info,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	MachineInfo    map[string]string      `protobuf:"bytes,4,rep,name=machine_info,json=machineInfo,proto3" json:"machine_info,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *NewInstallationRequest) Reset() {
+	*x = NewInstallationRequest{}
+	mi := &file_tracker_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NewInstallationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NewInstallationRequest) ProtoMessage() {}
+
+func (x *NewInstallationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NewInstallationRequest.ProtoReflect.Descriptor instead.
+func (*NewInstallationRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *NewInstallationRequest) GetMachineId() string {
+	if x != nil {
+		return x.MachineId
+	}
+	return ""
+}
+
+func (x *NewInstallationRequest) GetXmppvoxVersion() string {
+	if x != nil {
+		return x.XmppvoxVersion
+	}
+	return ""
+}
+
+func (x *NewInstallationRequest) GetDosvoxInfo() map[string]string {
+	if x != nil {
+		return x.DosvoxInfo
+	}
+	return nil
+}
+
+func (x *NewInstallationRequest) GetMachineInfo() map[string]string {
+	if x != nil {
+		return x.MachineInfo
+	}
+	return nil
+}
+
+type Installation struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	MachineId      string                 `protobuf:"bytes,1,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
+	XmppvoxVersion string                 `protobuf:"bytes,2,opt,name=xmppvox_version,json=xmppvoxVersion,proto3" json:"xmppvox_version,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Installation) Reset() {
+	*x = Installation{}
+	mi := &file_tracker_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Installation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Installation) ProtoMessage() {}
+
+func (x *Installation) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Installation.ProtoReflect.Descriptor instead.
+func (*Installation) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Installation) GetMachineId() string {
+	if x != nil {
+		return x.MachineId
+	}
+	return ""
+}
+
+func (x *Installation) GetXmppvoxVersion() string {
+	if x != nil {
+		return x.XmppvoxVersion
+	}
+	return ""
+}
+
+type ClaimInstallationRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MachineId     string                 `protobuf:"bytes,1,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
+	Code          string                 `protobuf:"bytes,2,opt,name=code,proto3" json:"code,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClaimInstallationRequest) Reset() {
+	*x = ClaimInstallationRequest{}
+	mi := &file_tracker_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClaimInstallationRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClaimInstallationRequest) ProtoMessage() {}
+
+func (x *ClaimInstallationRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClaimInstallationRequest.ProtoReflect.Descriptor instead.
+func (*ClaimInstallationRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ClaimInstallationRequest) GetMachineId() string {
+	if x != nil {
+		return x.MachineId
+	}
+	return ""
+}
+
+func (x *ClaimInstallationRequest) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+type ClaimInstallationResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Ticket        string                 `protobuf:"bytes,1,opt,name=ticket,proto3" json:"ticket,omitempty"`
+	DebugUntil    *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=debug_until,json=debugUntil,proto3" json:"debug_until,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClaimInstallationResponse) Reset() {
+	*x = ClaimInstallationResponse{}
+	mi := &file_tracker_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClaimInstallationResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClaimInstallationResponse) ProtoMessage() {}
+
+func (x *ClaimInstallationResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClaimInstallationResponse.ProtoReflect.Descriptor instead.
+func (*ClaimInstallationResponse) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ClaimInstallationResponse) GetTicket() string {
+	if x != nil {
+		return x.Ticket
+	}
+	return ""
+}
+
+func (x *ClaimInstallationResponse) GetDebugUntil() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DebugUntil
+	}
+	return nil
+}
+
+type NewSessionRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Jid            string                 `protobuf:"bytes,1,opt,name=jid,proto3" json:"jid,omitempty"`
+	MachineId      string                 `protobuf:"bytes,2,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
+	XmppvoxVersion string                 `protobuf:"bytes,3,opt,name=xmppvox_version,json=xmppvoxVersion,proto3" json:"xmppvox_version,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *NewSessionRequest) Reset() {
+	*x = NewSessionRequest{}
+	mi := &file_tracker_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NewSessionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NewSessionRequest) ProtoMessage() {}
+
+func (x *NewSessionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NewSessionRequest.ProtoReflect.Descriptor instead.
+func (*NewSessionRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *NewSessionRequest) GetJid() string {
+	if x != nil {
+		return x.Jid
+	}
+	return ""
+}
+
+func (x *NewSessionRequest) GetMachineId() string {
+	if x != nil {
+		return x.MachineId
+	}
+	return ""
+}
+
+func (x *NewSessionRequest) GetXmppvoxVersion() string {
+	if x != nil {
+		return x.XmppvoxVersion
+	}
+	return ""
+}
+
+type SessionRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	SessionId string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	MachineId string                 `protobuf:"bytes,2,opt,name=machine_id,json=machineId,proto3" json:"machine_id,omitempty"`
+	// The signature of the request, as in sessions.signing, of the params of
+	// the call in API v1: session_id, machine_id, timestamp and those of the
+	// activity set.
+	Signature string `protobuf:"bytes,3,opt,name=signature,proto3" json:"signature,omitempty"`
+	// Only read by Ping, like its messages_sent, messages_received,
+	// contacts_online and rtt_ms params.
+	Activity *Activity `protobuf:"bytes,4,opt,name=activity,proto3" json:"activity,omitempty"`
+	// The Unix time of the signature, required with one.
+	Timestamp     int64 `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SessionRequest) Reset() {
+	*x = SessionRequest{}
+	mi := &file_tracker_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionRequest) ProtoMessage() {}
+
+func (x *SessionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionRequest.ProtoReflect.Descriptor instead.
+func (*SessionRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SessionRequest) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *SessionRequest) GetMachineId() string {
+	if x != nil {
+		return x.MachineId
+	}
+	return ""
+}
+
+func (x *SessionRequest) GetSignature() string {
+	if x != nil {
+		return x.Signature
+	}
+	return ""
+}
+
+func (x *SessionRequest) GetActivity() *Activity {
+	if x != nil {
+		return x.Activity
+	}
+	return nil
+}
+
+func (x *SessionRequest) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+type Activity struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	MessagesSent     *int64                 `protobuf:"varint,1,opt,name=messages_sent,json=messagesSent,proto3,oneof" json:"messages_sent,omitempty"`
+	MessagesReceived *int64                 `protobuf:"varint,2,opt,name=messages_received,json=messagesReceived,proto3,oneof" json:"messages_received,omitempty"`
+	ContactsOnline   *int32                 `protobuf:"varint,3,opt,name=contacts_online,json=contactsOnline,proto3,oneof" json:"contacts_online,omitempty"`
+	RttMs            *int32                 `protobuf:"varint,4,opt,name=rtt_ms,json=rttMs,proto3,oneof" json:"rtt_ms,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Activity) Reset() {
+	*x = Activity{}
+	mi := &file_tracker_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Activity) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Activity) ProtoMessage() {}
+
+func (x *Activity) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Activity.ProtoReflect.Descriptor instead.
+func (*Activity) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Activity) GetMessagesSent() int64 {
+	if x != nil && x.MessagesSent != nil {
+		return *x.MessagesSent
+	}
+	return 0
+}
+
+func (x *Activity) GetMessagesReceived() int64 {
+	if x != nil && x.MessagesReceived != nil {
+		return *x.MessagesReceived
+	}
+	return 0
+}
+
+func (x *Activity) GetContactsOnline() int32 {
+	if x != nil && x.ContactsOnline != nil {
+		return *x.ContactsOnline
+	}
+	return 0
+}
+
+func (x *Activity) GetRttMs() int32 {
+	if x != nil && x.RttMs != nil {
+		return *x.RttMs
+	}
+	return 0
+}
+
+type Session struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Alias string                 `protobuf:"bytes,2,opt,name=alias,proto3" json:"alias,omitempty"`
+	// Only set by New, when sessions.signing is set.
+	Secret string `protobuf:"bytes,3,opt,name=secret,proto3" json:"secret,omitempty"`
+	// Messages to display to the user, as in the lines after the session id.
+	Messages []string `protobuf:"bytes,4,rep,name=messages,proto3" json:"messages,omitempty"`
+	// Only set by Export, as are closed_at and closed_reason.
+	CreatedAt     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	ClosedAt      *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=closed_at,json=closedAt,proto3" json:"closed_at,omitempty"`
+	ClosedReason  string                 `protobuf:"bytes,7,opt,name=closed_reason,json=closedReason,proto3" json:"closed_reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Session) Reset() {
+	*x = Session{}
+	mi := &file_tracker_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Session) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Session) ProtoMessage() {}
+
+func (x *Session) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Session.ProtoReflect.Descriptor instead.
+func (*Session) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Session) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Session) GetAlias() string {
+	if x != nil {
+		return x.Alias
+	}
+	return ""
+}
+
+func (x *Session) GetSecret() string {
+	if x != nil {
+		return x.Secret
+	}
+	return ""
+}
+
+func (x *Session) GetMessages() []string {
+	if x != nil {
+		return x.Messages
+	}
+	return nil
+}
+
+func (x *Session) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Session) GetClosedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ClosedAt
+	}
+	return nil
+}
+
+func (x *Session) GetClosedReason() string {
+	if x != nil {
+		return x.ClosedReason
+	}
+	return ""
+}
+
+type ExportRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	From          *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	To            *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExportRequest) Reset() {
+	*x = ExportRequest{}
+	mi := &file_tracker_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExportRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportRequest) ProtoMessage() {}
+
+func (x *ExportRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportRequest.ProtoReflect.Descriptor instead.
+func (*ExportRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ExportRequest) GetFrom() *timestamppb.Timestamp {
+	if x != nil {
+		return x.From
+	}
+	return nil
+}
+
+func (x *ExportRequest) GetTo() *timestamppb.Timestamp {
+	if x != nil {
+		return x.To
+	}
+	return nil
+}
+
+var File_tracker_proto protoreflect.FileDescriptor
+
+const file_tracker_proto_rawDesc = "" +
+	"\n" +
+	"\rtracker.proto\x12\x12elephanttracker.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x9c\x03\n" +
+	"\x16NewInstallationRequest\x12\x1d\n" +
+	"\n" +
+	"machine_id\x18\x01 \x01(\tR\tmachineId\x12'\n" +
+	"\x0fxmppvox_version\x18\x02 \x01(\tR\x0exmppvoxVersion\x12[\n" +
+	"\vdosvox_info\x18\x03 \x03(\v2:.elephanttracker.v1.NewInstallationRequest.DosvoxInfoEntryR\n" +
+	"dosvoxInfo\x12^\n" +
+	"\fmachine_info\x18\x04 \x03(\v2;.elephanttracker.v1.NewInstallationRequest.MachineInfoEntryR\vmachineInfo\x1a=\n" +
+	"\x0fDosvoxInfoEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a>\n" +
+	"\x10MachineInfoEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"V\n" +
+	"\fInstallation\x12\x1d\n" +
+	"\n" +
+	"machine_id\x18\x01 \x01(\tR\tmachineId\x12'\n" +
+	"\x0fxmppvox_version\x18\x02 \x01(\tR\x0exmppvoxVersion\"M\n" +
+	"\x18ClaimInstallationRequest\x12\x1d\n" +
+	"\n" +
+	"machine_id\x18\x01 \x01(\tR\tmachineId\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\tR\x04code\"p\n" +
+	"\x19ClaimInstallationResponse\x12\x16\n" +
+	"\x06ticket\x18\x01 \x01(\tR\x06ticket\x12;\n" +
+	"\vdebug_until\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"debugUntil\"m\n" +
+	"\x11NewSessionRequest\x12\x10\n" +
+	"\x03jid\x18\x01 \x01(\tR\x03jid\x12\x1d\n" +
+	"\n" +
+	"machine_id\x18\x02 \x01(\tR\tmachineId\x12'\n" +
+	"\x0fxmppvox_version\x18\x03 \x01(\tR\x0exmppvoxVersion\"\xc4\x01\n" +
+	"\x0eSessionRequest\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x1d\n" +
+	"\n" +
+	"machine_id\x18\x02 \x01(\tR\tmachineId\x12\x1c\n" +
+	"\tsignature\x18\x03 \x01(\tR\tsignature\x128\n" +
+	"\bactivity\x18\x04 \x01(\v2\x1c.elephanttracker.v1.ActivityR\bactivity\x12\x1c\n" +
+	"\ttimestamp\x18\x05 \x01(\x03R\ttimestamp\"\xf7\x01\n" +
+	"\bActivity\x12(\n" +
+	"\rmessages_sent\x18\x01 \x01(\x03H\x00R\fmessagesSent\x88\x01\x01\x120\n" +
+	"\x11messages_received\x18\x02 \x01(\x03H\x01R\x10messagesReceived\x88\x01\x01\x12,\n" +
+	"\x0fcontacts_online\x18\x03 \x01(\x05H\x02R\x0econtactsOnline\x88\x01\x01\x12\x1a\n" +
+	"\x06rtt_ms\x18\x04 \x01(\x05H\x03R\x05rttMs\x88\x01\x01B\x10\n" +
+	"\x0e_messages_sentB\x14\n" +
+	"\x12_messages_receivedB\x12\n" +
+	"\x10_contacts_onlineB\t\n" +
+	"\a_rtt_ms\"\xfc\x01\n" +
+	"\aSession\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x14\n" +
+	"\x05alias\x18\x02 \x01(\tR\x05alias\x12\x16\n" +
+	"\x06secret\x18\x03 \x01(\tR\x06secret\x12\x1a\n" +
+	"\bmessages\x18\x04 \x03(\tR\bmessages\x129\n" +
+	"\n" +
+	"created_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x127\n" +
+	"\tclosed_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\bclosedAt\x12#\n" +
+	"\rclosed_reason\x18\a \x01(\tR\fclosedReason\"k\n" +
+	"\rExportRequest\x12.\n" +
+	"\x04from\x18\x01 \x01(\v2\x1a.google.protobuf.TimestampR\x04from\x12*\n" +
+	"\x02to\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x02to2\xca\x01\n" +
+	"\rInstallations\x12S\n" +
+	"\x03New\x12*.elephanttracker.v1.NewInstallationRequest\x1a .elephanttracker.v1.Installation\x12d\n" +
+	"\x05Claim\x12,.elephanttracker.v1.ClaimInstallationRequest\x1a-.elephanttracker.v1.ClaimInstallationResponse2\xb4\x02\n" +
+	"\bSessions\x12I\n" +
+	"\x03New\x12%.elephanttracker.v1.NewSessionRequest\x1a\x1b.elephanttracker.v1.Session\x12H\n" +
+	"\x05Close\x12\".elephanttracker.v1.SessionRequest\x1a\x1b.elephanttracker.v1.Session\x12G\n" +
+	"\x04Ping\x12\".elephanttracker.v1.SessionRequest\x1a\x1b.elephanttracker.v1.Session\x12J\n" +
+	"\x06Export\x12!.elephanttracker.v1.ExportRequest\x1a\x1b.elephanttracker.v1.Session0\x01B8Z6github.com/rhcarvalho/elephant-tracker/proto/trackerpbb\x06proto3"
+
+var (
+	file_tracker_proto_rawDescOnce sync.Once
+	file_tracker_proto_rawDescData []byte
+)
+
+func file_tracker_proto_rawDescGZIP() []byte {
+	file_tracker_proto_rawDescOnce.Do(func() {
+		file_tracker_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tracker_proto_rawDesc), len(file_tracker_proto_rawDesc)))
+	})
+	return file_tracker_proto_rawDescData
+}
+
+var file_tracker_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_tracker_proto_goTypes = []any{
+	(*NewInstallationRequest)(nil),    // 0: elephanttracker.v1.NewInstallationRequest
+	(*Installation)(nil),              // 1: elephanttracker.v1.Installation
+	(*ClaimInstallationRequest)(nil),  // 2: elephanttracker.v1.ClaimInstallationRequest
+	(*ClaimInstallationResponse)(nil), // 3: elephanttracker.v1.ClaimInstallationResponse
+	(*NewSessionRequest)(nil),         // 4: elephanttracker.v1.NewSessionRequest
+	(*SessionRequest)(nil),            // 5: elephanttracker.v1.SessionRequest
+	(*Activity)(nil),                  // 6: elephanttracker.v1.Activity
+	(*Session)(nil),                   // 7: elephanttracker.v1.Session
+	(*ExportRequest)(nil),             // 8: elephanttracker.v1.ExportRequest
+	nil,                               // 9: elephanttracker.v1.NewInstallationRequest.DosvoxInfoEntry
+	nil,                               // 10: elephanttracker.v1.NewInstallationRequest.MachineInfoEntry
+	(*timestamppb.Timestamp)(nil),     // 11: google.protobuf.Timestamp
+}
+var file_tracker_proto_depIdxs = []int32{
+	9,  // 0: elephanttracker.v1.NewInstallationRequest.dosvox_info:type_name -> elephanttracker.v1.NewInstallationRequest.DosvoxInfoEntry
+	10, // 1: elephanttracker.v1.NewInstallationRequest.machine_info:type_name -> elephanttracker.v1.NewInstallationRequest.MachineInfoEntry
+	11, // 2: elephanttracker.v1.ClaimInstallationResponse.debug_until:type_name -> google.protobuf.Timestamp
+	6,  // 3: elephanttracker.v1.SessionRequest.activity:type_name -> elephanttracker.v1.Activity
+	11, // 4: elephanttracker.v1.Session.created_at:type_name -> google.protobuf.Timestamp
+	11, // 5: elephanttracker.v1.Session.closed_at:type_name -> google.protobuf.Timestamp
+	11, // 6: elephanttracker.v1.ExportRequest.from:type_name -> google.protobuf.Timestamp
+	11, // 7: elephanttracker.v1.ExportRequest.to:type_name -> google.protobuf.Timestamp
+	0,  // 8: elephanttracker.v1.Installations.New:input_type -> elephanttracker.v1.NewInstallationRequest
+	2,  // 9: elephanttracker.v1.Installations.Claim:input_type -> elephanttracker.v1.ClaimInstallationRequest
+	4,  // 10: elephanttracker.v1.Sessions.New:input_type -> elephanttracker.v1.NewSessionRequest
+	5,  // 11: elephanttracker.v1.Sessions.Close:input_type -> elephanttracker.v1.SessionRequest
+	5,  // 12: elephanttracker.v1.Sessions.Ping:input_type -> elephanttracker.v1.SessionRequest
+	8,  // 13: elephanttracker.v1.Sessions.Export:input_type -> elephanttracker.v1.ExportRequest
+	1,  // 14: elephanttracker.v1.Installations.New:output_type -> elephanttracker.v1.Installation
+	3,  // 15: elephanttracker.v1.Installations.Claim:output_type -> elephanttracker.v1.ClaimInstallationResponse
+	7,  // 16: elephanttracker.v1.Sessions.New:output_type -> elephanttracker.v1.Session
+	7,  // 17: elephanttracker.v1.Sessions.Close:output_type -> elephanttracker.v1.Session
+	7,  // 18: elephanttracker.v1.Sessions.Ping:output_type -> elephanttracker.v1.Session
+	7,  // 19: elephanttracker.v1.Sessions.Export:output_type -> elephanttracker.v1.Session
+	14, // [14:20] is the sub-list for method output_type
+	8,  // [8:14] is the sub-list for method input_type
+	8,  // [8:8] is the sub-list for extension type_name
+	8,  // [8:8] is the sub-list for extension extendee
+	0,  // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_tracker_proto_init() }
+func file_tracker_proto_init() {
+	if File_tracker_proto != nil {
+		return
+	}
+	file_tracker_proto_msgTypes[6].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tracker_proto_rawDesc), len(file_tracker_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_tracker_proto_goTypes,
+		DependencyIndexes: file_tracker_proto_depIdxs,
+		MessageInfos:      file_tracker_proto_msgTypes,
+	}.Build()
+	File_tracker_proto = out.File
+	file_tracker_proto_goTypes = nil
+	file_tracker_proto_depIdxs = nil
+}
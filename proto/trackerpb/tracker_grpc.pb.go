@@ -0,0 +1,395 @@
+// Contract of the gRPC interface of Elephant Tracker, mirroring API v1 as
+// documented in doc.go, served at grpc.host and grpc.port, see the README.
+// The Go code in trackerpb is generated from it.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: tracker.proto
+
+package trackerpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Installations_New_FullMethodName   = "/elephanttracker.v1.Installations/New"
+	Installations_Claim_FullMethodName = "/elephanttracker.v1.Installations/Claim"
+)
+
+// InstallationsClient is the client API for Installations service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InstallationsClient interface {
+	// Like POST /1/installation/new. Fails with ALREADY_EXISTS for a known machine_id.
+	New(ctx context.Context, in *NewInstallationRequest, opts ...grpc.CallOption) (*Installation, error)
+	// Like POST /1/installation/claim.
+	Claim(ctx context.Context, in *ClaimInstallationRequest, opts ...grpc.CallOption) (*ClaimInstallationResponse, error)
+}
+
+type installationsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInstallationsClient(cc grpc.ClientConnInterface) InstallationsClient {
+	return &installationsClient{cc}
+}
+
+func (c *installationsClient) New(ctx context.Context, in *NewInstallationRequest, opts ...grpc.CallOption) (*Installation, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Installation)
+	err := c.cc.Invoke(ctx, Installations_New_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *installationsClient) Claim(ctx context.Context, in *ClaimInstallationRequest, opts ...grpc.CallOption) (*ClaimInstallationResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ClaimInstallationResponse)
+	err := c.cc.Invoke(ctx, Installations_Claim_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// InstallationsServer is the server API for Installations service.
+// All implementations must embed UnimplementedInstallationsServer
+// for forward compatibility.
+type InstallationsServer interface {
+	// Like POST /1/installation/new. Fails with ALREADY_EXISTS for a known machine_id.
+	New(context.Context, *NewInstallationRequest) (*Installation, error)
+	// Like POST /1/installation/claim.
+	Claim(context.Context, *ClaimInstallationRequest) (*ClaimInstallationResponse, error)
+	mustEmbedUnimplementedInstallationsServer()
+}
+
+// UnimplementedInstallationsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedInstallationsServer struct{}
+
+func (UnimplementedInstallationsServer) New(context.Context, *NewInstallationRequest) (*Installation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method New not implemented")
+}
+func (UnimplementedInstallationsServer) Claim(context.Context, *ClaimInstallationRequest) (*ClaimInstallationResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Claim not implemented")
+}
+func (UnimplementedInstallationsServer) mustEmbedUnimplementedInstallationsServer() {}
+func (UnimplementedInstallationsServer) testEmbeddedByValue()                       {}
+
+// UnsafeInstallationsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InstallationsServer will
+// result in compilation errors.
+type UnsafeInstallationsServer interface {
+	mustEmbedUnimplementedInstallationsServer()
+}
+
+func RegisterInstallationsServer(s grpc.ServiceRegistrar, srv InstallationsServer) {
+	// If the following call pancis, it indicates UnimplementedInstallationsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Installations_ServiceDesc, srv)
+}
+
+func _Installations_New_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NewInstallationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InstallationsServer).New(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Installations_New_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InstallationsServer).New(ctx, req.(*NewInstallationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Installations_Claim_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClaimInstallationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InstallationsServer).Claim(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Installations_Claim_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InstallationsServer).Claim(ctx, req.(*ClaimInstallationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Installations_ServiceDesc is the grpc.ServiceDesc for Installations service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Installations_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "elephanttracker.v1.Installations",
+	HandlerType: (*InstallationsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "New",
+			Handler:    _Installations_New_Handler,
+		},
+		{
+			MethodName: "Claim",
+			Handler:    _Installations_Claim_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "tracker.proto",
+}
+
+const (
+	Sessions_New_FullMethodName    = "/elephanttracker.v1.Sessions/New"
+	Sessions_Close_FullMethodName  = "/elephanttracker.v1.Sessions/Close"
+	Sessions_Ping_FullMethodName   = "/elephanttracker.v1.Sessions/Ping"
+	Sessions_Export_FullMethodName = "/elephanttracker.v1.Sessions/Export"
+)
+
+// SessionsClient is the client API for Sessions service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SessionsClient interface {
+	// Like POST /1/session/new. Fails with PERMISSION_DENIED and the block message when blocked.
+	New(ctx context.Context, in *NewSessionRequest, opts ...grpc.CallOption) (*Session, error)
+	// Like POST /1/session/close.
+	Close(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Session, error)
+	// Like POST /1/session/ping.
+	Ping(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Session, error)
+	// Streams the sessions created within a window, oldest first, like GET /admin/1/export/sessions.
+	Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Session], error)
+}
+
+type sessionsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSessionsClient(cc grpc.ClientConnInterface) SessionsClient {
+	return &sessionsClient{cc}
+}
+
+func (c *sessionsClient) New(ctx context.Context, in *NewSessionRequest, opts ...grpc.CallOption) (*Session, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Session)
+	err := c.cc.Invoke(ctx, Sessions_New_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sessionsClient) Close(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Session, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Session)
+	err := c.cc.Invoke(ctx, Sessions_Close_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sessionsClient) Ping(ctx context.Context, in *SessionRequest, opts ...grpc.CallOption) (*Session, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Session)
+	err := c.cc.Invoke(ctx, Sessions_Ping_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *sessionsClient) Export(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Session], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Sessions_ServiceDesc.Streams[0], Sessions_Export_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ExportRequest, Session]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sessions_ExportClient = grpc.ServerStreamingClient[Session]
+
+// SessionsServer is the server API for Sessions service.
+// All implementations must embed UnimplementedSessionsServer
+// for forward compatibility.
+type SessionsServer interface {
+	// Like POST /1/session/new. Fails with PERMISSION_DENIED and the block message when blocked.
+	New(context.Context, *NewSessionRequest) (*Session, error)
+	// Like POST /1/session/close.
+	Close(context.Context, *SessionRequest) (*Session, error)
+	// Like POST /1/session/ping.
+	Ping(context.Context, *SessionRequest) (*Session, error)
+	// Streams the sessions created within a window, oldest first, like GET /admin/1/export/sessions.
+	Export(*ExportRequest, grpc.ServerStreamingServer[Session]) error
+	mustEmbedUnimplementedSessionsServer()
+}
+
+// UnimplementedSessionsServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSessionsServer struct{}
+
+func (UnimplementedSessionsServer) New(context.Context, *NewSessionRequest) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method New not implemented")
+}
+func (UnimplementedSessionsServer) Close(context.Context, *SessionRequest) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Close not implemented")
+}
+func (UnimplementedSessionsServer) Ping(context.Context, *SessionRequest) (*Session, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedSessionsServer) Export(*ExportRequest, grpc.ServerStreamingServer[Session]) error {
+	return status.Errorf(codes.Unimplemented, "method Export not implemented")
+}
+func (UnimplementedSessionsServer) mustEmbedUnimplementedSessionsServer() {}
+func (UnimplementedSessionsServer) testEmbeddedByValue()                  {}
+
+// UnsafeSessionsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SessionsServer will
+// result in compilation errors.
+type UnsafeSessionsServer interface {
+	mustEmbedUnimplementedSessionsServer()
+}
+
+func RegisterSessionsServer(s grpc.ServiceRegistrar, srv SessionsServer) {
+	// If the following call pancis, it indicates UnimplementedSessionsServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Sessions_ServiceDesc, srv)
+}
+
+func _Sessions_New_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NewSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SessionsServer).New(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sessions_New_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SessionsServer).New(ctx, req.(*NewSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sessions_Close_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SessionsServer).Close(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sessions_Close_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SessionsServer).Close(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sessions_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SessionsServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sessions_Ping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SessionsServer).Ping(ctx, req.(*SessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sessions_Export_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SessionsServer).Export(m, &grpc.GenericServerStream[ExportRequest, Session]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sessions_ExportServer = grpc.ServerStreamingServer[Session]
+
+// Sessions_ServiceDesc is the grpc.ServiceDesc for Sessions service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Sessions_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "elephanttracker.v1.Sessions",
+	HandlerType: (*SessionsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "New",
+			Handler:    _Sessions_New_Handler,
+		},
+		{
+			MethodName: "Close",
+			Handler:    _Sessions_Close_Handler,
+		},
+		{
+			MethodName: "Ping",
+			Handler:    _Sessions_Ping_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Export",
+			Handler:       _Sessions_Export_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tracker.proto",
+}
@@ -0,0 +1,231 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"time"
+)
+
+// Defaults of the abuse job, when it is enabled.
+const (
+	defaultAbuseInterval         = time.Hour
+	defaultAbuseWindow           = 24 * time.Hour
+	defaultMaxMachinesPerIP      = 100
+	defaultMaxSessionsPerMachine = 200
+)
+
+// abuseJob flags suspicious patterns in recent sessions for admins to
+// review, see detectAbuse. It is disabled unless the abuse section is set.
+var abuseJob = &Job{
+	Name: "abuse",
+	Interval: func(c *Config) time.Duration {
+		if c == nil || c.Abuse == nil {
+			return 0
+		}
+		if c.Abuse.Interval.Duration > 0 {
+			return c.Abuse.Interval.Duration
+		}
+		return defaultAbuseInterval
+	},
+	Run: func(c *Context) (int, error) {
+		return detectAbuse(c.Store, c.Config.Abuse, time.Now())
+	},
+}
+
+// detectAbuse looks at the sessions created over the abuse.window before now
+// and raises a flag for every IP address that sessions came from with more
+// than abuse.max_machines_per_ip machine ids, every machine id with more than
+// abuse.max_sessions_per_machine sessions and every invalid jid, as stored
+// before jids were validated. It returns how many flags it raised, new or not.
+func detectAbuse(store Storage, conf *AbuseConfig, now time.Time) (int, error) {
+	window, maxMachines, maxSessions := defaultAbuseWindow, defaultMaxMachinesPerIP, defaultMaxSessionsPerMachine
+	if conf != nil {
+		if conf.Window.Duration > 0 {
+			window = conf.Window.Duration
+		}
+		if conf.MaxMachinesPerIP > 0 {
+			maxMachines = conf.MaxMachinesPerIP
+		}
+		if conf.MaxSessionsPerMachine > 0 {
+			maxSessions = conf.MaxSessionsPerMachine
+		}
+	}
+	machinesPerIP := make(map[string]map[string]bool)
+	sessionsPerMachine := make(map[string]int)
+	invalidJIDs := make(map[string]int)
+	err := store.EachSession(TimeWindow{From: now.Add(-window), To: now}, 0, func(s *Session) error {
+		sessionsPerMachine[s.MachineId]++
+		if _, err := parseJID(s.JID); err != nil {
+			invalidJIDs[s.JID]++
+		}
+		// The request data of old sessions may have been removed, see retention.
+		if s.Request == nil {
+			return nil
+		}
+		if ip := remoteIP(s.Request.RemoteAddr); ip != nil {
+			machines := machinesPerIP[ip.String()]
+			if machines == nil {
+				machines = make(map[string]bool)
+				machinesPerIP[ip.String()] = machines
+			}
+			machines[s.MachineId] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var flags []*Flag
+	raise := func(kind, field, value string, count int, message string) {
+		flags = append(flags, &Flag{
+			Id:        bson.NewObjectId(),
+			Kind:      kind,
+			Field:     field,
+			Value:     value,
+			Count:     count,
+			Message:   message,
+			CreatedAt: now,
+			SeenAt:    now,
+			Status:    FlagOpen,
+		})
+	}
+	for ip, machines := range machinesPerIP {
+		if len(machines) > maxMachines {
+			raise(FlagMachinesPerIP, BlockRemoteIP, ip, len(machines),
+				fmt.Sprintf("%d machine ids started sessions from %s in %s", len(machines), ip, window))
+		}
+	}
+	for machineId, n := range sessionsPerMachine {
+		if n > maxSessions {
+			raise(FlagSessionChurn, BlockMachineId, machineId, n,
+				fmt.Sprintf("%d sessions started on machine %s in %s", n, machineId, window))
+		}
+	}
+	for jid, n := range invalidJIDs {
+		raise(FlagInvalidJID, BlockJID, jid, n, fmt.Sprintf("%d sessions started with the invalid jid %q in %s", n, jid, window))
+	}
+	for i, f := range flags {
+		if err := store.RaiseFlag(f); err != nil {
+			return i, err
+		}
+	}
+	return len(flags), nil
+}
+
+// FlagsHandler lists the open flags, last seen first, or those with the
+// status parameter, "all" for every flag.
+func FlagsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	status := r.FormValue("status")
+	switch status {
+	case "":
+		status = FlagOpen
+	case "all":
+		status = ""
+	case FlagOpen, FlagDismissed, FlagBlocked:
+	default:
+		writeError(w, r, &APIError{"invalid_value", "status", "",
+			fmt.Sprintf("Invalid status %s, expected open, dismissed, blocked or all", status)}, http.StatusBadRequest)
+		return
+	}
+	flags, err := c.Store.Flags(status)
+	if err != nil {
+		writeError(w, r, internalError("Failed to list flags"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if flags == nil {
+		flags = []*Flag{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(flags)
+}
+
+// openFlag returns the open flag with the id in the flag_id parameter,
+// replying with an error if there is none.
+func openFlag(w http.ResponseWriter, r *http.Request, c *Context) *Flag {
+	idHex := r.PostFormValue("flag_id")
+	if !bson.IsObjectIdHex(idHex) {
+		writeError(w, r, &APIError{"invalid_flag_id", "flag_id", "", fmt.Sprintf("Invalid flag id %s", idHex)},
+			http.StatusBadRequest)
+		return nil
+	}
+	f, err := c.Store.FindFlag(bson.ObjectIdHex(idHex))
+	switch {
+	case err == mgo.ErrNotFound:
+		writeError(w, r, &APIError{"flag_not_found", "flag_id", "", fmt.Sprintf("Flag %s does not exist", idHex)},
+			http.StatusBadRequest)
+	case err != nil:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to find flag %s", idHex)), http.StatusInternalServerError)
+		c.Log.Error(err)
+	case f.Status != FlagOpen:
+		writeError(w, r, &APIError{"flag_reviewed", "flag_id", "", fmt.Sprintf("Flag %s was already %s", idHex, f.Status)},
+			http.StatusBadRequest)
+	default:
+		return f
+	}
+	return nil
+}
+
+// reviewFlag sets the status of f, recording who reviewed it in the audit
+// log as action.
+func reviewFlag(r *http.Request, c *Context, f *Flag, action, status string, blockId bson.ObjectId) error {
+	if err := c.Store.ReviewFlag(f.Id, status, blockId); err != nil {
+		return err
+	}
+	details := bson.M{"kind": f.Kind, "field": f.Field, "value": f.Value}
+	if blockId != "" {
+		details["block_id"] = blockId.Hex()
+	}
+	a := NewAuditEntry(action, f.Id.Hex(), c.actor(r), r.PostFormValue("comment"), details)
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	return nil
+}
+
+// BlockFlagHandler blocks the field and value of an open flag, with the
+// message parameter for the users denied a session, replying with the id
+// of the block.
+func BlockFlagHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	if errs := checkParams(r, []string{"flag_id", "message"}, "comment"); errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	f := openFlag(w, r, c)
+	if f == nil {
+		return
+	}
+	b := NewBlock(f.Field, f.Value, r.PostFormValue("message"))
+	if err := addBlock(r, c, b); err != nil {
+		writeError(w, r, internalError("Failed to add block"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if err := reviewFlag(r, c, f, "flag.block", FlagBlocked, b.Id); err != nil {
+		// The block is in place, only the flag stays open.
+		c.Log.Error(err)
+	}
+	fmt.Fprintln(w, b.Id.Hex())
+}
+
+// DismissFlagHandler closes an open flag found to be legitimate. Flags
+// dismissed stay dismissed when the pattern is found again.
+func DismissFlagHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	if errs := checkParams(r, []string{"flag_id"}, "comment"); errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	f := openFlag(w, r, c)
+	if f == nil {
+		return
+	}
+	if err := reviewFlag(r, c, f, "flag.dismiss", FlagDismissed, ""); err != nil {
+		writeError(w, r, internalError(fmt.Sprintf("Failed to dismiss flag %s", f.Id.Hex())), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	fmt.Fprintln(w, f.Id.Hex())
+}
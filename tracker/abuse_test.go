@@ -0,0 +1,225 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type abuseTest struct {
+	store *MemoryStore
+	now   time.Time
+}
+
+func newAbuseTest(t *testing.T) *abuseTest {
+	s := &abuseTest{}
+	s.store = NewMemoryStore()
+	s.now = time.Now()
+	return s
+}
+
+func (s *abuseTest) insertSession(t *testing.T, jid, machineId, remoteAddr string) {
+	x := NewSession(jid, machineId, "1.0", &HttpRequest{RemoteAddr: remoteAddr})
+	x.CreatedAt = s.now.Add(-time.Hour)
+	if err := s.store.InsertSession(x); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (s *abuseTest) post(h contextualHandlerFunc, params url.Values) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("POST", "/admin/1/flags", strings.NewReader(params.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "10.0.0.1:51234"
+	w := httptest.NewRecorder()
+	h(w, r, &Context{Store: s.store})
+	return w
+}
+
+func TestAbuseDetect(t *testing.T) {
+	s := newAbuseTest(t)
+	conf := &AbuseConfig{MaxMachinesPerIP: 3, MaxSessionsPerMachine: 4}
+	for i := 0; i < 4; i++ {
+		s.insertSession(t, "user@server.org", fmt.Sprintf("machine-%d", i), "203.0.113.7:1234")
+	}
+	for i := 0; i < 5; i++ {
+		s.insertSession(t, "other@server.org", "churning", fmt.Sprintf("198.51.100.%d:1234", i))
+	}
+	s.insertSession(t, "not a jid", "machine-x", "198.51.100.9:1234")
+	// Older than the window.
+	old := NewSession("not a jid either", "machine-y", "1.0", nil)
+	old.CreatedAt = s.now.Add(-48 * time.Hour)
+	if err := s.store.InsertSession(old); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := detectAbuse(s.store, conf, s.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 3; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	flags, err := s.store.Flags(FlagOpen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]*Flag)
+	for _, f := range flags {
+		found[f.Kind] = f
+	}
+	if got, want := len(found), 3; got != want {
+		t.Fatalf("len(found) = %d, want %d", got, want)
+	}
+	if got, want := found[FlagMachinesPerIP].Field, BlockRemoteIP; got != want {
+		t.Errorf("found[FlagMachinesPerIP].Field = %v, want %v", got, want)
+	}
+	if got, want := found[FlagMachinesPerIP].Value, "203.0.113.7"; got != want {
+		t.Errorf("found[FlagMachinesPerIP].Value = %v, want %v", got, want)
+	}
+	if got, want := found[FlagMachinesPerIP].Count, 4; got != want {
+		t.Errorf("found[FlagMachinesPerIP].Count = %v, want %v", got, want)
+	}
+	if got, want := found[FlagSessionChurn].Value, "churning"; got != want {
+		t.Errorf("found[FlagSessionChurn].Value = %v, want %v", got, want)
+	}
+	if got, want := found[FlagInvalidJID].Value, "not a jid"; got != want {
+		t.Errorf("found[FlagInvalidJID].Value = %v, want %v", got, want)
+	}
+
+	// Found again, flags keep their status.
+	if err := s.store.ReviewFlag(found[FlagInvalidJID].Id, FlagDismissed, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.insertSession(t, "user@server.org", "machine-4", "203.0.113.7:1234")
+	s.now = s.now.Add(time.Minute)
+	_, err = detectAbuse(s.store, conf, s.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(s.store.FlagList), 3; got != want {
+		t.Fatalf("len(s.store.FlagList) = %d, want %d", got, want)
+	}
+	f, _ := s.store.FindFlag(found[FlagMachinesPerIP].Id)
+	if got, want := f.Count, 5; got != want {
+		t.Errorf("f.Count = %v, want %v", got, want)
+	}
+	if got, want := f.SeenAt.Equal(s.now), true; got != want {
+		t.Errorf("f.SeenAt.Equal(s.now) = %v, want %v", got, want)
+	}
+	f, _ = s.store.FindFlag(found[FlagInvalidJID].Id)
+	if got, want := f.Status, FlagDismissed; got != want {
+		t.Errorf("f.Status = %v, want %v", got, want)
+	}
+}
+
+func TestBlockFlag(t *testing.T) {
+	s := newAbuseTest(t)
+	flag := &Flag{Id: bson.NewObjectId(), Kind: FlagMachinesPerIP, Field: BlockRemoteIP, Value: "203.0.113.7",
+		Count: 120, SeenAt: s.now}
+	if err := s.store.RaiseFlag(flag); err != nil {
+		t.Fatal(err)
+	}
+
+	w := s.post(BlockFlagHandler, url.Values{"flag_id": {flag.Id.Hex()}, "message": {"Blocked"}, "comment": {"bots"}})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := len(s.store.BlockList), 1; got != want {
+		t.Fatalf("len(s.store.BlockList) = %d, want %d", got, want)
+	}
+	b := s.store.BlockList[0]
+	if got, want := w.Body.String(), b.Id.Hex()+"\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := b.Field, BlockRemoteIP; got != want {
+		t.Errorf("b.Field = %v, want %v", got, want)
+	}
+	f, _ := s.store.FindFlag(flag.Id)
+	if got, want := f.Status, FlagBlocked; got != want {
+		t.Errorf("f.Status = %v, want %v", got, want)
+	}
+	if got, want := f.BlockId, b.Id; got != want {
+		t.Errorf("f.BlockId = %v, want %v", got, want)
+	}
+	audit := s.store.Audit[len(s.store.Audit)-1]
+	if got, want := audit.Action, "flag.block"; got != want {
+		t.Errorf("audit.Action = %v, want %v", got, want)
+	}
+	if got, want := audit.Comment, "bots"; got != want {
+		t.Errorf("audit.Comment = %v, want %v", got, want)
+	}
+
+	// Sessions from the IP address are denied.
+	r, _ := http.NewRequest("POST", "/1/session/new", strings.NewReader(url.Values{
+		"jid": {"user@server.org"}, "machine_id": {"machine-0"}, "xmppvox_version": {"1.0"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "203.0.113.7:4321"
+	rec := httptest.NewRecorder()
+	NewSessionHandler(rec, r, &Context{Store: s.store})
+	if got, want := rec.Code, http.StatusForbidden; got != want {
+		t.Errorf("rec.Code = %v, want %v", got, want)
+	}
+	if got, want := rec.Body.String(), "Blocked\n"; got != want {
+		t.Errorf("rec.Body.String() = %v, want %v", got, want)
+	}
+
+	w = s.post(DismissFlagHandler, url.Values{"flag_id": {flag.Id.Hex()}})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), fmt.Sprintf("Flag %s was already blocked\n", flag.Id.Hex()); got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	w = s.post(DismissFlagHandler, url.Values{"flag_id": {"nope"}})
+	if got, want := w.Body.String(), "Invalid flag id nope\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+}
+
+func TestListFlags(t *testing.T) {
+	s := newAbuseTest(t)
+	for i, status := range []string{FlagOpen, FlagDismissed} {
+		f := &Flag{Id: bson.NewObjectId(), Kind: FlagSessionChurn, Field: BlockMachineId,
+			Value: fmt.Sprintf("machine-%d", i), SeenAt: s.now.Add(time.Duration(i) * time.Minute)}
+		if err := s.store.RaiseFlag(f); err != nil {
+			t.Fatal(err)
+		}
+		if status != FlagOpen {
+			if err := s.store.ReviewFlag(f.Id, status, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	list := func(query string) []*Flag {
+		r, _ := http.NewRequest("GET", "/admin/1/flags?"+query, nil)
+		w := httptest.NewRecorder()
+		FlagsHandler(w, r, &Context{Store: s.store})
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("w.Code = %v, want %v", got, want)
+		}
+		var flags []*Flag
+		if err := json.Unmarshal(w.Body.Bytes(), &flags); err != nil {
+			t.Fatal(err)
+		}
+		return flags
+	}
+	if got, want := len(list("")), 1; got != want {
+		t.Errorf("got length %d, want %d", got, want)
+	}
+	all := list("status=all")
+	if got, want := len(all), 2; got != want {
+		t.Fatalf("len(all) = %d, want %d", got, want)
+	}
+	if got, want := all[0].Value, "machine-1"; got != want {
+		t.Errorf("all[0].Value = %v, want %v", got, want)
+	}
+	if got, want := len(list("status=blocked")), 0; got != want {
+		t.Errorf("got length %d, want %d", got, want)
+	}
+}
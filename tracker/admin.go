@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"time"
+)
+
+// defaultReopenWindow is used when admin.reopen_window is not configured.
+const defaultReopenWindow = 24 * time.Hour
+
+// requireAdmin wraps h, rejecting requests without the X-Admin-Token of an operator.
+func requireAdmin(h contextualHandlerFunc) contextualHandlerFunc {
+	return requireToken(func(w http.ResponseWriter, r *http.Request, c *Context) {
+		if c.Role != RoleOperator {
+			writeError(w, r, forbidden(), http.StatusForbidden)
+			return
+		}
+		h(w, r, c)
+	})
+}
+
+// requireToken wraps h, rejecting requests without a valid X-Admin-Token header
+// and setting the role of the token in the Context otherwise. The app query
+// param scopes the request to an app other than the default one.
+func requireToken(h contextualHandlerFunc) contextualHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, c *Context) {
+		t := adminToken(r.Header.Get("X-Admin-Token"), c.Config)
+		if t == nil {
+			writeError(w, r, forbidden(), http.StatusForbidden)
+			return
+		}
+		c.Role, c.Actor = t.Role, t.actor()
+		if !useApp(w, r, c, r.URL.Query().Get("app")) {
+			return
+		}
+		h(w, r, c)
+	}
+}
+
+// adminToken returns the admin token of conf matching token, or nil if it
+// is not valid.
+func adminToken(token string, conf *Config) *AdminToken {
+	if token == "" || conf == nil || conf.Admin == nil {
+		return nil
+	}
+	for i, t := range conf.Admin.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return &conf.Admin.Tokens[i]
+		}
+	}
+	return nil
+}
+
+// actor is who the audit collection records for requests with t.
+func (t *AdminToken) actor() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.Role
+}
+
+// ReopenSessionHandler reopens a session that was closed by mistake.
+func ReopenSessionHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"session_id"}, "comment")
+	sessionIdHex := r.PostFormValue("session_id")
+	comment := r.PostFormValue("comment")
+	if sessionIdHex != "" && !validSessionId(c.Config, sessionIdHex) {
+		errs = append(errs, invalidSessionId("session_id", sessionIdHex))
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	window := c.Config.Admin.ReopenWindow.Duration
+	if window <= 0 {
+		window = defaultReopenWindow
+	}
+	s := &Session{Id: SessionId(sessionIdHex)}
+	err := c.Store.ReopenSession(s, time.Now().Add(-window))
+	switch err {
+	case nil:
+		fmt.Fprintln(w, sessionIdHex)
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"not_reopenable", "session_id", "",
+			fmt.Sprintf("Session %s does not exist, is open or was closed more than %s ago", sessionIdHex, window)},
+			http.StatusBadRequest)
+		return
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to reopen session %s", sessionIdHex)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	a := NewAuditEntry("session.reopen", sessionIdHex, c.actor(r), comment, bson.M{
+		"closed_at":     s.ClosedAt,
+		"closed_reason": s.ClosedReason,
+	})
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	reopened := *s
+	reopened.ClosedAt, reopened.ClosedReason = time.Time{}, ""
+	closeExcess(&reopened, c)
+	notifyWebhooks(c, WebhookSessionReopen, &reopened)
+}
+
+// ReloadConfigHandler reloads the configuration file, like a SIGHUP.
+func ReloadConfigHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	reload := func() error { return reloadConfig(defaultConfigSource(), currentConfig(), setConfig) }
+	if c.Server != nil {
+		reload = c.Server.Reload
+	}
+	if err := reload(); err != nil {
+		writeError(w, r, internalError(fmt.Sprintf("Failed to reload configuration: %v", err)),
+			http.StatusInternalServerError)
+		return
+	}
+	c.Log.Info("[config] reloaded on request from", r.RemoteAddr)
+	fmt.Fprintln(w, "Configuration reloaded")
+}
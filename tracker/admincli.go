@@ -0,0 +1,250 @@
+package tracker
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// adminCommand is a command of "elephant-tracker admin", which calls an
+// endpoint of the admin API.
+type adminCommand struct {
+	// Name is a resource and a verb, like "blocks add".
+	Name         string
+	Method, Path string
+	// Args name the positional arguments, which fill {arg} in Path or are
+	// sent as the parameters of the same name.
+	Args []string
+	// Flags are optional parameters, given as -name, and Bools optional
+	// flags without a value; a - in a name stands for a _ in the parameter.
+	Flags []string
+	Bools []string
+	Help  string
+	// query, if set, turns the parameters into those of the endpoint.
+	query func(params url.Values) error
+}
+
+// adminCommands lists the commands of the admin client.
+var adminCommands = []*adminCommand{
+	{Name: "sessions list", Method: "GET", Path: "/admin/1/search",
+		Flags: []string{"jid", "machine-id", "tag", "created", "page"}, Bools: []string{"open", "closed"},
+		Help: "list the sessions matching the flags, newest first", query: sessionListQuery},
+	{Name: "sessions search", Method: "GET", Path: "/admin/1/search", Args: []string{"q"}, Flags: []string{"page"},
+		Help: "list the sessions matching a query like \"jid:*@server.org status:open\""},
+	{Name: "sessions reopen", Method: "POST", Path: "/admin/session/reopen",
+		Args: []string{"session_id"}, Flags: []string{"comment"}, Help: "reopen a session closed by mistake"},
+	{Name: "sessions tag", Method: "POST", Path: "/admin/1/tags/add",
+		Args: []string{"session_id", "tag"}, Flags: []string{"comment"}, Help: "tag a session"},
+	{Name: "sessions untag", Method: "POST", Path: "/admin/1/tags/remove",
+		Args: []string{"session_id", "tag"}, Flags: []string{"comment"}, Help: "remove a tag from a session"},
+	{Name: "installations list", Method: "GET", Path: "/admin/1/installations",
+		Flags: []string{"tag", "page"}, Help: "list the installations, newest first"},
+	{Name: "blocks list", Method: "GET", Path: "/admin/1/blocks", Help: "list the blocks"},
+	{Name: "blocks add", Method: "POST", Path: "/admin/1/blocks/new",
+		Args: []string{"field", "value", "message"}, Help: "deny new sessions to a jid, machine_id, xmppvox_version or remote_ip"},
+	{Name: "blocks remove", Method: "POST", Path: "/admin/1/blocks/remove",
+		Args: []string{"block_id"}, Help: "lift a block"},
+	{Name: "flags list", Method: "GET", Path: "/admin/1/flags",
+		Flags: []string{"status"}, Help: "list the flags raised by the abuse job, open ones by default"},
+	{Name: "flags block", Method: "POST", Path: "/admin/1/flags/block",
+		Args: []string{"flag_id", "message"}, Flags: []string{"comment"}, Help: "block what a flag is about"},
+	{Name: "flags dismiss", Method: "POST", Path: "/admin/1/flags/dismiss",
+		Args: []string{"flag_id"}, Flags: []string{"comment"}, Help: "dismiss a flag"},
+	{Name: "api-keys list", Method: "GET", Path: "/admin/1/api_keys", Help: "list the API keys and their usage"},
+	{Name: "api-keys new", Method: "POST", Path: "/admin/1/api_keys/new",
+		Args: []string{"name"}, Flags: []string{"max-per-minute"}, Help: "create an API key"},
+	{Name: "api-keys revoke", Method: "POST", Path: "/admin/1/api_keys/revoke",
+		Args: []string{"api_key_id"}, Help: "revoke an API key"},
+	{Name: "webhooks list", Method: "GET", Path: "/admin/1/webhooks", Help: "list the webhooks"},
+	{Name: "webhooks remove", Method: "POST", Path: "/admin/1/webhooks/remove",
+		Args: []string{"webhook_id"}, Help: "remove a webhook"},
+	{Name: "crashes list", Method: "GET", Path: "/admin/1/crashes",
+		Flags: []string{"limit"}, Help: "list the crash groups, most recently seen first"},
+	{Name: "jobs list", Method: "GET", Path: "/admin/jobs", Help: "list the jobs with their last run"},
+	{Name: "jobs history", Method: "GET", Path: "/admin/jobs/{name}",
+		Args: []string{"name"}, Flags: []string{"limit"}, Help: "list the latest runs of a job"},
+	{Name: "config reload", Method: "POST", Path: "/admin/1/reload", Help: "reload the configuration file"},
+}
+
+// sessionListQuery turns the flags of "sessions list" into a search query.
+func sessionListQuery(params url.Values) error {
+	var terms []string
+	switch open, closed := params.Get("open") != "", params.Get("closed") != ""; {
+	case open && closed:
+		return fmt.Errorf("-open excludes -closed")
+	case open:
+		terms = append(terms, "status:open")
+	case closed:
+		terms = append(terms, "status:closed")
+	}
+	for _, field := range []string{"jid", "machine_id", "tag", "created"} {
+		if v := params.Get(field); v != "" {
+			terms = append(terms, field+":"+v)
+		}
+		params.Del(field)
+	}
+	params.Del("open")
+	params.Del("closed")
+	if terms == nil {
+		return fmt.Errorf("expected at least one of -open, -closed, -jid, -machine-id, -tag and -created")
+	}
+	params.Set("q", strings.Join(terms, " "))
+	return nil
+}
+
+func (cmd *adminCommand) usage() string {
+	s := cmd.Name
+	for _, arg := range cmd.Args {
+		s += " <" + arg + ">"
+	}
+	for _, b := range cmd.Bools {
+		s += " [-" + b + "]"
+	}
+	for _, f := range cmd.Flags {
+		s += " [-" + f + " " + strings.Replace(f, "-", "_", -1) + "]"
+	}
+	return s
+}
+
+// request builds the request of the command with args, which mix
+// positional arguments and flags in any order.
+func (cmd *adminCommand) request(base string, args []string) (*http.Request, error) {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	flags := make(map[string]*string)
+	for _, f := range cmd.Flags {
+		flags[f] = fs.String(f, "", "")
+	}
+	bools := make(map[string]*bool)
+	for _, b := range cmd.Bools {
+		bools[b] = fs.Bool(b, false, "")
+	}
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%v, usage: %s", err, cmd.usage())
+		}
+		if args = fs.Args(); len(args) == 0 {
+			break
+		}
+		positional, args = append(positional, args[0]), args[1:]
+	}
+	if len(positional) != len(cmd.Args) {
+		return nil, fmt.Errorf("usage: %s", cmd.usage())
+	}
+	params := url.Values{}
+	path := cmd.Path
+	for i, name := range cmd.Args {
+		if strings.Contains(path, "{"+name+"}") {
+			path = strings.Replace(path, "{"+name+"}", url.PathEscape(positional[i]), 1)
+			continue
+		}
+		params.Set(name, positional[i])
+	}
+	for name, v := range flags {
+		if *v != "" {
+			params.Set(strings.Replace(name, "-", "_", -1), *v)
+		}
+	}
+	for name, v := range bools {
+		if *v {
+			params.Set(name, "true")
+		}
+	}
+	if cmd.query != nil {
+		if err := cmd.query(params); err != nil {
+			return nil, err
+		}
+	}
+	u := strings.TrimSuffix(base, "/") + path
+	if cmd.Method == "GET" {
+		if len(params) > 0 {
+			u += "?" + params.Encode()
+		}
+		return http.NewRequest("GET", u, nil)
+	}
+	req, err := http.NewRequest(cmd.Method, u, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// findAdminCommand returns the command named by the first two words of args.
+func findAdminCommand(args []string) *adminCommand {
+	if len(args) < 2 {
+		return nil
+	}
+	for _, cmd := range adminCommands {
+		if cmd.Name == args[0]+" "+args[1] {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// adminClient calls the admin API for "elephant-tracker admin".
+var adminClient = &http.Client{Timeout: time.Minute}
+
+// runAdmin runs "elephant-tracker admin" with args, printing the responses
+// of the admin API to stdout and errors to stderr, and returns the exit
+// status: 0 on success, 1 when the API refuses the request and 2 on usage
+// errors.
+func runAdmin(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	defaultURL := os.Getenv("ELEPHANT_TRACKER_URL")
+	if defaultURL == "" {
+		defaultURL = "http://localhost:8080"
+	}
+	baseURL := fs.String("url", defaultURL, "URL of the tracker, $ELEPHANT_TRACKER_URL by default")
+	token := fs.String("token", os.Getenv("ELEPHANT_TRACKER_ADMIN_TOKEN"),
+		"admin token, $ELEPHANT_TRACKER_ADMIN_TOKEN by default")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elephant-tracker admin [-url url] [-token token] <command>")
+		fmt.Fprintln(stderr, "\nflags:")
+		fs.PrintDefaults()
+		fmt.Fprintln(stderr, "\ncommands:")
+		for _, cmd := range adminCommands {
+			fmt.Fprintf(stderr, "  %s\n        %s\n", cmd.usage(), cmd.Help)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cmd := findAdminCommand(fs.Args())
+	if cmd == nil {
+		fs.Usage()
+		return 2
+	}
+	req, err := cmd.request(*baseURL, fs.Args()[2:])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	if *token == "" {
+		fmt.Fprintln(stderr, "missing admin token, set -token or $ELEPHANT_TRACKER_ADMIN_TOKEN")
+		return 2
+	}
+	req.Header.Set("X-Admin-Token", *token)
+	resp, err := adminClient.Do(req)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		fmt.Fprintf(stderr, "%s: ", resp.Status)
+		io.Copy(stderr, resp.Body)
+		return 1
+	}
+	io.Copy(stdout, resp.Body)
+	return 0
+}
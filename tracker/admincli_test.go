@@ -0,0 +1,138 @@
+package tracker
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type adminCLITest struct {
+	old    *Config
+	store  *MemoryStore
+	server *httptest.Server
+}
+
+func newAdminCLITest(t *testing.T) *adminCLITest {
+	s := &adminCLITest{}
+	s.old = currentConfig()
+	setConfig(&Config{Admin: &AdminConfig{Tokens: []AdminToken{{Token: testAdminToken, Role: RoleOperator}}}})
+	s.store = NewMemoryStore()
+	openStore = func() (Storage, func()) {
+		return s.store, func() {}
+	}
+	s.server = httptest.NewServer(APIHandler())
+	t.Cleanup(func() {
+		s.server.Close()
+		setConfig(s.old)
+	})
+	return s
+}
+
+func (s *adminCLITest) admin(args ...string) (status int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	status = runAdmin(append([]string{"-url", s.server.URL, "-token", testAdminToken}, args...), &out, &errOut)
+	return status, out.String(), errOut.String()
+}
+
+func TestAdminCLIBlocks(t *testing.T) {
+	s := newAdminCLITest(t)
+	status, out, _ := s.admin("blocks", "add", "jid", "spammer@server.org", "Blocked for spam")
+	if got, want := status, 0; got != want {
+		t.Fatalf("status = %v, want %v", got, want)
+	}
+	id := strings.TrimSpace(out)
+	if got, want := len(s.store.BlockList), 1; got != want {
+		t.Fatalf("len(s.store.BlockList) = %d, want %d", got, want)
+	}
+	if got, want := s.store.BlockList[0].Id.Hex(), id; got != want {
+		t.Errorf("s.store.BlockList[0].Id.Hex() = %v, want %v", got, want)
+	}
+	if got, want := s.store.BlockList[0].Message, "Blocked for spam"; got != want {
+		t.Errorf("s.store.BlockList[0].Message = %v, want %v", got, want)
+	}
+
+	status, out, _ = s.admin("blocks", "list")
+	if got, want := status, 0; got != want {
+		t.Errorf("status = %v, want %v", got, want)
+	}
+	if got, want := strings.Contains(out, "spammer@server.org"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	status, _, _ = s.admin("blocks", "remove", id)
+	if got, want := status, 0; got != want {
+		t.Errorf("status = %v, want %v", got, want)
+	}
+	if got, want := len(s.store.BlockList), 0; got != want {
+		t.Errorf("len(s.store.BlockList) = %d, want %d", got, want)
+	}
+	status, _, errOut := s.admin("blocks", "remove", id)
+	if got, want := status, 1; got != want {
+		t.Errorf("status = %v, want %v", got, want)
+	}
+	if got, want := strings.HasPrefix(errOut, "400 Bad Request: "), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAdminCLISessions(t *testing.T) {
+	s := newAdminCLITest(t)
+	open := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	if err := s.store.InsertSession(open); err != nil {
+		t.Fatal(err)
+	}
+	status, out, _ := s.admin("sessions", "list", "-open", "-jid", "*@server.org")
+	if got, want := status, 0; got != want {
+		t.Errorf("status = %v, want %v", got, want)
+	}
+	if got, want := strings.Contains(out, open.Id.String()), true; got != want {
+		t.Errorf("strings.Contains(out, open.Id.String()) = %v, want %v", got, want)
+	}
+
+	// Flags can follow the positional arguments.
+	status, _, _ = s.admin("sessions", "tag", open.Id.String(), "beta-tester", "-comment", "asked to")
+	if got, want := status, 0; got != want {
+		t.Errorf("status = %v, want %v", got, want)
+	}
+	if got, want := open.Tags, ([]string{"beta-tester"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("open.Tags = %v, want %v", got, want)
+	}
+	if got, want := s.store.Audit[0].Comment, "asked to"; got != want {
+		t.Errorf("s.store.Audit[0].Comment = %v, want %v", got, want)
+	}
+}
+
+func TestAdminCLIUsage(t *testing.T) {
+	s := newAdminCLITest(t)
+	status, _, errOut := s.admin("sessions", "list")
+	if got, want := status, 2; got != want {
+		t.Errorf("status = %v, want %v", got, want)
+	}
+	if got, want := errOut, "expected at least one of -open, -closed, -jid, -machine-id, -tag and -created\n"; got != want {
+		t.Errorf("errOut = %v, want %v", got, want)
+	}
+	status, _, errOut = s.admin("blocks", "add", "jid")
+	if got, want := status, 2; got != want {
+		t.Errorf("status = %v, want %v", got, want)
+	}
+	if got, want := errOut, "usage: blocks add <field> <value> <message>\n"; got != want {
+		t.Errorf("errOut = %v, want %v", got, want)
+	}
+	status, _, errOut = s.admin("sessions", "purge")
+	if got, want := status, 2; got != want {
+		t.Errorf("status = %v, want %v", got, want)
+	}
+	if got, want := strings.Contains(errOut, "jobs history <name> [-limit limit]"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	var out, errBuf bytes.Buffer
+	if got, want := runAdmin([]string{"-url", s.server.URL, "-token", "wrong", "jobs", "list"}, &out, &errBuf), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := strings.HasPrefix(errBuf.String(), "403 Forbidden: "), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
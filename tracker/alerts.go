@@ -0,0 +1,332 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of alerts.
+const (
+	defaultAlertInterval    = time.Minute
+	defaultAlertCooldown    = time.Hour
+	defaultAlertMinRequests = 20
+)
+
+// Rules of alerts.
+const (
+	AlertErrorRate    = "error_rate"
+	AlertNoSessions   = "no_sessions"
+	AlertStorageDown  = "storage_down"
+	AlertSessionsDrop = "sessions_drop"
+	AlertCrashSpike   = "crash_spike"
+)
+
+// An Alert tells operators that a threshold of alerts was crossed, or with
+// Resolved that it is not anymore.
+type Alert struct {
+	Rule string
+	// App is the app whose sessions crossed the threshold, empty for the
+	// default app and for the rules of the whole tracker.
+	App      string
+	Resolved bool
+	Message  string
+	At       time.Time
+}
+
+// Subject sums up the alert in a line.
+func (a *Alert) Subject() string {
+	rule := a.Rule
+	if a.App != "" {
+		rule = fmt.Sprintf("%s (%s)", a.Rule, a.App)
+	}
+	if a.Resolved {
+		return fmt.Sprintf("[elephant-tracker] resolved: %s", rule)
+	}
+	return fmt.Sprintf("[elephant-tracker] alert: %s", rule)
+}
+
+// A Notifier delivers alerts to operators.
+type Notifier interface {
+	Notify(*Alert) error
+}
+
+// alertNotifiers returns the notifiers configured in conf.
+func alertNotifiers(conf *AlertsConfig) []Notifier {
+	var notifiers []Notifier
+	if conf.Email != nil {
+		notifiers = append(notifiers, &emailNotifier{conf.Email})
+	}
+	if conf.Slack != nil {
+		notifiers = append(notifiers, &slackNotifier{conf.Slack})
+	}
+	if conf.Telegram != nil {
+		notifiers = append(notifiers, &telegramNotifier{conf.Telegram})
+	}
+	return notifiers
+}
+
+// sendMail sends an email, as smtp.SendMail. Tests replace it.
+var sendMail = smtp.SendMail
+
+// emailNotifier mails alerts through a SMTP server.
+type emailNotifier struct {
+	conf *EmailConfig
+}
+
+func (n *emailNotifier) Notify(a *Alert) error {
+	var auth smtp.Auth
+	if n.conf.Username != "" {
+		host := n.conf.SMTP
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", n.conf.Username, n.conf.Password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.conf.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.conf.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", a.Subject())
+	fmt.Fprintf(&msg, "Date: %s\r\n", a.At.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n", a.Message)
+	return sendMail(n.conf.SMTP, auth, n.conf.From, n.conf.To, msg.Bytes())
+}
+
+// postAlert posts v as JSON to a chat service, like postWebhook. Errors
+// leave the URL out, since the URLs of Slack webhooks and Telegram bots are
+// secrets and errors are logged.
+func postAlert(u string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(u, "application/json; charset=utf-8", bytes.NewReader(body))
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// alertText is the text of an alert in a chat message.
+func alertText(a *Alert) string {
+	return a.Subject() + "\n" + a.Message
+}
+
+// slackNotifier posts alerts to a Slack channel through an incoming webhook.
+type slackNotifier struct {
+	conf *SlackConfig
+}
+
+func (n *slackNotifier) Notify(a *Alert) error {
+	return postAlert(n.conf.WebhookURL, map[string]string{"text": alertText(a)})
+}
+
+// telegramAPI is the endpoint of the Telegram Bot API. Tests replace it.
+var telegramAPI = "https://api.telegram.org"
+
+// telegramNotifier sends alerts to a Telegram chat through a bot.
+type telegramNotifier struct {
+	conf *TelegramConfig
+}
+
+func (n *telegramNotifier) Notify(a *Alert) error {
+	return postAlert(fmt.Sprintf("%s/bot%s/sendMessage", telegramAPI, n.conf.BotToken), map[string]string{
+		"chat_id": n.conf.ChatID,
+		"text":    alertText(a),
+	})
+}
+
+// alertKey keys the state of a rule, which is kept per app for the rules
+// on sessions.
+type alertKey struct {
+	App, Rule string
+}
+
+// alertRule is the state of a rule between checks.
+type alertRule struct {
+	// Firing is set while the threshold is crossed, and Notified once
+	// operators were told about it.
+	Firing   bool
+	Notified bool
+	LastSent time.Time
+}
+
+// Alerter checks the thresholds of alerts, remembering between checks what
+// it told operators, so that the same alert is sent at most once per
+// cooldown and its resolution only if it was sent. It is safe for
+// concurrent use.
+type Alerter struct {
+	sync.Mutex
+	rules map[alertKey]*alertRule
+	// requests and errors are the counters of runtimeStats at the last check.
+	requests, errors int64
+}
+
+func NewAlerter() *Alerter {
+	return &Alerter{rules: make(map[alertKey]*alertRule)}
+}
+
+// check evaluates the rules of c.Alerts as of now, those on sessions for
+// each app with its own storage, given that of the default app. It returns
+// the alerts to send, and the first error of a rule that could not be
+// evaluated.
+func (al *Alerter) check(store Storage, c *Config, now time.Time) ([]*Alert, error) {
+	al.Lock()
+	defer al.Unlock()
+	conf := c.Alerts
+	cooldown := defaultAlertCooldown
+	if conf.Cooldown.Duration > 0 {
+		cooldown = conf.Cooldown.Duration
+	}
+	var alerts []*Alert
+	update := func(app, rule string, firing bool, message string) {
+		key := alertKey{app, rule}
+		r, ok := al.rules[key]
+		if !ok {
+			r = &alertRule{}
+			al.rules[key] = r
+		}
+		switch {
+		case firing && now.Sub(r.LastSent) >= cooldown:
+			alerts = append(alerts, &Alert{Rule: rule, App: app, Message: message, At: now})
+		case !firing && r.Notified:
+			alerts = append(alerts, &Alert{Rule: rule, App: app, Resolved: true, Message: message, At: now})
+		}
+		if firing && !r.Firing {
+			r.Notified = false
+		}
+		r.Firing = firing
+	}
+
+	snap := runtimeStats.Snapshot()
+	requests, errors := snap.Requests-al.requests, snap.Errors-al.errors
+	al.requests, al.errors = snap.Requests, snap.Errors
+	if conf.ErrorRate > 0 {
+		minRequests := int64(defaultAlertMinRequests)
+		if conf.MinRequests > 0 {
+			minRequests = int64(conf.MinRequests)
+		}
+		var rate float64
+		if requests > 0 {
+			rate = float64(errors) / float64(requests)
+		}
+		update("", AlertErrorRate, requests >= minRequests && rate > conf.ErrorRate, fmt.Sprintf(
+			"%d of the %d requests served since the last check failed with 5xx, %.1f%% against a threshold of %.1f%%.",
+			errors, requests, 100*rate, 100*conf.ErrorRate))
+	}
+
+	since, down := runtimeStats.storageDownSince()
+	if d := conf.StorageDownFor.Duration; d > 0 {
+		firing := down && now.Sub(since) >= d
+		message := "Storage calls succeed again."
+		if firing {
+			message = fmt.Sprintf("Storage calls have been failing since %s, for more than %s.",
+				since.UTC().Format(time.RFC3339), d)
+		}
+		update("", AlertStorageDown, firing, message)
+	}
+	if down {
+		return alerts, nil
+	}
+
+	var err error
+	for _, app := range appIds(c) {
+		store := appStore(store, c, app)
+		if d := conf.NoSessionsFor.Duration; d > 0 {
+			sessions, e := store.SearchSessions(&SessionQuery{Created: TimeWindow{From: now.Add(-d)}}, 0, 1)
+			if e != nil {
+				if err == nil {
+					err = e
+				}
+				continue
+			}
+			firing := len(sessions) == 0
+			message := "New sessions are started again."
+			if firing {
+				message = fmt.Sprintf("No session was started in the last %s.", d)
+			}
+			update(app, AlertNoSessions, firing, message)
+		}
+		if conf.AnomalyThreshold > 0 {
+			anomalies, e := hourlyAnomalies(store, conf, now)
+			if e != nil && err == nil {
+				err = e
+			}
+			for _, a := range anomalies {
+				update(app, a.Rule, a.Firing, a.Message)
+			}
+		}
+	}
+	return alerts, err
+}
+
+// sent records that an alert was delivered.
+func (al *Alerter) sent(a *Alert) {
+	al.Lock()
+	defer al.Unlock()
+	if r, ok := al.rules[alertKey{a.App, a.Rule}]; ok {
+		r.Notified = !a.Resolved
+		r.LastSent = a.At
+	}
+}
+
+// notify checks the alerts and sends those due to every notifier,
+// returning how many it sent. An alert that no notifier delivered is sent
+// again at the next check.
+func (al *Alerter) notify(store Storage, c *Config, now time.Time) (int, error) {
+	conf := c.Alerts
+	alerts, err := al.check(store, c, now)
+	n := 0
+	for _, a := range alerts {
+		delivered := false
+		for _, notifier := range alertNotifiers(conf) {
+			if e := notifier.Notify(a); e != nil {
+				logger.Errorf("[alerts] failed to notify %s: %v", a.Rule, e)
+				if err == nil {
+					err = e
+				}
+				continue
+			}
+			delivered = true
+		}
+		if delivered {
+			al.sent(a)
+			n++
+		}
+	}
+	return n, err
+}
+
+// alertJob checks the thresholds of alerts, notifying operators when they
+// are crossed. It is disabled unless a notifier is configured. It is shared,
+// checking the rules on sessions of each app itself, so that the rules of
+// the whole tracker are checked once.
+var alertJob = &Job{
+	Name: "alerts",
+	Interval: func(c *Config) time.Duration {
+		if c == nil || c.Alerts == nil || len(alertNotifiers(c.Alerts)) == 0 {
+			return 0
+		}
+		if c.Alerts.Interval.Duration > 0 {
+			return c.Alerts.Interval.Duration
+		}
+		return defaultAlertInterval
+	},
+	Run: func(c *Context) (int, error) {
+		return c.Server.alerter.notify(c.Store, c.Config, time.Now())
+	},
+	Shared: true,
+}
@@ -0,0 +1,384 @@
+package tracker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type alertsTest struct {
+	savedStats *RuntimeStats
+	alerter    *Alerter
+	store      *MemoryStore
+	conf       *Config
+	mails      []string
+	mailErr    error
+}
+
+func newAlertsTest(t *testing.T) *alertsTest {
+	s := &alertsTest{}
+	s.savedStats = runtimeStats
+	runtimeStats = NewRuntimeStats()
+	s.alerter = NewAlerter()
+	s.store = NewMemoryStore()
+	s.conf = &Config{Alerts: &AlertsConfig{
+		Cooldown: Duration{time.Hour},
+		Email: &EmailConfig{
+			SMTP: "smtp.example.org:587",
+			From: "tracker@example.org",
+			To:   []string{"ops@example.org", "admin@example.org"},
+		},
+	}}
+	s.mails, s.mailErr = nil, nil
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		if s.mailErr != nil {
+			return s.mailErr
+		}
+		s.mails = append(s.mails, string(msg))
+		return nil
+	}
+	t.Cleanup(func() {
+		runtimeStats = s.savedStats
+		sendMail = smtp.SendMail
+	})
+	return s
+}
+
+func (s *alertsTest) serve(requests, errors int) {
+	for i := 0; i < requests; i++ {
+		status := 200
+		if i < errors {
+			status = 500
+		}
+		runtimeStats.ObserveRequest(status)
+	}
+}
+
+func TestDisabledWithoutNotifier(t *testing.T) {
+	s := newAlertsTest(t)
+	if got, want := alertJob.Interval(nil), time.Duration(0); got != want {
+		t.Errorf("alertJob.Interval(nil) = %v, want %v", got, want)
+	}
+	if got, want := alertJob.Interval(&Config{Alerts: &AlertsConfig{ErrorRate: 0.1}}), time.Duration(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := alertJob.Interval(s.conf), defaultAlertInterval; got != want {
+		t.Errorf("alertJob.Interval(s.conf) = %v, want %v", got, want)
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	s := newAlertsTest(t)
+	s.conf.Alerts.ErrorRate = 0.1
+	now := time.Now()
+	// Too few requests to tell.
+	s.serve(5, 5)
+	n, err := s.alerter.notify(s.store, s.conf, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 0; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+
+	s.serve(30, 10)
+	n, err = s.alerter.notify(s.store, s.conf, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := len(s.mails), 1; got != want {
+		t.Fatalf("len(s.mails) = %d, want %d", got, want)
+	}
+	if got, pattern := s.mails[0], "(?s)From: tracker@example.org\r\nTo: ops@example.org, admin@example.org\r\n"+
+		"Subject: \\[elephant-tracker\\] alert: error_rate\r\n.*10 of the 30 requests .* 33.3% against a threshold of 10.0%.*"; !fullMatch(pattern, got) {
+		t.Errorf("s.mails[0] = %q, want a match of %q", got, pattern)
+	}
+
+	// The same alert is held back during the cooldown.
+	s.serve(30, 10)
+	n, _ = s.alerter.notify(s.store, s.conf, now.Add(30*time.Minute))
+	if got, want := n, 0; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	s.serve(30, 10)
+	n, _ = s.alerter.notify(s.store, s.conf, now.Add(61*time.Minute))
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+
+	s.serve(30, 0)
+	n, _ = s.alerter.notify(s.store, s.conf, now.Add(62*time.Minute))
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := strings.Contains(s.mails[2], "Subject: [elephant-tracker] resolved: error_rate"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	s.serve(30, 0)
+	n, _ = s.alerter.notify(s.store, s.conf, now.Add(63*time.Minute))
+	if got, want := n, 0; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+}
+
+func TestStorageDown(t *testing.T) {
+	s := newAlertsTest(t)
+	s.conf.Alerts.StorageDownFor = Duration{5 * time.Minute}
+	s.conf.Alerts.NoSessionsFor = Duration{time.Hour}
+	runtimeStats.ObserveStorage(errors.New("no reachable servers"))
+	now := time.Now()
+	n, err := s.alerter.notify(s.store, s.conf, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 0; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+
+	// No sessions are expected while storage is down.
+	n, err = s.alerter.notify(s.store, s.conf, now.Add(10*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := len(s.mails), 1; got != want {
+		t.Fatalf("len(s.mails) = %d, want %d", got, want)
+	}
+	if got, want := strings.Contains(s.mails[0], "alert: storage_down"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNoSessions(t *testing.T) {
+	s := newAlertsTest(t)
+	s.conf.Alerts.NoSessionsFor = Duration{time.Hour}
+	now := time.Now()
+	s.mailErr = errors.New("connection refused")
+	n, err := s.alerter.notify(s.store, s.conf, now)
+	if err, pattern := err, "connection refused"; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("err = %v, want an error matching %q", err, pattern)
+	}
+	if got, want := n, 0; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+
+	// Undelivered alerts are sent at the next check.
+	s.mailErr = nil
+	n, err = s.alerter.notify(s.store, s.conf, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := strings.Contains(s.mails[0], "No session was started in the last 1h0m0s."), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := s.store.InsertSession(NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)); err != nil {
+		t.Fatal(err)
+	}
+	n, err = s.alerter.notify(s.store, s.conf, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := strings.Contains(s.mails[1], "resolved: no_sessions"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestAlertsPerApp(t *testing.T) {
+	s := newAlertsTest(t)
+	s.conf.Alerts.NoSessionsFor = Duration{time.Hour}
+	s.conf.Apps = &AppsConfig{List: []*AppConfig{{Id: "letravox"}}}
+	if got, want := alertJob.Shared, true; got != want {
+		t.Errorf("alertJob.Shared = %v, want %v", got, want)
+	}
+	if err := s.store.InsertSession(NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	n, err := s.alerter.notify(s.store, s.conf, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := len(s.mails), 1; got != want {
+		t.Fatalf("len(s.mails) = %d, want %d", got, want)
+	}
+	if got, want := strings.Contains(s.mails[0], "Subject: [elephant-tracker] alert: no_sessions (letravox)"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// The default app going quiet is alerted, even while letravox is held
+	// back by its cooldown.
+	s.store.Sessions = make(map[SessionId]*Session)
+	app := s.store.app("letravox")
+	if err := app.InsertSession(NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)); err != nil {
+		t.Fatal(err)
+	}
+	n, err = s.alerter.notify(s.store, s.conf, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 2; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := len(s.mails), 3; got != want {
+		t.Fatalf("len(s.mails) = %d, want %d", got, want)
+	}
+	if got, want := strings.Contains(s.mails[1], "Subject: [elephant-tracker] alert: no_sessions\r\n"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := strings.Contains(s.mails[2], "Subject: [elephant-tracker] resolved: no_sessions (letravox)"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// insertSessions inserts n sessions started at at and closed 10 minutes
+// later, the first crashed of them by a crash.
+func (s *alertsTest) insertSessions(t *testing.T, n, crashed int, at time.Time) {
+	for i := 0; i < n; i++ {
+		x := NewSession(fmt.Sprintf("user%d@server.org", i), "00:26:cc:18:be:14", "1.0", nil)
+		x.CreatedAt, x.ClosedAt, x.ClosedReason = at, at.Add(10*time.Minute), ClosedByClient
+		if i < crashed {
+			x.ClosedReason = ClosedCrash
+		}
+		if err := s.store.InsertSession(x); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAnomalies(t *testing.T) {
+	s := newAlertsTest(t)
+	s.conf.Alerts.AnomalyThreshold = 3
+	today := time.Date(2014, 5, 10, 0, 5, 0, 0, time.UTC)
+	for _, h := range []int{11, 12, 13} {
+		at := today.Add(time.Duration(h) * time.Hour)
+		// 20 sessions on average, one of them crashed.
+		for k := 1; k <= defaultAnomalyDays; k++ {
+			s.insertSessions(t, 18+2*(k%3), 1, at.AddDate(0, 0, -k))
+		}
+	}
+	s.insertSessions(t, 21, 1, today.Add(11*time.Hour))
+	s.insertSessions(t, 2, 0, today.Add(12*time.Hour))
+	s.insertSessions(t, 20, 10, today.Add(13*time.Hour))
+
+	n, err := s.alerter.notify(s.store, s.conf, today.Add(12*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 0; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+
+	n, err = s.alerter.notify(s.store, s.conf, today.Add(13*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := len(s.mails), 1; got != want {
+		t.Fatalf("len(s.mails) = %d, want %d", got, want)
+	}
+	if got, pattern := s.mails[0], "(?s).*alert: sessions_drop.*2 sessions were started from 2014-05-10 12:00 to 13:00 UTC, "+
+		"against 20.0 on average at that hour over the last 7 days, 4.0 standard deviations below.*"; !fullMatch(pattern, got) {
+		t.Errorf("s.mails[0] = %q, want a match of %q", got, pattern)
+	}
+
+	n, err = s.alerter.notify(s.store, s.conf, today.Add(14*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 2; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := len(s.mails), 3; got != want {
+		t.Fatalf("len(s.mails) = %d, want %d", got, want)
+	}
+	if got, want := strings.Contains(s.mails[1], "resolved: sessions_drop"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, pattern := s.mails[2], "(?s).*alert: crash_spike.*10 of the 20 sessions closed from 2014-05-10 13:00 to 14:00 UTC "+
+		"were closed by a crash, 50.0% against 5.0% on average .*"; !fullMatch(pattern, got) {
+		t.Errorf("s.mails[2] = %q, want a match of %q", got, pattern)
+	}
+}
+
+func TestChatNotifiers(t *testing.T) {
+	s := newAlertsTest(t)
+	var posts []string
+	var bodies []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		posts = append(posts, r.URL.Path)
+		bodies = append(bodies, body)
+		if strings.Contains(r.URL.Path, "revoked") {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+	saved := telegramAPI
+	telegramAPI = server.URL
+	defer func() { telegramAPI = saved }()
+
+	s.conf.Alerts = &AlertsConfig{
+		NoSessionsFor: Duration{time.Hour},
+		Slack:         &SlackConfig{WebhookURL: server.URL + "/services/T0/B0/x"},
+		Telegram:      &TelegramConfig{BotToken: "123:abc", ChatID: "@dosvox_ops"},
+	}
+	if got, want := alertJob.Interval(s.conf), defaultAlertInterval; got != want {
+		t.Errorf("alertJob.Interval(s.conf) = %v, want %v", got, want)
+	}
+	n, err := s.alerter.notify(s.store, s.conf, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := posts, ([]string{"/services/T0/B0/x", "/bot123:abc/sendMessage"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("posts = %v, want %v", got, want)
+	}
+	text := "[elephant-tracker] alert: no_sessions\nNo session was started in the last 1h0m0s."
+	if got, want := bodies[0], (map[string]string{"text": text}); !reflect.DeepEqual(got, want) {
+		t.Errorf("bodies[0] = %v, want %v", got, want)
+	}
+	if got, want := bodies[1], (map[string]string{"chat_id": "@dosvox_ops", "text": text}); !reflect.DeepEqual(got, want) {
+		t.Errorf("bodies[1] = %v, want %v", got, want)
+	}
+
+	// Failures do not tell the secret URLs.
+	err = (&telegramNotifier{&TelegramConfig{BotToken: "revoked", ChatID: "1"}}).Notify(&Alert{Rule: AlertNoSessions})
+	if err, pattern := err, "unexpected status 401 Unauthorized"; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("err = %v, want an error matching %q", err, pattern)
+	}
+	telegramAPI = "http://127.0.0.1:0"
+	err = (&telegramNotifier{&TelegramConfig{BotToken: "secret", ChatID: "1"}}).Notify(&Alert{Rule: AlertNoSessions})
+	if err == nil {
+		t.Fatal("err is nil")
+	}
+	if got, want := strings.Contains(err.Error(), "secret"), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,86 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"labix.org/v2/mgo"
+	"net/http"
+	"strings"
+)
+
+// Session aliases are short random codes, unrelated to the session id and
+// the user, that clients can print in logs and users can read aloud to
+// support. They use Crockford's base32 alphabet, which leaves out letters
+// easily mistaken for digits (I, L, O) and U.
+const (
+	aliasAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	aliasLength   = 8
+)
+
+// newSessionAlias returns a random alias, in canonical form.
+func newSessionAlias() string {
+	b := make([]byte, aliasLength)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = aliasAlphabet[int(b[i])%len(aliasAlphabet)]
+	}
+	return string(b)
+}
+
+// formatAlias groups an alias in two halves, as in "K7QX-4M2P", to make it easier to read aloud.
+func formatAlias(alias string) string {
+	if len(alias) != aliasLength {
+		return alias
+	}
+	return alias[:aliasLength/2] + "-" + alias[aliasLength/2:]
+}
+
+// normalizeAlias returns an alias as typed by a person in canonical form:
+// ignoring case, separators and the letters that look like digits.
+func normalizeAlias(s string) (string, bool) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case '-', ' ', '.':
+			return -1
+		case 'o', 'O':
+			return '0'
+		case 'i', 'I', 'l', 'L':
+			return '1'
+		}
+		return r
+	}, strings.ToUpper(s))
+	if len(s) != aliasLength {
+		return "", false
+	}
+	for _, r := range s {
+		if !strings.ContainsRune(aliasAlphabet, r) {
+			return "", false
+		}
+	}
+	return s, true
+}
+
+// SessionByAliasHandler resolves a session alias, for support.
+func SessionByAliasHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	alias, ok := normalizeAlias(mux.Vars(r)["alias"])
+	if !ok {
+		writeError(w, r, &APIError{"invalid_alias", "alias", "",
+			fmt.Sprintf("Invalid session alias %s", mux.Vars(r)["alias"])}, http.StatusBadRequest)
+		return
+	}
+	s, err := c.Store.FindSessionByAlias(alias)
+	switch err {
+	case nil:
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(s)
+	case mgo.ErrNotFound:
+		writeError(w, r, notFound(fmt.Sprintf("No session with alias %s", formatAlias(alias))), http.StatusNotFound)
+	default:
+		writeError(w, r, internalError("Failed to find session"), http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
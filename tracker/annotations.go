@@ -0,0 +1,233 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Tags are short labels like "beta-tester" or "reported-bug-42".
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// annotationTarget is the session or installation a tag or note is attached to.
+type annotationTarget struct {
+	SessionId SessionId
+	MachineId string
+}
+
+// kind names the target in audit actions and messages.
+func (t *annotationTarget) kind() string {
+	if t.MachineId != "" {
+		return "installation"
+	}
+	return "session"
+}
+
+func (t *annotationTarget) String() string {
+	if t.MachineId != "" {
+		return t.MachineId
+	}
+	return t.SessionId.String()
+}
+
+// annotationParams checks the parameters of an annotation request, which
+// names its target with exactly one of session_id or machine_id.
+func annotationParams(r *http.Request, conf *Config, required ...string) (*annotationTarget, APIErrors) {
+	errs := checkParams(r, required, "session_id", "machine_id", "comment")
+	sessionIdHex := r.PostFormValue("session_id")
+	machineId := r.PostFormValue("machine_id")
+	switch {
+	case sessionIdHex == "" && machineId == "":
+		errs = append(errs, &APIError{"missing_param", "session_id", "",
+			"Missing POST parameter session_id or machine_id"})
+	case sessionIdHex != "" && machineId != "":
+		errs = append(errs, &APIError{"unexpected_param", "machine_id", "",
+			"Unexpected POST parameter machine_id along with session_id"})
+	case sessionIdHex != "" && !validSessionId(conf, sessionIdHex):
+		errs = append(errs, invalidSessionId("session_id", sessionIdHex))
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	if machineId != "" {
+		return &annotationTarget{MachineId: canonicalMachineId(conf, machineId)}, nil
+	}
+	return &annotationTarget{SessionId: SessionId(sessionIdHex)}, nil
+}
+
+// tagParam parses the tag parameter of a request.
+func tagParam(r *http.Request) (string, *APIError) {
+	tag := r.FormValue("tag")
+	if !tagPattern.MatchString(tag) {
+		return "", &APIError{"invalid_tag", "tag", "", fmt.Sprintf(
+			"Invalid tag %s, expected up to 64 lowercase letters, digits, dots and dashes", tag)}
+	}
+	return tag, nil
+}
+
+// annotate stores an annotation with store and audits it, replying with an
+// error if it fails or the target does not exist. It reports whether it
+// succeeded.
+func annotate(w http.ResponseWriter, r *http.Request, c *Context, action string, t *annotationTarget,
+	details bson.M, store func() error) bool {
+	switch err := store(); err {
+	case nil:
+	case mgo.ErrNotFound:
+		if t.MachineId != "" {
+			writeError(w, r, &APIError{"installation_not_found", "machine_id", "",
+				fmt.Sprintf("Installation %s is not registered", t)}, http.StatusBadRequest)
+		} else {
+			writeError(w, r, &APIError{"session_not_found", "session_id", "",
+				fmt.Sprintf("Session %s does not exist", t)}, http.StatusBadRequest)
+		}
+		return false
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to annotate %s %s", t.kind(), t)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+		return false
+	}
+	a := NewAuditEntry(t.kind()+"."+action, t.String(), c.actor(r), r.PostFormValue("comment"), details)
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	return true
+}
+
+// tagTarget adds the tag parameter to, or with remove removes it from, the
+// session or installation of a request.
+func tagTarget(w http.ResponseWriter, r *http.Request, c *Context, remove bool) {
+	t, errs := annotationParams(r, c.Config, "tag")
+	tag, e := tagParam(r)
+	if e != nil && r.PostFormValue("tag") != "" {
+		errs = append(errs, e)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	action := "tag"
+	if remove {
+		action = "untag"
+	}
+	ok := annotate(w, r, c, action, t, bson.M{"tag": tag}, func() error {
+		if t.MachineId != "" {
+			return c.Store.TagInstallation(t.MachineId, tag, remove)
+		}
+		return c.Store.TagSession(t.SessionId, tag, remove)
+	})
+	if ok {
+		fmt.Fprintln(w, tag)
+	}
+}
+
+// TagHandler tags a session or an installation, for triage.
+func TagHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	tagTarget(w, r, c, false)
+}
+
+// UntagHandler removes a tag from a session or an installation.
+func UntagHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	tagTarget(w, r, c, true)
+}
+
+// NewNoteHandler attaches a free-text note to a session or an installation,
+// replying with the note.
+func NewNoteHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	t, errs := annotationParams(r, c.Config, "text")
+	// Notes keep the limit of other params, text is only long for feedback.
+	if n := len(r.PostFormValue("text")); n > maxParamBytes {
+		errs = append(errs, tooLong("text", "", n, maxParamBytes))
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	n := &Note{Text: r.PostFormValue("text"), By: r.RemoteAddr, At: time.Now().UTC()}
+	ok := annotate(w, r, c, "note", t, bson.M{"text": n.Text}, func() error {
+		if t.MachineId != "" {
+			return c.Store.AddInstallationNote(t.MachineId, n)
+		}
+		return c.Store.AddSessionNote(t.SessionId, n)
+	})
+	if ok {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(n)
+	}
+}
+
+// listedInstallation is an installation as listed by InstallationsHandler.
+type listedInstallation struct {
+	MachineId      string    `json:"machine_id"`
+	XMPPVOXVersion string    `json:"xmppvox_version"`
+	DosvoxVersion  string    `json:"dosvox_version,omitempty"`
+	Platform       *Platform `json:"platform,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	Ticket         string    `json:"ticket,omitempty"`
+	Tags           []string  `json:"tags"`
+	Notes          []*Note   `json:"notes"`
+}
+
+// InstallationsHandler lists the installations, newest first, or with the
+// tag parameter the installations tagged with it. Pages are like those of
+// session histories.
+func InstallationsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	page, limit, errs := historyPage(r)
+	tag, e := historyTag(r)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	var installations []*Installation
+	var err error
+	if tag != "" {
+		installations, err = c.Store.TaggedInstallations(tag, (page-1)*limit, limit+1)
+	} else {
+		installations, err = c.Store.RecentInstallations((page-1)*limit, limit+1)
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to list installations"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if writeHistoryLinks(w, r, page, len(installations) > limit) {
+		installations = installations[:limit]
+	}
+	listed := make([]*listedInstallation, len(installations))
+	for n, i := range installations {
+		listed[n] = &listedInstallation{
+			MachineId:      i.MachineId,
+			XMPPVOXVersion: i.XMPPVOXVersion,
+			DosvoxVersion:  i.DosvoxVersion,
+			Platform:       i.Platform,
+			CreatedAt:      i.CreatedAt,
+			Ticket:         i.Ticket,
+			Tags:           nonNilTags(i.Tags),
+			Notes:          nonNilNotes(i.Notes),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(listed)
+}
+
+// nonNilTags and nonNilNotes list nothing as [] rather than null in JSON.
+func nonNilTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+func nonNilNotes(notes []*Note) []*Note {
+	if notes == nil {
+		return []*Note{}
+	}
+	return notes
+}
@@ -0,0 +1,98 @@
+package tracker
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Defaults and bounds of the anomaly rules of alerts.
+const (
+	defaultAnomalyDays        = 7
+	defaultAnomalyMinSessions = 10
+	maxAnomalyDays            = 28
+)
+
+// anomaly is the outcome of an anomaly rule of alerts for the last hour.
+type anomaly struct {
+	Rule    string
+	Firing  bool
+	Message string
+}
+
+// meanStddev returns the mean and the population standard deviation of xs.
+func meanStddev(xs []float64) (mean, sd float64) {
+	if len(xs) == 0 {
+		return 0, 0
+	}
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(len(xs))
+	for _, x := range xs {
+		sd += (x - mean) * (x - mean)
+	}
+	return mean, math.Sqrt(sd / float64(len(xs)))
+}
+
+// hourlyAnomalies compares the hourly rollup of the last whole hour before
+// now with those of the same hour in the anomaly days before, by z-score,
+// so that the alert follows the usual rise and fall of the day:
+//
+//	sessions_drop  the sessions started are AnomalyThreshold standard
+//	               deviations below the mean of the same hours,
+//	crash_spike    the share of the sessions closed by a crash is as many
+//	               above the mean share of the same hours.
+//
+// Both counts are noisy when small, so the standard deviations are never
+// taken below those of a Poisson and a binomial distribution of the mean,
+// and hours usually with fewer than AnomalyMinSessions sessions never fire.
+func hourlyAnomalies(store Storage, conf *AlertsConfig, now time.Time) ([]*anomaly, error) {
+	days := defaultAnomalyDays
+	if conf.AnomalyDays > 0 {
+		days = conf.AnomalyDays
+	}
+	min := float64(defaultAnomalyMinSessions)
+	if conf.AnomalyMinSessions > 0 {
+		min = float64(conf.AnomalyMinSessions)
+	}
+	hour := periodStart(RollupHour, now).Add(-time.Hour)
+	rollups, _, err := sessionRollups(store, RollupHour, hour.AddDate(0, 0, -days), hour.Add(time.Hour))
+	if err != nil {
+		return nil, err
+	}
+	// There is a rollup per hour, the last one being of hour.
+	last := rollups[len(rollups)-1]
+	var started, crashed []float64
+	for k := 1; k <= days; k++ {
+		x := rollups[len(rollups)-1-24*k]
+		started = append(started, float64(x.SessionsStarted))
+		if x.SessionsClosed > 0 {
+			crashed = append(crashed, float64(x.SessionsCrashed)/float64(x.SessionsClosed))
+		}
+	}
+	span := fmt.Sprintf("from %s to %s UTC", hour.Format("2006-01-02 15:04"), hour.Add(time.Hour).Format("15:04"))
+
+	mean, sd := meanStddev(started)
+	sd = math.Max(sd, math.Sqrt(mean))
+	drop := &anomaly{Rule: AlertSessionsDrop, Message: "Sessions are started as usual again."}
+	if z := (float64(last.SessionsStarted) - mean) / sd; mean >= min && z <= -conf.AnomalyThreshold {
+		drop.Firing = true
+		drop.Message = fmt.Sprintf("%d sessions were started %s, against %.1f on average at that hour "+
+			"over the last %d days, %.1f standard deviations below.", last.SessionsStarted, span, mean, days, -z)
+	}
+
+	spike := &anomaly{Rule: AlertCrashSpike, Message: "Sessions are closed by crashes as usual again."}
+	if n := float64(last.SessionsClosed); n >= min {
+		share := float64(last.SessionsCrashed) / n
+		mean, sd := meanStddev(crashed)
+		sd = math.Max(sd, math.Sqrt(math.Max(mean, 1/n)/n))
+		if z := (share - mean) / sd; z >= conf.AnomalyThreshold {
+			spike.Firing = true
+			spike.Message = fmt.Sprintf("%d of the %d sessions closed %s were closed by a crash, %.1f%% against "+
+				"%.1f%% on average at that hour over the last %d days, %.1f standard deviations above.",
+				last.SessionsCrashed, last.SessionsClosed, span, 100*share, 100*mean, days, z)
+		}
+	}
+	return []*anomaly{drop, spike}, nil
+}
@@ -0,0 +1,3481 @@
+package tracker
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fullMatch reports whether pattern matches all of s.
+func fullMatch(pattern, s string) bool {
+	return regexp.MustCompile("^(?:" + pattern + ")$").MatchString(s)
+}
+
+type webAPITest struct {
+	Store  Storage
+	Config *Config
+}
+
+const testAdminToken = "test-admin-token"
+
+func newWebAPITest(t *testing.T) *webAPITest {
+	s := &webAPITest{}
+	s.Store = NewMemoryStore()
+	eventLimiter = NewRateLimiter("event")
+	apiKeyLimiter = NewRateLimiter("api_key")
+	pingLimiter = NewRateLimiter("ping")
+	s.Config = &Config{
+		Admin: &AdminConfig{Tokens: []AdminToken{{Token: testAdminToken, Role: RoleOperator}}},
+	}
+	return s
+}
+
+type Response struct {
+	Body       string
+	StatusCode int
+	Header     http.Header
+}
+
+func (s *webAPITest) handlePost(h contextualHandlerFunc, data map[string]string) *Response {
+	return s.handlePostWithHeader(h, data, nil)
+}
+
+func (s *webAPITest) handlePostWithHeader(h contextualHandlerFunc, data map[string]string, header http.Header) *Response {
+	postData := url.Values{}
+	for key, value := range data {
+		postData.Set(key, value)
+	}
+	req, err := http.NewRequest("POST", "Dummy URL", strings.NewReader(postData.Encode()))
+	if err != nil {
+		panic(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h(w, req, &Context{Store: s.Store, Config: s.Config})
+	return &Response{
+		Body:       w.Body.String(),
+		StatusCode: w.Code,
+		Header:     w.Header(),
+	}
+}
+
+func (s *webAPITest) handleGet(pattern string, h contextualHandlerFunc, url string, header http.Header) *Response {
+	return s.handleRequest("GET", pattern, h, url, header)
+}
+
+func (s *webAPITest) handleRequest(method, pattern string, h contextualHandlerFunc, url string, header http.Header) *Response {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		panic(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	router := mux.NewRouter()
+	router.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		h(w, r, &Context{Store: s.Store, Config: s.Config})
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return &Response{
+		Body:       w.Body.String(),
+		StatusCode: w.Code,
+		Header:     w.Header(),
+	}
+}
+
+func (s *webAPITest) newInstallation(machineId, xmppvoxVersion string, dosvoxInfo, machineInfo map[string]string) *Response {
+	m := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return s.handlePost(NewInstallationHandler, map[string]string{
+		"machine_id":      machineId,
+		"xmppvox_version": xmppvoxVersion,
+		"dosvox_info":     m(dosvoxInfo),
+		"machine_info":    m(machineInfo),
+	})
+
+}
+
+func (s *webAPITest) newSession(jid, machineId, xmppvoxVersion string) *Response {
+	return s.handlePost(NewSessionHandler, map[string]string{
+		"jid":             jid,
+		"machine_id":      machineId,
+		"xmppvox_version": xmppvoxVersion,
+	})
+}
+
+func (s *webAPITest) closeSession(sessionId SessionId, machineId string) *Response {
+	return s.handlePost(CloseSessionHandler, map[string]string{
+		"session_id": sessionId.String(),
+		"machine_id": machineId,
+	})
+}
+
+func (s *webAPITest) pingSession(sessionId SessionId, machineId string) *Response {
+	return s.handlePost(PingSessionHandler, map[string]string{
+		"session_id": sessionId.String(),
+		"machine_id": machineId,
+	})
+}
+
+func (s *webAPITest) reopenSession(sessionId SessionId, token string) *Response {
+	return s.handlePostWithHeader(requireAdmin(ReopenSessionHandler), map[string]string{
+		"session_id": sessionId.String(),
+	}, http.Header{"X-Admin-Token": {token}})
+}
+
+// ************************ Tests ************************
+
+// Install tests
+
+func TestNewInstallation(t *testing.T) {
+	s := newWebAPITest(t)
+	const (
+		machineId      = "0e5ab64c-1b24-4917-bb9e-new-installation"
+		xmppvoxVersion = "1.1"
+	)
+	var (
+		dosvoxInfo = map[string]string{
+			"root":    "C:\\winvox",
+			"version": "4.0 BETA",
+			"email":   "fulano@servidor.com.br",
+		}
+		machineInfo = map[string]string{
+			"system":    "Windows",
+			"node":      "network-name",
+			"release":   "XP",
+			"version":   "5.1.2600",
+			"csd":       "SP2",
+			"ptype":     "Multiprocessor Free",
+			"machine":   "x86",
+			"processor": "x86 Family 6 Model 23 Stepping 10, GenuineIntel",
+		}
+	)
+	r := s.newInstallation(machineId, xmppvoxVersion, dosvoxInfo, machineInfo)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	installation := s.Store.(*MemoryStore).Installations[machineId]
+	if got, want := installation.CreatedAt.IsZero(), false; got != want {
+		t.Errorf("installation.CreatedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := installation.XMPPVOXVersion, xmppvoxVersion; got != want {
+		t.Errorf("installation.XMPPVOXVersion = %v, want %v", got, want)
+	}
+	if got, want := installation.DosvoxInfo, dosvoxInfo; !reflect.DeepEqual(got, want) {
+		t.Errorf("installation.DosvoxInfo = %v, want %v", got, want)
+	}
+	if got, want := installation.MachineInfo, machineInfo; !reflect.DeepEqual(got, want) {
+		t.Errorf("installation.MachineInfo = %v, want %v", got, want)
+	}
+}
+
+func TestNewInstallationDuplicateMachineId(t *testing.T) {
+	s := newWebAPITest(t)
+	const (
+		machineId      = "0e5ab64c-1b24-4917-new-installation-dup"
+		xmppvoxVersion = "1.1"
+	)
+	var (
+		dosvoxInfo = map[string]string{
+			"root":    "C:\\winvox",
+			"version": "4.0 BETA",
+			"email":   "fulano@servidor.com.br",
+		}
+		machineInfo = map[string]string{
+			"system":    "Windows",
+			"node":      "network-name",
+			"release":   "XP",
+			"version":   "5.1.2600",
+			"csd":       "SP2",
+			"ptype":     "Multiprocessor Free",
+			"machine":   "x86",
+			"processor": "x86 Family 6 Model 23 Stepping 10, GenuineIntel",
+		}
+	)
+	r := s.newInstallation(machineId, xmppvoxVersion, dosvoxInfo, machineInfo)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	// try to install again with the same info
+	r = s.newInstallation(machineId, xmppvoxVersion, dosvoxInfo, machineInfo)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestPingInstallation(t *testing.T) {
+	s := newWebAPITest(t)
+	ping := func(machineId, xmppvoxVersion string) *Response {
+		return s.handlePost(PingInstallationHandler, map[string]string{
+			"machine_id":      machineId,
+			"xmppvox_version": xmppvoxVersion,
+		})
+	}
+	r := ping("00:26:cc:18:be:14", "1.1")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Installation 00:26:cc:18:be:14 is not registered\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	if got, want := ping("", "").Body, "Missing POST parameter machine_id\nMissing POST parameter xmppvox_version\n"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if got, want := s.newInstallation("00:26:cc:18:be:14", "1.0", nil, nil).StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	r = ping("00:26:cc:18:be:14", "1.1")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "00:26:cc:18:be:14\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	i := s.Store.(*MemoryStore).Installations["00:26:cc:18:be:14"]
+	// The registered version is kept as the version installed first.
+	if got, want := i.XMPPVOXVersion, "1.0"; got != want {
+		t.Errorf("i.XMPPVOXVersion = %v, want %v", got, want)
+	}
+	if got, want := i.CurrentVersion, "1.1"; got != want {
+		t.Errorf("i.CurrentVersion = %v, want %v", got, want)
+	}
+	if got, want := time.Since(i.LastSeen) < time.Minute, true; got != want {
+		t.Errorf("time.Since(i.LastSeen) < time.Minute = %v, want %v", got, want)
+	}
+}
+
+func TestRemoveInstallation(t *testing.T) {
+	s := newWebAPITest(t)
+	remove := func(params map[string]string) *Response {
+		return s.handlePost(RemoveInstallationHandler, params)
+	}
+	r := remove(map[string]string{"machine_id": "00:26:cc:18:be:14"})
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Installation 00:26:cc:18:be:14 is not registered\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+
+	if got, want := s.newInstallation("00:26:cc:18:be:14", "1.0", nil, nil).StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	r = remove(map[string]string{"machine_id": "00:26:cc:18:be:14", "reason": " Switched to another client "})
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "00:26:cc:18:be:14\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	i := s.Store.(*MemoryStore).Installations["00:26:cc:18:be:14"]
+	if got, want := time.Since(i.UninstalledAt) < time.Minute, true; got != want {
+		t.Errorf("time.Since(i.UninstalledAt) < time.Minute = %v, want %v", got, want)
+	}
+	if got, want := i.UninstallReason, "Switched to another client"; got != want {
+		t.Errorf("i.UninstallReason = %v, want %v", got, want)
+	}
+}
+
+func TestNewInstallationMissingFields(t *testing.T) {
+	s := newWebAPITest(t)
+	const (
+		machineId      = "0e5ab64c-1b24-4917-new-installation-missing"
+		xmppvoxVersion = "1.1"
+	)
+	var (
+		dosvoxInfo = map[string]string{
+			"root":    "C:\\winvox",
+			"version": "4.0 BETA",
+			"email":   "fulano@servidor.com.br",
+		}
+		machineInfo = map[string]string{
+			"system":    "Windows",
+			"node":      "network-name",
+			"release":   "XP",
+			"version":   "5.1.2600",
+			"csd":       "SP2",
+			"ptype":     "Multiprocessor Free",
+			"machine":   "x86",
+			"processor": "x86 Family 6 Model 23 Stepping 10, GenuineIntel",
+		}
+	)
+	countBefore := len(s.Store.(*MemoryStore).Installations)
+	type TestCase struct {
+		MachineId, XMPPVOXVersion string
+		DosvoxInfo, MachineInfo   map[string]string
+	}
+	for _, tc := range []TestCase{
+		TestCase{"", xmppvoxVersion, dosvoxInfo, machineInfo},
+		TestCase{machineId, "", dosvoxInfo, machineInfo},
+		TestCase{"", "", nil, nil},
+	} {
+		r := s.newInstallation(tc.MachineId, tc.XMPPVOXVersion, tc.DosvoxInfo, tc.MachineInfo)
+		if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+			t.Errorf("r.StatusCode = %v, want %v", got, want)
+		}
+	}
+	countAfter := len(s.Store.(*MemoryStore).Installations)
+	if got, want := countAfter, countBefore; got != want {
+		t.Errorf("countAfter = %v, want %v", got, want)
+	}
+}
+
+func TestNewInstallationReportsAllErrors(t *testing.T) {
+	s := newWebAPITest(t)
+	r := s.handlePostWithHeader(NewInstallationHandler, map[string]string{
+		"machine_id":   "0e5ab64c-1b24-4917-new-installation-errors",
+		"dosvox_info":  `{"version": 4, "root": "C:\\winvox", "beta": true}`,
+		"machine_info": `not json`,
+		"extra":        "field",
+	}, http.Header{"Accept": {"application/json"}})
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	var body struct {
+		Errors []APIError
+	}
+	if err := json.Unmarshal([]byte(r.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Errors, ([]APIError{
+		{"missing_param", "xmppvox_version", "", "Missing POST parameter xmppvox_version"},
+		{"unexpected_param", "extra", "", "Unexpected POST parameter extra"},
+		{"invalid_value_type", "dosvox_info", "beta", `Invalid value for dosvox_info["beta"]: expected a string`},
+		{"invalid_value_type", "dosvox_info", "version", `Invalid value for dosvox_info["version"]: expected a string`},
+		{"invalid_json", "machine_info", "", "Invalid JSON for machine_info: expected null or an object of strings"},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Errors = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).Installations), 0; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Installations) = %d, want %d", got, want)
+	}
+}
+
+func TestNewInstallationTooManyKeys(t *testing.T) {
+	s := newWebAPITest(t)
+	info := make(map[string]string)
+	for i := 0; i <= defaultMaxInfoKeys; i++ {
+		info[fmt.Sprint("key", i)] = "value"
+	}
+	r := s.newInstallation("0e5ab64c-1b24-4917-new-installation-big", "1.1", nil, info)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := strings.HasPrefix(r.Body, "Too many keys in machine_info"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// New Session tests
+
+func TestNewSession(t *testing.T) {
+	s := newWebAPITest(t)
+	const (
+		jid            = "testuser@server.org"
+		machineId      = "00:26:cc:18:be:14"
+		xmppvoxVersion = "1.0"
+	)
+	r := s.newSession(jid, machineId, xmppvoxVersion)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	idHex := strings.TrimSpace(r.Body)
+	if got, want := bson.IsObjectIdHex(idHex), true; got != want {
+		t.Fatalf("bson.IsObjectIdHex(idHex) = %v, want %v", got, want)
+	}
+	id := SessionId(idHex)
+	session := s.Store.(*MemoryStore).Sessions[id]
+	if got, want := session.CreatedAt.IsZero(), false; got != want {
+		t.Errorf("session.CreatedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := session.ClosedAt.IsZero(), true; got != want {
+		t.Errorf("session.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := session.LastPing.IsZero(), true; got != want {
+		t.Errorf("session.LastPing.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := session.JID, jid; got != want {
+		t.Errorf("session.JID = %v, want %v", got, want)
+	}
+	if got, want := session.MachineId, machineId; got != want {
+		t.Errorf("session.MachineId = %v, want %v", got, want)
+	}
+	if got, want := session.XMPPVOXVersion, xmppvoxVersion; got != want {
+		t.Errorf("session.XMPPVOXVersion = %v, want %v", got, want)
+	}
+	if session.Request == nil {
+		t.Error("session.Request is nil")
+	}
+}
+
+func TestNewSessionMissingFields(t *testing.T) {
+	s := newWebAPITest(t)
+	countBefore := len(s.Store.(*MemoryStore).Sessions)
+	type TestCase struct {
+		JID, MachineId, XMPPVOXVersion string
+	}
+	for _, tc := range []TestCase{
+		TestCase{"", "00:26:cc:18:be:14", "1.0"},
+		TestCase{"testuser@server.org", "", "1.0"},
+		TestCase{"testuser@server.org", "00:26:cc:18:be:14", ""},
+		TestCase{"testuser@server.org", "", ""},
+		TestCase{"", "", ""},
+	} {
+		r := s.newSession(tc.JID, tc.MachineId, tc.XMPPVOXVersion)
+		if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+			t.Errorf("r.StatusCode = %v, want %v", got, want)
+		}
+	}
+	countAfter := len(s.Store.(*MemoryStore).Sessions)
+	if got, want := countAfter, countBefore; got != want {
+		t.Errorf("countAfter = %v, want %v", got, want)
+	}
+}
+
+func TestNewSessionJID(t *testing.T) {
+	s := newWebAPITest(t)
+	r := s.newSession("testuser@Server.org/XMPPVOX", "00:26:cc:18:be:14", "1.0")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[SessionId(strings.TrimSpace(r.Body))]
+	if got, want := session.JID, "testuser@server.org"; got != want {
+		t.Errorf("session.JID = %v, want %v", got, want)
+	}
+	if got, want := session.Resource, "XMPPVOX"; got != want {
+		t.Errorf("session.Resource = %v, want %v", got, want)
+	}
+
+	countBefore := len(s.Store.(*MemoryStore).Sessions)
+	r = s.newSession("testuser server.org", "00:26:cc:18:be:14", "1.0")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Invalid jid testuser server.org, expected user@domain\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).Sessions), countBefore; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Sessions) = %d, want %d", got, want)
+	}
+}
+
+func TestNewSessionXMPPServer(t *testing.T) {
+	s := newWebAPITest(t)
+	data := map[string]string{"jid": "testuser@server.org", "machine_id": "00:26:cc:18:be:14",
+		"xmppvox_version": "1.0", "xmpp_server": "XMPP.Server.org"}
+	r := s.handlePost(NewSessionHandler, data)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("%s: r.StatusCode = %v, want %v", r.Body, got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[SessionId(strings.TrimSpace(r.Body))]
+	if got, want := session.XMPPServer, "xmpp.server.org"; got != want {
+		t.Errorf("session.XMPPServer = %v, want %v", got, want)
+	}
+
+	data["xmpp_server"] = "xmpp.server.org:5222"
+	r = s.handlePost(NewSessionHandler, data)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Invalid xmpp_server xmpp.server.org:5222, expected a host name like xmpp.example.org\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+}
+
+func TestNewSessionHashedJID(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Privacy = &PrivacyConfig{HashJIDs: true, JIDSalt: "salt"}
+	r := s.newSession("testuser@Server.org/XMPPVOX", "00:26:cc:18:be:14", "1.0")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("%s: r.StatusCode = %v, want %v", r.Body, got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[SessionId(strings.TrimSpace(r.Body))]
+	if got, pattern := session.JID, "[0-9a-f]{32}@server.org"; !fullMatch(pattern, got) {
+		t.Errorf("session.JID = %q, want a match of %q", got, pattern)
+	}
+	// Nor is the resource kept, which often names the device.
+	if got, want := session.Resource, ""; got != want {
+		t.Errorf("session.Resource = %v, want %v", got, want)
+	}
+
+	// Admins look up and erase users by their jid as usual.
+	r = s.handleGet("/admin/1/users/{jid}/sessions", requireAdmin(UserSessionsHandler),
+		"/admin/1/users/testuser@server.org/sessions", http.Header{"X-Admin-Token": {testAdminToken}})
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("%s: r.StatusCode = %v, want %v", r.Body, got, want)
+	}
+	if got, want := strings.Contains(r.Body, session.JID), true; got != want {
+		t.Errorf("strings.Contains(r.Body, session.JID) = %v, want %v", got, want)
+	}
+	r, rep := s.erase("/admin/1/users/{jid}", "/admin/1/users/testuser@server.org?mode=erase")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("%s: r.StatusCode = %v, want %v", r.Body, got, want)
+	}
+	if got, want := rep.Erasure.Sessions, 1; got != want {
+		t.Errorf("rep.Erasure.Sessions = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).Sessions), 0; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Sessions) = %d, want %d", got, want)
+	}
+}
+
+func TestMachineIds(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.MachineIds = &MachineIdsConfig{Canonicalize: true, Formats: []string{MachineIdMAC}}
+	r := s.newInstallation("00-26-CC-18-BE-14", "1.0", nil, nil)
+	if got, want := r.Body, "00:26:cc:18:be:14\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	if s.Store.(*MemoryStore).Installations["00:26:cc:18:be:14"] == nil {
+		t.Error("got nil")
+	}
+	r = s.newInstallation("00-26-CC-18-BE-14", "1.0", nil, nil)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+
+	r = s.newSession("testuser@server.org", "0026CC18BE14", "1.0")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	sessionId := SessionId(strings.Split(r.Body, "\n")[0])
+	if got, want := s.Store.(*MemoryStore).Sessions[sessionId].MachineId, "00:26:cc:18:be:14"; got != want {
+		t.Errorf("s.Store.(*MemoryStore).Sessions[sessionId].MachineId = %v, want %v", got, want)
+	}
+	if got, want := s.pingSession(sessionId, "00:26:CC:18:BE:14").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	r = s.newSession("testuser@server.org", "DESKTOP-4F2A", "1.0")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Invalid machine id DESKTOP-4F2A, expected one of [mac]\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+}
+
+func TestNewSessionMaxOpenPerMachine(t *testing.T) {
+	s := newWebAPITest(t)
+	// By default a machine can have any number of sessions open.
+	for i := 0; i < 4; i++ {
+		r := s.newSession(fmt.Sprintf("user%d@server.org", i), "00:26:cc:18:be:13", "1.0")
+		if got, want := r.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("r.StatusCode = %v, want %v", got, want)
+		}
+	}
+	n, err := s.Store.CountOpenSessions("00:26:cc:18:be:13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 4; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+
+	const max = 3
+	s.Config.Sessions = &SessionsConfig{MaxOpenPerMachine: max}
+	var ids []SessionId
+	for i := 0; i < max+1; i++ {
+		r := s.newSession(fmt.Sprintf("user%d@server.org", i), "00:26:cc:18:be:14", "1.0")
+		if got, want := r.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("r.StatusCode = %v, want %v", got, want)
+		}
+		ids = append(ids, SessionId(strings.Split(r.Body, "\n")[0]))
+		if i == max {
+			if got, pattern := r.Body, ".*\nClosed 1 previous session\\(s\\) left open on this machine.\n"; !fullMatch(pattern, got) {
+				t.Errorf("r.Body = %q, want a match of %q", got, pattern)
+			}
+		}
+	}
+	sessions := s.Store.(*MemoryStore).Sessions
+	if got, want := sessions[ids[0]].ClosedReason, ClosedOverLimit; got != want {
+		t.Errorf("sessions[ids[0]].ClosedReason = %v, want %v", got, want)
+	}
+	for _, id := range ids[1:] {
+		if got, want := sessions[id].ClosedAt.IsZero(), true; got != want {
+			t.Errorf("sessions[id].ClosedAt.IsZero() = %v, want %v", got, want)
+		}
+	}
+	n, err = s.Store.CountOpenSessions("00:26:cc:18:be:14")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, max; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+
+	s.Config.Sessions.OverMaxOpen = OverMaxOpenReject
+	r := s.newSession("user@server.org", "00:26:cc:18:be:14", "1.0")
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "This machine already has 3 open sessions, close one before starting another\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	s.Config.Sessions.MaxOpenPerMachine = 0
+	r = s.newSession("user@server.org", "00:26:cc:18:be:14", "1.0")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestNewSessionUnknownInstallation(t *testing.T) {
+	s := newWebAPITest(t)
+	const machineId = "00:26:cc:18:be:14"
+	// By default the session starts, an orphan.
+	r := s.newSession("user@server.org", machineId, "1.0")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).Installations), 0; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Installations) = %d, want %d", got, want)
+	}
+
+	s.Config.Sessions = &SessionsConfig{UnknownInstallation: UnknownInstallationReject}
+	r = s.newSession("user@server.org", machineId, "1.0")
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Installation 00:26:cc:18:be:14 is not registered\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	r = s.newInstallation(machineId, "1.0", nil, nil)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	r = s.newSession("user@server.org", machineId, "1.0")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+
+	s.Config.Sessions.UnknownInstallation = UnknownInstallationRegister
+	r = s.newSession("user@server.org", "00:26:cc:18:be:15", "1.1")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	i, err := s.Store.FindInstallation("00:26:cc:18:be:15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := i.Stub, true; got != want {
+		t.Errorf("i.Stub = %v, want %v", got, want)
+	}
+	if got, want := i.XMPPVOXVersion, "1.1"; got != want {
+		t.Errorf("i.XMPPVOXVersion = %v, want %v", got, want)
+	}
+
+	// The machine registering itself replaces its stub, but only once.
+	r = s.newInstallation("00:26:cc:18:be:15", "1.1", map[string]string{"version": "4.0"}, nil)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	i, err = s.Store.FindInstallation("00:26:cc:18:be:15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := i.Stub, false; got != want {
+		t.Errorf("i.Stub = %v, want %v", got, want)
+	}
+	if got, want := i.DosvoxVersion, "4.0"; got != want {
+		t.Errorf("i.DosvoxVersion = %v, want %v", got, want)
+	}
+	r = s.newInstallation("00:26:cc:18:be:15", "1.1", nil, nil)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestOrphanSessions(t *testing.T) {
+	s := newWebAPITest(t)
+	if got, want := s.newInstallation("00:26:cc:18:be:14", "1.0", nil, nil).StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for _, machineId := range []string{"00:26:cc:18:be:14", "00:26:cc:18:be:15", "00:26:cc:18:be:16", "00:26:cc:18:be:16"} {
+		if got, want := s.newSession("user@server.org", machineId, "1.0").StatusCode, http.StatusOK; got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	admin := http.Header{"X-Admin-Token": {testAdminToken}}
+	r := s.handleGet("/admin/1/sessions/orphans", requireAdmin(OrphanSessionsHandler), "/admin/1/sessions/orphans", admin)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	var report struct {
+		Machines []struct {
+			MachineId     string    `json:"machine_id"`
+			Sessions      int       `json:"sessions"`
+			LastSessionAt time.Time `json:"last_session_at"`
+		} `json:"machines"`
+	}
+	if err := json.Unmarshal([]byte(r.Body), &report); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(report.Machines), 2; got != want {
+		t.Fatalf("len(report.Machines) = %d, want %d", got, want)
+	}
+	if got, want := report.Machines[0].MachineId, "00:26:cc:18:be:16"; got != want {
+		t.Errorf("report.Machines[0].MachineId = %v, want %v", got, want)
+	}
+	if got, want := report.Machines[0].Sessions, 2; got != want {
+		t.Errorf("report.Machines[0].Sessions = %v, want %v", got, want)
+	}
+	if got, want := report.Machines[0].LastSessionAt.IsZero(), false; got != want {
+		t.Errorf("report.Machines[0].LastSessionAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := report.Machines[1].MachineId, "00:26:cc:18:be:15"; got != want {
+		t.Errorf("report.Machines[1].MachineId = %v, want %v", got, want)
+	}
+
+	r = s.handleGet("/admin/1/sessions/orphans", requireAdmin(OrphanSessionsHandler), "/admin/1/sessions/orphans?limit=1", admin)
+	if err := json.Unmarshal([]byte(r.Body), &report); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(report.Machines), 1; got != want {
+		t.Errorf("len(report.Machines) = %d, want %d", got, want)
+	}
+	r = s.handleGet("/admin/1/sessions/orphans", requireAdmin(OrphanSessionsHandler), "/admin/1/sessions/orphans?limit=0", admin)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestTailSessions(t *testing.T) {
+	s := newWebAPITest(t)
+	now := time.Now()
+	old := NewSession("old@server.org", "00:26:cc:18:be:13", "1.0", nil)
+	old.CreatedAt = now.Add(-2 * time.Hour)
+	old.ClosedAt, old.ClosedReason = now.Add(-30*time.Second), ClosedExpired
+	recent := NewSession("user@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	recent.CreatedAt = now.Add(-20 * time.Second)
+	for _, x := range []*Session{old, recent} {
+		if err := s.Store.InsertSession(x); err != nil {
+			t.Fatal(err)
+		}
+	}
+	admin := http.Header{"X-Admin-Token": {testAdminToken}}
+	r := s.handleGet("/admin/1/sessions/tail", requireAdmin(TailSessionsHandler), "/admin/1/sessions/tail?since=1m&timeout=10ms", admin)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	lines := strings.Split(strings.TrimSpace(r.Body), "\n")
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("len(lines) = %d, want %d", got, want)
+	}
+	var events []webhookPayload
+	for _, line := range lines {
+		var e webhookPayload
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+	// Oldest first, only what changed since.
+	if got, want := events[0].Event, WebhookSessionClose; got != want {
+		t.Errorf("events[0].Event = %v, want %v", got, want)
+	}
+	if got, want := events[0].Session.Id, old.Id.String(); got != want {
+		t.Errorf("events[0].Session.Id = %v, want %v", got, want)
+	}
+	if got, want := events[0].Session.ClosedReason, ClosedExpired; got != want {
+		t.Errorf("events[0].Session.ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := events[1].Event, WebhookSessionNew; got != want {
+		t.Errorf("events[1].Event = %v, want %v", got, want)
+	}
+	if got, want := events[1].Session.Id, recent.Id.String(); got != want {
+		t.Errorf("events[1].Session.Id = %v, want %v", got, want)
+	}
+
+	// Without since, the tail starts from now.
+	r = s.handleGet("/admin/1/sessions/tail", requireAdmin(TailSessionsHandler), "/admin/1/sessions/tail?timeout=10ms", admin)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, ""; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+
+	r = s.handleGet("/admin/1/sessions/tail", requireAdmin(TailSessionsHandler), "/admin/1/sessions/tail?since=2h&timeout=soon", admin)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Invalid since 2h, expected a time within the last 1h0m0s\nInvalid timeout soon, expected a duration like 5m\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+}
+
+func TestNewSessionExtraFields(t *testing.T) {
+	s := newWebAPITest(t)
+	const (
+		jid               = "testuser@server.org"
+		machineId         = "00:26:cc:18:be:14"
+		xmppvoxVersion    = "1.0"
+		extraInvalidField = "this is invalid"
+	)
+	r := s.handlePost(NewSessionHandler, map[string]string{
+		"jid":                 jid,
+		"machine_id":          machineId,
+		"xmppvox_version":     xmppvoxVersion,
+		"extra_invalid_field": extraInvalidField,
+	})
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestNewSessionClosesSuperseded(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{CloseSuperseded: true}
+	old := SessionId(strings.TrimSpace(s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0").Body))
+	other := SessionId(strings.TrimSpace(s.newSession("other@server.org", "00:26:cc:18:be:14", "1.0").Body))
+	r := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	lines := strings.Split(strings.TrimSpace(r.Body), "\n")
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("len(lines) = %d, want %d", got, want)
+	}
+	if got, want := bson.IsObjectIdHex(lines[0]), true; got != want {
+		t.Errorf("bson.IsObjectIdHex(lines[0]) = %v, want %v", got, want)
+	}
+	if got, pattern := lines[1], "Closed 1 previous session.*"; !fullMatch(pattern, got) {
+		t.Errorf("lines[1] = %q, want a match of %q", got, pattern)
+	}
+	sessions := s.Store.(*MemoryStore).Sessions
+	if got, want := sessions[old].ClosedReason, ClosedSuperseded; got != want {
+		t.Errorf("sessions[old].ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := sessions[other].ClosedAt.IsZero(), true; got != want {
+		t.Errorf("sessions[other].ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := sessions[SessionId(lines[0])].ClosedAt.IsZero(), true; got != want {
+		t.Errorf("sessions[SessionId(lines[0])].ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+}
+
+func TestNewSessionKeepsSupersededByDefault(t *testing.T) {
+	s := newWebAPITest(t)
+	old := SessionId(strings.TrimSpace(s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0").Body))
+	r := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	if got, want := strings.Count(r.Body, "\n"), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.Store.(*MemoryStore).Sessions[old].ClosedAt.IsZero(), true; got != want {
+		t.Errorf("s.Store.(*MemoryStore).Sessions[old].ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+}
+
+// Close Session tests
+
+func TestCloseSession(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.closeSession(id, "00:26:cc:18:be:14")
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := cr.Body, nr.Body; got != want {
+		t.Errorf("cr.Body = %v, want %v", got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[id]
+	if got, want := session.ClosedAt.IsZero(), false; got != want {
+		t.Errorf("session.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+}
+
+func TestCloseSessionExtraFields(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.handlePost(CloseSessionHandler, map[string]string{
+		"session_id":          id.String(),
+		"machine_id":          "00:26:cc:18:be:14",
+		"extra_invalid_field": "this is invalid",
+	})
+	if got, want := cr.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestCloseSessionInexistent(t *testing.T) {
+	s := newWebAPITest(t)
+	r := s.closeSession(NewSessionId(), "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestCloseSessionErrorCodes(t *testing.T) {
+	s := newWebAPITest(t)
+	jsonHeader := http.Header{"Accept": {"application/json"}}
+	var body struct {
+		Errors []APIError
+	}
+	r := s.handlePostWithHeader(CloseSessionHandler, map[string]string{
+		"session_id": "not-a-session",
+	}, jsonHeader)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if err := json.Unmarshal([]byte(r.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Errors, ([]APIError{
+		{"missing_param", "machine_id", "", "Missing POST parameter machine_id"},
+		{"invalid_session_id", "session_id", "", "Invalid session id not-a-session"},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Errors = %v, want %v", got, want)
+	}
+	id := NewSessionId()
+	r = s.handlePostWithHeader(CloseSessionHandler, map[string]string{
+		"session_id": id.String(),
+		"machine_id": "00:26:cc:18:be:14",
+	}, jsonHeader)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if err := json.Unmarshal([]byte(r.Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Errors, ([]APIError{
+		{"session_not_found", "session_id", "", fmt.Sprintf("Session %s does not exist or is already closed", id.String())},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Errors = %v, want %v", got, want)
+	}
+}
+
+func TestCloseSessionAlreadyClosed(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.closeSession(id, "00:26:cc:18:be:14")
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[id]
+	closedAtBefore := session.ClosedAt
+	// Close the same session again
+	cr = s.closeSession(id, "00:26:cc:18:be:14")
+	if got, want := cr.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	// Check session.ClosedAt value
+	session = s.Store.(*MemoryStore).Sessions[id]
+	closedAtAfter := session.ClosedAt
+	if got, want := closedAtAfter, closedAtBefore; got != want {
+		t.Errorf("closedAtAfter = %v, want %v", got, want)
+	}
+}
+
+func TestCannotCloseSomebodyElsesSession(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.closeSession(id, "ANOTHER_MACHINE_ID")
+	if got, want := cr.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+}
+
+// Ping Session tests
+
+func TestPingSession(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.pingSession(id, "00:26:cc:18:be:14")
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := cr.Body, nr.Body; got != want {
+		t.Errorf("cr.Body = %v, want %v", got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[id]
+	if got, want := session.LastPing.IsZero(), false; got != want {
+		t.Errorf("session.LastPing.IsZero() = %v, want %v", got, want)
+	}
+}
+
+func (s *webAPITest) updateSession(sessionId SessionId, machineId, metadata string) *Response {
+	return s.handlePost(UpdateSessionHandler, map[string]string{
+		"session_id": sessionId.String(),
+		"machine_id": machineId,
+		"metadata":   metadata,
+	})
+}
+
+func TestUpdateSession(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	r := s.updateSession(id, "00:26:cc:18:be:14", `{"screen_reader": "NVDA 2014.1", "tts_voice": "Raquel"}`)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, nr.Body; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	r = s.updateSession(id, "00:26:cc:18:be:14", `{"dosvox_program": "cartavox", "tts_voice": ""}`)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[id]
+	if got, want := session.Metadata, (map[string]string{"dosvox_program": "cartavox", "screen_reader": "NVDA 2014.1"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("session.Metadata = %v, want %v", got, want)
+	}
+	if got, want := session.LastPing.IsZero(), false; got != want {
+		t.Errorf("session.LastPing.IsZero() = %v, want %v", got, want)
+	}
+
+	r = s.updateSession(id, "00:26:cc:18:be:14", `{"screen_reader": "JAWS", "email": "user@example.org", "jid": "x"}`)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, `Unknown key "email" of metadata, expected one of dosvox_program, screen_reader, tts_voice`+"\n"+
+		`Unknown key "jid" of metadata, expected one of dosvox_program, screen_reader, tts_voice`+"\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	r = s.updateSession(id, "00:26:cc:18:be:14", `{}`)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Invalid metadata, expected an object with at least one key\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	if got, want := session.Metadata["screen_reader"], "NVDA 2014.1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	s.Config.Sessions = &SessionsConfig{MetadataKeys: []string{"braille_display"}}
+	r = s.updateSession(id, "00:26:cc:18:be:14", `{"braille_display": "Focus 40"}`)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+
+	// Only the open sessions of the machine are updated.
+	r = s.updateSession(id, "00:26:cc:18:be:15", `{"braille_display": "Focus 14"}`)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := s.closeSession(id, "00:26:cc:18:be:14").StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	r = s.updateSession(id, "00:26:cc:18:be:14", `{"braille_display": "Focus 14"}`)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := s.Store.(*MemoryStore).Sessions[id].Metadata["braille_display"], "Focus 40"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPingSessionExtraFields(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.handlePost(PingSessionHandler, map[string]string{
+		"session_id":          id.String(),
+		"machine_id":          "00:26:cc:18:be:14",
+		"extra_invalid_field": "this is invalid",
+	})
+	if got, want := cr.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestPingSessionInexistent(t *testing.T) {
+	s := newWebAPITest(t)
+	r := s.pingSession(NewSessionId(), "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestPingSessionAlreadyClosed(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.closeSession(id, "00:26:cc:18:be:14")
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[id]
+	lastPingBefore := session.LastPing
+	// PING closed session
+	cr = s.pingSession(id, "00:26:cc:18:be:14")
+	if got, want := cr.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	// Check session.LastPing value
+	session = s.Store.(*MemoryStore).Sessions[id]
+	lastPingAfter := session.LastPing
+	if got, want := lastPingAfter, lastPingBefore; got != want {
+		t.Errorf("lastPingAfter = %v, want %v", got, want)
+	}
+}
+
+func TestPingSessionTwice(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	// First PING
+	cr := s.pingSession(id, "00:26:cc:18:be:14")
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[id]
+	lastPingBefore := session.LastPing
+	middleTime := bson.Now()
+	// Second PING
+	cr = s.pingSession(id, "00:26:cc:18:be:14")
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	// Check session.LastPing value
+	session = s.Store.(*MemoryStore).Sessions[id]
+	lastPingAfter := session.LastPing
+	// Check that lastPingBefore <= middleTime <= lastPingAfter
+	if got, want := lastPingBefore.After(middleTime) || middleTime.After(lastPingAfter), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSessionIdFormat(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{IdFormat: SessionIdULID}
+	ulid := SessionId(strings.TrimSpace(s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0").Body))
+	if got, want := ulidPattern.MatchString(ulid.String()), true; got != want {
+		t.Errorf("%s: ulidPattern.MatchString(ulid.String()) = %v, want %v", ulid.String(), got, want)
+	}
+	// Sessions started before a switch of format are still accepted when
+	// they have ObjectIds.
+	legacy := NewSession("other@server.org", "00:26:cc:18:be:15", "1.0", nil)
+	if err := s.Store.InsertSession(legacy); err != nil {
+		t.Fatal(err)
+	}
+	s.Config.Sessions.IdFormat = SessionIdUUID
+	nr := s.newSession("third@server.org", "00:26:cc:18:be:16", "1.0")
+	if got, want := nr.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("nr.StatusCode = %v, want %v", got, want)
+	}
+	id := SessionId(strings.TrimSpace(nr.Body))
+	if got, want := uuidPattern.MatchString(id.String()), true; got != want {
+		t.Errorf("%s: uuidPattern.MatchString(id.String()) = %v, want %v", id.String(), got, want)
+	}
+	if got, want := s.pingSession(id, "00:26:cc:18:be:16").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.closeSession(id, "00:26:cc:18:be:16").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.Store.(*MemoryStore).Sessions[id].ClosedAt.IsZero(), false; got != want {
+		t.Errorf("s.Store.(*MemoryStore).Sessions[id].ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := s.closeSession(legacy.Id, "00:26:cc:18:be:15").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	r := s.pingSession(ulid, "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Invalid session id "+ulid.String()+"\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+}
+
+func TestPingSessionActivity(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.handlePost(PingSessionHandler, map[string]string{
+		"session_id":        id.String(),
+		"machine_id":        "00:26:cc:18:be:14",
+		"messages_sent":     "3",
+		"messages_received": "5",
+		"contacts_online":   "12",
+	})
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	cr = s.handlePost(PingSessionHandler, map[string]string{
+		"session_id":    id.String(),
+		"machine_id":    "00:26:cc:18:be:14",
+		"messages_sent": "2",
+	})
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	a := s.Store.(*MemoryStore).Sessions[id].Activity
+	if a == nil {
+		t.Fatal("a is nil")
+	}
+	if got, want := a.MessagesSent, int64(5); got != want {
+		t.Errorf("a.MessagesSent = %v, want %v", got, want)
+	}
+	if got, want := a.MessagesReceived, int64(5); got != want {
+		t.Errorf("a.MessagesReceived = %v, want %v", got, want)
+	}
+	if a.ContactsOnline == nil {
+		t.Fatal("a.ContactsOnline is nil")
+	}
+	if got, want := *a.ContactsOnline, 12; got != want {
+		t.Errorf("*a.ContactsOnline = %v, want %v", got, want)
+	}
+	cr = s.handlePost(PingSessionHandler, map[string]string{
+		"session_id":      id.String(),
+		"machine_id":      "00:26:cc:18:be:14",
+		"contacts_online": "-1",
+	})
+	if got, want := cr.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := cr.Body, "Invalid contacts_online, expected a number not below 0\n"; got != want {
+		t.Errorf("cr.Body = %v, want %v", got, want)
+	}
+}
+
+func TestPingSessionRTT(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Http = &HttpConfig{RegionHeader: "CF-IPCountry"}
+	nr := s.handlePostWithHeader(NewSessionHandler, map[string]string{
+		"jid":             "testuser@server.org",
+		"machine_id":      "00:26:cc:18:be:14",
+		"xmppvox_version": "1.0",
+	}, http.Header{"Cf-Ipcountry": {"BR"}})
+	id := SessionId(strings.TrimSpace(nr.Body))
+	if got, want := s.Store.(*MemoryStore).Sessions[id].Region, "BR"; got != want {
+		t.Errorf("s.Store.(*MemoryStore).Sessions[id].Region = %v, want %v", got, want)
+	}
+	for _, ms := range []string{"120", "80", "100"} {
+		cr := s.handlePost(PingSessionHandler, map[string]string{
+			"session_id": id.String(),
+			"machine_id": "00:26:cc:18:be:14",
+			"rtt_ms":     ms,
+		})
+		if got, want := cr.StatusCode, http.StatusOK; got != want {
+			t.Errorf("cr.StatusCode = %v, want %v", got, want)
+		}
+	}
+	a := s.Store.(*MemoryStore).Sessions[id].Activity
+	if a == nil {
+		t.Fatal("a is nil")
+	}
+	if a.RTT == nil {
+		t.Fatal("a.RTT is nil")
+	}
+	if got, want := *a.RTT, (RTTSummary{Samples: 3, TotalMs: 300, MinMs: 80, MaxMs: 120, LastMs: 100}); got != want {
+		t.Errorf("*a.RTT = %v, want %v", got, want)
+	}
+	if got, want := a.RTT.MeanMs(), 100.0; got != want {
+		t.Errorf("a.RTT.MeanMs() = %v, want %v", got, want)
+	}
+	cr := s.handlePost(PingSessionHandler, map[string]string{
+		"session_id": id.String(),
+		"machine_id": "00:26:cc:18:be:14",
+		"rtt_ms":     "60001",
+	})
+	if got, want := cr.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := cr.Body, "Invalid rtt_ms, expected at most 60000 milliseconds\n"; got != want {
+		t.Errorf("cr.Body = %v, want %v", got, want)
+	}
+}
+
+func TestPingSessionWithoutActivity(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.pingSession(id, "00:26:cc:18:be:14")
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+	if got := s.Store.(*MemoryStore).Sessions[id].Activity; got != nil {
+		t.Errorf("s.Store.(*MemoryStore).Sessions[id].Activity = %v, want nil", got)
+	}
+}
+
+func TestPingSessionTooOften(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{MinPingInterval: Duration{30 * time.Second}}
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	if got, want := s.pingSession(id, "00:26:cc:18:be:14").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	r := s.pingSession(id, "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusTooManyRequests; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Header.Get("Retry-After"), "30"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Other sessions are limited on their own.
+	nr = s.newSession("testuser@server.org", "00:26:cc:18:be:15", "1.0")
+	other := SessionId(strings.TrimSpace(nr.Body))
+	if got, want := s.pingSession(other, "00:26:cc:18:be:15").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPingSessionTooOftenInCluster(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{MinPingInterval: Duration{30 * time.Second}}
+	s.Config.Cluster = &ClusterConfig{Enabled: true}
+	now := func() time.Time { return time.Date(2014, 3, 1, 12, 0, 10, 0, time.UTC) }
+	pingLimiter.now = now
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	if got, want := s.pingSession(id, "00:26:cc:18:be:14").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Another tracker shares the counters kept in storage.
+	pingLimiter = NewRateLimiter("ping")
+	pingLimiter.now = now
+	r := s.pingSession(id, "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusTooManyRequests; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Header.Get("Retry-After"), "20"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCannotPingSomebodyElsesSession(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.pingSession(id, "ANOTHER_MACHINE_ID")
+	if got, want := cr.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("cr.StatusCode = %v, want %v", got, want)
+	}
+}
+
+// Admin tests
+
+func TestReopenSession(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	s.closeSession(id, "00:26:cc:18:be:14")
+	r := s.reopenSession(id, testAdminToken)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, nr.Body; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[id]
+	if got, want := session.ClosedAt.IsZero(), true; got != want {
+		t.Errorf("session.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := session.ClosedReason, ""; got != want {
+		t.Errorf("session.ClosedReason = %v, want %v", got, want)
+	}
+	// The session can be pinged and closed again
+	if got, want := s.pingSession(id, "00:26:cc:18:be:14").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.closeSession(id, "00:26:cc:18:be:14").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	audit := s.Store.(*MemoryStore).Audit
+	if got, want := len(audit), 1; got != want {
+		t.Fatalf("len(audit) = %d, want %d", got, want)
+	}
+	if got, want := audit[0].Action, "session.reopen"; got != want {
+		t.Errorf("audit[0].Action = %v, want %v", got, want)
+	}
+	if got, want := audit[0].Target, id.String(); got != want {
+		t.Errorf("audit[0].Target = %v, want %v", got, want)
+	}
+	if got, want := audit[0].Details["closed_reason"], ClosedByClient; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Tokens without a name are audited by their role.
+	if got, want := audit[0].Actor, RoleOperator; got != want {
+		t.Errorf("audit[0].Actor = %v, want %v", got, want)
+	}
+}
+
+func TestReopenSessionAuditsTokenName(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Admin.Tokens = append(s.Config.Admin.Tokens, AdminToken{Token: "alice-token", Role: RoleOperator, Name: "alice"})
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	s.closeSession(id, "00:26:cc:18:be:14")
+	if got, want := s.reopenSession(id, "alice-token").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	audit := s.Store.(*MemoryStore).Audit
+	if got, want := len(audit), 1; got != want {
+		t.Fatalf("len(audit) = %d, want %d", got, want)
+	}
+	if got, want := audit[0].Actor, "alice"; got != want {
+		t.Errorf("audit[0].Actor = %v, want %v", got, want)
+	}
+}
+
+func TestReopenSessionMaxOpenPerMachine(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{MaxOpenPerMachine: 1}
+	id := SessionId(strings.TrimSpace(s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0").Body))
+	s.closeSession(id, "00:26:cc:18:be:14")
+	other := SessionId(strings.TrimSpace(s.newSession("other@server.org", "00:26:cc:18:be:14", "1.0").Body))
+	if got, want := s.reopenSession(id, testAdminToken).StatusCode, http.StatusOK; got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	sessions := s.Store.(*MemoryStore).Sessions
+	if got, want := sessions[id].ClosedAt.IsZero(), true; got != want {
+		t.Errorf("sessions[id].ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := sessions[other].ClosedReason, ClosedOverLimit; got != want {
+		t.Errorf("sessions[other].ClosedReason = %v, want %v", got, want)
+	}
+}
+
+func TestReopenSessionOpen(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	r := s.reopenSession(id, testAdminToken)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).Audit), 0; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Audit) = %d, want %d", got, want)
+	}
+}
+
+func TestReopenSessionOutsideWindow(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	s.closeSession(id, "00:26:cc:18:be:14")
+	session := s.Store.(*MemoryStore).Sessions[id]
+	session.ClosedAt = session.ClosedAt.Add(-2 * time.Hour)
+	s.Config.Admin.ReopenWindow = Duration{time.Hour}
+	r := s.reopenSession(id, testAdminToken)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := session.ClosedAt.IsZero(), false; got != want {
+		t.Errorf("session.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+}
+
+func (s *webAPITest) resumeSession(jid, machineId string) *Response {
+	return s.handlePost(ResumeSessionHandler, map[string]string{
+		"jid":        jid,
+		"machine_id": machineId,
+	})
+}
+
+func TestResumeSession(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	// Open sessions are not resumed.
+	if got, want := s.resumeSession("testuser@server.org", "00:26:cc:18:be:14").StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// A crash report closes the session it names.
+	cr := s.handlePost(NewCrashHandler, map[string]string{
+		"machine_id":      "00:26:cc:18:be:14",
+		"xmppvox_version": "1.0",
+		"session_id":      id.String(),
+		"traceback":       fmt.Sprintf(testTraceback, 10, 0xdeadbeef),
+	})
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("cr.StatusCode = %v, want %v", got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[id]
+	if got, want := session.ClosedReason, ClosedCrash; got != want {
+		t.Errorf("session.ClosedReason = %v, want %v", got, want)
+	}
+
+	r := s.resumeSession("testuser@server.org", "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, nr.Body; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	if got, want := r.Header.Get("X-Session-Alias"), nr.Header.Get("X-Session-Alias"); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := session.ClosedAt.IsZero(), true; got != want {
+		t.Errorf("session.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := session.ClosedReason, ""; got != want {
+		t.Errorf("session.ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := s.pingSession(id, "00:26:cc:18:be:14").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Sessions closed by the client are not resumed.
+	s.closeSession(id, "00:26:cc:18:be:14")
+	if got, want := s.resumeSession("testuser@server.org", "00:26:cc:18:be:14").StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestResumeSessionMaxOpenPerMachine(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{MaxOpenPerMachine: 1}
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	cr := s.handlePost(NewCrashHandler, map[string]string{
+		"machine_id":      "00:26:cc:18:be:14",
+		"xmppvox_version": "1.0",
+		"session_id":      id.String(),
+		"traceback":       fmt.Sprintf(testTraceback, 10, 0xdeadbeef),
+	})
+	if got, want := cr.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("cr.StatusCode = %v, want %v", got, want)
+	}
+	other := SessionId(strings.TrimSpace(s.newSession("other@server.org", "00:26:cc:18:be:14", "1.0").Body))
+
+	s.Config.Sessions.OverMaxOpen = OverMaxOpenReject
+	if got, want := s.resumeSession("testuser@server.org", "00:26:cc:18:be:14").StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	s.Config.Sessions.OverMaxOpen = OverMaxOpenCloseOldest
+	r := s.resumeSession("testuser@server.org", "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, id.String()+"\nClosed 1 previous session(s) left open on this machine.\n"; got != want {
+		t.Errorf("r.Body = %q, want %q", got, want)
+	}
+	if got, want := s.Store.(*MemoryStore).Sessions[other].ClosedReason, ClosedOverLimit; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestResumeSessionOutsideWindow(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{ResumeWindow: Duration{time.Minute}}
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	session := s.Store.(*MemoryStore).Sessions[id]
+	session.CreatedAt = session.CreatedAt.Add(-time.Hour)
+	session.ClosedAt, session.ClosedReason = time.Now().Add(-2*time.Minute), ClosedExpired
+	r := s.resumeSession("testuser@server.org", "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, pattern := r.Body, "No session of .* in the last 1m0s\n"; !fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want a match of %q", got, pattern)
+	}
+	// Only the latest session of the jid on the machine is resumed.
+	session.ClosedAt = time.Now()
+	s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	if got, want := s.resumeSession("testuser@server.org", "00:26:cc:18:be:14").StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func (s *webAPITest) transferSession(sessionId SessionId, machineId, newMachineId string) *Response {
+	return s.handlePost(TransferSessionHandler, map[string]string{
+		"session_id":     sessionId.String(),
+		"machine_id":     machineId,
+		"new_machine_id": newMachineId,
+	})
+}
+
+func TestTransferSession(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	// Only the machine holding the session can transfer it.
+	r := s.transferSession(id, "00:26:cc:18:be:15", "00:26:cc:18:be:16")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, fmt.Sprintf("Session %s does not exist or is already closed\n", id.String()); got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	r = s.transferSession(id, "00:26:cc:18:be:14", "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Invalid new_machine_id, expected another machine than machine_id\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+
+	r = s.transferSession(id, "00:26:cc:18:be:14", "00:26:cc:18:be:15")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, id.String()+"\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	session := s.Store.(*MemoryStore).Sessions[id]
+	if got, want := session.MachineId, "00:26:cc:18:be:15"; got != want {
+		t.Errorf("session.MachineId = %v, want %v", got, want)
+	}
+	if got, want := len(session.Transfers), 1; got != want {
+		t.Fatalf("len(session.Transfers) = %d, want %d", got, want)
+	}
+	if got, want := session.Transfers[0].From, "00:26:cc:18:be:14"; got != want {
+		t.Errorf("session.Transfers[0].From = %v, want %v", got, want)
+	}
+	if got, want := session.Transfers[0].To, "00:26:cc:18:be:15"; got != want {
+		t.Errorf("session.Transfers[0].To = %v, want %v", got, want)
+	}
+	if got, want := s.pingSession(id, "00:26:cc:18:be:14").StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.pingSession(id, "00:26:cc:18:be:15").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Pseudonymized exports hash the machines of transfers like machine_id.
+	s.Config.Admin.Tokens = append(s.Config.Admin.Tokens, AdminToken{Token: "researcher-token", Role: RoleResearcher})
+	s.Config.Export = &ExportConfig{Profiles: map[string]string{RoleResearcher: ProfilePseudonymized}, Salt: "pepper"}
+	_, docs := s.export("sessions", "researcher-token")
+	if got, want := len(docs), 1; got != want {
+		t.Fatalf("len(docs) = %d, want %d", got, want)
+	}
+	transfers := docs[0]["transfers"].([]interface{})
+	if got, want := len(transfers), 1; got != want {
+		t.Fatalf("len(transfers) = %d, want %d", got, want)
+	}
+	if got, want := transfers[0].(map[string]interface{})["to"], docs[0]["machine_id"]; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := transfers[0].(map[string]interface{})["from"], "00:26:cc:18:be:14"; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+
+	// The new machine is checked like that of a new session.
+	s.Config.Sessions = &SessionsConfig{MaxOpenPerMachine: 1, OverMaxOpen: OverMaxOpenReject}
+	other := s.newSession("testuser@server.org", "00:26:cc:18:be:16", "1.0")
+	r = s.transferSession(id, "00:26:cc:18:be:15", "00:26:cc:18:be:16")
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "This machine already has 1 open sessions, close one before starting another\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	s.closeSession(SessionId(strings.TrimSpace(other.Body)), "00:26:cc:18:be:16")
+	if got, want := s.transferSession(id, "00:26:cc:18:be:15", "00:26:cc:18:be:16").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(session.Transfers), 2; got != want {
+		t.Errorf("len(session.Transfers) = %d, want %d", got, want)
+	}
+
+	// Closed sessions stay where they were closed.
+	s.closeSession(id, "00:26:cc:18:be:16")
+	if got, want := s.transferSession(id, "00:26:cc:18:be:16", "00:26:cc:18:be:14").StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReopenSessionForbidden(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	s.closeSession(id, "00:26:cc:18:be:14")
+	s.Config.Admin.Tokens = append(s.Config.Admin.Tokens, AdminToken{Token: "researcher-token", Role: RoleResearcher})
+	for _, token := range []string{"", "wrong-token", "researcher-token"} {
+		r := s.reopenSession(id, token)
+		if got, want := r.StatusCode, http.StatusForbidden; got != want {
+			t.Errorf("r.StatusCode = %v, want %v", got, want)
+		}
+	}
+	session := s.Store.(*MemoryStore).Sessions[id]
+	if got, want := session.ClosedAt.IsZero(), false; got != want {
+		t.Errorf("session.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+}
+
+// API key tests
+
+func (s *webAPITest) sessionWithKey(key string) *Response {
+	header := http.Header{}
+	if key != "" {
+		header.Set("X-API-Key", key)
+	}
+	return s.handlePostWithHeader(requireAPIKey(NewSessionHandler), map[string]string{
+		"jid":             "testuser@server.org",
+		"machine_id":      "00:26:cc:18:be:14",
+		"xmppvox_version": "1.0",
+	}, header)
+}
+
+func TestAPIKeyModes(t *testing.T) {
+	s := newWebAPITest(t)
+	if got, want := s.sessionWithKey("").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	s.Config.APIKeys = &APIKeysConfig{Mode: APIKeysGrace, Keys: []ConfigAPIKey{{Key: "partner-key", Name: "partner"}}}
+	r := s.sessionWithKey("")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, pattern := r.Header.Get("Warning"), `299 .*X-API-Key.*`; !fullMatch(pattern, got) {
+		t.Errorf("got %q, want a match of %q", got, pattern)
+	}
+	if got, want := s.sessionWithKey("wrong-key").StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.sessionWithKey("partner-key").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	s.Config.APIKeys.Mode = APIKeysRequired
+	if got, want := s.sessionWithKey("").StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.sessionWithKey("partner-key").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).Sessions), 4; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Sessions) = %d, want %d", got, want)
+	}
+}
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.APIKeys = &APIKeysConfig{Mode: APIKeysRequired, MaxPerMinute: 2,
+		Keys: []ConfigAPIKey{{Key: "partner-key", Name: "partner"}, {Key: "big-key", Name: "big", MaxPerMinute: 3}}}
+	for i := 0; i < 2; i++ {
+		if got, want := s.sessionWithKey("partner-key").StatusCode, http.StatusOK; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	r := s.sessionWithKey("partner-key")
+	if got, want := r.StatusCode, http.StatusTooManyRequests; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Header.Get("Retry-After"), ""; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+	for i := 0; i < 3; i++ {
+		if got, want := s.sessionWithKey("big-key").StatusCode, http.StatusOK; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if got, want := s.sessionWithKey("big-key").StatusCode, http.StatusTooManyRequests; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// The limiter counts by key name, not by the secret.
+	if _, ok := apiKeyLimiter.windows["/partner"]; !ok {
+		t.Errorf("no rate window for /partner in %v", apiKeyLimiter.windows)
+	}
+	if _, ok := apiKeyLimiter.windows["partner-key"]; ok {
+		t.Error("rate window keyed by the API key")
+	}
+}
+
+func TestNewSessionLeavesOutCredentials(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.APIKeys = &APIKeysConfig{Mode: APIKeysRequired, Keys: []ConfigAPIKey{{Key: "partner-key", Name: "partner"}}}
+	header := http.Header{"X-Api-Key": {"partner-key"}, "User-Agent": {"XMPPVOX/1.0"}}
+	r := s.handlePostWithHeader(requireAPIKey(NewSessionHandler), map[string]string{
+		"jid":             "testuser@server.org",
+		"machine_id":      "00:26:cc:18:be:14",
+		"xmppvox_version": "1.0",
+	}, header)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	var session *Session
+	for _, x := range s.Store.(*MemoryStore).Sessions {
+		session = x
+	}
+	if got, want := session.Request.Header, (http.Header{"User-Agent": {"XMPPVOX/1.0"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("session.Request.Header = %v, want %v", got, want)
+	}
+	if got, want := session.Request.Form.Get("jid"), "testuser@server.org"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestStoredAPIKeyRevocation(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.APIKeys = &APIKeysConfig{Mode: APIKeysRequired}
+	admin := http.Header{"X-Admin-Token": {testAdminToken}}
+	r := s.handlePostWithHeader(requireAdmin(NewAPIKeyHandler), map[string]string{"name": "partner"}, admin)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	key := strings.TrimSpace(r.Body)
+	if got, want := s.sessionWithKey(key).StatusCode, http.StatusOK; got != want {
+		t.Errorf("s.sessionWithKey(key).StatusCode = %v, want %v", got, want)
+	}
+
+	r = s.handleGet("/admin/1/api_keys", requireAdmin(APIKeysHandler), "/admin/1/api_keys", admin)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := strings.Contains(r.Body, key), false; got != want {
+		t.Errorf("strings.Contains(r.Body, key) = %v, want %v", got, want)
+	}
+	var report struct {
+		Stored []*APIKey
+	}
+	if err := json.Unmarshal([]byte(r.Body), &report); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(report.Stored), 1; got != want {
+		t.Fatalf("len(report.Stored) = %d, want %d", got, want)
+	}
+	if got, want := report.Stored[0].Name, "partner"; got != want {
+		t.Errorf("report.Stored[0].Name = %v, want %v", got, want)
+	}
+
+	revoke := map[string]string{"api_key_id": report.Stored[0].Id.Hex()}
+	r = s.handlePostWithHeader(requireAdmin(RevokeAPIKeyHandler), revoke, admin)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := s.sessionWithKey(key).StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("s.sessionWithKey(key).StatusCode = %v, want %v", got, want)
+	}
+	r = s.handlePostWithHeader(requireAdmin(RevokeAPIKeyHandler), revoke, admin)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).Audit), 2; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Audit) = %d, want %d", got, want)
+	}
+}
+
+func TestApps(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Apps = &AppsConfig{List: []*AppConfig{{Id: "letravox"}}}
+	s.Config.APIKeys = &APIKeysConfig{Mode: APIKeysGrace,
+		Keys: []ConfigAPIKey{{Key: "letravox-key", Name: "letravox", App: "letravox"}}}
+	if got, want := s.sessionWithKey("").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for i := 0; i < 2; i++ {
+		if got, want := s.sessionWithKey("letravox-key").StatusCode, http.StatusOK; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if got, want := len(s.Store.(*MemoryStore).Sessions), 1; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Sessions) = %d, want %d", got, want)
+	}
+	app := s.Store.(*MemoryStore).app("letravox")
+	if got, want := len(app.Sessions), 2; got != want {
+		t.Fatalf("len(app.Sessions) = %d, want %d", got, want)
+	}
+	for _, session := range app.Sessions {
+		if got, want := session.App, "letravox"; got != want {
+			t.Errorf("session.App = %v, want %v", got, want)
+		}
+	}
+
+	get := func(url string) *Response {
+		return s.handleGet("/admin/1/machines/{machine_id}/sessions", requireAdmin(MachineSessionsHandler),
+			url, http.Header{"X-Admin-Token": {testAdminToken}})
+	}
+	for query, n := range map[string]int{"": 1, "?app=xmppvox": 1, "?app=letravox": 2} {
+		r := get("/admin/1/machines/00:26:cc:18:be:14/sessions" + query)
+		if got, want := r.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("%s: r.StatusCode = %v, want %v", query, got, want)
+		}
+		var history []*historySession
+		if err := json.Unmarshal([]byte(r.Body), &history); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(history), n; got != want {
+			t.Errorf("%s: len(history) = %d, want %d", query, got, want)
+		}
+	}
+	r := get("/admin/1/machines/00:26:cc:18:be:14/sessions?app=cartavox")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Unknown app cartavox\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+}
+
+// Signing tests
+
+func (s *webAPITest) signedPost(h contextualHandlerFunc, call, secret string, id SessionId, machineId string) *Response {
+	return s.signedPostAt(h, call, secret, id, machineId, time.Now())
+}
+
+// signedPostAt posts a request signed with the timestamp of at.
+func (s *webAPITest) signedPostAt(h contextualHandlerFunc, call, secret string, id SessionId, machineId string, at time.Time) *Response {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	params := url.Values{"session_id": {id.String()}, "machine_id": {machineId}, "timestamp": {timestamp}}
+	return s.handlePost(h, map[string]string{
+		"session_id": id.String(),
+		"machine_id": machineId,
+		"timestamp":  timestamp,
+		"signature":  requestSignature(secret, call, params),
+	})
+}
+
+func TestSessionSigningOptional(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{Signing: SigningOptional}
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	if got, want := nr.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("nr.StatusCode = %v, want %v", got, want)
+	}
+	secret := nr.Header.Get("X-Session-Secret")
+	if got, want := secret, ""; got == want {
+		t.Fatalf("secret = %v, want anything but %v", got, want)
+	}
+	if got, want := strings.Contains(nr.Body, secret), false; got != want {
+		t.Errorf("strings.Contains(nr.Body, secret) = %v, want %v", got, want)
+	}
+	id := SessionId(strings.TrimSpace(nr.Body))
+
+	if got, want := s.pingSession(id, "00:26:cc:18:be:14").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	r := s.signedPost(PingSessionHandler, "/session/ping", "wrong-secret", id, "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	r = s.signedPost(PingSessionHandler, "/session/close", secret, id, "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	r = s.signedPost(PingSessionHandler, "/session/ping", secret, id, "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	r = s.signedPost(CloseSessionHandler, "/session/close", secret, id, "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestSessionSigningRequired(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{Signing: SigningRequired}
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	secret := nr.Header.Get("X-Session-Secret")
+	id := SessionId(strings.TrimSpace(nr.Body))
+
+	r := s.handlePostWithHeader(CloseSessionHandler, map[string]string{
+		"session_id": id.String(),
+		"machine_id": "00:26:cc:18:be:14",
+	}, http.Header{"Accept": {"application/json"}})
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, pattern := r.Body, `(?s).*"code":"missing_signature".*`; !fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want a match of %q", got, pattern)
+	}
+	if got, want := s.Store.(*MemoryStore).Sessions[id].ClosedAt.IsZero(), true; got != want {
+		t.Errorf("s.Store.(*MemoryStore).Sessions[id].ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	r = s.signedPost(CloseSessionHandler, "/session/close", secret, id, "00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := s.Store.(*MemoryStore).Sessions[id].ClosedAt.IsZero(), false; got != want {
+		t.Errorf("s.Store.(*MemoryStore).Sessions[id].ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+}
+
+func TestSessionSigningTimestamp(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{Signing: SigningOptional}
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	secret := nr.Header.Get("X-Session-Secret")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	accept := http.Header{"Accept": {"application/json"}}
+
+	params := url.Values{"session_id": {id.String()}, "machine_id": {"00:26:cc:18:be:14"}}
+	r := s.handlePostWithHeader(PingSessionHandler, map[string]string{
+		"session_id": id.String(),
+		"machine_id": "00:26:cc:18:be:14",
+		"signature":  requestSignature(secret, "/session/ping", params),
+	}, accept)
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, pattern := r.Body, `(?s).*"code":"missing_timestamp".*`; !fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want a match of %q", got, pattern)
+	}
+
+	// A signed timestamp cannot be changed.
+	params.Set("timestamp", strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	r = s.handlePost(PingSessionHandler, map[string]string{
+		"session_id": id.String(),
+		"machine_id": "00:26:cc:18:be:14",
+		"timestamp":  strconv.FormatInt(time.Now().Unix(), 10),
+		"signature":  requestSignature(secret, "/session/ping", params),
+	})
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Invalid signature\n"; got != want {
+		t.Errorf("r.Body = %q, want %q", got, want)
+	}
+
+	for _, d := range []time.Duration{-maxSignatureAge - time.Minute, maxSignatureAge + time.Minute} {
+		r = s.signedPostAt(PingSessionHandler, "/session/ping", secret, id, "00:26:cc:18:be:14", time.Now().Add(d))
+		if got, want := r.StatusCode, http.StatusForbidden; got != want {
+			t.Errorf("%s: r.StatusCode = %v, want %v", d, got, want)
+		}
+		if got, pattern := r.Body, "Stale signature, .*\n"; !fullMatch(pattern, got) {
+			t.Errorf("%s: r.Body = %q, want a match of %q", d, got, pattern)
+		}
+	}
+	r = s.signedPostAt(PingSessionHandler, "/session/ping", secret, id, "00:26:cc:18:be:14", time.Now().Add(-time.Minute))
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestSessionPlainModeIssuesNoSecret(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	if got, want := nr.Header.Get("X-Session-Secret"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// Support claim tests
+
+func TestClaimInstallation(t *testing.T) {
+	s := newWebAPITest(t)
+	s.newInstallation("00:26:cc:18:be:14", "1.0", nil, nil)
+	admin := http.Header{"X-Admin-Token": {testAdminToken}}
+	r := s.handlePostWithHeader(requireAdmin(NewClaimHandler), map[string]string{"ticket": "HELP-42"}, admin)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	code := strings.TrimSpace(r.Body)
+	if got, pattern := code, `[0-9A-Z]{4}-[0-9A-Z]{4}`; !fullMatch(pattern, got) {
+		t.Errorf("code = %q, want a match of %q", got, pattern)
+	}
+
+	claim := func(machineId, code string) *Response {
+		return s.handlePost(ClaimInstallationHandler, map[string]string{"machine_id": machineId, "code": code})
+	}
+	if got, want := claim("unknown-machine", code).StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := claim("00:26:cc:18:be:14", "not a code").StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	r = claim("00:26:cc:18:be:14", strings.ToLower(code))
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, pattern := r.Body, "HELP-42\n.*\n"; !fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want a match of %q", got, pattern)
+	}
+	if got, want := r.Header.Get("X-Debug-Until"), ""; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+	i := s.Store.(*MemoryStore).Installations["00:26:cc:18:be:14"]
+	if got, want := i.Ticket, "HELP-42"; got != want {
+		t.Errorf("i.Ticket = %v, want %v", got, want)
+	}
+	if got, want := i.DebugUntil.After(time.Now().Add(71*time.Hour)), true; got != want {
+		t.Errorf("i.DebugUntil.After(time.Now().Add(71*time.Hour)) = %v, want %v", got, want)
+	}
+	// Codes are claimed once.
+	if got, want := claim("00:26:cc:18:be:14", code).StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Pseudonymized exports leave the ticket out.
+	s.Config.Admin.Tokens = append(s.Config.Admin.Tokens, AdminToken{Token: "researcher-token", Role: RoleResearcher})
+	s.Config.Export = &ExportConfig{Profiles: map[string]string{RoleResearcher: ProfilePseudonymized}, Salt: "pepper"}
+	_, docs := s.export("installations", "researcher-token")
+	if got, want := len(docs), 1; got != want {
+		t.Fatalf("len(docs) = %d, want %d", got, want)
+	}
+	_, ok := docs[0]["ticket"]
+	if got, want := ok, false; got != want {
+		t.Errorf("ok = %v, want %v", got, want)
+	}
+
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	if got, want := nr.Header.Get("X-Debug-Until"), r.Header.Get("X-Debug-Until"); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	nr = s.newSession("testuser@server.org", "other-machine", "1.0")
+	if got, want := nr.Header.Get("X-Debug-Until"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestClaimCodeExpires(t *testing.T) {
+	s := newWebAPITest(t)
+	s.newInstallation("00:26:cc:18:be:14", "1.0", nil, nil)
+	claim := NewClaim(newSessionAlias(), "HELP-42", -time.Minute)
+	if err := s.Store.InsertClaim(claim); err != nil {
+		t.Fatal(err)
+	}
+	r := s.handlePost(ClaimInstallationHandler, map[string]string{"machine_id": "00:26:cc:18:be:14", "code": claim.Code})
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := s.Store.(*MemoryStore).Installations["00:26:cc:18:be:14"].Ticket, ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// Storage stats tests
+
+func TestStorageStats(t *testing.T) {
+	s := newWebAPITest(t)
+	ms := s.Store.(*MemoryStore)
+	for i := 0; i < 3; i++ {
+		s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	}
+	old := &StorageSnapshot{bson.NewObjectId(), bson.Now().Add(-8 * 24 * time.Hour),
+		[]*CollectionStats{{Name: "sessions", Count: 1, DataBytes: 100}}}
+	if err := ms.InsertStorageSnapshot(old); err != nil {
+		t.Fatal(err)
+	}
+	r := s.handleGet("/admin/storage", requireAdmin(StorageStatsHandler), "/admin/storage",
+		http.Header{"X-Admin-Token": {testAdminToken}})
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	var report struct {
+		Collections []struct {
+			Name        string
+			Count       int64
+			AvgDocBytes int64 `json:"avg_doc_bytes"`
+			Trend       map[string]*collectionTrend
+		}
+	}
+	if err := json.Unmarshal([]byte(r.Body), &report); err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, cr := range report.Collections {
+		if cr.Name != "sessions" {
+			continue
+		}
+		found = true
+		if got, want := cr.Count, int64(3); got != want {
+			t.Errorf("cr.Count = %v, want %v", got, want)
+		}
+		if got, want := cr.AvgDocBytes > 0, true; got != want {
+			t.Errorf("cr.AvgDocBytes > 0 = %v, want %v", got, want)
+		}
+		if cr.Trend["7d"] == nil {
+			t.Fatal("got nil")
+		}
+		if got, want := cr.Trend["7d"].Count, int64(2); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := cr.Trend["1d"].Count, int64(2); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got := cr.Trend["30d"]; got != nil {
+			t.Errorf("got %v, want nil", got)
+		}
+	}
+	if got, want := found, true; got != want {
+		t.Errorf("found = %v, want %v", got, want)
+	}
+}
+
+// Webhook tests
+
+func TestWebhookDeliveries(t *testing.T) {
+	s := newWebAPITest(t)
+	var (
+		mu        sync.Mutex
+		delivered = make(map[string][]string)
+	)
+	defer func(f func(*Webhook, []byte) error) { postWebhook = f }(postWebhook)
+	postWebhook = func(h *Webhook, body []byte) error {
+		var p struct{ Event string }
+		json.Unmarshal(body, &p)
+		mu.Lock()
+		delivered[h.URL] = append(delivered[h.URL], p.Event)
+		mu.Unlock()
+		return nil
+	}
+	admin := http.Header{"X-Admin-Token": {testAdminToken}}
+	for _, params := range []map[string]string{
+		{"url": "https://partner.org/hooks", "jid_domains": "Partner.org"},
+		{"url": "https://other.org/hooks", "jid_domains": "other.org"},
+		{"url": "https://closes.org/hooks", "events": "session.close", "min_version": "1.10"},
+	} {
+		r := s.handlePostWithHeader(requireAdmin(NewWebhookHandler), params, admin)
+		if got, want := r.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("r.StatusCode = %v, want %v", got, want)
+		}
+	}
+	r := s.handlePostWithHeader(requireAdmin(NewWebhookHandler), map[string]string{
+		"url": "ftp://partner.org", "events": "session.ping",
+	}, admin)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+
+	nr := s.newSession("user@partner.org/XMPPVOX", "00:26:cc:18:be:14", "1.10")
+	if got, want := nr.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("nr.StatusCode = %v, want %v", got, want)
+	}
+	// Deliveries are made in the background, in no particular order.
+	processDeliveries.inFlight.Wait()
+	id := SessionId(strings.SplitN(nr.Body, "\n", 2)[0])
+	if got, want := s.closeSession(id, "00:26:cc:18:be:14").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	processDeliveries.inFlight.Wait()
+	if got, want := delivered, (map[string][]string{
+		"https://partner.org/hooks": {WebhookSessionNew, WebhookSessionClose},
+		"https://closes.org/hooks":  {WebhookSessionClose},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered = %v, want %v", got, want)
+	}
+}
+
+func TestWebhooksOfClosesByTracker(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Sessions = &SessionsConfig{CloseSuperseded: true, MaxOpenPerMachine: 1}
+	s.Config.Reaper = &ReaperConfig{ExpireAfter: Duration{10 * time.Minute}}
+	var (
+		mu     sync.Mutex
+		closes = make(map[string]string)
+	)
+	defer func(f func(*Webhook, []byte) error) { postWebhook = f }(postWebhook)
+	postWebhook = func(h *Webhook, body []byte) error {
+		var p webhookPayload
+		json.Unmarshal(body, &p)
+		mu.Lock()
+		closes[p.Session.Id] = p.Session.ClosedReason
+		mu.Unlock()
+		return nil
+	}
+	admin := http.Header{"X-Admin-Token": {testAdminToken}}
+	r := s.handlePostWithHeader(requireAdmin(NewWebhookHandler), map[string]string{
+		"url": "https://closes.org/hooks", "events": "session.close",
+	}, admin)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+
+	var ids []SessionId
+	for _, jid := range []string{"user@server.org/XMPPVOX", "user@server.org/XMPPVOX", "other@server.org/XMPPVOX"} {
+		nr := s.newSession(jid, "00:26:cc:18:be:14", "1.0")
+		if got, want := nr.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("nr.StatusCode = %v, want %v", got, want)
+		}
+		ids = append(ids, SessionId(strings.SplitN(nr.Body, "\n", 2)[0]))
+	}
+	s.Store.(*MemoryStore).Sessions[ids[2]].CreatedAt = time.Now().Add(-time.Hour)
+	if _, err := reaperJob.Run(&Context{Store: s.Store, Config: s.Config}); err != nil {
+		t.Fatal(err)
+	}
+	processDeliveries.inFlight.Wait()
+	if got, want := closes, (map[string]string{
+		ids[0].String(): ClosedSuperseded,
+		ids[1].String(): ClosedOverLimit,
+		ids[2].String(): ClosedExpired,
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("closes = %v, want %v", got, want)
+	}
+}
+
+// Export tests
+
+func (s *webAPITest) export(collection, token string) (*Response, []map[string]interface{}) {
+	r := s.handleGet("/admin/1/export/{collection}", requireToken(ExportHandler),
+		"/admin/1/export/"+collection, http.Header{"X-Admin-Token": {token}})
+	var docs []map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(r.Body))
+	for {
+		var doc map[string]interface{}
+		if err := dec.Decode(&doc); err != nil {
+			break
+		}
+		docs = append(docs, doc)
+	}
+	return r, docs
+}
+
+func TestExportProfiles(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Admin.Tokens = append(s.Config.Admin.Tokens,
+		AdminToken{Token: "researcher-token", Role: RoleResearcher}, AdminToken{Token: "guest-token", Role: "guest"})
+	s.Config.Export = &ExportConfig{
+		Profiles: map[string]string{RoleResearcher: ProfilePseudonymized},
+		Salt:     "pepper",
+	}
+	s.newSession("testuser@server.org/XMPPVOX", "00:26:cc:18:be:14", "1.0")
+	s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	s.newSession("other@server.org", "00:26:cc:18:be:15", "1.1")
+
+	r, docs := s.export("sessions", testAdminToken)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := len(docs), 3; got != want {
+		t.Fatalf("len(docs) = %d, want %d", got, want)
+	}
+	if got, want := docs[0]["jid"], "testuser@server.org"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if docs[0]["req"] == nil {
+		t.Error("got nil")
+	}
+	if got, want := docs[0]["resource"], "XMPPVOX"; got != want {
+		t.Errorf("docs[0][\"resource\"] = %v, want %v", got, want)
+	}
+
+	r, docs = s.export("sessions", "researcher-token")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := len(docs), 3; got != want {
+		t.Fatalf("len(docs) = %d, want %d", got, want)
+	}
+	if got, want := docs[0]["jid"], "testuser@server.org"; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+	if got, want := docs[0]["jid"], docs[1]["jid"]; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := docs[0]["jid"], docs[2]["jid"]; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+	if got, want := docs[0]["machine_id"], "00:26:cc:18:be:14"; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+	if got, want := docs[0]["xmppvox_ver"], "1.0"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	_, ok := docs[0]["req"]
+	if got, want := ok, false; got != want {
+		t.Errorf("ok = %v, want %v", got, want)
+	}
+	_, ok = docs[0]["resource"]
+	if got, want := ok, false; got != want {
+		t.Errorf("ok = %v, want %v", got, want)
+	}
+
+	r, docs = s.export("sessions", "guest-token")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := len(docs), 2; got != want {
+		t.Fatalf("len(docs) = %d, want %d", got, want)
+	}
+	if got, want := docs[0]["xmppvox_ver"], "1.0"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := docs[0]["count"], 2.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := docs[1]["xmppvox_ver"], "1.1"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := docs[1]["count"], 1.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	_, ok = docs[0]["jid"]
+	if got, want := ok, false; got != want {
+		t.Errorf("ok = %v, want %v", got, want)
+	}
+}
+
+func TestExportResume(t *testing.T) {
+	s := newWebAPITest(t)
+	for i := 0; i < 5; i++ {
+		s.newSession(fmt.Sprintf("user%d@server.org", i), "00:26:cc:18:be:14", "1.0")
+	}
+	_, all := s.export("sessions", testAdminToken)
+	if got, want := len(all), 5; got != want {
+		t.Fatalf("len(all) = %d, want %d", got, want)
+	}
+
+	r := s.handleGet("/admin/1/export/{collection}", requireToken(ExportHandler), "/admin/1/export/sessions",
+		http.Header{"X-Admin-Token": {testAdminToken}, "Range": {"documents=3-"}})
+	if got, want := r.StatusCode, http.StatusPartialContent; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Header.Get("Content-Range"), "documents 3-"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	lines := strings.Split(strings.TrimSpace(r.Body), "\n")
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("len(lines) = %d, want %d", got, want)
+	}
+	if got, pattern := lines[0], `.*"jid":"`+all[3]["jid"].(string)+`".*`; !fullMatch(pattern, got) {
+		t.Errorf("lines[0] = %q, want a match of %q", got, pattern)
+	}
+
+	r = s.handleGet("/admin/1/export/{collection}", requireToken(ExportHandler), "/admin/1/export/sessions",
+		http.Header{"X-Admin-Token": {testAdminToken}, "Range": {"bytes=0-100"}})
+	if got, want := r.StatusCode, http.StatusRequestedRangeNotSatisfiable; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestExportWindow(t *testing.T) {
+	s := newWebAPITest(t)
+	for i := 0; i < 3; i++ {
+		s.newSession(fmt.Sprintf("user%d@server.org", i), "00:26:cc:18:be:14", "1.0")
+	}
+	for _, session := range s.Store.(*MemoryStore).Sessions {
+		session.CreatedAt = time.Date(2014, 5, 1+int(session.JID[4]-'0'), 10, 0, 0, 0, time.UTC)
+	}
+	r := s.handleGet("/admin/1/export/{collection}", requireToken(ExportHandler),
+		"/admin/1/export/sessions?from=2014-05-02&to=2014-05-03", http.Header{"X-Admin-Token": {testAdminToken}})
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	lines := strings.Split(strings.TrimSpace(r.Body), "\n")
+	if got, want := len(lines), 1; got != want {
+		t.Fatalf("len(lines) = %d, want %d", got, want)
+	}
+	if got, pattern := lines[0], `.*"jid":"user1@server.org".*`; !fullMatch(pattern, got) {
+		t.Errorf("lines[0] = %q, want a match of %q", got, pattern)
+	}
+
+	r = s.handleGet("/admin/1/export/{collection}", requireToken(ExportHandler),
+		"/admin/1/export/sessions?range=sometime", http.Header{"X-Admin-Token": {testAdminToken}})
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestExportPseudonymizedRequiresSalt(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Export = &ExportConfig{Profiles: map[string]string{RoleOperator: ProfilePseudonymized}}
+	s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	r, docs := s.export("sessions", testAdminToken)
+	if got, want := r.StatusCode, http.StatusInternalServerError; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := len(docs), 0; got != want {
+		t.Errorf("len(docs) = %d, want %d", got, want)
+	}
+}
+
+func TestExportForbiddenAndUnknown(t *testing.T) {
+	s := newWebAPITest(t)
+	r, _ := s.export("sessions", "wrong-token")
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	r, _ = s.export("audit", testAdminToken)
+	if got, want := r.StatusCode, http.StatusNotFound; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+// Session alias tests
+
+func TestSessionAlias(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	id := SessionId(strings.TrimSpace(nr.Body))
+	alias := nr.Header.Get("X-Session-Alias")
+	if got, pattern := alias, "[0-9A-Z]{4}-[0-9A-Z]{4}"; !fullMatch(pattern, got) {
+		t.Errorf("alias = %q, want a match of %q", got, pattern)
+	}
+	if got, want := s.Store.(*MemoryStore).Sessions[id].Alias, strings.Replace(alias, "-", "", 1); got != want {
+		t.Errorf("s.Store.(*MemoryStore).Sessions[id].Alias = %v, want %v", got, want)
+	}
+	// As typed by support staff
+	typed := strings.Replace(strings.Replace(strings.ToLower(alias), "-", " ", 1), "0", "o", -1)
+	r := s.handleGet("/admin/1/sessions/alias/{alias}", requireAdmin(SessionByAliasHandler),
+		"/admin/1/sessions/alias/"+strings.Replace(typed, " ", "%20", 1), http.Header{"X-Admin-Token": {testAdminToken}})
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	var session Session
+	if err := json.Unmarshal([]byte(r.Body), &session); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := session.Id, id; got != want {
+		t.Errorf("session.Id = %v, want %v", got, want)
+	}
+}
+
+func TestSessionAliasNotFound(t *testing.T) {
+	s := newWebAPITest(t)
+	for alias, status := range map[string]int{
+		"ZZZZ-ZZZZ": http.StatusNotFound,
+		"ZZZZ-ZZZ":  http.StatusBadRequest,
+		"ZZZZ-ZZZU": http.StatusBadRequest,
+	} {
+		r := s.handleGet("/admin/1/sessions/alias/{alias}", requireAdmin(SessionByAliasHandler),
+			"/admin/1/sessions/alias/"+alias, http.Header{"X-Admin-Token": {testAdminToken}})
+		if got, want := r.StatusCode, status; got != want {
+			t.Errorf("%s: r.StatusCode = %v, want %v", alias, got, want)
+		}
+	}
+}
+
+func TestMachineSessions(t *testing.T) {
+	s := newWebAPITest(t)
+	start := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		session := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+		session.CreatedAt = start.Add(time.Duration(i) * time.Hour)
+		session.ClosedAt = session.CreatedAt.Add(10 * time.Minute)
+		if err := s.Store.InsertSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Store.InsertSession(NewSession("other@server.org", "ANOTHER_MACHINE_ID", "1.0", nil)); err != nil {
+		t.Fatal(err)
+	}
+	get := func(url string) *Response {
+		return s.handleGet("/admin/1/machines/{machine_id}/sessions", requireAdmin(MachineSessionsHandler),
+			url, http.Header{"X-Admin-Token": {testAdminToken}})
+	}
+	r := get("/admin/1/machines/00:26:cc:18:be:14/sessions?limit=2")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	var history []*historySession
+	if err := json.Unmarshal([]byte(r.Body), &history); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(history), 2; got != want {
+		t.Fatalf("len(history) = %d, want %d", got, want)
+	}
+	if got, want := history[0].CreatedAt.Equal(start.Add(2*time.Hour)), true; got != want {
+		t.Errorf("history[0].CreatedAt.Equal(start.Add(2*time.Hour)) = %v, want %v", got, want)
+	}
+	if got, want := history[0].JID, "testuser@server.org"; got != want {
+		t.Errorf("history[0].JID = %v, want %v", got, want)
+	}
+	if got, want := history[0].Duration, int64(600); got != want {
+		t.Errorf("history[0].Duration = %v, want %v", got, want)
+	}
+	if got, want := r.Header["Link"], ([]string{`</admin/1/machines/00:26:cc:18:be:14/sessions?limit=2&page=2>; rel="next"`}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	r = get("/admin/1/machines/00:26:cc:18:be:14/sessions?limit=2&page=2")
+	if err := json.Unmarshal([]byte(r.Body), &history); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(history), 1; got != want {
+		t.Fatalf("len(history) = %d, want %d", got, want)
+	}
+	if got, want := history[0].CreatedAt.Equal(start), true; got != want {
+		t.Errorf("history[0].CreatedAt.Equal(start) = %v, want %v", got, want)
+	}
+	if got, want := r.Header["Link"], ([]string{`</admin/1/machines/00:26:cc:18:be:14/sessions?limit=2&page=1>; rel="prev"`}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	r = get("/admin/1/machines/UNKNOWN/sessions")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "[]\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	r = get("/admin/1/machines/00:26:cc:18:be:14/sessions?limit=0")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	r = s.handleGet("/admin/1/machines/{machine_id}/sessions", requireAdmin(MachineSessionsHandler),
+		"/admin/1/machines/00:26:cc:18:be:14/sessions", nil)
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	s := newWebAPITest(t)
+	start := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, machineId := range []string{"machine-a", "machine-b", "machine-a"} {
+		session := NewSession("testuser@server.org", machineId, "1.0", nil)
+		session.CreatedAt = start.AddDate(0, 0, i)
+		if err := s.Store.InsertSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Store.InsertSession(NewSession("other@server.org", "machine-a", "1.0", nil)); err != nil {
+		t.Fatal(err)
+	}
+	get := func(url string) []*historySession {
+		r := s.handleGet("/admin/1/users/{jid}/sessions", requireAdmin(UserSessionsHandler),
+			url, http.Header{"X-Admin-Token": {testAdminToken}})
+		if got, want := r.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("%s: r.StatusCode = %v, want %v", r.Body, got, want)
+		}
+		var history []*historySession
+		if err := json.Unmarshal([]byte(r.Body), &history); err != nil {
+			t.Fatal(err)
+		}
+		return history
+	}
+	history := get("/admin/1/users/testuser@server.org/sessions")
+	if got, want := len(history), 3; got != want {
+		t.Fatalf("len(history) = %d, want %d", got, want)
+	}
+	if got, want := history[1].MachineId, "machine-b"; got != want {
+		t.Errorf("history[1].MachineId = %v, want %v", got, want)
+	}
+	history = get("/admin/1/users/testuser@server.org/sessions?from=2014-05-02&to=2014-05-03")
+	if got, want := len(history), 1; got != want {
+		t.Fatalf("len(history) = %d, want %d", got, want)
+	}
+	if got, want := history[0].MachineId, "machine-b"; got != want {
+		t.Errorf("history[0].MachineId = %v, want %v", got, want)
+	}
+	history = get("/admin/1/users/testuser@server.org/sessions?from=2014-05-02&limit=1&page=2")
+	if got, want := len(history), 1; got != want {
+		t.Fatalf("len(history) = %d, want %d", got, want)
+	}
+	if got, want := history[0].CreatedAt.Equal(start.AddDate(0, 0, 1)), true; got != want {
+		t.Errorf("history[0].CreatedAt.Equal(start.AddDate(0, 0, 1)) = %v, want %v", got, want)
+	}
+
+	r := s.handleGet("/admin/1/users/{jid}/sessions", requireAdmin(UserSessionsHandler),
+		"/admin/1/users/testuser@server.org/sessions?from=yesterday", http.Header{"X-Admin-Token": {testAdminToken}})
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	r = s.handleGet("/admin/1/users/{jid}/sessions", requireAdmin(UserSessionsHandler),
+		"/admin/1/users/testuser@server.org/sessions", nil)
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	s := newWebAPITest(t)
+	if err := s.Store.InsertInstallation(&Installation{MachineId: "00:26:cc:18:be:14", XMPPVOXVersion: "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store.InsertInstallation(&Installation{MachineId: "ANOTHER_MACHINE_ID", XMPPVOXVersion: "1.0"}); err != nil {
+		t.Fatal(err)
+	}
+	tagged := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	if err := s.Store.InsertSession(tagged); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store.InsertSession(NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)); err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"X-Admin-Token": {testAdminToken}}
+	post := func(h contextualHandlerFunc, data map[string]string) *Response {
+		return s.handlePostWithHeader(requireAdmin(h), data, header)
+	}
+	for _, data := range []map[string]string{
+		{"session_id": tagged.Id.String(), "tag": "beta-tester"},
+		{"session_id": tagged.Id.String(), "tag": "beta-tester"},
+		{"session_id": tagged.Id.String(), "tag": "reported-bug-42"},
+		{"machine_id": "00:26:cc:18:be:14", "tag": "beta-tester", "comment": "joined the beta"},
+	} {
+		r := post(TagHandler, data)
+		if got, want := r.StatusCode, http.StatusOK; got != want {
+			t.Errorf("%v: %s: r.StatusCode = %v, want %v", data, r.Body, got, want)
+		}
+	}
+	r := post(UntagHandler, map[string]string{"session_id": tagged.Id.String(), "tag": "reported-bug-42"})
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	r = post(NewNoteHandler, map[string]string{"session_id": tagged.Id.String(), "text": "Drops every hour"})
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	r = post(NewNoteHandler, map[string]string{"session_id": tagged.Id.String(), "text": strings.Repeat("a", maxParamBytes+1)})
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+
+	r = s.handleGet("/admin/1/machines/{machine_id}/sessions", requireAdmin(MachineSessionsHandler),
+		"/admin/1/machines/00:26:cc:18:be:14/sessions?tag=beta-tester", header)
+	var history []*historySession
+	if err := json.Unmarshal([]byte(r.Body), &history); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(history), 1; got != want {
+		t.Fatalf("len(history) = %d, want %d", got, want)
+	}
+	if got, want := history[0].Id, tagged.Id.String(); got != want {
+		t.Errorf("history[0].Id = %v, want %v", got, want)
+	}
+	if got, want := history[0].Tags, ([]string{"beta-tester"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("history[0].Tags = %v, want %v", got, want)
+	}
+	if got, want := len(history[0].Notes), 1; got != want {
+		t.Fatalf("len(history[0].Notes) = %d, want %d", got, want)
+	}
+	if got, want := history[0].Notes[0].Text, "Drops every hour"; got != want {
+		t.Errorf("history[0].Notes[0].Text = %v, want %v", got, want)
+	}
+	r = s.handleGet("/admin/1/users/{jid}/sessions", requireAdmin(UserSessionsHandler),
+		"/admin/1/users/testuser@server.org/sessions?tag=reported-bug-42", header)
+	if got, want := r.Body, "[]\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+
+	r = s.handleGet("/admin/1/installations", requireAdmin(InstallationsHandler),
+		"/admin/1/installations?tag=beta-tester", header)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	var installations []*listedInstallation
+	if err := json.Unmarshal([]byte(r.Body), &installations); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(installations), 1; got != want {
+		t.Fatalf("len(installations) = %d, want %d", got, want)
+	}
+	if got, want := installations[0].MachineId, "00:26:cc:18:be:14"; got != want {
+		t.Errorf("installations[0].MachineId = %v, want %v", got, want)
+	}
+	r = s.handleGet("/admin/1/installations", requireAdmin(InstallationsHandler), "/admin/1/installations", header)
+	if err := json.Unmarshal([]byte(r.Body), &installations); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(installations), 2; got != want {
+		t.Errorf("len(installations) = %d, want %d", got, want)
+	}
+
+	audit := s.Store.(*MemoryStore).Audit
+	if got, want := len(audit), 6; got != want {
+		t.Fatalf("len(audit) = %d, want %d", got, want)
+	}
+	if got, want := audit[3].Action, "installation.tag"; got != want {
+		t.Errorf("audit[3].Action = %v, want %v", got, want)
+	}
+	if got, want := audit[3].Comment, "joined the beta"; got != want {
+		t.Errorf("audit[3].Comment = %v, want %v", got, want)
+	}
+	if got, want := audit[5].Action, "session.note"; got != want {
+		t.Errorf("audit[5].Action = %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		data map[string]string
+		code string
+	}{
+		{map[string]string{"tag": "beta-tester"}, "missing_param"},
+		{map[string]string{"session_id": tagged.Id.String(), "machine_id": "00:26:cc:18:be:14", "tag": "x"}, "unexpected_param"},
+		{map[string]string{"session_id": "xyz", "tag": "x"}, "invalid_session_id"},
+		{map[string]string{"session_id": tagged.Id.String(), "tag": "Beta Tester"}, "invalid_tag"},
+		{map[string]string{"session_id": NewSessionId().String(), "tag": "x"}, "session_not_found"},
+		{map[string]string{"machine_id": "UNKNOWN", "tag": "x"}, "installation_not_found"},
+	} {
+		r := s.handlePostWithHeader(requireAdmin(TagHandler), tc.data, http.Header{
+			"X-Admin-Token": {testAdminToken}, "Accept": {"application/json"}})
+		if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+			t.Errorf("%v: r.StatusCode = %v, want %v", tc.data, got, want)
+		}
+		if got, pattern := r.Body, `(?s).*"code":"`+tc.code+`".*`; !fullMatch(pattern, got) {
+			t.Errorf("%v: r.Body = %q, want a match of %q", tc.data, got, pattern)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := newWebAPITest(t)
+	start := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, jid := range []string{"testuser@server.org", "other@server.org", "testuser@example.org"} {
+		session := NewSession(jid, "00:26:cc:18:be:14", "1.0", nil)
+		session.CreatedAt = start.AddDate(0, 0, i)
+		if i > 0 {
+			session.ClosedAt = session.CreatedAt.Add(time.Hour)
+			session.ClosedReason = ClosedExpired
+		}
+		if err := s.Store.InsertSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	search := func(q string) *Response {
+		return s.handleGet("/admin/1/search", requireAdmin(SearchHandler),
+			"/admin/1/search?q="+url.QueryEscape(q), http.Header{"X-Admin-Token": {testAdminToken}})
+	}
+	for q, jids := range map[string][]string{
+		"jid:*@server.org":                                {"other@server.org", "testuser@server.org"},
+		"jid:testuser@* status:closed":                    {"testuser@example.org"},
+		"closed_reason:expired created:..2014-05-03":      {"other@server.org"},
+		"machine_id:00:26:cc:18:be:14 created:2014-05-01": {"testuser@server.org"},
+		"tag:beta-tester":                                 {},
+	} {
+		r := search(q)
+		if got, want := r.StatusCode, http.StatusOK; got != want {
+			t.Fatalf("%s: %s: r.StatusCode = %v, want %v", q, r.Body, got, want)
+		}
+		var history []*historySession
+		if err := json.Unmarshal([]byte(r.Body), &history); err != nil {
+			t.Fatal(err)
+		}
+		found := []string{}
+		for _, h := range history {
+			found = append(found, h.JID)
+		}
+		if got, want := found, jids; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: found = %v, want %v", q, got, want)
+		}
+	}
+	r := search("req.remote_addr:127.0.0.1")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	r = s.handleGet("/admin/1/search", requireAdmin(SearchHandler), "/admin/1/search?q=jid:*", nil)
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+// Erasure tests
+
+func (s *webAPITest) erasureFixture(t *testing.T) (*Session, *Session) {
+	if err := s.Store.InsertInstallation(&Installation{
+		MachineId:      "00:26:cc:18:be:14",
+		XMPPVOXVersion: "1.0",
+		DosvoxInfo:     map[string]string{"email": "testuser@example.org"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	mine := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", &HttpRequest{RemoteAddr: "10.0.0.1:1234"})
+	mine.Resource = "XMPPVOX"
+	other := NewSession("other@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	for _, x := range []*Session{mine, other} {
+		if err := s.Store.InsertSession(x); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Store.InsertEvent(&Event{Id: bson.NewObjectId(), MachineId: x.MachineId, SessionId: x.Id,
+			Name: "feature", Properties: bson.M{"contact": "friend@server.org"}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Store.RecordCrash("sig", "traceback", &Crash{MachineId: x.MachineId, SessionId: x.Id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Store.InsertFeedback(&Feedback{Id: bson.NewObjectId(), MachineId: mine.MachineId, SessionId: mine.Id,
+		Rating: 2, Text: "My name is Test User"}); err != nil {
+		t.Fatal(err)
+	}
+	return mine, other
+}
+
+func (s *webAPITest) erase(pattern, url string) (*Response, *erasureReport) {
+	h := EraseUserHandler
+	if strings.Contains(pattern, "machine_id") {
+		h = EraseMachineHandler
+	}
+	r := s.handleRequest("DELETE", pattern, requireAdmin(h), url, http.Header{"X-Admin-Token": {testAdminToken}})
+	var rep erasureReport
+	json.Unmarshal([]byte(r.Body), &rep)
+	return r, &rep
+}
+
+func TestEraseUser(t *testing.T) {
+	s := newWebAPITest(t)
+	mine, other := s.erasureFixture(t)
+	store := s.Store.(*MemoryStore)
+	r, rep := s.erase("/admin/1/users/{jid}", "/admin/1/users/testuser@server.org?comment=ticket+42")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("%s: r.StatusCode = %v, want %v", r.Body, got, want)
+	}
+	if got, want := rep.Mode, ErasureAnonymize; got != want {
+		t.Errorf("rep.Mode = %v, want %v", got, want)
+	}
+	if got, want := *rep.Erasure, (Erasure{Sessions: 1, Events: 1, Feedback: 1, CrashGroups: 1}); got != want {
+		t.Errorf("*rep.Erasure = %v, want %v", got, want)
+	}
+	if got, want := store.Sessions[mine.Id].JID, ""; got != want {
+		t.Errorf("store.Sessions[mine.Id].JID = %v, want %v", got, want)
+	}
+	if got := store.Sessions[mine.Id].Request; got != nil {
+		t.Errorf("store.Sessions[mine.Id].Request = %v, want nil", got)
+	}
+	if got, want := store.Sessions[mine.Id].Resource, ""; got != want {
+		t.Errorf("store.Sessions[mine.Id].Resource = %v, want %v", got, want)
+	}
+	if got, want := store.Sessions[mine.Id].CreatedAt, mine.CreatedAt; got != want {
+		t.Errorf("store.Sessions[mine.Id].CreatedAt = %v, want %v", got, want)
+	}
+	if got, want := store.Sessions[other.Id].JID, "other@server.org"; got != want {
+		t.Errorf("store.Sessions[other.Id].JID = %v, want %v", got, want)
+	}
+	if got := store.Events[0].Properties; got != nil {
+		t.Errorf("store.Events[0].Properties = %v, want nil", got)
+	}
+	if store.Events[1].Properties == nil {
+		t.Error("store.Events[1].Properties is nil")
+	}
+	if got, want := store.FeedbackList[0].Text, ""; got != want {
+		t.Errorf("store.FeedbackList[0].Text = %v, want %v", got, want)
+	}
+	if got, want := store.FeedbackList[0].Rating, 2; got != want {
+		t.Errorf("store.FeedbackList[0].Rating = %v, want %v", got, want)
+	}
+	if got, want := len(store.Crashes["sig"].Recent), 1; got != want {
+		t.Fatalf("got length %d, want %d", got, want)
+	}
+	if got, want := store.Crashes["sig"].Recent[0].SessionId, other.Id; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := store.Crashes["sig"].Count, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	a := store.Audit[len(store.Audit)-1]
+	if got, want := a.Action, "user.erase"; got != want {
+		t.Errorf("a.Action = %v, want %v", got, want)
+	}
+	if got, want := a.Target, "testuser@server.org"; got != want {
+		t.Errorf("a.Target = %v, want %v", got, want)
+	}
+	if got, want := a.Comment, "ticket 42"; got != want {
+		t.Errorf("a.Comment = %v, want %v", got, want)
+	}
+
+	r, rep = s.erase("/admin/1/users/{jid}", "/admin/1/users/other@server.org?mode=erase")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := rep.Mode, ErasureErase; got != want {
+		t.Errorf("rep.Mode = %v, want %v", got, want)
+	}
+	if got, want := len(store.Sessions), 1; got != want {
+		t.Errorf("len(store.Sessions) = %d, want %d", got, want)
+	}
+	if got, want := len(store.Events), 1; got != want {
+		t.Errorf("len(store.Events) = %d, want %d", got, want)
+	}
+	if got, want := len(store.Crashes["sig"].Recent), 0; got != want {
+		t.Errorf("got length %d, want %d", got, want)
+	}
+
+	r, _ = s.erase("/admin/1/users/{jid}", "/admin/1/users/other@server.org?mode=shred")
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestEraseMachine(t *testing.T) {
+	s := newWebAPITest(t)
+	mine, other := s.erasureFixture(t)
+	store := s.Store.(*MemoryStore)
+	r, rep := s.erase("/admin/1/machines/{machine_id}", "/admin/1/machines/00:26:cc:18:be:14")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("%s: r.StatusCode = %v, want %v", r.Body, got, want)
+	}
+	if got, want := *rep.Erasure, (Erasure{Installations: 1, Sessions: 2, Events: 2, Feedback: 1, CrashGroups: 1}); got != want {
+		t.Errorf("*rep.Erasure = %v, want %v", got, want)
+	}
+	if got, pattern := rep.Pseudonym, "erased-[0-9a-f]{24}"; !fullMatch(pattern, got) {
+		t.Errorf("rep.Pseudonym = %q, want a match of %q", got, pattern)
+	}
+	if got := store.Installations["00:26:cc:18:be:14"]; got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+	i := store.Installations[rep.Pseudonym]
+	if i == nil {
+		t.Fatal("i is nil")
+	}
+	if got, want := i.XMPPVOXVersion, "1.0"; got != want {
+		t.Errorf("i.XMPPVOXVersion = %v, want %v", got, want)
+	}
+	if got := i.DosvoxInfo; got != nil {
+		t.Errorf("i.DosvoxInfo = %v, want nil", got)
+	}
+	if got, want := store.Sessions[mine.Id].MachineId, rep.Pseudonym; got != want {
+		t.Errorf("store.Sessions[mine.Id].MachineId = %v, want %v", got, want)
+	}
+	if got, want := store.Sessions[other.Id].MachineId, rep.Pseudonym; got != want {
+		t.Errorf("store.Sessions[other.Id].MachineId = %v, want %v", got, want)
+	}
+	if got, want := store.Events[0].MachineId, rep.Pseudonym; got != want {
+		t.Errorf("store.Events[0].MachineId = %v, want %v", got, want)
+	}
+	if got, want := store.FeedbackList[0].MachineId, rep.Pseudonym; got != want {
+		t.Errorf("store.FeedbackList[0].MachineId = %v, want %v", got, want)
+	}
+	if got, want := len(store.Crashes["sig"].Recent), 0; got != want {
+		t.Errorf("got length %d, want %d", got, want)
+	}
+
+	r, rep = s.erase("/admin/1/machines/{machine_id}", "/admin/1/machines/"+rep.Pseudonym+"?mode=erase")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := *rep.Erasure, (Erasure{Installations: 1, Sessions: 2, Events: 2, Feedback: 1}); got != want {
+		t.Errorf("*rep.Erasure = %v, want %v", got, want)
+	}
+	if got, want := len(store.Installations), 0; got != want {
+		t.Errorf("len(store.Installations) = %d, want %d", got, want)
+	}
+	if got, want := len(store.FeedbackList), 0; got != want {
+		t.Errorf("len(store.FeedbackList) = %d, want %d", got, want)
+	}
+	if got, want := len(store.Sessions), 0; got != want {
+		t.Errorf("len(store.Sessions) = %d, want %d", got, want)
+	}
+	if got, want := len(store.Events), 0; got != want {
+		t.Errorf("len(store.Events) = %d, want %d", got, want)
+	}
+	if got, want := store.Audit[len(store.Audit)-1].Action, "machine.erase"; got != want {
+		t.Errorf("store.Audit[len(store.Audit)-1].Action = %v, want %v", got, want)
+	}
+}
+
+func TestSessionAliasCollision(t *testing.T) {
+	s := newWebAPITest(t)
+	nr := s.newSession("testuser@server.org", "00:26:cc:18:be:14", "1.0")
+	first := s.Store.(*MemoryStore).Sessions[SessionId(strings.TrimSpace(nr.Body))]
+	session := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	session.Alias = first.Alias
+	if got, want := s.Store.InsertSession(session), errDup; got != want {
+		t.Errorf("s.Store.InsertSession(session) = %v, want %v", got, want)
+	}
+}
+
+// Crash report tests
+
+const testTraceback = `Traceback (most recent call last):
+  File "C:\winvox\xmppvox\xmppvox.py", line %d, in main
+    client.run()
+  File "C:\winvox\xmppvox\client.py", line 88, in run
+ValueError: <object at 0x%x>`
+
+func (s *webAPITest) newCrash(version, traceback string) *Response {
+	return s.handlePost(NewCrashHandler, map[string]string{
+		"machine_id":      "00:26:cc:18:be:14",
+		"xmppvox_version": version,
+		"traceback":       traceback,
+		"context":         `{"screen_reader": "NVDA", "uptime": 42}`,
+	})
+}
+
+func TestNewCrashGroupsBySignature(t *testing.T) {
+	s := newWebAPITest(t)
+	r1 := s.newCrash("1.0", fmt.Sprintf(testTraceback, 10, 0xdeadbeef))
+	if got, want := r1.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r1.StatusCode = %v, want %v", got, want)
+	}
+	// Same crash in another version, at another address
+	r2 := s.newCrash("1.1", fmt.Sprintf(testTraceback, 12, 0xcafe))
+	if got, want := r2.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r2.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r2.Body, r1.Body; got != want {
+		t.Errorf("r2.Body = %v, want %v", got, want)
+	}
+	r3 := s.newCrash("1.1", "Traceback (most recent call last):\nKeyError: 'jid'")
+	if got, want := r3.Body, r1.Body; got == want {
+		t.Errorf("r3.Body = %v, want anything but %v", got, want)
+	}
+
+	r := s.handleGet("/admin/1/crashes", requireAdmin(CrashesHandler), "/admin/1/crashes",
+		http.Header{"X-Admin-Token": {testAdminToken}})
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	var groups []*CrashGroup
+	if err := json.Unmarshal([]byte(r.Body), &groups); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(groups), 2; got != want {
+		t.Fatalf("len(groups) = %d, want %d", got, want)
+	}
+	g := groups[0]
+	if g.Signature != strings.TrimSpace(r1.Body) {
+		g = groups[1]
+	}
+	if got, want := g.Count, 2; got != want {
+		t.Errorf("g.Count = %v, want %v", got, want)
+	}
+	if got, want := g.Versions, ([]string{"1.0", "1.1"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("g.Versions = %v, want %v", got, want)
+	}
+	if got, want := g.Traceback, fmt.Sprintf(testTraceback, 10, 0xdeadbeef); got != want {
+		t.Errorf("g.Traceback = %v, want %v", got, want)
+	}
+	if got, want := len(g.Recent), 2; got != want {
+		t.Fatalf("len(g.Recent) = %d, want %d", got, want)
+	}
+	if got, want := g.Recent[0].Context["screen_reader"], "NVDA"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNewCrashInvalid(t *testing.T) {
+	s := newWebAPITest(t)
+	r := s.handlePost(NewCrashHandler, map[string]string{
+		"machine_id":      "00:26:cc:18:be:14",
+		"xmppvox_version": "1.0",
+		"session_id":      "not-a-session",
+		"traceback":       strings.Repeat("x", maxTracebackBytes+1),
+		"context":         "[]",
+	})
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := strings.Count(r.Body, "\n"), 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).Crashes), 0; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Crashes) = %d, want %d", got, want)
+	}
+}
+
+// Event tests
+
+func (s *webAPITest) newEvent(sessionId SessionId, event, properties string) *Response {
+	return s.handlePost(NewEventHandler, map[string]string{
+		"machine_id": "00:26:cc:18:be:14",
+		"session_id": sessionId.String(),
+		"event":      event,
+		"properties": properties,
+	})
+}
+
+func TestNewEvent(t *testing.T) {
+	s := newWebAPITest(t)
+	sessionId := NewSessionId()
+	r := s.newEvent(sessionId, "speech.command", `{"command": "read_contacts"}`)
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	events := s.Store.(*MemoryStore).Events
+	if got, want := len(events), 1; got != want {
+		t.Fatalf("len(events) = %d, want %d", got, want)
+	}
+	if got, want := events[0].Id.Hex(), strings.TrimSpace(r.Body); got != want {
+		t.Errorf("events[0].Id.Hex() = %v, want %v", got, want)
+	}
+	if got, want := events[0].SessionId, sessionId; got != want {
+		t.Errorf("events[0].SessionId = %v, want %v", got, want)
+	}
+	if got, want := events[0].Name, "speech.command"; got != want {
+		t.Errorf("events[0].Name = %v, want %v", got, want)
+	}
+	if got, want := events[0].Properties["command"], "read_contacts"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestNewEventRateCap(t *testing.T) {
+	s := newWebAPITest(t)
+	s.Config.Events = &EventsConfig{MaxPerMinute: 2}
+	sessionId := NewSessionId()
+	if got, want := s.newEvent(sessionId, "a", "").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.newEvent(sessionId, "b", "").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	r := s.newEvent(sessionId, "c", "")
+	if got, want := r.StatusCode, http.StatusTooManyRequests; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Header.Get("Retry-After"), ""; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+	// Other sessions are not affected
+	if got, want := s.newEvent(NewSessionId(), "a", "").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).Events), 3; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Events) = %d, want %d", got, want)
+	}
+}
+
+func TestNewEventInvalid(t *testing.T) {
+	s := newWebAPITest(t)
+	for _, tc := range [][2]string{
+		{"Speech Command", ""},
+		{"speech", "[1, 2]"},
+		{"speech", strings.Repeat(" ", maxEventPropertiesBytes+1)},
+	} {
+		r := s.newEvent(NewSessionId(), tc[0], tc[1])
+		if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+			t.Errorf("%q: r.StatusCode = %v, want %v", tc, got, want)
+		}
+	}
+	if got, want := len(s.Store.(*MemoryStore).Events), 0; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).Events) = %d, want %d", got, want)
+	}
+}
+
+func (s *webAPITest) newBlock(field, value, message string) *Response {
+	return s.handlePostWithHeader(requireAdmin(NewBlockHandler), map[string]string{
+		"field":   field,
+		"value":   value,
+		"message": message,
+	}, http.Header{"X-Admin-Token": {testAdminToken}})
+}
+
+func TestBlockDeniesNewSessions(t *testing.T) {
+	s := newWebAPITest(t)
+	r := s.newBlock(BlockXMPPVOXVersion, "0.9", "Please upgrade XMPPVOX")
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	blockId := strings.TrimSpace(r.Body)
+
+	r = s.newSession("user@example.com", "machine-a", "0.9")
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Please upgrade XMPPVOX\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	if got, want := s.newSession("user@example.com", "machine-a", "1.0").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	r = s.handlePostWithHeader(requireAdmin(RemoveBlockHandler), map[string]string{"block_id": blockId},
+		http.Header{"X-Admin-Token": {testAdminToken}})
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := s.newSession("user@example.com", "machine-a", "0.9").StatusCode, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	audit := s.Store.(*MemoryStore).Audit
+	if got, want := len(audit), 2; got != want {
+		t.Fatalf("len(audit) = %d, want %d", got, want)
+	}
+	if got, want := audit[0].Action, "block.add"; got != want {
+		t.Errorf("audit[0].Action = %v, want %v", got, want)
+	}
+	if got, want := audit[1].Action, "block.remove"; got != want {
+		t.Errorf("audit[1].Action = %v, want %v", got, want)
+	}
+	if got, want := audit[1].Target, blockId; got != want {
+		t.Errorf("audit[1].Target = %v, want %v", got, want)
+	}
+}
+
+func TestNewBlockInvalid(t *testing.T) {
+	s := newWebAPITest(t)
+	if got, want := s.newBlock("ip", "127.0.0.1", "Go away").StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.newBlock(BlockJID, "user@example.com", "").StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	r := s.handlePostWithHeader(requireAdmin(NewBlockHandler), map[string]string{
+		"field":    BlockMachineId,
+		"value":    "machine-a",
+		"message":  "Vá embora",
+		"messages": `{"en": " ", "pt-br": "Vá embora", "español": "Váyase"}`,
+	}, http.Header{"X-Admin-Token": {testAdminToken}})
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Empty message in en\n"+
+		"Invalid language español, expected a language tag like pt-BR\n"+
+		"Invalid language pt-br, the message param is in pt-BR\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).BlockList), 0; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).BlockList) = %d, want %d", got, want)
+	}
+}
+
+func TestBlockMessageLanguages(t *testing.T) {
+	s := newWebAPITest(t)
+	r := s.handlePostWithHeader(requireAdmin(NewBlockHandler), map[string]string{
+		"field":    BlockXMPPVOXVersion,
+		"value":    "0.9",
+		"message":  "Atualize o XMPPVOX",
+		"messages": `{"en": "Please upgrade XMPPVOX", "es": "Actualice XMPPVOX"}`,
+	}, http.Header{"X-Admin-Token": {testAdminToken}})
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("%s: r.StatusCode = %v, want %v", r.Body, got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).BlockList[0].Messages), 2; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).BlockList[0].Messages) = %d, want %d", got, want)
+	}
+	session := map[string]string{"jid": "user@example.com", "machine_id": "machine-a", "xmppvox_version": "0.9"}
+	for _, tc := range []struct{ lang, acceptLanguage, message, contentLanguage string }{
+		{"", "", "Atualize o XMPPVOX", "pt-BR"},
+		{"", "fr, en-US;q=0.8, es;q=0.9", "Actualice XMPPVOX", "es"},
+		{"", "pt-PT, en;q=0.5", "Atualize o XMPPVOX", "pt-BR"},
+		{"", "fr, de;q=0.5", "Atualize o XMPPVOX", "pt-BR"},
+		{"EN", "es", "Please upgrade XMPPVOX", "en"},
+	} {
+		session["lang"] = tc.lang
+		r := s.handlePostWithHeader(NewSessionHandler, session, http.Header{"Accept-Language": {tc.acceptLanguage}})
+		if got, want := r.StatusCode, http.StatusForbidden; got != want {
+			t.Errorf("r.StatusCode = %v, want %v", got, want)
+		}
+		if got, want := r.Body, tc.message+"\n"; got != want {
+			t.Errorf("%+v: r.Body = %v, want %v", tc, got, want)
+		}
+		if got, want := r.Header.Get("Content-Language"), tc.contentLanguage; got != want {
+			t.Errorf("%+v: got %v, want %v", tc, got, want)
+		}
+	}
+	session["lang"] = "português"
+	r = s.handlePost(NewSessionHandler, session)
+	if got, want := r.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := r.Body, "Invalid lang português, expected a language tag like pt-BR\n"; got != want {
+		t.Errorf("r.Body = %v, want %v", got, want)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	s := newWebAPITest(t)
+	openStore = func() (Storage, func()) {
+		return s.Store, func() {}
+	}
+	for _, tc := range []struct {
+		method, path string
+		code         int
+		allow        string
+	}{
+		{"GET", "/2/session/new", http.StatusMethodNotAllowed, "POST"},
+		{"POST", "/2/stats/versions", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS"},
+		{"POST", "/healthz", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"GET", "/admin/1/users/user@server.org", http.StatusMethodNotAllowed, "DELETE"},
+		{"GET", "/2/nowhere", http.StatusNotFound, ""},
+		{"HEAD", "/healthz", http.StatusOK, ""},
+		{"HEAD", "/2/status", http.StatusOK, ""},
+	} {
+		req, _ := http.NewRequest(tc.method, tc.path, nil)
+		w := httptest.NewRecorder()
+		APIHandler().ServeHTTP(w, req)
+		if got, want := w.Code, tc.code; got != want {
+			t.Errorf("%s %s: %s: w.Code = %v, want %v", tc.method, tc.path, w.Body, got, want)
+		}
+		if got, want := w.Header().Get("Allow"), tc.allow; got != want {
+			t.Errorf("%s %s: got %v, want %v", tc.method, tc.path, got, want)
+		}
+	}
+	req, _ := http.NewRequest("GET", "/2/session/new", nil)
+	w := httptest.NewRecorder()
+	APIHandler().ServeHTTP(w, req)
+	if got, want := w.Body.String(), "Method GET is not allowed for /2/session/new, expected POST\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+}
+
+func basicAuth(password string) http.Header {
+	credentials := base64.StdEncoding.EncodeToString([]byte("admin:" + password))
+	return http.Header{"Authorization": {"Basic " + credentials}}
+}
+
+func TestUIRequiresAuthentication(t *testing.T) {
+	s := newWebAPITest(t)
+	r := s.handleGet("/admin/ui", requireUI(UIHandler), "/admin/ui", nil)
+	if got, want := r.StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, pattern := r.Header.Get("WWW-Authenticate"), "Basic .*"; !fullMatch(pattern, got) {
+		t.Errorf("got %q, want a match of %q", got, pattern)
+	}
+	r = s.handleGet("/admin/ui", requireUI(UIHandler), "/admin/ui", basicAuth("wrong"))
+	if got, want := r.StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+}
+
+func TestUIPaginatesOpenSessions(t *testing.T) {
+	s := newWebAPITest(t)
+	for i := 0; i < uiPageSize+1; i++ {
+		s.newSession(fmt.Sprintf("user%d@example.com", i), fmt.Sprintf("machine-%d", i), "1.0")
+	}
+	r := s.handleGet("/admin/ui", requireUI(UIHandler), "/admin/ui", basicAuth(testAdminToken))
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := strings.Count(r.Body, "@example.com"), uiPageSize; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, pattern := r.Body, `(?s).*href="\?sessions_page=2".*`; !fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want a match of %q", got, pattern)
+	}
+
+	r = s.handleGet("/admin/ui", requireUI(UIHandler), "/admin/ui?sessions_page=2", basicAuth(testAdminToken))
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := strings.Count(r.Body, "@example.com"), 1; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, pattern := r.Body, `(?s).*sessions_page=3.*`; fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want no match of %q", got, pattern)
+	}
+}
+
+func TestUIIsAccessible(t *testing.T) {
+	s := newWebAPITest(t)
+	s.newSession("user@example.com", "machine-a", "1.0")
+	r := s.handleGet("/admin/ui", requireUI(UIHandler), "/admin/ui", basicAuth(testAdminToken))
+	if got, want := r.StatusCode, http.StatusOK; got != want {
+		t.Fatalf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := strings.Count(r.Body, "<table"), strings.Count(r.Body, "<caption>"); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, pattern := r.Body, `(?s).*<th>.*`; fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want no match of %q", got, pattern)
+	}
+	if got, pattern := r.Body, `(?s).*<a class="skip" href="#main">.*`; !fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want a match of %q", got, pattern)
+	}
+	// The chart is summed up in words and its bars are hidden from screen readers.
+	if got, pattern := r.Body, `(?s).*1 sessions in 30 days\. The busiest day was .*, with 1 sessions\..*`; !fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want a match of %q", got, pattern)
+	}
+	if got, pattern := r.Body, `(?s).*<td class="bar-cell" aria-hidden="true">.*`; !fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want a match of %q", got, pattern)
+	}
+	if got, pattern := r.Body, `(?s).*aria-label="Pages of open sessions".*`; !fullMatch(pattern, got) {
+		t.Errorf("r.Body = %q, want a match of %q", got, pattern)
+	}
+}
+
+func TestUINewBlockRequiresCSRFToken(t *testing.T) {
+	s := newWebAPITest(t)
+	data := map[string]string{"field": BlockJID, "value": "user@example.com", "message": "Blocked"}
+	r := s.handlePostWithHeader(requireUI(UINewBlockHandler), data, basicAuth(testAdminToken))
+	if got, want := r.StatusCode, http.StatusForbidden; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).BlockList), 0; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).BlockList) = %d, want %d", got, want)
+	}
+
+	data["csrf_token"] = csrfToken(testAdminToken)
+	r = s.handlePostWithHeader(requireUI(UINewBlockHandler), data, basicAuth(testAdminToken))
+	if got, want := r.StatusCode, http.StatusSeeOther; got != want {
+		t.Errorf("r.StatusCode = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.(*MemoryStore).BlockList), 1; got != want {
+		t.Errorf("len(s.Store.(*MemoryStore).BlockList) = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,216 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// apiKeyLimiter caps how many requests each API key can make.
+var apiKeyLimiter = NewRateLimiter("api_key")
+
+// keylessRequests counts the requests accepted without a key in grace mode,
+// to tell when legacy clients are gone and keys can be required.
+var keylessRequests int64
+
+// requireAPIKey wraps h, checking the X-API-Key header of requests
+// according to api_keys.mode and applying the rate limit of the key.
+func requireAPIKey(h contextualHandlerFunc) contextualHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, c *Context) {
+		if c.Config == nil || c.Config.APIKeys == nil || c.Config.APIKeys.Mode == "" {
+			h(w, r, c)
+			return
+		}
+		conf := c.Config.APIKeys
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			if conf.Mode == APIKeysGrace {
+				atomic.AddInt64(&keylessRequests, 1)
+				w.Header().Set("Warning", `299 elephant-tracker "Missing X-API-Key header, it will be required"`)
+				h(w, r, c)
+				return
+			}
+			writeError(w, r, &APIError{"missing_api_key", "", "", "Missing X-API-Key header"}, http.StatusUnauthorized)
+			return
+		}
+		k, err := findAPIKey(key, c)
+		switch err {
+		case nil:
+		case mgo.ErrNotFound:
+			writeError(w, r, &APIError{"invalid_api_key", "", "", "Invalid X-API-Key header"}, http.StatusUnauthorized)
+			return
+		default:
+			writeError(w, r, internalError("Failed to check API key"), http.StatusInternalServerError)
+			c.Log.Error(err)
+			return
+		}
+		if !k.RevokedAt.IsZero() {
+			writeError(w, r, &APIError{"revoked_api_key", "", "", fmt.Sprintf("API key %s was revoked", k.Name)},
+				http.StatusUnauthorized)
+			return
+		}
+		limit := k.MaxPerMinute
+		if limit == 0 {
+			limit = conf.MaxPerMinute
+		}
+		if limit > 0 {
+			if ok, retry := apiKeyLimiter.AllowIn(sharedRates(c), k.limiterKey(), limit, time.Minute); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+				writeError(w, r, &APIError{"rate_limited", "", "",
+					fmt.Sprintf("Too many requests with API key %s, at most %d per minute", k.Name, limit)},
+					http.StatusTooManyRequests)
+				return
+			}
+		}
+		c.Project = k.Name
+		if !useApp(w, r, c, k.App) {
+			return
+		}
+		h(w, r, c)
+	}
+}
+
+// findAPIKey looks key up in the configuration, then in the storage of
+// each app.
+func findAPIKey(key string, c *Context) (*APIKey, error) {
+	for _, ck := range c.Config.APIKeys.Keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(ck.Key)) == 1 {
+			return &APIKey{Key: ck.Key, Name: ck.Name, MaxPerMinute: ck.MaxPerMinute, App: ck.App}, nil
+		}
+	}
+	for _, app := range appIds(c.Config) {
+		k, err := appStore(c.Store, c.Config, app).FindAPIKey(key)
+		if err != mgo.ErrNotFound {
+			return k, err
+		}
+	}
+	return nil, mgo.ErrNotFound
+}
+
+// limiterKey returns what apiKeyLimiter counts the requests of k by: its
+// id, or its app and name for keys in the config, which have no id. The
+// key itself is a secret, and the rate limiter may count it in storage.
+func (k *APIKey) limiterKey() string {
+	if k.Id != "" {
+		return k.Id.Hex()
+	}
+	return k.App + "/" + k.Name
+}
+
+// newSecret returns a random secret, as for API keys and webhooks.
+func newSecret() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// NewAPIKeyHandler creates a stored API key, replying with the key. The key
+// belongs to the app of the request, see requireToken.
+func NewAPIKeyHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"name"}, "max_per_minute")
+	maxPerMinute := 0
+	if s := r.PostFormValue("max_per_minute"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			errs = append(errs, &APIError{"invalid_value", "max_per_minute", "",
+				"Invalid max_per_minute, expected a number, 0 for the default"})
+		}
+		maxPerMinute = n
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	k := NewAPIKey(newSecret(), r.PostFormValue("name"), maxPerMinute)
+	k.App = c.App
+	if err := c.Store.InsertAPIKey(k); err != nil {
+		writeError(w, r, internalError("Failed to create API key"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	a := NewAuditEntry("api_key.new", k.Id.Hex(), c.actor(r), "", bson.M{
+		"name":           k.Name,
+		"max_per_minute": k.MaxPerMinute,
+	})
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	fmt.Fprintln(w, k.Key)
+}
+
+// RevokeAPIKeyHandler revokes a stored API key.
+func RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"api_key_id"})
+	idHex := r.PostFormValue("api_key_id")
+	if idHex != "" && !bson.IsObjectIdHex(idHex) {
+		errs = append(errs, &APIError{"invalid_api_key_id", "api_key_id", "", fmt.Sprintf("Invalid API key id %s", idHex)})
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	switch err := c.Store.RevokeAPIKey(bson.ObjectIdHex(idHex)); err {
+	case nil:
+		a := NewAuditEntry("api_key.revoke", idHex, c.actor(r), "", nil)
+		if err := c.Store.InsertAuditEntry(a); err != nil {
+			c.Log.Error(err)
+		}
+		fmt.Fprintln(w, idHex)
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"api_key_not_found", "api_key_id", "",
+			fmt.Sprintf("API key %s does not exist or is already revoked", idHex)}, http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to revoke API key %s", idHex)), http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// apiKeysReport lists the API keys, without their secrets.
+type apiKeysReport struct {
+	Mode            string          `json:"mode"`
+	KeylessRequests int64           `json:"keyless_requests"`
+	Configured      []*configKeyRow `json:"configured"`
+	Stored          []*APIKey       `json:"stored"`
+}
+
+type configKeyRow struct {
+	Name         string `json:"name"`
+	MaxPerMinute int    `json:"max_per_minute"`
+}
+
+// APIKeysHandler lists the configured and stored API keys.
+func APIKeysHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	keys, err := c.Store.APIKeys()
+	if err != nil {
+		writeError(w, r, internalError("Failed to list API keys"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	rep := &apiKeysReport{
+		KeylessRequests: atomic.LoadInt64(&keylessRequests),
+		Configured:      []*configKeyRow{},
+		Stored:          keys,
+	}
+	if rep.Stored == nil {
+		rep.Stored = []*APIKey{}
+	}
+	if conf := c.Config.APIKeys; conf != nil {
+		rep.Mode = conf.Mode
+		for _, k := range conf.Keys {
+			rep.Configured = append(rep.Configured, &configKeyRow{k.Name, k.MaxPerMinute})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,99 @@
+package tracker
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// defaultApp is the app of the requests made without an API key or with a
+// key of no app, as the legacy clients of API v1 do: XMPPVOX, whose data is
+// in the database of mongo.db.
+const defaultApp = "xmppvox"
+
+// appIdPattern matches the ids of apps, which name their Mongo databases.
+var appIdPattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// findApp returns the app of conf with the given id, or nil.
+func findApp(conf *Config, id string) *AppConfig {
+	if conf == nil || conf.Apps == nil {
+		return nil
+	}
+	for _, a := range conf.Apps.List {
+		if a != nil && a.Id == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// appIds returns the ids of the apps of conf, the default app first, as
+// stored in the app_id of documents: empty for the default app.
+func appIds(conf *Config) []string {
+	ids := []string{""}
+	if conf != nil && conf.Apps != nil {
+		for _, a := range conf.Apps.List {
+			ids = append(ids, a.Id)
+		}
+	}
+	return ids
+}
+
+// appStore returns the Storage of app given the store of the default app,
+// keeping its wrappers. Each app has its own database, so that its
+// installations, sessions and stats are never mixed with those of others.
+func appStore(store Storage, conf *Config, app string) Storage {
+	if app == "" || app == defaultApp {
+		return store
+	}
+	switch s := store.(type) {
+	case *meteredStore:
+		m := *s
+		m.Storage = appStore(s.Storage, conf, app)
+		return &m
+	case *auditedStore:
+		a := *s
+		a.Storage = appStore(s.Storage, conf, app)
+		return &a
+	case *cachedStore:
+		c := *s
+		c.Storage = appStore(s.Storage, conf, app)
+		c.app = app
+		return &c
+	case *MongoStore:
+		db := s.Name + "_" + app
+		if a := findApp(conf, app); a != nil && a.DB != "" {
+			db = a.DB
+		}
+		return &MongoStore{s.Session.DB(db)}
+	case *MemoryStore:
+		return s.app(app)
+	}
+	return store
+}
+
+// useApp serves the rest of the request from the storage of app, replying
+// with an error and returning false when app is not configured.
+func useApp(w http.ResponseWriter, r *http.Request, c *Context, app string) bool {
+	if app == "" || app == defaultApp {
+		return true
+	}
+	if findApp(c.Config, app) == nil {
+		writeError(w, r, &APIError{"unknown_app", "app", "", fmt.Sprintf("Unknown app %s", app)}, http.StatusBadRequest)
+		return false
+	}
+	c.App = app
+	c.Store = appStore(c.Store, c.Config, app)
+	return true
+}
+
+// eachApp calls f with the storage of every app in turn, given the store of
+// the default app, stopping at the first error.
+func eachApp(store Storage, conf *Config, f func(Storage) error) error {
+	for _, app := range appIds(conf) {
+		if err := f(appStore(store, conf, app)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package tracker
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"labix.org/v2/mgo"
+	"time"
+)
+
+// backfillCheckpoint names the checkpoint of backfillSessions.
+const backfillCheckpoint = "backfill_sessions"
+
+// defaultBackfillBatch is how many sessions the backfill reads at a time.
+const defaultBackfillBatch = 500
+
+// backfillSession fills in the fields of a closed session that older
+// versions of the tracker did not record, as far as they can be derived:
+// the last ping of sessions never pinged is when they were created, the
+// sessions closed without a reason were closed by their client, and the
+// duration is the time from creation to closing.
+func backfillSession(s *Session) {
+	if s.LastPing.IsZero() {
+		s.LastPing = s.CreatedAt
+	}
+	if s.ClosedReason == "" {
+		s.ClosedReason = ClosedByClient
+	}
+	if s.Duration == nil {
+		var d int64
+		if s.ClosedAt.After(s.CreatedAt) {
+			d = int64(s.ClosedAt.Sub(s.CreatedAt) / time.Second)
+		}
+		s.Duration = &d
+	}
+}
+
+// backfillSessions backfills the closed sessions missing fields, batch
+// sessions at a time, saving a checkpoint after every batch so that an
+// interrupted backfill resumes where it stopped. With restart it ignores
+// the checkpoint. It writes its progress to w and returns how many sessions
+// it backfilled in this run.
+func backfillSessions(store Storage, batch int, restart bool, w io.Writer) (int, error) {
+	cp, err := store.Checkpoint(backfillCheckpoint)
+	switch {
+	case err == mgo.ErrNotFound || restart:
+		cp = &Checkpoint{Name: backfillCheckpoint}
+	case err != nil:
+		return 0, err
+	case cp.After != "":
+		fmt.Fprintf(w, "resuming after session %s, %d backfilled so far\n", cp.After, cp.Done)
+	default:
+		cp.Done = 0
+	}
+	n := 0
+	for {
+		sessions, err := store.LegacySessions(cp.After, batch)
+		if err != nil {
+			return n, err
+		}
+		if len(sessions) == 0 {
+			break
+		}
+		for _, s := range sessions {
+			backfillSession(s)
+			if err := store.BackfillSession(s); err != nil {
+				return n, fmt.Errorf("session %s: %v", s.Id, err)
+			}
+		}
+		n += len(sessions)
+		cp.After, cp.Done, cp.UpdatedAt = sessions[len(sessions)-1].Id, cp.Done+len(sessions), time.Now()
+		if err := store.SaveCheckpoint(cp); err != nil {
+			return n, err
+		}
+		fmt.Fprintf(w, "backfilled %d sessions, up to %s\n", cp.Done, cp.After)
+	}
+	// Start over next time, to backfill the sessions closed since.
+	cp.After, cp.UpdatedAt = "", time.Now()
+	if err := store.SaveCheckpoint(cp); err != nil {
+		return n, err
+	}
+	fmt.Fprintf(w, "done, %d sessions backfilled\n", cp.Done)
+	return n, nil
+}
+
+// runBackfill runs "elephant-tracker backfill" with args against the
+// storage of the configuration of src, and returns the exit status: 0 on
+// success, 1 when the backfill fails and 2 on usage errors.
+func runBackfill(args []string, src *configSource, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	batch := fs.Int("batch", defaultBackfillBatch, "number of sessions to read at a time")
+	restart := fs.Bool("restart", false, "start from the first session, ignoring the checkpoint")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elephant-tracker backfill [-batch n] [-restart]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 || *batch < 1 {
+		fs.Usage()
+		return 2
+	}
+	store, closeStore, err := offlineStore(src)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer closeStore()
+	if _, err := backfillSessions(store, *batch, *restart, stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
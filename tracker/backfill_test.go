@@ -0,0 +1,117 @@
+package tracker
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+type backfillTest struct {
+	store *MemoryStore
+}
+
+func newBackfillTest(t *testing.T) *backfillTest {
+	s := &backfillTest{}
+	s.store = NewMemoryStore()
+	return s
+}
+
+func (s *backfillTest) session(created, closed, lastPing time.Time, reason string) *Session {
+	x := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	x.CreatedAt, x.ClosedAt, x.LastPing, x.ClosedReason = created, closed, lastPing, reason
+	s.store.Sessions[x.Id] = x
+	return x
+}
+
+// failingStore fails to backfill sessions after a number of them.
+type failingStore struct {
+	*MemoryStore
+	left int
+}
+
+func (s *failingStore) BackfillSession(x *Session) error {
+	if s.left == 0 {
+		return errors.New("connection reset")
+	}
+	s.left--
+	return s.MemoryStore.BackfillSession(x)
+}
+
+func TestBackfill(t *testing.T) {
+	s := newBackfillTest(t)
+	start := time.Date(2013, 6, 1, 12, 0, 0, 0, time.UTC)
+	legacy := s.session(start, start.Add(90*time.Second), time.Time{}, "")
+	expired := s.session(start, start.Add(time.Hour), start.Add(50*time.Minute), ClosedExpired)
+	open := s.session(start, time.Time{}, time.Time{}, "")
+	third := s.session(start, start.Add(time.Minute), start.Add(time.Minute), "")
+
+	var out bytes.Buffer
+	n, err := backfillSessions(&failingStore{s.store, 2}, 1, false, &out)
+	if err, pattern := err, "session .*: connection reset"; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("err = %v, want an error matching %q", err, pattern)
+	}
+	if got, want := n, 2; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := s.store.Checkpoints[backfillCheckpoint].Done, 2; got != want {
+		t.Errorf("s.store.Checkpoints[backfillCheckpoint].Done = %v, want %v", got, want)
+	}
+
+	out.Reset()
+	n, err = backfillSessions(s.store, 1, false, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, pattern := out.String(), "resuming after session .*, 2 backfilled so far\n.*\ndone, 3 sessions backfilled\n"; !fullMatch(pattern, got) {
+		t.Errorf("out.String() = %q, want a match of %q", got, pattern)
+	}
+
+	if got, want := legacy.LastPing, start; got != want {
+		t.Errorf("legacy.LastPing = %v, want %v", got, want)
+	}
+	if got, want := legacy.ClosedReason, ClosedByClient; got != want {
+		t.Errorf("legacy.ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := *legacy.Duration, int64(90); got != want {
+		t.Errorf("*legacy.Duration = %v, want %v", got, want)
+	}
+	if got, want := expired.LastPing, start.Add(50*time.Minute); got != want {
+		t.Errorf("expired.LastPing = %v, want %v", got, want)
+	}
+	if got, want := expired.ClosedReason, ClosedExpired; got != want {
+		t.Errorf("expired.ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := *expired.Duration, int64(3600); got != want {
+		t.Errorf("*expired.Duration = %v, want %v", got, want)
+	}
+	if got, want := *third.Duration, int64(60); got != want {
+		t.Errorf("*third.Duration = %v, want %v", got, want)
+	}
+	if got, want := open.LastPing.IsZero(), true; got != want {
+		t.Errorf("open.LastPing.IsZero() = %v, want %v", got, want)
+	}
+	if got := open.Duration; got != nil {
+		t.Errorf("open.Duration = %v, want nil", got)
+	}
+
+	// Once done, sessions closed since are backfilled from the start.
+	open.ClosedAt = start.Add(2 * time.Hour)
+	out.Reset()
+	n, err = backfillSessions(s.store, 10, false, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := *open.Duration, int64(7200); got != want {
+		t.Errorf("*open.Duration = %v, want %v", got, want)
+	}
+	if got, want := s.store.Checkpoints[backfillCheckpoint].After, SessionId(""); got != want {
+		t.Errorf("s.store.Checkpoints[backfillCheckpoint].After = %v, want %v", got, want)
+	}
+}
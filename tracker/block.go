@@ -0,0 +1,164 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// blockFields lists the valid values of Block.Field.
+var blockFields = []string{BlockJID, BlockMachineId, BlockXMPPVOXVersion, BlockRemoteIP}
+
+// blockFromForm validates the parameters of a new block:
+// field, value, message and the optional messages, in addition to optional.
+// messages is a JSON object of message in other languages by language tag,
+// like {"en": "..."}.
+func blockFromForm(r *http.Request, conf *Config, optional ...string) (*Block, APIErrors) {
+	field := r.PostFormValue("field")
+	errs := checkParams(r, []string{"field", "value", "message"}, append([]string{"messages"}, optional...)...)
+	if field != "" && !isBlockField(field) {
+		errs = append(errs, &APIError{"invalid_value", "field", "",
+			fmt.Sprintf("Invalid field %s, expected one of %v", field, blockFields)})
+	}
+	value := r.PostFormValue("value")
+	switch field {
+	case BlockJID:
+		// Sessions match blocks by their bare jid.
+		value = canonicalJID(conf, value)
+	case BlockMachineId:
+		value = canonicalMachineId(conf, value)
+	}
+	if field == BlockRemoteIP && value != "" {
+		// Blocks match the IP as net.IP formats it.
+		if ip := net.ParseIP(value); ip != nil {
+			value = ip.String()
+		} else {
+			errs = append(errs, &APIError{"invalid_value", "value", "", fmt.Sprintf("Invalid IP address %s", value)})
+		}
+	}
+	var messages map[string]string
+	if raw := r.PostFormValue("messages"); raw != "" {
+		if len(raw) > maxBlockMessagesBytes {
+			errs = append(errs, tooLong("messages", "", len(raw), maxBlockMessagesBytes))
+		} else if err := json.Unmarshal([]byte(raw), &messages); err != nil {
+			errs = append(errs, &APIError{"invalid_json", "messages", "",
+				"Invalid JSON for messages: expected an object of messages by language"})
+		}
+	}
+	for _, lang := range languageTags(messages) {
+		switch {
+		case !languageTagPattern.MatchString(lang):
+			errs = append(errs, &APIError{"invalid_value", "messages", lang,
+				fmt.Sprintf("Invalid language %s, expected a language tag like pt-BR", lang)})
+		case strings.EqualFold(lang, defaultLanguage):
+			errs = append(errs, &APIError{"invalid_value", "messages", lang,
+				fmt.Sprintf("Invalid language %s, the message param is in %s", lang, defaultLanguage)})
+		case strings.TrimSpace(messages[lang]) == "":
+			errs = append(errs, &APIError{"invalid_value", "messages", lang, fmt.Sprintf("Empty message in %s", lang)})
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	b := NewBlock(field, value, r.PostFormValue("message"))
+	b.Messages = messages
+	return b, nil
+}
+
+func isBlockField(field string) bool {
+	for _, f := range blockFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// addBlock stores b, recording who added it in the audit log.
+func addBlock(r *http.Request, c *Context, b *Block) error {
+	if err := c.Store.InsertBlock(b); err != nil {
+		return err
+	}
+	a := NewAuditEntry("block.add", b.Id.Hex(), c.actor(r), "", bson.M{
+		"field":    b.Field,
+		"value":    b.Value,
+		"message":  b.Message,
+		"messages": b.Messages,
+	})
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	return nil
+}
+
+// removeBlock removes the block with the id in the block_id parameter,
+// replying with an error if it fails.
+func removeBlock(w http.ResponseWriter, r *http.Request, c *Context) bool {
+	idHex := r.PostFormValue("block_id")
+	if !bson.IsObjectIdHex(idHex) {
+		writeError(w, r, &APIError{"invalid_block_id", "block_id", "", fmt.Sprintf("Invalid block id %s", idHex)},
+			http.StatusBadRequest)
+		return false
+	}
+	switch err := c.Store.RemoveBlock(bson.ObjectIdHex(idHex)); err {
+	case nil:
+		a := NewAuditEntry("block.remove", idHex, c.actor(r), "", nil)
+		if err := c.Store.InsertAuditEntry(a); err != nil {
+			c.Log.Error(err)
+		}
+		return true
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"block_not_found", "block_id", "", fmt.Sprintf("Block %s does not exist", idHex)},
+			http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to remove block %s", idHex)), http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+	return false
+}
+
+// NewBlockHandler denies new sessions to the clients with a jid,
+// machine_id or xmppvox_version, telling them why.
+func NewBlockHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	b, errs := blockFromForm(r, c.Config)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if err := addBlock(r, c, b); err != nil {
+		writeError(w, r, internalError("Failed to add block"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	fmt.Fprintln(w, b.Id.Hex())
+}
+
+// RemoveBlockHandler lifts a block.
+func RemoveBlockHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	if errs := checkParams(r, []string{"block_id"}); errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if removeBlock(w, r, c) {
+		fmt.Fprintln(w, r.PostFormValue("block_id"))
+	}
+}
+
+// BlocksHandler lists all blocks, oldest first.
+func BlocksHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	blocks, err := c.Store.Blocks()
+	if err != nil {
+		writeError(w, r, internalError("Failed to list blocks"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if blocks == nil {
+		blocks = []*Block{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(blocks)
+}
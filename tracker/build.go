@@ -0,0 +1,39 @@
+package tracker
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Version, Commit and BuildDate identify the build of the tracker. Release
+// builds set them with -ldflags "-X .../tracker.Version=1.4.0 ...", see the
+// README. Version is a semantic version, as MAJOR.MINOR.PATCH with an
+// optional pre-release, and BuildDate is in RFC 3339.
+var (
+	Version   = "0.0.0-dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// buildInfo is the build of the tracker, as reported by /version.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+}
+
+// VersionHandler reports the build of the tracker as JSON, for bug reports
+// to tell which build a client talked to.
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(&buildInfo{Version, Commit, BuildDate})
+}
+
+// serverHeader names the build of the tracker in the Server header of the
+// responses of h, like "elephant-tracker/1.4.0".
+func serverHeader(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "elephant-tracker/"+Version)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,368 @@
+package tracker
+
+import (
+	"fmt"
+	"github.com/garyburd/redigo/redis"
+	"labix.org/v2/mgo"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheTTL is used when cache.ttl is not configured.
+const defaultCacheTTL = time.Hour
+
+// cachedSession is the state of a session kept in a SessionCache.
+type cachedSession struct {
+	MachineId string
+	Closed    bool
+	// Written is when the session was last written to storage.
+	Written time.Time
+	// Secret is the secret of the session, empty for sessions without
+	// one, when KnowsSecret, so that signatures are checked without
+	// reaching storage, see sessionSecret.
+	Secret      string
+	KnowsSecret bool
+}
+
+// keepSecret copies to cs the secret known by old, the cached state before
+// a write, returning cs.
+func (cs *cachedSession) keepSecret(old *cachedSession) *cachedSession {
+	if old != nil && old.KnowsSecret {
+		cs.Secret, cs.KnowsSecret = old.Secret, true
+	}
+	return cs
+}
+
+// A SessionCache keeps the state of sessions, by id, for a while.
+type SessionCache interface {
+	// Get returns the state of the session id, or nil if it is not cached.
+	Get(id SessionId) (*cachedSession, error)
+	Set(id SessionId, s *cachedSession, ttl time.Duration) error
+}
+
+// pingJournal holds the latest ping of each session that a cachedStore
+// acknowledged without writing it, until a later write to the session.
+// Shutdown writes those left, see Server.flushHeldPings. It is safe for
+// concurrent use.
+type pingJournal struct {
+	sync.Mutex
+	pings map[SessionId]*queuedWrite
+}
+
+func newPingJournal() *pingJournal {
+	return &pingJournal{pings: make(map[SessionId]*queuedWrite)}
+}
+
+func (j *pingJournal) hold(qw *queuedWrite) {
+	j.Lock()
+	defer j.Unlock()
+	j.pings[qw.SessionId] = qw
+}
+
+func (j *pingJournal) forget(id SessionId) {
+	j.Lock()
+	defer j.Unlock()
+	delete(j.pings, id)
+}
+
+// take returns the pings held and forgets them.
+func (j *pingJournal) take() []*queuedWrite {
+	j.Lock()
+	defer j.Unlock()
+	pings := make([]*queuedWrite, 0, len(j.pings))
+	for _, qw := range j.pings {
+		pings = append(pings, qw)
+	}
+	j.pings = make(map[SessionId]*queuedWrite)
+	return pings
+}
+
+// flushHeldPings writes the pings held by s to the storage of their app
+// given store, that of the default app, each as of when it was
+// acknowledged. Those failing because storage is unavailable go to the
+// write queue, to be replayed on the next start, or are lost without one.
+// It returns how many were lost.
+func (s *Server) flushHeldPings(store Storage) int {
+	c := &Context{Store: store, Config: s.CurrentConfig(), Log: s.Log, Server: s}
+	lost := 0
+	for _, qw := range s.heldPings.take() {
+		err := appStore(store, c.Config, qw.App).PingSession(&Session{Id: qw.SessionId, MachineId: qw.MachineId,
+			LastPing: qw.QueuedAt})
+		if err != nil && err != mgo.ErrNotFound && !c.queueWrite(err, qw) {
+			lost++
+		}
+	}
+	return lost
+}
+
+// redisCache is a SessionCache in Redis, which every tracker of a cluster
+// can share.
+type redisCache struct {
+	pool *redis.Pool
+}
+
+func newRedisCache(conf *CacheConfig) *redisCache {
+	return &redisCache{&redis.Pool{
+		MaxIdle:     16,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			// Fail fast, storage is there to fall back on.
+			return redis.Dial("tcp", conf.Redis,
+				redis.DialPassword(conf.Password),
+				redis.DialDatabase(conf.DB),
+				redis.DialConnectTimeout(time.Second),
+				redis.DialReadTimeout(time.Second),
+				redis.DialWriteTimeout(time.Second))
+		},
+	}}
+}
+
+func cacheKey(id SessionId) string {
+	return "elephant-tracker:session:" + id.String()
+}
+
+// ping checks that the Redis server answers, for the startup self-check.
+func (c *redisCache) ping() error {
+	conn := c.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("PING")
+	return err
+}
+
+// Sessions are cached as their state, "open" or "closed", the Unix time
+// they were written, their secret, "-" without one and "?" when unknown,
+// and their machine id, separated by spaces. Values without the secret,
+// cached by older trackers, have it unknown.
+func formatCachedSession(cs *cachedSession) string {
+	state := "open"
+	if cs.Closed {
+		state = "closed"
+	}
+	secret := "?"
+	switch {
+	case cs.KnowsSecret && cs.Secret == "":
+		secret = "-"
+	case cs.KnowsSecret:
+		secret = cs.Secret
+	}
+	return fmt.Sprintf("%s %d %s %s", state, cs.Written.Unix(), secret, cs.MachineId)
+}
+
+func parseCachedSession(v string) (*cachedSession, error) {
+	fields := strings.SplitN(v, " ", 4)
+	if len(fields) == 3 {
+		fields = []string{fields[0], fields[1], "?", fields[2]}
+	}
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("invalid cached session %q", v)
+	}
+	written, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cached session %q", v)
+	}
+	cs := &cachedSession{MachineId: fields[3], Closed: fields[0] == "closed", Written: time.Unix(written, 0)}
+	switch secret := fields[2]; secret {
+	case "?":
+	case "-":
+		cs.KnowsSecret = true
+	default:
+		cs.Secret, cs.KnowsSecret = secret, true
+	}
+	return cs, nil
+}
+
+func (c *redisCache) Get(id SessionId) (*cachedSession, error) {
+	conn := c.pool.Get()
+	defer conn.Close()
+	v, err := redis.String(conn.Do("GET", cacheKey(id)))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseCachedSession(v)
+}
+
+func (c *redisCache) Set(id SessionId, s *cachedSession, ttl time.Duration) error {
+	conn := c.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", cacheKey(id), formatCachedSession(s), "EX", int(ttl/time.Second))
+	return err
+}
+
+// cachedStore is a Storage that looks sessions up in a SessionCache before
+// pinging or closing them, answering mgo.ErrNotFound for those closed or of
+// another machine without reaching storage. With cache.ping_write_interval,
+// pings without activity are written to storage at most that often.
+//
+// Sessions closed by the reaper or superseded are only known to be closed
+// once written to again, so pings of such sessions may be accepted for
+// up to cache.ping_write_interval. When the cache fails, storage decides.
+// The pings it does not write are kept in held until shutdown.
+type cachedStore struct {
+	Storage
+	cache SessionCache
+	conf  *CacheConfig
+	held  *pingJournal
+	// app is the app of the storage, see appStore.
+	app string
+}
+
+// cacheOf returns the cachedStore wrapped by store, if any.
+func cacheOf(store Storage) *cachedStore {
+	switch s := store.(type) {
+	case *meteredStore:
+		return cacheOf(s.Storage)
+	case *auditedStore:
+		return cacheOf(s.Storage)
+	case *cachedStore:
+		return s
+	}
+	return nil
+}
+
+func (s *cachedStore) lookup(id SessionId) *cachedSession {
+	cs, err := s.cache.Get(id)
+	if err != nil {
+		logger.Warn("[cache]", err)
+		return nil
+	}
+	return cs
+}
+
+func (s *cachedStore) set(id SessionId, cs *cachedSession) {
+	ttl := defaultCacheTTL
+	if s.conf.TTL.Duration > 0 {
+		ttl = s.conf.TTL.Duration
+	}
+	if err := s.cache.Set(id, cs, ttl); err != nil {
+		logger.Warn("[cache]", err)
+	}
+}
+
+// refused reports whether cs tells that a session cannot be written to by
+// machineId, as storage would.
+func refused(cs *cachedSession, machineId string) bool {
+	return cs != nil && (cs.Closed || cs.MachineId != machineId)
+}
+
+// learn caches the state of a session storage did not find open for a
+// machine, which may be another machine's or closed or not exist at all.
+func (s *cachedStore) learn(id SessionId) {
+	x, err := s.Storage.FindSession(id)
+	switch {
+	case err == mgo.ErrNotFound:
+		s.set(id, &cachedSession{Closed: true, Written: time.Now()})
+	case err != nil:
+		logger.Warn("[cache]", err)
+	default:
+		s.set(id, &cachedSession{MachineId: x.MachineId, Closed: !x.ClosedAt.IsZero(), Written: time.Now(),
+			Secret: x.Secret, KnowsSecret: true})
+	}
+}
+
+// sessionSecret returns the secret of session id, looking the session up in
+// storage, and caching it, only when the cache does not know it. Sessions
+// the cache knows to be closed are mgo.ErrNotFound.
+func (s *cachedStore) sessionSecret(id SessionId) (string, error) {
+	cs := s.lookup(id)
+	switch {
+	case cs != nil && cs.Closed:
+		return "", mgo.ErrNotFound
+	case cs != nil && cs.KnowsSecret:
+		return cs.Secret, nil
+	}
+	x, err := s.Storage.FindSession(id)
+	if err != nil {
+		return "", err
+	}
+	written := x.CreatedAt
+	if x.LastPing.After(written) {
+		written = x.LastPing
+	}
+	s.set(id, &cachedSession{MachineId: x.MachineId, Closed: !x.ClosedAt.IsZero(), Written: written,
+		Secret: x.Secret, KnowsSecret: true})
+	return x.Secret, nil
+}
+
+func (s *cachedStore) InsertSession(x *Session) error {
+	err := s.Storage.InsertSession(x)
+	if err == nil {
+		s.set(x.Id, &cachedSession{MachineId: x.MachineId, Written: x.CreatedAt, Secret: x.Secret, KnowsSecret: true})
+	}
+	return err
+}
+
+func (s *cachedStore) PingSession(x *Session) error {
+	now := time.Now()
+	cs := s.lookup(x.Id)
+	if refused(cs, x.MachineId) {
+		return mgo.ErrNotFound
+	}
+	if cs != nil && x.Activity == nil && now.Sub(cs.Written) < s.conf.PingWriteInterval.Duration {
+		s.held.hold(&queuedWrite{Kind: QueuedPing, QueuedAt: now.UTC(), SessionId: x.Id, MachineId: x.MachineId,
+			App: s.app})
+		return nil
+	}
+	err := s.Storage.PingSession(x)
+	switch err {
+	case nil:
+		s.held.forget(x.Id)
+		s.set(x.Id, (&cachedSession{MachineId: x.MachineId, Written: now}).keepSecret(cs))
+	case mgo.ErrNotFound:
+		s.learn(x.Id)
+	}
+	return err
+}
+
+func (s *cachedStore) CloseSession(x *Session) error {
+	cs := s.lookup(x.Id)
+	if refused(cs, x.MachineId) {
+		return mgo.ErrNotFound
+	}
+	id := x.Id
+	err := s.Storage.CloseSession(x)
+	switch err {
+	case nil:
+		s.held.forget(id)
+		s.set(id, (&cachedSession{MachineId: x.MachineId, Closed: true, Written: time.Now()}).keepSecret(cs))
+	case mgo.ErrNotFound:
+		s.learn(id)
+	}
+	return err
+}
+
+func (s *cachedStore) TransferSession(x *Session, to string) error {
+	cs := s.lookup(x.Id)
+	if refused(cs, x.MachineId) {
+		return mgo.ErrNotFound
+	}
+	id := x.Id
+	err := s.Storage.TransferSession(x, to)
+	switch err {
+	case nil:
+		s.set(id, (&cachedSession{MachineId: to, Written: time.Now()}).keepSecret(cs))
+	case mgo.ErrNotFound:
+		s.learn(id)
+	}
+	return err
+}
+
+func (s *cachedStore) ReopenSession(x *Session, closedSince time.Time) error {
+	err := s.Storage.ReopenSession(x, closedSince)
+	if err == nil {
+		s.set(x.Id, &cachedSession{MachineId: x.MachineId, Written: time.Now(), Secret: x.Secret, KnowsSecret: true})
+	}
+	return err
+}
+
+func (s *cachedStore) ResumeSession(x *Session, closedSince time.Time) error {
+	err := s.Storage.ResumeSession(x, closedSince)
+	if err == nil {
+		s.set(x.Id, &cachedSession{MachineId: x.MachineId, Written: time.Now(), Secret: x.Secret, KnowsSecret: true})
+	}
+	return err
+}
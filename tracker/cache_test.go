@@ -0,0 +1,307 @@
+package tracker
+
+import (
+	"errors"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memoryCache is a SessionCache in a map, failing with err if set.
+type memoryCache struct {
+	sessions map[SessionId]cachedSession
+	err      error
+}
+
+func (c *memoryCache) Get(id SessionId) (*cachedSession, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	cs, ok := c.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &cs, nil
+}
+
+func (c *memoryCache) Set(id SessionId, s *cachedSession, ttl time.Duration) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.sessions[id] = *s
+	return nil
+}
+
+// countingStore counts the pings, closes and lookups that reach storage.
+type countingStore struct {
+	*MemoryStore
+	pings, closes, finds int
+}
+
+func (s *countingStore) FindSession(id SessionId) (*Session, error) {
+	s.finds++
+	return s.MemoryStore.FindSession(id)
+}
+
+func (s *countingStore) PingSession(x *Session) error {
+	s.pings++
+	return s.MemoryStore.PingSession(x)
+}
+
+func (s *countingStore) CloseSession(x *Session) error {
+	s.closes++
+	return s.MemoryStore.CloseSession(x)
+}
+
+type cacheTest struct {
+	backend *countingStore
+	cache   *memoryCache
+	store   *cachedStore
+	server  *Server
+}
+
+func newCacheTest(t *testing.T) *cacheTest {
+	s := &cacheTest{}
+	s.backend = &countingStore{MemoryStore: NewMemoryStore()}
+	s.cache = &memoryCache{sessions: make(map[SessionId]cachedSession)}
+	s.server = &Server{heldPings: newPingJournal()}
+	s.store = &cachedStore{Storage: s.backend, cache: s.cache, conf: &CacheConfig{Redis: "localhost:6379"},
+		held: s.server.heldPings}
+	return s
+}
+
+func TestClosedSessions(t *testing.T) {
+	s := newCacheTest(t)
+	x := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	if err := s.store.InsertSession(x); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.store.PingSession(&Session{Id: x.Id, MachineId: "00:26:cc:18:be:15"}), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.backend.pings, 0; got != want {
+		t.Errorf("s.backend.pings = %v, want %v", got, want)
+	}
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Error(err)
+	}
+	if got, want := s.backend.pings, 1; got != want {
+		t.Errorf("s.backend.pings = %v, want %v", got, want)
+	}
+
+	if err := s.store.CloseSession(&Session{Id: x.Id, MachineId: x.MachineId, ClosedReason: ClosedByClient}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.store.CloseSession(&Session{Id: x.Id, MachineId: x.MachineId}), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.backend.closes, 1; got != want {
+		t.Errorf("s.backend.closes = %v, want %v", got, want)
+	}
+	if got, want := s.backend.pings, 1; got != want {
+		t.Errorf("s.backend.pings = %v, want %v", got, want)
+	}
+
+	// Sessions unknown to the cache are looked up once.
+	expired := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	expired.ClosedAt = bson.Now()
+	if err := s.backend.InsertSession(expired); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if got, want := s.store.PingSession(&Session{Id: expired.Id, MachineId: expired.MachineId}), mgo.ErrNotFound; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if got, want := s.backend.pings, 2; got != want {
+		t.Errorf("s.backend.pings = %v, want %v", got, want)
+	}
+
+	if err := s.store.ReopenSession(&Session{Id: expired.Id}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.PingSession(&Session{Id: expired.Id, MachineId: expired.MachineId}); err != nil {
+		t.Error(err)
+	}
+	if got, want := s.backend.pings, 3; got != want {
+		t.Errorf("s.backend.pings = %v, want %v", got, want)
+	}
+}
+
+func TestPingWriteInterval(t *testing.T) {
+	s := newCacheTest(t)
+	s.store.conf.PingWriteInterval = Duration{time.Minute}
+	x := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	if err := s.store.InsertSession(x); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Error(err)
+	}
+	if got, want := s.backend.pings, 0; got != want {
+		t.Errorf("s.backend.pings = %v, want %v", got, want)
+	}
+	// Activity is always written.
+	activity := &SessionActivity{MessagesSent: 2}
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId, Activity: activity}); err != nil {
+		t.Error(err)
+	}
+	if got, want := s.backend.pings, 1; got != want {
+		t.Errorf("s.backend.pings = %v, want %v", got, want)
+	}
+	if got, want := x.Activity.MessagesSent, int64(2); got != want {
+		t.Errorf("x.Activity.MessagesSent = %v, want %v", got, want)
+	}
+
+	cs := s.cache.sessions[x.Id]
+	cs.Written = cs.Written.Add(-time.Minute)
+	s.cache.sessions[x.Id] = cs
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Error(err)
+	}
+	if got, want := s.backend.pings, 2; got != want {
+		t.Errorf("s.backend.pings = %v, want %v", got, want)
+	}
+}
+
+func TestFlushHeldPings(t *testing.T) {
+	s := newCacheTest(t)
+	s.store.conf.PingWriteInterval = Duration{time.Minute}
+	x := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	if err := s.store.InsertSession(x); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(s.server.heldPings.pings), 1; got != want {
+		t.Fatalf("len(s.server.heldPings.pings) = %d, want %d", got, want)
+	}
+	at := s.server.heldPings.pings[x.Id].QueuedAt
+	if got, want := s.server.flushHeldPings(s.backend), 0; got != want {
+		t.Errorf("lost = %v, want %v", got, want)
+	}
+	if got, want := s.backend.Sessions[x.Id].LastPing, at; !got.Equal(want) {
+		t.Errorf("LastPing = %v, want the time of the held ping, %v", got, want)
+	}
+
+	// With storage down, held pings go to the write queue, if any.
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Fatal(err)
+	}
+	down := flakyStore{s.backend.MemoryStore, &queueTest{Down: true}}
+	q, err := OpenWriteQueue(filepath.Join(t.TempDir(), "queue"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.server.writeQueue = q
+	if got, want := s.server.flushHeldPings(down), 0; got != want {
+		t.Errorf("lost = %v, want %v", got, want)
+	}
+	if got, want := q.Stats().Depth, 1; got != want {
+		t.Errorf("q.Stats().Depth = %v, want %v", got, want)
+	}
+	s.server.writeQueue = nil
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.server.flushHeldPings(down), 1; got != want {
+		t.Errorf("lost = %v, want %v", got, want)
+	}
+}
+
+func TestSessionSecret(t *testing.T) {
+	s := newCacheTest(t)
+	x := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	x.Secret = "s3cr3t"
+	if err := s.store.InsertSession(x); err != nil {
+		t.Fatal(err)
+	}
+	// Writes keep the secret cached.
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := sessionSecret(newMeteredStore(s.store, NewMetrics(), nil, nil), x.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := secret, "s3cr3t"; got != want {
+		t.Errorf("secret = %v, want %v", got, want)
+	}
+	if got, want := s.backend.finds, 0; got != want {
+		t.Errorf("s.backend.finds = %v, want %v", got, want)
+	}
+
+	// Sessions unknown to the cache are looked up once.
+	y := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	y.Secret = "t0ps3cr3t"
+	if err := s.backend.InsertSession(y); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		secret, err := s.store.sessionSecret(y.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := secret, "t0ps3cr3t"; got != want {
+			t.Errorf("secret = %v, want %v", got, want)
+		}
+	}
+	if got, want := s.backend.finds, 1; got != want {
+		t.Errorf("s.backend.finds = %v, want %v", got, want)
+	}
+	if _, err := s.store.sessionSecret(NewSession("", "", "", nil).Id); err != mgo.ErrNotFound {
+		t.Errorf("err = %v, want %v", err, mgo.ErrNotFound)
+	}
+}
+
+func TestCachedSessionFormat(t *testing.T) {
+	written := time.Unix(1401537600, 0)
+	for _, cs := range []*cachedSession{
+		{MachineId: "00:26:cc:18:be:14", Written: written},
+		{MachineId: "machine with spaces", Closed: true, Written: written, KnowsSecret: true},
+		{MachineId: "00:26:cc:18:be:14", Written: written, Secret: "0123456789abcdef", KnowsSecret: true},
+	} {
+		v := formatCachedSession(cs)
+		got, err := parseCachedSession(v)
+		if err != nil {
+			t.Errorf("%q: %v", v, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, cs) {
+			t.Errorf("%q: parsed %+v, want %+v", v, got, cs)
+		}
+	}
+	// As cached by older trackers.
+	got, err := parseCachedSession("open 1401537600 00:26:cc:18:be:14")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (&cachedSession{MachineId: "00:26:cc:18:be:14", Written: written}); !reflect.DeepEqual(got, want) {
+		t.Errorf("parsed %+v, want %+v", got, want)
+	}
+}
+
+func TestCacheDown(t *testing.T) {
+	s := newCacheTest(t)
+	s.cache.err = errors.New("connection refused")
+	x := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	if err := s.store.InsertSession(x); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Error(err)
+	}
+	if got, want := s.store.PingSession(&Session{Id: x.Id, MachineId: "00:26:cc:18:be:15"}), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.backend.pings, 2; got != want {
+		t.Errorf("s.backend.pings = %v, want %v", got, want)
+	}
+}
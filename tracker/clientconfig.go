@@ -0,0 +1,74 @@
+package tracker
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// clientSettings are the settings of a client as replied by
+// ClientConfigHandler, without those left to the client.
+type clientSettings struct {
+	// PingInterval is in seconds.
+	PingInterval         int    `json:"ping_interval,omitempty"`
+	ServerHost           string `json:"server_host,omitempty"`
+	MaxMessagesPerMinute int    `json:"max_messages_per_minute,omitempty"`
+}
+
+// Matches reports whether o applies to machineId running xmppvoxVersion.
+func (o *ClientOverride) Matches(conf *Config, machineId, xmppvoxVersion string) bool {
+	if o.MinVersion != "" && compareVersions(xmppvoxVersion, o.MinVersion) < 0 {
+		return false
+	}
+	if o.MaxVersion != "" && compareVersions(xmppvoxVersion, o.MaxVersion) > 0 {
+		return false
+	}
+	if len(o.MachineIds) == 0 {
+		return true
+	}
+	for _, id := range o.MachineIds {
+		if canonicalMachineId(conf, id) == machineId {
+			return true
+		}
+	}
+	return false
+}
+
+// clientSettingsFor returns the settings of the client section for
+// machineId running xmppvoxVersion, applying the matching overrides in order.
+func clientSettingsFor(conf *Config, machineId, xmppvoxVersion string) *clientSettings {
+	s := &clientSettings{}
+	if conf == nil || conf.Client == nil {
+		return s
+	}
+	set := func(ping Duration, host string, maxMessages int) {
+		if ping.Duration > 0 {
+			s.PingInterval = int(ping.Seconds())
+		}
+		if host != "" {
+			s.ServerHost = host
+		}
+		if maxMessages > 0 {
+			s.MaxMessagesPerMinute = maxMessages
+		}
+	}
+	set(conf.Client.PingInterval, conf.Client.ServerHost, conf.Client.MaxMessagesPerMinute)
+	for _, o := range conf.Client.Overrides {
+		if o.Matches(conf, machineId, xmppvoxVersion) {
+			set(o.PingInterval, o.ServerHost, o.MaxMessagesPerMinute)
+		}
+	}
+	return s
+}
+
+// ClientConfigHandler replies with the settings of the client section for
+// the machine_id and xmppvox_version query params, as JSON, so that
+// settings like the ping interval are tuned on the server.
+func ClientConfigHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	if errs := checkQueryParams(r, "machine_id", "xmppvox_version"); errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	machineId := canonicalMachineId(c.Config, r.URL.Query().Get("machine_id"))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(clientSettingsFor(c.Config, machineId, r.URL.Query().Get("xmppvox_version")))
+}
@@ -0,0 +1,63 @@
+package tracker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSettingsFor(t *testing.T) {
+	conf := &Config{
+		MachineIds: &MachineIdsConfig{Canonicalize: true},
+		Client: &ClientConfig{
+			PingInterval:         Duration{5 * time.Minute},
+			MaxMessagesPerMinute: 20,
+			Overrides: []*ClientOverride{
+				{MaxVersion: "1.1", PingInterval: Duration{10 * time.Minute}},
+				{MinVersion: "1.2", ServerHost: "xmpp2.example.org"},
+				{MachineIds: []string{"00-26-CC-18-BE-14"}, MaxMessagesPerMinute: 5, PingInterval: Duration{time.Minute}},
+			},
+		},
+	}
+	for _, tc := range []struct {
+		machineId, version string
+		want               clientSettings
+	}{
+		{"machine", "1.0", clientSettings{600, "", 20}},
+		{"machine", "1.2", clientSettings{300, "xmpp2.example.org", 20}},
+		{"machine", "1.1.1", clientSettings{300, "", 20}},
+		// Later overrides win.
+		{"00:26:cc:18:be:14", "1.0", clientSettings{60, "", 5}},
+	} {
+		if got, want := *clientSettingsFor(conf, tc.machineId, tc.version), tc.want; got != want {
+			t.Errorf("%+v: *clientSettingsFor(conf, tc.machineId, tc.version) = %v, want %v", tc, got, want)
+		}
+	}
+	if got, want := *clientSettingsFor(&Config{}, "machine", "1.0"), (clientSettings{}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestClientConfigHandler(t *testing.T) {
+	conf := &Config{Client: &ClientConfig{PingInterval: Duration{90 * time.Second}}}
+	r, _ := http.NewRequest("GET", "/1/config/client?machine_id=machine&xmppvox_version=1.0", nil)
+	w := httptest.NewRecorder()
+	ClientConfigHandler(w, r, &Context{Config: conf})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), `{"ping_interval":90}`+"\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+
+	r, _ = http.NewRequest("GET", "/1/config/client", nil)
+	w = httptest.NewRecorder()
+	ClientConfigHandler(w, r, &Context{Config: conf})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Missing parameter machine_id\nMissing parameter xmppvox_version\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,119 @@
+package tracker
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// installationHeaders are the headers kept with installations, those that
+// tell clients apart. The form is already in the installation.
+var installationHeaders = []string{"User-Agent", "Accept", "Accept-Encoding", "Accept-Language"}
+
+// installationRequest trims r to what is kept with an installation.
+func installationRequest(r *http.Request) *HttpRequest {
+	h := make(http.Header)
+	for _, k := range installationHeaders {
+		if v, ok := r.Header[k]; ok {
+			h[k] = v
+		}
+	}
+	return &HttpRequest{
+		Method:     r.Method,
+		URL:        &url.URL{Path: r.URL.Path},
+		Header:     h,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+	}
+}
+
+// sessionRequest trims r to what is kept with a session: the headers of
+// installations, leaving out credentials like X-API-Key, and the form.
+func sessionRequest(r *http.Request) *HttpRequest {
+	req := installationRequest(r)
+	req.URL = r.URL
+	req.Form = r.Form
+	return req
+}
+
+// clientNetwork returns the network of a remote address, its /24 for IPv4
+// and /48 for IPv6, as in "203.0.113.0/24", or "" if it is not an IP.
+func clientNetwork(remoteAddr string) string {
+	ip := remoteIP(remoteAddr)
+	if ip == nil {
+		return ""
+	}
+	mask := net.CIDRMask(48, 128)
+	if v4 := ip.To4(); v4 != nil {
+		ip, mask = v4, net.CIDRMask(24, 32)
+	}
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// Limits of the clients listed by ClientStatsHandler.
+const (
+	defaultClientStatsLimit = 20
+	maxClientStatsLimit     = 1000
+)
+
+// clientStats is the distribution of the installations created over a time
+// window by user agent or client network.
+type clientStats struct {
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	By      string         `json:"by"`
+	Clients []*clientShare `json:"clients"`
+}
+
+// clientShare is how many installations were registered by a client. An
+// empty value is unknown, as for installations registered before requests
+// were kept.
+type clientShare struct {
+	Value         string `json:"value"`
+	Installations int    `json:"installations"`
+}
+
+// ClientStatsHandler reports the user agents or the networks that registered
+// the most installations, by the by parameter, to spot automated fake
+// registrations. The networks are personal data, so it is for admins only.
+func ClientStatsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	win, errs := parseWindow(r, now, defaultStatsWindow, maxStatsWindow)
+	by := r.FormValue("by")
+	switch by {
+	case "":
+		by = ClientsByUserAgent
+	case ClientsByUserAgent, ClientsByNetwork:
+	default:
+		errs = append(errs, &APIError{"invalid_value", "by", "", "Invalid by, expected user_agent or network"})
+	}
+	limit := defaultClientStatsLimit
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxClientStatsLimit {
+			errs = append(errs, invalidLimit(maxClientStatsLimit))
+		}
+		limit = n
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	asOf, err := c.Store.NewestActivity()
+	var counts []*ClientCount
+	if err == nil {
+		counts, err = c.Store.InstallationClients(by, win.From, win.To, limit)
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute client stats"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	stats := &clientStats{From: win.From, To: win.To, By: by, Clients: []*clientShare{}}
+	for _, cc := range counts {
+		stats.Clients = append(stats.Clients, &clientShare{cc.Value, cc.Count})
+	}
+	writeStats(w, stats, freshness(asOf, c.Config, now))
+}
@@ -0,0 +1,181 @@
+package tracker
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Defaults of compression, when it is enabled.
+const defaultCompressionMinBytes = 1024
+
+var defaultCompressionTypes = []string{"application/json", "text/csv", "text/html", "text/plain"}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, as in
+// "gzip, deflate" or "gzip;q=0.5", and not "gzip;q=0". A * stands for gzip
+// when gzip is not named.
+func acceptsGzip(header string) bool {
+	gzipQ, starQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		q := 1.0
+		for _, p := range params[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "gzip":
+			gzipQ = q
+		case "*":
+			starQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return starQ > 0
+}
+
+// compressible reports whether responses of contentType are compressed.
+func compressible(conf *CompressionConfig, contentType string) bool {
+	types := conf.ContentTypes
+	if len(types) == 0 {
+		types = defaultCompressionTypes
+	}
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	for _, t := range types {
+		if strings.EqualFold(t, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipWriter holds back the start of a response until it knows whether to
+// compress it, which is when compression.min_bytes were written, the
+// handler flushes or it is done.
+type gzipWriter struct {
+	http.ResponseWriter
+	conf    *CompressionConfig
+	code    int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.minBytes() {
+			return len(b), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipWriter) minBytes() int {
+	if w.conf.MinBytes > 0 {
+		return w.conf.MinBytes
+	}
+	return defaultCompressionMinBytes
+}
+
+// decide sends the headers, compressing the rest of the response if large
+// is true and its status and content type allow it, then writes what was
+// held back.
+func (w *gzipWriter) decide(large bool) error {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	if large && w.code == http.StatusOK && h.Get("Content-Encoding") == "" && compressible(w.conf, h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		level := w.conf.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		w.gz, _ = gzip.NewWriterLevel(w.ResponseWriter, level)
+	}
+	if w.code != 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.gz != nil {
+		_, err := w.gz.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// Flush sends what was written so far, compressed if the response can be,
+// as streams are worth compressing whatever their size.
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close ends the response, sending it as is if it is shorter than
+// compression.min_bytes.
+func (w *gzipWriter) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// compressHandler wraps h, compressing with gzip the large responses of
+// compression.content_types to clients that accept it, as dashboards reading
+// stats and exports over slow links.
+func compressHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := requestConfig(r)
+		if c == nil || c.Compression == nil || !c.Compression.Enabled {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == "HEAD" || r.Header.Get("Range") != "" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w, conf: c.Compression}
+		defer gw.close()
+		h.ServeHTTP(gw, r)
+	})
+}
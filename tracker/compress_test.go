@@ -0,0 +1,119 @@
+package tracker
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type compressTest struct {
+	old *Config
+}
+
+func newCompressTest(t *testing.T) *compressTest {
+	s := &compressTest{}
+	s.old = currentConfig()
+	setConfig(&Config{Compression: &CompressionConfig{Enabled: true, MinBytes: 100}})
+	t.Cleanup(func() {
+		setConfig(s.old)
+	})
+	return s
+}
+
+func (s *compressTest) request(acceptEncoding, contentType, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "/2/stats/versions", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	compressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		// Written in pieces, as by a json.Encoder.
+		for _, line := range strings.SplitAfter(body, "\n") {
+			w.Write([]byte(line))
+		}
+	})).ServeHTTP(w, req)
+	return w
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	newCompressTest(t)
+	for header, accepts := range map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, gzip":     true,
+		"GZIP;q=0.5":        true,
+		"gzip;q=0":          false,
+		"*":                 true,
+		"gzip;q=0, *":       false,
+		"deflate, identity": false,
+	} {
+		if got, want := acceptsGzip(header), accepts; got != want {
+			t.Errorf("%s: acceptsGzip(header) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestCompressLarge(t *testing.T) {
+	s := newCompressTest(t)
+	body := strings.Repeat(`{"version": "1.0", "sessions": 42}`+"\n", 20)
+	w := s.request("gzip", "application/json; charset=utf-8", body)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Content-Encoding"), "gzip"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Vary"), "Accept-Encoding"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Body.Len() < len(body), true; got != want {
+		t.Errorf("w.Body.Len() < len(body) = %v, want %v", got, want)
+	}
+	r, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), body; got != want {
+		t.Errorf("string(b) = %v, want %v", got, want)
+	}
+}
+
+func TestNotCompressed(t *testing.T) {
+	s := newCompressTest(t)
+	body := strings.Repeat("x", 200)
+	for _, tc := range []struct{ acceptEncoding, contentType, body string }{
+		{"", "application/json", body},
+		{"gzip", "application/json", "small\n"},
+		{"gzip", "image/png", body},
+	} {
+		w := s.request(tc.acceptEncoding, tc.contentType, tc.body)
+		if got, want := w.Header().Get("Content-Encoding"), ""; got != want {
+			t.Errorf("%+v: got %v, want %v", tc, got, want)
+		}
+		if got, want := w.Body.String(), tc.body; got != want {
+			t.Errorf("w.Body.String() = %v, want %v", got, want)
+		}
+	}
+
+	setConfig(&Config{})
+	w := s.request("gzip", "application/json", body)
+	if got, want := w.Header().Get("Content-Encoding"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Vary"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), body; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+}
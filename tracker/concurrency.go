@@ -0,0 +1,131 @@
+package tracker
+
+import (
+	"labix.org/v2/mgo"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultSampleInterval = 5 * time.Minute
+	// defaultConcurrencyWindow is the time window of the concurrency stats
+	// when from is not given.
+	defaultConcurrencyWindow = 7 * 24 * time.Hour
+)
+
+// concurrencyJob samples how many sessions are open, keeping the peak of
+// every hour in its concurrency rollup, for capacity planning.
+var concurrencyJob = &Job{
+	Name: "concurrency",
+	Interval: func(c *Config) time.Duration {
+		if c != nil && c.Rollups != nil && c.Rollups.SampleInterval.Duration > 0 {
+			return c.Rollups.SampleInterval.Duration
+		}
+		return defaultSampleInterval
+	},
+	Run: func(c *Context) (int, error) {
+		return sampleConcurrency(c.Store, time.Now())
+	},
+}
+
+// sampleConcurrency counts the open sessions, raising the peak of the
+// concurrency rollup of the hour of now if needed.
+func sampleConcurrency(store Storage, now time.Time) (int, error) {
+	open, err := store.CountAllOpenSessions()
+	if err != nil {
+		return 0, err
+	}
+	start := periodStart(RollupHour, now)
+	stored, err := store.Rollups(RollupConcurrency, start, periodNext(RollupHour, start))
+	if err != nil {
+		return 0, err
+	}
+	r := NewRollup(RollupConcurrency, start)
+	if len(stored) > 0 {
+		r = stored[0]
+	}
+	if open > r.PeakOpenSessions {
+		r.PeakOpenSessions = open
+	}
+	r.ComputedAt = now.UTC()
+	if err := store.UpsertRollup(r); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// concurrencyPeak is the most sessions open at once in an hour or a UTC day.
+type concurrencyPeak struct {
+	Start time.Time `json:"start"`
+	Peak  int       `json:"peak"`
+}
+
+// concurrencyStats are the sessions open now and at the peaks of a time window.
+type concurrencyStats struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Current int       `json:"current"`
+	// Peak is the highest of the peaks of Days.
+	Peak  int                `json:"peak"`
+	Days  []*concurrencyPeak `json:"days"`
+	Hours []*concurrencyPeak `json:"hours"`
+}
+
+// newConcurrencyStats builds the peaks of the UTC days and hours of
+// concurrency rollups, which are ordered by start.
+func newConcurrencyStats(from, to time.Time, current int, rollups []*Rollup) *concurrencyStats {
+	stats := &concurrencyStats{From: from, To: to, Current: current, Days: []*concurrencyPeak{}, Hours: []*concurrencyPeak{}}
+	for _, x := range rollups {
+		stats.Hours = append(stats.Hours, &concurrencyPeak{x.Start, x.PeakOpenSessions})
+		day := utcDay(x.Start)
+		if n := len(stats.Days); n == 0 || !stats.Days[n-1].Start.Equal(day) {
+			stats.Days = append(stats.Days, &concurrencyPeak{day, 0})
+		}
+		if d := stats.Days[len(stats.Days)-1]; x.PeakOpenSessions > d.Peak {
+			d.Peak = x.PeakOpenSessions
+		}
+		if x.PeakOpenSessions > stats.Peak {
+			stats.Peak = x.PeakOpenSessions
+		}
+	}
+	return stats
+}
+
+// ConcurrencyStatsHandler reports how many sessions are open now, and the
+// peaks of the hours and UTC days of a time window sampled by concurrencyJob.
+// It does not answer conditional requests, as sessions closing change the
+// current count without changing what statsModified looks at.
+func ConcurrencyStatsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	win, errs := parseWindow(r, now, defaultConcurrencyWindow, maxHourlyWindow)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	from, to := periodStart(RollupHour, win.From), periodStart(RollupHour, win.To)
+	if to.Before(win.To) {
+		to = periodNext(RollupHour, to)
+	}
+	current, err := c.Store.CountAllOpenSessions()
+	var rollups []*Rollup
+	if err == nil {
+		rollups, err = c.Store.Rollups(RollupConcurrency, from, to)
+	}
+	// The peaks are as fresh as the latest sample, stale when the job stopped.
+	var asOf time.Time
+	if err == nil {
+		var latest *Rollup
+		switch latest, err = c.Store.LatestRollup(RollupConcurrency); err {
+		case nil:
+			asOf = latest.ComputedAt
+		case mgo.ErrNotFound:
+			err = nil
+		}
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute concurrency stats"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	writeStats(w, newConcurrencyStats(from, to, current, rollups), freshness(asOf, c.Config, now))
+}
@@ -0,0 +1,751 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	_path "path"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	Http         *HttpConfig         `json:"http"`
+	Mongo        *MongoConfig        `json:"mongo"`
+	Admin        *AdminConfig        `json:"admin"`
+	Export       *ExportConfig       `json:"export"`
+	Ops          *OpsConfig          `json:"ops"`
+	Reaper       *ReaperConfig       `json:"reaper"`
+	Events       *EventsConfig       `json:"events"`
+	Feedback     *FeedbackConfig     `json:"feedback"`
+	Stats        *StatsConfig        `json:"stats"`
+	Sessions     *SessionsConfig     `json:"sessions"`
+	Limits       *LimitsConfig       `json:"limits"`
+	APIKeys      *APIKeysConfig      `json:"api_keys"`
+	Support      *SupportConfig      `json:"support"`
+	StorageStats *StorageStatsConfig `json:"storage_stats"`
+	Queue        *QueueConfig        `json:"queue"`
+	API          *APIConfig          `json:"api"`
+	CORS         *CORSConfig         `json:"cors"`
+	Rollups      *RollupsConfig      `json:"rollups"`
+	Retention    *RetentionConfig    `json:"retention"`
+	Compression  *CompressionConfig  `json:"compression"`
+	Alerts       *AlertsConfig       `json:"alerts"`
+	Cluster      *ClusterConfig      `json:"cluster"`
+	Cache        *CacheConfig        `json:"cache"`
+	Log          *LogConfig          `json:"log"`
+	Sentry       *SentryConfig       `json:"sentry"`
+	Abuse        *AbuseConfig        `json:"abuse"`
+	MachineIds   *MachineIdsConfig   `json:"machine_ids"`
+	Client       *ClientConfig       `json:"client"`
+	Privacy      *PrivacyConfig      `json:"privacy"`
+	Apps         *AppsConfig         `json:"apps"`
+	GRPC         *GRPCConfig         `json:"grpc"`
+}
+
+type HttpConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	// TrustedProxies lists addresses or CIDR ranges of reverse proxies
+	// allowed to set X-Forwarded-For and X-Real-IP.
+	TrustedProxies []string `json:"trusted_proxies"`
+	// RegionHeader is a header the trusted proxies set with the region of
+	// the client, like "CF-IPCountry", kept with new sessions for
+	// /1/stats/latency. It is dropped from requests of other peers.
+	RegionHeader string `json:"region_header"`
+
+	trustedNets []*net.IPNet
+}
+
+// GRPCConfig serves the gRPC interface of proto/tracker.proto at Host and
+// Port, besides the HTTP API. It is only read on startup.
+type GRPCConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// MongoConfig configures the connection to MongoDB. It is only read on startup.
+// Zero values take the mgo defaults.
+type MongoConfig struct {
+	URL string `json:"url"`
+	DB  string `json:"db"`
+	// Mode is the consistency of reads: "strong" (the default) reads from
+	// the primary, "monotonic" from a secondary until the first write and
+	// "eventual" from any member.
+	Mode string `json:"mode"`
+	// PoolLimit caps the sockets open to each server, 4096 by default.
+	PoolLimit int `json:"pool_limit"`
+	// SyncTimeout is how long an operation waits for a usable server, 7s by default.
+	SyncTimeout Duration `json:"sync_timeout"`
+	// SocketTimeout is how long to wait for a server to respond,
+	// limits.request_timeout by default.
+	SocketTimeout Duration `json:"socket_timeout"`
+	// MaxBackoff caps the wait between reconnection attempts, 1m by default.
+	MaxBackoff Duration `json:"max_backoff"`
+}
+
+// AdminConfig configures the administrative API.
+// Admin endpoints are disabled when no tokens are configured.
+type AdminConfig struct {
+	// Tokens are the secrets accepted in the X-Admin-Token header.
+	Tokens []AdminToken `json:"tokens"`
+	// ReopenWindow is how long after being closed a session can still be reopened.
+	ReopenWindow Duration `json:"reopen_window"`
+	// Debug serves the profiles of net/http/pprof under /admin/1/debug/pprof/
+	// and /admin/1/debug/runtime to operators. The MongoDB sockets are only
+	// counted when it is set on startup.
+	Debug bool `json:"debug"`
+}
+
+// Roles of admin tokens.
+const (
+	RoleOperator   = "operator"
+	RoleResearcher = "researcher"
+)
+
+// AdminToken is an admin secret, its role and the name of its holder.
+// In JSON it is either an object or just the secret, for an operator token.
+type AdminToken struct {
+	Token string `json:"token"`
+	Role  string `json:"role"`
+	// Name is who the audit collection records as the actor of requests
+	// with the token, its role without a name.
+	Name string `json:"name"`
+}
+
+func (t *AdminToken) UnmarshalJSON(b []byte) error {
+	var token struct {
+		Token string `json:"token"`
+		Role  string `json:"role"`
+		Name  string `json:"name"`
+	}
+	if err := json.Unmarshal(b, &token.Token); err != nil {
+		if err := json.Unmarshal(b, &token); err != nil {
+			return err
+		}
+	}
+	if token.Role == "" {
+		token.Role = RoleOperator
+	}
+	*t = AdminToken(token)
+	return nil
+}
+
+// ExportConfig configures data exports.
+type ExportConfig struct {
+	// Profiles maps admin token roles to export profiles.
+	// Roles not listed get the aggregate-only profile.
+	Profiles map[string]string `json:"profiles"`
+	// Salt keys the hashes of identifying fields in pseudonymized exports.
+	// Keep it secret and stable: hashes are consistent across exports only
+	// while the salt does not change.
+	Salt string `json:"salt"`
+}
+
+// OpsConfig describes the capacity of an instance for the /1/ops/load signal.
+type OpsConfig struct {
+	// CapacityRPS is the request rate an instance is expected to sustain.
+	CapacityRPS float64 `json:"capacity_rps"`
+	// LatencyTarget is the acceptable mean duration of a storage call.
+	LatencyTarget Duration `json:"latency_target"`
+	// MaxShedRate is the acceptable fraction of requests refused with 503.
+	MaxShedRate float64 `json:"max_shed_rate"`
+	// ScaleUpAt and ScaleDownAt are the load scores above and below which
+	// scaling up or down is recommended.
+	ScaleUpAt   float64 `json:"scale_up_at"`
+	ScaleDownAt float64 `json:"scale_down_at"`
+}
+
+// ReaperConfig configures the job closing sessions that stopped pinging.
+type ReaperConfig struct {
+	// ExpireAfter is how long after the last ping a session is closed as expired.
+	// The reaper is disabled when it is 0.
+	ExpireAfter Duration `json:"expire_after"`
+	// Interval is how often to look for expired sessions.
+	Interval Duration `json:"interval"`
+}
+
+// EventsConfig configures client telemetry events.
+type EventsConfig struct {
+	// MaxPerMinute caps the events accepted per session and minute.
+	MaxPerMinute int `json:"max_per_minute"`
+}
+
+// FeedbackConfig configures the feedback sent by users.
+type FeedbackConfig struct {
+	// MaxPerHour caps the feedback accepted per machine and hour.
+	MaxPerHour int `json:"max_per_hour"`
+}
+
+// PrivacyConfig configures what is stored about users.
+type PrivacyConfig struct {
+	// HashJIDs stores the jids of sessions with a keyed hash in place of
+	// the user, so that stats can be published without naming anybody.
+	HashJIDs bool `json:"hash_jids"`
+	// JIDSalt is the key of the hashes. Changing it splits every user in
+	// two, the jids stored before and after.
+	JIDSalt string `json:"jid_salt"`
+	// JIDSaltFile is a file holding the key instead, as written by a
+	// secret manager. It is read on startup and reloads.
+	JIDSaltFile string `json:"jid_salt_file"`
+
+	fileSalt string
+}
+
+// jidSalt returns the key of the hashes of jids.
+func (p *PrivacyConfig) jidSalt() string {
+	if p.JIDSalt != "" {
+		return p.JIDSalt
+	}
+	return p.fileSalt
+}
+
+// StatsConfig configures the statistics endpoints.
+type StatsConfig struct {
+	// StaleAfter is how old the newest data behind a response can be
+	// before the response is flagged as stale.
+	StaleAfter Duration `json:"stale_after"`
+	// Timezone is the time zone of the weekdays and hours of
+	// /1/stats/usage-by-hour, like America/Sao_Paulo, UTC by default.
+	Timezone string `json:"timezone"`
+}
+
+// SessionsConfig configures the tracking of sessions.
+type SessionsConfig struct {
+	// CloseSuperseded makes a new session close the sessions of the same
+	// jid and machine_id that are still open, as after a client crash.
+	CloseSuperseded bool `json:"close_superseded"`
+	// Signing is one of:
+	//   ""          plain close and ping requests, checked by machine_id only (the default),
+	//   "optional"  new sessions get a secret, and close and ping requests signed
+	//               with it are checked, while unsigned ones from old clients are accepted,
+	//   "required"  close and ping requests must be signed.
+	Signing string `json:"signing"`
+	// MinPingInterval is how long after a ping of a session its next ping
+	// is accepted. Faster pings, as from a client stuck in a loop, are
+	// refused with 429 without touching the database. 0, the default,
+	// accepts every ping.
+	MinPingInterval Duration `json:"min_ping_interval"`
+	// ResumeWindow is how long after being expired or closed by a crash
+	// report a session can be resumed by /session/resume. 0 takes the
+	// default of 10 minutes.
+	ResumeWindow Duration `json:"resume_window"`
+	// MaxOpenPerMachine is how many sessions of a machine_id can be open
+	// at once, so that a client stuck in a loop does not leak sessions.
+	// 0, the default, allows any.
+	MaxOpenPerMachine int `json:"max_open_per_machine"`
+	// OverMaxOpen is what a new session past max_open_per_machine does:
+	//   "close_oldest"  closes the oldest open sessions of the machine (the default),
+	//   "reject"        fails with 403 and code too_many_sessions.
+	OverMaxOpen string `json:"over_max_open"`
+	// IdFormat is the format of the ids of new sessions, one of:
+	//   "objectid"  24 hex digits, a MongoDB ObjectId (the default),
+	//   "uuid"      a random version 4 UUID,
+	//   "ulid"      a ULID, 26 characters sorted by creation time.
+	// ObjectIds are always accepted, for the sessions started before a
+	// switch of format.
+	IdFormat string `json:"id_format"`
+	// UnknownInstallation is what a new session of a machine_id without an
+	// installation does:
+	//   ""          starts, the default, leaving the session an orphan,
+	//   "reject"    fails with 403 and code installation_not_found,
+	//   "register"  starts and registers a stub installation, replaced
+	//               once the machine registers itself.
+	// See /admin/1/sessions/orphans for the sessions left orphans.
+	UnknownInstallation string `json:"unknown_installation"`
+	// MetadataKeys lists the keys clients can set in the metadata of their
+	// sessions with /session/update, instead of dosvox_program,
+	// screen_reader and tts_voice.
+	MetadataKeys []string `json:"metadata_keys"`
+}
+
+// Session signing modes.
+const (
+	SigningOptional = "optional"
+	SigningRequired = "required"
+)
+
+// Values of sessions.over_max_open.
+const (
+	OverMaxOpenCloseOldest = "close_oldest"
+	OverMaxOpenReject      = "reject"
+)
+
+// Values of sessions.unknown_installation.
+const (
+	UnknownInstallationReject   = "reject"
+	UnknownInstallationRegister = "register"
+)
+
+// LimitsConfig bounds the size of requests. Zero values take the defaults.
+type LimitsConfig struct {
+	// MaxBodyBytes bounds request bodies; larger ones are refused with 413.
+	MaxBodyBytes int `json:"max_body_bytes"`
+	// MaxHeaderBytes bounds request headers. It is only read on startup.
+	MaxHeaderBytes int `json:"max_header_bytes"`
+	// MaxInfoKeys bounds the keys of the dosvox_info and machine_info mappings.
+	MaxInfoKeys int `json:"max_info_keys"`
+	// RequestTimeout is the deadline of requests, 30s by default,
+	// past which they are answered with 503.
+	RequestTimeout Duration `json:"request_timeout"`
+	// RequestTimeouts overrides RequestTimeout for some paths,
+	// like "/admin/1/export/sessions", 0 for no deadline.
+	RequestTimeouts map[string]Duration `json:"request_timeouts"`
+}
+
+// APIKeysConfig configures the API keys of the client endpoints of API v1.
+// Keys are taken from Keys and from the api_keys collection, where they
+// are managed through the admin API.
+type APIKeysConfig struct {
+	// Mode is one of:
+	//   ""          keys are not checked (the default),
+	//   "grace"     requests without a key are accepted, for legacy clients,
+	//               but invalid and revoked keys are refused,
+	//   "required"  requests without a valid key are refused.
+	Mode string `json:"mode"`
+	// Keys are keys given in the configuration, which are revoked by removing them.
+	Keys []ConfigAPIKey `json:"keys"`
+	// MaxPerMinute caps the requests per minute of keys without their own limit, 0 for no cap.
+	MaxPerMinute int `json:"max_per_minute"`
+}
+
+// API key modes.
+const (
+	APIKeysGrace    = "grace"
+	APIKeysRequired = "required"
+)
+
+// ConfigAPIKey is an API key given in the configuration.
+type ConfigAPIKey struct {
+	Key          string `json:"key"`
+	Name         string `json:"name"`
+	MaxPerMinute int    `json:"max_per_minute"`
+	// App is the id of the app the key belongs to, empty for the default app.
+	App string `json:"app"`
+}
+
+// AppsConfig configures the client applications other than XMPPVOX, like
+// other DOSVOX tools, sharing the tracker. Each app has its own API keys
+// and its own database, so that its stats are kept apart, see appStore.
+type AppsConfig struct {
+	List []*AppConfig `json:"list"`
+}
+
+type AppConfig struct {
+	// Id names the app in API keys and in the app param of the admin API.
+	Id string `json:"id"`
+	// DB is the Mongo database of the app, mongo.db followed by an
+	// underscore and Id by default.
+	DB string `json:"db"`
+}
+
+// SupportConfig configures the claim codes support gives users, which link
+// an installation to a ticket. Zero values take the defaults.
+type SupportConfig struct {
+	// ClaimTTL is how long a claim code can be claimed, 24h by default.
+	ClaimTTL Duration `json:"claim_ttl"`
+	// DebugFor is how long the debug capture of a claimed installation lasts, 72h by default.
+	DebugFor Duration `json:"debug_for"`
+}
+
+// StorageStatsConfig configures the snapshots of collection stats behind
+// the trends of /admin/storage.
+type StorageStatsConfig struct {
+	// Interval is how often to take a snapshot, 24h by default.
+	Interval Duration `json:"interval"`
+}
+
+// RollupsConfig configures the rollups job. Zero values take the defaults.
+type RollupsConfig struct {
+	// Interval is how often to compute the rollups of the periods that
+	// ended, 10m by default.
+	Interval Duration `json:"interval"`
+	// Backfill is how far back the first run computes rollups, 90 days by
+	// default. Stats of older periods are computed from the sessions.
+	Backfill Duration `json:"backfill"`
+	// SampleInterval is how often to sample the open sessions for
+	// /1/stats/concurrency, 5m by default.
+	SampleInterval Duration `json:"sample_interval"`
+}
+
+// RetentionConfig bounds how long the data that grows with usage is kept,
+// so that the database does not outgrow a small server. Zero durations,
+// the default, keep the data forever.
+type RetentionConfig struct {
+	// SessionRequests is how long to keep the request data of sessions,
+	// which are kept without it.
+	SessionRequests Duration `json:"session_requests"`
+	Events          Duration `json:"events"`
+	// Crashes is how long to keep crash reports, and crash groups after
+	// they were last seen.
+	Crashes Duration `json:"crashes"`
+	// Interval is how often to apply the retention, 1h by default.
+	Interval Duration `json:"interval"`
+}
+
+// QueueConfig configures the on-disk queue of the installations and pings
+// accepted while MongoDB is unavailable. Zero values take the defaults.
+type QueueConfig struct {
+	// Path is the queue file. The queue is disabled when it is empty.
+	// It is only read on startup.
+	Path string `json:"path"`
+	// MaxEntries bounds the queue, 10000 by default. Writes are refused
+	// with 500, as without a queue, once it is full.
+	MaxEntries int `json:"max_entries"`
+	// ReplayInterval is how often to replay the queue, 30s by default.
+	ReplayInterval Duration `json:"replay_interval"`
+}
+
+// APIConfig configures the retirement of API v1, which is deprecated in
+// favor of v2.
+type APIConfig struct {
+	// V1Sunset is when v1 stops being served, announced in the Sunset
+	// header of its responses, if set.
+	V1Sunset time.Time `json:"v1_sunset"`
+	// DisableV1 refuses the requests to v1 with 410 once clients migrated.
+	DisableV1 bool `json:"disable_v1"`
+}
+
+// CORSConfig lets web pages of other origins call the read-only endpoints
+// of the API, such as /1/stats/versions, from the browser.
+// CORS is disabled when no origins are configured.
+type CORSConfig struct {
+	// AllowedOrigins are origins like "https://dashboard.example.org", or "*" for any.
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods are the methods allowed in preflight requests, GET by default.
+	AllowedMethods []string `json:"allowed_methods"`
+	// AllowedHeaders are the request headers allowed in preflight requests,
+	// X-API-Key by default.
+	AllowedHeaders []string `json:"allowed_headers"`
+	// MaxAge is how long browsers may cache a preflight response, 10m by default.
+	MaxAge Duration `json:"max_age"`
+}
+
+// CompressionConfig configures the gzip compression of responses to clients
+// sending Accept-Encoding: gzip. Compression is disabled unless Enabled is set.
+type CompressionConfig struct {
+	Enabled bool `json:"enabled"`
+	// MinBytes is the size from which responses are compressed, 1024 by
+	// default, as smaller ones gain little.
+	MinBytes int `json:"min_bytes"`
+	// ContentTypes are the media types compressed, by default
+	// application/json, text/csv, text/html and text/plain.
+	ContentTypes []string `json:"content_types"`
+	// Level is the gzip level, from 1, the fastest, to 9, the smallest,
+	// and 0 for the default of compress/gzip.
+	Level int `json:"level"`
+}
+
+// AlertsConfig configures the alerts sent to operators when thresholds are
+// crossed. Each threshold is disabled when 0, and alerts are checked only
+// when a notifier, like Email, is configured.
+type AlertsConfig struct {
+	// Interval is how often the thresholds are checked, 1m by default.
+	Interval Duration `json:"interval"`
+	// Cooldown is how long after an alert is sent the same alert is held
+	// back, 1h by default, so that an ongoing problem does not flood the
+	// operators.
+	Cooldown Duration `json:"cooldown"`
+	// ErrorRate is the fraction of the requests answered with 5xx between
+	// two checks past which an alert is sent, like 0.05.
+	ErrorRate float64 `json:"error_rate"`
+	// MinRequests is how many requests a check needs for ErrorRate to
+	// apply, 20 by default, so that a few errors at night do not alert.
+	MinRequests int `json:"min_requests"`
+	// NoSessionsFor alerts when no session was started for that long, like "1h".
+	NoSessionsFor Duration `json:"no_sessions_for"`
+	// StorageDownFor alerts when storage calls have been failing for that long, like "5m".
+	StorageDownFor Duration `json:"storage_down_for"`
+	// AnomalyThreshold alerts when the sessions started in the last hour
+	// drop, or the share of them closed by crashes rises, that many
+	// standard deviations past the usual at that hour, like 3.
+	AnomalyThreshold float64 `json:"anomaly_threshold"`
+	// AnomalyDays is how many days of the same hour make up the usual, 7
+	// by default.
+	AnomalyDays int `json:"anomaly_days"`
+	// AnomalyMinSessions is how many sessions an hour needs usually for
+	// AnomalyThreshold to apply, 10 by default, so that quiet hours do not alert.
+	AnomalyMinSessions int             `json:"anomaly_min_sessions"`
+	Email              *EmailConfig    `json:"email"`
+	Slack              *SlackConfig    `json:"slack"`
+	Telegram           *TelegramConfig `json:"telegram"`
+}
+
+// EmailConfig configures the delivery of alerts by email.
+type EmailConfig struct {
+	// SMTP is the host:port of the mail server, like "smtp.example.org:587".
+	SMTP string `json:"smtp"`
+	// Username and Password authenticate with the server, unless empty.
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// SlackConfig posts alerts to a Slack channel.
+type SlackConfig struct {
+	// WebhookURL is the URL of an incoming webhook of the channel,
+	// like "https://hooks.slack.com/services/...".
+	WebhookURL string `json:"webhook_url"`
+}
+
+// TelegramConfig sends alerts to a Telegram chat, as a bot that was added
+// to it.
+type TelegramConfig struct {
+	// BotToken is the token BotFather gave the bot.
+	BotToken string `json:"bot_token"`
+	// ChatID is the id of the chat, or the @username of a channel.
+	ChatID string `json:"chat_id"`
+}
+
+// ClusterConfig coordinates trackers serving from the same database, as
+// behind a load balancer.
+type ClusterConfig struct {
+	// Enabled makes each job run on one tracker at a time, the one holding
+	// its lease in storage, and counts rate limits in storage, so that they
+	// apply to all trackers together.
+	Enabled bool `json:"enabled"`
+}
+
+// CacheConfig configures a Redis cache of the state of sessions, so that
+// pings and closes of sessions already closed do not reach storage. The
+// Redis settings are only read on startup.
+type CacheConfig struct {
+	// Redis is the address of the Redis server, like "localhost:6379".
+	Redis    string `json:"redis"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+	// TTL is how long the state of a session is cached after it was last
+	// written, 1h by default.
+	TTL Duration `json:"ttl"`
+	// PingWriteInterval, if set, is how often at most the pings of an open
+	// session are written to storage, unless they report activity. It must
+	// be shorter than reaper.expire_after.
+	PingWriteInterval Duration `json:"ping_write_interval"`
+}
+
+// LogConfig configures the log. Only the level changes on reload.
+type LogConfig struct {
+	// Level is the least severe level logged: "debug", "info" (the
+	// default), "warn" or "error".
+	Level string `json:"level"`
+	// Output is "stderr" (the default), "file" or "syslog".
+	Output string `json:"output"`
+	// File is the path of the log with the "file" output. It is rotated
+	// when it grows past MaxSizeMB, 100 by default, keeping MaxBackups
+	// older files, 5 by default.
+	File       string `json:"file"`
+	MaxSizeMB  int    `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+	// Syslog is the address of a syslog server reached over UDP, like
+	// "logs.example.com:514", the local syslog by default.
+	Syslog string `json:"syslog"`
+	// SlowStorage logs the storage calls of the client API that take at
+	// least this long at the warn level, with the method and its duration.
+	// 0, the default, logs none.
+	SlowStorage Duration `json:"slow_storage"`
+}
+
+// SentryConfig configures the reports of panics to Sentry.
+type SentryConfig struct {
+	// DSN is the client key of the Sentry project, like
+	// "https://<key>@sentry.example.org/42".
+	DSN string `json:"dsn"`
+	// Environment tells the reports of this tracker apart, like "staging".
+	Environment string `json:"environment"`
+}
+
+// AbuseConfig enables the abuse job, which flags suspicious patterns in the
+// sessions created over Window, 24h by default, every Interval, 1h by default.
+type AbuseConfig struct {
+	Interval Duration `json:"interval"`
+	Window   Duration `json:"window"`
+	// MaxMachinesPerIP is how many machine ids may start sessions from an
+	// IP address over the window before it is flagged, 100 by default.
+	MaxMachinesPerIP int `json:"max_machines_per_ip"`
+	// MaxSessionsPerMachine is how many sessions a machine id may start
+	// over the window before it is flagged, 200 by default.
+	MaxSessionsPerMachine int `json:"max_sessions_per_machine"`
+}
+
+// MachineIdsConfig configures the checks of the machine_id param of client
+// requests, see parseMachineId. Machine ids are taken as they come unless
+// it is set.
+type MachineIdsConfig struct {
+	// Canonicalize rewrites MAC addresses and UUIDs in one spelling, so
+	// that a machine registers once whichever spelling its client sends.
+	// Machine ids stored before it is set keep their spelling.
+	Canonicalize bool `json:"canonicalize"`
+	// Formats lists the formats accepted among "mac", "uuid" and "other",
+	// for any other id. Other formats are refused with invalid_machine_id.
+	// Empty, the default, accepts any.
+	Formats []string `json:"formats"`
+}
+
+// ClientConfig has the settings XMPPVOX fetches from /1/config/client, so
+// that they can be tuned without a release of the client. Zero values are
+// left out, for the client to use its own defaults.
+type ClientConfig struct {
+	// PingInterval is how often clients ping their session, like "5m".
+	// It must be shorter than reaper.expire_after.
+	PingInterval Duration `json:"ping_interval"`
+	// ServerHost replaces the XMPP server clients connect to, like
+	// "xmpp.example.org", as when moving the server.
+	ServerHost string `json:"server_host"`
+	// MaxMessagesPerMinute throttles the messages clients send.
+	MaxMessagesPerMinute int `json:"max_messages_per_minute"`
+	// Overrides replace the settings above for the clients they match, in
+	// order, so that later overrides win.
+	Overrides []*ClientOverride `json:"overrides"`
+}
+
+// ClientOverride replaces the settings of ClientConfig that it sets for
+// the clients running a version from MinVersion to MaxVersion, both
+// included, on one of MachineIds, when each is given.
+type ClientOverride struct {
+	MinVersion           string   `json:"min_version"`
+	MaxVersion           string   `json:"max_version"`
+	MachineIds           []string `json:"machine_ids"`
+	PingInterval         Duration `json:"ping_interval"`
+	ServerHost           string   `json:"server_host"`
+	MaxMessagesPerMinute int      `json:"max_messages_per_minute"`
+}
+
+// Duration is a time.Duration that decodes from JSON strings like "24h".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// ConfigOpen opens a configuration file and returns a Config.
+func ConfigOpen(path string) (*Config, error) {
+	path, err := absPath(os.ExpandEnv(path))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	conf, err := configNew(f)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// configNew decodes JSON from a io.Reader into a Config.
+func configNew(raw io.Reader) (*Config, error) {
+	conf := &Config{}
+	err := json.NewDecoder(raw).Decode(conf)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// prepare computes the values derived from the settings of c.
+func (c *Config) prepare() error {
+	if c.Http != nil {
+		var err error
+		c.Http.trustedNets, err = parseTrustedProxies(c.Http.TrustedProxies)
+		if err != nil {
+			return err
+		}
+	}
+	if p := c.Privacy; p != nil && p.JIDSaltFile != "" {
+		data, err := ioutil.ReadFile(p.JIDSaltFile)
+		if err != nil {
+			return err
+		}
+		if p.fileSalt = strings.TrimSpace(string(data)); p.fileSalt == "" {
+			return fmt.Errorf("privacy.jid_salt_file %s is empty", p.JIDSaltFile)
+		}
+	}
+	return nil
+}
+
+var (
+	configMu sync.RWMutex
+	config   *Config
+)
+
+// currentConfig returns the configuration in effect for the process, for
+// what is not served by a Server, see requestConfig.
+// The returned Config must not be modified.
+func currentConfig() *Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return config
+}
+
+func setConfig(c *Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = c
+}
+
+// reloadConfig loads the configuration of src again and puts it in effect
+// with set, old being the one in effect. The HTTP address and the MongoDB
+// settings only change with a restart, so they are kept from old.
+func reloadConfig(src *configSource, old *Config, set func(*Config)) error {
+	conf, err := loadConfig(src)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		if old.Http != nil {
+			if conf.Http == nil {
+				conf.Http = &HttpConfig{}
+			}
+			if conf.Http.Host != old.Http.Host || conf.Http.Port != old.Http.Port {
+				logger.Warn("[config] changing the http address requires a restart")
+			}
+			conf.Http.Host, conf.Http.Port = old.Http.Host, old.Http.Port
+		}
+		if old.Mongo != nil && (conf.Mongo == nil || *conf.Mongo != *old.Mongo) {
+			logger.Warn("[config] changing the mongo settings requires a restart")
+		}
+		conf.Mongo = old.Mongo
+	}
+	if err := validateConfig(src, conf); err != nil {
+		return err
+	}
+	set(conf)
+	setLogLevel(conf.Log)
+	return nil
+}
+
+// absPath translates relative paths into absolute paths.
+func absPath(path string) (string, error) {
+	if _path.IsAbs(path) {
+		return path, nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return path, err
+	}
+	return _path.Join(wd, path), nil
+}
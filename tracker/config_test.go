@@ -0,0 +1,331 @@
+package tracker
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type configTest struct {
+	Path string
+}
+
+func newConfigTest(t *testing.T) *configTest {
+	s := &configTest{}
+	s.Path = filepath.Join(t.TempDir(), "config.json")
+	os.Setenv("ET_CONFIG", s.Path)
+	t.Cleanup(func() {
+		os.Unsetenv("ET_CONFIG")
+		setConfig(nil)
+	})
+	return s
+}
+
+func (s *configTest) write(t *testing.T, data string) {
+	if err := ioutil.WriteFile(s.Path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	s := newConfigTest(t)
+	s.write(t, `{
+		"http": {"host": "localhost", "port": 8080},
+		"mongo": {"url": "localhost", "db": "xmppvox"},
+		"admin": {"tokens": ["old"]}
+	}`)
+	conf, err := loadConfig(defaultConfigSource())
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(conf)
+	s.write(t, `{
+		"http": {"host": "example.com", "port": 9090, "trusted_proxies": ["127.0.0.1"]},
+		"mongo": {"url": "example.com", "db": "other"},
+		"admin": {"tokens": ["new", {"token": "other", "role": "researcher"}], "reopen_window": "1h"}
+	}`)
+	if err := reloadConfig(defaultConfigSource(), currentConfig(), setConfig); err != nil {
+		t.Fatal(err)
+	}
+	conf = currentConfig()
+	if got, want := conf.Admin.Tokens, ([]AdminToken{{Token: "new", Role: RoleOperator}, {Token: "other", Role: RoleResearcher}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("conf.Admin.Tokens = %v, want %v", got, want)
+	}
+	if got, want := conf.Admin.ReopenWindow.Duration, time.Hour; got != want {
+		t.Errorf("conf.Admin.ReopenWindow.Duration = %v, want %v", got, want)
+	}
+	if got, want := len(conf.Http.trustedNets), 1; got != want {
+		t.Errorf("len(conf.Http.trustedNets) = %d, want %d", got, want)
+	}
+	// Restart-only settings are kept
+	if got, want := conf.Http.Host, "localhost"; got != want {
+		t.Errorf("conf.Http.Host = %v, want %v", got, want)
+	}
+	if got, want := conf.Http.Port, 8080; got != want {
+		t.Errorf("conf.Http.Port = %v, want %v", got, want)
+	}
+	if got, want := *conf.Mongo, (MongoConfig{URL: "localhost", DB: "xmppvox"}); got != want {
+		t.Errorf("*conf.Mongo = %v, want %v", got, want)
+	}
+}
+
+func TestReloadInvalidKeepsCurrent(t *testing.T) {
+	s := newConfigTest(t)
+	old := &Config{Admin: &AdminConfig{Tokens: []AdminToken{{Token: "old", Role: RoleOperator}}}}
+	setConfig(old)
+	for _, data := range []string{
+		`{"admin": {"tokens": ["new"]}`,
+		`{"admin": {"reopen_window": "forever"}}`,
+		`{"http": {"trusted_proxies": ["localhost"]}}`,
+	} {
+		s.write(t, data)
+		if reloadConfig(defaultConfigSource(), currentConfig(), setConfig) == nil {
+			t.Errorf("%s: reloadConfig(defaultConfigSource()) is nil", data)
+		}
+		if got, want := currentConfig(), old; got != want {
+			t.Errorf("currentConfig() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	s := newConfigTest(t)
+	s.write(t, `{
+		"http": {"host": "localhost", "port": 8080},
+		"mongo": {"url": "localhost", "db": "xmppvox"}
+	}`)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o := newConfigOverrides(fs)
+	if err := fs.Parse([]string{"--http-port", "9090", "--admin-reopen-window", "2h"}); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"ET_HTTP_PORT":            "7070",
+		"ET_MONGO_URL":            "mongo.example.com",
+		"ET_HTTP_TRUSTED_PROXIES": "127.0.0.1, 10.0.0.0/8",
+		"ET_ADMIN_TOKENS":         `["secret", {"token": "other", "role": "researcher"}]`,
+		"ET_EXPORT_PROFILES":      `{"researcher": "aggregate-only"}`,
+		"ET_API_V1_SUNSET":        "2015-01-01T00:00:00Z",
+	}
+	conf, err := ConfigOpen(s.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Apply(conf, func(key string) string { return env[key] }); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Http.Host, "localhost"; got != want {
+		t.Errorf("conf.Http.Host = %v, want %v", got, want)
+	}
+	if got, want := conf.Http.Port, 9090; got != want {
+		t.Errorf("conf.Http.Port = %v, want %v", got, want)
+	} // flags take precedence over the environment
+	if got, want := conf.Http.TrustedProxies, ([]string{"127.0.0.1", "10.0.0.0/8"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("conf.Http.TrustedProxies = %v, want %v", got, want)
+	}
+	if got, want := *conf.Mongo, (MongoConfig{URL: "mongo.example.com", DB: "xmppvox"}); got != want {
+		t.Errorf("*conf.Mongo = %v, want %v", got, want)
+	}
+	if got, want := conf.Admin.Tokens, ([]AdminToken{{Token: "secret", Role: RoleOperator}, {Token: "other", Role: RoleResearcher}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("conf.Admin.Tokens = %v, want %v", got, want)
+	}
+	if got, want := conf.Admin.ReopenWindow.Duration, 2*time.Hour; got != want {
+		t.Errorf("conf.Admin.ReopenWindow.Duration = %v, want %v", got, want)
+	}
+	if got, want := conf.Export.Profiles, (map[string]string{RoleResearcher: ProfileAggregateOnly}); !reflect.DeepEqual(got, want) {
+		t.Errorf("conf.Export.Profiles = %v, want %v", got, want)
+	}
+	if got, want := conf.API.V1Sunset.Equal(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestOverridesInvalid(t *testing.T) {
+	newConfigTest(t)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o := newConfigOverrides(fs)
+	env := map[string]string{"ET_HTTP_PORT": "eighty", "ET_OPS_LATENCY_TARGET": "fast"}
+	err := o.Apply(&Config{}, func(key string) string { return env[key] })
+	if err, pattern := err, "invalid value for http.port: .*; invalid value for ops.latency_target: .*"; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("err = %v, want an error matching %q", err, pattern)
+	}
+}
+
+func TestNoFlagsOnCommandLine(t *testing.T) {
+	// Programs importing the package define their own flags.
+	for _, name := range []string{"config", "read-only", "mock", "http-port"} {
+		if f := flag.CommandLine.Lookup(name); f != nil {
+			t.Errorf("flag.CommandLine.Lookup(%q) = %v, want nil", name, f.Name)
+		}
+	}
+}
+
+func TestMissingFileWithoutExplicitPath(t *testing.T) {
+	s := newConfigTest(t)
+	os.Unsetenv("ET_CONFIG")
+	os.Setenv("ET_MONGO_DB", "fromenv")
+	defer os.Unsetenv("ET_MONGO_DB")
+	src := defaultConfigSource()
+	*src.overrides.path = s.Path
+	conf, err := loadConfig(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Mongo.DB, "fromenv"; got != want {
+		t.Errorf("conf.Mongo.DB = %v, want %v", got, want)
+	}
+	// An explicit path must exist
+	os.Setenv("ET_CONFIG", s.Path)
+	_, err = loadConfig(src)
+	if got, want := os.IsNotExist(err), true; got != want {
+		t.Errorf("os.IsNotExist(err) = %v, want %v", got, want)
+	}
+}
+
+func TestJIDSaltFile(t *testing.T) {
+	s := newConfigTest(t)
+	saltPath := filepath.Join(filepath.Dir(s.Path), "jid_salt")
+	if err := ioutil.WriteFile(saltPath, []byte("pepper\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s.write(t, `{"privacy": {"hash_jids": true, "jid_salt_file": "`+saltPath+`"}}`)
+	conf, err := loadConfig(defaultConfigSource())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conf.Privacy.jidSalt(), "pepper"; got != want {
+		t.Errorf("conf.Privacy.jidSalt() = %v, want %v", got, want)
+	}
+	if err := ioutil.WriteFile(saltPath, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = loadConfig(defaultConfigSource())
+	if err, pattern := err, "privacy.jid_salt_file .* is empty"; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("err = %v, want an error matching %q", err, pattern)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	newConfigTest(t)
+	conf := &Config{
+		Http:  &HttpConfig{Host: "localhost", Port: 8080},
+		Mongo: &MongoConfig{URL: "user:password@localhost:27017", DB: "xmppvox"},
+	}
+	if err := conf.Validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (&Config{}).validate(false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	newConfigTest(t)
+	conf := &Config{
+		Http:   &HttpConfig{Port: 424242, TrustedProxies: []string{"localhost"}},
+		Admin:  &AdminConfig{Tokens: []AdminToken{{Token: "", Role: RoleOperator}, {Token: "x", Role: "root"}}},
+		Export: &ExportConfig{Profiles: map[string]string{RoleResearcher: ProfilePseudonymized}},
+		Ops:    &OpsConfig{ScaleUpAt: 0.5, ScaleDownAt: 0.6},
+	}
+	err := conf.Validate()
+	if _, ok := err.(ConfigErrors); !ok {
+		t.Fatalf("got %T, want ConfigErrors", err)
+	}
+	if got, want := err.(ConfigErrors), (ConfigErrors{
+		"http.port must be between 1 and 65535, got 424242",
+		`http.trusted_proxies: invalid trusted proxy address "localhost"`,
+		"missing mongo section",
+		"admin.tokens[0] is empty",
+		`admin.tokens[1] has unknown role "root"`,
+		`export.profiles["researcher"] is pseudonymized, which requires export.salt`,
+		"ops.scale_down_at must be lower than ops.scale_up_at",
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("err.(ConfigErrors) = %v, want %v", got, want)
+	}
+	conf = &Config{
+		Http:  &HttpConfig{Port: 80},
+		Mongo: &MongoConfig{URL: "mongodb://", DB: "xmpp.vox", Mode: "nearest", PoolLimit: -1},
+	}
+	if err, pattern := conf.Validate(), `(?s).*mongo.url: missing host.*mongo.db "xmpp.vox" contains.*mongo.mode must be.*mongo.pool_limit.*`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.Validate() = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{CORS: &CORSConfig{AllowedOrigins: []string{"*", "https://dashboard.example.org", "dashboard.example.org"}}}
+	if err, pattern := conf.validate(false), `(?s).*cors.allowed_origins\[2\] must be "\*" or an origin like https://dashboard.example.org, got "dashboard.example.org"$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{MachineIds: &MachineIdsConfig{Formats: []string{"mac", "serial"}}}
+	if err, pattern := conf.validate(false), `(?s).*machine_ids.formats must be among \[mac uuid other\], got "serial"$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{Http: &HttpConfig{Port: 8080}, GRPC: &GRPCConfig{Port: 8080}}
+	if err, pattern := conf.validate(false), `(?s).*grpc.port must differ from http.port on the same host, got 8080 for both$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{Stats: &StatsConfig{Timezone: "Brasilia"}}
+	if err, pattern := conf.validate(false), `(?s).*stats.timezone must be a time zone like America/Sao_Paulo, got "Brasilia"$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{Http: &HttpConfig{Port: 80, RegionHeader: "CF-IPCountry"}}
+	if err, pattern := conf.validate(false), `(?s).*http.region_header requires http.trusted_proxies, which set it$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{Sessions: &SessionsConfig{IdFormat: "snowflake"}}
+	if err, pattern := conf.validate(false), `(?s).*sessions.id_format must be empty, "objectid", "uuid" or "ulid", got "snowflake"$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{Sessions: &SessionsConfig{UnknownInstallation: "create"}}
+	if err, pattern := conf.validate(false), `(?s).*sessions.unknown_installation must be empty, "reject" or "register", got "create"$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{Sessions: &SessionsConfig{MetadataKeys: []string{"screen_reader", "tts.voice"}}}
+	if err, pattern := conf.validate(false), `(?s).*sessions.metadata_keys must be lowercase letters, digits and underscores, got "tts.voice"$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{Alerts: &AlertsConfig{AnomalyThreshold: 3, AnomalyDays: 90}}
+	if err, pattern := conf.validate(false), `(?s).*alerts.anomaly_days must be between 0 and 28, got 90$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{Privacy: &PrivacyConfig{HashJIDs: true}}
+	if err, pattern := conf.validate(false), `(?s).*privacy.hash_jids requires privacy.jid_salt or privacy.jid_salt_file$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{Privacy: &PrivacyConfig{JIDSalt: "salt", JIDSaltFile: "/run/secrets/jid_salt"}}
+	if err, pattern := conf.validate(false), `(?s).*privacy.jid_salt and privacy.jid_salt_file exclude each other$`; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("conf.validate(false) = %v, want an error matching %q", err, pattern)
+	}
+	conf = &Config{
+		Reaper:   &ReaperConfig{ExpireAfter: Duration{15 * time.Minute}},
+		Sessions: &SessionsConfig{MinPingInterval: Duration{30 * time.Second}},
+		Client: &ClientConfig{PingInterval: Duration{15 * time.Minute}, Overrides: []*ClientOverride{
+			{PingInterval: Duration{10 * time.Second}, MinVersion: "1.10", MaxVersion: "1.9"},
+			nil,
+		}},
+	}
+	if got, want := conf.validate(false), (ConfigErrors{
+		"client.ping_interval must be shorter than reaper.expire_after, got 15m0s",
+		"client.overrides[0].ping_interval must not be shorter than sessions.min_ping_interval, got 10s",
+		"client.overrides[0].min_version must not be after max_version",
+		"client.overrides[1] is empty",
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("conf.validate(false) = %v, want %v", got, want)
+	}
+	conf = &Config{
+		Apps: &AppsConfig{List: []*AppConfig{{Id: "letravox"}, {Id: "LetraVox"}, {Id: "xmppvox"}, {Id: "letravox"}, nil}},
+		APIKeys: &APIKeysConfig{Keys: []ConfigAPIKey{
+			{Key: "a", App: "letravox"}, {Key: "b", App: "xmppvox"}, {Key: "c", App: "cartavox"},
+		}},
+	}
+	if got, want := conf.validate(false), (ConfigErrors{
+		`api_keys.keys[2].app is not in apps.list, got "cartavox"`,
+		`apps.list[1].id must be lowercase letters, digits and underscores, got "LetraVox"`,
+		`apps.list[2].id must not be "xmppvox", the default app`,
+		`apps.list[3].id "letravox" is repeated`,
+		"apps.list[4] is empty",
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("conf.validate(false) = %v, want %v", got, want)
+	}
+}
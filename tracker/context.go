@@ -0,0 +1,100 @@
+package tracker
+
+import (
+	"context"
+	"net/http"
+)
+
+type Context struct {
+	Store  Storage
+	Config *Config
+	// Role is the role of the admin token of the request, if any.
+	Role string
+	// Actor names the admin token of the request in the audit collection,
+	// see actor.
+	Actor string
+	// Project is the name of the API key of the request, if any.
+	Project string
+	// App is the id of the app the request is served for, from its API key
+	// or the app param of the admin API, empty for the default app.
+	App string
+	// Log tags the lines it logs with the id of the request.
+	Log *Logger
+	// Server is the server of the request or job, with its write queue and
+	// other state. It is nil when there is none, as in tests and tools.
+	Server *Server
+}
+
+// openStore returns the Storage used to serve a request or run a job, and a
+// func to release it, when there is no Server, as in tests.
+var openStore func() (Storage, func())
+
+// serverKey keys the Server of a request, see withServer.
+type serverKey struct{}
+
+// withServer makes the requests served by h use the storage, configuration
+// and state of s, so that each Server serves from its own.
+func withServer(h http.Handler, s *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serverKey{}, s)))
+	})
+}
+
+// requestServer returns the Server of r, or nil, see withServer.
+func requestServer(r *http.Request) *Server {
+	s, _ := r.Context().Value(serverKey{}).(*Server)
+	return s
+}
+
+// requestConfig returns the configuration in effect for r: that of its
+// Server, or that of the process without one.
+func requestConfig(r *http.Request) *Config {
+	if s := requestServer(r); s != nil {
+		return s.CurrentConfig()
+	}
+	return currentConfig()
+}
+
+// requestStore opens the Storage of r, that of its Server or openStore.
+func requestStore(r *http.Request) (Storage, func()) {
+	if s := requestServer(r); s != nil {
+		return s.OpenStore()
+	}
+	return openStore()
+}
+
+// actor returns who the audit collection records as the author of r: its
+// admin token, or the address of the client without one.
+func (c *Context) actor(r *http.Request) string {
+	if c.Actor != "" {
+		return c.Actor
+	}
+	return r.RemoteAddr
+}
+
+type contextualHandlerFunc func(http.ResponseWriter, *http.Request, *Context)
+
+func (h contextualHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv := requestServer(r)
+	config := requestConfig(r)
+	id := requestId(r)
+	w.Header().Set("X-Request-Id", id)
+	if !limitRequest(w, r, config) {
+		return
+	}
+	serve := func(w http.ResponseWriter) {
+		store, release := requestStore(r)
+		defer release()
+		store = writeAudit.Wrap(store, r.URL.Path)
+		if srv != nil && srv.cache != nil && config != nil && config.Cache != nil {
+			store = &cachedStore{Storage: store, cache: srv.cache, conf: config.Cache, held: srv.heldPings}
+		}
+		log := &Logger{id}
+		h(w, r, &Context{Store: newMeteredStore(store, metrics, config, log), Config: config, Log: log, Server: srv})
+	}
+	if d := requestTimeout(config, r.URL.Path); d > 0 {
+		serveWithTimeout(w, r, d, serve)
+		return
+	}
+	serve(w)
+}
@@ -0,0 +1,69 @@
+package tracker
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultCORSMaxAge = 10 * time.Minute
+
+var (
+	defaultCORSMethods = []string{"GET"}
+	defaultCORSHeaders = []string{"X-API-Key"}
+)
+
+// corsOrigin returns the value of the Access-Control-Allow-Origin header
+// for origin, or "" if origin is not allowed.
+func corsOrigin(conf *CORSConfig, origin string) string {
+	for _, allowed := range conf.AllowedOrigins {
+		switch {
+		case allowed == "*":
+			return "*"
+		case strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin):
+			return origin
+		}
+	}
+	return ""
+}
+
+// corsHandler wraps a read-only handler, letting the origins of
+// cors.allowed_origins call it from the browser, and answers preflight
+// OPTIONS requests.
+func corsHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var conf *CORSConfig
+		if c := requestConfig(r); c != nil && c.CORS != nil && len(c.CORS.AllowedOrigins) > 0 {
+			conf = c.CORS
+			w.Header().Add("Vary", "Origin")
+		}
+		preflight := r.Method == "OPTIONS"
+		if origin := r.Header.Get("Origin"); conf != nil && origin != "" {
+			if allowed := corsOrigin(conf, origin); allowed != "" {
+				w.Header().Set("Access-Control-Allow-Origin", allowed)
+				if preflight && r.Header.Get("Access-Control-Request-Method") != "" {
+					methods, headers, maxAge := conf.AllowedMethods, conf.AllowedHeaders, conf.MaxAge.Duration
+					if len(methods) == 0 {
+						methods = defaultCORSMethods
+					}
+					if len(headers) == 0 {
+						headers = defaultCORSHeaders
+					}
+					if maxAge == 0 {
+						maxAge = defaultCORSMaxAge
+					}
+					w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+					w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge/time.Second)))
+				}
+			}
+		}
+		if preflight {
+			w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,116 @@
+package tracker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type corsTest struct {
+	old *Config
+}
+
+func newCorsTest(t *testing.T) *corsTest {
+	s := &corsTest{}
+	s.old = currentConfig()
+	setConfig(&Config{CORS: &CORSConfig{
+		AllowedOrigins: []string{"https://dashboard.example.org"},
+		MaxAge:         Duration{time.Hour},
+	}})
+	t.Cleanup(func() {
+		setConfig(s.old)
+	})
+	return s
+}
+
+func (s *corsTest) request(method, origin string, header http.Header) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "/2/stats/versions", nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	w := httptest.NewRecorder()
+	corsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("stats\n"))
+	})).ServeHTTP(w, req)
+	return w
+}
+
+func TestAllowedOrigin(t *testing.T) {
+	s := newCorsTest(t)
+	w := s.request("GET", "https://dashboard.example.org", nil)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "stats\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Access-Control-Allow-Origin"), "https://dashboard.example.org"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Vary"), "Origin"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	w = s.request("OPTIONS", "https://dashboard.example.org", http.Header{"Access-Control-Request-Method": {"GET"}})
+	if got, want := w.Code, http.StatusNoContent; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), ""; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Access-Control-Allow-Methods"), "GET"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Access-Control-Allow-Headers"), "X-API-Key"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Access-Control-Max-Age"), "3600"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestOtherOrigin(t *testing.T) {
+	s := newCorsTest(t)
+	w := s.request("GET", "https://evil.example.com", nil)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Access-Control-Allow-Origin"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	w = s.request("OPTIONS", "https://evil.example.com", http.Header{"Access-Control-Request-Method": {"GET"}})
+	if got, want := w.Header().Get("Access-Control-Allow-Methods"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	s := newCorsTest(t)
+	setConfig(&Config{})
+	w := s.request("GET", "https://dashboard.example.org", nil)
+	if got, want := w.Header().Get("Access-Control-Allow-Origin"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Vary"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPreflightIsRouted(t *testing.T) {
+	newCorsTest(t)
+	req, _ := http.NewRequest("OPTIONS", "/2/stats/versions", nil)
+	req.Header.Set("Origin", "https://dashboard.example.org")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	w := httptest.NewRecorder()
+	APIHandler().ServeHTTP(w, req)
+	if got, want := w.Code, http.StatusNoContent; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Access-Control-Allow-Origin"), "https://dashboard.example.org"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
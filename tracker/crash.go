@@ -0,0 +1,120 @@
+package tracker
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Size limits of crash reports.
+const (
+	maxTracebackBytes    = 16 << 10
+	maxCrashContextBytes = 4 << 10
+)
+
+// Parts of tracebacks that vary between occurrences of the same crash.
+var tracebackNoise = []*regexp.Regexp{
+	regexp.MustCompile(`0x[0-9a-fA-F]+`),      // memory addresses
+	regexp.MustCompile(`, line \d+`),          // line numbers change between versions
+	regexp.MustCompile(`(?i)[a-z]:\\[^"]*\\`), // install directories on Windows
+	regexp.MustCompile(`[ \t]+`),              // whitespace
+}
+
+// crashSignature identifies a crash by its traceback, ignoring memory
+// addresses, line numbers and install paths, so that the same bug
+// reported from different machines and versions is grouped together.
+func crashSignature(traceback string) string {
+	normalized := strings.TrimSpace(traceback)
+	for _, re := range tracebackNoise {
+		normalized = re.ReplaceAllString(normalized, " ")
+	}
+	sum := sha1.Sum([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewCrashHandler records a crash report.
+func NewCrashHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"machine_id", "xmppvox_version", "traceback"}, "session_id", "context")
+	sessionIdHex := r.PostFormValue("session_id")
+	if sessionIdHex != "" && !validSessionId(c.Config, sessionIdHex) {
+		errs = append(errs, invalidSessionId("session_id", sessionIdHex))
+	}
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	traceback := r.PostFormValue("traceback")
+	if len(traceback) > maxTracebackBytes {
+		errs = append(errs, tooLong("traceback", "", len(traceback), maxTracebackBytes))
+	}
+	var context bson.M
+	if raw := r.PostFormValue("context"); raw != "" {
+		if len(raw) > maxCrashContextBytes {
+			errs = append(errs, tooLong("context", "", len(raw), maxCrashContextBytes))
+		} else if err := json.Unmarshal([]byte(raw), &context); err != nil {
+			errs = append(errs, &APIError{"invalid_json", "context", "", "Invalid JSON for context: expected null or an object"})
+		}
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	crash := &Crash{
+		MachineId:      machineId,
+		XMPPVOXVersion: r.PostFormValue("xmppvox_version"),
+		Context:        context,
+		At:             bson.Now(),
+	}
+	if sessionIdHex != "" {
+		crash.SessionId = SessionId(sessionIdHex)
+	}
+	signature := crashSignature(traceback)
+	if err := c.Store.RecordCrash(signature, traceback, crash); err != nil {
+		writeError(w, r, internalError("Failed to record crash"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if crash.SessionId != "" {
+		// The session crashed along with the client, and can be resumed.
+		s := &Session{Id: crash.SessionId, MachineId: crash.MachineId, ClosedReason: ClosedCrash}
+		switch err := c.Store.CloseSession(s); err {
+		case nil:
+			notifyWebhooks(c, WebhookSessionClose, s)
+		case mgo.ErrNotFound:
+		default:
+			c.Log.Error(err)
+		}
+	}
+	fmt.Fprintln(w, signature)
+}
+
+// CrashesHandler lists crash groups, most recently seen first.
+func CrashesHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	limit := 50
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 1000 {
+			writeError(w, r, invalidLimit(1000), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	groups, err := c.Store.CrashGroups(limit)
+	if err != nil {
+		writeError(w, r, internalError("Failed to list crashes"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if groups == nil {
+		groups = []*CrashGroup{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(groups)
+}
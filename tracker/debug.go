@@ -0,0 +1,127 @@
+package tracker
+
+import (
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"labix.org/v2/mgo"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// debugEnabled reports whether admin.debug is set.
+func debugEnabled(conf *Config) bool {
+	return conf != nil && conf.Admin != nil && conf.Admin.Debug
+}
+
+// requireDebug wraps h, answering 404 unless admin.debug is set, so that
+// the debug endpoints do not exist for a tracker that did not ask for them.
+func requireDebug(h contextualHandlerFunc) contextualHandlerFunc {
+	return requireAdmin(func(w http.ResponseWriter, r *http.Request, c *Context) {
+		if !debugEnabled(c.Config) {
+			writeError(w, r, notFound("Debug endpoints are disabled, see admin.debug"), http.StatusNotFound)
+			return
+		}
+		h(w, r, c)
+	})
+}
+
+// debugRoutes adds the debug endpoints of the admin API to a: the profiles
+// of net/http/pprof under /1/debug/pprof/ and the runtime report.
+func debugRoutes(a *mux.Router) {
+	for pattern, handler := range map[string]http.HandlerFunc{
+		"/1/debug/pprof/":        pprof.Index,
+		"/1/debug/pprof/cmdline": pprof.Cmdline,
+		"/1/debug/pprof/profile": pprof.Profile,
+		"/1/debug/pprof/symbol":  pprof.Symbol,
+		"/1/debug/pprof/trace":   pprof.Trace,
+	} {
+		a.Handle(pattern, requireDebug(serveDebug(handler))).Methods("GET")
+	}
+	// pprof.Index only serves named profiles under /debug/pprof/.
+	a.Handle("/1/debug/pprof/{profile}", requireDebug(func(w http.ResponseWriter, r *http.Request, c *Context) {
+		pprof.Handler(mux.Vars(r)["profile"]).ServeHTTP(w, r)
+	})).Methods("GET")
+	a.Handle("/1/debug/runtime", requireDebug(DebugRuntimeHandler)).Methods("GET")
+}
+
+func serveDebug(h http.HandlerFunc) contextualHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, c *Context) {
+		h(w, r)
+	}
+}
+
+// debugRuntime is a reading of the Go runtime and of the MongoDB driver.
+type debugRuntime struct {
+	Goroutines int         `json:"goroutines"`
+	Heap       *debugHeap  `json:"heap"`
+	Mongo      *debugMongo `json:"mongo"`
+}
+
+type debugHeap struct {
+	AllocBytes   uint64     `json:"alloc_bytes"`
+	SysBytes     uint64     `json:"sys_bytes"`
+	Objects      uint64     `json:"objects"`
+	GCRuns       uint32     `json:"gc_runs"`
+	LastGC       *time.Time `json:"last_gc"`
+	PauseTotalMs float64    `json:"pause_total_ms"`
+}
+
+// debugMongo counts the sockets and operations of the MongoDB driver.
+type debugMongo struct {
+	SocketsAlive int `json:"sockets_alive"`
+	SocketsInUse int `json:"sockets_in_use"`
+	MasterConns  int `json:"master_conns"`
+	SlaveConns   int `json:"slave_conns"`
+	SentOps      int `json:"sent_ops"`
+	ReceivedOps  int `json:"received_ops"`
+}
+
+// mgoStatsEnabled is set once the MongoDB driver collects its stats.
+var mgoStatsEnabled bool
+
+// enableMgoStats makes the MongoDB driver collect the stats of
+// /admin/1/debug/runtime. It is called on startup, since counts start from
+// zero when collection starts and would miss the sockets already open.
+func enableMgoStats(conf *Config) {
+	if debugEnabled(conf) {
+		mgo.SetStats(true)
+		mgoStatsEnabled = true
+	}
+}
+
+// DebugRuntimeHandler reports the goroutines, the heap and the MongoDB
+// sockets of the process, to tell what grows when ping volume spikes.
+// mongo is null unless admin.debug was set on startup.
+func DebugRuntimeHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	rep := &debugRuntime{
+		Goroutines: runtime.NumGoroutine(),
+		Heap: &debugHeap{
+			AllocBytes:   m.HeapAlloc,
+			SysBytes:     m.HeapSys,
+			Objects:      m.HeapObjects,
+			GCRuns:       m.NumGC,
+			PauseTotalMs: float64(m.PauseTotalNs) / float64(time.Millisecond),
+		},
+	}
+	if m.LastGC > 0 {
+		t := time.Unix(0, int64(m.LastGC))
+		rep.Heap.LastGC = &t
+	}
+	if mgoStatsEnabled {
+		s := mgo.GetStats()
+		rep.Mongo = &debugMongo{
+			SocketsAlive: s.SocketsAlive,
+			SocketsInUse: s.SocketsInUse,
+			MasterConns:  s.MasterConns,
+			SlaveConns:   s.SlaveConns,
+			SentOps:      s.SentOps,
+			ReceivedOps:  s.ReceivedOps,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,107 @@
+package tracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type debugTest struct {
+	old     *Config
+	handler http.Handler
+}
+
+func newDebugTest(t *testing.T) *debugTest {
+	s := &debugTest{}
+	s.old = currentConfig()
+	setConfig(&Config{Admin: &AdminConfig{Tokens: []AdminToken{{Token: testAdminToken, Role: RoleOperator}}, Debug: true}})
+	store := NewMemoryStore()
+	openStore = func() (Storage, func()) {
+		return store, func() {}
+	}
+	s.handler = APIHandler()
+	t.Cleanup(func() {
+		setConfig(s.old)
+	})
+	return s
+}
+
+func (s *debugTest) get(path, token string) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest("GET", path, nil)
+	if token != "" {
+		r.Header.Set("X-Admin-Token", token)
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestDebugRuntime(t *testing.T) {
+	s := newDebugTest(t)
+	w := s.get("/admin/1/debug/runtime", testAdminToken)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	var rep struct {
+		Goroutines int
+		Heap       map[string]interface{}
+		Mongo      *debugMongo
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := rep.Goroutines > 0, true; got != want {
+		t.Errorf("rep.Goroutines > 0 = %v, want %v", got, want)
+	}
+	if got, want := rep.Heap["alloc_bytes"].(float64) > 0, true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	// Stats were not enabled on startup.
+	if got := rep.Mongo; got != nil {
+		t.Errorf("rep.Mongo = %v, want nil", got)
+	}
+
+	if got, want := s.get("/admin/1/debug/runtime", "").Code, http.StatusForbidden; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	s := newDebugTest(t)
+	w := s.get("/admin/1/debug/pprof/", testAdminToken)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := strings.Contains(w.Body.String(), "goroutine"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	w = s.get("/admin/1/debug/pprof/goroutine?debug=1", testAdminToken)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := strings.HasPrefix(w.Body.String(), "goroutine profile:"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.get("/admin/1/debug/pprof/nope", testAdminToken).Code, http.StatusNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := requestTimeout(nil, "/admin/1/debug/pprof/profile"), requestTimeout(nil, "/admin/1/export/sessions"); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDebugDisabled(t *testing.T) {
+	s := newDebugTest(t)
+	setConfig(&Config{Admin: &AdminConfig{Tokens: []AdminToken{{Token: testAdminToken, Role: RoleOperator}}}})
+	for _, path := range []string{"/admin/1/debug/runtime", "/admin/1/debug/pprof/", "/admin/1/debug/pprof/heap"} {
+		w := s.get(path, testAdminToken)
+		if got, want := w.Code, http.StatusNotFound; got != want {
+			t.Errorf("%s: w.Code = %v, want %v", path, got, want)
+		}
+		if got, want := w.Body.String(), "Debug endpoints are disabled, see admin.debug\n"; got != want {
+			t.Errorf("w.Body.String() = %v, want %v", got, want)
+		}
+	}
+}
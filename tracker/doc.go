@@ -0,0 +1,1165 @@
+/*
+Package tracker is Elephant Tracker, the usage tracker of XMPPVOX: the
+API below, served by a Server, and the jobs and subcommands around it. The
+elephant-tracker command, in cmd/elephant-tracker, runs Main.
+
+API v1 documentation
+
+  HTTP_METHOD URL (params, ...)
+
+  POST /installation/new (machine_id, xmppvox_version, dosvox_info, machine_info)
+
+Registers a new XMPPVOX installation. All params must be non-empty strings.
+dosvox_info and machine_info can either be null or contain a JSON-encoded mapping
+of strings to strings, with at most 64 keys unless configured otherwise.
+Returns the machine_id. A stub installation registered for the sessions of an
+unknown machine_id, see sessions.unknown_installation, is replaced by the first
+registration of the machine.
+All invalid params are reported at once, one message per line, or as
+  {"errors": [{"code": ..., "field": ..., "key": ..., "message": ...}, ...]}
+when the request has an "Accept: application/json" header.
+
+  POST /1/installation/ping (machine_id, xmppvox_version)
+
+Records a heartbeat of the installation of machine_id, which XMPPVOX sends
+whether or not a session is open. The installation keeps when it was last_seen
+and the xmppvox_version it runs as current_version, so that installed but idle
+machines and the machines left on old versions can be counted. Responds 400
+with code installation_not_found when the installation is not registered.
+Returns the machine_id.
+
+  POST /1/installation/remove (machine_id[, reason])
+
+Marks the installation of machine_id as uninstalled, with the time and the
+reason the user gave, if any, as the uninstaller of XMPPVOX reports it.
+Uninstalled installations are kept, but no longer count as active in
+/1/stats/installations. Responds 400 with code installation_not_found when the
+installation is not registered.
+Returns the machine_id.
+
+  POST /session/new (jid, machine_id, xmppvox_version[, lang, xmpp_server])
+
+Registers a new XMPPVOX session. All params but lang and xmpp_server must be
+non-empty. xmpp_server is the host name of the XMPP server the client connected
+to, like "jabber.org", stored lowercased with the session for
+/1/stats/servers; otherwise the request fails with invalid_value.
+The jid must be a valid user@domain[/resource] as of RFC 6122, otherwise the
+request fails with invalid_jid. The session stores the bare jid, with the
+domain lowercased, and the resource apart, so the sessions of a user are
+counted together whichever resource they were started with.
+Returns the ID of the session in the first line of the response
+and might return a message in the next lines. The ID is in the format of
+sessions.id_format: 24 hex digits of an ObjectId, the default, a random
+UUID, like "6ba7b810-9dad-41d1-80b4-00c04fd430c8", or a ULID, like
+"01ARZ3NDEKTSV4RRFFQ69G5FAV", sorted by creation time. Clients must keep it
+as an opaque string. The session_id of the other calls is in that format or
+an ObjectId, for the sessions started before a switch of format; otherwise
+the request fails with invalid_session_id.
+Responds 403 with a message to display to the user when the jid, machine_id
+or xmppvox_version is blocked, in the language asked, see Languages.
+When sessions.close_superseded is set, the sessions of the same jid and machine_id
+still open, as left by a crash, are closed with closed_reason "superseded" and
+a line telling how many were closed follows the ID.
+When sessions.max_open_per_machine is set, a machine_id has at most that many
+sessions open. Past that, with sessions.over_max_open set to
+"close_oldest", the default, the oldest are closed with closed_reason
+"over_limit" and counted in that line, so that a client stuck in a loop does
+not leak sessions, and with "reject" the new session fails with 403 and code
+too_many_sessions and a message to display to the user.
+A machine_id without an installation starts sessions by default, left orphans
+as listed by /admin/1/sessions/orphans. With sessions.unknown_installation set
+to "reject" the new session fails with 403 and code installation_not_found
+and a message to display to the user, and with "register" it starts and a stub
+installation with the xmppvox_version and no dosvox_info or machine_info is
+registered for the machine.
+The X-Session-Alias response header has a short alias of the session, like
+"K7QX-4M2P", which does not identify the user and is safe to print in local
+logs and crash reports, or to read aloud to support.
+When sessions.signing is set, the X-Session-Secret response header has a
+secret of the session to sign its close and ping requests, see Signing.
+
+  POST /session/close (session_id, machine_id[, signature, timestamp])
+
+Closes an existing XMPPVOX session.
+The machine_id is required as a minimal security feature
+to prevent an attacker from closing arbitrary sessions.
+Returns the ID of the session.
+
+  POST /session/ping (session_id, machine_id[, messages_sent, messages_received, contacts_online, rtt_ms, signature, timestamp])
+
+Pings an existing open XMPPVOX session.
+The optional messages_sent and messages_received count the messages since
+the previous ping and are added up in the activity of the session, while
+contacts_online, how many contacts are online, replaces the previous figure.
+rtt_ms is the round trip to the XMPP server measured by the client, in
+milliseconds up to 60000, summed up in the activity of the session as
+"rtt": {"samples", "total_ms", "min_ms", "max_ms", "last_ms"} for
+/1/stats/latency.
+Counters are numbers not below 0, otherwise the ping fails with invalid_value.
+With sessions.min_ping_interval set, a ping sooner than that after the
+previous accepted ping of the session is refused with rate_limited, 429 and a
+Retry-After header, and does not refresh last_ping.
+Returns the ID of the session.
+
+  POST /session/transfer (session_id, machine_id, new_machine_id[, signature, timestamp])
+
+Moves an open session from machine_id, the machine holding it, to
+new_machine_id, for a user who carries on with the same session on another
+machine. The new machine is checked like that of /session/new: it fails with
+403 when blocked or, with sessions.over_max_open set to "reject", when it
+already has sessions.max_open_per_machine open sessions. Pings and the close of
+the session then come from new_machine_id. The session keeps a transfers list
+of the moves, with the from and to machines and the time of each.
+Returns the ID of the session.
+
+  POST /session/update (session_id, machine_id, metadata[, signature, timestamp])
+
+Sets the metadata of an open session, a JSON-encoded mapping of strings like
+  {"screen_reader": "NVDA 2014.1", "tts_voice": "Raquel", "dosvox_program": "cartavox"}
+telling the setup of the user, so that usage can be told apart by it. The
+keys are merged into those set before, and a key with an empty value is
+removed. Keys other than dosvox_program, screen_reader and tts_voice, or those
+of sessions.metadata_keys when given, fail with unknown_key, and the metadata
+is limited like dosvox_info. The update counts as a ping of the session. The
+metadata is listed with the sessions in the admin API and exported with them.
+Returns the ID of the session.
+
+  POST /session/resume (machine_id, jid[, lang])
+
+Resumes the latest session of jid on machine_id when it was closed as
+"expired" by the reaper or as "crash" by a crash report naming it, within
+sessions.resume_window (10m by default), so that XMPPVOX restarting quickly
+carries on with the same session rather than starting a new one.
+The jid is parsed like by /session/new and its resource ignored.
+The session is reopened and replied like by /session/new, with its ID and
+X-Session-Alias and X-Session-Secret headers, and sessions.max_open_per_machine
+applies as to a new session. Otherwise the request fails with
+not_resumable, and the client starts a new session.
+
+  POST /1/installation/claim (machine_id, code)
+
+Links the installation of machine_id to the support ticket of a claim code,
+like "K7QX-4M2P", which support creates with /admin/1/claims/new and the user
+enters in XMPPVOX. The code is matched like session aliases, and claimed once
+within support.claim_ttl (24h by default). Responds 400 with code
+claim_not_found when the code is unknown, expired or already used, or the
+installation is not registered. Claiming starts a debug capture of the machine
+for support.debug_for (72h by default): the requests to /session/new of the
+machine are logged with the ticket, and their X-Debug-Until response header
+tells XMPPVOX until when to keep its debug logs. Returns the ticket and, in
+the next line, the end of the capture, also in the X-Debug-Until header.
+
+  POST /1/crash/new (machine_id, session_id, xmppvox_version, traceback, context)
+
+Reports a crash of XMPPVOX. session_id and context are optional; context is a
+JSON object with details such as the screen reader in use, of at most 4KB.
+traceback is limited to 16KB.
+Crashes are grouped by a signature of the traceback that ignores memory addresses,
+line numbers and install paths, so the same bug reported from different versions and
+machines is counted once. The open session named by session_id, if any, is
+closed with closed_reason "crash". Returns the signature of the crash.
+
+  POST /1/event (machine_id, session_id, event, properties)
+
+Records a client telemetry event, such as the use of a feature. event is a name
+of up to 64 lowercase letters, digits, dots and underscores, like "speech.command".
+properties is optional, a JSON object of at most 2KB.
+Each session can send events.max_per_minute events per minute (30 by default);
+further events are refused with 429 and a Retry-After header.
+Returns the ID of the event.
+
+  POST /1/feedback (machine_id, session_id, rating, text)
+
+Records feedback a user sends from XMPPVOX, such as on the accessibility of a
+feature, without having to write an email. rating is a number from 1, the
+worst, to 5, the best. session_id and text are optional; text is trimmed and
+limited to 4KB. Each machine can send feedback.max_per_hour feedback per hour
+(5 by default); further feedback is refused with 429 and a Retry-After header.
+Returns the ID of the feedback.
+
+  GET /1/flags (machine_id, xmppvox_version)
+
+Returns whether each feature flag, managed with /admin/1/features/set, is on
+for machine_id running xmppvox_version, as a JSON object like
+  {"espeak_tts": true, "typing_notifications": false}
+XMPPVOX asks on startup and turns off the features not listed, so that a
+feature can be rolled out to a share of the machines and turned off remotely.
+machine_id is spelled as stored, see Machine ids, so that every spelling of a
+machine gets the same answer.
+
+  GET /1/config/client (machine_id, xmppvox_version)
+
+Returns the client settings of the client section of the configuration for
+machine_id running xmppvox_version, as a JSON object like
+  {"ping_interval": 300, "server_host": "xmpp.example.org", "max_messages_per_minute": 20}
+with ping_interval in seconds. Settings that are not configured are left out,
+for XMPPVOX to use its own defaults. client.overrides replace the settings
+for the versions from min_version to max_version and the machine_ids they
+list, in order, so that a setting can be tried on a few machines first.
+client.ping_interval must be shorter than reaper.expire_after, so that the
+sessions of clients pinging as told are not expired.
+
+  GET /1/update/check (xmppvox_version[, channel])
+
+Returns the latest release of XMPPVOX on channel, "stable" by default or
+"beta", which is also offered the stable releases, as published with
+/admin/1/releases/new, and whether it is newer than xmppvox_version, as
+  {"update_available": true,
+   "latest": {"version": "1.3", "channel": "stable", "url": ..., "notes": ..., "published_at": ...,
+              "artifacts": [{"platform": "win64", "url": ..., "sha256": ..., "size": ...}, ...]},
+   "download": "/1/update/download/1.3"}
+so that XMPPVOX can announce the update to its user. Versions are compared
+part by part, as for webhooks, and latest is null when there is no release.
+
+  GET /1/update/download/{version} (platform)
+
+Redirects with 302 to the artifact of the release for platform, like "win64",
+or to the url of the release when it has no artifact for platform, and counts
+the download, by the platform of the artifact or as "default" for the url of
+the release. The download counts of releases are listed by /admin/1/releases.
+No API key is needed, so that the link can be opened in a browser.
+
+  GET /1/ops/load
+
+Returns a JSON load signal for external autoscalers, computed over the last minute:
+  {"score": 0.42, "recommendation": "hold", "request_rate": 21,
+   "storage_latency_ms": 12.5, "shed_rate": 0}
+score is the highest of request_rate, storage_latency_ms and shed_rate relative to
+the capacity in the ops section of the configuration, capped to 1.
+recommendation is "up", "down" or "hold", with hysteresis around the thresholds.
+
+  GET /1/testvectors
+
+Returns protocol test vectors as JSON, for third-party clients to check their
+conformance: canonical requests, with the status and body the server responds,
+covering the endpoints above, crash signatures and error cases. They are
+generated by the server's own handlers, in order, against empty storage.
+Values that vary between runs, like session ids, are captured from the first
+line of a response body (see "capture") and written as {name} in later vectors.
+
+  GET /1/status
+
+Returns the runtime stats of the process as JSON:
+  {"started_at": ..., "uptime_seconds": 3600.5, "requests": 1234, "errors": 2,
+   "panics": 0, "last_storage_error": null, "storage_failing": false}
+errors counts 5xx responses, panics the requests whose handler panicked, see
+Errors, and last_storage_error is the time of the last failed storage call, if any.
+When queue.path is set, write_queue reports the write queue, see Write queue:
+  {"depth": 12, "max_entries": 10000, "enqueued": 40, "refused": 0,
+   "replayed": 27, "dropped": 1, "failed_replays": 3, "last_replay": ...}
+storage_calls measures the storage calls of the client API since the process
+started by Storage method, with calls that failed, other than not finding a
+document, counted in errors:
+  {"InsertSession": {"calls": 120, "errors": 2, "mean_ms": 3.1, "max_ms": 840}, ...}
+
+  GET /status
+
+Returns the build and state of the tracker as JSON:
+  {"started_at": ..., "uptime_seconds": 3600.5, "version": "1.4.0",
+   "commit": "4f76234", "storage": "mongodb", "open_sessions": 312,
+   "last_reaper_run": {"job": "reaper", "started_at": ..., ...}, "as_of": ...}
+version and commit are those of /version, storage is "mongodb" or, in mock
+mode, "memory", and last_reaper_run is null until the reaper ran. open_sessions and last_reaper_run are read from storage at as_of,
+at most 5 seconds before, so that frequent probes do not load the database.
+It supersedes /uptime, which tells the uptime as text.
+
+  GET /version
+
+Returns the build of the tracker as JSON:
+  {"version": "1.4.0", "commit": "4f76234", "build_date": "2026-10-14T12:00:00Z"}
+The version is a semantic version set at build time, "0.0.0-dev" otherwise,
+and commit and build_date are left out when not set. Every response has it in
+its Server header too, like "elephant-tracker/1.4.0", for bug reports to tell
+which build a client talked to.
+
+  GET /healthz
+
+Responds "ok", or 503 while storage is failing, that is, since a storage call
+failed and until one succeeds. Meant for load balancers and process supervisors.
+
+Probes can use HEAD, answered like GET without a body, on /, /uptime,
+/status, /version, /healthz and the GET endpoints under /1 and /2, like /1/status and
+the stats. HEAD on /1/update/download/{version} does not count a download. A request with a
+method that a path is not served with is answered with 405, code
+method_not_allowed and an Allow header listing the methods it is served with,
+and a request to a path that is not served with 404 and code not_found.
+
+Request bodies are limited to limits.max_body_bytes (64KB by default) and refused
+with 413 beyond that. POST parameters are limited to 512 bytes, except those with
+their own limits above, and so are the keys and values of dosvox_info and
+machine_info, which are also limited to 8KB and limits.max_info_keys keys.
+Headers are limited to limits.max_header_bytes (8KB by default).
+
+Requests not served within limits.request_timeout (30s by default) are
+answered with 503 and code timeout, to be retried later. limits.request_timeouts
+maps paths, as in "/1/session/new", to their own deadline, "0s" for none.
+Exports under /admin/1/export/ and /admin/1/sessions/tail have no deadline
+unless given one there.
+
+Errors
+
+Every error of the API, not only those of /installation/new, is a plain text
+message per line or, with an "Accept: application/json" header, a list of
+  {"code": ..., "field": ..., "key": ..., "message": ...}
+where code is stable and meant for clients to pick a localized message, field
+is the param at fault, if any, and key the key of a JSON mapping within it.
+Messages are in English and may change. The codes are:
+
+  missing_param, unexpected_param, too_long, invalid_value
+  invalid_json, invalid_value_type, too_many_keys   (dosvox_info, machine_info, ...)
+  invalid_session_id, session_not_found             (400)
+  invalid_jid                                       (400, /session/new and /session/resume)
+  invalid_machine_id                                (400, see Machine ids)
+  already_registered                                (400, /installation/new)
+  blocked                                           (403, field is the blocked param)
+  too_many_sessions                                 (403, /session/new, see sessions.over_max_open)
+  installation_not_found                            (400, or 403 on /session/new, see sessions.unknown_installation)
+  invalid_event                                     (400, /event)
+  unknown_key                                       (400, /session/update, key is the unknown key)
+  invalid_claim_code, claim_not_found               (400, /installation/claim)
+  missing_api_key, invalid_api_key, revoked_api_key (401, see API keys)
+  missing_signature, invalid_signature              (403, see Signing)
+  missing_timestamp, stale_signature                (403, see Signing)
+  rate_limited                                      (429, with Retry-After)
+  timeout                                           (503, see limits.request_timeout)
+  api_disabled                                      (410, see Versions)
+  read_only                                         (503, see Read-only mode)
+  body_too_large                                    (413)
+  not_found, method_not_allowed                     (404 and 405, with Allow)
+  internal_error                                    (500)
+
+Admin endpoints also use forbidden (403), not_found (404), invalid_limit,
+invalid_window, invalid_range (416), not_reopenable, invalid_alias,
+invalid_block_id, block_not_found, invalid_api_key_id, api_key_not_found,
+invalid_webhook_id, webhook_not_found, invalid_flag_id, flag_not_found,
+flag_reviewed, invalid_tag, installation_not_found, invalid_query, unauthorized
+and invalid_csrf_token.
+
+A request whose handler panics is answered with 500 and code internal_error,
+unless its response had started. The panic is logged at the error level with
+its stack and the request id, counted in the panics of /1/status and, with
+sentry.dsn set to the DSN of a Sentry project, reported to Sentry with the
+method and path of the request, tagged with sentry.environment, if any.
+
+Signing
+
+Old clients identify a session by its id and machine_id alone. With
+sessions.signing set to "optional" or "required", /session/new also returns a
+secret in the X-Session-Secret header, and close, ping, transfer and update requests can be
+signed with a signature param, the hex HMAC-SHA256 keyed by the secret of
+  <call>\n<params>
+where call is "/session/close", "/session/ping", "/session/transfer" or "/session/update" and params are the other
+POST params URL-encoded and sorted by name, like
+  machine_id=00%3A26%3Acc%3A18%3Abe%3A14&session_id=5373a0c5e4b0d0a4f7e5c1a2&timestamp=1401537600
+Signed requests have a timestamp param, the Unix time in seconds they were
+signed at, and are refused with stale_signature when it is more than 5 minutes
+away from the clock of the tracker, so that an overheard request cannot be
+replayed later, or with missing_timestamp without one.
+A wrong signature is always refused. Unsigned requests are accepted in the
+"optional" mode, which keeps old clients working, and refused in the
+"required" mode. Plain mode, the default, ignores signatures.
+
+Languages
+
+Messages that XMPPVOX speaks to its user, like those of blocks, are stored in
+pt-BR and optionally in other languages. They are given in the language of the
+lang param, a language tag like "en", or else of the Accept-Language header, by
+quality. A language matches its own tag and then its primary language, so that
+"pt-PT" gets the pt-BR message, before the next language is tried; when none
+matches, the pt-BR message is given. The Content-Language response header tells
+the language of the message. A lang that is not a language tag is refused with
+invalid_value.
+
+Machine ids
+
+The machine_id of client requests is any string unless the machine_ids section
+is set. machine_ids.formats lists the formats accepted among "mac", for MAC
+addresses, "uuid", for UUIDs, and "other", for any other string; a machine_id
+of another format is refused with invalid_machine_id. With
+machine_ids.canonicalize, MAC addresses, separated by colons, hyphens or dots
+or not at all, are rewritten as lowercase pairs separated by colons, as in
+"00:26:cc:18:be:14", and UUIDs, with or without hyphens or braces, as lowercase
+hyphenated groups, so that a machine keeps one id whichever spelling it sends.
+Machine ids given to admin endpoints and blocks are canonicalized likewise,
+while those stored before are left as they are.
+
+Hashed jids
+
+With privacy.hash_jids set, new sessions store their jid with the user replaced
+by a keyed hash of the bare jid, 32 lowercase hex digits, keeping the domain, as
+in "5d41402abc4b2a76b9719d911017c592@server.org", so that stats and exports can
+be published without naming users. The key is privacy.jid_salt, or the content
+of privacy.jid_salt_file, as written by a secret manager or KMS agent; it can
+also be given as ET_PRIVACY_JID_SALT. Changing the key makes every user look
+new. Blocks, erasures and the sessions of users are given the plain jid and
+hash it, or the hashed jid itself; search hashes a jid without wildcards,
+while wildcards match the stored value, so "jid:*@server.org" still works.
+The resource of the jid is not stored then, as it often names the device.
+Sessions stored before are left as they are.
+
+Write queue
+
+With queue.path set, installations and pings that fail because MongoDB is
+unavailable are appended to that file, synced to disk, and acknowledged as if
+stored. The write_queue job replays them, oldest first, once MongoDB is back,
+and queued writes survive restarts. Replayed pings refresh last_ping to when
+they were queued, unless the session was pinged later. Writes rejected on replay, such as installations registered meanwhile
+or pings of sessions closed meanwhile, are dropped. The queue holds at most
+queue.max_entries writes (10000 by default); past that, writes fail with 500
+as without a queue. Signed pings still fail while MongoDB is unavailable,
+since their session secret cannot be read.
+
+Versions
+
+The endpoints under /1 are also served under /2, where changes breaking the
+clients of v1 will go. v1 is deprecated: its responses have a
+"Deprecation: true" header and a Link header to the same endpoint in v2,
+  Link: </2/session/new>; rel="successor-version"
+and, when api.v1_sunset is set, a Sunset header with that time. Once clients
+migrated, api.disable_v1 refuses the requests to v1 with 410 and code
+api_disabled. Both settings apply on reload.
+
+CORS
+
+Web pages of the origins in cors.allowed_origins, like
+"https://dashboard.example.org" or "*" for any, can call the GET endpoints
+of the API, such as /1/status and /1/stats/versions, from the browser.
+Preflight OPTIONS requests are answered with cors.allowed_methods (GET by
+default), cors.allowed_headers (X-API-Key by default) and cors.max_age (10m
+by default). CORS is disabled when no origins are configured, and the admin
+API is never open to other origins.
+
+Alerts
+
+The alerts job of each instance checks the thresholds of alerts:
+
+  error_rate        more than alerts.error_rate of the requests served since the
+                    last check, like 0.05, failed with 5xx, out of at least
+                    alerts.min_requests (20 by default).
+  no_sessions       no session was started for alerts.no_sessions_for, like "1h".
+  storage_down      storage calls have been failing for alerts.storage_down_for,
+                    like "5m".
+  sessions_drop     the sessions started in the last whole hour are
+                    alerts.anomaly_threshold standard deviations, like 3, below
+                    the mean of the same hour over the last alerts.anomaly_days
+                    (7 by default), as when an XMPP server is down for everyone.
+  crash_spike       the share of the sessions closed by a crash report in the
+                    last whole hour is as many standard deviations above the
+                    mean share of the same hours.
+
+The anomaly rules read the hourly rollups, see /1/stats/sessions, and leave
+out the hours with usually fewer than alerts.anomaly_min_sessions sessions (10
+by default), whose counts are too noisy to tell. With apps, see Apps, the rules on sessions,
+no_sessions and the anomaly ones, are checked for each app on its own, and their
+alerts name it, like "no_sessions (letravox)". Thresholds are disabled
+unless set. Once an alert is sent, the same alert is
+held back for alerts.cooldown (1h by default), and a resolved notice follows
+when its threshold is not crossed anymore. Alerts are emailed to alerts.email.to
+from alerts.email.from through the SMTP server at alerts.email.smtp, which is
+authenticated with alerts.email.username and password when given. For teams
+without a mail server, alerts.slack.webhook_url posts them to the Slack channel
+of an incoming webhook, and alerts.telegram sends them to chat_id, a chat or a
+@channel, as the bot of bot_token. Every configured notifier gets every alert,
+and an alert no notifier delivered is sent again at the next check.
+
+Compression
+
+With compression.enabled set, responses of compression.content_types
+(application/json, text/csv, text/html and text/plain by default) of at least
+compression.min_bytes (1024 by default) are compressed with gzip for clients
+sending Accept-Encoding: gzip, at compression.level (1 to 9, the gzip default
+when 0). Responses then carry Vary: Accept-Encoding, so that caches keep
+the compressed and plain ones apart.
+
+API keys
+
+Public deployments can require clients to send an API key in the X-API-Key
+header of the POST endpoints above and those under /1/stats, as set by api_keys.mode:
+
+  ""        keys are not checked (the default).
+  grace     requests without a key are accepted, with a Warning header,
+            so that legacy clients keep working, but invalid and revoked
+            keys are refused. Switch to required once the keyless_requests
+            of GET /admin/1/api_keys stop growing.
+  required  requests without a valid key are refused with 401.
+
+Keys are listed in api_keys.keys, and revoked by removing them from there,
+or created and revoked with the admin API. Each key is limited to its own
+max_per_minute requests, or api_keys.max_per_minute, with 429 past that.
+
+Apps
+
+Other DOSVOX tools can share the tracker with XMPPVOX, as apps listed in
+apps.list. A request is served for the app of its API key, the app of its
+entry in api_keys.keys or the app it was created for with the admin API, and
+its installations and sessions are stored with the app_id of the app. Each
+app has its own database, apps.list[].db or mongo.db followed by "_" and the
+id of the app, so that stats and jobs never mix the data of different apps.
+Requests without a key, or with a key of no app, go to the default app,
+xmppvox, whose database is mongo.db, so that the clients of v1 keep working
+unchanged.
+
+Statistics
+
+Statistics and dashboard responses are JSON objects of the form
+  {"data_as_of": "2014-05-01T12:00:00Z", "stale": false, "data": ...}
+data_as_of is the time of the newest document or rollup the response was computed
+from, and stale is set when it is older than stats.stale_after (1h by default).
+They are repeated in the X-Data-As-Of and X-Data-Stale response headers.
+Stats responses have a weak ETag and a Last-Modified header, which change with
+the newest session or installation created and the latest rollup computed.
+Requests sending them back in If-None-Match or If-Modified-Since get 304 Not
+Modified without the stats being computed again, so that dashboards can poll
+cheaply. Windows relative to now are only revalidated against that data.
+
+  GET /1/stats/versions (from, to, range, tz)
+
+Counts sessions and installations per xmppvox_version and UTC day, to follow how
+quickly users upgrade after a release, over a time window as described below.
+to defaults to now and from to 30 days before to, and the window is at most
+366 days. data is of the form
+  {"from": ..., "to": ..., "days": ["2014-05-01", "2014-05-02", ...],
+   "versions": [{"version": "1.0", "sessions": 12, "installations": 3,
+                 "daily_sessions": [5, 7, ...], "daily_installations": [1, 2, ...]}, ...]}
+with a count for each of days in the daily lists.
+
+  GET /1/stats/platforms (from, to, range, tz)
+
+Counts the installations created over a time window, as for /1/stats/versions,
+by operating system and architecture, from the system, release and machine
+keys of machine_info, which are parsed on /installation/new and kept as sent.
+data is of the form
+  {"from": ..., "to": ..., "systems": [{"system": "Windows", "release": "7", "installations": 12}, ...],
+   "machines": [{"machine": "AMD64", "installations": 9}, ...],
+   "platforms": [{"system": "Windows", "release": "7", "machine": "AMD64", "installations": 8}, ...]}
+with the most common first. Empty fields are left out and mean unknown.
+
+  GET /1/stats/dosvox-versions (from, to, range, tz)
+
+Counts the installations per DOSVOX version, the version key of dosvox_info,
+for the maintainers of DOSVOX to decide when to drop compatibility with old
+versions. Since installations are registered once, the window is open at the
+start unless from or range is given. data is of the form
+  {"from": ..., "to": ..., "versions": [{"version": "4.0", "installations": 3}, ...]}
+ordered by version, with "" for installations without a DOSVOX version.
+
+  GET /1/stats/installations (from, to, range, tz)
+
+Counts the installations created over a time window, open at the start as for
+/1/stats/dosvox-versions, by machine_id and by fingerprint, since reinstalling
+Windows registers the same machine again with a new machine_id. The
+fingerprint is a hash of the node, processor and system keys of machine_info,
+computed on /installation/new; installations without all of them count as a
+machine each. uninstalled counts the installations reported by
+/1/installation/remove, and active the machines with an installation that was
+not, for adoption numbers that leave out those who gave up. data is of the form
+  {"from": ..., "to": ..., "machines": 120, "fingerprints": 97, "reinstalls": 23,
+   "uninstalled": 11, "active": 88}
+
+  GET /1/stats/sessions (period, from, to, range, tz)
+
+Reports, per hour or UTC day as period is "hour" or "day" (the default), how
+many sessions were started and closed, how many of those were closed by a
+crash report, and the distinct jids and machines of the sessions started. The
+window is widened to whole periods, and hourly stats are limited to 31 days. data is of the form
+  {"from": ..., "to": ..., "period": "day",
+   "rollups": [{"start": ..., "sessions_started": 12, "sessions_closed": 11,
+                "sessions_crashed": 1, "unique_jids": 9, "unique_machines": 10}, ...]}
+with every period of the window. The periods stored by the rollups job are
+read from the rollups collection, and those after the first period not
+stored, like the current one, are computed from the sessions.
+
+  GET /1/stats/concurrency (from, to, range, tz)
+
+Reports how many sessions are open now, for capacity planning, along with
+the most sessions open at once in each hour and UTC day of the window, the
+last 7 days by default and up to 31 days, as sampled by the concurrency job.
+data is of the form
+  {"from": ..., "to": ..., "current": 42, "peak": 57,
+   "days": [{"start": ..., "peak": 57}, ...],
+   "hours": [{"start": ..., "peak": 12}, ...]}
+with peak the highest of the window. Hours without samples, as when the job
+was not running, are left out. data_as_of is the time of the latest sample.
+
+  GET /1/stats/usage-by-hour (from, to, range, tz)
+
+Counts the sessions started in each hour of each weekday, to schedule
+maintenance when nearly nobody is online. Weekdays and hours are in tz, which
+defaults to stats.timezone or else UTC, and the window, the last 4 weeks by
+default, is widened to whole hours and read from the hourly rollups like
+/1/stats/sessions. data is of the form
+  {"from": ..., "to": ..., "tz": "America/Sao_Paulo",
+   "weekdays": [{"weekday": "monday", "hours": [0, 1, 0, ..., 3]}, ...]}
+with the 7 weekdays from Monday and 24 hours each, from midnight. In time
+zones offset from UTC by a fraction of an hour, a UTC hour counts as the
+local hour it starts in.
+
+  GET /1/stats/servers (limit, from, to, range, tz)
+
+Counts the sessions started over a time window, as for /1/stats/versions, by
+the XMPP server their client connected to, the xmpp_server of /session/new, so
+that it is known which public servers the community depends on. data is of the
+form
+  {"from": ..., "to": ..., "servers": [{"server": "jabber.org",
+   "sessions": 1200, "unique_jids": 130, "unique_machines": 140}, ...]}
+with the limit (20 by default, up to 1000) servers with the most sessions
+first, and "" for the sessions of clients that do not tell their server.
+
+  GET /1/stats/latency (from, to, range, tz)
+
+Sums up the round trips to the XMPP server that clients reported with the
+rtt_ms of their pings, per region, to help debug complaints of XMPPVOX being
+slow, over the sessions started in the window, the last 7 days by default and
+up to 31 days. The region of a session is told by the trusted proxies in the
+http.region_header of /session/new, like "CF-IPCountry". data is of the form
+  {"from": ..., "to": ..., "regions": [{"region": "BR", "sessions": 80,
+   "samples": 2400, "mean_ms": 187.5, "p50_ms": 150, "p90_ms": 400,
+   "max_ms": 2100}, ...]}
+with the regions with the most sessions first, and "" for sessions of unknown
+region. mean_ms is over all samples, while p50_ms and p90_ms are percentiles
+of the mean of each session, so that long sessions do not outweigh the
+others. Conditional requests are not answered, as pings change the data.
+
+Time windows
+
+Stats, exports and the chart of the dashboard take the same parameters to
+restrict them to a window of time, which includes from and excludes to:
+
+  from, to  a date like "2014-05-01", midnight in tz, a RFC 3339 time, "now",
+            or a duration before now like "90m", "24h", "7d" or "2w".
+  range     instead of from and to, one of today, yesterday, this_week,
+            last_week, this_month or last_month in tz. Weeks start on Monday.
+  tz        a time zone like "America/Sao_Paulo", UTC by default.
+
+Invalid values are reported with code invalid_value and windows ending before
+they start, or too long, with code invalid_window.
+
+Admin API
+
+Admin endpoints require a X-Admin-Token header matching one of the tokens
+in the admin section of the configuration. They respond 403 otherwise.
+Tokens have a role: "operator" (the default) or "researcher". Only operators
+can change data; other roles can only export. The audit collection records the
+name of the token as the actor of a change, or its role for tokens without one.
+Admin endpoints serve the default app, or the app given in the app query param,
+see Apps, which is refused with 400 and code unknown_app when it is not in
+apps.list.
+
+  POST /admin/session/reopen (session_id, comment)
+
+Reopens a session closed within the configured admin.reopen_window (24h by default),
+clearing closed_at and closed_reason. comment is optional and is recorded,
+along with the previous closed_at and closed_reason, in the audit collection.
+Past sessions.max_open_per_machine with "close_oldest", the oldest other open
+sessions of the machine are closed as for a new session. Returns the ID of the
+session.
+
+  POST /admin/1/reload ()
+
+Reloads the configuration file, the same as sending a SIGHUP to the process.
+
+  POST /admin/1/blocks/new (field, value, message[, messages])
+
+Denies new sessions to the clients whose field, one of "jid", "machine_id",
+"xmppvox_version" or "remote_ip", the IP address the session is requested from,
+equals value. XMPPVOX displays message to the user. message is in pt-BR, and
+the optional messages is a JSON object of the message in other languages by
+language tag, like {"en": "Please upgrade XMPPVOX"}, of at most 4KB, see
+Languages. Returns the ID of the block.
+
+  POST /admin/1/blocks/remove (block_id)
+
+Lifts a block. Returns the ID of the block.
+
+  GET /admin/1/blocks
+
+Lists all blocks, oldest first, as JSON.
+Adding and removing blocks is recorded in the audit collection.
+
+  GET /admin/1/flags (status)
+
+Lists the flags raised by the abuse job, last seen first, as JSON:
+  [{"id": ..., "kind": "session_churn", "field": "machine_id", "value": ...,
+    "count": 250, "message": "250 sessions started on machine ... in 24h0m0s",
+    "created_at": ..., "seen_at": ..., "status": "open"}, ...]
+kind is "machines_per_ip", for an IP address that more than
+abuse.max_machines_per_ip machine ids started sessions from, "session_churn",
+for a machine id that started more than abuse.max_sessions_per_machine sessions,
+or "invalid_jid", for a jid stored before jids were validated, over the
+abuse.window before the run. Field and value can be blocked. Only open flags
+are listed unless status is "dismissed", "blocked" or "all".
+
+  POST /admin/1/flags/block (flag_id, message, comment)
+  POST /admin/1/flags/dismiss (flag_id, comment)
+
+Reviews an open flag: blocking adds a block of its field and value with message,
+returning the ID of the block, and dismissing, for legitimate patterns like a
+school behind one IP address, returns the ID of the flag. A flag found again
+keeps its status, with its count and seen_at updated. Responds 400 with code
+invalid_flag_id, flag_not_found or flag_reviewed. Reviews are recorded in the
+audit collection as flag.block and flag.dismiss.
+
+  POST /admin/1/api_keys/new (name, max_per_minute)
+
+Creates an API key, limited to max_per_minute requests when given.
+Returns the key, which is not shown again.
+
+  POST /admin/1/api_keys/revoke (api_key_id)
+
+Revokes an API key created with the admin API. Returns the ID of the key.
+
+  GET /admin/1/api_keys
+
+Lists the API keys, without the keys themselves, as JSON:
+  {"mode": "grace", "keyless_requests": 1234,
+   "configured": [{"name": ..., "max_per_minute": ...}, ...],
+   "stored": [{"id": ..., "name": ..., "max_per_minute": ..., "created_at": ..., "revoked_at": ...}, ...]}
+keyless_requests counts the requests accepted without a key since the server started.
+Creating and revoking keys is recorded in the audit collection.
+
+  POST /admin/1/webhooks/new (url, events, jid_domains, projects, min_version, max_version)
+
+Subscribes url to session events, delivered as a POST with a JSON body
+  {"event": "session.new", "at": ...,
+   "session": {"id": ..., "alias": ..., "jid": ..., "machine_id": ..., "xmppvox_version": ...,
+               "project": ..., "created_at": ..., "closed_at": ..., "closed_reason": ...}}
+and a X-Webhook-Signature header, "sha256=" followed by the hex HMAC-SHA256 of the
+body keyed by the secret of the webhook. Only the events passing every filter
+given are delivered, so that partners receive only events about their own users:
+  events       comma-separated, among session.new, session.close, session.reopen and
+               session.transfer.
+  jid_domains  comma-separated domains of the jid, like "server.org".
+  projects     comma-separated projects, the names of the API keys sessions were
+               started with.
+  min_version, max_version
+               bounds of the xmppvox_version, both included, compared part by
+               part so that 1.10 comes after 1.9.
+Deliveries are made in the background, once, with a timeout of 10 seconds.
+Sessions closed by the tracker, as expired, superseded or over_limit, are
+delivered as session.close too. Each tracker reads the webhooks at most once a
+minute, so those of another tracker of a cluster may take that long to change.
+Returns the ID of the webhook and, in the next line, its secret.
+
+  POST /admin/1/webhooks/remove (webhook_id)
+
+Unsubscribes a webhook. Returns the ID of the webhook.
+
+  GET /admin/1/webhooks
+
+Lists all webhooks, oldest first, without their secrets, as JSON.
+Adding and removing webhooks is recorded in the audit collection.
+
+  POST /admin/1/features/set (name, percent, min_version, max_version)
+
+Creates or replaces the feature flag name, up to 64 lowercase letters, digits,
+dots and underscores like "espeak_tts", on in /1/flags for percent of the
+machines, from 0 to 100, running an xmppvox_version from min_version to
+max_version, both included and optional, compared as for webhooks. Machines
+are placed in a rollout by a hash of the flag name and machine_id, so that a
+machine keeps the feature as percent grows. A percent of 0 turns the feature
+off for every machine. Returns the name.
+
+  POST /admin/1/features/remove (name)
+
+Removes a feature flag, which clients no longer receive. Returns the name.
+
+  GET /admin/1/features
+
+Lists all feature flags by name, as JSON.
+Setting and removing feature flags is recorded in the audit collection.
+
+  POST /admin/1/releases/new (version, url, channel, notes)
+
+Publishes a release of XMPPVOX for /1/update/check: the version, up to 32
+letters, digits, dots, dashes and pluses like "1.3" or "1.4-beta1", the http
+or https url to download it, the channel, "stable" by default or "beta", and
+release notes to show to users. Releases are stored in the releases
+collection, and a version is released once. Returns the version.
+
+  POST /admin/1/releases/artifacts/new (version, platform, url, sha256, size)
+
+Adds to a release its build for platform, up to 32 lowercase letters, digits,
+dashes and underscores like "win64", downloaded from url, with the hex SHA-256
+digest and the size in bytes of the file, if given, for clients to check the
+download. A release has one artifact per platform. Returns the version.
+
+  POST /admin/1/releases/remove (version)
+
+Withdraws a release, which clients are no longer offered. Returns the version.
+
+  GET /admin/1/releases
+
+Lists all releases, latest published first, as JSON, with their downloads
+counted by platform.
+Publishing and withdrawing releases is recorded in the audit collection.
+
+  POST /admin/1/claims/new (ticket)
+
+Creates a claim code for a support ticket, to give the user, as described in
+/1/installation/claim. Returns the code. Creating and claiming codes is
+recorded in the audit collection.
+
+  GET /admin/1/sessions/alias/{alias}
+
+Returns the session with an alias, as JSON. The alias is matched ignoring case,
+dashes and spaces, and letters that look like digits ("O" for "0", "I" and "L" for "1").
+
+  GET /admin/1/sessions/orphans (from, to, range, tz, limit)
+
+Lists the machines that started sessions over a time window without an
+installation, most sessions first, as JSON:
+  {"from": ..., "to": ..., "machines": [{"machine_id": ..., "sessions": 4,
+    "last_session_at": ...}, ...]}
+to follow the clients that skip /1/installation/new before setting
+sessions.unknown_installation to "reject". The window is the last 30 days by
+default, up to a year, and limit defaults to 100, up to 1000. Stub
+installations are registered, their machines are not listed.
+
+  GET /admin/1/sessions/tail (since, timeout)
+
+Streams the sessions created and closed from then on as newline-delimited
+JSON, one event per line in the format of webhook deliveries, oldest first:
+  {"event": "session.new", "at": ..., "session": {...}}
+  {"event": "session.close", "at": ..., "session": {..., "closed_reason": "expired"}}
+where at is when the session was created or closed. The response is chunked
+and stays open until the client goes away or, when given, the timeout, like
+5m, is over, so that scripts can follow the activity with
+  curl -N -H "X-Admin-Token: ..." https://tracker.example.org/admin/1/sessions/tail
+Events are polled from the storage every second, a couple of seconds behind.
+since, a time within the last hour as in Time windows, replays the events
+from then on, as for picking up after a timeout.
+
+  GET /admin/1/machines/{machine_id}/sessions (page, limit, tag)
+
+Lists the sessions of a machine, open or closed, newest first, as JSON, to
+follow reports of sessions that keep dropping:
+  [{"id": ..., "alias": "K7QX-4M2P", "jid": ..., "machine_id": ...,
+    "xmppvox_version": ..., "created_at": ..., "closed_at": ..., "closed_reason": ...,
+    "last_ping": ..., "duration": 600, "tags": ["beta-tester"], "notes": [...],
+    "metadata": {"screen_reader": ...}}, ...]
+where closed_at and last_ping are null until set, metadata is left out until
+set by /session/update, and duration is in seconds, until the session was
+closed or, while open, until its last ping. limit
+defaults to 50, up to 1000, and the Link header has the URLs of the previous
+and next pages, as in rel="next". tag only lists the sessions tagged with it.
+
+  GET /admin/1/users/{jid}/sessions (page, limit, tag, from, to, range, tz)
+
+Lists the sessions of a jid across machines, newest first, as JSON, like
+/admin/1/machines/{machine_id}/sessions, to follow problems of an account.
+The jid is normalized to the bare jid sessions store, when valid.
+from, to, range and tz select the sessions created within a window, as in
+Time windows, which is open at the start unless from or range is given.
+
+  GET /admin/1/search (q, page, limit)
+
+Finds sessions without access to the database, listing them newest first
+like /admin/1/machines/{machine_id}/sessions. q has space separated
+field:value terms, which all have to match, like
+  jid:*@server.org status:closed created:2014-05-01..2014-06-01
+The fields are jid, which takes * wildcards, machine_id, xmppvox_version,
+closed_reason, project, tag, status (open or closed), and created and closed,
+which take a day like 2014-05-01 or a range of times as in Time windows, in
+UTC, like 2014-05-01..2014-06-01, 7d.. or ..2014-06-01, including the start
+and excluding the end. Other fields, like those of the request, cannot be
+searched. Responds 400 with code invalid_query and the field as key.
+
+  GET /admin/1/installations (page, limit, tag)
+
+Lists the installations, newest first, as JSON, paged like the sessions of a
+machine, or with tag the installations tagged with it:
+  [{"machine_id": ..., "xmppvox_version": ..., "dosvox_version": ...,
+    "platform": {...}, "created_at": ..., "ticket": ..., "tags": [...], "notes": [...]}, ...]
+
+  GET /admin/1/feedback (page, limit)
+
+Lists the feedback sent with /1/feedback, newest first, as JSON, paged like
+the sessions of a machine:
+  [{"id": ..., "machine_id": ..., "session_id": ..., "rating": 4,
+    "text": "Reads the menus well", "created_at": ...}, ...]
+All of it can be exported from /admin/1/export/feedback.
+
+  GET /admin/1/stats/clients (by, limit, from, to, range, tz)
+
+Counts the installations created over a time window, as for /1/stats/versions,
+by the client that registered them, to spot automated fake registrations: by
+its User-Agent header with by=user_agent (the default) or by its network, the
+/24 of an IPv4 or the /48 of an IPv6 address, with by=network. /installation/new
+keeps the method, path, host, remote address and User-Agent and Accept headers
+of the request with the installation, which pseudonymized exports leave out
+along with the network. data is of the form
+  {"from": ..., "to": ..., "by": "network",
+   "clients": [{"value": "203.0.113.0/24", "installations": 120}, ...]}
+with the limit (20 by default, up to 1000) most common first, and "" for
+installations registered before requests were kept.
+
+  POST /admin/1/tags/add (session_id or machine_id, tag, comment)
+  POST /admin/1/tags/remove (session_id or machine_id, tag, comment)
+  POST /admin/1/notes/new (session_id or machine_id, text, comment)
+
+Tag a session or an installation, remove a tag, or attach a free-text note,
+for triage. Tags are up to 64 lowercase letters, digits, dots and dashes, like
+"beta-tester" or "reported-bug-42", and are kept once per document. Notes
+record who wrote them and when, like
+  {"text": "Drops every hour", "by": "10.0.0.1:51234", "at": ...}
+which is returned for a new note; tags return the tag. Responds 400 with code
+invalid_tag, session_not_found or installation_not_found. Annotations are
+recorded in the audit collection, as session.tag, installation.note and so
+on. Notes are removed when anonymizing and from pseudonymized exports, tags are kept.
+
+  DELETE /admin/1/users/{jid} (mode, comment)
+  DELETE /admin/1/machines/{machine_id} (mode, comment)
+
+Satisfies a data removal request for a jid, covering its sessions, their
+events, feedback and crash reports, or for a machine, covering its
+installation, sessions, events, feedback and crash reports. With mode "anonymize", the default, the
+jid is emptied or the machine id replaced with a pseudonym like
+"erased-5384c2a1e13823522e000001", and the request, alias, secret and
+resource of sessions, the properties of events, the text of feedback and the dosvox_info and machine_info of
+the installation are removed, keeping the rest, like the platform of the
+installation, for statistics. With mode "erase", the documents are removed. Either way, crash reports are removed
+from their groups, whose counts stay. Returns what was done, as JSON:
+  {"mode": "anonymize", "installations": 1, "sessions": 12, "events": 40,
+   "feedback": 1, "crash_groups": 2, "pseudonym": "erased-..."}
+The erasure is recorded in the audit collection, with the comment, as
+user.erase or machine.erase. A failed erasure can be retried to finish it.
+
+  GET /admin/1/crashes (limit)
+
+Lists crash groups, most recently seen first, with their signature, first traceback,
+count, first and last time seen, versions, and the latest 20 reports.
+limit defaults to 50.
+
+  GET /admin/jobs
+
+Lists the scheduled jobs, each with its interval, last run and status:
+"ok", "failing" (the last run failed), "overdue" (no run started for two intervals),
+"never_run" or "disabled". Every run is recorded in the job_runs collection.
+
+  GET /admin/jobs/{name} (limit)
+
+Lists the latest runs of a job, most recent first: when it started and ended,
+on which instance, its outcome ("ok" or "failed"), how many items it processed
+and its error, if any. limit defaults to 20.
+
+Jobs:
+
+  reaper   closes sessions not pinged for reaper.expire_after, every reaper.interval
+           (1m by default), with closed_reason "expired". Disabled unless
+           reaper.expire_after is set.
+  storage_stats
+           records the stats of every collection in storage_snapshots, every
+           storage_stats.interval (24h by default), for GET /admin/storage.
+  write_queue
+           replays the write queue every queue.replay_interval (30s by default).
+           Disabled unless queue.path is set.
+  rollups  stores in the rollups collection the rollups of the hours and UTC
+           days that ended, for /1/stats/sessions and /1/stats/usage-by-hour,
+           every rollups.interval (10m by default). The first run goes back
+           rollups.backfill (90 days by default).
+  concurrency
+           counts the open sessions every rollups.sample_interval (5m by
+           default), keeping the peak of each hour in the rollups collection,
+           for /1/stats/concurrency.
+  retention
+           keeps the database from growing unbounded, every retention.interval
+           (1h by default). The request data of sessions older than
+           retention.session_requests is removed, keeping the sessions, and
+           crash reports older than retention.crashes are removed from their
+           groups. Events older than retention.events, and crash groups not
+           seen for retention.crashes, are expired by MongoDB with TTL indexes
+           on created_at and last_seen, which the job creates, changes or drops
+           to follow the configuration. Unset retentions keep the data forever.
+  alerts   notifies operators when a threshold of alerts is crossed, every
+           alerts.interval (1m by default), see Alerts. Disabled unless a
+           notifier, alerts.email, alerts.slack or alerts.telegram, is
+           configured.
+  abuse    raises flags for the suspicious patterns of the sessions created
+           over abuse.window (24h by default), every abuse.interval (1h by
+           default), see GET /admin/1/flags. Disabled unless the abuse section
+           is set.
+
+With cluster.enabled set, for trackers serving from the same database behind a
+load balancer, every job but write_queue runs on one tracker at a time: before a
+run, a tracker takes the lease of the job in the leases collection, which lasts
+until a minute after its next run, and skips the run, unrecorded, while another
+tracker holds it. If the holder stops, another takes over once the lease
+expires. Each tracker replays its own write queue. The alerts job then only
+sees the error rate and storage failures of the tracker holding its lease.
+The rate limits of pings, events and API keys are then counted in the
+rate_counters collection, in windows starting at multiples of their period, so
+that they apply to all trackers together; when storage fails, each tracker
+limits on its own.
+
+  POST /admin/write_audit (window)
+
+Starts a write audit of window seconds (600 by default, at most a day), discarding
+the previous one. While it runs, the MongoDB documents and bytes written are
+tallied per API path, and per job as "job <name>". Bytes are those of inserted
+documents and of the fields set by updates.
+
+  GET /admin/write_audit
+
+Reports the current or last write audit as JSON: for each call, its requests,
+docs and bytes written, and the amplification factors docs_per_request and
+bytes_per_request.
+
+  GET /admin/storage
+
+Reports the stats of every collection as the database computes them, so that
+capacity can be planned without a database shell:
+  {"at": ..., "collections": [{"name": "sessions", "count": ..., "avg_doc_bytes": ...,
+    "data_bytes": ..., "index_bytes": ..., "trend": {"1d": ..., "7d": ..., "30d": ...}}, ...]}
+Each trend is the growth of count, data_bytes and index_bytes since the newest
+snapshot of the storage_stats job at least that old, given in since. Periods
+without such a snapshot yet are left out.
+
+  GET /admin/1/debug/runtime
+  GET /admin/1/debug/pprof/
+
+Only served when admin.debug is set, 404 otherwise, to profile the tracker in
+production. The first reports the goroutines, the heap and the sockets of the
+MongoDB driver:
+  {"goroutines": 57, "heap": {"alloc_bytes": ..., "sys_bytes": ..., "objects": ...,
+    "gc_runs": ..., "last_gc": ..., "pause_total_ms": ...},
+   "mongo": {"sockets_alive": 12, "sockets_in_use": 3, "master_conns": 1,
+    "slave_conns": 0, "sent_ops": ..., "received_ops": ...}}
+mongo is null unless admin.debug was set on startup, as the driver only counts
+from then. The second serves the profiles of net/http/pprof, without the
+request deadline, as in
+  curl -H 'X-Admin-Token: ...' -o cpu.pprof 'https://tracker/admin/1/debug/pprof/profile?seconds=30'
+  go tool pprof cpu.pprof
+
+  GET /admin/1/export/{sessions,installations,feedback} (from, to, range, tz)
+
+Streams the documents of a collection created within a time window, all of them
+until now by default, as newline-delimited JSON, oldest documents first, through the
+export profile configured for the role of the token in export.profiles:
+
+  full            documents as stored.
+  pseudonymized   identifying fields (jid, machine ids, host names, fingerprints)
+                  replaced by consistent keyed hashes, and request data,
+                  jid resources, emails, support tickets and feedback text
+                  removed.
+                  Requires export.salt.
+  aggregate-only  only counts of documents per day and xmppvox_version.
+
+Operators get full exports and every other role gets aggregate-only unless
+configured otherwise. The profile used is sent in the X-Export-Profile header.
+
+Full and pseudonymized exports write a line per document, so that an interrupted
+download can be resumed by sending the number N of complete lines received in a
+"Range: documents=N-" header. The response is then 206 with a
+"Content-Range: documents N-" header and starts with the next document.
+Resumed downloads must ask for the same time window, with to not relative to now.
+Documents are read from MongoDB in batches, so a slow download holds no cursor open.
+
+  GET /admin/ui (sessions_page, installations_page, from, to, range, tz)
+
+A dashboard for operators, with open sessions and recent installations, 20 per page,
+a chart of sessions per UTC day over a time window, the last 30 days by default,
+and forms to manage blocks.
+Browsers ask for the admin token as the password of HTTP basic authentication,
+any user name will do. It warns when its data is stale.
+
+Cache
+
+With cache.redis set to the host:port of a Redis server, the state of sessions
+is kept in Redis for cache.ttl (1h by default) after they were last written:
+their machine_id, whether they are closed and their secret, see Signing, so
+that signatures are checked without reaching MongoDB. Pings and closes of sessions
+closed, unknown or of another machine_id are then answered from the cache,
+without reaching MongoDB, a session unknown to the cache being looked up once.
+With cache.ping_write_interval, which must be shorter than reaper.expire_after,
+pings of an open session are written to MongoDB at most that often, unless
+they report activity, so last_ping may lag that much behind. The pings not
+written yet are written on shutdown, or put in the write queue, if any, when
+MongoDB is unavailable then. Sessions closed by
+the reaper, superseded or over the limit are only known to be closed at their next write, so
+their pings may be accepted until then. When Redis fails, MongoDB decides.
+Trackers of a cluster should share the same Redis server.
+
+Logging
+
+Log lines have a level, debug, info, warn or error, and those of requests are
+tagged with request_id, the X-Request-Id header of the request, as set by a
+reverse proxy, or a random id, which is sent back in the X-Request-Id header of
+every response. Lines less severe than log.level, info by default, are dropped.
+They go to stderr unless log.output is "file", for the log.file rotated past
+log.max_size_mb (100 by default) with log.max_backups older files (5 by default),
+or "syslog", for the local syslog or the UDP server at log.syslog. Only
+log.level changes on reload.
+With log.slow_storage set, the storage calls of the client API taking at least
+that long are logged at the warn level with the method, its duration and its
+error, if any, like
+  WARN request_id=4f2a [storage] slow InsertSession took 1.2s
+so that 500s caused by MongoDB latency can be told apart from bugs.
+
+Read-only mode
+
+With --read-only, the tracker serves the GET, HEAD and OPTIONS requests of every
+endpoint, including statistics, exports and the admin dashboard, and refuses
+every other request with 503 and code read_only. It runs no jobs, opens no write
+queue and ignores --ensure-indexes, so that a second tracker can serve heavy
+analytics from MongoDB secondaries, with mongo.mode set to "eventual", while
+the primary tracker serves clients and runs the jobs.
+
+Admin client
+
+  elephant-tracker admin [-url url] [-token token] <resource> <verb> [args]
+
+Calls the admin API of a running tracker, at $ELEPHANT_TRACKER_URL or
+http://localhost:8080 by default, with the token in $ELEPHANT_TRACKER_ADMIN_TOKEN
+unless -token is given, and prints the response. It exits with status 1 when the
+API refuses the request and 2 on usage errors. Run it without a command for the
+list of commands, like "sessions list -open", "blocks add jid <jid> <message>"
+or "jobs history <name>".
+
+Migrations
+
+  elephant-tracker [-config path] migrate [-dry-run] [status]
+
+Applies the pending migrations to the MongoDB database of the configuration,
+in order, recording each in the migrations collection, and stops at the first
+that fails. With -dry-run it only prints what each would change, and status
+lists every migration as applied, with its time and changes, or pending.
+The migrations are:
+
+  1 indexes        creates the missing indexes, as --ensure-indexes.
+  2 closed_reason  sets closed_reason "client" on sessions closed before
+                   reasons were recorded.
+
+Backfill
+
+  elephant-tracker [-config path] backfill [-batch n] [-restart]
+
+Fills in the fields of closed sessions that older versions of the tracker did
+not record: last_ping, set to created_at for sessions never pinged,
+closed_reason, set to "client", and duration, the seconds from created_at to
+closed_at. It goes through the sessions by _id, 500 at a time by default,
+saving a checkpoint in the checkpoints collection after each batch, so that
+when interrupted it resumes after the last batch done, unless -restart is
+given. Once done, the next run starts over, to fill in the sessions closed since.
+Reopened sessions are left untouched. It can run while the tracker serves.
+
+Load test
+
+  elephant-tracker loadtest [-url url] [-clients n] [-duration d] [-ping-interval d] [-pings n] [-rate r]
+
+Simulates -clients XMPPVOX clients, 10 by default, against the API at -url,
+http://localhost:8080/1 by default, for -duration, 1m by default. Each client
+registers an installation with a random machine id, then starts a session,
+pings it -pings times, 5 by default, -ping-interval apart, 1s by default, closes
+it and starts over, signing its requests when the tracker gives sessions a
+secret. -rate caps the requests per second of all clients together, and
+-api-key sets the X-API-Key header. It then prints the requests, failures and
+p50, p90, p99 and maximum latencies of each call, and exits with status 1 if any
+request failed. Point it at a staging tracker: the installations and sessions
+it creates are real, under jids like loadtest0@loadtest.example.
+The storage layer has Go benchmarks, run against MongoDB too with the
+integration build tag, in a scratch database of the server at
+$ET_TEST_MONGO_URL, localhost by default:
+  go test -tags integration -run NONE -bench Storage ./tracker
+
+*/
+package tracker
@@ -0,0 +1,108 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+)
+
+// Modes of data removal requests.
+const (
+	// ErasureAnonymize strips the data of what identifies the person or
+	// the machine, keeping it for statistics.
+	ErasureAnonymize = "anonymize"
+	// ErasureErase removes the data.
+	ErasureErase = "erase"
+)
+
+// erasureMode parses the mode parameter of a data removal request,
+// which defaults to anonymize, reporting whether it anonymizes.
+func erasureMode(r *http.Request) (bool, *APIError) {
+	switch mode := r.FormValue("mode"); mode {
+	case "", ErasureAnonymize:
+		return true, nil
+	case ErasureErase:
+		return false, nil
+	default:
+		return false, &APIError{"invalid_value", "mode", "",
+			fmt.Sprintf("Invalid mode %s, expected anonymize or erase", mode)}
+	}
+}
+
+// erasureReport is the reply to a data removal request.
+type erasureReport struct {
+	Mode string `json:"mode"`
+	*Erasure
+	// Pseudonym replaces the machine id in anonymized data.
+	Pseudonym string `json:"pseudonym,omitempty"`
+}
+
+// writeErasure audits a data removal request and replies with what it did.
+func writeErasure(w http.ResponseWriter, r *http.Request, c *Context, action, target string, rep *erasureReport) {
+	details := bson.M{
+		"mode":          rep.Mode,
+		"installations": rep.Installations,
+		"sessions":      rep.Sessions,
+		"events":        rep.Events,
+		"crash_groups":  rep.CrashGroups,
+	}
+	if rep.Pseudonym != "" {
+		details["pseudonym"] = rep.Pseudonym
+	}
+	a := NewAuditEntry(action, target, c.actor(r), r.FormValue("comment"), details)
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(rep)
+}
+
+// EraseUserHandler anonymizes or erases the sessions of a jid, their
+// events and crash reports, to satisfy a data removal request.
+func EraseUserHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	anonymize, e := erasureMode(r)
+	if e != nil {
+		writeError(w, r, e, http.StatusBadRequest)
+		return
+	}
+	jid := canonicalJID(c.Config, mux.Vars(r)["jid"])
+	erasure, err := c.Store.EraseUser(jid, anonymize)
+	if err != nil {
+		writeError(w, r, internalError(fmt.Sprintf("Failed to erase the data of %s, retry to finish", jid)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	rep := &erasureReport{Mode: ErasureErase, Erasure: erasure}
+	if anonymize {
+		rep.Mode = ErasureAnonymize
+	}
+	writeErasure(w, r, c, "user.erase", jid, rep)
+}
+
+// EraseMachineHandler is like EraseUserHandler, for the installation of a
+// machine and its sessions, events and crash reports.
+func EraseMachineHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	anonymize, e := erasureMode(r)
+	if e != nil {
+		writeError(w, r, e, http.StatusBadRequest)
+		return
+	}
+	machineId := canonicalMachineId(c.Config, mux.Vars(r)["machine_id"])
+	rep := &erasureReport{Mode: ErasureErase}
+	if anonymize {
+		rep.Mode = ErasureAnonymize
+		rep.Pseudonym = "erased-" + bson.NewObjectId().Hex()
+	}
+	erasure, err := c.Store.EraseMachine(machineId, anonymize, rep.Pseudonym)
+	if err != nil {
+		writeError(w, r, internalError(fmt.Sprintf("Failed to erase the data of %s, retry to finish", machineId)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	rep.Erasure = erasure
+	writeErasure(w, r, c, "machine.erase", machineId, rep)
+}
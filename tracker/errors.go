@@ -0,0 +1,137 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// APIError describes a single problem with a request.
+type APIError struct {
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+// APIErrors is a list of problems reported together in a response.
+type APIErrors []*APIError
+
+func (errs APIErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Message
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func missingParam(field string) *APIError {
+	return &APIError{"missing_param", field, "", fmt.Sprintf("Missing POST parameter %s", field)}
+}
+
+func unexpectedParam(field string) *APIError {
+	return &APIError{"unexpected_param", field, "", fmt.Sprintf("Unexpected POST parameter %s", field)}
+}
+
+func tooLong(field, key string, n, max int) *APIError {
+	name := field
+	if key != "" {
+		name = fmt.Sprintf("%s[%q]", field, key)
+	}
+	return &APIError{"too_long", field, key, fmt.Sprintf("%s is too long: %d bytes (maximum %d)", name, n, max)}
+}
+
+// checkParams reports missing required and unexpected parameters in r.PostForm.
+func checkParams(r *http.Request, required []string, optional ...string) APIErrors {
+	var errs APIErrors
+	known := make(map[string]bool)
+	for _, name := range required {
+		known[name] = true
+		if r.PostFormValue(name) == "" {
+			errs = append(errs, missingParam(name))
+		}
+	}
+	for _, name := range optional {
+		known[name] = true
+	}
+	for _, name := range sortedParams(r.PostForm) {
+		if !known[name] {
+			errs = append(errs, unexpectedParam(name))
+		}
+	}
+	return errs
+}
+
+// checkQueryParams reports the required parameters missing in the query of r,
+// for GET endpoints.
+func checkQueryParams(r *http.Request, required ...string) APIErrors {
+	var errs APIErrors
+	for _, name := range required {
+		if r.URL.Query().Get(name) == "" {
+			errs = append(errs, &APIError{"missing_param", name, "", fmt.Sprintf("Missing parameter %s", name)})
+		}
+	}
+	return errs
+}
+
+func sortedParams(form url.Values) []string {
+	names := make([]string, 0, len(form))
+	for name := range form {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// wantsJSON reports whether the client asked for JSON responses.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// writeError replies with a single error, like writeErrors.
+func writeError(w http.ResponseWriter, r *http.Request, e *APIError, code int) {
+	writeErrors(w, r, APIErrors{e}, code)
+}
+
+func internalError(message string) *APIError {
+	return &APIError{"internal_error", "", "", message}
+}
+
+func invalidSessionId(field, hex string) *APIError {
+	return &APIError{"invalid_session_id", field, "", fmt.Sprintf("Invalid session id %s", hex)}
+}
+
+func sessionNotFound(hex string) *APIError {
+	return &APIError{"session_not_found", "session_id", "", fmt.Sprintf("Session %s does not exist or is already closed", hex)}
+}
+
+func forbidden() *APIError {
+	return &APIError{"forbidden", "", "", "Forbidden"}
+}
+
+func notFound(message string) *APIError {
+	return &APIError{"not_found", "", "", message}
+}
+
+func invalidLimit(max int) *APIError {
+	return &APIError{"invalid_limit", "limit", "", fmt.Sprintf("Invalid limit, expected a number from 1 to %d", max)}
+}
+
+func invalidJID(jid string, err error) *APIError {
+	return &APIError{"invalid_jid", "jid", "", fmt.Sprintf("Invalid jid %s, %v", jid, err)}
+}
+
+// writeErrors replies with errs, as JSON when requested by the client
+// and otherwise as plain text with one message per line.
+func writeErrors(w http.ResponseWriter, r *http.Request, errs APIErrors, code int) {
+	if !wantsJSON(r) {
+		http.Error(w, errs.Error(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]APIErrors{"errors": errs})
+}
@@ -0,0 +1,78 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Limits of client telemetry events.
+const (
+	maxEventPropertiesBytes = 2 << 10
+	defaultEventsPerMinute  = 30
+)
+
+var eventNameRegexp = regexp.MustCompile(`^[a-z0-9_.]{1,64}$`)
+
+// eventLimiter caps how many events each session can send.
+var eventLimiter = NewRateLimiter("event")
+
+// NewEventHandler records a client telemetry event, such as the use of a feature.
+func NewEventHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"machine_id", "session_id", "event"}, "properties")
+	sessionIdHex := r.PostFormValue("session_id")
+	if sessionIdHex != "" && !validSessionId(c.Config, sessionIdHex) {
+		errs = append(errs, &APIError{"invalid_session_id", "session_id", "", fmt.Sprintf("Invalid session id %s", sessionIdHex)})
+	}
+	machineId, merr := machineIdParam(r, c.Config)
+	if merr != nil {
+		errs = append(errs, merr)
+	}
+	name := r.PostFormValue("event")
+	if name != "" && !eventNameRegexp.MatchString(name) {
+		errs = append(errs, &APIError{"invalid_event", "event", "",
+			"Invalid event name, expected up to 64 lowercase letters, digits, dots and underscores"})
+	}
+	var properties bson.M
+	if raw := r.PostFormValue("properties"); raw != "" {
+		if len(raw) > maxEventPropertiesBytes {
+			errs = append(errs, tooLong("properties", "", len(raw), maxEventPropertiesBytes))
+		} else if err := json.Unmarshal([]byte(raw), &properties); err != nil {
+			errs = append(errs, &APIError{"invalid_json", "properties", "", "Invalid JSON for properties: expected null or an object"})
+		}
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	limit := defaultEventsPerMinute
+	if c.Config.Events != nil && c.Config.Events.MaxPerMinute > 0 {
+		limit = c.Config.Events.MaxPerMinute
+	}
+	if ok, retry := eventLimiter.AllowIn(sharedRates(c), sessionIdHex, limit, time.Minute); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		writeError(w, r, &APIError{"rate_limited", "session_id", "",
+			fmt.Sprintf("Too many events for session %s, at most %d per minute", sessionIdHex, limit)},
+			http.StatusTooManyRequests)
+		return
+	}
+	e := &Event{
+		Id:         bson.NewObjectId(),
+		MachineId:  machineId,
+		SessionId:  SessionId(sessionIdHex),
+		Name:       name,
+		Properties: properties,
+		CreatedAt:  bson.Now(),
+	}
+	if err := c.Store.InsertEvent(e); err != nil {
+		writeError(w, r, internalError("Failed to record event"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	fmt.Fprintln(w, e.Id.Hex())
+}
@@ -0,0 +1,301 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export profiles
+const (
+	ProfileFull          = "full"
+	ProfilePseudonymized = "pseudonymized"
+	ProfileAggregateOnly = "aggregate-only"
+)
+
+// exportProfileFor returns the export profile of an admin token role.
+// Operators get full exports unless configured otherwise, everybody else
+// gets aggregates only.
+func exportProfileFor(conf *Config, role string) string {
+	if conf != nil && conf.Export != nil {
+		if profile, ok := conf.Export.Profiles[role]; ok {
+			return profile
+		}
+	}
+	if role == RoleOperator {
+		return ProfileFull
+	}
+	return ProfileAggregateOnly
+}
+
+// An ExportProfile transforms exported documents, keyed by their field names in storage.
+type ExportProfile interface {
+	// Add returns what to write out for doc, or nil to write nothing.
+	Add(doc bson.M) (interface{}, error)
+	// Flush returns what to write out after all documents were added.
+	Flush() []interface{}
+}
+
+// newExportProfile returns a new ExportProfile for a collection.
+func newExportProfile(name, collection string, conf *ExportConfig) (ExportProfile, error) {
+	switch name {
+	case ProfileFull:
+		return fullProfile{}, nil
+	case ProfilePseudonymized:
+		if conf == nil || conf.Salt == "" {
+			return nil, errors.New("pseudonymized exports require export.salt to be configured")
+		}
+		return &pseudonymizedProfile{[]byte(conf.Salt), pseudonymizedFields[collection]}, nil
+	case ProfileAggregateOnly:
+		return &aggregateProfile{counts: make(map[[2]string]int)}, nil
+	}
+	return nil, fmt.Errorf("unknown export profile %q", name)
+}
+
+type fullProfile struct{}
+
+func (fullProfile) Add(doc bson.M) (interface{}, error) { return doc, nil }
+func (fullProfile) Flush() []interface{}                { return nil }
+
+// pseudonymizedFields lists, per collection, the fields replaced by a keyed
+// hash and the fields removed from pseudonymized exports.
+// Nested fields are written with dots, as in MongoDB queries, and hashed
+// in every element of the arrays they are in.
+var pseudonymizedFields = map[string]struct{ Hash, Remove []string }{
+	"sessions": {
+		Hash:   []string{"jid", "machine_id", "transfers.from", "transfers.to"},
+		Remove: []string{"req", "notes", "resource"},
+	},
+	"installations": {
+		Hash:   []string{"_id", "machine_info.node", "fingerprint"},
+		Remove: []string{"dosvox_info.email", "req", "network", "notes", "ticket"},
+	},
+	// Feedback text is free, it may well name the user.
+	"feedback": {
+		Hash:   []string{"machine_id"},
+		Remove: []string{"text"},
+	},
+}
+
+type pseudonymizedProfile struct {
+	salt   []byte
+	fields struct{ Hash, Remove []string }
+}
+
+// hash returns a keyed hash of v, so that the same value always gets the
+// same pseudonym without being reversible by someone who does not know the salt.
+func (p *pseudonymizedProfile) hash(v interface{}) string {
+	mac := hmac.New(sha256.New, p.salt)
+	fmt.Fprint(mac, v)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (p *pseudonymizedProfile) Add(doc bson.M) (interface{}, error) {
+	for _, field := range p.fields.Hash {
+		p.hashField(doc, strings.Split(field, "."))
+	}
+	for _, field := range p.fields.Remove {
+		if m, key := lookupField(doc, field); m != nil {
+			delete(m, key)
+		}
+	}
+	return doc, nil
+}
+
+// hashField hashes the field of doc at path, in every element of the
+// arrays along the path.
+func (p *pseudonymizedProfile) hashField(doc bson.M, path []string) {
+	v, ok := doc[path[0]]
+	switch {
+	case !ok || v == nil || v == "":
+	case len(path) == 1:
+		doc[path[0]] = p.hash(v)
+	default:
+		switch v := v.(type) {
+		case bson.M:
+			p.hashField(v, path[1:])
+		case []interface{}:
+			for _, e := range v {
+				if m, ok := e.(bson.M); ok {
+					p.hashField(m, path[1:])
+				}
+			}
+		}
+	}
+}
+
+func (p *pseudonymizedProfile) Flush() []interface{} { return nil }
+
+// lookupField returns the map holding a possibly dotted field of doc and
+// the key of the field in that map, or a nil map if there is no such field.
+func lookupField(doc bson.M, field string) (bson.M, string) {
+	parts := strings.Split(field, ".")
+	m := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(bson.M)
+		if !ok {
+			return nil, ""
+		}
+		m = next
+	}
+	return m, parts[len(parts)-1]
+}
+
+// aggregateProfile writes out only document counts per day and XMPPVOX version.
+type aggregateProfile struct {
+	counts map[[2]string]int
+}
+
+func (p *aggregateProfile) Add(doc bson.M) (interface{}, error) {
+	day := ""
+	if t, ok := doc["created_at"].(time.Time); ok {
+		day = t.UTC().Format("2006-01-02")
+	}
+	version, _ := doc["xmppvox_ver"].(string)
+	p.counts[[2]string{day, version}]++
+	return nil, nil
+}
+
+func (p *aggregateProfile) Flush() []interface{} {
+	keys := make([][2]string, 0, len(p.counts))
+	for k := range p.counts {
+		keys = append(keys, k)
+	}
+	sort.Sort(byDayAndVersion(keys))
+	rows := make([]interface{}, len(keys))
+	for i, k := range keys {
+		rows[i] = &aggregateRow{k[0], k[1], p.counts[k]}
+	}
+	return rows
+}
+
+type aggregateRow struct {
+	Day            string `json:"day"`
+	XMPPVOXVersion string `json:"xmppvox_ver"`
+	Count          int    `json:"count"`
+}
+
+type byDayAndVersion [][2]string
+
+func (s byDayAndVersion) Len() int      { return len(s) }
+func (s byDayAndVersion) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byDayAndVersion) Less(i, j int) bool {
+	if s[i][0] == s[j][0] {
+		return s[i][1] < s[j][1]
+	}
+	return s[i][0] < s[j][0]
+}
+
+// toDoc converts v to a document keyed by its field names in storage.
+func toDoc(v interface{}) (bson.M, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	return doc, bson.Unmarshal(data, doc)
+}
+
+// parseDocumentsRange parses a Range header of the form "documents=N-",
+// asking for an export to resume after the N documents already received.
+func parseDocumentsRange(h string) (int, bool) {
+	const prefix = "documents="
+	if !strings.HasPrefix(h, prefix) || !strings.HasSuffix(h, "-") {
+		return 0, false
+	}
+	n, err := strconv.Atoi(h[len(prefix) : len(h)-1])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// ExportHandler streams a collection as newline-delimited JSON,
+// transformed by the export profile of the admin token role.
+func ExportHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	collection := mux.Vars(r)["collection"]
+	var each func(TimeWindow, int, func(interface{}) error) error
+	switch collection {
+	case "sessions":
+		each = func(win TimeWindow, skip int, fn func(interface{}) error) error {
+			return c.Store.EachSession(win, skip, func(s *Session) error { return fn(s) })
+		}
+	case "installations":
+		each = func(win TimeWindow, skip int, fn func(interface{}) error) error {
+			return c.Store.EachInstallation(win, skip, func(i *Installation) error { return fn(i) })
+		}
+	case "feedback":
+		each = func(win TimeWindow, skip int, fn func(interface{}) error) error {
+			return c.Store.EachFeedback(win, skip, func(f *Feedback) error { return fn(f) })
+		}
+	default:
+		writeError(w, r, notFound(fmt.Sprintf("Unknown collection %s", collection)), http.StatusNotFound)
+		return
+	}
+	win, errs := parseWindow(r, time.Now(), 0, 0)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	profileName := exportProfileFor(c.Config, c.Role)
+	profile, err := newExportProfile(profileName, collection, c.Config.Export)
+	if err != nil {
+		writeError(w, r, internalError("Failed to export"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.Header().Set("X-Export-Profile", profileName)
+	skip := 0
+	// Aggregates are only written at the end, there is nothing to resume.
+	if profileName != ProfileAggregateOnly {
+		w.Header().Set("Accept-Ranges", "documents")
+		if h := r.Header.Get("Range"); h != "" {
+			var ok bool
+			if skip, ok = parseDocumentsRange(h); !ok {
+				w.Header().Set("Content-Range", "documents */*")
+				writeError(w, r, &APIError{"invalid_range", "", "",
+					fmt.Sprintf("Invalid Range %s, expected documents=N- to skip N documents", h)},
+					http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("documents %d-", skip))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+	}
+	enc := json.NewEncoder(w)
+	err = each(win, skip, func(v interface{}) error {
+		doc, err := toDoc(v)
+		if err != nil {
+			return err
+		}
+		// Session secrets never leave the server.
+		delete(doc, "secret")
+		out, err := profile.Add(doc)
+		if err != nil || out == nil {
+			return err
+		}
+		return enc.Encode(out)
+	})
+	if err == nil {
+		for _, out := range profile.Flush() {
+			if err = enc.Encode(out); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		// Headers are likely sent already, the truncated body is all the client gets.
+		c.Log.Error("[export]", collection, err)
+	}
+}
@@ -0,0 +1,143 @@
+package tracker
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// featureNamePattern is the spelling of feature flag names, like "espeak_tts".
+var featureNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.]{0,63}$`)
+
+// rolloutBucket places a machine in one of 100 buckets for the rollout of
+// the flag name, so that a machine keeps its answer as the percent grows and
+// each flag is rolled out to a different share of the machines.
+func rolloutBucket(name, machineId string) int {
+	sum := sha256.Sum256([]byte(name + "\n" + machineId))
+	return int(binary.BigEndian.Uint32(sum[:4]) % 100)
+}
+
+// On reports whether f is on for machineId running xmppvoxVersion.
+func (f *FeatureFlag) On(machineId, xmppvoxVersion string) bool {
+	if f.MinVersion != "" && compareVersions(xmppvoxVersion, f.MinVersion) < 0 {
+		return false
+	}
+	if f.MaxVersion != "" && compareVersions(xmppvoxVersion, f.MaxVersion) > 0 {
+		return false
+	}
+	return rolloutBucket(f.Name, machineId) < f.Percent
+}
+
+// ClientFlagsHandler replies with a JSON object telling whether every feature
+// flag is on for the machine_id and xmppvox_version query params, so that
+// XMPPVOX features can be rolled out gradually and turned off remotely.
+func ClientFlagsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	if errs := checkQueryParams(r, "machine_id", "xmppvox_version"); errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	flags, err := c.Store.FeatureFlags()
+	if err != nil {
+		writeError(w, r, internalError("Failed to list feature flags"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	// Spellings of a machine id fall in the same bucket.
+	machineId := canonicalMachineId(c.Config, r.URL.Query().Get("machine_id"))
+	on := make(map[string]bool, len(flags))
+	for _, f := range flags {
+		on[f.Name] = f.On(machineId, r.URL.Query().Get("xmppvox_version"))
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(on)
+}
+
+// SetFeatureFlagHandler creates or replaces the feature flag name, on for
+// percent of the machines running versions from min_version to max_version.
+// A percent of 0 turns the flag off for every machine.
+func SetFeatureFlagHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"name", "percent"}, "min_version", "max_version")
+	name := r.PostFormValue("name")
+	if name != "" && !featureNamePattern.MatchString(name) {
+		errs = append(errs, &APIError{"invalid_value", "name", "", fmt.Sprintf(
+			"Invalid name %s, expected up to 64 lowercase letters, digits, dots and underscores", name)})
+	}
+	percent, err := strconv.Atoi(r.PostFormValue("percent"))
+	if s := r.PostFormValue("percent"); s != "" && (err != nil || percent < 0 || percent > 100) {
+		errs = append(errs, &APIError{"invalid_value", "percent", "",
+			fmt.Sprintf("Invalid percent %s, expected a number from 0 to 100", s)})
+	}
+	f := &FeatureFlag{
+		Name:       name,
+		Percent:    percent,
+		MinVersion: r.PostFormValue("min_version"),
+		MaxVersion: r.PostFormValue("max_version"),
+		UpdatedAt:  bson.Now(),
+	}
+	if f.MinVersion != "" && f.MaxVersion != "" && compareVersions(f.MinVersion, f.MaxVersion) > 0 {
+		errs = append(errs, &APIError{"invalid_value", "min_version", "", "Invalid version range, min_version is after max_version"})
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if err := c.Store.SetFeatureFlag(f); err != nil {
+		writeError(w, r, internalError(fmt.Sprintf("Failed to set feature flag %s", name)), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	a := NewAuditEntry("feature.set", name, c.actor(r), "", bson.M{
+		"percent":     f.Percent,
+		"min_version": f.MinVersion,
+		"max_version": f.MaxVersion,
+	})
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	fmt.Fprintln(w, name)
+}
+
+// RemoveFeatureFlagHandler removes a feature flag, which clients no longer
+// receive.
+func RemoveFeatureFlagHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	if errs := checkParams(r, []string{"name"}); errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	name := r.PostFormValue("name")
+	switch err := c.Store.RemoveFeatureFlag(name); err {
+	case nil:
+		a := NewAuditEntry("feature.remove", name, c.actor(r), "", nil)
+		if err := c.Store.InsertAuditEntry(a); err != nil {
+			c.Log.Error(err)
+		}
+		fmt.Fprintln(w, name)
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"feature_not_found", "name", "", fmt.Sprintf("Feature flag %s does not exist", name)},
+			http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to remove feature flag %s", name)), http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// FeatureFlagsHandler lists all feature flags by name.
+func FeatureFlagsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	flags, err := c.Store.FeatureFlags()
+	if err != nil {
+		writeError(w, r, internalError("Failed to list feature flags"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if flags == nil {
+		flags = []*FeatureFlag{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(flags)
+}
@@ -0,0 +1,184 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type featuresTest struct {
+	old     *Config
+	store   *MemoryStore
+	handler http.Handler
+}
+
+func newFeaturesTest(t *testing.T) *featuresTest {
+	s := &featuresTest{}
+	s.old = currentConfig()
+	setConfig(&Config{Admin: &AdminConfig{Tokens: []AdminToken{{Token: testAdminToken, Role: RoleOperator}}}})
+	s.store = NewMemoryStore()
+	openStore = func() (Storage, func()) {
+		return s.store, func() {}
+	}
+	s.handler = APIHandler()
+	t.Cleanup(func() {
+		setConfig(s.old)
+	})
+	return s
+}
+
+func (s *featuresTest) do(method, path string, params url.Values) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, path, strings.NewReader(params.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Admin-Token", testAdminToken)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+func (s *featuresTest) flags(t *testing.T, machineId, version string) map[string]bool {
+	w := s.do("GET", "/1/flags?"+url.Values{"machine_id": {machineId}, "xmppvox_version": {version}}.Encode(), nil)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	var on map[string]bool
+	if err := json.Unmarshal(w.Body.Bytes(), &on); err != nil {
+		t.Fatal(err)
+	}
+	return on
+}
+
+func TestFeatureOn(t *testing.T) {
+	newFeaturesTest(t)
+	f := &FeatureFlag{Name: "espeak_tts", Percent: 100, MinVersion: "1.2", MaxVersion: "1.10"}
+	if got, want := f.On("machine", "1.2"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := f.On("machine", "1.10"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := f.On("machine", "1.1"), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := f.On("machine", "1.11"), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	f.Percent = 0
+	if got, want := f.On("machine", "1.2"), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRollout(t *testing.T) {
+	newFeaturesTest(t)
+	f := &FeatureFlag{Name: "espeak_tts", Percent: 30}
+	on := 0
+	for i := 0; i < 1000; i++ {
+		machineId := fmt.Sprintf("machine-%d", i)
+		if f.On(machineId, "1.0") {
+			on++
+			// Machines keep the feature as the rollout grows.
+			if got, want := (&FeatureFlag{Name: f.Name, Percent: 60}).On(machineId, "1.0"), true; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+	}
+	if got, want := on > 250 && on < 350, true; got != want {
+		t.Errorf("%d machines of 1000: on > 250 && on < 350 = %v, want %v", on, got, want)
+	}
+}
+
+func TestFeatureFlags(t *testing.T) {
+	s := newFeaturesTest(t)
+	w := s.do("GET", "/1/flags?machine_id=machine", nil)
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Missing parameter xmppvox_version\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := s.flags(t, "machine", "1.0"), (map[string]bool{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	w = s.do("POST", "/admin/1/features/set", url.Values{"name": {"espeak_tts"}, "percent": {"100"}, "min_version": {"1.1"}})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "espeak_tts\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	s.do("POST", "/admin/1/features/set", url.Values{"name": {"typing"}, "percent": {"0"}})
+	if got, want := s.flags(t, "machine", "1.0"), (map[string]bool{"espeak_tts": false, "typing": false}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.flags(t, "machine", "1.1"), (map[string]bool{"espeak_tts": true, "typing": false}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.store.Audit[0].Action, "feature.set"; got != want {
+		t.Errorf("s.store.Audit[0].Action = %v, want %v", got, want)
+	}
+
+	// Setting a flag again replaces it, which is how it is killed.
+	s.do("POST", "/admin/1/features/set", url.Values{"name": {"espeak_tts"}, "percent": {"0"}})
+	if got, want := s.flags(t, "machine", "1.1")["espeak_tts"], false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	w = s.do("GET", "/admin/1/features", nil)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	var flags []*FeatureFlag
+	if err := json.Unmarshal(w.Body.Bytes(), &flags); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(flags), 2; got != want {
+		t.Fatalf("len(flags) = %d, want %d", got, want)
+	}
+	if got, want := flags[0].Name, "espeak_tts"; got != want {
+		t.Errorf("flags[0].Name = %v, want %v", got, want)
+	}
+	if got, want := flags[0].MinVersion, ""; got != want {
+		t.Errorf("flags[0].MinVersion = %v, want %v", got, want)
+	}
+
+	if got, want := s.do("POST", "/admin/1/features/remove", url.Values{"name": {"typing"}}).Code, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	w = s.do("POST", "/admin/1/features/remove", url.Values{"name": {"typing"}})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Feature flag typing does not exist\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := s.flags(t, "machine", "1.1"), (map[string]bool{"espeak_tts": false}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSetInvalid(t *testing.T) {
+	s := newFeaturesTest(t)
+	w := s.do("POST", "/admin/1/features/set", url.Values{
+		"name":        {"Espeak TTS"},
+		"percent":     {"101"},
+		"min_version": {"1.10"},
+		"max_version": {"1.9"},
+	})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Invalid name Espeak TTS, expected up to 64 lowercase letters, digits, dots and underscores\n"+
+		"Invalid percent 101, expected a number from 0 to 100\n"+
+		"Invalid version range, min_version is after max_version\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := len(s.store.Features), 0; got != want {
+		t.Errorf("len(s.store.Features) = %d, want %d", got, want)
+	}
+}
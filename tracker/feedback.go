@@ -0,0 +1,99 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits of the feedback sent by users.
+const (
+	maxFeedbackTextBytes   = 4 << 10
+	defaultFeedbackPerHour = 5
+)
+
+// feedbackLimiter caps how much feedback each machine can send, so that a
+// stuck key or a script does not flood the listing.
+var feedbackLimiter = NewRateLimiter("feedback")
+
+// NewFeedbackHandler records a rating of XMPPVOX from 1 to 5 with an optional
+// text, sent by users from within XMPPVOX, optionally about a session.
+func NewFeedbackHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"machine_id", "rating"}, "session_id", "text")
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	sessionIdHex := r.PostFormValue("session_id")
+	if sessionIdHex != "" && !validSessionId(c.Config, sessionIdHex) {
+		errs = append(errs, &APIError{"invalid_session_id", "session_id", "", fmt.Sprintf("Invalid session id %s", sessionIdHex)})
+	}
+	rating, err := strconv.Atoi(r.PostFormValue("rating"))
+	if s := r.PostFormValue("rating"); s != "" && (err != nil || rating < 1 || rating > 5) {
+		errs = append(errs, &APIError{"invalid_value", "rating", "", fmt.Sprintf("Invalid rating %s, expected a number from 1 to 5", s)})
+	}
+	text := strings.TrimSpace(r.PostFormValue("text"))
+	if len(text) > maxFeedbackTextBytes {
+		errs = append(errs, tooLong("text", "", len(text), maxFeedbackTextBytes))
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	limit := defaultFeedbackPerHour
+	if c.Config.Feedback != nil && c.Config.Feedback.MaxPerHour > 0 {
+		limit = c.Config.Feedback.MaxPerHour
+	}
+	if ok, retry := feedbackLimiter.AllowIn(sharedRates(c), machineId, limit, time.Hour); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		writeError(w, r, &APIError{"rate_limited", "machine_id", "",
+			fmt.Sprintf("Too much feedback from machine %s, at most %d per hour", machineId, limit)},
+			http.StatusTooManyRequests)
+		return
+	}
+	f := &Feedback{
+		Id:        bson.NewObjectId(),
+		MachineId: machineId,
+		Rating:    rating,
+		Text:      text,
+		CreatedAt: bson.Now(),
+	}
+	if sessionIdHex != "" {
+		f.SessionId = SessionId(sessionIdHex)
+	}
+	if err := c.Store.InsertFeedback(f); err != nil {
+		writeError(w, r, internalError("Failed to record feedback"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	fmt.Fprintln(w, f.Id.Hex())
+}
+
+// FeedbackHandler lists the feedback, newest first, a page at a time like
+// InstallationsHandler.
+func FeedbackHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	page, limit, errs := historyPage(r)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	feedback, err := c.Store.RecentFeedback((page-1)*limit, limit+1)
+	if err != nil {
+		writeError(w, r, internalError("Failed to list feedback"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if writeHistoryLinks(w, r, page, len(feedback) > limit) {
+		feedback = feedback[:limit]
+	}
+	if feedback == nil {
+		feedback = []*Feedback{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(feedback)
+}
@@ -0,0 +1,159 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type feedbackTest struct {
+	old     *Config
+	store   *MemoryStore
+	handler http.Handler
+}
+
+func newFeedbackTest(t *testing.T) *feedbackTest {
+	s := &feedbackTest{}
+	s.old = currentConfig()
+	setConfig(&Config{
+		Admin:    &AdminConfig{Tokens: []AdminToken{{Token: testAdminToken, Role: RoleOperator}}},
+		Feedback: &FeedbackConfig{MaxPerHour: 2},
+	})
+	feedbackLimiter = NewRateLimiter("feedback")
+	s.store = NewMemoryStore()
+	openStore = func() (Storage, func()) {
+		return s.store, func() {}
+	}
+	s.handler = APIHandler()
+	t.Cleanup(func() {
+		setConfig(s.old)
+	})
+	return s
+}
+
+func (s *feedbackTest) do(method, path string, params url.Values) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, path, strings.NewReader(params.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Admin-Token", testAdminToken)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestFeedback(t *testing.T) {
+	s := newFeedbackTest(t)
+	sessionId := "53c0e64ef5e93a1a2e000001"
+	w := s.do("POST", "/1/feedback", url.Values{"machine_id": {"machine"}, "rating": {"4"},
+		"session_id": {sessionId}, "text": {"  Reads menus well.\n"}})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("%s: w.Code = %v, want %v", w.Body.String(), got, want)
+	}
+	if got, want := len(s.store.FeedbackList), 1; got != want {
+		t.Fatalf("len(s.store.FeedbackList) = %d, want %d", got, want)
+	}
+	f := s.store.FeedbackList[0]
+	if got, want := w.Body.String(), f.Id.Hex()+"\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := f.MachineId, "machine"; got != want {
+		t.Errorf("f.MachineId = %v, want %v", got, want)
+	}
+	if got, want := f.SessionId.String(), sessionId; got != want {
+		t.Errorf("f.SessionId.String() = %v, want %v", got, want)
+	}
+	if got, want := f.Rating, 4; got != want {
+		t.Errorf("f.Rating = %v, want %v", got, want)
+	}
+	if got, want := f.Text, "Reads menus well."; got != want {
+		t.Errorf("f.Text = %v, want %v", got, want)
+	}
+
+	if got, want := s.do("POST", "/1/feedback", url.Values{"machine_id": {"machine"}, "rating": {"1"}}).Code, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	w = s.do("POST", "/1/feedback", url.Values{"machine_id": {"machine"}, "rating": {"1"}})
+	if got, want := w.Code, http.StatusTooManyRequests; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Too much feedback from machine machine, at most 2 per hour\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Retry-After"), ""; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+	if got, want := s.do("POST", "/1/feedback", url.Values{"machine_id": {"other"}, "rating": {"5"}}).Code, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	w = s.do("GET", "/admin/1/feedback?limit=2", nil)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Link"), `</admin/1/feedback?limit=2&page=2>; rel="next"`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var listed []*Feedback
+	if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(listed), 2; got != want {
+		t.Fatalf("len(listed) = %d, want %d", got, want)
+	}
+	if got, want := listed[0].MachineId, "other"; got != want {
+		t.Errorf("listed[0].MachineId = %v, want %v", got, want)
+	}
+	if got, want := listed[1].Rating, 1; got != want {
+		t.Errorf("listed[1].Rating = %v, want %v", got, want)
+	}
+}
+
+func TestFeedbackInvalid(t *testing.T) {
+	s := newFeedbackTest(t)
+	w := s.do("POST", "/1/feedback", url.Values{"machine_id": {"machine"}, "rating": {"6"},
+		"session_id": {"nope"}, "text": {strings.Repeat("a", maxFeedbackTextBytes+1)}})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Invalid session id nope\n"+
+		"Invalid rating 6, expected a number from 1 to 5\n"+
+		fmt.Sprintf("text is too long: %d bytes (maximum %d)\n", maxFeedbackTextBytes+1, maxFeedbackTextBytes); got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	w = s.do("POST", "/1/feedback", url.Values{"machine_id": {"machine"}})
+	if got, want := w.Body.String(), "Missing POST parameter rating\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := len(s.store.FeedbackList), 0; got != want {
+		t.Errorf("len(s.store.FeedbackList) = %d, want %d", got, want)
+	}
+}
+
+func TestFeedbackExport(t *testing.T) {
+	s := newFeedbackTest(t)
+	setConfig(&Config{
+		Admin:  &AdminConfig{Tokens: []AdminToken{{Token: testAdminToken, Role: RoleOperator}}},
+		Export: &ExportConfig{Salt: "salt", Profiles: map[string]string{RoleOperator: ProfilePseudonymized}},
+	})
+	s.do("POST", "/1/feedback", url.Values{"machine_id": {"machine"}, "rating": {"3"}, "text": {"My name is Test User"}})
+	w := s.do("GET", "/admin/1/export/feedback", nil)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("%s: w.Code = %v, want %v", w.Body.String(), got, want)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := doc["rating"], 3.0; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := doc["machine_id"], "machine"; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+	if got := doc["text"]; got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+}
@@ -0,0 +1,374 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/rhcarvalho/elephant-tracker/proto/trackerpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newGRPCServer returns a gRPC server of the services of proto/tracker.proto
+// for s. Every call is served as the call of API v1 it mirrors, by the
+// handler of s, so that both interfaces share the storage, the checks and
+// the limits of each call. The metadata of a call are its headers, like
+// x-api-key and x-admin-token.
+func newGRPCServer(s *Server) *grpc.Server {
+	gs := grpc.NewServer()
+	gw := &grpcGateway{s.http.Handler}
+	trackerpb.RegisterInstallationsServer(gs, &grpcInstallations{grpcGateway: gw})
+	trackerpb.RegisterSessionsServer(gs, &grpcSessions{grpcGateway: gw})
+	return gs
+}
+
+// grpcAddr is the address of grpc.host and grpc.port.
+func grpcAddr(c *GRPCConfig) string {
+	return fmt.Sprintf("%s:%d", c.Host, c.Port)
+}
+
+// grpcGateway serves gRPC calls with the HTTP handler of a Server.
+type grpcGateway struct {
+	handler http.Handler
+}
+
+// serve serves the request of API v1 method path with params, with the
+// headers of the metadata and the address of the peer of ctx, writing the
+// response to w. It returns the error of a response other than 2xx as a
+// gRPC status.
+func (gw *grpcGateway) serve(ctx context.Context, w grpcResponse, method, path string, params url.Values) error {
+	var req *http.Request
+	var err error
+	if method == "GET" {
+		req, err = http.NewRequestWithContext(ctx, method, path+"?"+params.Encode(), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, path, strings.NewReader(params.Encode()))
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		// Pseudo-headers and those of the gRPC protocol itself.
+		if strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") || key == "content-type" || key == "te" {
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	if method != "GET" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.Header.Set("Accept", "application/json")
+	if p, ok := peer.FromContext(ctx); ok {
+		req.RemoteAddr = p.Addr.String()
+	}
+	gw.handler.ServeHTTP(w, req)
+	if code := w.status(); code/100 != 2 {
+		if s := w.Header().Get("Retry-After"); s != "" {
+			grpc.SetTrailer(ctx, metadata.Pairs("retry-after", s))
+		}
+		return grpcError(code, w.body())
+	}
+	return w.err()
+}
+
+// post serves a POST to API v1 call, like "/session/new", and returns the
+// response.
+func (gw *grpcGateway) post(ctx context.Context, call string, params url.Values) (*httptest.ResponseRecorder, error) {
+	w := &grpcRecorder{httptest.NewRecorder()}
+	if err := gw.serve(ctx, w, "POST", "/1"+call, params); err != nil {
+		return nil, err
+	}
+	return w.ResponseRecorder, nil
+}
+
+// A grpcResponse is the response of a call served by a grpcGateway.
+type grpcResponse interface {
+	http.ResponseWriter
+	status() int
+	// body is the body of a response other than 2xx.
+	body() []byte
+	// err is the error of a response that failed after a 2xx status.
+	err() error
+}
+
+type grpcRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *grpcRecorder) status() int  { return w.Code }
+func (w *grpcRecorder) body() []byte { return w.Body.Bytes() }
+func (w *grpcRecorder) err() error   { return nil }
+
+// grpcLines passes each line of a 2xx response to send as it is written,
+// for the streaming calls. The first error of send fails the writes that
+// follow, which stops the handler.
+type grpcLines struct {
+	header  http.Header
+	code    int
+	buf     bytes.Buffer
+	send    func(line []byte) error
+	sendErr error
+}
+
+func (w *grpcLines) Header() http.Header { return w.header }
+
+func (w *grpcLines) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *grpcLines) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.sendErr != nil {
+		return 0, w.sendErr
+	}
+	w.buf.Write(b)
+	if w.code/100 != 2 {
+		return len(b), nil
+	}
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		line := w.buf.Next(i + 1)
+		if w.sendErr = w.send(line[:i]); w.sendErr != nil {
+			return 0, w.sendErr
+		}
+	}
+}
+
+func (w *grpcLines) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func (w *grpcLines) body() []byte { return w.buf.Bytes() }
+func (w *grpcLines) err() error   { return w.sendErr }
+
+// grpcError returns the error of a response with code and body as a gRPC
+// status of the closest code, with the messages of the errors of the body.
+func grpcError(code int, body []byte) error {
+	c := codes.Unknown
+	switch {
+	case code == http.StatusBadRequest || code == http.StatusRequestEntityTooLarge ||
+		code == http.StatusRequestedRangeNotSatisfiable:
+		c = codes.InvalidArgument
+	case code == http.StatusUnauthorized:
+		c = codes.Unauthenticated
+	case code == http.StatusForbidden:
+		c = codes.PermissionDenied
+	case code == http.StatusNotFound || code == http.StatusGone:
+		c = codes.NotFound
+	case code == http.StatusTooManyRequests:
+		c = codes.ResourceExhausted
+	case code == http.StatusServiceUnavailable:
+		c = codes.Unavailable
+	case code >= 500:
+		c = codes.Internal
+	}
+	msg := strings.TrimSpace(string(body))
+	var resp struct {
+		Errors APIErrors `json:"errors"`
+	}
+	if json.Unmarshal(body, &resp) == nil && len(resp.Errors) > 0 {
+		msg = resp.Errors.Error()
+		if resp.Errors[0].Code == "already_registered" {
+			c = codes.AlreadyExists
+		}
+	}
+	return status.Error(c, msg)
+}
+
+// bodyLines splits a response body in its lines.
+func bodyLines(body string) []string {
+	body = strings.TrimRight(body, "\n")
+	if body == "" {
+		return nil
+	}
+	return strings.Split(body, "\n")
+}
+
+// infoParam encodes info as the dosvox_info and machine_info params do.
+func infoParam(info map[string]string) string {
+	b, _ := json.Marshal(info)
+	return string(b)
+}
+
+type grpcInstallations struct {
+	trackerpb.UnimplementedInstallationsServer
+	*grpcGateway
+}
+
+func (g *grpcInstallations) New(ctx context.Context, req *trackerpb.NewInstallationRequest) (*trackerpb.Installation, error) {
+	w, err := g.post(ctx, "/installation/new", url.Values{
+		"machine_id":      {req.MachineId},
+		"xmppvox_version": {req.XmppvoxVersion},
+		"dosvox_info":     {infoParam(req.DosvoxInfo)},
+		"machine_info":    {infoParam(req.MachineInfo)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	machineId := req.MachineId
+	if ls := bodyLines(w.Body.String()); len(ls) > 0 {
+		machineId = ls[0]
+	}
+	return &trackerpb.Installation{MachineId: machineId, XmppvoxVersion: req.XmppvoxVersion}, nil
+}
+
+func (g *grpcInstallations) Claim(ctx context.Context, req *trackerpb.ClaimInstallationRequest) (*trackerpb.ClaimInstallationResponse, error) {
+	w, err := g.post(ctx, "/installation/claim", url.Values{"machine_id": {req.MachineId}, "code": {req.Code}})
+	if err != nil {
+		return nil, err
+	}
+	resp := &trackerpb.ClaimInstallationResponse{}
+	ls := bodyLines(w.Body.String())
+	if len(ls) > 0 {
+		resp.Ticket = ls[0]
+	}
+	if until, err := time.Parse(time.RFC3339, w.Header().Get("X-Debug-Until")); err == nil {
+		resp.DebugUntil = timestamppb.New(until)
+	}
+	return resp, nil
+}
+
+type grpcSessions struct {
+	trackerpb.UnimplementedSessionsServer
+	*grpcGateway
+}
+
+func (g *grpcSessions) New(ctx context.Context, req *trackerpb.NewSessionRequest) (*trackerpb.Session, error) {
+	w, err := g.post(ctx, "/session/new", url.Values{
+		"jid":             {req.Jid},
+		"machine_id":      {req.MachineId},
+		"xmppvox_version": {req.XmppvoxVersion},
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := &trackerpb.Session{Alias: w.Header().Get("X-Session-Alias"), Secret: w.Header().Get("X-Session-Secret")}
+	if ls := bodyLines(w.Body.String()); len(ls) > 0 {
+		s.Id, s.Messages = ls[0], ls[1:]
+	}
+	return s, nil
+}
+
+// sessionParams are the params of the call of API v1 of req, with its
+// activity when withActivity.
+func sessionParams(req *trackerpb.SessionRequest, withActivity bool) url.Values {
+	params := url.Values{"session_id": {req.SessionId}, "machine_id": {req.MachineId}}
+	if req.Signature != "" {
+		params.Set("signature", req.Signature)
+	}
+	if req.Timestamp != 0 {
+		params.Set("timestamp", strconv.FormatInt(req.Timestamp, 10))
+	}
+	if a := req.Activity; a != nil && withActivity {
+		if a.MessagesSent != nil {
+			params.Set("messages_sent", strconv.FormatInt(*a.MessagesSent, 10))
+		}
+		if a.MessagesReceived != nil {
+			params.Set("messages_received", strconv.FormatInt(*a.MessagesReceived, 10))
+		}
+		if a.ContactsOnline != nil {
+			params.Set("contacts_online", strconv.Itoa(int(*a.ContactsOnline)))
+		}
+		if a.RttMs != nil {
+			params.Set("rtt_ms", strconv.Itoa(int(*a.RttMs)))
+		}
+	}
+	return params
+}
+
+func (g *grpcSessions) Close(ctx context.Context, req *trackerpb.SessionRequest) (*trackerpb.Session, error) {
+	if _, err := g.post(ctx, "/session/close", sessionParams(req, false)); err != nil {
+		return nil, err
+	}
+	return &trackerpb.Session{Id: req.SessionId}, nil
+}
+
+func (g *grpcSessions) Ping(ctx context.Context, req *trackerpb.SessionRequest) (*trackerpb.Session, error) {
+	if _, err := g.post(ctx, "/session/ping", sessionParams(req, true)); err != nil {
+		return nil, err
+	}
+	return &trackerpb.Session{Id: req.SessionId}, nil
+}
+
+// exportedSession is a session as exported by ExportHandler.
+type exportedSession struct {
+	Id           string    `json:"_id"`
+	Alias        string    `json:"alias"`
+	CreatedAt    time.Time `json:"created_at"`
+	ClosedAt     time.Time `json:"closed_at"`
+	ClosedReason string    `json:"closed_reason"`
+}
+
+// Export streams the sessions of the export of sessions, which the export
+// profile of the admin token must not aggregate.
+func (g *grpcSessions) Export(req *trackerpb.ExportRequest, stream grpc.ServerStreamingServer[trackerpb.Session]) error {
+	params := url.Values{}
+	if req.From != nil {
+		params.Set("from", req.From.AsTime().Format(time.RFC3339))
+	}
+	if req.To != nil {
+		params.Set("to", req.To.AsTime().Format(time.RFC3339))
+	}
+	w := &grpcLines{header: make(http.Header)}
+	w.send = func(line []byte) error {
+		if w.header.Get("X-Export-Profile") == ProfileAggregateOnly {
+			return status.Errorf(codes.FailedPrecondition, "The %s export profile of the token has no sessions", ProfileAggregateOnly)
+		}
+		var x exportedSession
+		if err := json.Unmarshal(line, &x); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		s := &trackerpb.Session{Id: x.Id, Alias: x.Alias, CreatedAt: timestamppb.New(x.CreatedAt),
+			ClosedReason: x.ClosedReason}
+		if !x.ClosedAt.IsZero() {
+			s.ClosedAt = timestamppb.New(x.ClosedAt)
+		}
+		return stream.Send(s)
+	}
+	return g.serve(stream.Context(), w, "GET", "/admin/1/export/sessions", params)
+}
+
+// serveGRPC serves gs on l until it is stopped, logging why it stopped
+// otherwise.
+func serveGRPC(gs *grpc.Server, l net.Listener) {
+	if err := gs.Serve(l); err != nil {
+		logger.Error("[grpc]", err)
+	}
+}
+
+// stopGRPC stops gs gracefully, waiting for the calls in flight until ctx is
+// done, and then closing the connections left.
+func stopGRPC(ctx context.Context, gs *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		gs.Stop()
+		<-done
+	}
+}
@@ -0,0 +1,151 @@
+package tracker
+
+import (
+	"context"
+	"github.com/rhcarvalho/elephant-tracker/proto/trackerpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"testing"
+)
+
+type grpcTest struct {
+	Store         *MemoryStore
+	Installations trackerpb.InstallationsClient
+	Sessions      trackerpb.SessionsClient
+}
+
+func newGRPCTest(t *testing.T) *grpcTest {
+	s := &grpcTest{}
+	s.Store = NewMemoryStore()
+	server := NewServer(&Config{
+		Http:  &HttpConfig{},
+		GRPC:  &GRPCConfig{},
+		Admin: &AdminConfig{Tokens: []AdminToken{{Token: testAdminToken, Role: RoleOperator}}},
+	}, nil)
+	server.OpenStore = func() (Storage, func()) {
+		return s.Store, func() {}
+	}
+	l := bufconn.Listen(1 << 20)
+	go serveGRPC(server.grpc, l)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		server.grpc.Stop()
+	})
+	s.Installations = trackerpb.NewInstallationsClient(conn)
+	s.Sessions = trackerpb.NewSessionsClient(conn)
+	return s
+}
+
+func TestGRPCInstallations(t *testing.T) {
+	s := newGRPCTest(t)
+	ctx := context.Background()
+	req := &trackerpb.NewInstallationRequest{MachineId: "00:26:cc:18:be:14", XmppvoxVersion: "1.0",
+		MachineInfo: map[string]string{"system": "Windows"}}
+	i, err := s.Installations.New(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := i.MachineId, "00:26:cc:18:be:14"; got != want {
+		t.Errorf("i.MachineId = %v, want %v", got, want)
+	}
+	if got, want := s.Store.Installations["00:26:cc:18:be:14"].MachineInfo["system"], "Windows"; got != want {
+		t.Errorf("MachineInfo[system] = %v, want %v", got, want)
+	}
+	_, err = s.Installations.New(ctx, req)
+	if got, want := status.Code(err), codes.AlreadyExists; got != want {
+		t.Errorf("status.Code(err) = %v, want %v", got, want)
+	}
+	if got, want := status.Convert(err).Message(), "Installation already registered"; got != want {
+		t.Errorf("status.Convert(err).Message() = %v, want %v", got, want)
+	}
+}
+
+func TestGRPCSessions(t *testing.T) {
+	s := newGRPCTest(t)
+	ctx := context.Background()
+	x, err := s.Sessions.New(ctx, &trackerpb.NewSessionRequest{Jid: "testuser@server.org", MachineId: "00:26:cc:18:be:14",
+		XmppvoxVersion: "1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := x.Alias != "", true; got != want {
+		t.Errorf("x.Alias != \"\" = %v, want %v", got, want)
+	}
+	stored := s.Store.Sessions[SessionId(x.Id)]
+	if stored == nil {
+		t.Fatalf("session %s not stored", x.Id)
+	}
+	sent := int64(3)
+	req := &trackerpb.SessionRequest{SessionId: x.Id, MachineId: "00:26:cc:18:be:14",
+		Activity: &trackerpb.Activity{MessagesSent: &sent}}
+	if _, err := s.Sessions.Ping(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored.Activity.MessagesSent, int64(3); got != want {
+		t.Errorf("stored.Activity.MessagesSent = %v, want %v", got, want)
+	}
+	if _, err := s.Sessions.Close(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stored.ClosedReason, ClosedByClient; got != want {
+		t.Errorf("stored.ClosedReason = %v, want %v", got, want)
+	}
+	_, err = s.Sessions.Close(ctx, req)
+	if got, want := status.Code(err), codes.InvalidArgument; got != want {
+		t.Errorf("status.Code(err) = %v, want %v", got, want)
+	}
+	_, err = s.Sessions.New(ctx, &trackerpb.NewSessionRequest{Jid: "testuser@server.org"})
+	if got, want := status.Code(err), codes.InvalidArgument; got != want {
+		t.Errorf("status.Code(err) = %v, want %v", got, want)
+	}
+
+	// Exports take the admin token from the metadata.
+	stream, err := s.Sessions.Export(ctx, &trackerpb.ExportRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	if got, want := status.Code(err), codes.PermissionDenied; got != want {
+		t.Errorf("status.Code(err) = %v, want %v", got, want)
+	}
+	stream, err = s.Sessions.Export(metadata.AppendToOutgoingContext(ctx, "x-admin-token", testAdminToken),
+		&trackerpb.ExportRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exported []*trackerpb.Session
+	for {
+		x, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		exported = append(exported, x)
+	}
+	if got, want := len(exported), 1; got != want {
+		t.Fatalf("len(exported) = %d, want %d", got, want)
+	}
+	if got, want := exported[0].Id, x.Id; got != want {
+		t.Errorf("exported[0].Id = %v, want %v", got, want)
+	}
+	if got, want := exported[0].ClosedReason, ClosedByClient; got != want {
+		t.Errorf("exported[0].ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := exported[0].CreatedAt.AsTime().Equal(stored.CreatedAt), true; got != want {
+		t.Errorf("exported[0].CreatedAt = %v, want %v", exported[0].CreatedAt.AsTime(), stored.CreatedAt)
+	}
+}
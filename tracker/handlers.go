@@ -0,0 +1,756 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"labix.org/v2/mgo"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultResumeWindow is used when sessions.resume_window is not configured.
+const defaultResumeWindow = 10 * time.Minute
+
+// routeMethods are the methods tried by methodNotAllowed to find those
+// allowed for a path.
+var routeMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "OPTIONS"}
+
+// methodNotAllowed replies to the requests router does not match: with 405
+// and an Allow header listing the methods of the routes matching the path,
+// or with 404 if none does.
+func methodNotAllowed(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range routeMethods {
+			req := *r
+			req.Method = method
+			var match mux.RouteMatch
+			if router.Match(&req, &match) && match.MatchErr == nil {
+				allowed = append(allowed, method)
+			}
+		}
+		if allowed == nil {
+			writeError(w, r, notFound(fmt.Sprintf("Path %s does not exist", r.URL.Path)), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, r, &APIError{"method_not_allowed", "", "",
+			fmt.Sprintf("Method %s is not allowed for %s, expected %s", r.Method, r.URL.Path, strings.Join(allowed, " or "))},
+			http.StatusMethodNotAllowed)
+	})
+}
+
+// APIHandler returns a http.Handler that matches URLs of every version of
+// the API, each under its own prefix, and of the admin API. Probes can use
+// HEAD on the health and read-only endpoints.
+func APIHandler() http.Handler {
+	r := mux.NewRouter()
+	r.NotFoundHandler = methodNotAllowed(r)
+	r.MethodNotAllowedHandler = r.NotFoundHandler
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "API OK")
+	}).Methods("GET", "HEAD")
+	r.HandleFunc("/uptime", UptimeHandler).Methods("GET", "HEAD")
+	r.Handle("/status", contextualHandlerFunc(TrackerStatusHandler)).Methods("GET", "HEAD")
+	r.HandleFunc("/version", VersionHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/healthz", HealthzHandler).Methods("GET", "HEAD")
+	apiV1(r.PathPrefix("/1").Subrouter())
+	apiV2(r.PathPrefix("/2").Subrouter())
+	a := r.PathPrefix("/admin").Subrouter()
+	for pattern, handler := range map[string]contextualHandlerFunc{
+		"/session/reopen":           ReopenSessionHandler,
+		"/1/reload":                 ReloadConfigHandler,
+		"/write_audit":              StartWriteAuditHandler,
+		"/1/api_keys/new":           NewAPIKeyHandler,
+		"/1/api_keys/revoke":        RevokeAPIKeyHandler,
+		"/1/webhooks/new":           NewWebhookHandler,
+		"/1/webhooks/remove":        RemoveWebhookHandler,
+		"/1/claims/new":             NewClaimHandler,
+		"/1/tags/add":               TagHandler,
+		"/1/tags/remove":            UntagHandler,
+		"/1/notes/new":              NewNoteHandler,
+		"/1/flags/block":            BlockFlagHandler,
+		"/1/flags/dismiss":          DismissFlagHandler,
+		"/1/features/set":           SetFeatureFlagHandler,
+		"/1/features/remove":        RemoveFeatureFlagHandler,
+		"/1/releases/new":           NewReleaseHandler,
+		"/1/releases/remove":        RemoveReleaseHandler,
+		"/1/releases/artifacts/new": NewReleaseArtifactHandler,
+	} {
+		a.Handle(pattern, requireAdmin(handler)).Methods("POST")
+	}
+	a.Handle("/1/blocks/new", requireAdmin(NewBlockHandler)).Methods("POST")
+	a.Handle("/1/blocks/remove", requireAdmin(RemoveBlockHandler)).Methods("POST")
+	a.Handle("/1/blocks", requireAdmin(BlocksHandler)).Methods("GET")
+	a.Handle("/1/flags", requireAdmin(FlagsHandler)).Methods("GET")
+	a.Handle("/1/features", requireAdmin(FeatureFlagsHandler)).Methods("GET")
+	a.Handle("/1/releases", requireAdmin(ReleasesHandler)).Methods("GET")
+	a.Handle("/1/api_keys", requireAdmin(APIKeysHandler)).Methods("GET")
+	a.Handle("/1/webhooks", requireAdmin(WebhooksHandler)).Methods("GET")
+	a.Handle("/jobs", requireAdmin(JobsHandler)).Methods("GET")
+	a.Handle("/write_audit", requireAdmin(WriteAuditHandler)).Methods("GET")
+	a.Handle("/storage", requireAdmin(StorageStatsHandler)).Methods("GET")
+	a.Handle("/1/crashes", requireAdmin(CrashesHandler)).Methods("GET")
+	a.Handle("/1/sessions/alias/{alias}", requireAdmin(SessionByAliasHandler)).Methods("GET")
+	a.Handle("/1/sessions/orphans", requireAdmin(OrphanSessionsHandler)).Methods("GET")
+	a.Handle("/1/sessions/tail", requireAdmin(TailSessionsHandler)).Methods("GET")
+	a.Handle("/1/installations", requireAdmin(InstallationsHandler)).Methods("GET")
+	a.Handle("/1/feedback", requireAdmin(FeedbackHandler)).Methods("GET")
+	a.Handle("/1/stats/clients", requireAdmin(ClientStatsHandler)).Methods("GET")
+	a.Handle("/1/search", requireAdmin(SearchHandler)).Methods("GET")
+	a.Handle("/1/machines/{machine_id}/sessions", requireAdmin(MachineSessionsHandler)).Methods("GET")
+	a.Handle("/1/users/{jid}/sessions", requireAdmin(UserSessionsHandler)).Methods("GET")
+	a.Handle("/1/users/{jid}", requireAdmin(EraseUserHandler)).Methods("DELETE")
+	a.Handle("/1/machines/{machine_id}", requireAdmin(EraseMachineHandler)).Methods("DELETE")
+	a.Handle("/jobs/{name}", requireAdmin(JobHistoryHandler)).Methods("GET")
+	debugRoutes(a)
+	a.Handle("/ui", requireUI(UIHandler)).Methods("GET")
+	a.Handle("/ui/blocks/new", requireUI(UINewBlockHandler)).Methods("POST")
+	a.Handle("/ui/blocks/remove", requireUI(UIRemoveBlockHandler)).Methods("POST")
+	// Any admin token can export, the profile of its role decides what is disclosed.
+	a.Handle("/1/export/{collection}", requireToken(ExportHandler)).Methods("GET")
+	return r
+}
+
+// apiRoutes adds the endpoints of the API to s, wrapping their handlers with wrap.
+func apiRoutes(s *mux.Router, wrap func(http.Handler) http.Handler) {
+	// The read-only endpoints can be called from the browser, see corsHandler.
+	for pattern, handler := range map[string]http.Handler{
+		"/status":                http.HandlerFunc(StatusHandler),
+		"/ops/load":              http.HandlerFunc(LoadHandler),
+		"/stats/versions":        requireAPIKey(VersionStatsHandler),
+		"/stats/platforms":       requireAPIKey(PlatformStatsHandler),
+		"/stats/dosvox-versions": requireAPIKey(DosvoxVersionStatsHandler),
+		"/stats/installations":   requireAPIKey(InstallationStatsHandler),
+		"/stats/sessions":        requireAPIKey(SessionStatsHandler),
+		"/stats/usage-by-hour":   requireAPIKey(UsageByHourHandler),
+		"/stats/concurrency":     requireAPIKey(ConcurrencyStatsHandler),
+		"/stats/servers":         requireAPIKey(ServerStatsHandler),
+		"/stats/latency":         requireAPIKey(LatencyStatsHandler),
+		"/testvectors":           http.HandlerFunc(TestVectorsHandler),
+		"/flags":                 requireAPIKey(ClientFlagsHandler),
+		"/config/client":         requireAPIKey(ClientConfigHandler),
+		"/update/check":          requireAPIKey(UpdateCheckHandler),
+		// Downloads are links opened by users, without an API key.
+		"/update/download/{version}": contextualHandlerFunc(UpdateDownloadHandler),
+	} {
+		s.Handle(pattern, wrap(corsHandler(handler))).Methods("GET", "HEAD", "OPTIONS")
+	}
+	for pattern, handler := range clientHandlers {
+		s.Handle(pattern, wrap(requireAPIKey(handler))).Methods("POST")
+	}
+}
+
+// apiV1 builds API v1, deprecated in favor of v2, see deprecatedV1.
+func apiV1(s *mux.Router) {
+	apiRoutes(s, deprecatedV1)
+}
+
+// apiV2 builds API v2. It has the endpoints of v1 so far, and is where
+// changes incompatible with the clients of v1 go.
+func apiV2(s *mux.Router) {
+	apiRoutes(s, func(h http.Handler) http.Handler { return h })
+}
+
+// clientHandlers maps the POST endpoints of the API used by XMPPVOX.
+var clientHandlers = map[string]contextualHandlerFunc{
+	"/installation/new":    NewInstallationHandler,
+	"/installation/claim":  ClaimInstallationHandler,
+	"/installation/ping":   PingInstallationHandler,
+	"/installation/remove": RemoveInstallationHandler,
+	"/session/new":         NewSessionHandler,
+	"/session/close":       CloseSessionHandler,
+	"/session/ping":        PingSessionHandler,
+	"/session/resume":      ResumeSessionHandler,
+	"/session/transfer":    TransferSessionHandler,
+	"/session/update":      UpdateSessionHandler,
+	"/crash/new":           NewCrashHandler,
+	"/event":               NewEventHandler,
+	"/feedback":            NewFeedbackHandler,
+}
+
+// parseInfo decodes a JSON-encoded mapping of at most maxKeys strings to strings,
+// reporting every problem found instead of stopping at the first.
+func parseInfo(field, raw string, maxKeys int) (map[string]string, APIErrors) {
+	if len(raw) > maxInfoBytes {
+		return nil, APIErrors{tooLong(field, "", len(raw), maxInfoBytes)}
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return nil, APIErrors{{"invalid_json", field, "",
+			fmt.Sprintf("Invalid JSON for %s: expected null or an object of strings", field)}}
+	}
+	if v == nil {
+		return nil, nil
+	}
+	var errs APIErrors
+	if len(v) > maxKeys {
+		errs = append(errs, &APIError{"too_many_keys", field, "",
+			fmt.Sprintf("Too many keys in %s: %d (maximum %d)", field, len(v), maxKeys)})
+	}
+	keys := make([]string, 0, len(v))
+	for key := range v {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	info := make(map[string]string, len(v))
+	for _, key := range keys {
+		s, ok := v[key].(string)
+		if !ok {
+			errs = append(errs, &APIError{"invalid_value_type", field, key,
+				fmt.Sprintf("Invalid value for %s[%q]: expected a string", field, key)})
+			continue
+		}
+		if len(key) > maxParamBytes {
+			errs = append(errs, &APIError{"too_long", field, "",
+				fmt.Sprintf("A key of %s is too long: %d bytes (maximum %d)", field, len(key), maxParamBytes)})
+			continue
+		}
+		if len(s) > maxParamBytes {
+			errs = append(errs, tooLong(field, key, len(s), maxParamBytes))
+			continue
+		}
+		info[key] = s
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return info, nil
+}
+
+// NewInstallationHandler ...
+func NewInstallationHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	xmppvoxVersion := r.PostFormValue("xmppvox_version")
+	errs := checkParams(r, []string{"machine_id", "xmppvox_version", "dosvox_info", "machine_info"})
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	var dosvoxInfo, machineInfo map[string]string
+	if raw := r.PostFormValue("dosvox_info"); raw != "" {
+		var e APIErrors
+		dosvoxInfo, e = parseInfo("dosvox_info", raw, maxInfoKeys(c.Config))
+		errs = append(errs, e...)
+	}
+	if raw := r.PostFormValue("machine_info"); raw != "" {
+		var e APIErrors
+		machineInfo, e = parseInfo("machine_info", raw, maxInfoKeys(c.Config))
+		errs = append(errs, e...)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	i := NewInstallation(machineId, xmppvoxVersion, dosvoxInfo, machineInfo)
+	i.Request = installationRequest(r)
+	i.UserAgent = r.UserAgent()
+	i.Network = clientNetwork(r.RemoteAddr)
+	i.App = c.App
+	err := c.Store.InsertInstallation(i)
+	if mgo.IsDup(err) && c.Store.ReplaceStubInstallation(i) == nil {
+		// The stub registered for its sessions, see unregisteredMachine.
+		err = nil
+	}
+	if mgo.IsDup(err) {
+		writeError(w, r, &APIError{"already_registered", "machine_id", "", "Installation already registered"},
+			http.StatusBadRequest)
+		return
+	}
+	switch {
+	case err == nil:
+		fmt.Fprintln(w, machineId)
+	case c.queueWrite(err, &queuedWrite{Kind: QueuedInstallation, Installation: i, App: c.App}):
+		// Stored once the queue is replayed.
+		fmt.Fprintln(w, machineId)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to track install %s", machineId)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// PingInstallationHandler records a heartbeat of the installation of
+// machine_id, sent by XMPPVOX whether or not a session is open, so that
+// installed but idle machines and the versions they run are known.
+func PingInstallationHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"machine_id", "xmppvox_version"})
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	switch err := c.Store.PingInstallation(machineId, r.PostFormValue("xmppvox_version")); err {
+	case nil:
+		debugCapture(w, r, c, machineId)
+		fmt.Fprintln(w, machineId)
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"installation_not_found", "machine_id", "",
+			fmt.Sprintf("Installation %s is not registered", machineId)}, http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to ping install %s", machineId)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// RemoveInstallationHandler marks the installation of machine_id as
+// uninstalled, as reported by the uninstaller of XMPPVOX with the reason the
+// user gave, so that it no longer counts as an active installation.
+func RemoveInstallationHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"machine_id"}, "reason")
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	switch err := c.Store.UninstallInstallation(machineId, strings.TrimSpace(r.PostFormValue("reason"))); err {
+	case nil:
+		fmt.Fprintln(w, machineId)
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"installation_not_found", "machine_id", "",
+			fmt.Sprintf("Installation %s is not registered", machineId)}, http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to track uninstall %s", machineId)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// NewSessionHandler ...
+func NewSessionHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	jid := r.PostFormValue("jid")
+	xmppvoxVersion := r.PostFormValue("xmppvox_version")
+	errs := checkParams(r, []string{"jid", "machine_id", "xmppvox_version"}, "lang", "xmpp_server")
+	j, err := parseJID(jid)
+	if err != nil && jid != "" {
+		errs = append(errs, invalidJID(jid, err))
+	}
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if e := langParam(r); e != nil {
+		errs = append(errs, e)
+	}
+	xmppServer, e := xmppServerParam(r)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	jid = storedJID(c.Config, j)
+	if blocked(w, r, c, jid, machineId, xmppvoxVersion) || unregisteredMachine(w, r, c, machineId, xmppvoxVersion) ||
+		tooManySessions(w, r, c, machineId) {
+		return
+	}
+	s := NewSession(jid, machineId, xmppvoxVersion, sessionRequest(r))
+	s.Id = newSessionId(c.Config)
+	// The resource often names the device or client of the user, which a
+	// hashed jid must not give away.
+	if !hashJIDs(c.Config) {
+		s.Resource = j.Resource
+	}
+	s.XMPPServer = xmppServer
+	s.Region = clientRegion(r, c.Config)
+	s.Project = c.Project
+	s.App = c.App
+	if sessionSigning(c.Config) != "" {
+		s.Secret = newSecret()
+	}
+	err = c.Store.InsertSession(s)
+	// Aliases are short enough to collide once in a while, try another one.
+	for retries := 0; mgo.IsDup(err) && retries < 3; retries++ {
+		s.Alias = newSessionAlias()
+		err = c.Store.InsertSession(s)
+	}
+	switch err {
+	case nil:
+		// The alias goes in a header, since older clients display
+		// every line after the session id to the user.
+		w.Header().Set("X-Session-Alias", formatAlias(s.Alias))
+		debugCapture(w, r, c, machineId)
+		if s.Secret != "" {
+			w.Header().Set("X-Session-Secret", s.Secret)
+		}
+		closed := closeSuperseded(s, c) + closeExcess(s, c)
+		notifyWebhooks(c, WebhookSessionNew, s)
+		fmt.Fprintln(w, s.Id.String())
+		// Together with a sessionId, the response body might include a message.
+		// The client will display the message to the user right after acquiring
+		// the sessionId.
+		if closed > 0 {
+			fmt.Fprintf(w, "Closed %d previous session(s) left open on this machine.\n", closed)
+		}
+	default:
+		writeError(w, r, internalError("Failed to create a new session"), http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// blocked replies with an error and returns true when the xmppvoxVersion,
+// machineId, jid or IP address of a new session is blocked. The client will
+// stop executing and display the message to the user.
+func blocked(w http.ResponseWriter, r *http.Request, c *Context, jid, machineId, xmppvoxVersion string) bool {
+	ip := ""
+	if addr := remoteIP(r.RemoteAddr); addr != nil {
+		ip = addr.String()
+	}
+	switch b, err := c.Store.FindBlock(jid, machineId, xmppvoxVersion, ip); err {
+	case nil:
+		message, lang := localize(r, b.Message, b.Messages)
+		w.Header().Set("Content-Language", lang)
+		writeError(w, r, &APIError{"blocked", b.Field, "", message}, http.StatusForbidden)
+		return true
+	case mgo.ErrNotFound:
+	default:
+		// Do not lock everybody out because the block list is unavailable.
+		c.Log.Error(err)
+	}
+	return false
+}
+
+// resumeWindow is how long after being closed a session can be resumed.
+func resumeWindow(c *Config) time.Duration {
+	if c != nil && c.Sessions != nil && c.Sessions.ResumeWindow.Duration > 0 {
+		return c.Sessions.ResumeWindow.Duration
+	}
+	return defaultResumeWindow
+}
+
+// ResumeSessionHandler reopens the latest session of a jid on a machine when
+// it was expired or closed by a crash report shortly before, so that a quick
+// restart of XMPPVOX carries on with the same session instead of splitting
+// it in two. It replies like NewSessionHandler, with the id of the session.
+func ResumeSessionHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	jid := r.PostFormValue("jid")
+	errs := checkParams(r, []string{"jid", "machine_id"}, "lang")
+	j, err := parseJID(jid)
+	if err != nil && jid != "" {
+		errs = append(errs, invalidJID(jid, err))
+	}
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if e := langParam(r); e != nil {
+		errs = append(errs, e)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	// Sessions are resumed whatever the resource, which may change on restart.
+	jid = storedJID(c.Config, j)
+	if blocked(w, r, c, jid, machineId, "") || tooManySessions(w, r, c, machineId) {
+		return
+	}
+	window := resumeWindow(c.Config)
+	s := &Session{JID: jid, MachineId: machineId}
+	switch err := c.Store.ResumeSession(s, time.Now().Add(-window)); err {
+	case nil:
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"not_resumable", "machine_id", "",
+			fmt.Sprintf("No session of %s on %s expired or crashed in the last %s", jid, machineId, window)},
+			http.StatusBadRequest)
+		return
+	default:
+		writeError(w, r, internalError("Failed to resume session"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if s.Alias != "" {
+		w.Header().Set("X-Session-Alias", formatAlias(s.Alias))
+	}
+	if s.Secret != "" {
+		w.Header().Set("X-Session-Secret", s.Secret)
+	}
+	resumed := *s
+	resumed.ClosedAt, resumed.ClosedReason = time.Time{}, ""
+	closed := closeExcess(&resumed, c)
+	notifyWebhooks(c, WebhookSessionReopen, &resumed)
+	fmt.Fprintln(w, s.Id.String())
+	if closed > 0 {
+		fmt.Fprintf(w, "Closed %d previous session(s) left open on this machine.\n", closed)
+	}
+}
+
+// closeSuperseded closes the sessions that s supersedes, if configured to,
+// returning how many were closed.
+func closeSuperseded(s *Session, c *Context) int {
+	if c.Config == nil || c.Config.Sessions == nil || !c.Config.Sessions.CloseSuperseded {
+		return 0
+	}
+	closed, err := c.Store.CloseSupersededSessions(s)
+	if err != nil {
+		// The new session is fine, the old ones stay open until they expire.
+		c.Log.Error(err)
+	}
+	for _, x := range closed {
+		notifyWebhooks(c, WebhookSessionClose, x)
+	}
+	return len(closed)
+}
+
+// maxOpenPerMachine is how many sessions a machine can have open at once,
+// by sessions.max_open_per_machine, or 0 for any.
+func maxOpenPerMachine(c *Config) int {
+	if c == nil || c.Sessions == nil || c.Sessions.MaxOpenPerMachine < 0 {
+		return 0
+	}
+	return c.Sessions.MaxOpenPerMachine
+}
+
+// tooManySessions replies with an error and returns true when
+// sessions.over_max_open is "reject" and the machine of a new session
+// already has maxOpenPerMachine sessions open. Like blocks, the client
+// displays the message to the user.
+func tooManySessions(w http.ResponseWriter, r *http.Request, c *Context, machineId string) bool {
+	max := maxOpenPerMachine(c.Config)
+	if max == 0 || c.Config == nil || c.Config.Sessions == nil || c.Config.Sessions.OverMaxOpen != OverMaxOpenReject {
+		return false
+	}
+	n, err := c.Store.CountOpenSessions(machineId)
+	if err != nil {
+		// Sessions are capped to stop leaks, not worth failing for.
+		c.Log.Error(err)
+		return false
+	}
+	if n < max {
+		return false
+	}
+	writeError(w, r, &APIError{"too_many_sessions", "machine_id", "",
+		fmt.Sprintf("This machine already has %d open sessions, close one before starting another", n)},
+		http.StatusForbidden)
+	return true
+}
+
+// closeExcess closes the oldest open sessions of the machine of s past
+// maxOpenPerMachine, unless those are rejected by tooManySessions,
+// returning how many were closed.
+func closeExcess(s *Session, c *Context) int {
+	max := maxOpenPerMachine(c.Config)
+	if max == 0 || (c.Config != nil && c.Config.Sessions != nil && c.Config.Sessions.OverMaxOpen == OverMaxOpenReject) {
+		return 0
+	}
+	closed, err := c.Store.CloseExcessSessions(s, max-1)
+	if err != nil {
+		c.Log.Error(err)
+	}
+	if len(closed) > 0 {
+		c.Log.Infof("[sessions] closed %d sessions of machine %s over the limit of %d", len(closed), s.MachineId, max)
+	}
+	for _, x := range closed {
+		notifyWebhooks(c, WebhookSessionClose, x)
+	}
+	return len(closed)
+}
+
+// CloseSessionHandler ...
+func CloseSessionHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	sessionIdHex := r.PostFormValue("session_id")
+	errs := checkParams(r, []string{"session_id", "machine_id"}, "signature", "timestamp")
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if sessionIdHex != "" && !validSessionId(c.Config, sessionIdHex) {
+		errs = append(errs, invalidSessionId("session_id", sessionIdHex))
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	sessionId := SessionId(sessionIdHex)
+	if !checkSessionSignature(w, r, c, "/session/close", sessionId) {
+		return
+	}
+	s := &Session{Id: sessionId, MachineId: machineId, ClosedReason: ClosedByClient}
+	err := c.Store.CloseSession(s)
+	switch err {
+	case nil:
+		notifyWebhooks(c, WebhookSessionClose, s)
+		fmt.Fprintln(w, sessionIdHex)
+	case mgo.ErrNotFound:
+		writeError(w, r, sessionNotFound(sessionIdHex), http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to close session %s", sessionIdHex)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// checkSessionSignature checks the signature of a request to call,
+// replying with an error and returning false if it is refused.
+func checkSessionSignature(w http.ResponseWriter, r *http.Request, c *Context, call string, id SessionId) bool {
+	e, err := checkSignature(r, c, call, id, time.Now())
+	switch {
+	case err != nil:
+		writeError(w, r, internalError("Failed to check signature"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return false
+	case e != nil:
+		writeError(w, r, e, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// activityParams are the optional counters of a ping.
+var activityParams = []string{"messages_sent", "messages_received", "contacts_online", "rtt_ms"}
+
+// maxRTTMs bounds the round-trip times reported with pings, so that a
+// broken clock does not skew the latency stats.
+const maxRTTMs = 60000
+
+// parseActivity parses the activity counters of a ping, returning nil when
+// there are none.
+func parseActivity(r *http.Request) (*SessionActivity, APIErrors) {
+	var a *SessionActivity
+	var errs APIErrors
+	for _, name := range activityParams {
+		s := r.PostFormValue(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			errs = append(errs, &APIError{"invalid_value", name, "",
+				fmt.Sprintf("Invalid %s, expected a number not below 0", name)})
+			continue
+		}
+		if name == "rtt_ms" && n > maxRTTMs {
+			errs = append(errs, &APIError{"invalid_value", name, "",
+				fmt.Sprintf("Invalid %s, expected at most %d milliseconds", name, maxRTTMs)})
+			continue
+		}
+		if a == nil {
+			a = &SessionActivity{}
+		}
+		switch name {
+		case "messages_sent":
+			a.MessagesSent = int64(n)
+		case "messages_received":
+			a.MessagesReceived = int64(n)
+		case "contacts_online":
+			a.ContactsOnline = &n
+		case "rtt_ms":
+			a.RTT = newRTTSummary(n)
+		}
+	}
+	return a, errs
+}
+
+// pingLimiter keeps the sessions from pinging more often than
+// sessions.min_ping_interval.
+var pingLimiter = NewRateLimiter("ping")
+
+// PingSessionHandler ...
+func PingSessionHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	sessionIdHex := r.PostFormValue("session_id")
+	errs := checkParams(r, []string{"session_id", "machine_id"}, append(activityParams, "signature", "timestamp")...)
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if sessionIdHex != "" && !validSessionId(c.Config, sessionIdHex) {
+		errs = append(errs, invalidSessionId("session_id", sessionIdHex))
+	}
+	activity, aerrs := parseActivity(r)
+	errs = append(errs, aerrs...)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	sessionId := SessionId(sessionIdHex)
+	if !checkSessionSignature(w, r, c, "/session/ping", sessionId) {
+		return
+	}
+	if c.Config != nil && c.Config.Sessions != nil && c.Config.Sessions.MinPingInterval.Duration > 0 {
+		interval := c.Config.Sessions.MinPingInterval.Duration
+		// A window of one ping starts with every ping accepted.
+		if ok, retry := pingLimiter.AllowIn(sharedRates(c), sessionIdHex, 1, interval); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			writeError(w, r, &APIError{"rate_limited", "session_id", "",
+				fmt.Sprintf("Too many pings for session %s, at most one every %s", sessionIdHex, interval)},
+				http.StatusTooManyRequests)
+			return
+		}
+	}
+	err := c.Store.PingSession(&Session{Id: sessionId, MachineId: machineId, Activity: activity})
+	switch {
+	case err == nil:
+		fmt.Fprintln(w, sessionIdHex)
+	case err == mgo.ErrNotFound:
+		writeError(w, r, sessionNotFound(sessionIdHex), http.StatusBadRequest)
+	case c.queueWrite(err, &queuedWrite{Kind: QueuedPing, SessionId: sessionId, MachineId: machineId, Activity: activity,
+		App: c.App}):
+		// Stored once the queue is replayed.
+		fmt.Fprintln(w, sessionIdHex)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to ping session %s", sessionIdHex)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// TransferSessionHandler moves an open session of machine_id to
+// new_machine_id, as when the user carries on from another computer, so that
+// the session goes on instead of being split in two. The transfer is
+// recorded in the session. Only the machine holding the session can
+// transfer it, and new_machine_id is checked like the machine of a new
+// session. It replies with the session id.
+func TransferSessionHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	sessionIdHex := r.PostFormValue("session_id")
+	errs := checkParams(r, []string{"session_id", "machine_id", "new_machine_id"}, "signature", "timestamp")
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	newMachineId, e := machineIdField(r, c.Config, "new_machine_id")
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if sessionIdHex != "" && !validSessionId(c.Config, sessionIdHex) {
+		errs = append(errs, invalidSessionId("session_id", sessionIdHex))
+	}
+	if errs == nil && newMachineId == machineId {
+		errs = append(errs, &APIError{"invalid_value", "new_machine_id", "", "Invalid new_machine_id, expected another machine than machine_id"})
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	sessionId := SessionId(sessionIdHex)
+	if !checkSessionSignature(w, r, c, "/session/transfer", sessionId) {
+		return
+	}
+	if blocked(w, r, c, "", newMachineId, "") || tooManySessions(w, r, c, newMachineId) {
+		return
+	}
+	s := &Session{Id: sessionId, MachineId: machineId}
+	switch err := c.Store.TransferSession(s, newMachineId); err {
+	case nil:
+		closeExcess(s, c)
+		notifyWebhooks(c, WebhookSessionTransfer, s)
+		fmt.Fprintln(w, sessionIdHex)
+	case mgo.ErrNotFound:
+		writeError(w, r, sessionNotFound(sessionIdHex), http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to transfer session %s", sessionIdHex)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
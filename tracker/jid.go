@@ -0,0 +1,128 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxJIDPart is the most bytes of each part of a jid, as of RFC 6122.
+const maxJIDPart = 1023
+
+// JID is a parsed XMPP address, local@domain/resource.
+type JID struct {
+	Local    string
+	Domain   string
+	Resource string
+}
+
+// Bare returns the jid without its resource, as sessions store it.
+func (j *JID) Bare() string {
+	return j.Local + "@" + j.Domain
+}
+
+// parseJID parses the jid of a user, as in "user@server.org/XMPPVOX",
+// following RFC 6122: the localpart is required and has none of "&'/:<>@,
+// spaces or control characters, the domain is a host name or an IP address
+// and the optional resource is any text without control characters. The
+// domain is lowercased and stripped of a final dot, since it is not case
+// sensitive. The localpart keeps its case, as without nodeprep at hand
+// lowercasing it could merge distinct accounts. Errors tell which part is
+// invalid, for the message of an invalid_jid error.
+func parseJID(s string) (*JID, error) {
+	if !utf8.ValidString(s) {
+		return nil, errors.New("expected UTF-8 text")
+	}
+	j := &JID{}
+	bare := s
+	if i := strings.Index(s, "/"); i >= 0 {
+		bare, j.Resource = s[:i], s[i+1:]
+		if j.Resource == "" || len(j.Resource) > maxJIDPart || strings.IndexFunc(j.Resource, unicode.IsControl) >= 0 {
+			return nil, errors.New("invalid resource")
+		}
+	}
+	i := strings.Index(bare, "@")
+	if i < 0 {
+		return nil, errors.New("expected user@domain")
+	}
+	j.Local, j.Domain = bare[:i], strings.TrimSuffix(strings.ToLower(bare[i+1:]), ".")
+	if j.Local == "" || len(j.Local) > maxJIDPart || strings.IndexFunc(j.Local, invalidLocalRune) >= 0 {
+		return nil, errors.New("invalid user")
+	}
+	if !validJIDDomain(j.Domain) {
+		return nil, errors.New("invalid domain")
+	}
+	return j, nil
+}
+
+func invalidLocalRune(r rune) bool {
+	return strings.ContainsRune("\"&'/:<>@", r) || unicode.IsSpace(r) || unicode.IsControl(r)
+}
+
+// validJIDDomain reports whether domain is an IPv4 address, an IPv6 address
+// in brackets or a host name of letters, digits and hyphens.
+func validJIDDomain(domain string) bool {
+	if domain == "" || len(domain) > maxJIDPart {
+		return false
+	}
+	if strings.HasPrefix(domain, "[") && strings.HasSuffix(domain, "]") {
+		ip := net.ParseIP(domain[1 : len(domain)-1])
+		return ip != nil && ip.To4() == nil
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// canonicalJID returns the bare jid of s as sessions store it, see
+// storedJID, or s itself if it is not a valid jid, so that admins can still
+// look up and erase the sessions of jids stored before they were validated.
+// With privacy.hash_jids, a jid already hashed is kept as it is.
+func canonicalJID(conf *Config, s string) string {
+	j, err := parseJID(s)
+	if err != nil {
+		return s
+	}
+	if hashJIDs(conf) && isJIDHash(j.Local) {
+		return j.Bare()
+	}
+	return storedJID(conf, j)
+}
+
+// hashJIDs reports whether privacy.hash_jids is set.
+func hashJIDs(conf *Config) bool {
+	return conf != nil && conf.Privacy != nil && conf.Privacy.HashJIDs
+}
+
+// storedJID returns the bare jid of j as sessions store it. With
+// privacy.hash_jids, the user is replaced by a keyed hash of the bare jid,
+// keeping the domain for stats per server, as in
+// "5d41402abc4b2a76b9719d911017c592@server.org". The same jid always gets
+// the same hash, so that stats and lookups work the same on hashed jids.
+func storedJID(conf *Config, j *JID) string {
+	if !hashJIDs(conf) {
+		return j.Bare()
+	}
+	mac := hmac.New(sha256.New, []byte(conf.Privacy.jidSalt()))
+	io.WriteString(mac, j.Bare())
+	return hex.EncodeToString(mac.Sum(nil)[:16]) + "@" + j.Domain
+}
+
+// isJIDHash reports whether the user of a jid is a hash made by storedJID.
+func isJIDHash(local string) bool {
+	return len(local) == 32 && isHex(local) && local == strings.ToLower(local)
+}
@@ -0,0 +1,82 @@
+package tracker
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestJIDParse(t *testing.T) {
+	for in, want := range map[string]JID{
+		"user@server.org":             {"user", "server.org", ""},
+		"User@Server.ORG./XMPPVOX":    {"User", "server.org", "XMPPVOX"},
+		"user@server.org/a b/c":       {"user", "server.org", "a b/c"},
+		"user@localhost":              {"user", "localhost", ""},
+		"user@192.0.2.1":              {"user", "192.0.2.1", ""},
+		"user@[2001:db8::1]":          {"user", "[2001:db8::1]", ""},
+		"usuário@exemplo.com.br/Casa": {"usuário", "exemplo.com.br", "Casa"},
+	} {
+		j, err := parseJID(in)
+		if err != nil {
+			t.Errorf("%s: %v", in, err)
+			continue
+		}
+		if got, want := *j, want; got != want {
+			t.Errorf("%s: *j = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestJIDInvalid(t *testing.T) {
+	for in, want := range map[string]string{
+		"server.org":               "expected user@domain",
+		"@server.org":              "invalid user",
+		"us er@server.org":         "invalid user",
+		"user:x@server.org":        "invalid user",
+		"user@":                    "invalid domain",
+		"user@server.org@evil.org": "invalid domain",
+		"user@server_org":          "invalid domain",
+		"user@-server.org":         "invalid domain",
+		"user@server..org":         "invalid domain",
+		"user@[192.0.2.1]":         "invalid domain",
+		"user@server.org/":         "invalid resource",
+		"user@server.org/a\nb":     "invalid resource",
+		"user@" + strings.Repeat("a", 64) + ".org": "invalid domain",
+		"user@server.org\xff":                      "expected UTF-8 text",
+	} {
+		_, err := parseJID(in)
+		if err, pattern := err, want; err == nil || !fullMatch(pattern, err.Error()) {
+			t.Errorf("%q: err = %v, want an error matching %q", in, err, pattern)
+		}
+	}
+}
+
+func TestJIDCanonical(t *testing.T) {
+	if got, want := canonicalJID(nil, "user@Server.org/XMPPVOX"), "user@server.org"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := canonicalJID(nil, "not a jid"), "not a jid"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestJIDHashed(t *testing.T) {
+	conf := &Config{Privacy: &PrivacyConfig{HashJIDs: true, JIDSalt: "salt"}}
+	hashed := canonicalJID(conf, "user@Server.org/XMPPVOX")
+	if got, pattern := hashed, "[0-9a-f]{32}@server.org"; !fullMatch(pattern, got) {
+		t.Errorf("hashed = %q, want a match of %q", got, pattern)
+	}
+	if got, want := canonicalJID(conf, "user@server.org"), hashed; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := canonicalJID(conf, "other@server.org"), hashed; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+	// Hashes are looked up as they are.
+	if got, want := canonicalJID(conf, hashed), hashed; got != want {
+		t.Errorf("canonicalJID(conf, hashed) = %v, want %v", got, want)
+	}
+	conf.Privacy.JIDSalt = "pepper"
+	if got, want := canonicalJID(conf, "user@server.org"), hashed; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+}
@@ -0,0 +1,263 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Outcomes of a JobRun.
+const (
+	JobOK     = "ok"
+	JobFailed = "failed"
+)
+
+// A Job is a task run periodically by the Scheduler.
+type Job struct {
+	Name string
+	// Interval returns how long to wait between runs, or 0 if the job is disabled.
+	// It is called before every run, so that intervals follow configuration reloads.
+	Interval func(*Config) time.Duration
+	// Run does the work with the storage, configuration and server of c,
+	// returning how many items it processed.
+	Run func(c *Context) (int, error)
+	// PerInstance jobs run on every tracker of a cluster, as they work on
+	// the state of the process, see cluster.enabled.
+	PerInstance bool
+	// Shared jobs run once with the storage of the default app. The others
+	// run once per app with its own storage, see apps.
+	Shared bool
+}
+
+// defaultJobs lists the jobs run by servers.
+var defaultJobs = []*Job{reaperJob, snapshotJob, replayJob, rollupJob, concurrencyJob, retentionJob, alertJob, abuseJob}
+
+// jobs returns the jobs of the server of c, or the default ones without one.
+func (c *Context) jobs() []*Job {
+	if c.Server != nil {
+		return c.Server.jobs
+	}
+	return defaultJobs
+}
+
+func findJob(jobs []*Job, name string) *Job {
+	for _, j := range jobs {
+		if j.Name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+// disabledJobPoll is how often a disabled job checks if it was enabled by a reload.
+const disabledJobPoll = time.Minute
+
+// jobLeaseGrace is how long the lease of a job outlasts its next run, so
+// that the tracker holding it renews it in time, unless it stopped.
+const jobLeaseGrace = time.Minute
+
+// Scheduler runs jobs periodically, recording every run in storage.
+type Scheduler struct {
+	jobs     []*Job
+	instance string
+	// server is the server of the jobs, which they run with the storage,
+	// configuration and state of. Without one, as in tests, they run with
+	// openStore and the configuration of the process.
+	server *Server
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(jobs []*Job) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		jobs:     jobs,
+		instance: fmt.Sprintf("%s:%d", host, os.Getpid()),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start runs every job in its own goroutine until Stop is called.
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+}
+
+// Stop waits for running jobs to finish and stops scheduling new runs.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(j *Job) {
+	defer s.wg.Done()
+	for {
+		wait := j.Interval(s.config())
+		enabled := wait > 0
+		if !enabled {
+			wait = disabledJobPoll
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(wait):
+		}
+		if enabled {
+			s.run(j, s.config())
+		}
+	}
+}
+
+func (s *Scheduler) config() *Config {
+	if s.server != nil {
+		return s.server.CurrentConfig()
+	}
+	return currentConfig()
+}
+
+// run runs j once and records the run. In a cluster, it returns nil
+// without running j while another tracker holds the lease of j.
+func (s *Scheduler) run(j *Job, conf *Config) *JobRun {
+	open, log := openStore, logger
+	if s.server != nil {
+		open, log = s.server.OpenStore, s.server.Log
+	}
+	store, release := open()
+	defer release()
+	if !j.PerInstance && conf != nil && conf.Cluster != nil && conf.Cluster.Enabled {
+		ok, err := store.AcquireLease("job:"+j.Name, s.instance, time.Now(), j.Interval(conf)+jobLeaseGrace)
+		if err != nil {
+			log.Warnf("[jobs] failed to acquire the lease of %s: %v", j.Name, err)
+		}
+		if !ok {
+			return nil
+		}
+	}
+	store = writeAudit.Wrap(store, "job "+j.Name)
+	run := &JobRun{Id: bson.NewObjectId(), Job: j.Name, Instance: s.instance, StartedAt: bson.Now()}
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				run.Error = fmt.Sprint("panic: ", r)
+			}
+		}()
+		apps := appIds(conf)
+		if j.Shared {
+			apps = apps[:1]
+		}
+		for _, app := range apps {
+			n, err := j.Run(&Context{Store: appStore(store, conf, app), Config: conf, App: app, Log: log, Server: s.server})
+			run.Items += n
+			if err != nil {
+				run.Error = err.Error()
+				return
+			}
+		}
+	}()
+	run.EndedAt = bson.Now()
+	run.Outcome = JobOK
+	if run.Error != "" {
+		run.Outcome = JobFailed
+		log.Errorf("[jobs] %s failed: %s", j.Name, run.Error)
+	}
+	if err := store.InsertJobRun(run); err != nil {
+		log.Errorf("[jobs] failed to record run of %s: %v", j.Name, err)
+	}
+	return run
+}
+
+// Statuses of a job in /admin/jobs.
+const (
+	JobStatusDisabled = "disabled"
+	JobStatusNeverRun = "never_run"
+	JobStatusOK       = "ok"
+	JobStatusFailing  = "failing"
+	JobStatusOverdue  = "overdue"
+)
+
+// JobStatus sums up the health of a job.
+type JobStatus struct {
+	Name     string  `json:"name"`
+	Interval string  `json:"interval"`
+	Status   string  `json:"status"`
+	LastRun  *JobRun `json:"last_run"`
+}
+
+// jobStatus tells whether j is running as scheduled.
+// A job is overdue when it has not started for two intervals,
+// as when every instance of the server is down or stuck.
+func jobStatus(j *Job, conf *Config, last *JobRun, at time.Time) *JobStatus {
+	interval := j.Interval(conf)
+	s := &JobStatus{Name: j.Name, Interval: interval.String(), LastRun: last}
+	switch {
+	case interval <= 0:
+		s.Status = JobStatusDisabled
+	case last == nil:
+		s.Status = JobStatusNeverRun
+	case at.Sub(last.StartedAt) > 2*interval:
+		s.Status = JobStatusOverdue
+	case last.Outcome != JobOK:
+		s.Status = JobStatusFailing
+	default:
+		s.Status = JobStatusOK
+	}
+	return s
+}
+
+// JobsHandler lists the scheduled jobs with their last run.
+func JobsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	jobs := c.jobs()
+	statuses := make([]*JobStatus, 0, len(jobs))
+	for _, j := range jobs {
+		runs, err := c.Store.JobRuns(j.Name, 1)
+		if err != nil {
+			writeError(w, r, internalError("Failed to list job runs"), http.StatusInternalServerError)
+			c.Log.Error(err)
+			return
+		}
+		var last *JobRun
+		if len(runs) > 0 {
+			last = runs[0]
+		}
+		statuses = append(statuses, jobStatus(j, c.Config, last, bson.Now()))
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// JobHistoryHandler lists the latest runs of a job, 20 unless a limit is given.
+func JobHistoryHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	name := mux.Vars(r)["name"]
+	if findJob(c.jobs(), name) == nil {
+		writeError(w, r, notFound(fmt.Sprintf("Unknown job %s", name)), http.StatusNotFound)
+		return
+	}
+	limit := 20
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 1000 {
+			writeError(w, r, invalidLimit(1000), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	runs, err := c.Store.JobRuns(name, limit)
+	if err != nil {
+		writeError(w, r, internalError("Failed to list job runs"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if runs == nil {
+		runs = []*JobRun{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(runs)
+}
@@ -0,0 +1,261 @@
+package tracker
+
+import (
+	"errors"
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type jobsTest struct {
+	Store     *MemoryStore
+	Scheduler *Scheduler
+}
+
+func newJobsTest(t *testing.T) *jobsTest {
+	s := &jobsTest{}
+	s.Store = NewMemoryStore()
+	openStore = func() (Storage, func()) {
+		return s.Store, func() {}
+	}
+	s.Scheduler = NewScheduler(nil)
+	return s
+}
+
+func TestReaper(t *testing.T) {
+	s := newJobsTest(t)
+	old := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	old.CreatedAt = old.CreatedAt.Add(-time.Hour)
+	pinged := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	pinged.CreatedAt = pinged.CreatedAt.Add(-time.Hour)
+	pinged.LastPing = bson.Now()
+	recent := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	for _, session := range []*Session{old, pinged, recent} {
+		if err := s.Store.InsertSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := &Config{Reaper: &ReaperConfig{ExpireAfter: Duration{10 * time.Minute}}}
+	if got, want := reaperJob.Interval(conf), defaultReaperInterval; got != want {
+		t.Errorf("reaperJob.Interval(conf) = %v, want %v", got, want)
+	}
+	run := s.Scheduler.run(reaperJob, conf)
+	if got, want := run.Outcome, JobOK; got != want {
+		t.Errorf("run.Outcome = %v, want %v", got, want)
+	}
+	if got, want := run.Items, 1; got != want {
+		t.Errorf("run.Items = %v, want %v", got, want)
+	}
+	if got, want := old.ClosedReason, ClosedExpired; got != want {
+		t.Errorf("old.ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := pinged.ClosedAt.IsZero(), true; got != want {
+		t.Errorf("pinged.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := recent.ClosedAt.IsZero(), true; got != want {
+		t.Errorf("recent.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	runs, err := s.Store.JobRuns("reaper", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := runs, ([]*JobRun{run}); !reflect.DeepEqual(got, want) {
+		t.Errorf("runs = %v, want %v", got, want)
+	}
+}
+
+func TestReaperDisabledByDefault(t *testing.T) {
+	newJobsTest(t)
+	if got, want := reaperJob.Interval(&Config{}), time.Duration(0); got != want {
+		t.Errorf("reaperJob.Interval(&Config{}) = %v, want %v", got, want)
+	}
+}
+
+func TestStorageSnapshot(t *testing.T) {
+	s := newJobsTest(t)
+	if got, want := snapshotJob.Interval(&Config{}), defaultSnapshotInterval; got != want {
+		t.Errorf("snapshotJob.Interval(&Config{}) = %v, want %v", got, want)
+	}
+	if err := s.Store.InsertSession(NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)); err != nil {
+		t.Fatal(err)
+	}
+	run := s.Scheduler.run(snapshotJob, &Config{})
+	if got, want := run.Outcome, JobOK; got != want {
+		t.Errorf("run.Outcome = %v, want %v", got, want)
+	}
+	// Only sessions, the run itself is recorded in job_runs after the snapshot.
+	if got, want := run.Items, 1; got != want {
+		t.Errorf("run.Items = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.Snapshots), 1; got != want {
+		t.Fatalf("len(s.Store.Snapshots) = %d, want %d", got, want)
+	}
+	if got, want := s.Store.Snapshots[0].Collections[0].Name, "sessions"; got != want {
+		t.Errorf("s.Store.Snapshots[0].Collections[0].Name = %v, want %v", got, want)
+	}
+	if got, want := s.Store.Snapshots[0].Collections[0].Count, int64(1); got != want {
+		t.Errorf("s.Store.Snapshots[0].Collections[0].Count = %v, want %v", got, want)
+	}
+}
+
+func TestFailingJob(t *testing.T) {
+	s := newJobsTest(t)
+	failing := &Job{
+		Name:     "failing",
+		Interval: func(*Config) time.Duration { return time.Minute },
+		Run:      func(*Context) (int, error) { return 3, errors.New("boom") },
+	}
+	panicking := &Job{
+		Name:     "panicking",
+		Interval: func(*Config) time.Duration { return time.Minute },
+		Run:      func(*Context) (int, error) { panic("oops") },
+	}
+	run := s.Scheduler.run(failing, &Config{})
+	if got, want := run.Outcome, JobFailed; got != want {
+		t.Errorf("run.Outcome = %v, want %v", got, want)
+	}
+	if got, want := run.Items, 3; got != want {
+		t.Errorf("run.Items = %v, want %v", got, want)
+	}
+	if got, want := run.Error, "boom"; got != want {
+		t.Errorf("run.Error = %v, want %v", got, want)
+	}
+	run = s.Scheduler.run(panicking, &Config{})
+	if got, want := run.Outcome, JobFailed; got != want {
+		t.Errorf("run.Outcome = %v, want %v", got, want)
+	}
+	if got, want := run.Error, "panic: oops"; got != want {
+		t.Errorf("run.Error = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.JobRunLog), 2; got != want {
+		t.Errorf("len(s.Store.JobRunLog) = %d, want %d", got, want)
+	}
+}
+
+func TestJobStatus(t *testing.T) {
+	newJobsTest(t)
+	conf := &Config{Reaper: &ReaperConfig{ExpireAfter: Duration{time.Hour}, Interval: Duration{time.Minute}}}
+	at := time.Now()
+	ok := &JobRun{StartedAt: at.Add(-time.Minute), Outcome: JobOK}
+	failed := &JobRun{StartedAt: at.Add(-time.Minute), Outcome: JobFailed}
+	late := &JobRun{StartedAt: at.Add(-3 * time.Minute), Outcome: JobOK}
+	if got, want := jobStatus(reaperJob, &Config{}, ok, at).Status, JobStatusDisabled; got != want {
+		t.Errorf("jobStatus(reaperJob, &Config{}, ok, at).Status = %v, want %v", got, want)
+	}
+	if got, want := jobStatus(reaperJob, conf, nil, at).Status, JobStatusNeverRun; got != want {
+		t.Errorf("jobStatus(reaperJob, conf, nil, at).Status = %v, want %v", got, want)
+	}
+	if got, want := jobStatus(reaperJob, conf, ok, at).Status, JobStatusOK; got != want {
+		t.Errorf("jobStatus(reaperJob, conf, ok, at).Status = %v, want %v", got, want)
+	}
+	if got, want := jobStatus(reaperJob, conf, failed, at).Status, JobStatusFailing; got != want {
+		t.Errorf("jobStatus(reaperJob, conf, failed, at).Status = %v, want %v", got, want)
+	}
+	if got, want := jobStatus(reaperJob, conf, late, at).Status, JobStatusOverdue; got != want {
+		t.Errorf("jobStatus(reaperJob, conf, late, at).Status = %v, want %v", got, want)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	s := newJobsTest(t)
+	now := time.Now()
+	old := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", &HttpRequest{RemoteAddr: "10.0.0.1"})
+	old.CreatedAt = now.AddDate(0, 0, -40)
+	recent := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", &HttpRequest{RemoteAddr: "10.0.0.1"})
+	for _, session := range []*Session{old, recent} {
+		if err := s.Store.InsertSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, at := range []time.Time{now.AddDate(0, 0, -100), now} {
+		ev := &Event{Id: bson.NewObjectId(), MachineId: old.MachineId, SessionId: old.Id, Name: "feature.used", CreatedAt: at}
+		if err := s.Store.InsertEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, at := range []time.Time{now.AddDate(0, 0, -200), now.AddDate(0, 0, -190), now} {
+		signature := "gone"
+		if i > 0 {
+			signature = "seen"
+		}
+		if err := s.Store.RecordCrash(signature, "Traceback", &Crash{MachineId: "00:26:cc:18:be:14", At: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := &Config{Retention: &RetentionConfig{
+		SessionRequests: Duration{30 * 24 * time.Hour},
+		Events:          Duration{90 * 24 * time.Hour},
+		Crashes:         Duration{180 * 24 * time.Hour},
+	}}
+	if got, want := retentionJob.Interval(conf), defaultRetentionInterval; got != want {
+		t.Errorf("retentionJob.Interval(conf) = %v, want %v", got, want)
+	}
+	run := s.Scheduler.run(retentionJob, conf)
+	if got, want := run.Outcome, JobOK; got != want {
+		t.Errorf("%s: run.Outcome = %v, want %v", run.Error, got, want)
+	}
+	// The request of a session, an event, a crash group and a report of the other.
+	if got, want := run.Items, 4; got != want {
+		t.Errorf("run.Items = %v, want %v", got, want)
+	}
+	if got := s.Store.Sessions[old.Id].Request; got != nil {
+		t.Errorf("s.Store.Sessions[old.Id].Request = %v, want nil", got)
+	}
+	if s.Store.Sessions[recent.Id].Request == nil {
+		t.Error("s.Store.Sessions[recent.Id].Request is nil")
+	}
+	if got, want := len(s.Store.Events), 1; got != want {
+		t.Errorf("len(s.Store.Events) = %d, want %d", got, want)
+	}
+	if got, want := len(s.Store.Crashes), 1; got != want {
+		t.Fatalf("len(s.Store.Crashes) = %d, want %d", got, want)
+	}
+	if got, want := len(s.Store.Crashes["seen"].Recent), 1; got != want {
+		t.Errorf("got length %d, want %d", got, want)
+	}
+	if got, want := s.Store.Crashes["seen"].Count, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Without retention, nothing is removed.
+	run = s.Scheduler.run(retentionJob, &Config{})
+	if got, want := run.Items, 0; got != want {
+		t.Errorf("run.Items = %v, want %v", got, want)
+	}
+}
+
+func TestJobLease(t *testing.T) {
+	s := newJobsTest(t)
+	conf := &Config{Cluster: &ClusterConfig{Enabled: true}}
+	job := &Job{
+		Name:     "test",
+		Interval: func(*Config) time.Duration { return time.Hour },
+		Run:      func(*Context) (int, error) { return 1, nil },
+	}
+	other := NewScheduler(nil)
+	other.instance = "other:1"
+	if s.Scheduler.run(job, conf) == nil {
+		t.Fatal("s.Scheduler.run(job, conf) is nil")
+	}
+	if err := other.run(job, conf); err != nil {
+		t.Error(err)
+	}
+	if s.Scheduler.run(job, conf) == nil {
+		t.Error("s.Scheduler.run(job, conf) is nil")
+	}
+	job.PerInstance = true
+	if other.run(job, conf) == nil {
+		t.Error("other.run(job, conf) is nil")
+	}
+
+	// Another tracker takes over once the lease expires.
+	job.PerInstance = false
+	s.Store.Leases["job:test"].ExpiresAt = time.Now().Add(-time.Second)
+	if other.run(job, conf) == nil {
+		t.Error("other.run(job, conf) is nil")
+	}
+	if err := s.Scheduler.run(job, conf); err != nil {
+		t.Error(err)
+	}
+}
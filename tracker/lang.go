@@ -0,0 +1,125 @@
+package tracker
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is the language of the messages XMPPVOX speaks to its
+// users, like Block.Message, when they have no variant in the language asked.
+const defaultLanguage = "pt-BR"
+
+// maxBlockMessagesBytes bounds the length of the messages param of blocks.
+const maxBlockMessagesBytes = 4 << 10
+
+// languageTagPattern is the spelling of language tags, like "pt-BR" or "en".
+var languageTagPattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$`)
+
+// langParam validates the optional lang param of r, which chooses the
+// language of messages over the Accept-Language header.
+func langParam(r *http.Request) *APIError {
+	if lang := r.PostFormValue("lang"); lang != "" && !languageTagPattern.MatchString(lang) {
+		return &APIError{"invalid_value", "lang", "", fmt.Sprintf("Invalid lang %s, expected a language tag like pt-BR", lang)}
+	}
+	return nil
+}
+
+// acceptedLanguages returns the languages asked by r, preferred first: the
+// lang param, then those of the Accept-Language header by quality. The
+// wildcard and malformed tags are left out.
+func acceptedLanguages(r *http.Request) []string {
+	var langs []string
+	if lang := r.PostFormValue("lang"); languageTagPattern.MatchString(lang) {
+		langs = append(langs, lang)
+	}
+	var header byQuality
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if !languageTagPattern.MatchString(tag) {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			if f = strings.TrimSpace(f); strings.HasPrefix(f, "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(f[2:], 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		if q > 0 {
+			header = append(header, acceptedLanguage{tag, q})
+		}
+	}
+	sort.Stable(header)
+	for _, a := range header {
+		langs = append(langs, a.tag)
+	}
+	return langs
+}
+
+// acceptedLanguage is a language of the Accept-Language header with its quality.
+type acceptedLanguage struct {
+	tag string
+	q   float64
+}
+
+// byQuality sorts accepted languages, the highest quality first.
+type byQuality []acceptedLanguage
+
+func (a byQuality) Len() int           { return len(a) }
+func (a byQuality) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byQuality) Less(i, j int) bool { return a[i].q > a[j].q }
+
+// localize returns the variant of a message in the language r asks for,
+// and that language, among message, in defaultLanguage, and its variants
+// by language tag. Each language asked matches its own tag and then its
+// primary language, so that "pt-PT" gets "pt-BR" before the next language
+// asked; when no language matches, message is returned.
+func localize(r *http.Request, message string, variants map[string]string) (string, string) {
+	find := func(match func(tag string) bool) (string, string, bool) {
+		if match(defaultLanguage) {
+			return message, defaultLanguage, true
+		}
+		for _, tag := range languageTags(variants) {
+			if match(tag) {
+				return variants[tag], tag, true
+			}
+		}
+		return "", "", false
+	}
+	for _, lang := range acceptedLanguages(r) {
+		if m, tag, ok := find(func(tag string) bool { return strings.EqualFold(tag, lang) }); ok {
+			return m, tag
+		}
+		primary := primaryLanguage(lang)
+		if m, tag, ok := find(func(tag string) bool { return strings.EqualFold(primaryLanguage(tag), primary) }); ok {
+			return m, tag
+		}
+	}
+	return message, defaultLanguage
+}
+
+// languageTags returns the sorted languages of the variants of a message.
+func languageTags(variants map[string]string) []string {
+	tags := make([]string, 0, len(variants))
+	for tag := range variants {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// primaryLanguage returns the language of a tag without its region or
+// script, like "pt" for "pt-BR".
+func primaryLanguage(tag string) string {
+	if i := strings.Index(tag, "-"); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
@@ -0,0 +1,99 @@
+package tracker
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// defaultLatencyWindow is the time window of the latency stats when from is
+// not given.
+const defaultLatencyWindow = 7 * 24 * time.Hour
+
+// latencyStats is the quality of the network of the clients per region over
+// a time window, from the round trips to the XMPP server they reported.
+type latencyStats struct {
+	From    time.Time        `json:"from"`
+	To      time.Time        `json:"to"`
+	Regions []*regionLatency `json:"regions"`
+}
+
+// regionLatency sums up the round trips of the sessions of a region. The
+// percentiles are of the mean round trip of each session, so that a long
+// session does not outweigh the others. An empty region is unknown, as
+// without http.region_header.
+type regionLatency struct {
+	Region   string  `json:"region"`
+	Sessions int     `json:"sessions"`
+	Samples  int64   `json:"samples"`
+	MeanMs   float64 `json:"mean_ms"`
+	P50Ms    int     `json:"p50_ms"`
+	P90Ms    int     `json:"p90_ms"`
+	MaxMs    int     `json:"max_ms"`
+}
+
+// regionsBySessions sorts by sessions, most first, and then by region.
+type regionsBySessions []*regionLatency
+
+func (s regionsBySessions) Len() int      { return len(s) }
+func (s regionsBySessions) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s regionsBySessions) Less(i, j int) bool {
+	if s[i].Sessions != s[j].Sessions {
+		return s[i].Sessions > s[j].Sessions
+	}
+	return s[i].Region < s[j].Region
+}
+
+// newLatencyStats sums up the round trips of sessions per region.
+func newLatencyStats(from, to time.Time, latencies []*SessionLatency) *latencyStats {
+	regions := make(map[string]*regionLatency)
+	total := make(map[string]*RTTSummary)
+	means := make(map[string][]time.Duration)
+	for _, l := range latencies {
+		rl, ok := regions[l.Region]
+		if !ok {
+			rl = &regionLatency{Region: l.Region}
+			regions[l.Region], total[l.Region] = rl, &RTTSummary{}
+		}
+		rl.Sessions++
+		total[l.Region].Add(&l.RTT)
+		means[l.Region] = append(means[l.Region], time.Duration(l.RTT.MeanMs()*float64(time.Millisecond)))
+	}
+	stats := &latencyStats{From: from, To: to, Regions: []*regionLatency{}}
+	for region, rl := range regions {
+		ds := means[region]
+		sort.Sort(durations(ds))
+		rl.Samples, rl.MaxMs = total[region].Samples, total[region].MaxMs
+		rl.MeanMs = math.Floor(total[region].MeanMs()*10+0.5) / 10
+		rl.P50Ms, rl.P90Ms = int(percentile(ds, 0.5)/time.Millisecond), int(percentile(ds, 0.9)/time.Millisecond)
+		stats.Regions = append(stats.Regions, rl)
+	}
+	sort.Sort(regionsBySessions(stats.Regions))
+	return stats
+}
+
+// LatencyStatsHandler reports the round trips to the XMPP server that
+// clients measured and reported with their pings, per region, to help debug
+// complaints of XMPPVOX being slow. It does not answer conditional
+// requests, as pings change the round trips without changing what
+// statsModified looks at.
+func LatencyStatsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	win, errs := parseWindow(r, now, defaultLatencyWindow, maxHourlyWindow)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	asOf, err := c.Store.NewestActivity()
+	var latencies []*SessionLatency
+	if err == nil {
+		latencies, err = c.Store.SessionLatencies(win.From, win.To)
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute latency stats"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	writeStats(w, newLatencyStats(win.From, win.To, latencies), freshness(asOf, c.Config, now))
+}
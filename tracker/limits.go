@@ -0,0 +1,97 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	defaultMaxBodyBytes   = 64 << 10
+	defaultMaxHeaderBytes = 8 << 10
+	defaultMaxInfoKeys    = 64
+	// maxParamBytes bounds the length of every POST parameter but longParams.
+	maxParamBytes = 512
+	// maxInfoBytes bounds the length of the dosvox_info and machine_info mappings.
+	maxInfoBytes = 8 << 10
+)
+
+// longParams are the POST parameters with their own, larger, length limits,
+// checked by the handlers using them.
+var longParams = map[string]bool{
+	"dosvox_info":  true,
+	"machine_info": true,
+	"traceback":    true,
+	"context":      true,
+	"properties":   true,
+	"text":         true,
+	"messages":     true,
+	"metadata":     true,
+}
+
+func maxBodyBytes(conf *Config) int64 {
+	if conf != nil && conf.Limits != nil && conf.Limits.MaxBodyBytes > 0 {
+		return int64(conf.Limits.MaxBodyBytes)
+	}
+	return defaultMaxBodyBytes
+}
+
+func maxHeaderBytes(conf *Config) int {
+	if conf != nil && conf.Limits != nil && conf.Limits.MaxHeaderBytes > 0 {
+		return conf.Limits.MaxHeaderBytes
+	}
+	return defaultMaxHeaderBytes
+}
+
+func maxInfoKeys(conf *Config) int {
+	if conf != nil && conf.Limits != nil && conf.Limits.MaxInfoKeys > 0 {
+		return conf.Limits.MaxInfoKeys
+	}
+	return defaultMaxInfoKeys
+}
+
+func bodyTooLarge(message string) *APIError {
+	return &APIError{"body_too_large", "", "", message}
+}
+
+// limitRequest parses the form of r, refusing bodies larger than
+// limits.max_body_bytes with 413 and POST parameters longer than
+// maxParamBytes with 400. It reports whether r is within the limits.
+func limitRequest(w http.ResponseWriter, r *http.Request, conf *Config) bool {
+	if r.Method != "POST" {
+		return true
+	}
+	max := maxBodyBytes(conf)
+	if r.ContentLength > max {
+		writeError(w, r, bodyTooLarge(fmt.Sprintf("Request body too large: %d bytes (maximum %d)", r.ContentLength, max)),
+			http.StatusRequestEntityTooLarge)
+		return false
+	}
+	// Chunked bodies have no length to check up front. Past max, reads fail
+	// and the connection is closed once the reply is written.
+	r.Body = http.MaxBytesReader(w, r.Body, max)
+	var tooLarge *http.MaxBytesError
+	if err := r.ParseForm(); errors.As(err, &tooLarge) {
+		writeError(w, r, bodyTooLarge(fmt.Sprintf("Request body too large (maximum %d bytes)", max)),
+			http.StatusRequestEntityTooLarge)
+		return false
+	}
+	// Other parse errors leave the form empty, which handlers report as missing params.
+	var errs APIErrors
+	for _, name := range sortedParams(r.PostForm) {
+		if longParams[name] {
+			continue
+		}
+		for _, v := range r.PostForm[name] {
+			if len(v) > maxParamBytes {
+				errs = append(errs, tooLong(name, "", len(v), maxParamBytes))
+				break
+			}
+		}
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,145 @@
+package tracker
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func limitPost(form url.Values, conf *Config, chunked bool) *httptest.ResponseRecorder {
+	body := ioutil.NopCloser(strings.NewReader(form.Encode()))
+	r, err := http.NewRequest("POST", "/1/session/new", body)
+	if err != nil {
+		panic(err)
+	}
+	if !chunked {
+		r.ContentLength = int64(len(form.Encode()))
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	if limitRequest(w, r, conf) {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	conf := &Config{Limits: &LimitsConfig{MaxBodyBytes: 100}}
+	form := url.Values{"traceback": {strings.Repeat("x", 200)}}
+	if got, want := limitPost(form, conf, false).Code, http.StatusRequestEntityTooLarge; got != want {
+		t.Errorf("limitPost(form, conf, false).Code = %v, want %v", got, want)
+	}
+	// Without a Content-Length, the body is cut while reading it.
+	w := limitPost(form, conf, true)
+	if got, want := w.Code, http.StatusRequestEntityTooLarge; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Request body too large (maximum 100 bytes)\n"; got != want {
+		t.Errorf("w.Body.String() = %q, want %q", got, want)
+	}
+	if got, want := limitPost(form, nil, true).Code, http.StatusOK; got != want {
+		t.Errorf("limitPost(form, nil, true).Code = %v, want %v", got, want)
+	}
+}
+
+func TestParamTooLong(t *testing.T) {
+	w := limitPost(url.Values{"jid": {strings.Repeat("x", maxParamBytes+1)}}, nil, false)
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "jid is too long: 513 bytes (maximum 512)\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	// Long params have their own limits
+	w = limitPost(url.Values{"traceback": {strings.Repeat("x", maxParamBytes+1)}}, nil, false)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+}
+
+func TestParseInfoLimits(t *testing.T) {
+	_, errs := parseInfo("dosvox_info", `{"a": "1", "b": "2"}`, 1)
+	if got, want := len(errs), 1; got != want {
+		t.Errorf("len(errs) = %d, want %d", got, want)
+	}
+	_, errs = parseInfo("dosvox_info", `{"a": "`+strings.Repeat("x", maxParamBytes+1)+`"}`, 64)
+	if got, want := len(errs), 1; got != want {
+		t.Fatalf("len(errs) = %d, want %d", got, want)
+	}
+	if got, want := errs[0].Code, "too_long"; got != want {
+		t.Errorf("errs[0].Code = %v, want %v", got, want)
+	}
+	if got, want := errs[0].Key, "a"; got != want {
+		t.Errorf("errs[0].Key = %v, want %v", got, want)
+	}
+	_, errs = parseInfo("dosvox_info", strings.Repeat(" ", maxInfoBytes+1), 64)
+	if got, want := len(errs), 1; got != want {
+		t.Errorf("len(errs) = %d, want %d", got, want)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	conf := &Config{Limits: &LimitsConfig{
+		RequestTimeout:  Duration{10 * time.Second},
+		RequestTimeouts: map[string]Duration{"/1/session/new": {time.Second}, "/admin/1/export/sessions": {time.Minute}},
+	}}
+	if got, want := requestTimeout(nil, "/1/session/ping"), defaultRequestTimeout; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := requestTimeout(nil, "/admin/1/export/sessions"), time.Duration(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := requestTimeout(conf, "/1/session/ping"), 10*time.Second; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := requestTimeout(conf, "/1/session/new"), time.Second; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := requestTimeout(conf, "/admin/1/export/sessions"), time.Minute; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := requestTimeout(conf, "/admin/1/export/installations"), time.Duration(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestServeWithTimeout(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/1/session/new", nil)
+	w := httptest.NewRecorder()
+	serveWithTimeout(w, r, time.Second, func(w http.ResponseWriter) {
+		w.Header().Set("X-Session-Secret", "s3cr3t")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("ok\n"))
+	})
+	if got, want := w.Code, http.StatusCreated; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("X-Session-Secret"), "s3cr3t"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "ok\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+
+	release := make(chan bool)
+	w = httptest.NewRecorder()
+	serveWithTimeout(w, r, 10*time.Millisecond, func(w http.ResponseWriter) {
+		<-release
+		w.Header().Set("X-Session-Secret", "s3cr3t")
+		w.Write([]byte("ok\n"))
+	})
+	close(release)
+	if got, want := w.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("X-Session-Secret"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Request not served within 10ms, retry later\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package tracker
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default capacity of a single instance, used when the ops section is not configured.
+const (
+	defaultCapacityRPS   = 50
+	defaultLatencyTarget = 100 * time.Millisecond
+	defaultMaxShedRate   = 0.05
+	defaultScaleUpAt     = 0.75
+	defaultScaleDownAt   = 0.25
+)
+
+// Scaling recommendations of the load signal.
+const (
+	ScaleUp   = "up"
+	ScaleHold = "hold"
+	ScaleDown = "down"
+)
+
+// Load is the autoscaling signal returned by /1/ops/load.
+type Load struct {
+	// Score is the highest of the components, each relative to its budget, capped to [0, 1].
+	Score            float64 `json:"score"`
+	Recommendation   string  `json:"recommendation"`
+	RequestRate      float64 `json:"request_rate"`
+	StorageLatencyMs float64 `json:"storage_latency_ms"`
+	ShedRate         float64 `json:"shed_rate"`
+}
+
+// loadHysteresis is how far the score must move back past a threshold
+// before a scale up or down recommendation turns into hold.
+const loadHysteresis = 0.1
+
+// loadSignal turns metrics into a load score and a scaling recommendation.
+// Scores oscillating around a threshold do not make the recommendation flap.
+type loadSignal struct {
+	sync.Mutex
+	recommendation string
+}
+
+var load = &loadSignal{recommendation: ScaleHold}
+
+func (l *loadSignal) compute(s MetricsSummary, conf *OpsConfig) *Load {
+	capacity, target, maxShed := float64(defaultCapacityRPS), defaultLatencyTarget, defaultMaxShedRate
+	up, down := defaultScaleUpAt, defaultScaleDownAt
+	if conf != nil {
+		if conf.CapacityRPS > 0 {
+			capacity = conf.CapacityRPS
+		}
+		if conf.LatencyTarget.Duration > 0 {
+			target = conf.LatencyTarget.Duration
+		}
+		if conf.MaxShedRate > 0 {
+			maxShed = conf.MaxShedRate
+		}
+		if conf.ScaleUpAt > 0 {
+			up = conf.ScaleUpAt
+		}
+		if conf.ScaleDownAt > 0 {
+			down = conf.ScaleDownAt
+		}
+	}
+	score := math.Max(s.RequestRate/capacity,
+		math.Max(float64(s.StorageLatency)/float64(target), s.ShedRate/maxShed))
+	score = math.Min(score, 1)
+
+	l.Lock()
+	defer l.Unlock()
+	switch {
+	case score >= up:
+		l.recommendation = ScaleUp
+	case score <= down:
+		l.recommendation = ScaleDown
+	case l.recommendation == ScaleUp && score < up-loadHysteresis,
+		l.recommendation == ScaleDown && score > down+loadHysteresis:
+		l.recommendation = ScaleHold
+	}
+	return &Load{
+		Score:            score,
+		Recommendation:   l.recommendation,
+		RequestRate:      s.RequestRate,
+		StorageLatencyMs: float64(s.StorageLatency) / float64(time.Millisecond),
+		ShedRate:         s.ShedRate,
+	}
+}
+
+// LoadHandler reports how loaded this instance is, for external autoscalers.
+func LoadHandler(w http.ResponseWriter, r *http.Request) {
+	var ops *OpsConfig
+	if conf := requestConfig(r); conf != nil {
+		ops = conf.Ops
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(load.compute(metrics.Summary(), ops))
+}
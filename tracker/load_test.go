@@ -0,0 +1,73 @@
+package tracker
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+type metricsTest struct {
+	Now     time.Time
+	Metrics *Metrics
+}
+
+func newMetricsTest(t *testing.T) *metricsTest {
+	s := &metricsTest{}
+	s.Now = time.Unix(1400000000, 0)
+	s.Metrics = NewMetrics()
+	s.Metrics.now = func() time.Time { return s.Now }
+	return s
+}
+
+func TestMetricsSummary(t *testing.T) {
+	s := newMetricsTest(t)
+	for i := 0; i < 60; i++ {
+		s.Metrics.ObserveRequest(http.StatusOK)
+		s.Metrics.ObserveStorage(10 * time.Millisecond)
+	}
+	s.Metrics.ObserveRequest(http.StatusServiceUnavailable)
+	s.Metrics.ObserveStorage(71 * time.Millisecond)
+	sum := s.Metrics.Summary()
+	if got, want := sum.RequestRate, 61.0/60; got != want {
+		t.Errorf("sum.RequestRate = %v, want %v", got, want)
+	}
+	if got, want := sum.ShedRate, 1.0/61; got != want {
+		t.Errorf("sum.ShedRate = %v, want %v", got, want)
+	}
+	if got, want := sum.StorageLatency, 11*time.Millisecond; got != want {
+		t.Errorf("sum.StorageLatency = %v, want %v", got, want)
+	}
+	// Old measurements are forgotten
+	s.Now = s.Now.Add(time.Minute)
+	if got, want := s.Metrics.Summary(), (MetricsSummary{}); got != want {
+		t.Errorf("s.Metrics.Summary() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadSheddingHysteresis(t *testing.T) {
+	newMetricsTest(t)
+	l := &loadSignal{recommendation: ScaleHold}
+	conf := &OpsConfig{CapacityRPS: 100}
+	type TestCase struct {
+		RequestRate    float64
+		Recommendation string
+	}
+	for _, tc := range []TestCase{
+		{50, ScaleHold},
+		{80, ScaleUp},
+		{70, ScaleUp}, // within hysteresis
+		{60, ScaleHold},
+		{20, ScaleDown},
+		{30, ScaleDown}, // within hysteresis
+		{40, ScaleHold},
+		{300, ScaleUp},
+	} {
+		load := l.compute(MetricsSummary{RequestRate: tc.RequestRate}, conf)
+		if got, want := load.Recommendation, tc.Recommendation; got != want {
+			t.Errorf("%+v: load.Recommendation = %v, want %v", tc, got, want)
+		}
+	}
+	if got, want := l.compute(MetricsSummary{RequestRate: 300}, conf).Score, 1.0; got != want {
+		t.Errorf("l.compute(MetricsSummary{RequestRate: 300}, conf).Score = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,230 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// loadCalls are the calls of the XMPPVOX cycle, in the order of the report.
+var loadCalls = []string{"/installation/new", "/session/new", "/session/ping", "/session/close"}
+
+// loadTest simulates XMPPVOX clients against a running tracker: each
+// registers an installation, then starts sessions, pings them and closes
+// them until the test is over.
+type loadTest struct {
+	// URL is the base of the API, like "http://localhost:8080/1".
+	URL          string
+	APIKey       string
+	Clients      int
+	Duration     time.Duration
+	PingInterval time.Duration
+	// Pings is how many times each session is pinged before being closed.
+	Pings int
+	// Rate caps the requests per second of all clients together, 0 for no cap.
+	Rate float64
+
+	client   *http.Client
+	throttle <-chan time.Time
+
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	failures  map[string]int
+	firstErr  map[string]string
+}
+
+// Run runs the clients for the duration of the test and returns how long it took.
+func (lt *loadTest) Run() time.Duration {
+	lt.client = &http.Client{Timeout: 30 * time.Second}
+	lt.latencies = make(map[string][]time.Duration)
+	lt.failures = make(map[string]int)
+	lt.firstErr = make(map[string]string)
+	if lt.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / lt.Rate))
+		defer ticker.Stop()
+		lt.throttle = ticker.C
+	}
+	start := time.Now()
+	deadline := start.Add(lt.Duration)
+	var wg sync.WaitGroup
+	for i := 0; i < lt.Clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lt.runClient(i, deadline)
+		}(i)
+	}
+	wg.Wait()
+	return time.Since(start)
+}
+
+// runClient runs the cycle of a client until deadline.
+func (lt *loadTest) runClient(i int, deadline time.Time) {
+	machineId := randomUUID()
+	jid := fmt.Sprintf("loadtest%d@loadtest.example", i)
+	lt.post("/installation/new", url.Values{
+		"machine_id":      {machineId},
+		"xmppvox_version": {"loadtest"},
+		"dosvox_info":     {"null"},
+		"machine_info":    {fmt.Sprintf(`{"node": "loadtest-%d", "system": "Windows", "processor": "x86"}`, i)},
+	}, "")
+	for time.Now().Before(deadline) {
+		body, header, ok := lt.post("/session/new", url.Values{
+			"jid":             {jid},
+			"machine_id":      {machineId},
+			"xmppvox_version": {"loadtest"},
+		}, "")
+		if !ok {
+			time.Sleep(lt.PingInterval)
+			continue
+		}
+		params := url.Values{"session_id": {strings.SplitN(body, "\n", 2)[0]}, "machine_id": {machineId}}
+		secret := header.Get("X-Session-Secret")
+		for p := 0; p < lt.Pings && time.Now().Add(lt.PingInterval).Before(deadline); p++ {
+			time.Sleep(lt.PingInterval)
+			lt.post("/session/ping", params, secret)
+		}
+		lt.post("/session/close", params, secret)
+	}
+}
+
+// post calls the API, signing the params with secret unless it is empty,
+// and records how long the call took. Responses other than 2xx fail.
+func (lt *loadTest) post(call string, params url.Values, secret string) (string, http.Header, bool) {
+	if secret != "" {
+		params.Set("timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+		params.Set("signature", requestSignature(secret, call, params))
+	}
+	if lt.throttle != nil {
+		<-lt.throttle
+	}
+	req, _ := http.NewRequest("POST", lt.URL+call, strings.NewReader(params.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if lt.APIKey != "" {
+		req.Header.Set("X-API-Key", lt.APIKey)
+	}
+	start := time.Now()
+	resp, err := lt.client.Do(req)
+	var body []byte
+	if err == nil {
+		body, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil && resp.StatusCode/100 != 2 {
+			err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+	}
+	lt.record(call, time.Since(start), err)
+	if err != nil {
+		return "", nil, false
+	}
+	return string(body), resp.Header, true
+}
+
+func (lt *loadTest) record(call string, d time.Duration, err error) {
+	lt.mu.Lock()
+	defer lt.mu.Unlock()
+	lt.latencies[call] = append(lt.latencies[call], d)
+	if err != nil {
+		lt.failures[call]++
+		if _, ok := lt.firstErr[call]; !ok {
+			lt.firstErr[call] = err.Error()
+		}
+	}
+}
+
+// Report writes the requests, failures and latency percentiles of each
+// call, and the total rate over elapsed. It returns how many requests failed.
+func (lt *loadTest) Report(w io.Writer, elapsed time.Duration) int {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "call\trequests\tfailed\tp50\tp90\tp99\tmax\t")
+	total, failed := 0, 0
+	for _, call := range loadCalls {
+		ds := lt.latencies[call]
+		if len(ds) == 0 {
+			continue
+		}
+		sort.Sort(durations(ds))
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t\n", call, len(ds), lt.failures[call],
+			percentile(ds, 0.5), percentile(ds, 0.9), percentile(ds, 0.99), ds[len(ds)-1])
+		total += len(ds)
+		failed += lt.failures[call]
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d requests in %s, %.1f/s, %d failed\n", total, elapsed/time.Millisecond*time.Millisecond,
+		float64(total)/elapsed.Seconds(), failed)
+	for _, call := range loadCalls {
+		if e, ok := lt.firstErr[call]; ok {
+			fmt.Fprintf(w, "first failure of %s: %s\n", call, e)
+		}
+	}
+	return failed
+}
+
+type durations []time.Duration
+
+func (ds durations) Len() int           { return len(ds) }
+func (ds durations) Less(i, j int) bool { return ds[i] < ds[j] }
+func (ds durations) Swap(i, j int)      { ds[i], ds[j] = ds[j], ds[i] }
+
+// percentile returns the nearest-rank p-th percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// randomUUID returns a random version 4 UUID, which is a machine id valid
+// for any machine_ids.formats.
+func randomUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// runLoadtest runs "elephant-tracker loadtest" with args and returns the
+// exit status: 0 when every request succeeded, 1 when some failed and 2 on
+// usage errors.
+func runLoadtest(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	lt := &loadTest{}
+	fs.StringVar(&lt.URL, "url", "http://localhost:8080/1", "base URL of the API under test")
+	fs.StringVar(&lt.APIKey, "api-key", "", "API key sent in the X-API-Key header")
+	fs.IntVar(&lt.Clients, "clients", 10, "number of simulated clients")
+	fs.DurationVar(&lt.Duration, "duration", time.Minute, "how long to run")
+	fs.DurationVar(&lt.PingInterval, "ping-interval", time.Second, "time between the pings of a session")
+	fs.IntVar(&lt.Pings, "pings", 5, "pings of each session before it is closed")
+	fs.Float64Var(&lt.Rate, "rate", 0, "maximum requests per second of all clients, 0 for no cap")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elephant-tracker loadtest [-url url] [-clients n] [-duration d] [-ping-interval d] [-pings n] [-rate r]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 || lt.Clients < 1 || lt.Duration <= 0 || lt.PingInterval <= 0 || lt.Pings < 0 || lt.Rate < 0 {
+		fs.Usage()
+		return 2
+	}
+	lt.URL = strings.TrimSuffix(lt.URL, "/")
+	fmt.Fprintf(stdout, "%d clients against %s for %s\n", lt.Clients, lt.URL, lt.Duration)
+	if lt.Report(stdout, lt.Run()) > 0 {
+		return 1
+	}
+	return 0
+}
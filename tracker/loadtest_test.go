@@ -0,0 +1,92 @@
+package tracker
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type loadtestTest struct {
+	old   *Config
+	store *MemoryStore
+}
+
+func newLoadtestTest(t *testing.T) *loadtestTest {
+	s := &loadtestTest{}
+	s.old = currentConfig()
+	setConfig(&Config{Sessions: &SessionsConfig{Signing: SigningRequired}})
+	s.store = NewMemoryStore()
+	openStore = func() (Storage, func()) {
+		return s.store, func() {}
+	}
+	t.Cleanup(func() {
+		setConfig(s.old)
+	})
+	return s
+}
+
+func TestLoadTestRun(t *testing.T) {
+	s := newLoadtestTest(t)
+	server := httptest.NewServer(APIHandler())
+	defer server.Close()
+	var out bytes.Buffer
+	status := runLoadtest([]string{"-url", server.URL + "/2/", "-clients", "3", "-duration", "300ms",
+		"-ping-interval", "20ms", "-pings", "2"}, &out, &out)
+	if got, want := status, 0; got != want {
+		t.Fatalf("%s: status = %v, want %v", out.String(), got, want)
+	}
+	for _, call := range loadCalls {
+		if got, want := strings.Contains(out.String(), call), true; got != want {
+			t.Errorf("%s: strings.Contains(out.String(), call) = %v, want %v", call, got, want)
+		}
+	}
+	if got, want := len(s.store.Installations), 3; got != want {
+		t.Errorf("len(s.store.Installations) = %d, want %d", got, want)
+	}
+	if got, want := len(s.store.Sessions) >= 3, true; got != want {
+		t.Errorf("len(s.store.Sessions) >= 3 = %v, want %v", got, want)
+	}
+	for _, x := range s.store.Sessions {
+		if got, want := x.ClosedReason, ClosedByClient; got != want {
+			t.Errorf("x.ClosedReason = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLoadTestFailures(t *testing.T) {
+	newLoadtestTest(t)
+	setConfig(&Config{APIKeys: &APIKeysConfig{Mode: APIKeysRequired}})
+	server := httptest.NewServer(APIHandler())
+	defer server.Close()
+	var out bytes.Buffer
+	status := runLoadtest([]string{"-url", server.URL + "/2", "-clients", "1", "-duration", "50ms",
+		"-ping-interval", "20ms"}, &out, &out)
+	if got, want := status, 1; got != want {
+		t.Errorf("status = %v, want %v", got, want)
+	}
+	if got, pattern := out.String(), `(?s).*first failure of /installation/new: 401 Unauthorized: Missing X-API-Key header\n.*`; !fullMatch(pattern, got) {
+		t.Errorf("out.String() = %q, want a match of %q", got, pattern)
+	}
+	if got, want := runLoadtest([]string{"-clients", "0"}, &out, &out), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoadTestPercentile(t *testing.T) {
+	newLoadtestTest(t)
+	var ds []time.Duration
+	for i := 1; i <= 100; i++ {
+		ds = append(ds, time.Duration(i)*time.Millisecond)
+	}
+	if got, want := percentile(ds, 0.5), 50*time.Millisecond; got != want {
+		t.Errorf("percentile(ds, 0.5) = %v, want %v", got, want)
+	}
+	if got, want := percentile(ds, 0.99), 99*time.Millisecond; got != want {
+		t.Errorf("percentile(ds, 0.99) = %v, want %v", got, want)
+	}
+	if got, want := percentile(ds[:1], 0.9), time.Millisecond; got != want {
+		t.Errorf("percentile(ds[:1], 0.9) = %v, want %v", got, want)
+	}
+}
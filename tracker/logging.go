@@ -0,0 +1,282 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Levels of log lines, from the least to the most severe.
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// parseLevel returns the level named s, info if s is empty.
+func parseLevel(s string) (int, bool) {
+	if s == "" {
+		return LevelInfo, true
+	}
+	for level, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// Outputs of the log.
+const (
+	LogStderr = "stderr"
+	LogFile   = "file"
+	LogSyslog = "syslog"
+)
+
+// Defaults of the file output.
+const (
+	defaultLogMaxSizeMB  = 100
+	defaultLogMaxBackups = 5
+)
+
+// logSink writes log lines, without a trailing newline, to an output.
+type logSink interface {
+	write(level int, line string) error
+}
+
+// writerSink writes timestamped lines to a writer, like stderr.
+type writerSink struct {
+	w io.Writer
+}
+
+func (s *writerSink) write(level int, line string) error {
+	_, err := fmt.Fprintf(s.w, "%s %s\n", time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), line)
+	return err
+}
+
+// rotatingFile writes timestamped lines to a file. When the file would grow
+// past maxBytes, it is renamed to path.1, path.1 to path.2 and so on, keeping
+// the given number of backups.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	backups  int
+	f        *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxBytes int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxBytes: maxBytes, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	for i := rf.backups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if rf.backups > 0 {
+		os.Rename(rf.path, rf.path+".1")
+	} else {
+		os.Remove(rf.path)
+	}
+	return rf.open()
+}
+
+func (rf *rotatingFile) write(level int, line string) error {
+	b := []byte(fmt.Sprintf("%s %s\n", time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), line))
+	if rf.size > 0 && rf.size+int64(len(b)) > rf.maxBytes {
+		if err := rf.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := rf.f.Write(b)
+	rf.size += int64(n)
+	return err
+}
+
+// syslogSink sends lines to syslog, which timestamps them, at the
+// priority of their level.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func (s *syslogSink) write(level int, line string) error {
+	switch level {
+	case LevelDebug:
+		return s.w.Debug(line)
+	case LevelInfo:
+		return s.w.Info(line)
+	case LevelWarn:
+		return s.w.Warning(line)
+	}
+	return s.w.Err(line)
+}
+
+// logOutput is where the lines of every Logger go, stderr until
+// configureLog is called.
+var logOutput = struct {
+	sync.Mutex
+	level int
+	sink  logSink
+}{level: LevelInfo, sink: &writerSink{os.Stderr}}
+
+// configureLog opens the output of conf and sets the level. Lines go to
+// stderr when the output cannot be opened.
+func configureLog(conf *LogConfig) error {
+	setLogLevel(conf)
+	if conf == nil {
+		return nil
+	}
+	var sink logSink
+	switch conf.Output {
+	case "", LogStderr:
+		sink = &writerSink{os.Stderr}
+	case LogFile:
+		maxSize, backups := conf.MaxSizeMB, conf.MaxBackups
+		if maxSize == 0 {
+			maxSize = defaultLogMaxSizeMB
+		}
+		if backups == 0 {
+			backups = defaultLogMaxBackups
+		}
+		rf, err := openRotatingFile(conf.File, int64(maxSize)<<20, backups)
+		if err != nil {
+			return err
+		}
+		sink = rf
+	case LogSyslog:
+		network, addr := "", ""
+		if conf.Syslog != "" {
+			network, addr = "udp", conf.Syslog
+		}
+		w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "elephant-tracker")
+		if err != nil {
+			return err
+		}
+		sink = &syslogSink{w}
+	}
+	logOutput.Lock()
+	defer logOutput.Unlock()
+	logOutput.sink = sink
+	return nil
+}
+
+// setLogLevel sets the level of conf, which follows configuration reloads.
+func setLogLevel(conf *LogConfig) {
+	level := LevelInfo
+	if conf != nil {
+		level, _ = parseLevel(conf.Level)
+	}
+	logOutput.Lock()
+	defer logOutput.Unlock()
+	logOutput.level = level
+}
+
+// A Logger logs lines at a level, like "warn", tagged with the id of the
+// request being served, if any. A nil Logger logs untagged lines.
+type Logger struct {
+	RequestId string
+}
+
+// logger logs what is not about a request.
+var logger *Logger
+
+func (l *Logger) log(level int, message string) {
+	logOutput.Lock()
+	defer logOutput.Unlock()
+	if level < logOutput.level {
+		return
+	}
+	line := strings.ToUpper(levelNames[level]) + " "
+	if l != nil && l.RequestId != "" {
+		line += "request_id=" + l.RequestId + " "
+	}
+	line += strings.TrimSuffix(message, "\n")
+	if err := logOutput.sink.write(level, line); err != nil {
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+// Debug, Info, Warn and Error log their arguments as fmt.Sprintln does,
+// and their f variants as fmt.Sprintf does.
+func (l *Logger) Debug(v ...interface{}) {
+	l.log(LevelDebug, fmt.Sprintln(v...))
+}
+
+func (l *Logger) Info(v ...interface{}) {
+	l.log(LevelInfo, fmt.Sprintln(v...))
+}
+
+func (l *Logger) Warn(v ...interface{}) {
+	l.log(LevelWarn, fmt.Sprintln(v...))
+}
+
+func (l *Logger) Error(v ...interface{}) {
+	l.log(LevelError, fmt.Sprintln(v...))
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.log(LevelDebug, fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.log(LevelInfo, fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.log(LevelWarn, fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.log(LevelError, fmt.Sprintf(format, v...))
+}
+
+// Fatal logs its arguments at the error level and exits with status 1.
+func (l *Logger) Fatal(v ...interface{}) {
+	l.Error(v...)
+	os.Exit(1)
+}
+
+// maxRequestIdLength caps the request ids taken from clients.
+const maxRequestIdLength = 64
+
+// requestId returns the id of r, from its X-Request-Id header, as set by a
+// reverse proxy, or a new random one.
+func requestId(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" && len(id) <= maxRequestIdLength && !strings.ContainsAny(id, " \t\r\n") {
+		return id
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,123 @@
+package tracker
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type loggingTest struct {
+	buf *bytes.Buffer
+}
+
+func newLoggingTest(t *testing.T) *loggingTest {
+	s := &loggingTest{}
+	s.buf = &bytes.Buffer{}
+	logOutput.sink = &writerSink{s.buf}
+	t.Cleanup(func() {
+		logOutput.sink = &writerSink{os.Stderr}
+		setLogLevel(nil)
+	})
+	return s
+}
+
+// lines returns the logged lines without their timestamps.
+func (s *loggingTest) lines() []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(s.buf.String()), "\n") {
+		if line != "" {
+			lines = append(lines, strings.SplitN(line, " ", 2)[1])
+		}
+	}
+	return lines
+}
+
+func TestLogLevels(t *testing.T) {
+	s := newLoggingTest(t)
+	setLogLevel(&LogConfig{Level: "warn"})
+	logger.Debug("[jobs] started")
+	logger.Info("[jobs] done")
+	logger.Warnf("[mongo] reconnect failed, retrying in %s", "1s")
+	(&Logger{"4f2a"}).Error("not found")
+	if got, want := s.lines(), ([]string{
+		"WARN [mongo] reconnect failed, retrying in 1s",
+		"ERROR request_id=4f2a not found",
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("s.lines() = %v, want %v", got, want)
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	newLoggingTest(t)
+	var logged *Logger
+	h := contextualHandlerFunc(func(w http.ResponseWriter, r *http.Request, ctx *Context) {
+		logged = ctx.Log
+	})
+	openStore = func() (Storage, func()) { return NewMemoryStore(), func() {} }
+
+	req, _ := http.NewRequest("GET", "/1/stats", nil)
+	req.Header.Set("X-Request-Id", "from-proxy")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got, want := w.Header().Get("X-Request-Id"), "from-proxy"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := logged.RequestId, "from-proxy"; got != want {
+		t.Errorf("logged.RequestId = %v, want %v", got, want)
+	}
+
+	req.Header.Set("X-Request-Id", "not an id")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got, pattern := logged.RequestId, "[0-9a-f]{16}"; !fullMatch(pattern, got) {
+		t.Errorf("logged.RequestId = %q, want a match of %q", got, pattern)
+	}
+	if got, want := w.Header().Get("X-Request-Id"), logged.RequestId; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	newLoggingTest(t)
+	path := filepath.Join(t.TempDir(), "tracker.log")
+	rf, err := openRotatingFile(path, 40, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"INFO first", "INFO second", "INFO third", "INFO fourth"} {
+		if err := rf.write(LevelInfo, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{"": "fourth", ".1": "third", ".2": "second"} {
+		b, err := ioutil.ReadFile(path + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.HasSuffix(string(b), want+"\n"), true; got != want {
+			t.Errorf("%s%s: %q: got %v, want %v", path, name, b, got, want)
+		}
+	}
+	_, err = os.Stat(path + ".3")
+	if got, want := os.IsNotExist(err), true; got != want {
+		t.Errorf("os.IsNotExist(err) = %v, want %v", got, want)
+	}
+}
+
+func TestLoggingValidate(t *testing.T) {
+	newLoggingTest(t)
+	conf := &Config{Log: &LogConfig{Level: "verbose", Output: "file", Syslog: "localhost"}}
+	if got, want := conf.validate(false), (ConfigErrors{
+		`log.level must be debug, info, warn or error, got "verbose"`,
+		"log.file is required with the file output",
+		`log.syslog must be a host:port, got "localhost"`,
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("conf.validate(false) = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,93 @@
+package tracker
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Formats of machine ids, as listed in machine_ids.formats.
+const (
+	MachineIdMAC   = "mac"
+	MachineIdUUID  = "uuid"
+	MachineIdOther = "other"
+)
+
+// machineIdFormats lists the valid values of machine_ids.formats.
+var machineIdFormats = []string{MachineIdMAC, MachineIdUUID, MachineIdOther}
+
+// parseMachineId returns the format of a machine id and its canonical
+// spelling. MAC addresses, as 6 or 8 hex pairs separated by colons or
+// hyphens, as groups of 4 hex digits separated by dots or as 12 bare hex
+// digits, are spelled as lowercase pairs separated by colons, as in
+// "00:26:cc:18:be:14". UUIDs, with or without hyphens and braces, are
+// spelled as lowercase hyphenated groups, as in
+// "6ba7b810-9dad-11d1-80b4-00c04fd430c8". Other ids are kept as they are.
+func parseMachineId(s string) (format, canonical string) {
+	if len(s) == 12 && isHex(s) {
+		s = s[0:4] + "." + s[4:8] + "." + s[8:12]
+	}
+	if mac, err := net.ParseMAC(s); err == nil && (len(mac) == 6 || len(mac) == 8) {
+		return MachineIdMAC, mac.String()
+	}
+	u := strings.Replace(strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}"), "-", "", -1)
+	if len(u) == 32 && isHex(u) && isUUIDSpelling(s) {
+		u = strings.ToLower(u)
+		return MachineIdUUID, u[0:8] + "-" + u[8:12] + "-" + u[12:16] + "-" + u[16:20] + "-" + u[20:]
+	}
+	return MachineIdOther, s
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// isUUIDSpelling reports whether s has the hyphens of a UUID at their
+// places, within optional braces.
+func isUUIDSpelling(s string) bool {
+	if strings.HasPrefix(s, "{") != strings.HasSuffix(s, "}") {
+		return false
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "{"), "}")
+	if !strings.Contains(s, "-") {
+		return len(s) == 32
+	}
+	return len(s) == 36 && s[8] == '-' && s[13] == '-' && s[18] == '-' && s[23] == '-'
+}
+
+// machineIdParam returns the machine_id param of r, canonicalized with
+// machine_ids.canonicalize, or an invalid_machine_id error when its format
+// is not among machine_ids.formats.
+func machineIdParam(r *http.Request, conf *Config) (string, *APIError) {
+	return machineIdField(r, conf, "machine_id")
+}
+
+// machineIdField is like machineIdParam, for a machine id in another param.
+func machineIdField(r *http.Request, conf *Config, field string) (string, *APIError) {
+	id := r.PostFormValue(field)
+	if id == "" || conf == nil || conf.MachineIds == nil {
+		return id, nil
+	}
+	format, canonical := parseMachineId(id)
+	if formats := conf.MachineIds.Formats; len(formats) > 0 && !contains(formats, format) {
+		return id, &APIError{"invalid_machine_id", field, "",
+			fmt.Sprintf("Invalid machine id %s, expected one of %v", id, formats)}
+	}
+	if conf.MachineIds.Canonicalize {
+		id = canonical
+	}
+	return id, nil
+}
+
+// canonicalMachineId is the machine id stored for id, to look up a machine
+// named by an admin whatever the spelling.
+func canonicalMachineId(conf *Config, id string) string {
+	if conf == nil || conf.MachineIds == nil || !conf.MachineIds.Canonicalize {
+		return id
+	}
+	_, canonical := parseMachineId(id)
+	return canonical
+}
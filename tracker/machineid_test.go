@@ -0,0 +1,37 @@
+package tracker
+
+import (
+	"testing"
+)
+
+func TestMachineIdParse(t *testing.T) {
+	for in, want := range map[string][2]string{
+		"00:26:cc:18:be:14":                      {MachineIdMAC, "00:26:cc:18:be:14"},
+		"00-26-CC-18-BE-14":                      {MachineIdMAC, "00:26:cc:18:be:14"},
+		"0026.cc18.be14":                         {MachineIdMAC, "00:26:cc:18:be:14"},
+		"0026CC18BE14":                           {MachineIdMAC, "00:26:cc:18:be:14"},
+		"00:26:cc:ff:fe:18:be:14":                {MachineIdMAC, "00:26:cc:ff:fe:18:be:14"},
+		"6BA7B810-9DAD-11D1-80B4-00C04FD430C8":   {MachineIdUUID, "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		"{6ba7b810-9dad-11d1-80b4-00c04fd430c8}": {MachineIdUUID, "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		"6ba7b8109dad11d180b400c04fd430c8":       {MachineIdUUID, "6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		"6ba7b8-109dad-11d1-80b4-00c04fd430c8":   {MachineIdOther, "6ba7b8-109dad-11d1-80b4-00c04fd430c8"},
+		"{6ba7b810-9dad-11d1-80b4-00c04fd430c8":  {MachineIdOther, "{6ba7b810-9dad-11d1-80b4-00c04fd430c8"},
+		"00:26:cc:18:be":                         {MachineIdOther, "00:26:cc:18:be"},
+		"DESKTOP-4F2A":                           {MachineIdOther, "DESKTOP-4F2A"},
+	} {
+		format, canonical := parseMachineId(in)
+		if got, want := ([2]string{format, canonical}), want; got != want {
+			t.Errorf("%s: ([2]string{format, canonical}) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestMachineIdCanonical(t *testing.T) {
+	if got, want := canonicalMachineId(nil, "00-26-CC-18-BE-14"), "00-26-CC-18-BE-14"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	conf := &Config{MachineIds: &MachineIdsConfig{Canonicalize: true}}
+	if got, want := canonicalMachineId(conf, "00-26-CC-18-BE-14"), "00:26:cc:18:be:14"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
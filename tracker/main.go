@@ -0,0 +1,173 @@
+package tracker
+
+import (
+	"flag"
+	"fmt"
+	"labix.org/v2/mgo"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Main runs the elephant-tracker command with the flags and arguments of
+// the process: it serves the API, or runs the subcommand given.
+func Main() {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	ensureIndexes := fs.Bool("ensure-indexes", false, "create missing MongoDB indexes on startup")
+	checkOnly := fs.Bool("check", false, "run the startup self-check, print the report and exit")
+	readOnly := fs.Bool("read-only", false, "serve reads only, refusing writes with 503 and running no jobs")
+	mock := fs.Bool("mock", false, "serve the API from memory, without MongoDB, for client testing")
+	mockAddr := fs.String("mock-addr", "localhost:8080", "address to serve at in mock mode")
+	mockScript := fs.String("mock-script", "", "path to a JSON file with scripted mock responses")
+	src := &configSource{overrides: newConfigOverrides(fs)}
+	fs.Parse(os.Args[1:])
+	src.mock = *mock
+	// The admin client and the load test only talk to a running tracker.
+	if fs.Arg(0) == "admin" {
+		os.Exit(runAdmin(fs.Args()[1:], os.Stdout, os.Stderr))
+	}
+	if fs.Arg(0) == "loadtest" {
+		os.Exit(runLoadtest(fs.Args()[1:], os.Stdout, os.Stderr))
+	}
+	if fs.Arg(0) == "migrate" {
+		os.Exit(runMigrate(fs.Args()[1:], src, os.Stdout, os.Stderr))
+	}
+	if fs.Arg(0) == "backfill" {
+		os.Exit(runBackfill(fs.Args()[1:], src, os.Stdout, os.Stderr))
+	}
+	config, session, check := bootstrap(src, *ensureIndexes && !*readOnly)
+	check.Report(os.Stderr)
+	if *checkOnly {
+		if !check.Passed() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if check.Fatal() {
+		os.Exit(1)
+	}
+	if *mock {
+		server := newMockServer(config)
+		server.source = src
+		logger.Fatal(serveMock(server, *mockAddr, *mockScript))
+	}
+	server := NewServer(config, session)
+	server.ReadOnly = *readOnly
+	server.source = src
+	if !*readOnly && config.Queue != nil && config.Queue.Path != "" {
+		q, err := OpenWriteQueue(config.Queue.Path, queueMaxEntries(config))
+		if err != nil {
+			logger.Fatal("[queue]", err)
+		}
+		server.writeQueue = q
+	}
+
+	go reloadOnSIGHUP(server)
+
+	drained := drainOnSignal(server)
+	if err := server.Run(); err != nil {
+		logger.Fatal(err)
+	}
+	<-drained
+	logger.Info("[shutdown] done")
+}
+
+// bootstrap loads the configuration of src and connects to MongoDB,
+// checking everything the server needs along the way, and creating the
+// missing indexes if ensureIndexes. The session is nil in mock mode or
+// when MongoDB is unreachable.
+func bootstrap(src *configSource, ensureIndexes bool) (*Config, *mgo.Session, *SelfCheck) {
+	check := &SelfCheck{}
+	config, err := loadConfig(src)
+	if err == nil {
+		err = validateConfig(src, config)
+	}
+	check.Check("config", err, true)
+	if err != nil {
+		return nil, nil, check
+	}
+	// Log to stderr anyway.
+	check.Check("log", configureLog(config.Log), false)
+	enableMgoStats(config)
+
+	var session *mgo.Session
+	if src.mock {
+		check.Skip("storage", "mock mode serves from memory")
+	} else {
+		session, err = dialMongo(config)
+		check.Check("storage", err, true)
+	}
+
+	switch {
+	case session == nil:
+		check.Skip("indexes", "no storage")
+	case ensureIndexes:
+		// Serve anyway: queries still work without indexes, only slower.
+		check.Check("indexes", eachApp(&MongoStore{session.DB(config.Mongo.DB)}, config, Storage.EnsureIndexes), false)
+	default:
+		check.Check("indexes", checkIndexes(session.DB(config.Mongo.DB)), false)
+	}
+	if src.mock || config.Cache == nil {
+		check.Skip("cache", "not configured")
+	} else {
+		cache := newRedisCache(config.Cache)
+		// Serve anyway: storage is used while the cache fails.
+		check.Check("cache", cache.ping(), false)
+		cache.pool.Close()
+	}
+	if session == nil {
+		check.Skip("migrations", "no storage")
+	} else {
+		check.Check("migrations", checkMigrations(&MongoStore{session.DB(config.Mongo.DB)}), false)
+	}
+	check.Check("clock", checkClock(session), false)
+	check.Check("temp_dir", checkTempDir(), false)
+	return config, session, check
+}
+
+// dialMongo connects to the MongoDB server of config.
+func dialMongo(config *Config) (*mgo.Session, error) {
+	// Set session timeout to fail early and avoid long response times.
+	session, err := mgo.DialWithTimeout(config.Mongo.URL, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	tuneSession(session, config)
+	if err := session.Ping(); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// offlineStore connects to the storage of the configuration of src for the
+// subcommands that work on it without serving, like migrate.
+func offlineStore(src *configSource) (*MongoStore, func(), error) {
+	config, err := loadConfig(src)
+	if err == nil {
+		err = validateConfig(src, config)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	session, err := dialMongo(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("[storage] %v", err)
+	}
+	return &MongoStore{session.DB(config.Mongo.DB)}, session.Close, nil
+}
+
+// reloadOnSIGHUP reloads the configuration file of server every time the
+// process gets a SIGHUP.
+func reloadOnSIGHUP(server *Server) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for _ = range c {
+		if err := server.Reload(); err != nil {
+			logger.Error("[config] reload failed, keeping current configuration:", err)
+			continue
+		}
+		logger.Info("[config] reloaded")
+	}
+}
@@ -0,0 +1,1909 @@
+package tracker
+
+import (
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Storage that keeps everything in memory.
+// It mimics the semantics and errors of MongoStore and is safe for concurrent use.
+type MemoryStore struct {
+	sync.Mutex
+	Installations map[string]*Installation
+	Sessions      map[SessionId]*Session
+	Audit         []*AuditEntry
+	JobRunLog     []*JobRun
+	Crashes       map[string]*CrashGroup
+	Events        []*Event
+	FeedbackList  []*Feedback
+	BlockList     []*Block
+	APIKeyList    []*APIKey
+	WebhookList   []*Webhook
+	Features      map[string]*FeatureFlag
+	ReleaseList   []*Release
+	Claims        map[string]*Claim
+	Snapshots     []*StorageSnapshot
+	RollupList    map[string]*Rollup
+	MigrationLog  []*MigrationRun
+	Checkpoints   map[string]*Checkpoint
+	Leases        map[string]*memoryLease
+	RateCounters  map[string]int
+	FlagList      []*Flag
+
+	// apps holds the stores of the apps other than the default one, see appStore.
+	apps map[string]*MemoryStore
+}
+
+type memoryLease struct {
+	Holder    string
+	ExpiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		Installations: make(map[string]*Installation),
+		Sessions:      make(map[SessionId]*Session),
+		Crashes:       make(map[string]*CrashGroup),
+		Claims:        make(map[string]*Claim),
+		Features:      make(map[string]*FeatureFlag),
+		RollupList:    make(map[string]*Rollup),
+		Checkpoints:   make(map[string]*Checkpoint),
+		Leases:        make(map[string]*memoryLease),
+		RateCounters:  make(map[string]int),
+	}
+}
+
+// app returns the store of app, created on first use.
+func (ms *MemoryStore) app(app string) *MemoryStore {
+	ms.Lock()
+	defer ms.Unlock()
+	if ms.apps == nil {
+		ms.apps = make(map[string]*MemoryStore)
+	}
+	if ms.apps[app] == nil {
+		ms.apps[app] = NewMemoryStore()
+	}
+	return ms.apps[app]
+}
+
+// errDup is what MongoStore returns on a duplicate key, so that mgo.IsDup(errDup) == true.
+var errDup = &mgo.QueryError{Code: 11000, Message: "duplicate key"}
+
+func (ms *MemoryStore) InsertInstallation(i *Installation) error {
+	ms.Lock()
+	defer ms.Unlock()
+	if _, ok := ms.Installations[i.MachineId]; ok {
+		return errDup
+	}
+	ms.Installations[i.MachineId] = i
+	return nil
+}
+
+func (ms *MemoryStore) InsertSession(s *Session) error {
+	ms.Lock()
+	defer ms.Unlock()
+	if _, ok := ms.Sessions[s.Id]; ok {
+		return errDup
+	}
+	if s.Alias != "" {
+		for _, mss := range ms.Sessions {
+			if mss.Alias == s.Alias {
+				return errDup
+			}
+		}
+	}
+	ms.Sessions[s.Id] = s
+	return nil
+}
+
+// openSession returns the open session matching the id and machine id of s.
+func (ms *MemoryStore) openSession(s *Session) (*Session, bool) {
+	mss, ok := ms.Sessions[s.Id]
+	if !ok || mss.MachineId != s.MachineId || !mss.ClosedAt.IsZero() {
+		return nil, false
+	}
+	return mss, true
+}
+
+func (ms *MemoryStore) CloseSession(s *Session) error {
+	ms.Lock()
+	defer ms.Unlock()
+	mss, ok := ms.openSession(s)
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	mss.ClosedAt = bson.Now()
+	mss.ClosedReason = s.ClosedReason
+	*s = *mss
+	return nil
+}
+
+func (ms *MemoryStore) PingSession(s *Session) error {
+	ms.Lock()
+	defer ms.Unlock()
+	mss, ok := ms.openSession(s)
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	if s.LastPing.IsZero() {
+		mss.LastPing = bson.Now()
+	} else if s.LastPing.After(mss.LastPing) {
+		mss.LastPing = s.LastPing
+	}
+	if a := s.Activity; a != nil {
+		if mss.Activity == nil {
+			mss.Activity = &SessionActivity{}
+		}
+		mss.Activity.MessagesSent += a.MessagesSent
+		mss.Activity.MessagesReceived += a.MessagesReceived
+		if a.ContactsOnline != nil {
+			n := *a.ContactsOnline
+			mss.Activity.ContactsOnline = &n
+		}
+		if a.RTT != nil {
+			if mss.Activity.RTT == nil {
+				mss.Activity.RTT = &RTTSummary{}
+			}
+			mss.Activity.RTT.Add(a.RTT)
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStore) UpdateSessionMetadata(s *Session) error {
+	ms.Lock()
+	defer ms.Unlock()
+	mss, ok := ms.openSession(s)
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	metadata := make(map[string]string)
+	for key, value := range mss.Metadata {
+		metadata[key] = value
+	}
+	for key, value := range s.Metadata {
+		if value == "" {
+			delete(metadata, key)
+		} else {
+			metadata[key] = value
+		}
+	}
+	if len(metadata) == 0 {
+		metadata = nil
+	}
+	mss.Metadata, mss.LastPing = metadata, bson.Now()
+	return nil
+}
+
+func (ms *MemoryStore) TransferSession(s *Session, to string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	mss, ok := ms.openSession(s)
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	t := &SessionTransfer{From: mss.MachineId, To: to, At: bson.Now()}
+	mss.MachineId, mss.LastPing = to, t.At
+	mss.Transfers = append(mss.Transfers, t)
+	*s = *mss
+	return nil
+}
+
+func (ms *MemoryStore) ReopenSession(s *Session, closedSince time.Time) error {
+	ms.Lock()
+	defer ms.Unlock()
+	mss, ok := ms.Sessions[s.Id]
+	if !ok || mss.ClosedAt.Before(closedSince) {
+		return mgo.ErrNotFound
+	}
+	*s = *mss
+	mss.ClosedAt = time.Time{}
+	mss.ClosedReason = ""
+	mss.LastPing = bson.Now()
+	return nil
+}
+
+func (ms *MemoryStore) ResumeSession(s *Session, closedSince time.Time) error {
+	ms.Lock()
+	defer ms.Unlock()
+	var latest *Session
+	for _, mss := range ms.Sessions {
+		if mss.MachineId == s.MachineId && mss.JID == s.JID &&
+			(latest == nil || mss.CreatedAt.After(latest.CreatedAt)) {
+			latest = mss
+		}
+	}
+	if latest == nil || !resumable(latest, closedSince) {
+		return mgo.ErrNotFound
+	}
+	*s = *latest
+	latest.ClosedAt = time.Time{}
+	latest.ClosedReason = ""
+	latest.LastPing = bson.Now()
+	return nil
+}
+
+func (ms *MemoryStore) InsertAuditEntry(a *AuditEntry) error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.Audit = append(ms.Audit, a)
+	return nil
+}
+
+type sessionsByCreation []*Session
+
+func (s sessionsByCreation) Len() int { return len(s) }
+func (s sessionsByCreation) Less(i, j int) bool {
+	if s[i].CreatedAt.Equal(s[j].CreatedAt) {
+		return s[i].Id < s[j].Id
+	}
+	return s[i].CreatedAt.Before(s[j].CreatedAt)
+}
+func (s sessionsByCreation) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+type installationsByCreation []*Installation
+
+func (s installationsByCreation) Len() int { return len(s) }
+func (s installationsByCreation) Less(i, j int) bool {
+	if s[i].CreatedAt.Equal(s[j].CreatedAt) {
+		return s[i].MachineId < s[j].MachineId
+	}
+	return s[i].CreatedAt.Before(s[j].CreatedAt)
+}
+func (s installationsByCreation) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// sessions returns copies of all sessions, oldest first.
+func (ms *MemoryStore) sessions() []*Session {
+	ms.Lock()
+	defer ms.Unlock()
+	sessions := make([]*Session, 0, len(ms.Sessions))
+	for _, s := range ms.Sessions {
+		c := *s
+		sessions = append(sessions, &c)
+	}
+	sort.Sort(sessionsByCreation(sessions))
+	return sessions
+}
+
+// installations returns copies of all installations, oldest first.
+func (ms *MemoryStore) installations() []*Installation {
+	ms.Lock()
+	defer ms.Unlock()
+	installations := make([]*Installation, 0, len(ms.Installations))
+	for _, i := range ms.Installations {
+		c := *i
+		installations = append(installations, &c)
+	}
+	sort.Sort(installationsByCreation(installations))
+	return installations
+}
+
+func (ms *MemoryStore) EachSession(w TimeWindow, skip int, fn func(*Session) error) error {
+	for _, s := range ms.sessions() {
+		if !w.Contains(s.CreatedAt) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStore) EachInstallation(w TimeWindow, skip int, fn func(*Installation) error) error {
+	for _, i := range ms.installations() {
+		if !w.Contains(i.CreatedAt) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if err := fn(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeSessions closes the sessions of list with reason, returning copies
+// of them as closed. ms must be locked.
+func (ms *MemoryStore) closeSessions(list []*Session, reason string) []*Session {
+	var closed []*Session
+	now := bson.Now()
+	for _, mss := range list {
+		mss.ClosedAt, mss.ClosedReason = now, reason
+		x := *mss
+		closed = append(closed, &x)
+	}
+	return closed
+}
+
+func (ms *MemoryStore) ExpireSessions(before time.Time) ([]*Session, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var expired []*Session
+	for _, s := range ms.Sessions {
+		if s.ClosedAt.IsZero() && s.CreatedAt.Before(before) && s.LastPing.Before(before) {
+			expired = append(expired, s)
+		}
+	}
+	return ms.closeSessions(expired, ClosedExpired), nil
+}
+
+func (ms *MemoryStore) CloseSupersededSessions(s *Session) ([]*Session, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var superseded []*Session
+	for _, mss := range ms.Sessions {
+		if mss.Id != s.Id && mss.MachineId == s.MachineId && mss.JID == s.JID && mss.ClosedAt.IsZero() {
+			superseded = append(superseded, mss)
+		}
+	}
+	return ms.closeSessions(superseded, ClosedSuperseded), nil
+}
+
+func (ms *MemoryStore) CountOpenSessions(machineId string) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	n := 0
+	for _, s := range ms.Sessions {
+		if s.MachineId == machineId && s.ClosedAt.IsZero() {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (ms *MemoryStore) CountAllOpenSessions() (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	n := 0
+	for _, s := range ms.Sessions {
+		if s.ClosedAt.IsZero() {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (ms *MemoryStore) CloseExcessSessions(s *Session, keep int) ([]*Session, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var open []*Session
+	for _, mss := range ms.Sessions {
+		if mss.Id != s.Id && mss.MachineId == s.MachineId && mss.ClosedAt.IsZero() {
+			open = append(open, mss)
+		}
+	}
+	if len(open) <= keep {
+		return nil, nil
+	}
+	sort.Sort(sessionsByCreation(open))
+	return ms.closeSessions(open[:len(open)-keep], ClosedOverLimit), nil
+}
+
+func (ms *MemoryStore) InsertJobRun(run *JobRun) error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.JobRunLog = append(ms.JobRunLog, run)
+	return nil
+}
+
+func (ms *MemoryStore) JobRuns(job string, limit int) ([]*JobRun, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var runs []*JobRun
+	for i := len(ms.JobRunLog) - 1; i >= 0 && len(runs) < limit; i-- {
+		if ms.JobRunLog[i].Job == job {
+			runs = append(runs, ms.JobRunLog[i])
+		}
+	}
+	return runs, nil
+}
+
+func (ms *MemoryStore) FindSession(id SessionId) (*Session, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	s, ok := ms.Sessions[id]
+	if !ok {
+		return nil, mgo.ErrNotFound
+	}
+	c := *s
+	return &c, nil
+}
+
+func (ms *MemoryStore) FindSessionByAlias(alias string) (*Session, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, s := range ms.Sessions {
+		if s.Alias == alias {
+			c := *s
+			return &c, nil
+		}
+	}
+	return nil, mgo.ErrNotFound
+}
+
+func (ms *MemoryStore) RecordCrash(signature, traceback string, c *Crash) error {
+	ms.Lock()
+	defer ms.Unlock()
+	g, ok := ms.Crashes[signature]
+	if !ok {
+		g = &CrashGroup{Signature: signature, Traceback: traceback, FirstSeen: c.At}
+		ms.Crashes[signature] = g
+	}
+	g.Count++
+	g.LastSeen = c.At
+	found := false
+	for _, v := range g.Versions {
+		found = found || v == c.XMPPVOXVersion
+	}
+	if !found {
+		g.Versions = append(g.Versions, c.XMPPVOXVersion)
+	}
+	g.Recent = append(g.Recent, c)
+	if len(g.Recent) > maxCrashOccurrences {
+		g.Recent = g.Recent[len(g.Recent)-maxCrashOccurrences:]
+	}
+	return nil
+}
+
+type crashGroupsBySeen []*CrashGroup
+
+func (s crashGroupsBySeen) Len() int           { return len(s) }
+func (s crashGroupsBySeen) Less(i, j int) bool { return s[i].LastSeen.After(s[j].LastSeen) }
+func (s crashGroupsBySeen) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (ms *MemoryStore) CrashGroups(limit int) ([]*CrashGroup, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var groups []*CrashGroup
+	for _, g := range ms.Crashes {
+		c := *g
+		groups = append(groups, &c)
+	}
+	sort.Sort(crashGroupsBySeen(groups))
+	if len(groups) > limit {
+		groups = groups[:limit]
+	}
+	return groups, nil
+}
+
+func (ms *MemoryStore) InsertEvent(e *Event) error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.Events = append(ms.Events, e)
+	return nil
+}
+
+func (ms *MemoryStore) InsertFeedback(f *Feedback) error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.FeedbackList = append(ms.FeedbackList, f)
+	return nil
+}
+
+func (ms *MemoryStore) RecentFeedback(skip, limit int) ([]*Feedback, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var recent []*Feedback
+	for i := len(ms.FeedbackList) - 1 - skip; i >= 0 && len(recent) < limit; i-- {
+		f := *ms.FeedbackList[i]
+		recent = append(recent, &f)
+	}
+	return recent, nil
+}
+
+func (ms *MemoryStore) EachFeedback(w TimeWindow, skip int, fn func(*Feedback) error) error {
+	ms.Lock()
+	feedback := append([]*Feedback(nil), ms.FeedbackList...)
+	ms.Unlock()
+	for _, f := range feedback {
+		if !w.Contains(f.CreatedAt) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStore) NewestActivity() (time.Time, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var newest time.Time
+	for _, s := range ms.Sessions {
+		if s.CreatedAt.After(newest) {
+			newest = s.CreatedAt
+		}
+	}
+	for _, i := range ms.Installations {
+		if i.CreatedAt.After(newest) {
+			newest = i.CreatedAt
+		}
+	}
+	return newest, nil
+}
+
+func (ms *MemoryStore) OpenSessions(skip, limit int) ([]*Session, error) {
+	var open []*Session
+	sessions := ms.sessions()
+	for i := len(sessions) - 1; i >= 0 && len(open) < limit; i-- {
+		if !sessions[i].ClosedAt.IsZero() {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		open = append(open, sessions[i])
+	}
+	return open, nil
+}
+
+func (ms *MemoryStore) RecentInstallations(skip, limit int) ([]*Installation, error) {
+	var recent []*Installation
+	installations := ms.installations()
+	for i := len(installations) - 1 - skip; i >= 0 && len(recent) < limit; i-- {
+		recent = append(recent, installations[i])
+	}
+	return recent, nil
+}
+
+func (ms *MemoryStore) TaggedInstallations(tag string, skip, limit int) ([]*Installation, error) {
+	var found []*Installation
+	installations := ms.installations()
+	for i := len(installations) - 1; i >= 0 && len(found) < limit; i-- {
+		if !hasTag(installations[i].Tags, tag) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		found = append(found, installations[i])
+	}
+	return found, nil
+}
+
+// hasTag reports whether tags has tag.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms *MemoryStore) MachineSessions(machineId, tag string, skip, limit int) ([]*Session, error) {
+	var found []*Session
+	sessions := ms.sessions()
+	for i := len(sessions) - 1; i >= 0 && len(found) < limit; i-- {
+		if sessions[i].MachineId != machineId || tag != "" && !hasTag(sessions[i].Tags, tag) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		found = append(found, sessions[i])
+	}
+	return found, nil
+}
+
+func (ms *MemoryStore) UserSessions(jid string, window TimeWindow, tag string, skip, limit int) ([]*Session, error) {
+	var found []*Session
+	sessions := ms.sessions()
+	for i := len(sessions) - 1; i >= 0 && len(found) < limit; i-- {
+		s := sessions[i]
+		if s.JID != jid || !window.Contains(s.CreatedAt) || tag != "" && !hasTag(s.Tags, tag) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		found = append(found, s)
+	}
+	return found, nil
+}
+
+func (ms *MemoryStore) SessionChanges(w TimeWindow) ([]*Session, error) {
+	var changed []*Session
+	for _, s := range ms.sessions() {
+		if w.Contains(s.CreatedAt) || !s.ClosedAt.IsZero() && w.Contains(s.ClosedAt) {
+			changed = append(changed, s)
+		}
+	}
+	return changed, nil
+}
+
+func (ms *MemoryStore) SearchSessions(q *SessionQuery, skip, limit int) ([]*Session, error) {
+	var found []*Session
+	sessions := ms.sessions()
+	for i := len(sessions) - 1; i >= 0 && len(found) < limit; i-- {
+		if !q.Matches(sessions[i]) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		found = append(found, sessions[i])
+	}
+	return found, nil
+}
+
+// withTag returns a copy of tags with tag added once or, with remove, without it.
+func withTag(tags []string, tag string, remove bool) []string {
+	var updated []string
+	for _, t := range tags {
+		if t != tag {
+			updated = append(updated, t)
+		}
+	}
+	if !remove {
+		updated = append(updated, tag)
+	}
+	return updated
+}
+
+func (ms *MemoryStore) TagSession(id SessionId, tag string, remove bool) error {
+	ms.Lock()
+	defer ms.Unlock()
+	s, ok := ms.Sessions[id]
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	s.Tags = withTag(s.Tags, tag, remove)
+	return nil
+}
+
+func (ms *MemoryStore) TagInstallation(machineId, tag string, remove bool) error {
+	ms.Lock()
+	defer ms.Unlock()
+	i, ok := ms.Installations[machineId]
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	i.Tags = withTag(i.Tags, tag, remove)
+	return nil
+}
+
+func (ms *MemoryStore) AddSessionNote(id SessionId, n *Note) error {
+	ms.Lock()
+	defer ms.Unlock()
+	s, ok := ms.Sessions[id]
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	s.Notes = append(s.Notes, n)
+	return nil
+}
+
+func (ms *MemoryStore) AddInstallationNote(machineId string, n *Note) error {
+	ms.Lock()
+	defer ms.Unlock()
+	i, ok := ms.Installations[machineId]
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	i.Notes = append(i.Notes, n)
+	return nil
+}
+
+// removeCrashes removes the crash reports matching fn from the crash groups,
+// returning how many groups had reports removed.
+func (ms *MemoryStore) removeCrashes(fn func(*Crash) bool) int {
+	n := 0
+	for _, g := range ms.Crashes {
+		var kept []*Crash
+		for _, c := range g.Recent {
+			if !fn(c) {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) < len(g.Recent) {
+			g.Recent = kept
+			n++
+		}
+	}
+	return n
+}
+
+// eraseEvents removes the events matching fn or, when anonymize is not nil,
+// replaces them with a copy without properties changed by anonymize.
+// It returns how many events matched.
+func (ms *MemoryStore) eraseEvents(fn func(*Event) bool, anonymize func(*Event)) int {
+	n := 0
+	var kept []*Event
+	for _, ev := range ms.Events {
+		if !fn(ev) {
+			kept = append(kept, ev)
+			continue
+		}
+		n++
+		if anonymize != nil {
+			c := *ev
+			c.Properties = nil
+			anonymize(&c)
+			kept = append(kept, &c)
+		}
+	}
+	ms.Events = kept
+	return n
+}
+
+// eraseFeedback is like eraseEvents, for feedback, removing the text of
+// anonymized feedback.
+func (ms *MemoryStore) eraseFeedback(fn func(*Feedback) bool, anonymize func(*Feedback)) int {
+	n := 0
+	var kept []*Feedback
+	for _, f := range ms.FeedbackList {
+		if !fn(f) {
+			kept = append(kept, f)
+			continue
+		}
+		n++
+		if anonymize != nil {
+			c := *f
+			c.Text = ""
+			anonymize(&c)
+			kept = append(kept, &c)
+		}
+	}
+	ms.FeedbackList = kept
+	return n
+}
+
+// eraseSessions is like eraseEvents, for sessions, removing the fields in
+// anonymizedSessionFields from anonymized sessions.
+// It returns the ids of the sessions that matched.
+func (ms *MemoryStore) eraseSessions(fn func(*Session) bool, anonymize func(*Session)) map[SessionId]bool {
+	ids := make(map[SessionId]bool)
+	for id, s := range ms.Sessions {
+		if !fn(s) {
+			continue
+		}
+		ids[id] = true
+		if anonymize == nil {
+			delete(ms.Sessions, id)
+			continue
+		}
+		c := *s
+		c.Request, c.Alias, c.Secret, c.Notes, c.Resource = nil, "", "", nil, ""
+		anonymize(&c)
+		ms.Sessions[id] = &c
+	}
+	return ids
+}
+
+func (ms *MemoryStore) EraseUser(jid string, anonymize bool) (*Erasure, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var anonymizeSession func(*Session)
+	var anonymizeEvent func(*Event)
+	var anonymizeFeedback func(*Feedback)
+	if anonymize {
+		anonymizeSession = func(s *Session) { s.JID = "" }
+		anonymizeEvent = func(*Event) {}
+		anonymizeFeedback = func(*Feedback) {}
+	}
+	ids := ms.eraseSessions(func(s *Session) bool { return s.JID == jid }, anonymizeSession)
+	return &Erasure{
+		Sessions:    len(ids),
+		Events:      ms.eraseEvents(func(ev *Event) bool { return ids[ev.SessionId] }, anonymizeEvent),
+		Feedback:    ms.eraseFeedback(func(f *Feedback) bool { return ids[f.SessionId] }, anonymizeFeedback),
+		CrashGroups: ms.removeCrashes(func(c *Crash) bool { return ids[c.SessionId] }),
+	}, nil
+}
+
+func (ms *MemoryStore) EraseMachine(machineId string, anonymize bool, pseudonym string) (*Erasure, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var anonymizeSession func(*Session)
+	var anonymizeEvent func(*Event)
+	var anonymizeFeedback func(*Feedback)
+	if anonymize {
+		anonymizeSession = func(s *Session) { s.MachineId = pseudonym }
+		anonymizeEvent = func(ev *Event) { ev.MachineId = pseudonym }
+		anonymizeFeedback = func(f *Feedback) { f.MachineId = pseudonym }
+	}
+	e := &Erasure{
+		Sessions:    len(ms.eraseSessions(func(s *Session) bool { return s.MachineId == machineId }, anonymizeSession)),
+		Events:      ms.eraseEvents(func(ev *Event) bool { return ev.MachineId == machineId }, anonymizeEvent),
+		Feedback:    ms.eraseFeedback(func(f *Feedback) bool { return f.MachineId == machineId }, anonymizeFeedback),
+		CrashGroups: ms.removeCrashes(func(c *Crash) bool { return c.MachineId == machineId }),
+	}
+	for _, s := range ms.Sessions {
+		for _, t := range s.Transfers {
+			if t.From == machineId || t.To == machineId {
+				s.Transfers = nil
+				break
+			}
+		}
+	}
+	if i, ok := ms.Installations[machineId]; ok {
+		delete(ms.Installations, machineId)
+		if anonymize {
+			ms.Installations[pseudonym] = anonymizedInstallation(i, pseudonym)
+		}
+		e.Installations = 1
+	}
+	return e, nil
+}
+
+func (ms *MemoryStore) SessionsPerDay(since time.Time) ([]*DayCount, error) {
+	var counts []*DayCount
+	for _, s := range ms.sessions() {
+		if s.CreatedAt.Before(since) {
+			continue
+		}
+		day := utcDay(s.CreatedAt)
+		if n := len(counts); n > 0 && counts[n-1].Day.Equal(day) {
+			counts[n-1].Count++
+			continue
+		}
+		counts = append(counts, &DayCount{day, 1})
+	}
+	return counts, nil
+}
+
+func utcDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+type versionDayCounts []*VersionDayCount
+
+func (s versionDayCounts) Len() int { return len(s) }
+func (s versionDayCounts) Less(i, j int) bool {
+	if s[i].Day.Equal(s[j].Day) {
+		return s[i].Version < s[j].Version
+	}
+	return s[i].Day.Before(s[j].Day)
+}
+func (s versionDayCounts) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// countVersionsPerDay counts the created times per day and version,
+// both given in the same order.
+func countVersionsPerDay(created []time.Time, versions []string, from, to time.Time) []*VersionDayCount {
+	type key struct {
+		day     time.Time
+		version string
+	}
+	index := make(map[key]*VersionDayCount)
+	var counts []*VersionDayCount
+	for i, t := range created {
+		if t.Before(from) || !t.Before(to) {
+			continue
+		}
+		k := key{utcDay(t), versions[i]}
+		if vdc, ok := index[k]; ok {
+			vdc.Count++
+			continue
+		}
+		vdc := &VersionDayCount{k.version, DayCount{k.day, 1}}
+		index[k] = vdc
+		counts = append(counts, vdc)
+	}
+	sort.Sort(versionDayCounts(counts))
+	return counts
+}
+
+func (ms *MemoryStore) SessionVersionsPerDay(from, to time.Time) ([]*VersionDayCount, error) {
+	var created []time.Time
+	var versions []string
+	for _, s := range ms.sessions() {
+		created = append(created, s.CreatedAt)
+		versions = append(versions, s.XMPPVOXVersion)
+	}
+	return countVersionsPerDay(created, versions, from, to), nil
+}
+
+func (ms *MemoryStore) InstallationVersionsPerDay(from, to time.Time) ([]*VersionDayCount, error) {
+	var created []time.Time
+	var versions []string
+	for _, i := range ms.installations() {
+		created = append(created, i.CreatedAt)
+		versions = append(versions, i.XMPPVOXVersion)
+	}
+	return countVersionsPerDay(created, versions, from, to), nil
+}
+
+func (ms *MemoryStore) InstallationPlatforms(from, to time.Time) ([]*PlatformCount, error) {
+	counts := make(map[PlatformCount]int)
+	for _, i := range ms.installations() {
+		if i.CreatedAt.Before(from) || !i.CreatedAt.Before(to) {
+			continue
+		}
+		p := i.Platform
+		if p == nil {
+			p = parsePlatform(i.MachineInfo)
+		}
+		if p == nil {
+			p = &Platform{}
+		}
+		counts[PlatformCount{System: p.System, Release: p.Release, Machine: p.Machine}]++
+	}
+	var platforms []*PlatformCount
+	for pc, n := range counts {
+		pc := pc
+		pc.Count = n
+		platforms = append(platforms, &pc)
+	}
+	sort.Sort(platformsByName(platforms))
+	return platforms, nil
+}
+
+func (ms *MemoryStore) InstallationDosvoxVersions(from, to time.Time) ([]*VersionCount, error) {
+	counts := make(map[string]int)
+	for _, i := range ms.installations() {
+		if i.CreatedAt.Before(from) || !i.CreatedAt.Before(to) {
+			continue
+		}
+		v := i.DosvoxVersion
+		if v == "" {
+			v = i.DosvoxInfo["version"]
+		}
+		counts[v]++
+	}
+	versions := make([]string, 0, len(counts))
+	for v := range counts {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	var found []*VersionCount
+	for _, v := range versions {
+		found = append(found, &VersionCount{v, counts[v]})
+	}
+	return found, nil
+}
+
+func (ms *MemoryStore) InstallationUniques(from, to time.Time) (*UniqueInstallations, error) {
+	u := &UniqueInstallations{}
+	fingerprints := make(map[string]bool)
+	active := make(map[string]bool)
+	for _, i := range ms.installations() {
+		if i.CreatedAt.Before(from) || !i.CreatedAt.Before(to) {
+			continue
+		}
+		u.Machines++
+		fp := i.Fingerprint
+		if fp == "" {
+			fp = i.MachineId
+		}
+		if !fingerprints[fp] {
+			fingerprints[fp] = true
+			u.Fingerprints++
+		}
+		if !i.UninstalledAt.IsZero() {
+			u.Uninstalled++
+		} else if !active[fp] {
+			active[fp] = true
+			u.Active++
+		}
+	}
+	return u, nil
+}
+
+func (ms *MemoryStore) InstallationClients(by string, from, to time.Time, limit int) ([]*ClientCount, error) {
+	counts := make(map[string]int)
+	for _, i := range ms.installations() {
+		if i.CreatedAt.Before(from) || !i.CreatedAt.Before(to) {
+			continue
+		}
+		if by == ClientsByNetwork {
+			counts[i.Network]++
+		} else {
+			counts[i.UserAgent]++
+		}
+	}
+	var clients []*ClientCount
+	for v, n := range counts {
+		clients = append(clients, &ClientCount{v, n})
+	}
+	sort.Sort(clientsByCount(clients))
+	if len(clients) > limit {
+		clients = clients[:limit]
+	}
+	return clients, nil
+}
+
+func (ms *MemoryStore) SessionServers(from, to time.Time, limit int) ([]*ServerCount, error) {
+	window := TimeWindow{from, to}
+	counts := make(map[string]*ServerCount)
+	jids := make(map[string]map[string]bool)
+	machines := make(map[string]map[string]bool)
+	for _, s := range ms.sessions() {
+		if !window.Contains(s.CreatedAt) {
+			continue
+		}
+		sc, ok := counts[s.XMPPServer]
+		if !ok {
+			sc = &ServerCount{Server: s.XMPPServer}
+			counts[s.XMPPServer] = sc
+			jids[s.XMPPServer] = make(map[string]bool)
+			machines[s.XMPPServer] = make(map[string]bool)
+		}
+		sc.Sessions++
+		jids[sc.Server][s.JID] = true
+		machines[sc.Server][s.MachineId] = true
+		sc.UniqueJIDs, sc.UniqueMachines = len(jids[sc.Server]), len(machines[sc.Server])
+	}
+	var servers []*ServerCount
+	for _, sc := range counts {
+		servers = append(servers, sc)
+	}
+	sort.Sort(serversBySessions(servers))
+	if len(servers) > limit {
+		servers = servers[:limit]
+	}
+	return servers, nil
+}
+
+func (ms *MemoryStore) SessionLatencies(from, to time.Time) ([]*SessionLatency, error) {
+	window := TimeWindow{from, to}
+	var latencies []*SessionLatency
+	for _, s := range ms.sessions() {
+		if window.Contains(s.CreatedAt) && s.Activity != nil && s.Activity.RTT != nil && s.Activity.RTT.Samples > 0 {
+			latencies = append(latencies, &SessionLatency{s.Region, *s.Activity.RTT})
+		}
+	}
+	return latencies, nil
+}
+
+// serversBySessions sorts by sessions, most first, and then by server.
+type serversBySessions []*ServerCount
+
+func (s serversBySessions) Len() int      { return len(s) }
+func (s serversBySessions) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s serversBySessions) Less(i, j int) bool {
+	if s[i].Sessions != s[j].Sessions {
+		return s[i].Sessions > s[j].Sessions
+	}
+	return s[i].Server < s[j].Server
+}
+
+// clientsByCount sorts by count, most first, and then by value.
+type clientsByCount []*ClientCount
+
+func (s clientsByCount) Len() int      { return len(s) }
+func (s clientsByCount) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s clientsByCount) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	return s[i].Value < s[j].Value
+}
+
+// platformsByName sorts by system, release and machine.
+type platformsByName []*PlatformCount
+
+func (s platformsByName) Len() int      { return len(s) }
+func (s platformsByName) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s platformsByName) Less(i, j int) bool {
+	a, b := s[i], s[j]
+	if a.System != b.System {
+		return a.System < b.System
+	}
+	if a.Release != b.Release {
+		return a.Release < b.Release
+	}
+	return a.Machine < b.Machine
+}
+
+func (ms *MemoryStore) ApplyRetention(r Retention, now time.Time) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	n := 0
+	if r.SessionRequests > 0 {
+		cutoff := now.Add(-r.SessionRequests)
+		for id, s := range ms.Sessions {
+			if s.CreatedAt.Before(cutoff) && s.Request != nil {
+				c := *s
+				c.Request = nil
+				ms.Sessions[id] = &c
+				n++
+			}
+		}
+	}
+	if r.Events > 0 {
+		n += ms.eraseEvents(func(ev *Event) bool { return ev.CreatedAt.Before(now.Add(-r.Events)) }, nil)
+	}
+	if r.Crashes > 0 {
+		cutoff := now.Add(-r.Crashes)
+		for signature, g := range ms.Crashes {
+			if g.LastSeen.Before(cutoff) {
+				delete(ms.Crashes, signature)
+				n++
+			}
+		}
+		n += ms.removeCrashes(func(c *Crash) bool { return c.At.Before(cutoff) })
+	}
+	return n, nil
+}
+
+func (ms *MemoryStore) AggregateRollups(period string, from, to time.Time) ([]*Rollup, error) {
+	window := TimeWindow{from, to}
+	rollups := make(map[time.Time]*Rollup)
+	jids := make(map[time.Time]map[string]bool)
+	machines := make(map[time.Time]map[string]bool)
+	get := func(t time.Time) *Rollup {
+		start := periodStart(period, t)
+		r, ok := rollups[start]
+		if !ok {
+			r = NewRollup(period, start)
+			rollups[start] = r
+			jids[start] = make(map[string]bool)
+			machines[start] = make(map[string]bool)
+		}
+		return r
+	}
+	for _, s := range ms.sessions() {
+		if window.Contains(s.CreatedAt) {
+			r := get(s.CreatedAt)
+			r.SessionsStarted++
+			jids[r.Start][s.JID] = true
+			machines[r.Start][s.MachineId] = true
+			r.UniqueJIDs, r.UniqueMachines = len(jids[r.Start]), len(machines[r.Start])
+		}
+		if !s.ClosedAt.IsZero() && window.Contains(s.ClosedAt) {
+			r := get(s.ClosedAt)
+			r.SessionsClosed++
+			if s.ClosedReason == ClosedCrash {
+				r.SessionsCrashed++
+			}
+		}
+	}
+	return sortedRollups(rollups), nil
+}
+
+func (ms *MemoryStore) UpsertRollup(r *Rollup) error {
+	ms.Lock()
+	defer ms.Unlock()
+	c := *r
+	ms.RollupList[r.Id] = &c
+	return nil
+}
+
+func (ms *MemoryStore) Rollups(period string, from, to time.Time) ([]*Rollup, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	found := make(map[time.Time]*Rollup)
+	for _, r := range ms.RollupList {
+		if r.Period == period && (TimeWindow{from, to}).Contains(r.Start) {
+			c := *r
+			found[r.Start] = &c
+		}
+	}
+	return sortedRollups(found), nil
+}
+
+func (ms *MemoryStore) LatestRollup(period string) (*Rollup, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var latest *Rollup
+	for _, r := range ms.RollupList {
+		if r.Period == period && (latest == nil || r.Start.After(latest.Start)) {
+			latest = r
+		}
+	}
+	if latest == nil {
+		return nil, mgo.ErrNotFound
+	}
+	c := *latest
+	return &c, nil
+}
+
+func (ms *MemoryStore) InsertBlock(b *Block) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, mb := range ms.BlockList {
+		if mb.Id == b.Id {
+			return errDup
+		}
+	}
+	ms.BlockList = append(ms.BlockList, b)
+	return nil
+}
+
+func (ms *MemoryStore) RemoveBlock(id bson.ObjectId) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for i, b := range ms.BlockList {
+		if b.Id == id {
+			ms.BlockList = append(ms.BlockList[:i], ms.BlockList[i+1:]...)
+			return nil
+		}
+	}
+	return mgo.ErrNotFound
+}
+
+func (ms *MemoryStore) Blocks() ([]*Block, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	blocks := make([]*Block, len(ms.BlockList))
+	for i, b := range ms.BlockList {
+		c := *b
+		blocks[i] = &c
+	}
+	return blocks, nil
+}
+
+func (ms *MemoryStore) FindBlock(jid, machineId, xmppvoxVersion, remoteIP string) (*Block, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, b := range ms.BlockList {
+		if (b.Field == BlockJID && b.Value == jid) ||
+			(b.Field == BlockMachineId && b.Value == machineId) ||
+			(b.Field == BlockXMPPVOXVersion && b.Value == xmppvoxVersion) ||
+			(b.Field == BlockRemoteIP && b.Value == remoteIP) {
+			c := *b
+			return &c, nil
+		}
+	}
+	return nil, mgo.ErrNotFound
+}
+
+func (ms *MemoryStore) RaiseFlag(f *Flag) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, mf := range ms.FlagList {
+		if mf.Kind == f.Kind && mf.Field == f.Field && mf.Value == f.Value {
+			mf.Count, mf.Message, mf.SeenAt = f.Count, f.Message, f.SeenAt
+			return nil
+		}
+	}
+	c := *f
+	c.Status = FlagOpen
+	ms.FlagList = append(ms.FlagList, &c)
+	return nil
+}
+
+func (ms *MemoryStore) Flags(status string) ([]*Flag, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var flags []*Flag
+	for _, f := range ms.FlagList {
+		if status == "" || f.Status == status {
+			c := *f
+			flags = append(flags, &c)
+		}
+	}
+	sort.Sort(flagsBySeen(flags))
+	return flags, nil
+}
+
+// flagsBySeen sorts by the time flags were last seen, latest first.
+type flagsBySeen []*Flag
+
+func (s flagsBySeen) Len() int           { return len(s) }
+func (s flagsBySeen) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s flagsBySeen) Less(i, j int) bool { return s[i].SeenAt.After(s[j].SeenAt) }
+
+func (ms *MemoryStore) FindFlag(id bson.ObjectId) (*Flag, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, f := range ms.FlagList {
+		if f.Id == id {
+			c := *f
+			return &c, nil
+		}
+	}
+	return nil, mgo.ErrNotFound
+}
+
+func (ms *MemoryStore) ReviewFlag(id bson.ObjectId, status string, blockId bson.ObjectId) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, f := range ms.FlagList {
+		if f.Id == id && f.Status == FlagOpen {
+			f.Status, f.ReviewedAt = status, bson.Now()
+			if blockId != "" {
+				f.BlockId = blockId
+			}
+			return nil
+		}
+	}
+	return mgo.ErrNotFound
+}
+
+func (ms *MemoryStore) InsertAPIKey(k *APIKey) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, mk := range ms.APIKeyList {
+		if mk.Id == k.Id || mk.Key == k.Key {
+			return errDup
+		}
+	}
+	ms.APIKeyList = append(ms.APIKeyList, k)
+	return nil
+}
+
+func (ms *MemoryStore) RevokeAPIKey(id bson.ObjectId) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, k := range ms.APIKeyList {
+		if k.Id == id && k.RevokedAt.IsZero() {
+			k.RevokedAt = bson.Now()
+			return nil
+		}
+	}
+	return mgo.ErrNotFound
+}
+
+func (ms *MemoryStore) APIKeys() ([]*APIKey, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	keys := make([]*APIKey, len(ms.APIKeyList))
+	for i, k := range ms.APIKeyList {
+		c := *k
+		keys[i] = &c
+	}
+	return keys, nil
+}
+
+func (ms *MemoryStore) FindAPIKey(key string) (*APIKey, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, k := range ms.APIKeyList {
+		if k.Key == key {
+			c := *k
+			return &c, nil
+		}
+	}
+	return nil, mgo.ErrNotFound
+}
+
+func (ms *MemoryStore) InsertWebhook(h *Webhook) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, mh := range ms.WebhookList {
+		if mh.Id == h.Id {
+			return errDup
+		}
+	}
+	ms.WebhookList = append(ms.WebhookList, h)
+	return nil
+}
+
+func (ms *MemoryStore) RemoveWebhook(id bson.ObjectId) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for i, h := range ms.WebhookList {
+		if h.Id == id {
+			ms.WebhookList = append(ms.WebhookList[:i], ms.WebhookList[i+1:]...)
+			return nil
+		}
+	}
+	return mgo.ErrNotFound
+}
+
+func (ms *MemoryStore) Webhooks() ([]*Webhook, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	hooks := make([]*Webhook, len(ms.WebhookList))
+	for i, h := range ms.WebhookList {
+		c := *h
+		hooks[i] = &c
+	}
+	return hooks, nil
+}
+
+func (ms *MemoryStore) InsertRelease(x *Release) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, r := range ms.ReleaseList {
+		if r.Version == x.Version {
+			return errDup
+		}
+	}
+	ms.ReleaseList = append(ms.ReleaseList, x)
+	return nil
+}
+
+func (ms *MemoryStore) RemoveRelease(version string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for i, r := range ms.ReleaseList {
+		if r.Version == version {
+			ms.ReleaseList = append(ms.ReleaseList[:i], ms.ReleaseList[i+1:]...)
+			return nil
+		}
+	}
+	return mgo.ErrNotFound
+}
+
+func (ms *MemoryStore) Releases() ([]*Release, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	releases := make([]*Release, len(ms.ReleaseList))
+	for i, r := range ms.ReleaseList {
+		c := *r
+		releases[i] = &c
+	}
+	sort.Sort(releasesByPublication(releases))
+	return releases, nil
+}
+
+func (ms *MemoryStore) release(version string) *Release {
+	for _, r := range ms.ReleaseList {
+		if r.Version == version {
+			return r
+		}
+	}
+	return nil
+}
+
+func (ms *MemoryStore) FindRelease(version string) (*Release, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	r := ms.release(version)
+	if r == nil {
+		return nil, mgo.ErrNotFound
+	}
+	c := *r
+	c.Artifacts = append([]*ReleaseArtifact(nil), r.Artifacts...)
+	c.Downloads = make(map[string]int, len(r.Downloads))
+	for k, v := range r.Downloads {
+		c.Downloads[k] = v
+	}
+	return &c, nil
+}
+
+func (ms *MemoryStore) AddReleaseArtifact(version string, a *ReleaseArtifact) error {
+	ms.Lock()
+	defer ms.Unlock()
+	r := ms.release(version)
+	if r == nil {
+		return mgo.ErrNotFound
+	}
+	for _, x := range r.Artifacts {
+		if x.Platform == a.Platform {
+			return mgo.ErrNotFound
+		}
+	}
+	r.Artifacts = append(r.Artifacts, a)
+	return nil
+}
+
+func (ms *MemoryStore) CountDownload(version, platform string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	r := ms.release(version)
+	if r == nil {
+		return mgo.ErrNotFound
+	}
+	if r.Downloads == nil {
+		r.Downloads = make(map[string]int)
+	}
+	r.Downloads[platform]++
+	return nil
+}
+
+func (ms *MemoryStore) SetFeatureFlag(f *FeatureFlag) error {
+	ms.Lock()
+	defer ms.Unlock()
+	c := *f
+	ms.Features[f.Name] = &c
+	return nil
+}
+
+func (ms *MemoryStore) RemoveFeatureFlag(name string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	if _, ok := ms.Features[name]; !ok {
+		return mgo.ErrNotFound
+	}
+	delete(ms.Features, name)
+	return nil
+}
+
+func (ms *MemoryStore) FeatureFlags() ([]*FeatureFlag, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	flags := make([]*FeatureFlag, 0, len(ms.Features))
+	for _, f := range ms.Features {
+		c := *f
+		flags = append(flags, &c)
+	}
+	sort.Sort(featureFlagsByName(flags))
+	return flags, nil
+}
+
+func (ms *MemoryStore) PingInstallation(machineId, xmppvoxVersion string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	i, ok := ms.Installations[machineId]
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	i.LastSeen = bson.Now()
+	i.CurrentVersion = xmppvoxVersion
+	return nil
+}
+
+func (ms *MemoryStore) UninstallInstallation(machineId, reason string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	i, ok := ms.Installations[machineId]
+	if !ok {
+		return mgo.ErrNotFound
+	}
+	i.UninstalledAt = bson.Now()
+	i.UninstallReason = reason
+	return nil
+}
+
+func (ms *MemoryStore) FindInstallation(machineId string) (*Installation, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	i, ok := ms.Installations[machineId]
+	if !ok {
+		return nil, mgo.ErrNotFound
+	}
+	c := *i
+	return &c, nil
+}
+
+func (ms *MemoryStore) ReplaceStubInstallation(i *Installation) error {
+	ms.Lock()
+	defer ms.Unlock()
+	if old, ok := ms.Installations[i.MachineId]; !ok || !old.Stub {
+		return mgo.ErrNotFound
+	}
+	ms.Installations[i.MachineId] = i
+	return nil
+}
+
+func (ms *MemoryStore) OrphanSessions(from, to time.Time, limit int) ([]*OrphanCount, error) {
+	window := TimeWindow{from, to}
+	sessions := ms.sessions()
+	ms.Lock()
+	counts := make(map[string]*OrphanCount)
+	for _, s := range sessions {
+		if !window.Contains(s.CreatedAt) {
+			continue
+		}
+		if _, ok := ms.Installations[s.MachineId]; ok {
+			continue
+		}
+		oc, ok := counts[s.MachineId]
+		if !ok {
+			oc = &OrphanCount{MachineId: s.MachineId}
+			counts[s.MachineId] = oc
+		}
+		oc.Sessions++
+		if s.CreatedAt.After(oc.LastSessionAt) {
+			oc.LastSessionAt = s.CreatedAt
+		}
+	}
+	ms.Unlock()
+	var orphans []*OrphanCount
+	for _, oc := range counts {
+		orphans = append(orphans, oc)
+	}
+	sort.Sort(orphansBySessions(orphans))
+	if len(orphans) > limit {
+		orphans = orphans[:limit]
+	}
+	return orphans, nil
+}
+
+// orphansBySessions sorts by sessions, most first, and then by machine id.
+type orphansBySessions []*OrphanCount
+
+func (s orphansBySessions) Len() int      { return len(s) }
+func (s orphansBySessions) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s orphansBySessions) Less(i, j int) bool {
+	if s[i].Sessions != s[j].Sessions {
+		return s[i].Sessions > s[j].Sessions
+	}
+	return s[i].MachineId < s[j].MachineId
+}
+
+func (ms *MemoryStore) InsertClaim(c *Claim) error {
+	ms.Lock()
+	defer ms.Unlock()
+	if _, ok := ms.Claims[c.Code]; ok {
+		return errDup
+	}
+	ms.Claims[c.Code] = c
+	return nil
+}
+
+func (ms *MemoryStore) ClaimInstallation(code, machineId string, debugUntil time.Time) (*Claim, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	i, ok := ms.Installations[machineId]
+	if !ok {
+		return nil, mgo.ErrNotFound
+	}
+	now := bson.Now()
+	c, ok := ms.Claims[code]
+	if !ok || !c.ClaimedAt.IsZero() || !c.ExpiresAt.After(now) {
+		return nil, mgo.ErrNotFound
+	}
+	c.MachineId = machineId
+	c.ClaimedAt = now
+	i.Ticket = c.Ticket
+	i.DebugUntil = debugUntil
+	cc := *c
+	return &cc, nil
+}
+
+// CollectionStats measures documents as BSON. Without indexes, IndexBytes is 0.
+func (ms *MemoryStore) CollectionStats() ([]*CollectionStats, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	docs := map[string][]interface{}{}
+	for _, x := range ms.Installations {
+		docs["installations"] = append(docs["installations"], x)
+	}
+	for _, x := range ms.Sessions {
+		docs["sessions"] = append(docs["sessions"], x)
+	}
+	for _, x := range ms.Audit {
+		docs["audit"] = append(docs["audit"], x)
+	}
+	for _, x := range ms.JobRunLog {
+		docs["job_runs"] = append(docs["job_runs"], x)
+	}
+	for _, x := range ms.Crashes {
+		docs["crashes"] = append(docs["crashes"], x)
+	}
+	for _, x := range ms.Events {
+		docs["events"] = append(docs["events"], x)
+	}
+	for _, x := range ms.FeedbackList {
+		docs["feedback"] = append(docs["feedback"], x)
+	}
+	for _, x := range ms.BlockList {
+		docs["blocks"] = append(docs["blocks"], x)
+	}
+	for _, x := range ms.APIKeyList {
+		docs["api_keys"] = append(docs["api_keys"], x)
+	}
+	for _, x := range ms.WebhookList {
+		docs["webhooks"] = append(docs["webhooks"], x)
+	}
+	for _, x := range ms.Features {
+		docs["feature_flags"] = append(docs["feature_flags"], x)
+	}
+	for _, x := range ms.ReleaseList {
+		docs["releases"] = append(docs["releases"], x)
+	}
+	for _, x := range ms.Claims {
+		docs["claims"] = append(docs["claims"], x)
+	}
+	for _, x := range ms.Snapshots {
+		docs["storage_snapshots"] = append(docs["storage_snapshots"], x)
+	}
+	var stats []*CollectionStats
+	for name, list := range docs {
+		cs := &CollectionStats{Name: name, Count: int64(len(list))}
+		for _, x := range list {
+			cs.DataBytes += bsonSize(x)
+		}
+		cs.AvgDocBytes = cs.DataBytes / cs.Count
+		stats = append(stats, cs)
+	}
+	sort.Sort(collectionStatsByName(stats))
+	return stats, nil
+}
+
+// releasesByPublication sorts releases latest published first.
+type releasesByPublication []*Release
+
+func (s releasesByPublication) Len() int           { return len(s) }
+func (s releasesByPublication) Less(i, j int) bool { return s[i].PublishedAt.After(s[j].PublishedAt) }
+func (s releasesByPublication) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type featureFlagsByName []*FeatureFlag
+
+func (s featureFlagsByName) Len() int           { return len(s) }
+func (s featureFlagsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s featureFlagsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type collectionStatsByName []*CollectionStats
+
+func (s collectionStatsByName) Len() int           { return len(s) }
+func (s collectionStatsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s collectionStatsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (ms *MemoryStore) InsertStorageSnapshot(x *StorageSnapshot) error {
+	ms.Lock()
+	defer ms.Unlock()
+	ms.Snapshots = append(ms.Snapshots, x)
+	return nil
+}
+
+func (ms *MemoryStore) StorageSnapshots(since time.Time) ([]*StorageSnapshot, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var snapshots []*StorageSnapshot
+	for _, x := range ms.Snapshots {
+		if !x.At.Before(since) {
+			c := *x
+			snapshots = append(snapshots, &c)
+		}
+	}
+	return snapshots, nil
+}
+
+// MissingIndexes returns nothing, a MemoryStore needs no indexes.
+func (ms *MemoryStore) MissingIndexes() ([]string, error) { return nil, nil }
+
+func (ms *MemoryStore) EnsureIndexes() error { return nil }
+
+func (ms *MemoryStore) BackfillClosedReason(reason string, dryRun bool) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	n := 0
+	for _, s := range ms.Sessions {
+		if s.ClosedAt.IsZero() || s.ClosedReason != "" {
+			continue
+		}
+		if !dryRun {
+			s.ClosedReason = reason
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (ms *MemoryStore) BackfillFingerprints(dryRun bool) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	n := 0
+	for _, i := range ms.Installations {
+		f := fingerprint(i.MachineInfo)
+		if i.Fingerprint != "" || f == "" {
+			continue
+		}
+		if !dryRun {
+			i.Fingerprint = f
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (ms *MemoryStore) NormalizeSessionJIDs(dryRun bool) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	n := 0
+	for _, s := range ms.Sessions {
+		j, err := parseJID(s.JID)
+		if err != nil || (j.Bare() == s.JID && j.Resource == "") {
+			continue
+		}
+		if !dryRun {
+			s.JID = j.Bare()
+			if j.Resource != "" {
+				s.Resource = j.Resource
+			}
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (ms *MemoryStore) Migrations() ([]*MigrationRun, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	var runs []*MigrationRun
+	for _, x := range ms.MigrationLog {
+		c := *x
+		runs = append(runs, &c)
+	}
+	return runs, nil
+}
+
+func (ms *MemoryStore) InsertMigration(x *MigrationRun) error {
+	ms.Lock()
+	defer ms.Unlock()
+	for _, r := range ms.MigrationLog {
+		if r.Version == x.Version {
+			return errDup
+		}
+	}
+	ms.MigrationLog = append(ms.MigrationLog, x)
+	sort.Sort(migrationsByVersion(ms.MigrationLog))
+	return nil
+}
+
+type migrationsByVersion []*MigrationRun
+
+func (s migrationsByVersion) Len() int           { return len(s) }
+func (s migrationsByVersion) Less(i, j int) bool { return s[i].Version < s[j].Version }
+func (s migrationsByVersion) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type sessionsById []*Session
+
+func (s sessionsById) Len() int           { return len(s) }
+func (s sessionsById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s sessionsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (ms *MemoryStore) LegacySessions(after SessionId, limit int) ([]*Session, error) {
+	sessions := ms.sessions()
+	sort.Sort(sessionsById(sessions))
+	var legacy []*Session
+	for _, s := range sessions {
+		if len(legacy) == limit {
+			break
+		}
+		if s.Id <= after || s.ClosedAt.IsZero() {
+			continue
+		}
+		if s.LastPing.IsZero() || s.ClosedReason == "" || s.Duration == nil {
+			legacy = append(legacy, s)
+		}
+	}
+	return legacy, nil
+}
+
+func (ms *MemoryStore) BackfillSession(s *Session) error {
+	ms.Lock()
+	defer ms.Unlock()
+	x, ok := ms.Sessions[s.Id]
+	if !ok || !x.ClosedAt.Equal(s.ClosedAt) {
+		return nil
+	}
+	x.LastPing, x.ClosedReason, x.Duration = s.LastPing, s.ClosedReason, s.Duration
+	return nil
+}
+
+func (ms *MemoryStore) Checkpoint(name string) (*Checkpoint, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	cp, ok := ms.Checkpoints[name]
+	if !ok {
+		return nil, mgo.ErrNotFound
+	}
+	c := *cp
+	return &c, nil
+}
+
+func (ms *MemoryStore) SaveCheckpoint(cp *Checkpoint) error {
+	ms.Lock()
+	defer ms.Unlock()
+	c := *cp
+	ms.Checkpoints[cp.Name] = &c
+	return nil
+}
+
+func (ms *MemoryStore) AcquireLease(name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	if l, ok := ms.Leases[name]; ok && l.Holder != holder && l.ExpiresAt.After(now) {
+		return false, nil
+	}
+	ms.Leases[name] = &memoryLease{holder, now.Add(ttl)}
+	return true, nil
+}
+
+// CountRate never forgets counters, a MemoryStore does not live long.
+func (ms *MemoryStore) CountRate(key string, start, end time.Time) (int, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	k := fmt.Sprintf("%s@%d", key, start.UnixNano())
+	ms.RateCounters[k]++
+	return ms.RateCounters[k], nil
+}
@@ -0,0 +1,97 @@
+package tracker
+
+import (
+	"fmt"
+	"labix.org/v2/mgo"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// defaultMetadataKeys are the keys of the metadata of sessions, unless
+// sessions.metadata_keys lists others.
+var defaultMetadataKeys = []string{
+	"dosvox_program", // the DOSVOX program in use, like "cartavox"
+	"screen_reader",  // like "NVDA 2014.1"
+	"tts_voice",      // the speech synthesizer voice, like "Raquel"
+}
+
+// metadataKey matches the keys sessions.metadata_keys can list, which are
+// stored as they are in the metadata Mongo documents.
+var metadataKey = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// metadataKeys returns the keys of the metadata of sessions allowed by conf.
+func metadataKeys(conf *Config) []string {
+	if conf != nil && conf.Sessions != nil && len(conf.Sessions.MetadataKeys) > 0 {
+		return conf.Sessions.MetadataKeys
+	}
+	return defaultMetadataKeys
+}
+
+// parseMetadata decodes the metadata param of /session/update, a JSON
+// mapping of the keys of metadataKeys to strings, reporting every problem
+// found.
+func parseMetadata(raw string, conf *Config) (map[string]string, APIErrors) {
+	metadata, errs := parseInfo("metadata", raw, maxInfoKeys(conf))
+	if errs != nil {
+		return nil, errs
+	}
+	if len(metadata) == 0 {
+		return nil, APIErrors{{"invalid_value", "metadata", "", "Invalid metadata, expected an object with at least one key"}}
+	}
+	allowed := metadataKeys(conf)
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !contains(allowed, key) {
+			errs = append(errs, &APIError{"unknown_key", "metadata", key,
+				fmt.Sprintf("Unknown key %q of metadata, expected one of %s", key, strings.Join(allowed, ", "))})
+		}
+	}
+	return metadata, errs
+}
+
+// UpdateSessionHandler sets the metadata of an open session, what the client
+// tells of its setup, like the screen reader in use, so that usage can be
+// told apart by it. Keys with an empty value are removed, the others kept.
+// Like a ping, it keeps the session from expiring. It replies with the
+// session id.
+func UpdateSessionHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	sessionIdHex := r.PostFormValue("session_id")
+	errs := checkParams(r, []string{"session_id", "machine_id", "metadata"}, "signature", "timestamp")
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if sessionIdHex != "" && !validSessionId(c.Config, sessionIdHex) {
+		errs = append(errs, invalidSessionId("session_id", sessionIdHex))
+	}
+	var metadata map[string]string
+	if raw := r.PostFormValue("metadata"); raw != "" {
+		var e APIErrors
+		metadata, e = parseMetadata(raw, c.Config)
+		errs = append(errs, e...)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	sessionId := SessionId(sessionIdHex)
+	if !checkSessionSignature(w, r, c, "/session/update", sessionId) {
+		return
+	}
+	switch err := c.Store.UpdateSessionMetadata(&Session{Id: sessionId, MachineId: machineId, Metadata: metadata}); err {
+	case nil:
+		fmt.Fprintln(w, sessionIdHex)
+	case mgo.ErrNotFound:
+		writeError(w, r, sessionNotFound(sessionIdHex), http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to update session %s", sessionIdHex)),
+			http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
@@ -0,0 +1,251 @@
+package tracker
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// metricsWindow is how far back Metrics remembers.
+const metricsWindow = 60
+
+// Metrics accumulates request and storage measurements in one-second buckets
+// over the last minute. It is safe for concurrent use.
+type Metrics struct {
+	sync.Mutex
+	buckets [metricsWindow]metricsBucket
+	now     func() time.Time
+}
+
+type metricsBucket struct {
+	Second       int64
+	Requests     int64
+	Shed         int64
+	StorageCalls int64
+	StorageTime  time.Duration
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{now: time.Now}
+}
+
+// metrics measures the requests served by this process.
+var metrics = NewMetrics()
+
+// update calls fn with the bucket of the current second.
+func (m *Metrics) update(fn func(*metricsBucket)) {
+	sec := m.now().Unix()
+	m.Lock()
+	defer m.Unlock()
+	b := &m.buckets[sec%metricsWindow]
+	if b.Second != sec {
+		*b = metricsBucket{Second: sec}
+	}
+	fn(b)
+}
+
+// ObserveRequest records a served request and its status code.
+// Requests refused with 503 Service Unavailable count as shed.
+func (m *Metrics) ObserveRequest(status int) {
+	m.update(func(b *metricsBucket) {
+		b.Requests++
+		if status == http.StatusServiceUnavailable {
+			b.Shed++
+		}
+	})
+}
+
+// ObserveStorage records the duration of a storage call.
+func (m *Metrics) ObserveStorage(d time.Duration) {
+	m.update(func(b *metricsBucket) {
+		b.StorageCalls++
+		b.StorageTime += d
+	})
+}
+
+// MetricsSummary sums up the measurements of the last minute.
+type MetricsSummary struct {
+	RequestRate    float64       // requests per second
+	ShedRate       float64       // fraction of requests shed
+	StorageLatency time.Duration // mean duration of a storage call
+}
+
+func (m *Metrics) Summary() MetricsSummary {
+	now := m.now().Unix()
+	var total metricsBucket
+	m.Lock()
+	for _, b := range m.buckets {
+		if now-b.Second < metricsWindow {
+			total.Requests += b.Requests
+			total.Shed += b.Shed
+			total.StorageCalls += b.StorageCalls
+			total.StorageTime += b.StorageTime
+		}
+	}
+	m.Unlock()
+	var s MetricsSummary
+	s.RequestRate = float64(total.Requests) / metricsWindow
+	if total.Requests > 0 {
+		s.ShedRate = float64(total.Shed) / float64(total.Requests)
+	}
+	if total.StorageCalls > 0 {
+		s.StorageLatency = total.StorageTime / time.Duration(total.StorageCalls)
+	}
+	return s
+}
+
+// statusRecorder is a http.ResponseWriter that remembers the status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.Status == 0 {
+		r.Status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.Status == 0 {
+		r.Status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// MetricsHandler wraps h, recording every request in m and in runtimeStats.
+func MetricsHandler(h http.Handler, m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.Status == 0 {
+			rec.Status = http.StatusOK
+		}
+		m.ObserveRequest(rec.Status)
+		runtimeStats.ObserveRequest(rec.Status)
+	})
+}
+
+// meteredStore is a Storage that records in a Metrics the duration of the
+// calls made by the client API, which are the bulk of the storage load,
+// and their duration and outcome per method in runtimeStats. Calls slower
+// than slow, if set, are logged to log, to tell storage latency apart from
+// application bugs.
+type meteredStore struct {
+	Storage
+	m    *Metrics
+	slow time.Duration
+	log  *Logger
+}
+
+// newMeteredStore meters store with m, logging the calls slower than the
+// log.slow_storage of conf.
+func newMeteredStore(store Storage, m *Metrics, conf *Config, log *Logger) *meteredStore {
+	s := &meteredStore{Storage: store, m: m, log: log}
+	if conf != nil && conf.Log != nil {
+		s.slow = conf.Log.SlowStorage.Duration
+	}
+	return s
+}
+
+// observe is deferred with the name of a call, its start time and a
+// pointer to its error.
+func (s *meteredStore) observe(op string, start time.Time, err *error) {
+	d := time.Since(start)
+	s.m.ObserveStorage(d)
+	runtimeStats.ObserveStorageCall(op, d, *err)
+	if s.slow > 0 && d >= s.slow {
+		if *err != nil {
+			s.log.Warnf("[storage] slow %s took %s and failed: %v", op, d, *err)
+		} else {
+			s.log.Warnf("[storage] slow %s took %s", op, d)
+		}
+	}
+}
+
+func (s *meteredStore) InsertInstallation(i *Installation) (err error) {
+	defer s.observe("InsertInstallation", time.Now(), &err)
+	return s.Storage.InsertInstallation(i)
+}
+
+func (s *meteredStore) InsertSession(x *Session) (err error) {
+	defer s.observe("InsertSession", time.Now(), &err)
+	return s.Storage.InsertSession(x)
+}
+
+func (s *meteredStore) CloseSession(x *Session) (err error) {
+	defer s.observe("CloseSession", time.Now(), &err)
+	return s.Storage.CloseSession(x)
+}
+
+func (s *meteredStore) PingSession(x *Session) (err error) {
+	defer s.observe("PingSession", time.Now(), &err)
+	return s.Storage.PingSession(x)
+}
+
+func (s *meteredStore) TransferSession(x *Session, to string) (err error) {
+	defer s.observe("TransferSession", time.Now(), &err)
+	return s.Storage.TransferSession(x, to)
+}
+
+func (s *meteredStore) FindBlock(jid, machineId, xmppvoxVersion, remoteIP string) (b *Block, err error) {
+	defer s.observe("FindBlock", time.Now(), &err)
+	return s.Storage.FindBlock(jid, machineId, xmppvoxVersion, remoteIP)
+}
+
+func (s *meteredStore) FindSession(id SessionId) (x *Session, err error) {
+	defer s.observe("FindSession", time.Now(), &err)
+	return s.Storage.FindSession(id)
+}
+
+func (s *meteredStore) FindAPIKey(key string) (k *APIKey, err error) {
+	defer s.observe("FindAPIKey", time.Now(), &err)
+	return s.Storage.FindAPIKey(key)
+}
+
+func (s *meteredStore) Releases() (releases []*Release, err error) {
+	defer s.observe("Releases", time.Now(), &err)
+	return s.Storage.Releases()
+}
+
+func (s *meteredStore) FindRelease(version string) (x *Release, err error) {
+	defer s.observe("FindRelease", time.Now(), &err)
+	return s.Storage.FindRelease(version)
+}
+
+func (s *meteredStore) CountDownload(version, platform string) (err error) {
+	defer s.observe("CountDownload", time.Now(), &err)
+	return s.Storage.CountDownload(version, platform)
+}
+
+func (s *meteredStore) FeatureFlags() (flags []*FeatureFlag, err error) {
+	defer s.observe("FeatureFlags", time.Now(), &err)
+	return s.Storage.FeatureFlags()
+}
+
+func (s *meteredStore) PingInstallation(machineId, xmppvoxVersion string) (err error) {
+	defer s.observe("PingInstallation", time.Now(), &err)
+	return s.Storage.PingInstallation(machineId, xmppvoxVersion)
+}
+
+func (s *meteredStore) UninstallInstallation(machineId, reason string) (err error) {
+	defer s.observe("UninstallInstallation", time.Now(), &err)
+	return s.Storage.UninstallInstallation(machineId, reason)
+}
+
+func (s *meteredStore) FindInstallation(machineId string) (i *Installation, err error) {
+	defer s.observe("FindInstallation", time.Now(), &err)
+	return s.Storage.FindInstallation(machineId)
+}
+
+func (s *meteredStore) ClaimInstallation(code, machineId string, debugUntil time.Time) (c *Claim, err error) {
+	defer s.observe("ClaimInstallation", time.Now(), &err)
+	return s.Storage.ClaimInstallation(code, machineId, debugUntil)
+}
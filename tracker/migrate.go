@@ -0,0 +1,188 @@
+package tracker
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A Migration brings the documents or indexes of the storage up to date
+// with a new version of the tracker. Migrations are applied once, in
+// order of Version, and recorded in the storage, so a version must never
+// be reused nor a migration changed once released.
+type Migration struct {
+	Version int
+	Name    string
+	// Pending counts the documents or indexes Apply would change, for dry runs.
+	Pending func(Storage) (int, error)
+	Apply   func(Storage) (int, error)
+}
+
+// migrations lists every migration, by version.
+var migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "indexes",
+		Pending: func(store Storage) (int, error) {
+			missing, err := store.MissingIndexes()
+			return len(missing), err
+		},
+		Apply: func(store Storage) (int, error) {
+			missing, err := store.MissingIndexes()
+			if err != nil {
+				return 0, err
+			}
+			return len(missing), store.EnsureIndexes()
+		},
+	},
+	{
+		// Sessions closed before reasons were recorded were all closed by
+		// their client, the reaper came later.
+		Version: 2,
+		Name:    "closed_reason",
+		Pending: func(store Storage) (int, error) {
+			return store.BackfillClosedReason(ClosedByClient, true)
+		},
+		Apply: func(store Storage) (int, error) {
+			return store.BackfillClosedReason(ClosedByClient, false)
+		},
+	},
+	{
+		// Sessions started before jids were parsed may have a resource or
+		// an uppercase domain, which split the sessions of a user.
+		Version: 3,
+		Name:    "jid_resource",
+		Pending: func(store Storage) (int, error) {
+			return store.NormalizeSessionJIDs(true)
+		},
+		Apply: func(store Storage) (int, error) {
+			return store.NormalizeSessionJIDs(false)
+		},
+	},
+	{
+		Version: 4,
+		Name:    "fingerprint",
+		Pending: func(store Storage) (int, error) {
+			return store.BackfillFingerprints(true)
+		},
+		Apply: func(store Storage) (int, error) {
+			return store.BackfillFingerprints(false)
+		},
+	},
+}
+
+// pendingMigrations returns the migrations not applied to store yet, by version.
+func pendingMigrations(store Storage) ([]*Migration, error) {
+	runs, err := store.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int]bool)
+	for _, r := range runs {
+		applied[r.Version] = true
+	}
+	var pending []*Migration
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// migrate applies the pending migrations to store, or with dryRun only
+// tells what they would change, writing a line per migration to w. It
+// stops at the first migration that fails, which is applied again next time.
+func migrate(store Storage, dryRun bool, w io.Writer) error {
+	pending, err := pendingMigrations(store)
+	if err != nil {
+		return err
+	}
+	if pending == nil {
+		fmt.Fprintln(w, "no pending migrations")
+		return nil
+	}
+	for _, m := range pending {
+		if dryRun {
+			n, err := m.Pending(store)
+			if err != nil {
+				return fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+			}
+			fmt.Fprintf(w, "%d %s: would change %d\n", m.Version, m.Name, n)
+			continue
+		}
+		start := time.Now()
+		n, err := m.Apply(store)
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %v", m.Version, m.Name, err)
+		}
+		if err := store.InsertMigration(&MigrationRun{m.Version, m.Name, time.Now(), n}); err != nil {
+			return fmt.Errorf("migration %d %s: applied but not recorded: %v", m.Version, m.Name, err)
+		}
+		fmt.Fprintf(w, "%d %s: changed %d in %s\n", m.Version, m.Name, n, time.Since(start))
+	}
+	return nil
+}
+
+// migrationStatus writes to w whether each migration was applied to store.
+func migrationStatus(store Storage, w io.Writer) error {
+	runs, err := store.Migrations()
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]*MigrationRun)
+	for _, r := range runs {
+		applied[r.Version] = r
+	}
+	for _, m := range migrations {
+		if r, ok := applied[m.Version]; ok {
+			fmt.Fprintf(w, "%d %s: applied at %s, changed %d\n",
+				m.Version, m.Name, r.AppliedAt.UTC().Format(time.RFC3339), r.Changed)
+			continue
+		}
+		fmt.Fprintf(w, "%d %s: pending\n", m.Version, m.Name)
+	}
+	return nil
+}
+
+// runMigrate runs "elephant-tracker migrate" with args against the storage
+// of the configuration of src, and returns the exit status: 0 on success,
+// 1 when the storage cannot be used or a migration fails and 2 on usage
+// errors.
+func runMigrate(args []string, src *configSource, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dryRun := fs.Bool("dry-run", false, "tell what the pending migrations would change, without applying them")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elephant-tracker migrate [-dry-run] [status]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	status := false
+	switch {
+	case fs.NArg() == 1 && fs.Arg(0) == "status":
+		status = true
+	case fs.NArg() > 0:
+		fs.Usage()
+		return 2
+	}
+	store, closeStore, err := offlineStore(src)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer closeStore()
+	if status {
+		err = migrationStatus(store, stdout)
+	} else {
+		err = migrate(store, *dryRun, stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
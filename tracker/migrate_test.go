@@ -0,0 +1,119 @@
+package tracker
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+type migrateTest struct {
+	store *MemoryStore
+}
+
+func newMigrateTest(t *testing.T) *migrateTest {
+	s := &migrateTest{}
+	s.store = NewMemoryStore()
+	return s
+}
+
+func (s *migrateTest) session(closedAt time.Time, reason string) *Session {
+	x := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	x.ClosedAt, x.ClosedReason = closedAt, reason
+	s.store.Sessions[x.Id] = x
+	return x
+}
+
+func TestMigrate(t *testing.T) {
+	s := newMigrateTest(t)
+	legacy := s.session(time.Now().Add(-time.Hour), "")
+	expired := s.session(time.Now(), ClosedExpired)
+	open := s.session(time.Time{}, "")
+	open.JID = "testuser@Server.org/XMPPVOX"
+	legacyInstall := &Installation{MachineId: "00:26:cc:18:be:14",
+		MachineInfo: map[string]string{"node": "desktop", "processor": "x86", "system": "Windows"}}
+	s.store.Installations[legacyInstall.MachineId] = legacyInstall
+	if err, pattern := checkMigrations(s.store), "pending 1 indexes, 2 closed_reason, 3 jid_resource, 4 fingerprint; run elephant-tracker migrate"; err == nil || !fullMatch(pattern, err.Error()) {
+		t.Errorf("checkMigrations(s.store) = %v, want an error matching %q", err, pattern)
+	}
+
+	var out bytes.Buffer
+	if err := migrate(s.store, true, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "1 indexes: would change 0\n2 closed_reason: would change 1\n3 jid_resource: would change 1\n4 fingerprint: would change 1\n"; got != want {
+		t.Errorf("out.String() = %v, want %v", got, want)
+	}
+	if got, want := legacy.ClosedReason, ""; got != want {
+		t.Errorf("legacy.ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := len(s.store.MigrationLog), 0; got != want {
+		t.Errorf("len(s.store.MigrationLog) = %d, want %d", got, want)
+	}
+
+	out.Reset()
+	if err := migrate(s.store, false, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, pattern := out.String(), "1 indexes: changed 0 in .*\n2 closed_reason: changed 1 in .*\n3 jid_resource: changed 1 in .*\n4 fingerprint: changed 1 in .*\n"; !fullMatch(pattern, got) {
+		t.Errorf("out.String() = %q, want a match of %q", got, pattern)
+	}
+	if got, want := legacy.ClosedReason, ClosedByClient; got != want {
+		t.Errorf("legacy.ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := expired.ClosedReason, ClosedExpired; got != want {
+		t.Errorf("expired.ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := open.ClosedReason, ""; got != want {
+		t.Errorf("open.ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := open.JID, "testuser@server.org"; got != want {
+		t.Errorf("open.JID = %v, want %v", got, want)
+	}
+	if got, want := open.Resource, "XMPPVOX"; got != want {
+		t.Errorf("open.Resource = %v, want %v", got, want)
+	}
+	if got, want := legacyInstall.Fingerprint, fingerprint(legacyInstall.MachineInfo); got != want {
+		t.Errorf("legacyInstall.Fingerprint = %v, want %v", got, want)
+	}
+	if err := checkMigrations(s.store); err != nil {
+		t.Error(err)
+	}
+
+	out.Reset()
+	if err := migrate(s.store, false, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "no pending migrations\n"; got != want {
+		t.Errorf("out.String() = %v, want %v", got, want)
+	}
+}
+
+func TestMigrateStatus(t *testing.T) {
+	s := newMigrateTest(t)
+	at := time.Date(2014, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := s.store.InsertMigration(&MigrationRun{1, "indexes", at, 16}); err != nil {
+		t.Fatal(err)
+	}
+	if s.store.InsertMigration(&MigrationRun{1, "indexes", at, 0}) == nil {
+		t.Error("got nil")
+	}
+	var out bytes.Buffer
+	if err := migrationStatus(s.store, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "1 indexes: applied at 2014-03-01T12:00:00Z, changed 16\n2 closed_reason: pending\n3 jid_resource: pending\n4 fingerprint: pending\n"; got != want {
+		t.Errorf("out.String() = %v, want %v", got, want)
+	}
+}
+
+func TestMigrateUsage(t *testing.T) {
+	newMigrateTest(t)
+	var out, errOut bytes.Buffer
+	if got, want := runMigrate([]string{"up"}, defaultConfigSource(), &out, &errOut), 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := strings.HasPrefix(errOut.String(), "usage: elephant-tracker migrate [-dry-run] [status]\n"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
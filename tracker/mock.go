@@ -0,0 +1,218 @@
+package tracker
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockRule scripts the response to requests matching Method, Path and Params.
+// A zero Status lets the request through to the real handler after Delay,
+// which is useful to inject latency only.
+type MockRule struct {
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Params map[string]string `json:"params"`
+	Status int               `json:"status"`
+	Body   string            `json:"body"`
+	Delay  Duration          `json:"delay"`
+	// Times limits how many requests the rule applies to; 0 means forever.
+	Times int `json:"times"`
+}
+
+func (m *MockRule) matches(r *http.Request) bool {
+	if m.Method != "" && !strings.EqualFold(m.Method, r.Method) {
+		return false
+	}
+	if m.Path != "" && m.Path != r.URL.Path {
+		return false
+	}
+	for key, value := range m.Params {
+		if r.FormValue(key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// MockScript is an ordered list of rules; the first matching rule wins.
+type MockScript struct {
+	sync.Mutex
+	Rules []*MockRule `json:"rules"`
+}
+
+func MockScriptOpen(path string) (*MockScript, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	script := &MockScript{}
+	if err := json.NewDecoder(f).Decode(script); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+// next returns the first rule matching r, consuming one of its uses.
+func (s *MockScript) next(r *http.Request) *MockRule {
+	s.Lock()
+	defer s.Unlock()
+	for i, rule := range s.Rules {
+		if !rule.matches(r) {
+			continue
+		}
+		if rule.Times > 0 {
+			rule.Times--
+			if rule.Times == 0 {
+				s.Rules = append(s.Rules[:i:i], s.Rules[i+1:]...)
+			}
+		}
+		return rule
+	}
+	return nil
+}
+
+// MockHandler wraps h, applying the rules of script to every request.
+// The rules can be inspected and replaced at runtime with GET, PUT and DELETE
+// on /mock/rules, so that each test of a client can script its own scenario.
+func MockHandler(h http.Handler, script *MockScript) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mock/rules", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			var rules struct {
+				Rules []*MockRule `json:"rules"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid rules: %v", err), http.StatusBadRequest)
+				return
+			}
+			script.Lock()
+			script.Rules = rules.Rules
+			script.Unlock()
+		case "DELETE":
+			script.Lock()
+			script.Rules = nil
+			script.Unlock()
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		script.Lock()
+		defer script.Unlock()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(script)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		rule := script.next(r)
+		if rule == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		time.Sleep(rule.Delay.Duration)
+		if rule.Status == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		logger.Infof("[mock] %s %s: scripted %d", r.Method, r.URL.Path, rule.Status)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(rule.Status)
+		if rule.Body != "" {
+			fmt.Fprintln(w, rule.Body)
+		}
+	})
+	return mux
+}
+
+// newMockServer returns a Server of config over a MemoryStore, without
+// MongoDB or Redis. config needs no http section, the mock is served at an
+// address of its own.
+func newMockServer(config *Config) *Server {
+	if config.Http == nil {
+		config.Http = &HttpConfig{}
+	}
+	store := NewMemoryStore()
+	server := NewServer(config, nil)
+	server.OpenStore = func() (Storage, func()) {
+		return store, func() {}
+	}
+	server.cache = nil
+	return server
+}
+
+// serveMock serves at addr the API of server, a mock Server, see
+// newMockServer, with every middleware of Server.Handler behind the rules
+// of the script at scriptPath, if any.
+func serveMock(server *Server, addr, scriptPath string) error {
+	script := &MockScript{}
+	if scriptPath != "" {
+		var err error
+		script, err = MockScriptOpen(scriptPath)
+		if err != nil {
+			return err
+		}
+	}
+	server.scheduler.Start()
+	server.Log.Infof("[mock] serving at %s", addr)
+	return http.ListenAndServe(addr, MockHandler(server.Handler(), script))
+}
+
+// MockMain runs the elephant-mock command with the arguments of the
+// process, serving as "elephant-tracker --mock" does.
+func MockMain() {
+	os.Exit(runMock(os.Args[1:], os.Stderr))
+}
+
+// runMock runs "elephant-mock" with args and returns the exit status: 1
+// when the mock cannot be served and 2 on usage errors. Without -config, it
+// serves with the defaults of every setting.
+func runMock(args []string, stderr io.Writer) int {
+	fs := flag.NewFlagSet("elephant-mock", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	addr := fs.String("addr", "localhost:8080", "address to serve at")
+	scriptPath := fs.String("script", "", "path to a JSON file with scripted responses")
+	configPath := fs.String("config", "", "path to a configuration file in JSON format, optional")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: elephant-mock [-addr addr] [-script script.json] [-config config.json]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	config := &Config{}
+	if *configPath != "" {
+		var err error
+		config, err = ConfigOpen(*configPath)
+		if err == nil {
+			err = config.prepare()
+		}
+		if err == nil {
+			err = config.validate(false)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, "[config]", err)
+			return 1
+		}
+	}
+	if err := configureLog(config.Log); err != nil {
+		fmt.Fprintln(stderr, "[log]", err)
+		return 1
+	}
+	if err := serveMock(newMockServer(config), *addr, *scriptPath); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package tracker
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type mockTest struct {
+	Handler http.Handler
+	Script  *MockScript
+}
+
+func newMockTest(t *testing.T) *mockTest {
+	s := &mockTest{}
+	store := NewMemoryStore()
+	openStore = func() (Storage, func()) {
+		return store, func() {}
+	}
+	s.Script = &MockScript{}
+	s.Handler = MockHandler(APIHandler(), s.Script)
+	return s
+}
+
+func (s *mockTest) newSession() *httptest.ResponseRecorder {
+	form := url.Values{"jid": {"testuser@server.org"}, "machine_id": {"00:26:cc:18:be:14"}, "xmppvox_version": {"1.0"}}
+	req, _ := http.NewRequest("POST", "/1/session/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestScriptedResponse(t *testing.T) {
+	s := newMockTest(t)
+	s.Script.Rules = []*MockRule{{Path: "/1/session/new", Status: http.StatusForbidden, Body: "Denied", Times: 1}}
+	w := s.newSession()
+	if got, want := w.Code, http.StatusForbidden; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Denied\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	// The rule is used up, the real handler answers now.
+	w = s.newSession()
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := len(s.Script.Rules), 0; got != want {
+		t.Errorf("len(s.Script.Rules) = %d, want %d", got, want)
+	}
+}
+
+func TestReplaceRules(t *testing.T) {
+	s := newMockTest(t)
+	req, _ := http.NewRequest("PUT", "/mock/rules", strings.NewReader(`{"rules": [{"params": {"jid": "testuser@server.org"}, "status": 500}]}`))
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, req)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := s.newSession().Code, http.StatusInternalServerError; got != want {
+		t.Errorf("s.newSession().Code = %v, want %v", got, want)
+	}
+	req, _ = http.NewRequest("DELETE", "/mock/rules", nil)
+	s.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got, want := s.newSession().Code, http.StatusOK; got != want {
+		t.Errorf("s.newSession().Code = %v, want %v", got, want)
+	}
+}
+
+func TestMockServerWithoutConfig(t *testing.T) {
+	server := newMockServer(&Config{})
+	h := MockHandler(server.Handler(), &MockScript{})
+	form := url.Values{"jid": {"testuser@server.org"}, "machine_id": {"00:26:cc:18:be:14"}, "xmppvox_version": {"1.0"}}
+	req, _ := http.NewRequest("POST", "/1/session/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Server"), "elephant-tracker/"+Version; got != want {
+		t.Errorf("w.Header().Get(\"Server\") = %v, want %v", got, want)
+	}
+}
+
+func TestRunMockUsage(t *testing.T) {
+	var errOut bytes.Buffer
+	if got, want := runMock([]string{"extra"}, &errOut), 2; got != want {
+		t.Errorf("runMock([extra]) = %v, want %v", got, want)
+	}
+	if got, want := strings.HasPrefix(errOut.String(), "usage: elephant-mock "), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	errOut.Reset()
+	if got, want := runMock([]string{"-config", "no-such-config.json"}, &errOut), 1; got != want {
+		t.Errorf("runMock([-config no-such-config.json]) = %v, want %v", got, want)
+	}
+	if got, want := strings.HasPrefix(errOut.String(), "[config] "), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,702 @@
+package tracker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Installation stores information about a XMPPVOX installation.
+type Installation struct {
+	MachineId      string            `bson:"_id"`
+	XMPPVOXVersion string            `bson:"xmppvox_ver"`
+	DosvoxInfo     map[string]string `bson:"dosvox_info"`
+	// DosvoxVersion is the version key of DosvoxInfo, which is kept as sent.
+	DosvoxVersion string            `bson:"dosvox_ver,omitempty"`
+	MachineInfo   map[string]string `bson:"machine_info"`
+	// Platform is parsed from MachineInfo, which is kept as sent.
+	Platform  *Platform `bson:"platform,omitempty"`
+	CreatedAt time.Time `bson:"created_at"`
+	// Ticket is the support ticket the installation was claimed for, if any.
+	Ticket string `bson:"ticket,omitempty"`
+	// DebugUntil is when the debug capture started by the claim ends.
+	DebugUntil time.Time `bson:"debug_until,omitempty"`
+	// Tags and Notes are attached by support to triage the installation.
+	Tags  []string `bson:"tags,omitempty"`
+	Notes []*Note  `bson:"notes,omitempty"`
+	// Request is the registering request, see installationRequest.
+	Request *HttpRequest `bson:"req,omitempty"`
+	// UserAgent and Network tell the clients apart in statistics: the
+	// User-Agent header of Request and the network of its remote address.
+	UserAgent string `bson:"user_agent,omitempty"`
+	Network   string `bson:"network,omitempty"`
+	// Fingerprint identifies the machine across reinstalls, see fingerprint.
+	Fingerprint string `bson:"fingerprint,omitempty"`
+	// LastSeen and CurrentVersion are from the latest heartbeat of the
+	// machine, see PingInstallationHandler, zero until its first one.
+	LastSeen       time.Time `bson:"last_seen,omitempty"`
+	CurrentVersion string    `bson:"current_version,omitempty"`
+	// UninstalledAt is when XMPPVOX reported being uninstalled from the
+	// machine, with the reason the user gave if any.
+	UninstalledAt   time.Time `bson:"uninstalled_at,omitempty"`
+	UninstallReason string    `bson:"uninstall_reason,omitempty"`
+	// App is the id of the app of the installation, empty for the default app.
+	App string `bson:"app_id,omitempty"`
+	// Stub is set on the installations registered for a new session of an
+	// unknown machine, see sessions.unknown_installation.
+	Stub bool `bson:"stub,omitempty"`
+}
+
+// Platform is the operating system and architecture of an installation,
+// from the well-known keys of machine_info, which XMPPVOX fills with the
+// platform.uname() of Python.
+type Platform struct {
+	System    string `bson:"system,omitempty" json:"system"`   // like "Windows"
+	Release   string `bson:"release,omitempty" json:"release"` // like "7"
+	Machine   string `bson:"machine,omitempty" json:"machine"` // like "AMD64"
+	Processor string `bson:"processor,omitempty" json:"processor"`
+}
+
+// parsePlatform returns the platform described by machineInfo, or nil if
+// it has none of the well-known keys.
+func parsePlatform(machineInfo map[string]string) *Platform {
+	p := &Platform{
+		System:    strings.TrimSpace(machineInfo["system"]),
+		Release:   strings.TrimSpace(machineInfo["release"]),
+		Machine:   strings.TrimSpace(machineInfo["machine"]),
+		Processor: strings.TrimSpace(machineInfo["processor"]),
+	}
+	if *p == (Platform{}) {
+		return nil
+	}
+	return p
+}
+
+// fingerprint identifies the machine described by machineInfo across
+// reinstalls of Windows, which get a new machine id, by its node (the
+// hostname), processor and system keys. It is a hash, so that the hostname
+// is not repeated, or "" when any of them is missing, since a processor and
+// a system alone are shared by too many machines.
+func fingerprint(machineInfo map[string]string) string {
+	var parts []string
+	for _, k := range []string{"node", "processor", "system"} {
+		v := strings.ToLower(strings.TrimSpace(machineInfo[k]))
+		if v == "" {
+			return ""
+		}
+		parts = append(parts, v)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Session stores information about a XMPPVOX session.
+type Session struct {
+	Id           SessionId `bson:"_id"`
+	Alias        string    `bson:"alias,omitempty"`
+	CreatedAt    time.Time `bson:"created_at"`
+	ClosedAt     time.Time `bson:"closed_at"`
+	ClosedReason string    `bson:"closed_reason,omitempty"`
+	LastPing     time.Time `bson:"last_ping"`
+	// Duration is how long a closed session lasted, in seconds. It is only
+	// filled in by the backfill, see backfillSessions.
+	Duration *int64 `bson:"duration,omitempty"`
+	// JID is the bare jid of the user, and Resource the resource it was
+	// started with, if any, see parseJID.
+	JID            string `bson:"jid"`
+	Resource       string `bson:"resource,omitempty"`
+	MachineId      string `bson:"machine_id"`
+	XMPPVOXVersion string `bson:"xmppvox_ver"`
+	// XMPPServer is the XMPP server the client connected to, as it told.
+	XMPPServer string `bson:"xmpp_server,omitempty"`
+	// Region is the region of the client, as told by http.region_header.
+	Region string `bson:"region,omitempty"`
+	// Project is the name of the API key the session was started with, if any.
+	Project string `bson:"project,omitempty"`
+	// App is the id of the app of the session, empty for the default app.
+	App     string       `bson:"app_id,omitempty"`
+	Request *HttpRequest `bson:"req"`
+	// Secret keys the signatures of close and ping requests, see sessions.signing.
+	Secret string `bson:"secret,omitempty" json:"-"`
+	// Activity accumulates the counters reported with pings, if any.
+	Activity *SessionActivity `bson:"activity,omitempty"`
+	// Tags and Notes are attached by support to triage the session.
+	Tags  []string `bson:"tags,omitempty"`
+	Notes []*Note  `bson:"notes,omitempty"`
+	// Transfers lists the moves of the session to another machine, oldest
+	// first, see TransferSessionHandler.
+	Transfers []*SessionTransfer `bson:"transfers,omitempty"`
+	// Metadata is what the client told of its setup, like the screen
+	// reader in use, see UpdateSessionHandler.
+	Metadata map[string]string `bson:"metadata,omitempty"`
+}
+
+// SessionTransfer is a move of an open session from a machine to another.
+type SessionTransfer struct {
+	From string    `bson:"from" json:"from"`
+	To   string    `bson:"to" json:"to"`
+	At   time.Time `bson:"at" json:"at"`
+}
+
+// Note is a free-text note attached to a session or an installation.
+type Note struct {
+	Text string    `bson:"text" json:"text"`
+	By   string    `bson:"by" json:"by"`
+	At   time.Time `bson:"at" json:"at"`
+}
+
+// SessionActivity counts the messaging of a session. Pings report the
+// messages since the previous ping, which are added up, and how many
+// contacts are online, which replaces the previous figure.
+type SessionActivity struct {
+	MessagesSent     int64 `bson:"messages_sent" json:"messages_sent"`
+	MessagesReceived int64 `bson:"messages_received" json:"messages_received"`
+	ContactsOnline   *int  `bson:"contacts_online,omitempty" json:"contacts_online,omitempty"`
+	// RTT summarizes the round trips to the XMPP server measured by the
+	// client, one sample per ping that reports it.
+	RTT *RTTSummary `bson:"rtt,omitempty" json:"rtt,omitempty"`
+}
+
+// RTTSummary sums up round-trip times, in milliseconds.
+type RTTSummary struct {
+	Samples int64 `bson:"samples" json:"samples"`
+	TotalMs int64 `bson:"total_ms" json:"total_ms"`
+	MinMs   int   `bson:"min_ms" json:"min_ms"`
+	MaxMs   int   `bson:"max_ms" json:"max_ms"`
+	LastMs  int   `bson:"last_ms" json:"last_ms"`
+}
+
+// newRTTSummary returns the summary of a single sample.
+func newRTTSummary(ms int) *RTTSummary {
+	return &RTTSummary{Samples: 1, TotalMs: int64(ms), MinMs: ms, MaxMs: ms, LastMs: ms}
+}
+
+// Add merges the samples of o into s.
+func (s *RTTSummary) Add(o *RTTSummary) {
+	if s.Samples == 0 || o.MinMs < s.MinMs {
+		s.MinMs = o.MinMs
+	}
+	if o.MaxMs > s.MaxMs {
+		s.MaxMs = o.MaxMs
+	}
+	s.Samples += o.Samples
+	s.TotalMs += o.TotalMs
+	s.LastMs = o.LastMs
+}
+
+// MeanMs is the average of the samples.
+func (s *RTTSummary) MeanMs() float64 {
+	if s.Samples == 0 {
+		return 0
+	}
+	return float64(s.TotalMs) / float64(s.Samples)
+}
+
+// Reasons recorded in Session.ClosedReason.
+const (
+	ClosedByClient   = "client"
+	ClosedExpired    = "expired"
+	ClosedSuperseded = "superseded"
+	ClosedCrash      = "crash"
+	ClosedOverLimit  = "over_limit"
+)
+
+// resumable reports whether s was closed without the client meaning to, by
+// the reaper or a crash report, at or after closedSince.
+func resumable(s *Session, closedSince time.Time) bool {
+	if s.ClosedAt.IsZero() || s.ClosedAt.Before(closedSince) {
+		return false
+	}
+	return s.ClosedReason == ClosedExpired || s.ClosedReason == ClosedCrash
+}
+
+// AuditEntry records an administrative action.
+type AuditEntry struct {
+	Id      bson.ObjectId `bson:"_id"`
+	At      time.Time     `bson:"at"`
+	Action  string        `bson:"action"`
+	Target  string        `bson:"target"`
+	Actor   string        `bson:"actor"`
+	Comment string        `bson:"comment,omitempty"`
+	Details bson.M        `bson:"details,omitempty"`
+}
+
+// JobRun records an execution of a scheduled job.
+type JobRun struct {
+	Id        bson.ObjectId `bson:"_id" json:"id"`
+	Job       string        `bson:"job" json:"job"`
+	Instance  string        `bson:"instance" json:"instance"`
+	StartedAt time.Time     `bson:"started_at" json:"started_at"`
+	EndedAt   time.Time     `bson:"ended_at" json:"ended_at"`
+	Outcome   string        `bson:"outcome" json:"outcome"`
+	Items     int           `bson:"items" json:"items"`
+	Error     string        `bson:"error,omitempty" json:"error,omitempty"`
+}
+
+// CrashGroup stores the reports of crashes with the same signature.
+type CrashGroup struct {
+	Signature string    `bson:"_id" json:"signature"`
+	Traceback string    `bson:"traceback" json:"traceback"`
+	Count     int       `bson:"count" json:"count"`
+	FirstSeen time.Time `bson:"first_seen" json:"first_seen"`
+	LastSeen  time.Time `bson:"last_seen" json:"last_seen"`
+	Versions  []string  `bson:"versions" json:"versions"`
+	// Recent holds the latest maxCrashOccurrences reports.
+	Recent []*Crash `bson:"recent" json:"recent"`
+}
+
+// Crash is a single crash report.
+type Crash struct {
+	MachineId      string    `bson:"machine_id" json:"machine_id"`
+	SessionId      SessionId `bson:"session_id,omitempty" json:"session_id,omitempty"`
+	XMPPVOXVersion string    `bson:"xmppvox_ver" json:"xmppvox_version"`
+	Context        bson.M    `bson:"context,omitempty" json:"context,omitempty"`
+	At             time.Time `bson:"at" json:"at"`
+}
+
+// maxCrashOccurrences limits how many reports are kept in a CrashGroup.
+const maxCrashOccurrences = 20
+
+// Event is a client telemetry event, such as the use of a feature.
+type Event struct {
+	Id         bson.ObjectId `bson:"_id"`
+	MachineId  string        `bson:"machine_id"`
+	SessionId  SessionId     `bson:"session_id"`
+	Name       string        `bson:"name"`
+	Properties bson.M        `bson:"props,omitempty"`
+	CreatedAt  time.Time     `bson:"created_at"`
+}
+
+// Feedback is a rating of XMPPVOX sent by a user, with an optional comment.
+type Feedback struct {
+	Id        bson.ObjectId `bson:"_id" json:"id"`
+	MachineId string        `bson:"machine_id" json:"machine_id"`
+	SessionId SessionId     `bson:"session_id,omitempty" json:"session_id,omitempty"`
+	// Rating goes from 1, the worst, to 5, the best.
+	Rating    int       `bson:"rating" json:"rating"`
+	Text      string    `bson:"text,omitempty" json:"text,omitempty"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Block denies new sessions to the clients whose Field matches Value.
+type Block struct {
+	Id    bson.ObjectId `bson:"_id" json:"id"`
+	Field string        `bson:"field" json:"field"`
+	Value string        `bson:"value" json:"value"`
+	// Message is displayed by XMPPVOX to the user denied a session, in
+	// defaultLanguage.
+	Message string `bson:"message" json:"message"`
+	// Messages are Message in other languages, by language tag like "en".
+	Messages  map[string]string `bson:"messages,omitempty" json:"messages,omitempty"`
+	CreatedAt time.Time         `bson:"created_at" json:"created_at"`
+}
+
+// APIKey is a key stored in the api_keys collection, for clients to send in
+// the X-API-Key header.
+type APIKey struct {
+	Id   bson.ObjectId `bson:"_id" json:"id"`
+	Key  string        `bson:"key" json:"-"`
+	Name string        `bson:"name" json:"name"`
+	// MaxPerMinute caps the requests made with the key, 0 for api_keys.max_per_minute.
+	MaxPerMinute int       `bson:"max_per_minute" json:"max_per_minute"`
+	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+	// RevokedAt is set when the key is revoked, and it is refused from then on.
+	RevokedAt time.Time `bson:"revoked_at" json:"revoked_at"`
+	// App is the id of the app of the key, empty for the default app. Keys
+	// are stored in the database of their app.
+	App string `bson:"app_id,omitempty" json:"app,omitempty"`
+}
+
+// Webhook subscribes a URL to the session events matching Filter.
+type Webhook struct {
+	Id  bson.ObjectId `bson:"_id" json:"id"`
+	URL string        `bson:"url" json:"url"`
+	// Secret keys the signature of deliveries.
+	Secret    string        `bson:"secret" json:"-"`
+	Filter    WebhookFilter `bson:"filter" json:"filter"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+}
+
+// FeatureFlag turns a feature of XMPPVOX on for Percent of the machines
+// running a version from MinVersion to MaxVersion, both included.
+type FeatureFlag struct {
+	Name string `bson:"_id" json:"name"`
+	// Percent is from 0, off for every machine, to 100, on for every machine.
+	Percent    int       `bson:"percent" json:"percent"`
+	MinVersion string    `bson:"min_version,omitempty" json:"min_version,omitempty"`
+	MaxVersion string    `bson:"max_version,omitempty" json:"max_version,omitempty"`
+	UpdatedAt  time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// Release is a version of XMPPVOX offered to clients by /1/update/check.
+type Release struct {
+	Version string `bson:"_id" json:"version"`
+	// Channel is ReleaseStable or ReleaseBeta.
+	Channel     string    `bson:"channel" json:"channel"`
+	URL         string    `bson:"url" json:"url"`
+	Notes       string    `bson:"notes,omitempty" json:"notes"`
+	PublishedAt time.Time `bson:"published_at" json:"published_at"`
+	// Artifacts are the builds of the release for specific platforms,
+	// downloaded instead of URL by the clients of their platform.
+	Artifacts []*ReleaseArtifact `bson:"artifacts,omitempty" json:"artifacts,omitempty"`
+	// Downloads counts the downloads by the platform of the artifact, and
+	// those of URL as DefaultArtifact.
+	Downloads map[string]int `bson:"downloads,omitempty" json:"downloads,omitempty"`
+}
+
+// ReleaseArtifact is the build of a release for a platform, like "win32".
+type ReleaseArtifact struct {
+	Platform string `bson:"platform" json:"platform"`
+	URL      string `bson:"url" json:"url"`
+	// SHA256 is the hex digest of the file, for clients to check the download.
+	SHA256 string `bson:"sha256,omitempty" json:"sha256,omitempty"`
+	Size   int64  `bson:"size,omitempty" json:"size,omitempty"`
+}
+
+// DefaultArtifact counts in Release.Downloads the downloads of Release.URL.
+const DefaultArtifact = "default"
+
+// Artifact returns the URL of the release for platform, and the platform
+// its downloads count as.
+func (x *Release) Artifact(platform string) (url, countAs string) {
+	for _, a := range x.Artifacts {
+		if a.Platform == platform {
+			return a.URL, a.Platform
+		}
+	}
+	return x.URL, DefaultArtifact
+}
+
+// Channels of releases. Clients on the beta channel are offered stable
+// releases too.
+const (
+	ReleaseStable = "stable"
+	ReleaseBeta   = "beta"
+)
+
+// Claim is a code support gives a user to link an installation to a ticket.
+// A code is claimed at most once and not after ExpiresAt.
+type Claim struct {
+	Code      string    `bson:"_id" json:"code"`
+	Ticket    string    `bson:"ticket" json:"ticket"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+	MachineId string    `bson:"machine_id,omitempty" json:"machine_id,omitempty"`
+	ClaimedAt time.Time `bson:"claimed_at" json:"claimed_at"`
+}
+
+// CollectionStats are the size figures of a collection, as reported by the backend.
+type CollectionStats struct {
+	Name  string `bson:"name" json:"name"`
+	Count int64  `bson:"count" json:"count"`
+	// AvgDocBytes is the average size of a document.
+	AvgDocBytes int64 `bson:"avg_doc_bytes" json:"avg_doc_bytes"`
+	// DataBytes is the size of all documents, without padding nor indexes.
+	DataBytes int64 `bson:"data_bytes" json:"data_bytes"`
+	// IndexBytes is the size of all indexes of the collection.
+	IndexBytes int64 `bson:"index_bytes" json:"index_bytes"`
+}
+
+// StorageSnapshot records the stats of every collection at a time, to follow their trend.
+type StorageSnapshot struct {
+	Id          bson.ObjectId      `bson:"_id" json:"id"`
+	At          time.Time          `bson:"at" json:"at"`
+	Collections []*CollectionStats `bson:"collections" json:"collections"`
+}
+
+// MigrationRun records that a migration was applied, see migrations.
+type MigrationRun struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+	// Changed is how many documents or indexes the migration changed.
+	Changed int `bson:"changed" json:"changed"`
+}
+
+// Checkpoint records how far a long task went through a collection, by
+// _id, so that it resumes there after an interruption.
+type Checkpoint struct {
+	Name string `bson:"_id"`
+	// After is the last document done, empty once the task completed.
+	After     SessionId `bson:"after,omitempty"`
+	Done      int       `bson:"done"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// Retention is how long the data that grows with usage is kept, see
+// retentionJob. A zero duration keeps the data forever.
+type Retention struct {
+	// SessionRequests is how long the request data of sessions is kept,
+	// the sessions themselves are kept for statistics.
+	SessionRequests time.Duration
+	Events          time.Duration
+	// Crashes is how long crash reports are kept, and crash groups after
+	// they were last seen.
+	Crashes time.Duration
+}
+
+// Periods of rollups. Concurrency rollups are hourly too, with the peak
+// of the open sessions sampled in the hour, see concurrencyJob.
+const (
+	RollupHour        = "hour"
+	RollupDay         = "day"
+	RollupConcurrency = "concurrency"
+)
+
+// Rollup sums up the sessions of an hour or a UTC day, see rollupJob.
+type Rollup struct {
+	// Id is the period and its start, like "hour 2014-05-01T12:00:00Z",
+	// so that computing a rollup again replaces it.
+	Id              string    `bson:"_id" json:"-"`
+	Period          string    `bson:"period" json:"-"`
+	Start           time.Time `bson:"start" json:"start"`
+	SessionsStarted int       `bson:"sessions_started" json:"sessions_started"`
+	SessionsClosed  int       `bson:"sessions_closed" json:"sessions_closed"`
+	// SessionsCrashed counts the sessions closed in the period by a crash report.
+	SessionsCrashed int `bson:"sessions_crashed" json:"sessions_crashed"`
+	// UniqueJIDs and UniqueMachines count the jids and machines of the
+	// sessions started in the period.
+	UniqueJIDs     int `bson:"unique_jids" json:"unique_jids"`
+	UniqueMachines int `bson:"unique_machines" json:"unique_machines"`
+	// PeakOpenSessions is the most sessions open at once in the samples
+	// of a concurrency rollup.
+	PeakOpenSessions int       `bson:"peak_open_sessions,omitempty" json:"peak_open_sessions,omitempty"`
+	ComputedAt       time.Time `bson:"computed_at" json:"-"`
+}
+
+func NewRollup(period string, start time.Time) *Rollup {
+	return &Rollup{
+		Id:     period + " " + start.UTC().Format(time.RFC3339),
+		Period: period,
+		Start:  start,
+	}
+}
+
+// WebhookFilter selects the events delivered to a Webhook.
+// Empty fields match everything.
+type WebhookFilter struct {
+	Events     []string `bson:"events,omitempty" json:"events,omitempty"`
+	JIDDomains []string `bson:"jid_domains,omitempty" json:"jid_domains,omitempty"`
+	Projects   []string `bson:"projects,omitempty" json:"projects,omitempty"`
+	// MinVersion and MaxVersion bound the xmppvox_version, both included.
+	MinVersion string `bson:"min_version,omitempty" json:"min_version,omitempty"`
+	MaxVersion string `bson:"max_version,omitempty" json:"max_version,omitempty"`
+}
+
+// Session fields that can be blocked, as named in Block.Field, and the IP
+// address the session is requested from.
+const (
+	BlockJID            = "jid"
+	BlockMachineId      = "machine_id"
+	BlockXMPPVOXVersion = "xmppvox_version"
+	BlockRemoteIP       = "remote_ip"
+)
+
+// Flag is a suspicious pattern found by the abuse job, see detectAbuse,
+// kept for admins to review. There is one flag per kind, field and value,
+// updated by every run that finds the pattern again.
+type Flag struct {
+	Id bson.ObjectId `bson:"_id" json:"id"`
+	// Kind is the heuristic that raised the flag, like FlagSessionChurn.
+	Kind string `bson:"kind" json:"kind"`
+	// Field and Value are what the flag is about, which can be blocked:
+	// a Block.Field and its value.
+	Field string `bson:"field" json:"field"`
+	Value string `bson:"value" json:"value"`
+	// Count is how many machine ids or sessions the last run found.
+	Count   int    `bson:"count" json:"count"`
+	Message string `bson:"message" json:"message"`
+	// SeenAt is when the pattern was last found.
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	SeenAt    time.Time `bson:"seen_at" json:"seen_at"`
+	// Status is FlagOpen until the flag is reviewed.
+	Status     string    `bson:"status" json:"status"`
+	ReviewedAt time.Time `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	// BlockId is the block added from the flag, if any.
+	BlockId bson.ObjectId `bson:"block_id,omitempty" json:"block_id,omitempty"`
+}
+
+// Kinds of flags.
+const (
+	FlagMachinesPerIP = "machines_per_ip"
+	FlagSessionChurn  = "session_churn"
+	FlagInvalidJID    = "invalid_jid"
+)
+
+// Statuses of flags.
+const (
+	FlagOpen      = "open"
+	FlagDismissed = "dismissed"
+	FlagBlocked   = "blocked"
+)
+
+// DayCount is the number of documents created on a UTC day.
+type DayCount struct {
+	Day   time.Time
+	Count int
+}
+
+// VersionDayCount is the number of documents of a xmppvox_version created on a UTC day.
+type VersionDayCount struct {
+	Version string
+	DayCount
+}
+
+// VersionCount is how many documents have a version.
+type VersionCount struct {
+	Version string
+	Count   int
+}
+
+// PlatformCount is how many installations have a system, release and machine.
+type PlatformCount struct {
+	System, Release, Machine string
+	Count                    int
+}
+
+// ClientCount is how many installations have a value of a ClientsBy field.
+type ClientCount struct {
+	Value string
+	Count int
+}
+
+// SessionLatency is the region of a session and the summary of the round
+// trips its client measured.
+type SessionLatency struct {
+	Region string
+	RTT    RTTSummary
+}
+
+// ServerCount is how many sessions were started on an XMPP server, and
+// by how many distinct jids and machines.
+type ServerCount struct {
+	Server         string
+	Sessions       int
+	UniqueJIDs     int
+	UniqueMachines int
+}
+
+// OrphanCount is how many sessions were started by a machine id that has
+// no installation, and when the latest of them.
+type OrphanCount struct {
+	MachineId     string
+	Sessions      int
+	LastSessionAt time.Time
+}
+
+// UniqueInstallations counts the distinct machine ids and fingerprints of
+// installations. Installations without a fingerprint count as their own.
+// Uninstalled counts the installations marked as uninstalled and Active the
+// fingerprints with an installation that is not.
+type UniqueInstallations struct {
+	Machines     int `bson:"machines"`
+	Fingerprints int `bson:"fingerprints"`
+	Uninstalled  int `bson:"uninstalled"`
+	Active       int `bson:"active"`
+}
+
+// Fields of installations that InstallationClients counts by.
+const (
+	ClientsByUserAgent = "user_agent"
+	ClientsByNetwork   = "network"
+)
+
+// HttpRequest is a subset of http.Request.
+type HttpRequest struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+	//Body io.ReadCloser
+	//ContentLength int64
+	//TransferEncoding []string
+	//Close bool
+	Host string
+	Form url.Values
+	//PostForm url.Values
+	//MultipartForm *multipart.Form
+	//Trailer Header
+	RemoteAddr string
+	//RequestURI string
+	//TLS *tls.ConnectionState
+}
+
+func NewInstallation(machineId, xmppvoxVersion string, dosvoxInfo, machineInfo map[string]string) *Installation {
+	return &Installation{
+		MachineId:      machineId,
+		XMPPVOXVersion: xmppvoxVersion,
+		DosvoxInfo:     dosvoxInfo,
+		DosvoxVersion:  strings.TrimSpace(dosvoxInfo["version"]),
+		MachineInfo:    machineInfo,
+		Platform:       parsePlatform(machineInfo),
+		Fingerprint:    fingerprint(machineInfo),
+		CreatedAt:      bson.Now(),
+	}
+}
+
+func NewSession(jid, machineId, xmppvoxVersion string, r *HttpRequest) *Session {
+	return &Session{
+		Id:             NewSessionId(),
+		Alias:          newSessionAlias(),
+		CreatedAt:      bson.Now(),
+		JID:            jid,
+		MachineId:      machineId,
+		XMPPVOXVersion: xmppvoxVersion,
+		Request:        r,
+	}
+}
+
+func NewBlock(field, value, message string) *Block {
+	return &Block{
+		Id:        bson.NewObjectId(),
+		Field:     field,
+		Value:     value,
+		Message:   message,
+		CreatedAt: bson.Now(),
+	}
+}
+
+func NewAPIKey(key, name string, maxPerMinute int) *APIKey {
+	return &APIKey{
+		Id:           bson.NewObjectId(),
+		Key:          key,
+		Name:         name,
+		MaxPerMinute: maxPerMinute,
+		CreatedAt:    bson.Now(),
+	}
+}
+
+func NewWebhook(url, secret string, filter WebhookFilter) *Webhook {
+	return &Webhook{
+		Id:        bson.NewObjectId(),
+		URL:       url,
+		Secret:    secret,
+		Filter:    filter,
+		CreatedAt: bson.Now(),
+	}
+}
+
+func NewClaim(code, ticket string, ttl time.Duration) *Claim {
+	now := bson.Now()
+	return &Claim{
+		Code:      code,
+		Ticket:    ticket,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+}
+
+func NewAuditEntry(action, target, actor, comment string, details bson.M) *AuditEntry {
+	return &AuditEntry{
+		Id:      bson.NewObjectId(),
+		At:      bson.Now(),
+		Action:  action,
+		Target:  target,
+		Actor:   actor,
+		Comment: comment,
+		Details: details,
+	}
+}
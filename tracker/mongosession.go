@@ -0,0 +1,81 @@
+package tracker
+
+import (
+	"labix.org/v2/mgo"
+	"time"
+)
+
+// Session modes of mongo.mode, from the most to the least consistent.
+var mongoModes = map[string]mgo.Mode{
+	"strong":    mgo.Strong,
+	"monotonic": mgo.Monotonic,
+	"eventual":  mgo.Eventual,
+}
+
+const (
+	// mongoCheckInterval is how often the MongoDB session is pinged to detect failures.
+	mongoCheckInterval = 10 * time.Second
+	minMongoBackoff    = time.Second
+	defaultMaxBackoff  = time.Minute
+)
+
+// tuneSession applies the mongo settings of config to s, leaving the mgo
+// defaults for those not configured. Sessions cloned from s inherit them.
+// Storage calls time out with requests, unless the socket timeout is set.
+func tuneSession(s *mgo.Session, config *Config) {
+	conf := config.Mongo
+	if mode, ok := mongoModes[conf.Mode]; ok {
+		s.SetMode(mode, true)
+	}
+	if conf.PoolLimit > 0 {
+		s.SetPoolLimit(conf.PoolLimit)
+	}
+	if conf.SyncTimeout.Duration > 0 {
+		s.SetSyncTimeout(conf.SyncTimeout.Duration)
+	}
+	if conf.SocketTimeout.Duration > 0 {
+		s.SetSocketTimeout(conf.SocketTimeout.Duration)
+	} else {
+		s.SetSocketTimeout(requestTimeout(config, ""))
+	}
+}
+
+// nextBackoff doubles d, up to max.
+func nextBackoff(d, max time.Duration) time.Duration {
+	if d *= 2; d > max {
+		return max
+	}
+	return d
+}
+
+// superviseSession pings s every mongoCheckInterval and, when it fails, as
+// after a replica set failover, refreshes s so that it reconnects, retrying
+// with exponential backoff until it is back. It is the one place sessions
+// are recovered: requests clone s, and get the fresh sockets from then on.
+// It returns once stop is closed.
+func superviseSession(s *mgo.Session, conf *MongoConfig, stop <-chan struct{}) {
+	max := conf.MaxBackoff.Duration
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(mongoCheckInterval):
+		}
+		err := s.Ping()
+		if err == nil {
+			continue
+		}
+		logger.Warn("[mongo] ping failed, reconnecting:", err)
+		for backoff := minMongoBackoff; err != nil; backoff = nextBackoff(backoff, max) {
+			s.Refresh()
+			if err = s.Ping(); err != nil {
+				logger.Warnf("[mongo] reconnect failed, retrying in %s: %v", backoff, err)
+				time.Sleep(backoff)
+			}
+		}
+		logger.Info("[mongo] reconnected")
+	}
+}
@@ -0,0 +1,22 @@
+package tracker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNextBackoff(t *testing.T) {
+	d := minMongoBackoff
+	var waits []time.Duration
+	for i := 0; i < 8; i++ {
+		waits = append(waits, d)
+		d = nextBackoff(d, 30*time.Second)
+	}
+	if got, want := waits, ([]time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second,
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("waits = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Limits of the machines listed by OrphanSessionsHandler.
+const (
+	defaultOrphansLimit = 100
+	maxOrphansLimit     = 1000
+)
+
+// unknownInstallation returns sessions.unknown_installation of conf.
+func unknownInstallation(conf *Config) string {
+	if conf == nil || conf.Sessions == nil {
+		return ""
+	}
+	return conf.Sessions.UnknownInstallation
+}
+
+// unregisteredMachine checks that the machine of a new session has an
+// installation, as configured by sessions.unknown_installation. It replies
+// with an error and returns true when the session is refused; like blocks,
+// the client displays the message to the user. In register mode a stub
+// installation is registered for the machine instead.
+func unregisteredMachine(w http.ResponseWriter, r *http.Request, c *Context, machineId, xmppvoxVersion string) bool {
+	mode := unknownInstallation(c.Config)
+	if mode == "" {
+		return false
+	}
+	_, err := c.Store.FindInstallation(machineId)
+	switch {
+	case err == nil:
+		return false
+	case err != mgo.ErrNotFound:
+		// Sessions are worth more than the check, let them start.
+		c.Log.Error(err)
+		return false
+	case mode == UnknownInstallationRegister:
+		i := NewInstallation(machineId, xmppvoxVersion, nil, nil)
+		i.Stub = true
+		i.UserAgent = r.UserAgent()
+		i.Network = clientNetwork(r.RemoteAddr)
+		i.App = c.App
+		// A dup is a registration racing this one, as good as a stub.
+		if err := c.Store.InsertInstallation(i); err != nil && !mgo.IsDup(err) {
+			c.Log.Error(err)
+		}
+		return false
+	}
+	writeError(w, r, &APIError{"installation_not_found", "machine_id", "",
+		fmt.Sprintf("Installation %s is not registered", machineId)}, http.StatusForbidden)
+	return true
+}
+
+// orphanReport lists the machines whose sessions started over a time
+// window have no installation.
+type orphanReport struct {
+	From     time.Time        `json:"from"`
+	To       time.Time        `json:"to"`
+	Machines []*orphanMachine `json:"machines"`
+}
+
+type orphanMachine struct {
+	MachineId     string    `json:"machine_id"`
+	Sessions      int       `json:"sessions"`
+	LastSessionAt time.Time `json:"last_session_at"`
+}
+
+// OrphanSessionsHandler reports the machines that started sessions without
+// being registered, most sessions first, to follow clients that skip
+// /1/installation/new before turning sessions.unknown_installation to
+// "reject".
+func OrphanSessionsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	win, errs := parseWindow(r, time.Now().UTC(), defaultStatsWindow, maxStatsWindow)
+	limit := defaultOrphansLimit
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxOrphansLimit {
+			errs = append(errs, invalidLimit(maxOrphansLimit))
+		}
+		limit = n
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	counts, err := c.Store.OrphanSessions(win.From, win.To, limit)
+	if err != nil {
+		writeError(w, r, internalError("Failed to list orphan sessions"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	report := &orphanReport{From: win.From, To: win.To, Machines: []*orphanMachine{}}
+	for _, oc := range counts {
+		report.Machines = append(report.Machines, &orphanMachine{oc.MachineId, oc.Sessions, oc.LastSessionAt})
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,213 @@
+package tracker
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings can be overridden, from lowest to highest precedence, by:
+//
+//   1. the configuration file,
+//   2. environment variables, like ET_HTTP_PORT for http.port,
+//   3. command-line flags, like --http-port for http.port.
+//
+// Lists of strings are comma-separated. Other lists and mappings, like
+// admin.tokens or export.profiles, are given in JSON.
+
+// envPrefix prefixes the environment variables that override settings.
+const envPrefix = "ET_"
+
+// configField is a setting of Config, addressed by its JSON path.
+type configField struct {
+	Name  string // such as "http.port"
+	Index []int  // for reflect.Value.FieldByIndex
+}
+
+func (f configField) EnvName() string {
+	return envPrefix + strings.ToUpper(strings.Replace(f.Name, ".", "_", -1))
+}
+
+func (f configField) FlagName() string {
+	return strings.Replace(strings.Replace(f.Name, ".", "-", -1), "_", "-", -1)
+}
+
+// configFields lists the settings of the sections of Config.
+func configFields() []configField {
+	var fields []configField
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		section := t.Field(i)
+		st := section.Type.Elem()
+		for j := 0; j < st.NumField(); j++ {
+			f := st.Field(j)
+			if f.PkgPath != "" {
+				continue
+			}
+			fields = append(fields, configField{jsonName(section) + "." + jsonName(f), []int{i, j}})
+		}
+	}
+	return fields
+}
+
+func jsonName(f reflect.StructField) string {
+	return strings.Split(f.Tag.Get("json"), ",")[0]
+}
+
+// Set parses value into the setting of conf, allocating its section if needed.
+func (f configField) Set(conf *Config, value string) error {
+	section := reflect.ValueOf(conf).Elem().Field(f.Index[0])
+	if section.IsNil() {
+		section.Set(reflect.New(section.Type().Elem()))
+	}
+	v := section.Elem().Field(f.Index[1])
+	var err error
+	switch p := v.Addr().Interface().(type) {
+	case *string:
+		*p = value
+	case *int:
+		*p, err = strconv.Atoi(value)
+	case *float64:
+		*p, err = strconv.ParseFloat(value, 64)
+	case *bool:
+		*p, err = strconv.ParseBool(value)
+	case *Duration:
+		p.Duration, err = time.ParseDuration(value)
+	case *time.Time:
+		*p, err = time.Parse(time.RFC3339, value)
+	case *[]string:
+		if strings.HasPrefix(value, "[") {
+			err = json.Unmarshal([]byte(value), p)
+		} else {
+			*p = splitList(value)
+		}
+	default:
+		err = json.Unmarshal([]byte(value), p)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %v", f.Name, err)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, ignoring blanks around items.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// configOverrides holds the command-line flags that override settings,
+// and the --config flag giving the configuration file.
+type configOverrides struct {
+	fs     *flag.FlagSet
+	path   *string
+	fields []configField
+	values map[string]*string
+}
+
+// newConfigOverrides defines in fs the --config flag and a flag for every
+// setting.
+func newConfigOverrides(fs *flag.FlagSet) *configOverrides {
+	o := &configOverrides{fs: fs, fields: configFields(), values: make(map[string]*string)}
+	o.path = fs.String("config", "config.json", "path to a configuration file in JSON format")
+	for _, f := range o.fields {
+		o.values[f.FlagName()] = fs.String(f.FlagName(), "", fmt.Sprintf("override %s (env %s)", f.Name, f.EnvName()))
+	}
+	return o
+}
+
+// Apply overrides the settings of conf with the environment, as returned
+// by getenv, and with the flags set in the command line.
+func (o *configOverrides) Apply(conf *Config, getenv func(string) string) error {
+	set := make(map[string]bool)
+	o.fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var errs []string
+	for _, f := range o.fields {
+		value := getenv(f.EnvName())
+		ok := value != ""
+		if set[f.FlagName()] {
+			value, ok = *o.values[f.FlagName()], true
+		}
+		if !ok {
+			continue
+		}
+		if err := f.Set(conf, value); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if errs != nil {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// configPath returns the path of the configuration file, which the
+// ET_CONFIG environment variable sets when the --config flag is not given,
+// and whether the path was chosen explicitly.
+func (o *configOverrides) configPath(getenv func(string) string) (string, bool) {
+	explicit := false
+	o.fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			explicit = true
+		}
+	})
+	if explicit {
+		return *o.path, true
+	}
+	if path := getenv(envPrefix + "CONFIG"); path != "" {
+		return path, true
+	}
+	return *o.path, false
+}
+
+// configSource is where a process loads its configuration from, again on
+// every reload: the file and overrides of the flags parsed into overrides,
+// and the environment.
+type configSource struct {
+	overrides *configOverrides
+	// mock is set in mock mode, which needs no MongoDB settings.
+	mock bool
+}
+
+// defaultConfigSource returns the source of the configuration without
+// command-line flags, for the trackers not started by Main.
+func defaultConfigSource() *configSource {
+	return &configSource{overrides: newConfigOverrides(flag.NewFlagSet("defaults", flag.ContinueOnError))}
+}
+
+// loadConfig reads the configuration file of src, applies the overrides
+// and prepares the result for use. A missing file is only an error if its
+// path was chosen explicitly, so that everything can be set by the
+// environment.
+func loadConfig(src *configSource) (*Config, error) {
+	path, explicit := src.overrides.configPath(os.Getenv)
+	conf, err := ConfigOpen(path)
+	if os.IsNotExist(err) && !explicit {
+		conf, err = &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := src.overrides.Apply(conf, os.Getenv); err != nil {
+		return nil, err
+	}
+	if err := conf.prepare(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// validateConfig validates c for the mode of src.
+func validateConfig(src *configSource, c *Config) error {
+	return c.validate(!src.mock)
+}
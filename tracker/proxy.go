@@ -0,0 +1,116 @@
+package tracker
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// parseTrustedProxies parses a list of IP addresses and CIDR ranges.
+func parseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy address %q", s)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy range %q", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP in a http.Request.RemoteAddr, with or without port.
+func remoteIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+// clientIP returns the address of the client that originated r.
+// X-Forwarded-For and X-Real-IP are only honored when r comes from a trusted proxy.
+// X-Forwarded-For is walked from right to left, skipping trusted proxies,
+// since the leftmost entries can be forged by the client. Proxies may each
+// add a header line rather than append to the first one, so lines are
+// joined in order.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	if !isTrusted(remoteIP(r.RemoteAddr), trusted) {
+		return r.RemoteAddr
+	}
+	if xff := strings.Join(r.Header["X-Forwarded-For"], ","); xff != "" {
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			ip := net.ParseIP(hop)
+			if ip == nil {
+				break
+			}
+			if i == 0 || !isTrusted(ip, trusted) {
+				return hop
+			}
+		}
+	}
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
+		return xri
+	}
+	return r.RemoteAddr
+}
+
+// RealIPHandler wraps h, replacing the RemoteAddr of requests coming from
+// the trusted proxies of the current configuration with the address of the real client.
+// The http.region_header of requests from other peers is dropped, since
+// clients could forge it.
+func RealIPHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conf := requestConfig(r); conf != nil && conf.Http != nil {
+			if conf.Http.RegionHeader != "" && !isTrusted(remoteIP(r.RemoteAddr), conf.Http.trustedNets) {
+				r.Header.Del(conf.Http.RegionHeader)
+			}
+			r.RemoteAddr = clientIP(r, conf.Http.trustedNets)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// regionPattern is the spelling of the regions of clients kept with
+// sessions, like "BR" or "BR-SP".
+var regionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,31}$`)
+
+// clientRegion returns the region of the client of r, as told by the
+// http.region_header of the trusted proxies, or "" if it is unknown.
+func clientRegion(r *http.Request, conf *Config) string {
+	if conf == nil || conf.Http == nil || conf.Http.RegionHeader == "" {
+		return ""
+	}
+	region := strings.TrimSpace(r.Header.Get(conf.Http.RegionHeader))
+	if !regionPattern.MatchString(region) {
+		return ""
+	}
+	return region
+}
@@ -0,0 +1,92 @@
+package tracker
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type TestCase struct {
+		RemoteAddr, XForwardedFor, XRealIP, Expected string
+	}
+	for _, tc := range []TestCase{
+		// untrusted peers cannot spoof their address
+		TestCase{"192.0.2.1:5000", "198.51.100.7", "198.51.100.8", "192.0.2.1:5000"},
+		TestCase{"127.0.0.1:5000", "", "", "127.0.0.1:5000"},
+		TestCase{"127.0.0.1:5000", "198.51.100.7", "", "198.51.100.7"},
+		TestCase{"127.0.0.1:5000", "", "198.51.100.8", "198.51.100.8"},
+		TestCase{"127.0.0.1:5000", "198.51.100.7", "198.51.100.8", "198.51.100.7"},
+		// skip trusted hops, but do not trust what the client prepended
+		TestCase{"127.0.0.1:5000", "203.0.113.9, 198.51.100.7, 10.1.2.3", "", "198.51.100.7"},
+		TestCase{"127.0.0.1:5000", "10.1.2.3, 10.3.2.1", "", "10.1.2.3"},
+		TestCase{"127.0.0.1:5000", "garbage", "198.51.100.8", "198.51.100.8"},
+	} {
+		r := &http.Request{RemoteAddr: tc.RemoteAddr, Header: http.Header{}}
+		if tc.XForwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tc.XForwardedFor)
+		}
+		if tc.XRealIP != "" {
+			r.Header.Set("X-Real-IP", tc.XRealIP)
+		}
+		if got, want := clientIP(r, trusted), tc.Expected; got != want {
+			t.Errorf("%+v: clientIP(r, trusted) = %v, want %v", tc, got, want)
+		}
+	}
+}
+
+func TestClientIPHeaderLines(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The proxy nearest to the tracker added the last line.
+	r := &http.Request{RemoteAddr: "127.0.0.1:5000", Header: http.Header{
+		"X-Forwarded-For": {"203.0.113.9", "198.51.100.7, 10.1.2.3"},
+	}}
+	if got, want := clientIP(r, trusted), "198.51.100.7"; got != want {
+		t.Errorf("clientIP(r, trusted) = %v, want %v", got, want)
+	}
+	r.Header["X-Forwarded-For"] = []string{"203.0.113.9", "198.51.100.7"}
+	if got, want := clientIP(r, trusted), "198.51.100.7"; got != want {
+		t.Errorf("clientIP(r, trusted) = %v, want %v", got, want)
+	}
+}
+
+func TestParseTrustedProxiesInvalid(t *testing.T) {
+	for _, p := range []string{"localhost", "10.0.0.0/33", ""} {
+		_, err := parseTrustedProxies([]string{p})
+		if err == nil {
+			t.Errorf("%q: err is nil", p)
+		}
+	}
+}
+
+func TestRegionHeader(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := currentConfig()
+	defer setConfig(old)
+	conf := &Config{Http: &HttpConfig{RegionHeader: "CF-IPCountry", trustedNets: trusted}}
+	setConfig(conf)
+	var region string
+	h := RealIPHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		region = clientRegion(r, conf)
+	}))
+	for _, tc := range []struct{ remoteAddr, header, region string }{
+		{"127.0.0.1:5000", "BR", "BR"},
+		{"127.0.0.1:5000", "not a region", ""},
+		{"192.0.2.1:5000", "BR", ""},
+	} {
+		r := &http.Request{RemoteAddr: tc.remoteAddr, Header: http.Header{"Cf-Ipcountry": {tc.header}}}
+		h.ServeHTTP(nil, r)
+		if got, want := region, tc.region; got != want {
+			t.Errorf("%+v: region = %v, want %v", tc, got, want)
+		}
+	}
+}
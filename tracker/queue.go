@@ -0,0 +1,280 @@
+package tracker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"labix.org/v2/mgo"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueueMaxEntries     = 10000
+	defaultQueueReplayInterval = 30 * time.Second
+)
+
+// Kinds of queued writes.
+const (
+	QueuedInstallation = "installation"
+	QueuedPing         = "ping"
+)
+
+var errQueueFull = errors.New("write queue is full")
+
+// queuedWrite is a write accepted while storage was unavailable.
+type queuedWrite struct {
+	Kind         string        `json:"kind"`
+	QueuedAt     time.Time     `json:"queued_at"`
+	Installation *Installation `json:"installation,omitempty"`
+	SessionId    SessionId     `json:"session_id,omitempty"`
+	MachineId    string        `json:"machine_id,omitempty"`
+	// Activity is the activity reported with a ping.
+	Activity *SessionActivity `json:"activity,omitempty"`
+	// App is the app of the write, empty for the default app.
+	App string `json:"app,omitempty"`
+}
+
+// WriteQueue is a bounded on-disk queue of the installations and pings
+// accepted while storage is unavailable, replayed once it is back.
+// Writes are appended to a file, one JSON document per line, and synced
+// before being acknowledged, so that they survive restarts.
+// It is safe for concurrent use.
+type WriteQueue struct {
+	// The 64-bit fields come first to be aligned for atomic access on 32-bit platforms.
+	enqueued, refused, replayed, dropped, failedReplays int64
+
+	mu         sync.Mutex
+	path       string
+	f          *os.File
+	depth      int
+	max        int
+	lastReplay time.Time
+}
+
+func queueMaxEntries(conf *Config) int {
+	if conf != nil && conf.Queue != nil && conf.Queue.MaxEntries > 0 {
+		return conf.Queue.MaxEntries
+	}
+	return defaultQueueMaxEntries
+}
+
+// OpenWriteQueue opens the queue file at path, creating it if needed and
+// keeping the writes left from a previous run.
+func OpenWriteQueue(path string, max int) (*WriteQueue, error) {
+	writes, err := readQueue(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &WriteQueue{path: path, f: f, depth: len(writes), max: max}, nil
+}
+
+func readQueue(path string) ([]*queuedWrite, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var writes []*queuedWrite
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var qw queuedWrite
+		// A line cut short by a crash while appending is skipped.
+		if err := json.Unmarshal(scanner.Bytes(), &qw); err == nil {
+			writes = append(writes, &qw)
+		}
+	}
+	return writes, scanner.Err()
+}
+
+// Enqueue appends qw to the queue and syncs it to disk,
+// or returns errQueueFull if the queue holds max writes.
+func (q *WriteQueue) Enqueue(qw *queuedWrite) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.depth >= q.max {
+		atomic.AddInt64(&q.refused, 1)
+		return errQueueFull
+	}
+	b, err := json.Marshal(qw)
+	if err != nil {
+		return err
+	}
+	fi, err := q.f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := q.f.Write(append(b, '\n')); err != nil {
+		// A partial line would be glued to the next write, losing both.
+		q.f.Truncate(fi.Size())
+		return err
+	}
+	if err := q.f.Sync(); err != nil {
+		return err
+	}
+	q.depth++
+	atomic.AddInt64(&q.enqueued, 1)
+	return nil
+}
+
+// Replay writes the queued writes to the storage of their app given store,
+// that of the default app, oldest first, until storage
+// fails again, and keeps the writes not replayed. Writes that storage
+// rejects, such as pings of sessions closed meanwhile or installations
+// registered meanwhile, are dropped. It returns how many writes were
+// replayed or dropped.
+func (q *WriteQueue) Replay(store Storage, conf *Config) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.lastReplay = time.Now()
+	if q.depth == 0 {
+		return 0, nil
+	}
+	writes, err := readQueue(q.path)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for ; n < len(writes); n++ {
+		qw := writes[n]
+		app := appStore(store, conf, qw.App)
+		switch qw.Kind {
+		case QueuedInstallation:
+			err = app.InsertInstallation(qw.Installation)
+		case QueuedPing:
+			err = app.PingSession(&Session{Id: qw.SessionId, MachineId: qw.MachineId, Activity: qw.Activity,
+				LastPing: qw.QueuedAt})
+		}
+		if err == mgo.ErrNotFound || mgo.IsDup(err) {
+			atomic.AddInt64(&q.dropped, 1)
+			err = nil
+			continue
+		}
+		if err != nil {
+			atomic.AddInt64(&q.failedReplays, 1)
+			break
+		}
+		atomic.AddInt64(&q.replayed, 1)
+	}
+	if rerr := q.rewrite(writes[n:]); rerr != nil {
+		return n, rerr
+	}
+	return n, err
+}
+
+// rewrite replaces the queue file with writes.
+func (q *WriteQueue) rewrite(writes []*queuedWrite) error {
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, qw := range writes {
+		if err = enc.Encode(qw); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, q.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	q.f.Close()
+	if q.f, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return err
+	}
+	q.depth = len(writes)
+	return nil
+}
+
+// WriteQueueStats tells how the queue is doing, in /1/status.
+type WriteQueueStats struct {
+	Depth         int        `json:"depth"`
+	MaxEntries    int        `json:"max_entries"`
+	Enqueued      int64      `json:"enqueued"`
+	Refused       int64      `json:"refused"`
+	Replayed      int64      `json:"replayed"`
+	Dropped       int64      `json:"dropped"`
+	FailedReplays int64      `json:"failed_replays"`
+	LastReplay    *time.Time `json:"last_replay"`
+}
+
+func (q *WriteQueue) Stats() *WriteQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := &WriteQueueStats{
+		Depth:         q.depth,
+		MaxEntries:    q.max,
+		Enqueued:      atomic.LoadInt64(&q.enqueued),
+		Refused:       atomic.LoadInt64(&q.refused),
+		Replayed:      atomic.LoadInt64(&q.replayed),
+		Dropped:       atomic.LoadInt64(&q.dropped),
+		FailedReplays: atomic.LoadInt64(&q.failedReplays),
+	}
+	if !q.lastReplay.IsZero() {
+		t := q.lastReplay
+		s.LastReplay = &t
+	}
+	return s
+}
+
+// queueWrite queues qw in the write queue of the server of c when storage
+// failed with err, reporting whether it was queued. Writes are not queued
+// when the queue is disabled or full. qw.QueuedAt is set to now unless
+// already set, as for held pings.
+func (c *Context) queueWrite(err error, qw *queuedWrite) bool {
+	if c.Server == nil || c.Server.writeQueue == nil || err == nil || err == mgo.ErrNotFound || mgo.IsDup(err) {
+		return false
+	}
+	if qw.QueuedAt.IsZero() {
+		qw.QueuedAt = time.Now().UTC()
+	}
+	if qerr := c.Server.writeQueue.Enqueue(qw); qerr != nil {
+		c.Log.Error("[queue]", qerr)
+		return false
+	}
+	c.Log.Warn("[queue] queued", qw.Kind, "after", err)
+	return true
+}
+
+// replayJob replays the write queue, when there is one.
+var replayJob = &Job{
+	Name: "write_queue",
+	Interval: func(c *Config) time.Duration {
+		if c == nil || c.Queue == nil || c.Queue.Path == "" {
+			return 0
+		}
+		if c != nil && c.Queue != nil && c.Queue.ReplayInterval.Duration > 0 {
+			return c.Queue.ReplayInterval.Duration
+		}
+		return defaultQueueReplayInterval
+	},
+	Run: func(c *Context) (int, error) {
+		if c.Server == nil || c.Server.writeQueue == nil {
+			return 0, nil
+		}
+		return c.Server.writeQueue.Replay(c.Store, c.Config)
+	},
+	// Each tracker has its own queue, replayed to the apps of its writes.
+	PerInstance: true,
+	Shared:      true,
+}
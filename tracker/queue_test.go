@@ -0,0 +1,185 @@
+package tracker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type queueTest struct {
+	Store  *MemoryStore
+	Server *Server
+	Down   bool
+}
+
+var errNoServers = errors.New("no reachable servers")
+
+// flakyStore fails writes while its suite is down.
+type flakyStore struct {
+	*MemoryStore
+	s *queueTest
+}
+
+func (fs flakyStore) InsertInstallation(i *Installation) error {
+	if fs.s.Down {
+		return errNoServers
+	}
+	return fs.MemoryStore.InsertInstallation(i)
+}
+
+func (fs flakyStore) PingSession(x *Session) error {
+	if fs.s.Down {
+		return errNoServers
+	}
+	return fs.MemoryStore.PingSession(x)
+}
+
+func newQueueTest(t *testing.T) *queueTest {
+	s := &queueTest{}
+	s.Store = NewMemoryStore()
+	s.Down = false
+	q, err := OpenWriteQueue(filepath.Join(t.TempDir(), "queue"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Server = &Server{writeQueue: q}
+	return s
+}
+
+func (s *queueTest) post(h contextualHandlerFunc, data url.Values) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/", strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h(w, req, &Context{Store: flakyStore{s.Store, s}, Server: s.Server})
+	return w
+}
+
+func TestQueueDuringOutage(t *testing.T) {
+	s := newQueueTest(t)
+	session := NewSession("testuser@server.org", "machine-a", "1.0", nil)
+	if err := s.Store.InsertSession(session); err != nil {
+		t.Fatal(err)
+	}
+	s.Down = true
+	w := s.post(NewInstallationHandler, url.Values{
+		"machine_id": {"machine-a"}, "xmppvox_version": {"1.0"}, "dosvox_info": {"null"}, "machine_info": {"null"},
+	})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	w = s.post(PingSessionHandler, url.Values{"session_id": {session.Id.String()}, "machine_id": {"machine-a"}, "messages_sent": {"4"}})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	// The queue is full.
+	w = s.post(PingSessionHandler, url.Values{"session_id": {session.Id.String()}, "machine_id": {"machine-a"}})
+	if got, want := w.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := len(s.Store.Installations), 0; got != want {
+		t.Errorf("len(s.Store.Installations) = %d, want %d", got, want)
+	}
+
+	n, err := s.Server.writeQueue.Replay(flakyStore{s.Store, s}, nil)
+	if got, want := n, 0; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := err, errNoServers; got != want {
+		t.Errorf("err = %v, want %v", got, want)
+	}
+
+	// Queued writes survive a restart.
+	q, err := OpenWriteQueue(s.Server.writeQueue.path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := q.Stats().Depth, 2; got != want {
+		t.Errorf("q.Stats().Depth = %v, want %v", got, want)
+	}
+
+	s.Down = false
+	n, err = q.Replay(flakyStore{s.Store, s}, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := n, 2; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := s.Store.Installations["machine-a"].XMPPVOXVersion, "1.0"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.Store.Sessions[session.Id].LastPing.IsZero(), false; got != want {
+		t.Errorf("s.Store.Sessions[session.Id].LastPing.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := s.Store.Sessions[session.Id].Activity.MessagesSent, int64(4); got != want {
+		t.Errorf("s.Store.Sessions[session.Id].Activity.MessagesSent = %v, want %v", got, want)
+	}
+	stats := q.Stats()
+	if got, want := stats.Depth, 0; got != want {
+		t.Errorf("stats.Depth = %v, want %v", got, want)
+	}
+	if got, want := stats.Replayed, int64(2); got != want {
+		t.Errorf("stats.Replayed = %v, want %v", got, want)
+	}
+	if got, want := stats.FailedReplays, int64(0); got != want {
+		t.Errorf("stats.FailedReplays = %v, want %v", got, want)
+	}
+}
+
+func TestReplayDropsRejectedWrites(t *testing.T) {
+	s := newQueueTest(t)
+	if err := s.Server.writeQueue.Enqueue(&queuedWrite{Kind: QueuedPing, SessionId: NewSession("", "", "", nil).Id}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.Server.writeQueue.Replay(s.Store, nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	if got, want := s.Server.writeQueue.Stats().Dropped, int64(1); got != want {
+		t.Errorf("s.Server.writeQueue.Stats().Dropped = %v, want %v", got, want)
+	}
+	if got, want := s.Server.writeQueue.Stats().Depth, 0; got != want {
+		t.Errorf("s.Server.writeQueue.Stats().Depth = %v, want %v", got, want)
+	}
+}
+
+func TestReplayKeepsPingTime(t *testing.T) {
+	s := newQueueTest(t)
+	session := NewSession("testuser@server.org", "machine-a", "1.0", nil)
+	if err := s.Store.InsertSession(session); err != nil {
+		t.Fatal(err)
+	}
+	at := session.CreatedAt.Add(time.Minute)
+	if err := s.Server.writeQueue.Enqueue(&queuedWrite{Kind: QueuedPing, QueuedAt: at, SessionId: session.Id, MachineId: "machine-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Server.writeQueue.Replay(s.Store, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.Store.Sessions[session.Id].LastPing, at; !got.Equal(want) {
+		t.Errorf("LastPing = %v, want the time of the queued ping, %v", got, want)
+	}
+}
+
+func TestNoQueueWithoutPath(t *testing.T) {
+	s := newQueueTest(t)
+	s.Server.writeQueue = nil
+	if got, want := replayJob.Interval(&Config{}), time.Duration(0); got != want {
+		t.Errorf("replayJob.Interval(&Config{}) = %v, want %v", got, want)
+	}
+	s.Down = true
+	w := s.post(NewInstallationHandler, url.Values{
+		"machine_id": {"machine-a"}, "xmppvox_version": {"1.0"}, "dosvox_info": {"null"}, "machine_info": {"null"},
+	})
+	if got, want := w.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+}
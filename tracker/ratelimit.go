@@ -0,0 +1,88 @@
+package tracker
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter allows up to a number of events per key in fixed time windows.
+// It is safe for concurrent use and keeps state in memory, so limits apply
+// per process, unless counted in storage with AllowIn.
+type RateLimiter struct {
+	sync.Mutex
+	// name sets the counters in storage of this limiter apart from others.
+	name      string
+	windows   map[string]*rateWindow
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type rateWindow struct {
+	Start time.Time
+	Count int
+}
+
+func NewRateLimiter(name string) *RateLimiter {
+	return &RateLimiter{name: name, windows: make(map[string]*rateWindow), now: time.Now}
+}
+
+// Allow records an event for key, reporting whether it is within limit
+// events per window and, when it is not, how long until the window resets.
+func (l *RateLimiter) Allow(key string, limit int, window time.Duration) (bool, time.Duration) {
+	now := l.now()
+	l.Lock()
+	defer l.Unlock()
+	l.sweep(now, window)
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.Start) >= window {
+		w = &rateWindow{Start: now}
+		l.windows[key] = w
+	}
+	if w.Count >= limit {
+		return false, w.Start.Add(window).Sub(now)
+	}
+	w.Count++
+	return true, 0
+}
+
+// sweep forgets expired windows, at most once per window.
+func (l *RateLimiter) sweep(now time.Time, window time.Duration) {
+	if now.Sub(l.lastSweep) < window {
+		return
+	}
+	for key, w := range l.windows {
+		if now.Sub(w.Start) >= window {
+			delete(l.windows, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// AllowIn is like Allow, counting the events in store, if not nil, so that
+// the limit applies to every tracker sharing it. Windows then start at
+// multiples of window. When store fails, the counters in memory decide.
+func (l *RateLimiter) AllowIn(store Storage, key string, limit int, window time.Duration) (bool, time.Duration) {
+	if store == nil {
+		return l.Allow(key, limit, window)
+	}
+	now := l.now()
+	start := now.Truncate(window)
+	n, err := store.CountRate(l.name+":"+key, start, start.Add(window))
+	if err != nil {
+		logger.Warnf("[ratelimit] failed to count %s in storage: %v", l.name, err)
+		return l.Allow(key, limit, window)
+	}
+	if n > limit {
+		return false, start.Add(window).Sub(now)
+	}
+	return true, 0
+}
+
+// sharedRates returns the storage of c to count rate limits in when
+// cluster.enabled is set, or nil to count them in memory.
+func sharedRates(c *Context) Storage {
+	if c.Config != nil && c.Config.Cluster != nil && c.Config.Cluster.Enabled {
+		return c.Store
+	}
+	return nil
+}
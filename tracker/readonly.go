@@ -0,0 +1,23 @@
+package tracker
+
+import (
+	"net/http"
+)
+
+// readOnlyHandler wraps the API, refusing with 503 the requests that could
+// write while the Server of the request is read-only, as when it serves
+// statistics and exports from a MongoDB secondary, see Server.ReadOnly.
+func readOnlyHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+		default:
+			if srv := requestServer(r); srv != nil && srv.ReadOnly {
+				writeError(w, r, &APIError{"read_only", "", "",
+					"This tracker is read-only, send changes to the primary tracker"}, http.StatusServiceUnavailable)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
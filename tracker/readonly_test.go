@@ -0,0 +1,53 @@
+package tracker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type readOnlyTest struct {
+	Server *Server
+}
+
+func newReadOnlyTest(t *testing.T) *readOnlyTest {
+	s := &readOnlyTest{}
+	s.Server = &Server{}
+	return s
+}
+
+func (s *readOnlyTest) serve(method, path string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	w := httptest.NewRecorder()
+	withServer(readOnlyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("served"))
+	})), s.Server).ServeHTTP(w, req)
+	return w
+}
+
+func TestReadOnly(t *testing.T) {
+	s := newReadOnlyTest(t)
+	if got, want := s.serve("POST", "/1/session/new").Body.String(), "served"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	s.Server.ReadOnly = true
+	for _, method := range []string{"GET", "HEAD", "OPTIONS"} {
+		w := s.serve(method, "/2/stats/versions")
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Errorf("%s: w.Code = %v, want %v", method, got, want)
+		}
+		if got, want := w.Body.String(), "served"; got != want {
+			t.Errorf("w.Body.String() = %v, want %v", got, want)
+		}
+	}
+	for _, method := range []string{"POST", "DELETE"} {
+		w := s.serve(method, "/1/session/new")
+		if got, want := w.Code, http.StatusServiceUnavailable; got != want {
+			t.Errorf("%s: w.Code = %v, want %v", method, got, want)
+		}
+		if got, want := w.Body.String(), "This tracker is read-only, send changes to the primary tracker\n"; got != want {
+			t.Errorf("w.Body.String() = %v, want %v", got, want)
+		}
+	}
+}
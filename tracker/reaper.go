@@ -0,0 +1,32 @@
+package tracker
+
+import (
+	"time"
+)
+
+// defaultReaperInterval is used when reaper.interval is not configured.
+const defaultReaperInterval = time.Minute
+
+// reaperJob closes sessions of clients that stopped pinging, as when XMPPVOX
+// crashes or the computer is turned off, with the reason "expired", and
+// notifies webhooks of their close. Reopened sessions have their last ping
+// refreshed, so they are not reaped again right away.
+var reaperJob = &Job{
+	Name: "reaper",
+	Interval: func(c *Config) time.Duration {
+		if c == nil || c.Reaper == nil || c.Reaper.ExpireAfter.Duration <= 0 {
+			return 0
+		}
+		if c.Reaper.Interval.Duration > 0 {
+			return c.Reaper.Interval.Duration
+		}
+		return defaultReaperInterval
+	},
+	Run: func(c *Context) (int, error) {
+		closed, err := c.Store.ExpireSessions(time.Now().Add(-c.Config.Reaper.ExpireAfter.Duration))
+		for _, s := range closed {
+			notifyWebhooks(c, WebhookSessionClose, s)
+		}
+		return len(closed), err
+	},
+}
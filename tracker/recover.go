@@ -0,0 +1,150 @@
+package tracker
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// handlerPanic is a panic raised again in another goroutine, keeping the
+// stack where it was first raised.
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// recoverHandler wraps h, answering 500 with code internal_error when it
+// panics instead of dropping the connection. The panic is logged with its
+// stack, counted in runtimeStats and, with sentry.dsn, reported to Sentry.
+// Responses already started are left as they are.
+func recoverHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			stack := debug.Stack()
+			if hp, ok := p.(*handlerPanic); ok {
+				p, stack = hp.value, hp.stack
+			}
+			// The id is set by the handler of the request, if it got that far.
+			id := w.Header().Get("X-Request-Id")
+			if id == "" {
+				id = requestId(r)
+				w.Header().Set("X-Request-Id", id)
+			}
+			runtimeStats.ObservePanic()
+			(&Logger{id}).Errorf("[panic] %s %s: %v\n%s", r.Method, r.URL.Path, p, stack)
+			if conf := requestConfig(r); conf != nil && conf.Sentry != nil && conf.Sentry.DSN != "" {
+				go reportPanic(conf.Sentry, sentryEvent(conf.Sentry, r, id, p, stack))
+			}
+			if rec.Status == 0 {
+				writeError(w, r, internalError("Internal server error"), http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(rec, r)
+	})
+}
+
+// sentryClient sends reports to Sentry.
+var sentryClient = &http.Client{Timeout: 10 * time.Second}
+
+// sentryDSN is a parsed Sentry DSN, like
+// "https://<public key>@sentry.example.org/<project id>".
+type sentryDSN struct {
+	storeURL  string
+	publicKey string
+	secretKey string
+}
+
+func parseSentryDSN(dsn string) (*sentryDSN, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User == nil || i < 0 || u.Path[i+1:] == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN %q", dsn)
+	}
+	d := &sentryDSN{
+		storeURL:  fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], u.Path[i+1:]),
+		publicKey: u.User.Username(),
+	}
+	d.secretKey, _ = u.User.Password()
+	return d, nil
+}
+
+// sentryEvent is the report of a panic in the format of the Sentry store API.
+// It has the method and path of the request but neither its headers nor its
+// body, which may hold tokens and personal data.
+func sentryEvent(conf *SentryConfig, r *http.Request, id string, p interface{}, stack []byte) map[string]interface{} {
+	eventId := make([]byte, 16)
+	rand.Read(eventId)
+	host, _ := os.Hostname()
+	return map[string]interface{}{
+		"event_id":    hex.EncodeToString(eventId),
+		"timestamp":   time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"level":       "error",
+		"logger":      "elephant-tracker",
+		"platform":    "go",
+		"server_name": host,
+		"environment": conf.Environment,
+		"message":     fmt.Sprintf("panic: %v", p),
+		"tags":        map[string]string{"request_id": id},
+		"request":     map[string]string{"method": r.Method, "url": r.URL.Path},
+		"extra":       map[string]string{"stack": string(stack), "remote_addr": r.RemoteAddr},
+	}
+}
+
+// reportPanic posts event to the Sentry project of conf, logging failures.
+func reportPanic(conf *SentryConfig, event map[string]interface{}) {
+	if err := postSentry(conf.DSN, event); err != nil {
+		logger.Error("[sentry]", err)
+	}
+}
+
+func postSentry(dsn string, event map[string]interface{}) error {
+	d, err := parseSentryDSN(dsn)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", d.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	auth := "Sentry sentry_version=7, sentry_client=elephant-tracker/1.0, sentry_key=" + d.publicKey
+	if d.secretKey != "" {
+		auth += ", sentry_secret=" + d.secretKey
+	}
+	req.Header.Set("X-Sentry-Auth", auth)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := sentryClient.Do(req)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package tracker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recoverTest struct{}
+
+// The stacks of the panics are not worth printing.
+func newRecoverTest(t *testing.T) *recoverTest {
+	s := &recoverTest{}
+	logOutput.sink = &writerSink{ioutil.Discard}
+	t.Cleanup(func() {
+		logOutput.sink = &writerSink{os.Stderr}
+	})
+	return s
+}
+
+func (s *recoverTest) serve(h http.HandlerFunc) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/1/session/ping", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	recoverHandler(h).ServeHTTP(w, req)
+	return w
+}
+
+func TestPanic(t *testing.T) {
+	s := newRecoverTest(t)
+	panics := runtimeStats.Snapshot().Panics
+	w := s.serve(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "4f2a")
+		panic("oops")
+	})
+	if got, want := w.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), `{"errors":[{"code":"internal_error","message":"Internal server error"}]}`+"\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("X-Request-Id"), "4f2a"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := runtimeStats.Snapshot().Panics, panics+1; got != want {
+		t.Errorf("runtimeStats.Snapshot().Panics = %v, want %v", got, want)
+	}
+}
+
+func TestPanicAfterResponseStarted(t *testing.T) {
+	s := newRecoverTest(t)
+	w := s.serve(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("oops")
+	})
+	if got, want := w.Code, http.StatusAccepted; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), ""; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("X-Request-Id"), ""; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+}
+
+func TestPanicWithTimeout(t *testing.T) {
+	s := newRecoverTest(t)
+	w := s.serve(func(w http.ResponseWriter, r *http.Request) {
+		serveWithTimeout(w, r, time.Minute, func(w http.ResponseWriter) {
+			panic("oops")
+		})
+	})
+	if got, want := w.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+}
+
+func TestSentry(t *testing.T) {
+	newRecoverTest(t)
+	var auth string
+	var event map[string]interface{}
+	sentry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := r.URL.Path, "/sentry/api/42/store/"; got != want {
+			t.Errorf("r.URL.Path = %v, want %v", got, want)
+		}
+		auth = r.Header.Get("X-Sentry-Auth")
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer sentry.Close()
+
+	conf := &SentryConfig{DSN: strings.Replace(sentry.URL, "//", "//public:secret@", 1) + "/sentry/42", Environment: "staging"}
+	req, _ := http.NewRequest("POST", "/1/session/ping?machine_id=00:26:cc:18:be:14", nil)
+	if err := postSentry(conf.DSN, sentryEvent(conf, req, "4f2a", "oops", []byte("goroutine 1"))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := auth, "Sentry sentry_version=7, sentry_client=elephant-tracker/1.0, sentry_key=public, sentry_secret=secret"; got != want {
+		t.Errorf("auth = %v, want %v", got, want)
+	}
+	if got, want := event["message"], "panic: oops"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := event["environment"], "staging"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := event["tags"], (map[string]interface{}{"request_id": "4f2a"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := event["request"], (map[string]interface{}{"method": "POST", "url": "/1/session/ping"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, pattern := event["event_id"].(string), "[0-9a-f]{32}"; !fullMatch(pattern, got) {
+		t.Errorf("got %q, want a match of %q", got, pattern)
+	}
+}
+
+func TestParseSentryDSN(t *testing.T) {
+	newRecoverTest(t)
+	d, err := parseSentryDSN("https://key@sentry.example.org/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *d, (sentryDSN{storeURL: "https://sentry.example.org/api/42/store/", publicKey: "key"}); got != want {
+		t.Errorf("*d = %v, want %v", got, want)
+	}
+	for _, dsn := range []string{"sentry.example.org/42", "https://sentry.example.org/42", "https://key@sentry.example.org/"} {
+		_, err := parseSentryDSN(dsn)
+		if err == nil {
+			t.Errorf("%s: err is nil", dsn)
+		}
+	}
+}
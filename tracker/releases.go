@@ -0,0 +1,250 @@
+package tracker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// releaseChannels lists the valid values of Release.Channel.
+var releaseChannels = []string{ReleaseStable, ReleaseBeta}
+
+// artifactPlatformPattern is the spelling of artifact platforms, like
+// "win32", which count downloads as field names.
+var artifactPlatformPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// releaseVersionPattern is the spelling of release versions, like "1.2" or "1.3-beta1".
+var releaseVersionPattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z.+-]{0,31}$`)
+
+// latestRelease returns the latest version of releases offered on channel,
+// comparing versions with compareVersions, or nil if there is none.
+func latestRelease(releases []*Release, channel string) *Release {
+	var latest *Release
+	for _, x := range releases {
+		if x.Channel != ReleaseStable && x.Channel != channel {
+			continue
+		}
+		if latest == nil || compareVersions(x.Version, latest.Version) > 0 {
+			latest = x
+		}
+	}
+	return latest
+}
+
+// updateCheck is the reply of UpdateCheckHandler.
+type updateCheck struct {
+	UpdateAvailable bool     `json:"update_available"`
+	Latest          *Release `json:"latest"`
+	// Download is the path of UpdateDownloadHandler for Latest.
+	Download string `json:"download,omitempty"`
+}
+
+// UpdateCheckHandler replies with the latest release of the channel query
+// param, stable by default, and whether it is newer than the
+// xmppvox_version param, so that XMPPVOX can announce updates to its users.
+func UpdateCheckHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkQueryParams(r, "xmppvox_version")
+	channel := r.URL.Query().Get("channel")
+	if channel == "" {
+		channel = ReleaseStable
+	} else if !contains(releaseChannels, channel) {
+		errs = append(errs, &APIError{"invalid_value", "channel", "",
+			fmt.Sprintf("Invalid channel %s, expected one of %v", channel, releaseChannels)})
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	releases, err := c.Store.Releases()
+	if err != nil {
+		writeError(w, r, internalError("Failed to check for updates"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	check := &updateCheck{}
+	if latest := latestRelease(releases, channel); latest != nil {
+		// Download counts are for the project, not for clients.
+		x := *latest
+		x.Downloads = nil
+		check.Latest = &x
+		check.UpdateAvailable = compareVersions(x.Version, r.URL.Query().Get("xmppvox_version")) > 0
+		check.Download = strings.TrimSuffix(r.URL.Path, "/check") + "/download/" + x.Version
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(check)
+}
+
+// UpdateDownloadHandler redirects to the artifact of a release for the
+// platform query param, or to the URL of the release when it has no artifact
+// for the platform, counting the download.
+func UpdateDownloadHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	version := mux.Vars(r)["version"]
+	x, err := c.Store.FindRelease(version)
+	switch err {
+	case nil:
+	case mgo.ErrNotFound:
+		writeError(w, r, notFound(fmt.Sprintf("Release %s does not exist", version)), http.StatusNotFound)
+		return
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to download release %s", version)), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	target, countAs := x.Artifact(r.URL.Query().Get("platform"))
+	// A download is not refused because it could not be counted. Probes
+	// checking the link with HEAD do not download.
+	if r.Method != "HEAD" {
+		if err := c.Store.CountDownload(version, countAs); err != nil {
+			c.Log.Error(err)
+		}
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// NewReleaseHandler publishes a release of XMPPVOX, downloaded from url, on
+// channel, stable by default.
+func NewReleaseHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"version", "url"}, "channel", "notes")
+	version := r.PostFormValue("version")
+	if version != "" && !releaseVersionPattern.MatchString(version) {
+		errs = append(errs, &APIError{"invalid_value", "version", "",
+			fmt.Sprintf("Invalid version %s, expected up to 32 letters, digits, dots, dashes and pluses", version)})
+	}
+	if s := r.PostFormValue("url"); s != "" {
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, &APIError{"invalid_value", "url", "", fmt.Sprintf("Invalid url %s, expected a http or https URL", s)})
+		}
+	}
+	channel := r.PostFormValue("channel")
+	if channel == "" {
+		channel = ReleaseStable
+	} else if !contains(releaseChannels, channel) {
+		errs = append(errs, &APIError{"invalid_value", "channel", "",
+			fmt.Sprintf("Invalid channel %s, expected one of %v", channel, releaseChannels)})
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	x := &Release{
+		Version:     version,
+		Channel:     channel,
+		URL:         r.PostFormValue("url"),
+		Notes:       strings.TrimSpace(r.PostFormValue("notes")),
+		PublishedAt: bson.Now(),
+	}
+	err := c.Store.InsertRelease(x)
+	switch {
+	case mgo.IsDup(err):
+		writeError(w, r, &APIError{"already_released", "version", "", fmt.Sprintf("Version %s is already released", version)},
+			http.StatusBadRequest)
+		return
+	case err != nil:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to add release %s", version)), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	a := NewAuditEntry("release.add", version, c.actor(r), "", bson.M{"channel": x.Channel, "url": x.URL})
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	fmt.Fprintln(w, version)
+}
+
+// NewReleaseArtifactHandler adds to a release its build for a platform, with
+// the hex SHA-256 digest and the size in bytes of the file, if given.
+func NewReleaseArtifactHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"version", "platform", "url"}, "sha256", "size")
+	artifact := &ReleaseArtifact{Platform: r.PostFormValue("platform"), URL: r.PostFormValue("url")}
+	if artifact.Platform != "" && (!artifactPlatformPattern.MatchString(artifact.Platform) || artifact.Platform == DefaultArtifact) {
+		errs = append(errs, &APIError{"invalid_value", "platform", "", fmt.Sprintf(
+			"Invalid platform %s, expected up to 32 lowercase letters, digits, dashes and underscores, but not %s",
+			artifact.Platform, DefaultArtifact)})
+	}
+	if artifact.URL != "" {
+		if u, err := url.Parse(artifact.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, &APIError{"invalid_value", "url", "", fmt.Sprintf("Invalid url %s, expected a http or https URL", artifact.URL)})
+		}
+	}
+	if s := r.PostFormValue("sha256"); s != "" {
+		if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+			errs = append(errs, &APIError{"invalid_value", "sha256", "", fmt.Sprintf("Invalid sha256 %s, expected 64 hex digits", s)})
+		}
+		artifact.SHA256 = strings.ToLower(s)
+	}
+	if s := r.PostFormValue("size"); s != "" {
+		var err error
+		if artifact.Size, err = strconv.ParseInt(s, 10, 64); err != nil || artifact.Size < 1 {
+			errs = append(errs, &APIError{"invalid_value", "size", "", fmt.Sprintf("Invalid size %s, expected a positive number of bytes", s)})
+		}
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	version := r.PostFormValue("version")
+	switch err := c.Store.AddReleaseArtifact(version, artifact); err {
+	case nil:
+		a := NewAuditEntry("release.artifact", version, c.actor(r), "", bson.M{"platform": artifact.Platform, "url": artifact.URL})
+		if err := c.Store.InsertAuditEntry(a); err != nil {
+			c.Log.Error(err)
+		}
+		fmt.Fprintln(w, version)
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"release_not_found", "version", "",
+			fmt.Sprintf("Release %s does not exist or already has an artifact for %s", version, artifact.Platform)},
+			http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to add artifact to release %s", version)), http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// RemoveReleaseHandler withdraws a release, which clients are no longer
+// offered.
+func RemoveReleaseHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	if errs := checkParams(r, []string{"version"}); errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	version := r.PostFormValue("version")
+	switch err := c.Store.RemoveRelease(version); err {
+	case nil:
+		a := NewAuditEntry("release.remove", version, c.actor(r), "", nil)
+		if err := c.Store.InsertAuditEntry(a); err != nil {
+			c.Log.Error(err)
+		}
+		fmt.Fprintln(w, version)
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"release_not_found", "version", "", fmt.Sprintf("Release %s does not exist", version)},
+			http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to remove release %s", version)), http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// ReleasesHandler lists all releases, latest published first, with their
+// download counts.
+func ReleasesHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	releases, err := c.Store.Releases()
+	if err != nil {
+		writeError(w, r, internalError("Failed to list releases"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if releases == nil {
+		releases = []*Release{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(releases)
+}
@@ -0,0 +1,237 @@
+package tracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type releasesTest struct {
+	old     *Config
+	store   *MemoryStore
+	handler http.Handler
+}
+
+func newReleasesTest(t *testing.T) *releasesTest {
+	s := &releasesTest{}
+	s.old = currentConfig()
+	setConfig(&Config{Admin: &AdminConfig{Tokens: []AdminToken{{Token: testAdminToken, Role: RoleOperator}}}})
+	s.store = NewMemoryStore()
+	openStore = func() (Storage, func()) {
+		return s.store, func() {}
+	}
+	s.handler = APIHandler()
+	t.Cleanup(func() {
+		setConfig(s.old)
+	})
+	return s
+}
+
+func (s *releasesTest) do(method, path string, params url.Values) *httptest.ResponseRecorder {
+	r, _ := http.NewRequest(method, path, strings.NewReader(params.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Admin-Token", testAdminToken)
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+	return w
+}
+
+func (s *releasesTest) release(t *testing.T, version, channel string) {
+	w := s.do("POST", "/admin/1/releases/new", url.Values{
+		"version": {version},
+		"url":     {"https://downloads.example.org/xmppvox-" + version + ".exe"},
+		"channel": {channel},
+		"notes":   {"Release " + version},
+	})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), version+"\n"; got != want {
+		t.Fatalf("w.Body.String() = %v, want %v", got, want)
+	}
+}
+
+func (s *releasesTest) check(t *testing.T, query string) *updateCheck {
+	w := s.do("GET", "/1/update/check?"+query, nil)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	check := &updateCheck{}
+	if err := json.Unmarshal(w.Body.Bytes(), check); err != nil {
+		t.Fatal(err)
+	}
+	return check
+}
+
+func TestUpdateCheck(t *testing.T) {
+	s := newReleasesTest(t)
+	if got, want := s.check(t, "xmppvox_version=1.0"), (&updateCheck{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	s.release(t, "1.10", "")
+	s.release(t, "1.9", ReleaseStable)
+	s.release(t, "1.11-beta1", ReleaseBeta)
+
+	check := s.check(t, "xmppvox_version=1.9")
+	if got, want := check.UpdateAvailable, true; got != want {
+		t.Errorf("check.UpdateAvailable = %v, want %v", got, want)
+	}
+	if check.Latest == nil {
+		t.Fatal("check.Latest is nil")
+	}
+	// Versions are compared part by part, not by publication.
+	if got, want := check.Latest.Version, "1.10"; got != want {
+		t.Errorf("check.Latest.Version = %v, want %v", got, want)
+	}
+	if got, want := check.Latest.URL, "https://downloads.example.org/xmppvox-1.10.exe"; got != want {
+		t.Errorf("check.Latest.URL = %v, want %v", got, want)
+	}
+	if got, want := check.Latest.Notes, "Release 1.10"; got != want {
+		t.Errorf("check.Latest.Notes = %v, want %v", got, want)
+	}
+	if got, want := s.check(t, "xmppvox_version=1.10").UpdateAvailable, false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	check = s.check(t, "xmppvox_version=1.10&channel=beta")
+	if got, want := check.UpdateAvailable, true; got != want {
+		t.Errorf("check.UpdateAvailable = %v, want %v", got, want)
+	}
+	if got, want := check.Latest.Version, "1.11-beta1"; got != want {
+		t.Errorf("check.Latest.Version = %v, want %v", got, want)
+	}
+
+	w := s.do("GET", "/1/update/check?channel=nightly", nil)
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Missing parameter xmppvox_version\nInvalid channel nightly, expected one of [stable beta]\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+}
+
+func TestReleasesAdmin(t *testing.T) {
+	s := newReleasesTest(t)
+	s.release(t, "1.0", "")
+	w := s.do("POST", "/admin/1/releases/new", url.Values{"version": {"1.0"}, "url": {"https://downloads.example.org/x.exe"}})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Version 1.0 is already released\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	w = s.do("POST", "/admin/1/releases/new", url.Values{"version": {"1 0"}, "url": {"ftp://x"}, "channel": {"alpha"}})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Invalid version 1 0, expected up to 32 letters, digits, dots, dashes and pluses\n"+
+		"Invalid url ftp://x, expected a http or https URL\n"+
+		"Invalid channel alpha, expected one of [stable beta]\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+
+	w = s.do("GET", "/admin/1/releases", nil)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	var releases []*Release
+	if err := json.Unmarshal(w.Body.Bytes(), &releases); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(releases), 1; got != want {
+		t.Fatalf("len(releases) = %d, want %d", got, want)
+	}
+	if got, want := releases[0].Channel, ReleaseStable; got != want {
+		t.Errorf("releases[0].Channel = %v, want %v", got, want)
+	}
+
+	if got, want := s.do("POST", "/admin/1/releases/remove", url.Values{"version": {"1.0"}}).Code, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	w = s.do("POST", "/admin/1/releases/remove", url.Values{"version": {"1.0"}})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Release 1.0 does not exist\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got := s.check(t, "xmppvox_version=0.9").Latest; got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+	if got, want := len(s.store.Audit), 2; got != want {
+		t.Errorf("len(s.store.Audit) = %d, want %d", got, want)
+	}
+}
+
+func TestReleaseDownload(t *testing.T) {
+	s := newReleasesTest(t)
+	s.release(t, "1.3", "")
+	w := s.do("POST", "/admin/1/releases/artifacts/new", url.Values{
+		"version":  {"1.3"},
+		"platform": {"win64"},
+		"url":      {"https://downloads.example.org/xmppvox-1.3-win64.exe"},
+		"sha256":   {strings.Repeat("AB", 32)},
+		"size":     {"1048576"},
+	})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	w = s.do("POST", "/admin/1/releases/artifacts/new", url.Values{
+		"version": {"1.3"}, "platform": {"win64"}, "url": {"https://downloads.example.org/x.exe"},
+	})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Release 1.3 does not exist or already has an artifact for win64\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	w = s.do("POST", "/admin/1/releases/artifacts/new", url.Values{
+		"version": {"1.3"}, "platform": {"default"}, "url": {"https://downloads.example.org/x.exe"}, "sha256": {"abc"}, "size": {"0"},
+	})
+	if got, want := w.Body.String(), "Invalid platform default, expected up to 32 lowercase letters, digits, dashes and underscores, but not default\n"+
+		"Invalid sha256 abc, expected 64 hex digits\n"+
+		"Invalid size 0, expected a positive number of bytes\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+
+	check := s.check(t, "xmppvox_version=1.2")
+	if got, want := check.Download, "/1/update/download/1.3"; got != want {
+		t.Errorf("check.Download = %v, want %v", got, want)
+	}
+	if got, want := len(check.Latest.Artifacts), 1; got != want {
+		t.Fatalf("len(check.Latest.Artifacts) = %d, want %d", got, want)
+	}
+	if got, want := check.Latest.Artifacts[0], (&ReleaseArtifact{"win64", "https://downloads.example.org/xmppvox-1.3-win64.exe",
+		strings.Repeat("ab", 32), 1048576}); !reflect.DeepEqual(got, want) {
+		t.Errorf("check.Latest.Artifacts[0] = %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct{ query, location string }{
+		{"?platform=win64", "https://downloads.example.org/xmppvox-1.3-win64.exe"},
+		{"?platform=win32", "https://downloads.example.org/xmppvox-1.3.exe"},
+		{"", "https://downloads.example.org/xmppvox-1.3.exe"},
+	} {
+		r, _ := http.NewRequest("GET", "/1/update/download/1.3"+tc.query, nil)
+		w := httptest.NewRecorder()
+		s.handler.ServeHTTP(w, r)
+		if got, want := w.Code, http.StatusFound; got != want {
+			t.Errorf("w.Code = %v, want %v", got, want)
+		}
+		if got, want := w.Header().Get("Location"), tc.location; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+	if got, want := s.store.ReleaseList[0].Downloads, (map[string]int{"win64": 1, DefaultArtifact: 2}); !reflect.DeepEqual(got, want) {
+		t.Errorf("s.store.ReleaseList[0].Downloads = %v, want %v", got, want)
+	}
+	// Clients are not told the counts.
+	if got := s.check(t, "xmppvox_version=1.2").Latest.Downloads; got != nil {
+		t.Errorf("got %v, want nil", got)
+	}
+	if got, want := s.do("GET", "/1/update/download/1.4", nil).Code, http.StatusNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,35 @@
+package tracker
+
+import (
+	"time"
+)
+
+// defaultRetentionInterval is used when retention.interval is not configured.
+const defaultRetentionInterval = time.Hour
+
+func retentionOf(c *Config) Retention {
+	if c == nil || c.Retention == nil {
+		return Retention{}
+	}
+	return Retention{
+		SessionRequests: c.Retention.SessionRequests.Duration,
+		Events:          c.Retention.Events.Duration,
+		Crashes:         c.Retention.Crashes.Duration,
+	}
+}
+
+// retentionJob removes the data older than the retention configured, or
+// sets the storage up to expire it. It runs even when nothing has a
+// retention, so that the TTL indexes of a retention removed are dropped.
+var retentionJob = &Job{
+	Name: "retention",
+	Interval: func(c *Config) time.Duration {
+		if c != nil && c.Retention != nil && c.Retention.Interval.Duration > 0 {
+			return c.Retention.Interval.Duration
+		}
+		return defaultRetentionInterval
+	},
+	Run: func(c *Context) (int, error) {
+		return c.Store.ApplyRetention(retentionOf(c.Config), time.Now())
+	},
+}
@@ -0,0 +1,280 @@
+package tracker
+
+import (
+	"fmt"
+	"labix.org/v2/mgo"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRollupInterval = 10 * time.Minute
+	defaultRollupBackfill = 90 * 24 * time.Hour
+	// maxHourlyWindow bounds the hourly stats of a single request.
+	maxHourlyWindow = 31 * 24 * time.Hour
+)
+
+// periodStart returns the start of the period, an hour or a UTC day, of t.
+func periodStart(period string, t time.Time) time.Time {
+	if period == RollupHour {
+		return t.UTC().Truncate(time.Hour)
+	}
+	return utcDay(t)
+}
+
+// periodNext returns the start of the period after the one starting at start.
+func periodNext(period string, start time.Time) time.Time {
+	if period == RollupHour {
+		return start.Add(time.Hour)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+type rollupsByStart []*Rollup
+
+func (s rollupsByStart) Len() int           { return len(s) }
+func (s rollupsByStart) Less(i, j int) bool { return s[i].Start.Before(s[j].Start) }
+func (s rollupsByStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// sortedRollups returns the rollups of a map, ordered by start.
+func sortedRollups(m map[time.Time]*Rollup) []*Rollup {
+	rollups := make([]*Rollup, 0, len(m))
+	for _, r := range m {
+		rollups = append(rollups, r)
+	}
+	sort.Sort(rollupsByStart(rollups))
+	return rollups
+}
+
+// fillRollups returns one rollup per period from from until to, taking
+// those of rollups, which are ordered by start, and empty ones for the
+// periods left out.
+func fillRollups(period string, from, to time.Time, rollups []*Rollup) []*Rollup {
+	filled := []*Rollup{}
+	for t := from; t.Before(to); t = periodNext(period, t) {
+		if len(rollups) > 0 && rollups[0].Start.Equal(t) {
+			filled = append(filled, rollups[0])
+			rollups = rollups[1:]
+			continue
+		}
+		filled = append(filled, NewRollup(period, t))
+	}
+	return filled
+}
+
+// rollupJob stores the rollups of the hours and days that ended since its
+// last run, so that stats over long windows do not go through every session.
+var rollupJob = &Job{
+	Name: "rollups",
+	Interval: func(c *Config) time.Duration {
+		if c != nil && c.Rollups != nil && c.Rollups.Interval.Duration > 0 {
+			return c.Rollups.Interval.Duration
+		}
+		return defaultRollupInterval
+	},
+	Run: func(c *Context) (int, error) {
+		return updateRollups(c.Store, c.Config, time.Now())
+	},
+}
+
+// updateRollups computes and stores the rollups of the periods that ended
+// by now and were not stored yet, going back rollups.backfill at most,
+// returning how many it stored.
+func updateRollups(store Storage, c *Config, now time.Time) (int, error) {
+	backfill := defaultRollupBackfill
+	if c != nil && c.Rollups != nil && c.Rollups.Backfill.Duration > 0 {
+		backfill = c.Rollups.Backfill.Duration
+	}
+	n := 0
+	for _, period := range []string{RollupHour, RollupDay} {
+		from := periodStart(period, now.Add(-backfill))
+		latest, err := store.LatestRollup(period)
+		switch {
+		case err == nil:
+			if next := periodNext(period, latest.Start); next.After(from) {
+				from = next
+			}
+		case err != mgo.ErrNotFound:
+			return n, err
+		}
+		to := periodStart(period, now)
+		if !from.Before(to) {
+			continue
+		}
+		rollups, err := store.AggregateRollups(period, from, to)
+		if err != nil {
+			return n, err
+		}
+		// Periods are stored in order, so that a failed run resumes after
+		// the last period stored.
+		for _, r := range fillRollups(period, from, to, rollups) {
+			r.ComputedAt = now.UTC()
+			if err := store.UpsertRollup(r); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// sessionRollups returns one rollup per period from from until to, which
+// start periods, reading the stored rollups and computing from the sessions
+// those of the periods after the first one not stored, like the current
+// period. It reports whether it computed any.
+func sessionRollups(store Storage, period string, from, to time.Time) ([]*Rollup, bool, error) {
+	stored, err := store.Rollups(period, from, to)
+	if err != nil {
+		return nil, false, err
+	}
+	n, liveFrom := 0, from
+	for n < len(stored) && stored[n].Start.Equal(liveFrom) {
+		liveFrom = periodNext(period, liveFrom)
+		n++
+	}
+	rollups := stored[:n]
+	if !liveFrom.Before(to) {
+		return rollups, false, nil
+	}
+	live, err := store.AggregateRollups(period, liveFrom, to)
+	if err != nil {
+		return nil, false, err
+	}
+	return append(rollups, fillRollups(period, liveFrom, to, live)...), true, nil
+}
+
+// freshRollups is like sessionRollups, also returning the time the rollups
+// are up to date as of. Rollups read only from storage are as fresh as the
+// newest of them.
+func freshRollups(store Storage, period string, from, to time.Time) ([]*Rollup, time.Time, error) {
+	var asOf time.Time
+	rollups, live, err := sessionRollups(store, period, from, to)
+	switch {
+	case err == nil && live:
+		asOf, err = store.NewestActivity()
+	case err == nil:
+		for _, x := range rollups {
+			if x.ComputedAt.After(asOf) {
+				asOf = x.ComputedAt
+			}
+		}
+	}
+	return rollups, asOf, err
+}
+
+// sessionStats are the rollups of the sessions over a time window.
+type sessionStats struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Period  string    `json:"period"`
+	Rollups []*Rollup `json:"rollups"`
+}
+
+// SessionStatsHandler reports, per hour or UTC day, how many sessions were
+// started and closed, by how many jids and machines.
+func SessionStatsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	period := r.FormValue("period")
+	max := maxStatsWindow
+	switch period {
+	case "":
+		period = RollupDay
+	case RollupDay:
+	case RollupHour:
+		max = maxHourlyWindow
+	default:
+		writeError(w, r, &APIError{"invalid_value", "period", "",
+			fmt.Sprintf("Invalid period %s, expected hour or day", period)}, http.StatusBadRequest)
+		return
+	}
+	win, errs := parseWindow(r, now, defaultStatsWindow, max)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if checkModified(w, r, c) {
+		return
+	}
+	// The window is widened to whole periods.
+	from, to := periodStart(period, win.From), periodStart(period, win.To)
+	if to.Before(win.To) {
+		to = periodNext(period, to)
+	}
+	rollups, asOf, err := freshRollups(c.Store, period, from, to)
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute session stats"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	writeStats(w, &sessionStats{from, to, period, rollups}, freshness(asOf, c.Config, now))
+}
+
+// defaultUsageWindow is the time window of the usage by hour when from is
+// not given, whole weeks so that every weekday counts as many times.
+const defaultUsageWindow = 4 * 7 * 24 * time.Hour
+
+// weekdayUsage counts the sessions started in each hour of a weekday.
+type weekdayUsage struct {
+	Weekday string  `json:"weekday"`
+	Hours   [24]int `json:"hours"`
+}
+
+// usageByHour counts the sessions started over a time window by weekday,
+// from Monday, and hour in a time zone.
+type usageByHour struct {
+	From     time.Time       `json:"from"`
+	To       time.Time       `json:"to"`
+	TZ       string          `json:"tz"`
+	Weekdays []*weekdayUsage `json:"weekdays"`
+}
+
+// newUsageByHour adds up hourly rollups by the weekday and hour in loc at
+// which they start. Hours of time zones offset from UTC by a fraction of an
+// hour count as the local hour the UTC hour starts in.
+func newUsageByHour(from, to time.Time, loc *time.Location, rollups []*Rollup) *usageByHour {
+	u := &usageByHour{From: from, To: to, TZ: loc.String()}
+	for i := 0; i < 7; i++ {
+		u.Weekdays = append(u.Weekdays, &weekdayUsage{Weekday: strings.ToLower(time.Weekday((i + 1) % 7).String())})
+	}
+	for _, x := range rollups {
+		t := x.Start.In(loc)
+		u.Weekdays[(int(t.Weekday())+6)%7].Hours[t.Hour()] += x.SessionsStarted
+	}
+	return u
+}
+
+// UsageByHourHandler reports how many sessions were started in each hour of
+// each weekday, in the tz param or stats.timezone, so that maintenance can be
+// scheduled when nearly nobody is online.
+func UsageByHourHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	zone := time.UTC
+	if c.Config != nil && c.Config.Stats != nil && c.Config.Stats.Timezone != "" {
+		// Validated with the configuration.
+		if l, err := time.LoadLocation(c.Config.Stats.Timezone); err == nil {
+			zone = l
+		}
+	}
+	win, loc, errs := parseWindowIn(r, now, defaultUsageWindow, maxStatsWindow, zone)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if checkModified(w, r, c) {
+		return
+	}
+	// The window is widened to whole hours.
+	from, to := periodStart(RollupHour, win.From), periodStart(RollupHour, win.To)
+	if to.Before(win.To) {
+		to = periodNext(RollupHour, to)
+	}
+	rollups, asOf, err := freshRollups(c.Store, RollupHour, from, to)
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute usage by hour"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	writeStats(w, newUsageByHour(from, to, loc, rollups), freshness(asOf, c.Config, now))
+}
@@ -0,0 +1,307 @@
+package tracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type rollupsTest struct {
+	store *MemoryStore
+	now   time.Time
+}
+
+func newRollupsTest(t *testing.T) *rollupsTest {
+	s := &rollupsTest{}
+	s.store = NewMemoryStore()
+	s.now = time.Date(2014, 5, 3, 10, 30, 0, 0, time.UTC)
+	at := func(d, h int) time.Time { return time.Date(2014, 5, d, h, 15, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		jid, machineId      string
+		createdAt, closedAt time.Time
+	}{
+		{"a@example.com", "machine-1", at(1, 9), at(1, 11)},
+		{"a@example.com", "machine-2", at(1, 9), time.Time{}},
+		{"b@example.com", "machine-1", at(2, 23), at(3, 9)},
+		{"b@example.com", "machine-1", at(3, 10), time.Time{}},
+	} {
+		session := NewSession(tc.jid, tc.machineId, "1.0", nil)
+		session.CreatedAt, session.ClosedAt = tc.createdAt, tc.closedAt
+		if err := s.store.InsertSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestUpdateRollups(t *testing.T) {
+	s := newRollupsTest(t)
+	conf := &Config{Rollups: &RollupsConfig{Backfill: Duration{3 * 24 * time.Hour}}}
+	n, err := updateRollups(s.store, conf, s.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// From 2014-04-30T10:00 until 2014-05-03T10:00, and 3 days until 2014-05-03.
+	if got, want := n, 72+3; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+	days, err := s.store.Rollups(RollupDay, time.Time{}, s.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(days), 3; got != want {
+		t.Fatalf("len(days) = %d, want %d", got, want)
+	}
+	if got, want := days[1].Start, time.Date(2014, 5, 1, 0, 0, 0, 0, time.UTC); got != want {
+		t.Errorf("days[1].Start = %v, want %v", got, want)
+	}
+	if got, want := days[1].SessionsStarted, 2; got != want {
+		t.Errorf("days[1].SessionsStarted = %v, want %v", got, want)
+	}
+	if got, want := days[1].SessionsClosed, 1; got != want {
+		t.Errorf("days[1].SessionsClosed = %v, want %v", got, want)
+	}
+	if got, want := days[1].UniqueJIDs, 1; got != want {
+		t.Errorf("days[1].UniqueJIDs = %v, want %v", got, want)
+	}
+	if got, want := days[1].UniqueMachines, 2; got != want {
+		t.Errorf("days[1].UniqueMachines = %v, want %v", got, want)
+	}
+	if got, want := days[1].ComputedAt, s.now; got != want {
+		t.Errorf("days[1].ComputedAt = %v, want %v", got, want)
+	}
+	hour, err := s.store.Rollups(RollupHour, time.Date(2014, 5, 3, 9, 0, 0, 0, time.UTC), s.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(hour), 1; got != want {
+		t.Fatalf("len(hour) = %d, want %d", got, want)
+	}
+	if got, want := hour[0].SessionsClosed, 1; got != want {
+		t.Errorf("hour[0].SessionsClosed = %v, want %v", got, want)
+	}
+
+	// The next run only stores the periods that ended since.
+	n, err = updateRollups(s.store, conf, s.now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("n = %v, want %v", got, want)
+	}
+}
+
+func (s *rollupsTest) stats(t *testing.T, query string) *sessionStats {
+	r, _ := http.NewRequest("GET", "/1/stats/sessions?"+query, nil)
+	w := httptest.NewRecorder()
+	SessionStatsHandler(w, r, &Context{Store: s.store})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("%s: w.Code = %v, want %v", w.Body.String(), got, want)
+	}
+	var body struct {
+		Data *sessionStats
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Data
+}
+
+func TestSessionStats(t *testing.T) {
+	s := newRollupsTest(t)
+	// Without rollups, everything is computed from the sessions.
+	stats := s.stats(t, "from=2014-05-01&to=2014-05-03T10:30:00Z")
+	if got, want := stats.To.Equal(time.Date(2014, 5, 4, 0, 0, 0, 0, time.UTC)), true; got != want {
+		t.Errorf("stats.To.Equal(time.Date(2014, 5, 4, 0, 0, 0, 0, time.UTC)) = %v, want %v", got, want)
+	}
+	if got, want := len(stats.Rollups), 3; got != want {
+		t.Fatalf("len(stats.Rollups) = %d, want %d", got, want)
+	}
+	started := []int{}
+	for _, r := range stats.Rollups {
+		started = append(started, r.SessionsStarted)
+	}
+	if got, want := started, ([]int{2, 1, 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("started = %v, want %v", got, want)
+	}
+
+	// Stored rollups are used, the periods after them are computed.
+	stored := NewRollup(RollupDay, time.Date(2014, 5, 1, 0, 0, 0, 0, time.UTC))
+	stored.SessionsStarted = 42
+	if err := s.store.UpsertRollup(stored); err != nil {
+		t.Fatal(err)
+	}
+	stats = s.stats(t, "from=2014-05-01&to=2014-05-03T10:30:00Z")
+	if got, want := len(stats.Rollups), 3; got != want {
+		t.Fatalf("len(stats.Rollups) = %d, want %d", got, want)
+	}
+	if got, want := stats.Rollups[0].SessionsStarted, 42; got != want {
+		t.Errorf("stats.Rollups[0].SessionsStarted = %v, want %v", got, want)
+	}
+	if got, want := stats.Rollups[2].SessionsStarted, 1; got != want {
+		t.Errorf("stats.Rollups[2].SessionsStarted = %v, want %v", got, want)
+	}
+
+	stats = s.stats(t, "period=hour&from=2014-05-03T09:00:00Z&to=2014-05-03T11:00:00Z")
+	if got, want := len(stats.Rollups), 2; got != want {
+		t.Fatalf("len(stats.Rollups) = %d, want %d", got, want)
+	}
+	if got, want := stats.Rollups[1].UniqueJIDs, 1; got != want {
+		t.Errorf("stats.Rollups[1].UniqueJIDs = %v, want %v", got, want)
+	}
+
+	r, _ := http.NewRequest("GET", "/1/stats/sessions?period=week", nil)
+	w := httptest.NewRecorder()
+	SessionStatsHandler(w, r, &Context{Store: s.store})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	r, _ = http.NewRequest("GET", "/1/stats/sessions?period=hour&from=60d", nil)
+	w = httptest.NewRecorder()
+	SessionStatsHandler(w, r, &Context{Store: s.store})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+}
+
+func TestUsageByHour(t *testing.T) {
+	s := newRollupsTest(t)
+	r, _ := http.NewRequest("GET", "/1/stats/usage-by-hour?from=2014-05-01&to=2014-05-04&tz=America/Sao_Paulo", nil)
+	w := httptest.NewRecorder()
+	UsageByHourHandler(w, r, &Context{Store: s.store})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("%s: w.Code = %v, want %v", w.Body.String(), got, want)
+	}
+	var body struct {
+		Data *usageByHour
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	u := body.Data
+	if got, want := u.TZ, "America/Sao_Paulo"; got != want {
+		t.Errorf("u.TZ = %v, want %v", got, want)
+	}
+	if got, want := u.From.Equal(time.Date(2014, 5, 1, 3, 0, 0, 0, time.UTC)), true; got != want {
+		t.Errorf("u.From.Equal(time.Date(2014, 5, 1, 3, 0, 0, 0, time.UTC)) = %v, want %v", got, want)
+	}
+	if got, want := len(u.Weekdays), 7; got != want {
+		t.Fatalf("len(u.Weekdays) = %d, want %d", got, want)
+	}
+	if got, want := u.Weekdays[0].Weekday, "monday"; got != want {
+		t.Errorf("u.Weekdays[0].Weekday = %v, want %v", got, want)
+	}
+	if got, want := u.Weekdays[6].Weekday, "sunday"; got != want {
+		t.Errorf("u.Weekdays[6].Weekday = %v, want %v", got, want)
+	}
+	// In UTC-3, on Thursday at 9:15, Friday at 23:15 and Saturday at 10:15 UTC.
+	if got, want := u.Weekdays[3].Hours[6], 2; got != want {
+		t.Errorf("u.Weekdays[3].Hours[6] = %v, want %v", got, want)
+	}
+	if got, want := u.Weekdays[4].Hours[20], 1; got != want {
+		t.Errorf("u.Weekdays[4].Hours[20] = %v, want %v", got, want)
+	}
+	if got, want := u.Weekdays[5].Hours[7], 1; got != want {
+		t.Errorf("u.Weekdays[5].Hours[7] = %v, want %v", got, want)
+	}
+	total := 0
+	for _, d := range u.Weekdays {
+		for _, n := range d.Hours {
+			total += n
+		}
+	}
+	if got, want := total, 4; got != want {
+		t.Errorf("total = %v, want %v", got, want)
+	}
+
+	// stats.timezone is the default time zone.
+	r, _ = http.NewRequest("GET", "/1/stats/usage-by-hour?from=2014-05-01&to=2014-05-04", nil)
+	w = httptest.NewRecorder()
+	UsageByHourHandler(w, r, &Context{Store: s.store, Config: &Config{Stats: &StatsConfig{Timezone: "Asia/Tokyo"}}})
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Data.TZ, "Asia/Tokyo"; got != want {
+		t.Errorf("body.Data.TZ = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Weekdays[3].Hours[18], 2; got != want {
+		t.Errorf("body.Data.Weekdays[3].Hours[18] = %v, want %v", got, want)
+	}
+}
+
+func TestRollupsConcurrency(t *testing.T) {
+	s := newRollupsTest(t)
+	at := func(d, h, m int) time.Time { return time.Date(2014, 5, d, h, m, 0, 0, time.UTC) }
+	for _, now := range []time.Time{at(2, 9, 0), at(2, 9, 30), at(3, 10, 0)} {
+		n, err := sampleConcurrency(s.store, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := n, 1; got != want {
+			t.Errorf("n = %v, want %v", got, want)
+		}
+	}
+	// The peak of an hour stays as sessions close.
+	session := NewSession("c@example.com", "machine-3", "1.0", nil)
+	if err := s.store.InsertSession(session); err != nil {
+		t.Fatal(err)
+	}
+	_, err := sampleConcurrency(s.store, at(2, 9, 45))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.CloseSession(session); err != nil {
+		t.Fatal(err)
+	}
+	_, err = sampleConcurrency(s.store, at(2, 9, 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := http.NewRequest("GET", "/1/stats/concurrency?from=2014-05-01&to=2014-05-04", nil)
+	w := httptest.NewRecorder()
+	ConcurrencyStatsHandler(w, r, &Context{Store: s.store})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("%s: w.Code = %v, want %v", w.Body.String(), got, want)
+	}
+	var body struct {
+		Data *concurrencyStats
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	stats := body.Data
+	if got, want := stats.Current, 2; got != want {
+		t.Errorf("stats.Current = %v, want %v", got, want)
+	}
+	if got, want := stats.Peak, 3; got != want {
+		t.Errorf("stats.Peak = %v, want %v", got, want)
+	}
+	if got, want := len(stats.Hours), 2; got != want {
+		t.Fatalf("len(stats.Hours) = %d, want %d", got, want)
+	}
+	if got, want := stats.Hours[0].Start.Equal(at(2, 9, 0)), true; got != want {
+		t.Errorf("stats.Hours[0].Start.Equal(at(2, 9, 0)) = %v, want %v", got, want)
+	}
+	if got, want := stats.Hours[0].Peak, 3; got != want {
+		t.Errorf("stats.Hours[0].Peak = %v, want %v", got, want)
+	}
+	if got, want := stats.Hours[1].Peak, 2; got != want {
+		t.Errorf("stats.Hours[1].Peak = %v, want %v", got, want)
+	}
+	if got, want := len(stats.Days), 2; got != want {
+		t.Fatalf("len(stats.Days) = %d, want %d", got, want)
+	}
+	if got, want := stats.Days[1].Start.Equal(at(3, 0, 0)), true; got != want {
+		t.Errorf("stats.Days[1].Start.Equal(at(3, 0, 0)) = %v, want %v", got, want)
+	}
+	if got, want := stats.Days[1].Peak, 2; got != want {
+		t.Errorf("stats.Days[1].Peak = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("X-Data-As-Of"), "Sat, 03 May 2014 10:00:00 GMT"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
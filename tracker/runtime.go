@@ -0,0 +1,182 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RuntimeStats counts what happened since the process started.
+// It is safe for concurrent use, and every endpoint reporting on the
+// process reads from the same one so that their numbers agree.
+type RuntimeStats struct {
+	// The 64-bit fields come first to be aligned for atomic access on 32-bit platforms.
+	requests int64
+	errors   int64
+	panics   int64
+	// lastStorageError and lastStorageOK are Unix times in nanoseconds, 0 if never.
+	lastStorageError int64
+	lastStorageOK    int64
+	started          time.Time
+
+	storageMu    sync.Mutex
+	storageCalls map[string]*storageCallStats
+}
+
+// storageCallStats measures the calls to a Storage method.
+type storageCallStats struct {
+	calls, errors int64
+	total, max    time.Duration
+}
+
+func NewRuntimeStats() *RuntimeStats {
+	return &RuntimeStats{started: time.Now(), storageCalls: make(map[string]*storageCallStats)}
+}
+
+// runtimeStats counts what happened in this process.
+var runtimeStats = NewRuntimeStats()
+
+// ObserveRequest records a served request; 5xx status codes count as errors.
+func (rs *RuntimeStats) ObserveRequest(status int) {
+	atomic.AddInt64(&rs.requests, 1)
+	if status >= 500 {
+		atomic.AddInt64(&rs.errors, 1)
+	}
+}
+
+// ObservePanic records a request whose handler panicked.
+func (rs *RuntimeStats) ObservePanic() {
+	atomic.AddInt64(&rs.panics, 1)
+}
+
+// ObserveStorage records the outcome of a storage call.
+// Not finding a document or a duplicate key are answers, not storage failures.
+func (rs *RuntimeStats) ObserveStorage(err error) {
+	now := time.Now().UnixNano()
+	if err == nil || err == mgo.ErrNotFound || mgo.IsDup(err) {
+		atomic.StoreInt64(&rs.lastStorageOK, now)
+		return
+	}
+	atomic.StoreInt64(&rs.lastStorageError, now)
+}
+
+// ObserveStorageCall records the duration and outcome of a call to the
+// Storage method op, as well as with ObserveStorage.
+func (rs *RuntimeStats) ObserveStorageCall(op string, d time.Duration, err error) {
+	rs.ObserveStorage(err)
+	rs.storageMu.Lock()
+	defer rs.storageMu.Unlock()
+	st := rs.storageCalls[op]
+	if st == nil {
+		st = &storageCallStats{}
+		rs.storageCalls[op] = st
+	}
+	st.calls++
+	if err != nil && err != mgo.ErrNotFound && !mgo.IsDup(err) {
+		st.errors++
+	}
+	st.total += d
+	if d > st.max {
+		st.max = d
+	}
+}
+
+// storageDownSince returns since when storage calls have been failing,
+// which is the last time one succeeded, if the last one failed.
+func (rs *RuntimeStats) storageDownSince() (time.Time, bool) {
+	failed, ok := atomic.LoadInt64(&rs.lastStorageError), atomic.LoadInt64(&rs.lastStorageOK)
+	switch {
+	case failed == 0 || failed <= ok:
+		return time.Time{}, false
+	case ok == 0:
+		return rs.started, true
+	}
+	return time.Unix(0, ok), true
+}
+
+// RuntimeSnapshot is a consistent reading of RuntimeStats.
+type RuntimeSnapshot struct {
+	StartedAt        time.Time  `json:"started_at"`
+	Uptime           float64    `json:"uptime_seconds"`
+	Requests         int64      `json:"requests"`
+	Errors           int64      `json:"errors"`
+	Panics           int64      `json:"panics"`
+	LastStorageError *time.Time `json:"last_storage_error"`
+	// StorageFailing is set when the last storage call failed.
+	StorageFailing bool `json:"storage_failing"`
+	// WriteQueue is set when writes are queued during storage outages.
+	WriteQueue *WriteQueueStats `json:"write_queue,omitempty"`
+	// StorageCalls measures the storage calls of the client API, by method.
+	StorageCalls map[string]*StorageCallSnapshot `json:"storage_calls,omitempty"`
+}
+
+// StorageCallSnapshot is a reading of the calls to a Storage method.
+type StorageCallSnapshot struct {
+	Calls  int64   `json:"calls"`
+	Errors int64   `json:"errors"`
+	MeanMs float64 `json:"mean_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+func (rs *RuntimeStats) Snapshot() *RuntimeSnapshot {
+	s := &RuntimeSnapshot{
+		StartedAt: rs.started,
+		Uptime:    time.Since(rs.started).Seconds(),
+		Requests:  atomic.LoadInt64(&rs.requests),
+		Errors:    atomic.LoadInt64(&rs.errors),
+		Panics:    atomic.LoadInt64(&rs.panics),
+	}
+	if e := atomic.LoadInt64(&rs.lastStorageError); e != 0 {
+		t := time.Unix(0, e)
+		s.LastStorageError = &t
+		s.StorageFailing = e > atomic.LoadInt64(&rs.lastStorageOK)
+	}
+	rs.storageMu.Lock()
+	defer rs.storageMu.Unlock()
+	if len(rs.storageCalls) > 0 {
+		s.StorageCalls = make(map[string]*StorageCallSnapshot)
+	}
+	for op, st := range rs.storageCalls {
+		s.StorageCalls[op] = &StorageCallSnapshot{
+			Calls:  st.calls,
+			Errors: st.errors,
+			MeanMs: float64(st.total/time.Duration(st.calls)) / float64(time.Millisecond),
+			MaxMs:  float64(st.max) / float64(time.Millisecond),
+		}
+	}
+	return s
+}
+
+// UptimeHandler tells for how long the process has been running.
+func UptimeHandler(w http.ResponseWriter, r *http.Request) {
+	d := time.Since(runtimeStats.started)
+	var h, m, s int = int(d.Hours()), int(d.Minutes()), int(d.Seconds())
+	fmt.Fprintf(w, "API uptime: %dd%02dh%02dm%02ds\n", h/24, h%24, m%60, s%60)
+}
+
+// StatusHandler reports the runtime stats of the process as JSON, with the
+// write queue of the server of the request, if any.
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	s := runtimeStats.Snapshot()
+	if srv := requestServer(r); srv != nil && srv.writeQueue != nil {
+		s.WriteQueue = srv.writeQueue.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(s)
+}
+
+// HealthzHandler responds 200 unless the last storage call failed,
+// for load balancers and process supervisors.
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	s := runtimeStats.Snapshot()
+	if s.StorageFailing {
+		http.Error(w, fmt.Sprintf("storage failing since %s", s.LastStorageError.UTC().Format(time.RFC3339)),
+			http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
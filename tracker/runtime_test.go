@@ -0,0 +1,187 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+type runtimeTest struct {
+	saved *RuntimeStats
+}
+
+func newRuntimeTest(t *testing.T) *runtimeTest {
+	s := &runtimeTest{}
+	s.saved = runtimeStats
+	runtimeStats = NewRuntimeStats()
+	t.Cleanup(func() {
+		runtimeStats = s.saved
+	})
+	return s
+}
+
+func TestRequestsThroughMetricsHandler(t *testing.T) {
+	newRuntimeTest(t)
+	h := MetricsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			http.Error(w, "Failed", http.StatusInternalServerError)
+		}
+	}), NewMetrics())
+	for _, path := range []string{"/", "/fail", "/"} {
+		r, _ := http.NewRequest("GET", path, nil)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	snap := runtimeStats.Snapshot()
+	if got, want := snap.Requests, int64(3); got != want {
+		t.Errorf("snap.Requests = %v, want %v", got, want)
+	}
+	if got, want := snap.Errors, int64(1); got != want {
+		t.Errorf("snap.Errors = %v, want %v", got, want)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	newRuntimeTest(t)
+	healthz := func() int {
+		w := httptest.NewRecorder()
+		HealthzHandler(w, nil)
+		return w.Code
+	}
+	if got, want := healthz(), http.StatusOK; got != want {
+		t.Errorf("healthz() = %v, want %v", got, want)
+	}
+	runtimeStats.ObserveStorage(errors.New("no reachable servers"))
+	if got, want := healthz(), http.StatusServiceUnavailable; got != want {
+		t.Errorf("healthz() = %v, want %v", got, want)
+	}
+	if runtimeStats.Snapshot().LastStorageError == nil {
+		t.Error("runtimeStats.Snapshot().LastStorageError is nil")
+	}
+	// Not found is an answer from storage, it is back
+	runtimeStats.ObserveStorage(mgo.ErrNotFound)
+	if got, want := healthz(), http.StatusOK; got != want {
+		t.Errorf("healthz() = %v, want %v", got, want)
+	}
+	if runtimeStats.Snapshot().LastStorageError == nil {
+		t.Error("runtimeStats.Snapshot().LastStorageError is nil")
+	}
+}
+
+// slowStore takes a while to insert sessions.
+type slowStore struct {
+	*MemoryStore
+}
+
+func (s *slowStore) InsertSession(x *Session) error {
+	time.Sleep(5 * time.Millisecond)
+	return errors.New("no reachable servers")
+}
+
+func TestStorageCalls(t *testing.T) {
+	newRuntimeTest(t)
+	var buf bytes.Buffer
+	logOutput.sink = &writerSink{&buf}
+	defer func() { logOutput.sink = &writerSink{os.Stderr} }()
+	conf := &Config{Log: &LogConfig{SlowStorage: Duration{time.Millisecond}}}
+	store := newMeteredStore(&slowStore{NewMemoryStore()}, NewMetrics(), conf, &Logger{"4f2a"})
+	if store.InsertSession(NewSession("user@server.org", "machine", "1.0", nil)) == nil {
+		t.Error("got nil")
+	}
+	_, err := store.FindSession(NewSessionId())
+	if got, want := err, mgo.ErrNotFound; got != want {
+		t.Errorf("err = %v, want %v", got, want)
+	}
+
+	calls := runtimeStats.Snapshot().StorageCalls
+	if got, want := len(calls), 2; got != want {
+		t.Fatalf("len(calls) = %d, want %d", got, want)
+	}
+	if got, want := calls["InsertSession"].Calls, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := calls["InsertSession"].Errors, int64(1); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := calls["InsertSession"].MaxMs >= 5, true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := calls["FindSession"].Errors, int64(0); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, pattern := buf.String(), `\S+ WARN request_id=4f2a \[storage\] slow InsertSession took \S+ and failed: no reachable servers\n`; !fullMatch(pattern, got) {
+		t.Errorf("buf.String() = %q, want a match of %q", got, pattern)
+	}
+}
+
+func TestTrackerStatus(t *testing.T) {
+	newRuntimeTest(t)
+	server := &Server{status: &statusCache{}}
+	store := NewMemoryStore()
+	if err := store.InsertSession(NewSession("user@server.org", "machine", "1.0", nil)); err != nil {
+		t.Fatal(err)
+	}
+	status := func(server *Server) (st trackerStatus) {
+		w := httptest.NewRecorder()
+		ctx := &Context{Store: newMeteredStore(store, NewMetrics(), nil, &Logger{}), Log: &Logger{}, Server: server}
+		TrackerStatusHandler(w, nil, ctx)
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("w.Code = %v, want %v", got, want)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+	st := status(server)
+	if got, want := st.Version, "0.0.0-dev"; got != want {
+		t.Errorf("st.Version = %v, want %v", got, want)
+	}
+	if got, want := st.Storage, "memory"; got != want {
+		t.Errorf("st.Storage = %v, want %v", got, want)
+	}
+	if got, want := st.OpenSessions, 1; got != want {
+		t.Errorf("st.OpenSessions = %v, want %v", got, want)
+	}
+	if got := st.LastReaperRun; got != nil {
+		t.Errorf("st.LastReaperRun = %v, want nil", got)
+	}
+	// What was read from storage is reused for a while.
+	if err := store.InsertSession(NewSession("user@server.org", "machine", "1.0", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.InsertJobRun(&JobRun{Id: bson.NewObjectId(), Job: "reaper", Items: 3}); err != nil {
+		t.Fatal(err)
+	}
+	again := status(server)
+	if got, want := again.OpenSessions, 1; got != want {
+		t.Errorf("again.OpenSessions = %v, want %v", got, want)
+	}
+	if got, want := again.AsOf.Equal(st.AsOf), true; got != want {
+		t.Errorf("again.AsOf.Equal(st.AsOf) = %v, want %v", got, want)
+	}
+	if got, want := again.Uptime >= st.Uptime, true; got != want {
+		t.Errorf("again.Uptime >= st.Uptime = %v, want %v", got, want)
+	}
+	// By each server.
+	if got, want := status(&Server{status: &statusCache{}}).OpenSessions, 2; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	server.status.status.AsOf = server.status.status.AsOf.Add(-statusCacheTTL)
+	st = status(server)
+	if got, want := st.OpenSessions, 2; got != want {
+		t.Errorf("st.OpenSessions = %v, want %v", got, want)
+	}
+	if st.LastReaperRun == nil {
+		t.Fatal("st.LastReaperRun is nil")
+	}
+	if got, want := st.LastReaperRun.Items, 3; got != want {
+		t.Errorf("st.LastReaperRun.Items = %v, want %v", got, want)
+	}
+}
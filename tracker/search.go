@@ -0,0 +1,215 @@
+package tracker
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SessionQuery selects the sessions found by a search. Empty fields match
+// any session.
+type SessionQuery struct {
+	// JID may have * wildcards, as in *@server.org.
+	JID            string
+	MachineId      string
+	XMPPVOXVersion string
+	ClosedReason   string
+	Project        string
+	Tag            string
+	// Open, if not nil, selects the open or the closed sessions.
+	Open    *bool
+	Created TimeWindow
+	// Closed only matches closed sessions, so it cannot be combined with
+	// an Open of true.
+	Closed TimeWindow
+}
+
+// wildcardPattern returns the anchored regular expression of a value with
+// * wildcards, every other character matching itself.
+func wildcardPattern(s string) string {
+	parts := strings.Split(s, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return "^" + strings.Join(parts, ".*") + "$"
+}
+
+// Matches reports whether s is selected by the query, as MongoStore would.
+func (q *SessionQuery) Matches(s *Session) bool {
+	if q.JID != "" {
+		if ok, _ := regexp.MatchString(wildcardPattern(q.JID), s.JID); !ok {
+			return false
+		}
+	}
+	for _, f := range [][2]string{
+		{q.MachineId, s.MachineId},
+		{q.XMPPVOXVersion, s.XMPPVOXVersion},
+		{q.ClosedReason, s.ClosedReason},
+		{q.Project, s.Project},
+	} {
+		if f[0] != "" && f[0] != f[1] {
+			return false
+		}
+	}
+	if q.Tag != "" && !hasTag(s.Tags, q.Tag) {
+		return false
+	}
+	if q.Open != nil && *q.Open != s.ClosedAt.IsZero() {
+		return false
+	}
+	if !q.Created.Contains(s.CreatedAt) {
+		return false
+	}
+	if q.Closed != (TimeWindow{}) && (s.ClosedAt.IsZero() || !q.Closed.Contains(s.ClosedAt)) {
+		return false
+	}
+	return true
+}
+
+// searchFields are the fields a search query can name, with what they set.
+// Anything else is refused, so that searches cannot reach the request data
+// or the secret of sessions.
+var searchFields = map[string]func(q *SessionQuery, value string, now time.Time) error{
+	"jid":             func(q *SessionQuery, v string, now time.Time) error { q.JID = v; return nil },
+	"machine_id":      func(q *SessionQuery, v string, now time.Time) error { q.MachineId = v; return nil },
+	"xmppvox_version": func(q *SessionQuery, v string, now time.Time) error { q.XMPPVOXVersion = v; return nil },
+	"closed_reason":   func(q *SessionQuery, v string, now time.Time) error { q.ClosedReason = v; return nil },
+	"project":         func(q *SessionQuery, v string, now time.Time) error { q.Project = v; return nil },
+	"tag": func(q *SessionQuery, v string, now time.Time) error {
+		if !tagPattern.MatchString(v) {
+			return fmt.Errorf("invalid tag %s", v)
+		}
+		q.Tag = v
+		return nil
+	},
+	"status": func(q *SessionQuery, v string, now time.Time) error {
+		if v != "open" && v != "closed" {
+			return fmt.Errorf("invalid status %s, expected open or closed", v)
+		}
+		open := v == "open"
+		q.Open = &open
+		return nil
+	},
+	"created": func(q *SessionQuery, v string, now time.Time) (err error) {
+		q.Created, err = parseSearchRange(v, now)
+		return
+	},
+	"closed": func(q *SessionQuery, v string, now time.Time) (err error) {
+		q.Closed, err = parseSearchRange(v, now)
+		return
+	},
+}
+
+// parseSearchRange parses a range of times, as understood by
+// parseWindowTime in UTC, like 2014-05-01..2014-06-01, which includes the
+// start and excludes the end, 7d.. or ..2014-06-01. A lone date is that
+// whole day.
+func parseSearchRange(s string, now time.Time) (w TimeWindow, err error) {
+	i := strings.Index(s, "..")
+	if i < 0 {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return w, fmt.Errorf("invalid range %s, expected a date like 2014-05-01 or a range like 2014-05-01..2014-06-01", s)
+		}
+		return TimeWindow{t, t.AddDate(0, 0, 1)}, nil
+	}
+	from, to := s[:i], s[i+2:]
+	for _, end := range []struct {
+		s string
+		t *time.Time
+	}{{from, &w.From}, {to, &w.To}} {
+		if end.s == "" {
+			continue
+		}
+		t, ok := parseWindowTime(end.s, now, time.UTC)
+		if !ok {
+			return w, fmt.Errorf("invalid time %s, expected a date like 2014-05-01, a RFC 3339 time or a duration like 7d", end.s)
+		}
+		*end.t = t
+	}
+	if w == (TimeWindow{}) {
+		return w, fmt.Errorf("invalid range %s, expected a start or an end", s)
+	}
+	if !w.From.IsZero() && !w.To.IsZero() && !w.From.Before(w.To) {
+		return w, fmt.Errorf("invalid range %s, the start must be before the end", s)
+	}
+	return w, nil
+}
+
+// parseSessionQuery parses a search query of space separated field:value
+// terms, like
+//
+//	jid:*@server.org status:closed created:2014-05-01..2014-06-01
+//
+// which all have to match. Each field of searchFields can be named once.
+func parseSessionQuery(s string, now time.Time) (*SessionQuery, APIErrors) {
+	var errs APIErrors
+	invalid := func(key, format string, args ...interface{}) {
+		errs = append(errs, &APIError{"invalid_query", "q", key, fmt.Sprintf("Invalid query, "+format, args...)})
+	}
+	q := &SessionQuery{}
+	seen := make(map[string]bool)
+	for _, term := range strings.Fields(s) {
+		i := strings.Index(term, ":")
+		if i <= 0 || i == len(term)-1 {
+			invalid("", "expected field:value, got %s", term)
+			continue
+		}
+		field, value := term[:i], term[i+1:]
+		set, ok := searchFields[field]
+		switch {
+		case !ok:
+			invalid(field, "unknown field %s, expected one of %s", field, strings.Join(sortedSearchFields(), ", "))
+		case seen[field]:
+			invalid(field, "%s is given more than once", field)
+		default:
+			if err := set(q, value, now); err != nil {
+				invalid(field, "%s: %v", field, err)
+			}
+		}
+		seen[field] = true
+	}
+	if q.Open != nil && *q.Open && q.Closed != (TimeWindow{}) {
+		invalid("closed", "status:open excludes closed")
+	}
+	if errs == nil && len(seen) == 0 {
+		invalid("", "expected at least one field:value term")
+	}
+	return q, errs
+}
+
+func sortedSearchFields() []string {
+	names := make([]string, 0, len(searchFields))
+	for name := range searchFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SearchHandler lists the sessions matching the query in the q parameter,
+// newest first, paged like session histories, so that operators can find
+// sessions without access to the database.
+func SearchHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	page, limit, errs := historyPage(r)
+	q, queryErrs := parseSessionQuery(r.FormValue("q"), time.Now().UTC())
+	errs = append(errs, queryErrs...)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	// Hashed jids are only found by the jid they were hashed from.
+	if hashJIDs(c.Config) && q.JID != "" && !strings.Contains(q.JID, "*") {
+		q.JID = canonicalJID(c.Config, q.JID)
+	}
+	sessions, err := c.Store.SearchSessions(q, (page-1)*limit, limit+1)
+	if err != nil {
+		writeError(w, r, internalError("Failed to search sessions"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	writeHistory(w, r, sessions, page, limit)
+}
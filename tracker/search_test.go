@@ -0,0 +1,101 @@
+package tracker
+
+import (
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSessionQuery(t *testing.T) {
+	now := time.Date(2014, 5, 31, 12, 0, 0, 0, time.UTC)
+	day := func(m time.Month, d int) time.Time { return time.Date(2014, m, d, 0, 0, 0, 0, time.UTC) }
+	closed := false
+	for _, tc := range []struct {
+		query string
+		want  SessionQuery
+	}{
+		{"jid:*@server.org", SessionQuery{JID: "*@server.org"}},
+		{"machine_id:00:26:cc:18:be:14  xmppvox_version:1.0", SessionQuery{MachineId: "00:26:cc:18:be:14", XMPPVOXVersion: "1.0"}},
+		{"status:closed closed_reason:expired tag:beta-tester", SessionQuery{Open: &closed, ClosedReason: "expired", Tag: "beta-tester"}},
+		{"created:2014-05-01..2014-05-08", SessionQuery{Created: TimeWindow{day(5, 1), day(5, 8)}}},
+		{"created:2014-05-01", SessionQuery{Created: TimeWindow{day(5, 1), day(5, 2)}}},
+		{"created:7d.. closed:..2014-05-30", SessionQuery{
+			Created: TimeWindow{From: now.Add(-7 * 24 * time.Hour)},
+			Closed:  TimeWindow{To: day(5, 30)},
+		}},
+	} {
+		q, errs := parseSessionQuery(tc.query, now)
+		if got := errs; got != nil {
+			t.Errorf("%s: errs = %v, want nil", tc.query, got)
+		}
+		if got, want := *q, tc.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: *q = %v, want %v", tc.query, got, want)
+		}
+	}
+	for _, tc := range []struct{ query, key, message string }{
+		{"", "", "Invalid query, expected at least one field:value term"},
+		{"testuser@server.org", "", "Invalid query, expected field:value, got testuser@server.org"},
+		{"secret:abc", "secret", "Invalid query, unknown field secret, expected one of closed, closed_reason, created, jid, machine_id, project, status, tag, xmppvox_version"},
+		{"jid:a jid:b", "jid", "Invalid query, jid is given more than once"},
+		{"status:gone", "status", "Invalid query, status: invalid status gone, expected open or closed"},
+		{"created:2014-05-08..2014-05-01", "created", "Invalid query, created: invalid range 2014-05-08..2014-05-01, the start must be before the end"},
+		{"created:..", "created", "Invalid query, created: invalid range .., expected a start or an end"},
+		{"status:open closed:7d..", "closed", "Invalid query, status:open excludes closed"},
+	} {
+		_, errs := parseSessionQuery(tc.query, now)
+		if got, want := len(errs), 1; got != want {
+			t.Fatalf("%s: len(errs) = %d, want %d", tc.query, got, want)
+		}
+		if got, want := errs[0].Code, "invalid_query"; got != want {
+			t.Errorf("errs[0].Code = %v, want %v", got, want)
+		}
+		if got, want := errs[0].Key, tc.key; got != want {
+			t.Errorf("%s: errs[0].Key = %v, want %v", tc.query, got, want)
+		}
+		if got, want := errs[0].Message, tc.message; got != want {
+			t.Errorf("errs[0].Message = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSessionQueryMatches(t *testing.T) {
+	session := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	session.CreatedAt = time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	open, closed := true, false
+	for _, tc := range []struct {
+		q     SessionQuery
+		match bool
+	}{
+		{SessionQuery{JID: "*@server.org"}, true},
+		{SessionQuery{JID: "test*"}, true},
+		{SessionQuery{JID: "testuser@server.or"}, false},
+		{SessionQuery{JID: "*.org", MachineId: "OTHER"}, false},
+		{SessionQuery{Open: &open}, true},
+		{SessionQuery{Open: &closed}, false},
+		{SessionQuery{Closed: TimeWindow{To: time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)}}, false},
+		{SessionQuery{Created: TimeWindow{From: time.Date(2014, 5, 2, 0, 0, 0, 0, time.UTC)}}, false},
+	} {
+		if got, want := tc.q.Matches(session), tc.match; got != want {
+			t.Errorf("%+v: tc.q.Matches(session) = %v, want %v", tc.q, got, want)
+		}
+	}
+}
+
+func TestSessionSearch(t *testing.T) {
+	to := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+	open := true
+	if got, want := sessionSearch(&SessionQuery{JID: "*@server.org", Tag: "beta-tester", Closed: TimeWindow{To: to}}), (bson.M{
+		"jid":       bson.RegEx{Pattern: `^.*@server\.org$`},
+		"tags":      "beta-tester",
+		"closed_at": bson.M{"$gt": time.Time{}, "$lt": to},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := sessionSearch(&SessionQuery{JID: "testuser@server.org", Open: &open}), (bson.M{
+		"jid":       "testuser@server.org",
+		"closed_at": time.Time{},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,158 @@
+package tracker
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"strings"
+	"time"
+)
+
+// Statuses of a startup check.
+const (
+	CheckPass = "PASS"
+	CheckFail = "FAIL"
+	CheckSkip = "SKIP"
+)
+
+// maxClockSkew is how far the clocks of the server and MongoDB may drift apart.
+// Timestamps are taken from both, so larger skews distort session durations.
+const maxClockSkew = time.Minute
+
+// CheckResult is the outcome of one step of the startup self-check.
+type CheckResult struct {
+	Name   string
+	Status string
+	Detail string
+	// Fatal failures prevent the server from starting.
+	Fatal bool
+}
+
+// SelfCheck collects the results of the startup checks, to be reported together.
+type SelfCheck struct {
+	Results []*CheckResult
+}
+
+func (sc *SelfCheck) add(name, status, detail string, fatal bool) {
+	sc.Results = append(sc.Results, &CheckResult{name, status, detail, fatal})
+}
+
+func (sc *SelfCheck) Skip(name, reason string) { sc.add(name, CheckSkip, reason, false) }
+
+// Check records err as a failure, or a pass if it is nil.
+func (sc *SelfCheck) Check(name string, err error, fatal bool) {
+	if err != nil {
+		sc.add(name, CheckFail, err.Error(), fatal)
+		return
+	}
+	sc.add(name, CheckPass, "", false)
+}
+
+// Passed reports whether no check failed.
+func (sc *SelfCheck) Passed() bool {
+	for _, r := range sc.Results {
+		if r.Status == CheckFail {
+			return false
+		}
+	}
+	return true
+}
+
+// Fatal reports whether a check failed in a way that prevents the server from starting.
+func (sc *SelfCheck) Fatal() bool {
+	for _, r := range sc.Results {
+		if r.Status == CheckFail && r.Fatal {
+			return true
+		}
+	}
+	return false
+}
+
+func (sc *SelfCheck) Report(w io.Writer) {
+	fmt.Fprintln(w, "startup self-check:")
+	for _, r := range sc.Results {
+		line := fmt.Sprintf("  [%s] %s", r.Status, r.Name)
+		if r.Detail != "" {
+			line += ": " + strings.Replace(r.Detail, "\n", "\n         ", -1)
+		}
+		fmt.Fprintln(w, line)
+	}
+	switch {
+	case sc.Passed():
+		fmt.Fprintln(w, "all checks passed")
+	case sc.Fatal():
+		fmt.Fprintln(w, "fatal checks failed, cannot start")
+	default:
+		fmt.Fprintln(w, "some checks failed, starting anyway")
+	}
+}
+
+// checkIndexes reports the indexes of mongoIndexes missing in db.
+func checkIndexes(db *mgo.Database) error {
+	missing, err := (&MongoStore{db}).MissingIndexes()
+	if err != nil {
+		return err
+	}
+	if missing != nil {
+		return fmt.Errorf("missing %s; start once with --ensure-indexes", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// checkMigrations reports the migrations not applied to store yet.
+func checkMigrations(store Storage) error {
+	pending, err := pendingMigrations(store)
+	if err != nil {
+		return err
+	}
+	if pending != nil {
+		var names []string
+		for _, m := range pending {
+			names = append(names, fmt.Sprintf("%d %s", m.Version, m.Name))
+		}
+		return fmt.Errorf("pending %s; run elephant-tracker migrate", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// checkClock compares the local clock with the clock of the MongoDB server.
+func checkClock(s *mgo.Session) error {
+	local := time.Now()
+	if local.Year() < 2013 {
+		return fmt.Errorf("local clock is set to %s", local.Format(time.RFC3339))
+	}
+	if s == nil {
+		return nil
+	}
+	var status struct {
+		LocalTime time.Time `bson:"localTime"`
+	}
+	if err := s.Run(bson.M{"serverStatus": 1}, &status); err != nil {
+		return err
+	}
+	skew := status.LocalTime.Sub(local)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxClockSkew {
+		return fmt.Errorf("clocks of this server and MongoDB differ by %s, check NTP on both", skew)
+	}
+	return nil
+}
+
+// checkTempDir makes sure temporary files can be written.
+func checkTempDir() error {
+	f, err := ioutil.TempFile("", "elephant-tracker-check")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("ok"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,43 @@
+package tracker
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSelfCheckReport(t *testing.T) {
+	sc := &SelfCheck{}
+	sc.Check("config", nil, true)
+	sc.Skip("migrations", "no migrations defined")
+	if got, want := sc.Passed(), true; got != want {
+		t.Errorf("sc.Passed() = %v, want %v", got, want)
+	}
+	sc.Check("indexes", errors.New("missing sessions [jid]"), false)
+	if got, want := sc.Passed(), false; got != want {
+		t.Errorf("sc.Passed() = %v, want %v", got, want)
+	}
+	if got, want := sc.Fatal(), false; got != want {
+		t.Errorf("sc.Fatal() = %v, want %v", got, want)
+	}
+	var b bytes.Buffer
+	sc.Report(&b)
+	if got, want := b.String(), `startup self-check:
+  [PASS] config
+  [SKIP] migrations: no migrations defined
+  [FAIL] indexes: missing sessions [jid]
+some checks failed, starting anyway
+`; got != want {
+		t.Errorf("b.String() = %v, want %v", got, want)
+	}
+	sc.Check("storage", errors.New("no reachable servers"), true)
+	if got, want := sc.Fatal(), true; got != want {
+		t.Errorf("sc.Fatal() = %v, want %v", got, want)
+	}
+}
+
+func TestSelfCheckTempDir(t *testing.T) {
+	if err := checkTempDir(); err != nil {
+		t.Error(err)
+	}
+}
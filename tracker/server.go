@@ -0,0 +1,187 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"labix.org/v2/mgo"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Server is a tracker serving the API from a MongoDB database, with the
+// jobs that maintain it. A process may run several, each with its own
+// storage, configuration and state, as tests and programs embedding the
+// tracker do: the configuration of the process and openStore only serve
+// the handlers and jobs run without a Server.
+type Server struct {
+	// Config is the configuration s starts with. CurrentConfig returns the
+	// one in effect, which SetConfig replaces, as Reload does.
+	Config *Config
+	// OpenStore returns the Storage used to serve a request or run a job
+	// and a func to release it. NewServer sets it to clone the MongoDB
+	// session, tests may serve from a MemoryStore instead.
+	OpenStore func() (Storage, func())
+	// Log logs what s does outside of requests.
+	Log *Logger
+	// ReadOnly makes s refuse writes with 503 and run no jobs, as
+	// --read-only does. Set it before Run.
+	ReadOnly bool
+
+	// source is where Reload loads the configuration from, nil for the
+	// defaults without command-line flags.
+	source    *configSource
+	configMu  sync.RWMutex
+	session   *mgo.Session
+	http      *http.Server
+	jobs      []*Job
+	scheduler *Scheduler
+	// writeQueue holds the writes accepted while storage is unavailable,
+	// nil unless queue.path is set, see OpenWriteQueue.
+	writeQueue *WriteQueue
+	// cache keeps the state of sessions, nil unless cache.redis is set.
+	cache SessionCache
+	// heldPings are the pings the cache acknowledged without writing them.
+	heldPings *pingJournal
+	alerter   *Alerter
+	// webhooks caches the webhooks notified of session events.
+	webhooks *webhookCache
+	// deliveries are the webhook deliveries in flight.
+	deliveries *webhookDeliveries
+	// status caches what /status reads from storage.
+	status *statusCache
+	// grpc serves the gRPC interface, when grpc is configured.
+	grpc *grpc.Server
+	stop chan struct{}
+	// listening is closed once Run listens.
+	listening chan struct{}
+}
+
+// NewServer returns a Server of config on the database config.Mongo.DB of
+// session, which it owns from then on: Shutdown closes it.
+func NewServer(config *Config, session *mgo.Session) *Server {
+	s := &Server{
+		Config:  config,
+		session: session,
+		http: &http.Server{
+			Addr:           fmt.Sprintf("%s:%d", config.Http.Host, config.Http.Port),
+			MaxHeaderBytes: maxHeaderBytes(config),
+		},
+		jobs:       defaultJobs,
+		heldPings:  newPingJournal(),
+		alerter:    NewAlerter(),
+		webhooks:   newWebhookCache(),
+		deliveries: newWebhookDeliveries(),
+		status:     &statusCache{},
+		stop:       make(chan struct{}),
+		listening:  make(chan struct{}),
+	}
+	if config.Cache != nil {
+		s.cache = newRedisCache(config.Cache)
+	}
+	s.OpenStore = func() (Storage, func()) {
+		ms := session.Clone()
+		return &MongoStore{ms.DB(config.Mongo.DB)}, ms.Close
+	}
+	// Set before Run, as Shutdown may run meanwhile.
+	s.http.Handler = s.Handler()
+	if config.GRPC != nil {
+		s.grpc = newGRPCServer(s)
+	}
+	s.scheduler = NewScheduler(s.jobs)
+	s.scheduler.server = s
+	return s
+}
+
+// CurrentConfig returns the configuration in effect for s.
+// The returned Config must not be modified.
+func (s *Server) CurrentConfig() *Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.Config
+}
+
+// SetConfig puts c in effect for s.
+func (s *Server) SetConfig(c *Config) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.Config = c
+}
+
+// Reload loads the configuration file again and puts it in effect for s,
+// as on SIGHUP, see reloadConfig.
+func (s *Server) Reload() error {
+	src := s.source
+	if src == nil {
+		src = defaultConfigSource()
+	}
+	return reloadConfig(src, s.CurrentConfig(), s.SetConfig)
+}
+
+// Handler returns the API served by s, with every middleware in front.
+func (s *Server) Handler() http.Handler {
+	h := serverHeader(RealIPHandler(compressHandler(recoverHandler(readOnlyHandler(APIHandler())))))
+	return withServer(MetricsHandler(h, metrics), s)
+}
+
+// Run serves the API at http.host and http.port, and the gRPC interface at
+// grpc.host and grpc.port if configured, until Shutdown, and runs the jobs
+// meanwhile, unless the tracker is read-only, as another tracker is
+// expected to run them then.
+func (s *Server) Run() error {
+	if s.session != nil {
+		go superviseSession(s.session, s.CurrentConfig().Mongo, s.stop)
+	}
+	if !s.ReadOnly {
+		s.scheduler.Start()
+	}
+	l, err := net.Listen("tcp", s.http.Addr)
+	if err != nil {
+		return err
+	}
+	if s.grpc != nil {
+		gl, err := net.Listen("tcp", grpcAddr(s.CurrentConfig().GRPC))
+		if err != nil {
+			l.Close()
+			return err
+		}
+		s.Log.Infof("[grpc] serving at %s", gl.Addr())
+		go serveGRPC(s.grpc, gl)
+	}
+	s.Log.Infof("serving at %s", l.Addr())
+	close(s.listening)
+	if err := s.http.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops s gracefully, once: it stops accepting connections and
+// waits for the requests and gRPC calls in flight until ctx is done,
+// closing the connections left then. It then waits for the jobs running
+// and the webhook deliveries in flight, and writes the pings acknowledged
+// but not written yet, before closing the MongoDB session.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.http.Shutdown(ctx)
+	if err != nil {
+		s.http.Close()
+	}
+	if s.grpc != nil {
+		stopGRPC(ctx, s.grpc)
+	}
+	s.scheduler.Stop()
+	if !s.deliveries.wait(shutdownTimeout) {
+		s.Log.Warn("[shutdown] gave up on webhook deliveries in flight after", shutdownTimeout)
+	}
+	store, release := s.OpenStore()
+	if lost := s.flushHeldPings(store); lost > 0 {
+		s.Log.Errorf("[shutdown] lost %d pings held by the cache, neither storage nor the write queue took them", lost)
+	}
+	release()
+	close(s.stop)
+	if s.session != nil {
+		s.session.Close()
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package tracker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetServer undoes the package state a test's servers set up.
+func resetServer(t *testing.T) {
+	t.Cleanup(func() {
+		openStore = nil
+		setConfig(nil)
+	})
+}
+
+func memoryServer(store *MemoryStore) *Server {
+	return &Server{OpenStore: func() (Storage, func()) { return store, func() {} }}
+}
+
+func TestServersHaveTheirOwnStorage(t *testing.T) {
+	resetServer(t)
+	openStore = func() (Storage, func()) {
+		t.Fatal("served from openStore")
+		return nil, nil
+	}
+	first, second := NewMemoryStore(), NewMemoryStore()
+	for _, store := range []*MemoryStore{first, second, first} {
+		form := url.Values{"jid": {"testuser@server.org"}, "machine_id": {"00:26:cc:18:be:14"}, "xmppvox_version": {"1.0"}}
+		req, _ := http.NewRequest("POST", "/1/session/new", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		memoryServer(store).Handler().ServeHTTP(w, req)
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Errorf("%s: w.Code = %v, want %v", w.Body.String(), got, want)
+		}
+	}
+	if got, want := len(first.Sessions), 2; got != want {
+		t.Errorf("len(first.Sessions) = %d, want %d", got, want)
+	}
+	if got, want := len(second.Sessions), 1; got != want {
+		t.Errorf("len(second.Sessions) = %d, want %d", got, want)
+	}
+}
+
+func TestServersHaveTheirOwnConfig(t *testing.T) {
+	resetServer(t)
+	setConfig(&Config{APIKeys: &APIKeysConfig{Mode: APIKeysRequired}})
+	open, keys := memoryServer(NewMemoryStore()), memoryServer(NewMemoryStore())
+	open.Config = &Config{}
+	keys.Config = &Config{APIKeys: &APIKeysConfig{Mode: APIKeysRequired}}
+	for _, tc := range []struct {
+		server *Server
+		code   int
+	}{{open, http.StatusOK}, {keys, http.StatusUnauthorized}} {
+		form := url.Values{"jid": {"testuser@server.org"}, "machine_id": {"00:26:cc:18:be:14"}, "xmppvox_version": {"1.0"}}
+		req, _ := http.NewRequest("POST", "/1/session/new", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		tc.server.Handler().ServeHTTP(w, req)
+		if got, want := w.Code, tc.code; got != want {
+			t.Errorf("%s: w.Code = %v, want %v", w.Body.String(), got, want)
+		}
+	}
+	// A reload only changes the server it is made on.
+	open.SetConfig(keys.Config)
+	if got, want := open.CurrentConfig(), keys.Config; got != want {
+		t.Errorf("open.CurrentConfig() = %v, want %v", got, want)
+	}
+	if got, want := currentConfig().APIKeys.Mode, APIKeysRequired; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	resetServer(t)
+	store := NewMemoryStore()
+	srv := NewServer(&Config{Http: &HttpConfig{Host: "localhost", Port: 0}, Mongo: &MongoConfig{}}, nil)
+	srv.OpenStore = func() (Storage, func()) { return store, func() {} }
+	done := make(chan error, 1)
+	go func() { done <- srv.Run() }()
+	select {
+	case <-srv.listening:
+	case err := <-done:
+		t.Fatal(err)
+	}
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after Shutdown")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	resetServer(t)
+	defer func(v, commit string) { Version, Commit = v, commit }(Version, Commit)
+	Version, Commit = "1.4.0", "4f76234"
+	req, _ := http.NewRequest("GET", "/version", nil)
+	w := httptest.NewRecorder()
+	memoryServer(NewMemoryStore()).Handler().ServeHTTP(w, req)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Server"), "elephant-tracker/1.4.0"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), `{"version":"1.4.0","commit":"4f76234"}`+"\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	req, _ = http.NewRequest("GET", "/nowhere", nil)
+	w = httptest.NewRecorder()
+	memoryServer(NewMemoryStore()).Handler().ServeHTTP(w, req)
+	if got, want := w.Code, http.StatusNotFound; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Server"), "elephant-tracker/1.4.0"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package tracker
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits of the servers listed by ServerStatsHandler.
+const (
+	defaultServerStatsLimit = 20
+	maxServerStatsLimit     = 1000
+)
+
+// xmppServerParam returns the optional xmpp_server param of r, the host
+// name of the XMPP server the client connected to, lowercased like the
+// domain of jids.
+func xmppServerParam(r *http.Request) (string, *APIError) {
+	server := strings.ToLower(r.PostFormValue("xmpp_server"))
+	if server != "" && !validJIDDomain(server) {
+		return "", &APIError{"invalid_value", "xmpp_server", "",
+			fmt.Sprintf("Invalid xmpp_server %s, expected a host name like xmpp.example.org", server)}
+	}
+	return server, nil
+}
+
+// serverStats is the distribution of the sessions started over a time
+// window by the XMPP server the clients connected to.
+type serverStats struct {
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Servers []*serverShare `json:"servers"`
+}
+
+// serverShare is how many sessions were started on an XMPP server, and by
+// how many distinct users and machines. An empty server is unknown, as for
+// the sessions of clients that do not tell it.
+type serverShare struct {
+	Server         string `json:"server"`
+	Sessions       int    `json:"sessions"`
+	UniqueJIDs     int    `json:"unique_jids"`
+	UniqueMachines int    `json:"unique_machines"`
+}
+
+// ServerStatsHandler reports the XMPP servers with the most sessions, so
+// that it is known which public servers the community depends on.
+func ServerStatsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	win, errs := parseWindow(r, now, defaultStatsWindow, maxStatsWindow)
+	limit := defaultServerStatsLimit
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxServerStatsLimit {
+			errs = append(errs, invalidLimit(maxServerStatsLimit))
+		}
+		limit = n
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if checkModified(w, r, c) {
+		return
+	}
+	asOf, err := c.Store.NewestActivity()
+	var counts []*ServerCount
+	if err == nil {
+		counts, err = c.Store.SessionServers(win.From, win.To, limit)
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute server stats"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	stats := &serverStats{From: win.From, To: win.To, Servers: []*serverShare{}}
+	for _, sc := range counts {
+		stats.Servers = append(stats.Servers, &serverShare{sc.Server, sc.Sessions, sc.UniqueJIDs, sc.UniqueMachines})
+	}
+	writeStats(w, stats, freshness(asOf, c.Config, now))
+}
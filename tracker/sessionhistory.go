@@ -0,0 +1,164 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 1000
+)
+
+// historySession is a session as listed in a session history.
+type historySession struct {
+	Id             string     `json:"id"`
+	Alias          string     `json:"alias,omitempty"`
+	JID            string     `json:"jid"`
+	MachineId      string     `json:"machine_id"`
+	XMPPVOXVersion string     `json:"xmppvox_version"`
+	XMPPServer     string     `json:"xmpp_server,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ClosedAt       *time.Time `json:"closed_at"`
+	ClosedReason   string     `json:"closed_reason,omitempty"`
+	LastPing       *time.Time `json:"last_ping"`
+	// Duration is how long the session lasted, in seconds, until it was
+	// closed or, while open, until its last ping.
+	Duration int64             `json:"duration"`
+	Tags     []string          `json:"tags"`
+	Notes    []*Note           `json:"notes"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func newHistorySession(s *Session) *historySession {
+	h := &historySession{
+		Id:             s.Id.String(),
+		Alias:          formatAlias(s.Alias),
+		JID:            s.JID,
+		MachineId:      s.MachineId,
+		XMPPVOXVersion: s.XMPPVOXVersion,
+		XMPPServer:     s.XMPPServer,
+		CreatedAt:      s.CreatedAt,
+		ClosedReason:   s.ClosedReason,
+		Tags:           nonNilTags(s.Tags),
+		Notes:          nonNilNotes(s.Notes),
+		Metadata:       s.Metadata,
+	}
+	end := s.CreatedAt
+	if !s.LastPing.IsZero() {
+		t := s.LastPing
+		h.LastPing = &t
+		end = t
+	}
+	if !s.ClosedAt.IsZero() {
+		t := s.ClosedAt
+		h.ClosedAt = &t
+		end = t
+	}
+	if end.After(s.CreatedAt) {
+		h.Duration = int64(end.Sub(s.CreatedAt) / time.Second)
+	}
+	return h
+}
+
+// historyPage parses the page and limit parameters of a session history.
+func historyPage(r *http.Request) (page, limit int, errs APIErrors) {
+	page, e := pageParam(r, "page")
+	if e != nil {
+		errs = append(errs, e)
+	}
+	limit = defaultHistoryLimit
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxHistoryLimit {
+			errs = append(errs, invalidLimit(maxHistoryLimit))
+		}
+		limit = n
+	}
+	return page, limit, errs
+}
+
+// historyTag parses the optional tag parameter of a listing.
+func historyTag(r *http.Request) (string, *APIError) {
+	if r.FormValue("tag") == "" {
+		return "", nil
+	}
+	return tagParam(r)
+}
+
+// writeHistoryLinks links the previous and the next page, if more, in the
+// Link header, reporting whether there is a next page.
+func writeHistoryLinks(w http.ResponseWriter, r *http.Request, page int, more bool) bool {
+	p := newPager(r.URL, "page", "", page, more)
+	if p.Prev != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s%s>; rel="prev"`, r.URL.Path, p.Prev))
+	}
+	if p.Next != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s%s>; rel="next"`, r.URL.Path, p.Next))
+		return true
+	}
+	return false
+}
+
+// writeHistory replies with a page of sessions, fetched with one more than
+// limit to know whether there is a next page, which is linked in the Link
+// header along with the previous one.
+func writeHistory(w http.ResponseWriter, r *http.Request, sessions []*Session, page, limit int) {
+	if writeHistoryLinks(w, r, page, len(sessions) > limit) {
+		sessions = sessions[:limit]
+	}
+	history := make([]*historySession, len(sessions))
+	for i, s := range sessions {
+		history[i] = newHistorySession(s)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(history)
+}
+
+// MachineSessionsHandler lists the sessions of a machine, newest first,
+// for support to follow reports of sessions that keep dropping.
+func MachineSessionsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	page, limit, errs := historyPage(r)
+	tag, e := historyTag(r)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	sessions, err := c.Store.MachineSessions(canonicalMachineId(c.Config, mux.Vars(r)["machine_id"]), tag, (page-1)*limit, limit+1)
+	if err != nil {
+		writeError(w, r, internalError("Failed to list sessions"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	writeHistory(w, r, sessions, page, limit)
+}
+
+// UserSessionsHandler lists the sessions of a jid across machines, newest
+// first, for support to follow problems of an account.
+func UserSessionsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	page, limit, errs := historyPage(r)
+	window, windowErrs := parseWindow(r, time.Now().UTC(), 0, 0)
+	errs = append(errs, windowErrs...)
+	tag, e := historyTag(r)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	sessions, err := c.Store.UserSessions(canonicalJID(c.Config, mux.Vars(r)["jid"]), window, tag, (page-1)*limit, limit+1)
+	if err != nil {
+		writeError(w, r, internalError("Failed to list sessions"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	writeHistory(w, r, sessions, page, limit)
+}
@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"time"
+)
+
+// Formats of session ids, as set in sessions.id_format.
+const (
+	SessionIdObjectId = "objectid"
+	SessionIdUUID     = "uuid"
+	SessionIdULID     = "ulid"
+)
+
+// SessionId identifies a session. It is the hex of an ObjectId, the default,
+// or a UUID or ULID as set in sessions.id_format. ObjectIds are stored as
+// such, so that the sessions started before the other formats keep their
+// ids, and the others as strings.
+type SessionId string
+
+// NewSessionId returns a new session id as ObjectId.
+func NewSessionId() SessionId {
+	return SessionId(bson.NewObjectId().Hex())
+}
+
+// String returns the id as sent to clients.
+func (id SessionId) String() string {
+	return string(id)
+}
+
+// GetBSON implements bson.Getter.
+func (id SessionId) GetBSON() (interface{}, error) {
+	if bson.IsObjectIdHex(string(id)) {
+		return bson.ObjectIdHex(string(id)), nil
+	}
+	return string(id), nil
+}
+
+// SetBSON implements bson.Setter.
+func (id *SessionId) SetBSON(raw bson.Raw) error {
+	switch raw.Kind {
+	case 0x07:
+		var oid bson.ObjectId
+		if err := raw.Unmarshal(&oid); err != nil {
+			return err
+		}
+		*id = SessionId(oid.Hex())
+		return nil
+	case 0x0A:
+		*id = ""
+		return nil
+	}
+	var s string
+	if err := raw.Unmarshal(&s); err != nil {
+		return err
+	}
+	*id = SessionId(s)
+	return nil
+}
+
+// Spellings of the session ids of each format, as generated.
+var (
+	uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidPattern = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+// sessionIdFormat returns sessions.id_format, or its default.
+func sessionIdFormat(conf *Config) string {
+	if conf == nil || conf.Sessions == nil || conf.Sessions.IdFormat == "" {
+		return SessionIdObjectId
+	}
+	return conf.Sessions.IdFormat
+}
+
+// newSessionId returns a new session id in the format of sessions.id_format.
+func newSessionId(conf *Config) SessionId {
+	switch sessionIdFormat(conf) {
+	case SessionIdUUID:
+		return SessionId(randomUUID())
+	case SessionIdULID:
+		return newULID(time.Now())
+	}
+	return NewSessionId()
+}
+
+// validSessionId reports whether s is a session id in the format of
+// sessions.id_format, or an ObjectId.
+func validSessionId(conf *Config, s string) bool {
+	if bson.IsObjectIdHex(s) {
+		return true
+	}
+	switch sessionIdFormat(conf) {
+	case SessionIdUUID:
+		return uuidPattern.MatchString(s)
+	case SessionIdULID:
+		return ulidPattern.MatchString(s)
+	}
+	return false
+}
+
+// crockford is the base 32 alphabet of ULIDs, without I, L, O and U.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID of t: its milliseconds in 48 bits followed by 80
+// random bits, in 26 characters of Crockford's base 32.
+func newULID(t time.Time) SessionId {
+	var b [16]byte
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint64(b[:8], ms<<16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		panic(err)
+	}
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	s := make([]byte, 26)
+	for i := len(s) - 1; i >= 0; i-- {
+		s[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return SessionId(s)
+}
@@ -0,0 +1,119 @@
+package tracker
+
+import (
+	"labix.org/v2/mgo/bson"
+	"testing"
+	"time"
+)
+
+func TestNewSessionId(t *testing.T) {
+	for format, valid := range map[string]func(string) bool{
+		"":                bson.IsObjectIdHex,
+		SessionIdObjectId: bson.IsObjectIdHex,
+		SessionIdUUID:     uuidPattern.MatchString,
+		SessionIdULID:     ulidPattern.MatchString,
+	} {
+		conf := &Config{Sessions: &SessionsConfig{IdFormat: format}}
+		id := newSessionId(conf)
+		if got, want := valid(id.String()), true; got != want {
+			t.Errorf("%s: %s: valid(id.String()) = %v, want %v", format, id, got, want)
+		}
+		if got, want := validSessionId(conf, id.String()), true; got != want {
+			t.Errorf("%s: %s: validSessionId(conf, id.String()) = %v, want %v", format, id, got, want)
+		}
+		if got, want := validSessionId(conf, NewSessionId().String()), true; got != want {
+			t.Errorf("%s: validSessionId(conf, NewSessionId().String()) = %v, want %v", format, got, want)
+		}
+		if got, want := newSessionId(conf), id; got == want {
+			t.Errorf("%s: newSessionId(conf) = %v, want anything but %v", format, got, want)
+		}
+	}
+	if got, want := len(newSessionId(nil).String()), 24; got != want {
+		t.Errorf("len(newSessionId(nil).String()) = %d, want %d", got, want)
+	}
+}
+
+func TestSessionIdValid(t *testing.T) {
+	uuid := &Config{Sessions: &SessionsConfig{IdFormat: SessionIdUUID}}
+	ulid := &Config{Sessions: &SessionsConfig{IdFormat: SessionIdULID}}
+	if got, want := validSessionId(nil, "6ba7b810-9dad-41d1-80b4-00c04fd430c8"), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := validSessionId(uuid, "6ba7b810-9dad-41d1-80b4-00c04fd430c8"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := validSessionId(uuid, "6BA7B810-9DAD-41D1-80B4-00C04FD430C8"), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := validSessionId(uuid, "6ba7b810-9dad-11d1-80b4-00c04fd430c8"), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := validSessionId(uuid, "01ARZ3NDEKTSV4RRFFQ69G5FAV"), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := validSessionId(ulid, "01ARZ3NDEKTSV4RRFFQ69G5FAV"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := validSessionId(ulid, "01ARZ3NDEKTSV4RRFFQ69G5FAU"), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := validSessionId(ulid, "81ARZ3NDEKTSV4RRFFQ69G5FAV"), false; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := validSessionId(ulid, "5373a0c5e4b0d0a4f7e5c1a2"), true; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestULIDSortsByTime(t *testing.T) {
+	at := time.Date(2016, 7, 30, 23, 54, 10, 259000000, time.UTC)
+	if got, want := string(newULID(at))[:10], "01ARZ3NDEK"; got != want {
+		t.Errorf("string(newULID(at))[:10] = %v, want %v", got, want)
+	}
+	if got, want := newULID(at) < newULID(at.Add(time.Millisecond)), true; got != want {
+		t.Errorf("newULID(at) < newULID(at.Add(time.Millisecond)) = %v, want %v", got, want)
+	}
+}
+
+func TestSessionIdBSON(t *testing.T) {
+	for _, id := range []SessionId{NewSessionId(), SessionId(randomUUID()), newULID(time.Now())} {
+		data, err := bson.Marshal(&Session{Id: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var raw struct {
+			Id bson.Raw `bson:"_id"`
+		}
+		if err := bson.Unmarshal(data, &raw); err != nil {
+			t.Fatal(err)
+		}
+		if bson.IsObjectIdHex(id.String()) {
+			if got, want := raw.Id.Kind, byte(0x07); got != want {
+				t.Errorf("raw.Id.Kind = %v, want %v", got, want)
+			}
+		} else {
+			if got, want := raw.Id.Kind, byte(0x02); got != want {
+				t.Errorf("raw.Id.Kind = %v, want %v", got, want)
+			}
+		}
+		var got Session
+		if err := bson.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := got.Id, id; got != want {
+			t.Errorf("got.Id = %v, want %v", got, want)
+		}
+	}
+	data, err := bson.Marshal(&Crash{MachineId: "machine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	_, ok := m["session_id"]
+	if got, want := ok, false; got != want {
+		t.Errorf("ok = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,33 @@
+package tracker
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout bounds how long a graceful shutdown waits for the
+// requests in flight and then for the webhook deliveries in flight.
+const shutdownTimeout = 30 * time.Second
+
+// drainOnSignal shuts server down gracefully on SIGINT or SIGTERM, see
+// Server.Shutdown. The returned channel is closed once done.
+func drainOnSignal(server *Server) <-chan struct{} {
+	done := make(chan struct{})
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		defer close(done)
+		sig := <-c
+		signal.Stop(c)
+		logger.Infof("[shutdown] %s, draining requests in flight", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Warn("[shutdown] requests still in flight after", shutdownTimeout, "closing connections")
+		}
+	}()
+	return done
+}
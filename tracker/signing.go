@@ -0,0 +1,96 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"labix.org/v2/mgo"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// sessionSigning returns the sessions.signing mode of conf.
+func sessionSigning(conf *Config) string {
+	if conf == nil || conf.Sessions == nil {
+		return ""
+	}
+	return conf.Sessions.Signing
+}
+
+// requestSignature signs a request to call, like "/session/ping", with the
+// hex HMAC-SHA256 keyed by the session secret of the call, a newline and the
+// params other than signature, URL-encoded and sorted by name.
+func requestSignature(secret, call string, params url.Values) string {
+	signed := url.Values{}
+	for name, values := range params {
+		if name != "signature" {
+			signed[name] = values
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(call + "\n" + signed.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// maxSignatureAge is how far from the clock of the tracker, either way, the
+// timestamp of a signed request can be, so that a request overheard is not
+// accepted again later, while allowing for skewed client clocks.
+const maxSignatureAge = 5 * time.Minute
+
+// sessionSecret returns the secret of session id, from the cache of store
+// when it has one, or mgo.ErrNotFound.
+func sessionSecret(store Storage, id SessionId) (string, error) {
+	if cs := cacheOf(store); cs != nil {
+		return cs.sessionSecret(id)
+	}
+	s, err := store.FindSession(id)
+	if err != nil {
+		return "", err
+	}
+	return s.Secret, nil
+}
+
+// checkSignature checks the signature of a request to call about session id,
+// according to sessions.signing, and that its timestamp, in Unix seconds, is
+// within maxSignatureAge of now. It returns the problem with the signature,
+// if any, or the error looking up the session. Unknown sessions are left
+// for the caller to report.
+func checkSignature(r *http.Request, c *Context, call string, id SessionId, now time.Time) (*APIError, error) {
+	mode := sessionSigning(c.Config)
+	if mode == "" {
+		return nil, nil
+	}
+	signature := r.PostFormValue("signature")
+	if signature == "" {
+		if mode == SigningRequired {
+			return &APIError{"missing_signature", "signature", "", "Missing POST parameter signature"}, nil
+		}
+		return nil, nil
+	}
+	timestamp := r.PostFormValue("timestamp")
+	if timestamp == "" {
+		return &APIError{"missing_timestamp", "timestamp", "", "Missing POST parameter timestamp, required with signature"}, nil
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return &APIError{"invalid_value", "timestamp", "", "Invalid timestamp, expected Unix time in seconds"}, nil
+	}
+	if d := now.Sub(time.Unix(sec, 0)); d > maxSignatureAge || d < -maxSignatureAge {
+		return &APIError{"stale_signature", "timestamp", "",
+			fmt.Sprintf("Stale signature, its timestamp is more than %s away from the time of the server", maxSignatureAge)}, nil
+	}
+	secret, err := sessionSecret(c.Store, id)
+	switch {
+	case err == mgo.ErrNotFound:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	if secret == "" || !hmac.Equal([]byte(signature), []byte(requestSignature(secret, call, r.PostForm))) {
+		return &APIError{"invalid_signature", "signature", "", "Invalid signature"}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,400 @@
+package tracker
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultStaleAfter is used when stats.stale_after is not configured.
+const defaultStaleAfter = time.Hour
+
+const (
+	// defaultStatsWindow is the time window of stats when from is not given.
+	defaultStatsWindow = 30 * 24 * time.Hour
+	// maxStatsWindow bounds the work of a single stats request.
+	maxStatsWindow = 366 * 24 * time.Hour
+)
+
+// Freshness tells how recent the data behind a stats response is,
+// so that nobody takes decisions on counters that stopped updating,
+// as when the jobs computing them fail.
+type Freshness struct {
+	// DataAsOf is the time of the newest document or rollup the response was computed from.
+	DataAsOf time.Time `json:"data_as_of"`
+	// Stale is set when DataAsOf is older than stats.stale_after.
+	Stale bool `json:"stale"`
+}
+
+func freshness(asOf time.Time, conf *Config, now time.Time) Freshness {
+	staleAfter := defaultStaleAfter
+	if conf != nil && conf.Stats != nil && conf.Stats.StaleAfter.Duration > 0 {
+		staleAfter = conf.Stats.StaleAfter.Duration
+	}
+	return Freshness{asOf, now.Sub(asOf) > staleAfter}
+}
+
+// statsResponse is the envelope of every stats response.
+type statsResponse struct {
+	Freshness
+	Data interface{} `json:"data"`
+}
+
+// writeStats replies with data as JSON, along with its freshness.
+// The freshness is also in the X-Data-As-Of and X-Data-Stale headers,
+// for clients that do not parse the body, like monitoring probes.
+func writeStats(w http.ResponseWriter, data interface{}, f Freshness) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Data-As-Of", f.DataAsOf.UTC().Format(http.TimeFormat))
+	if f.Stale {
+		w.Header().Set("X-Data-Stale", "true")
+	}
+	json.NewEncoder(w).Encode(&statsResponse{f, data})
+}
+
+// statsModified returns when the data of the stats last changed, as far as
+// it can tell cheaply: the newest session or installation created, or the
+// latest rollup computed, whichever is later.
+func statsModified(store Storage) (time.Time, error) {
+	modified, err := store.NewestActivity()
+	if err != nil {
+		return modified, err
+	}
+	switch r, err := store.LatestRollup(RollupHour); err {
+	case nil:
+		if r.ComputedAt.After(modified) {
+			modified = r.ComputedAt
+		}
+	case mgo.ErrNotFound:
+	default:
+		return modified, err
+	}
+	return modified, nil
+}
+
+// statsETag is the entity tag of a stats response to r computed from the
+// data as of modified. It is weak, as windows relative to now, like the
+// default one, move between requests while the data stays the same.
+func statsETag(r *http.Request, modified time.Time) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s?%s\n%d", r.URL.Path, r.URL.Query().Encode(), modified.UnixNano())
+	return `W/"` + hex.EncodeToString(h.Sum(nil)[:8]) + `"`
+}
+
+// notModified sets the ETag and Last-Modified headers of a stats response
+// from modified, and replies 304 returning true when r already has that
+// response, by If-None-Match or, without it, If-Modified-Since. Pollers
+// like dashboards then revalidate for the price of statsModified instead of
+// having the stats computed again.
+func notModified(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	etag := statsETag(r, modified)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-cache")
+	match := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				match = true
+				break
+			}
+		}
+	} else if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		match = !modified.Truncate(time.Second).After(t)
+	}
+	if match {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return match
+}
+
+// checkModified replies 304 and returns true when r has the stats response
+// as of the current data, see notModified. The stats are computed anyway if
+// that cannot be told.
+func checkModified(w http.ResponseWriter, r *http.Request, c *Context) bool {
+	modified, err := statsModified(c.Store)
+	if err != nil {
+		c.Log.Error(err)
+		return false
+	}
+	return notModified(w, r, modified)
+}
+
+// versionStats is the adoption of each xmppvox_version over a time window.
+type versionStats struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Days are the UTC days of the window, the x axis of a plot.
+	Days     []string         `json:"days"`
+	Versions []*versionSeries `json:"versions"`
+}
+
+type versionSeries struct {
+	Version       string `json:"version"`
+	Sessions      int    `json:"sessions"`
+	Installations int    `json:"installations"`
+	// DailySessions and DailyInstallations have a count for each of Days.
+	DailySessions      []int `json:"daily_sessions"`
+	DailyInstallations []int `json:"daily_installations"`
+}
+
+func newVersionStats(from, to time.Time, sessions, installations []*VersionDayCount) *versionStats {
+	vs := &versionStats{From: from, To: to, Days: []string{}, Versions: []*versionSeries{}}
+	first := utcDay(from)
+	for day := first; day.Before(to); day = day.AddDate(0, 0, 1) {
+		vs.Days = append(vs.Days, day.Format("2006-01-02"))
+	}
+	series := make(map[string]*versionSeries)
+	get := func(version string) *versionSeries {
+		s, ok := series[version]
+		if !ok {
+			s = &versionSeries{
+				Version:            version,
+				DailySessions:      make([]int, len(vs.Days)),
+				DailyInstallations: make([]int, len(vs.Days)),
+			}
+			series[version] = s
+		}
+		return s
+	}
+	for _, vdc := range sessions {
+		s := get(vdc.Version)
+		s.Sessions += vdc.Count
+		s.DailySessions[int(vdc.Day.Sub(first)/(24*time.Hour))] += vdc.Count
+	}
+	for _, vdc := range installations {
+		s := get(vdc.Version)
+		s.Installations += vdc.Count
+		s.DailyInstallations[int(vdc.Day.Sub(first)/(24*time.Hour))] += vdc.Count
+	}
+	versions := make([]string, 0, len(series))
+	for version := range series {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	for _, version := range versions {
+		vs.Versions = append(vs.Versions, series[version])
+	}
+	return vs
+}
+
+// VersionStatsHandler reports how many sessions and installations
+// each xmppvox_version had per day, to follow the adoption of releases.
+func VersionStatsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	win, errs := parseWindow(r, now, defaultStatsWindow, maxStatsWindow)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if checkModified(w, r, c) {
+		return
+	}
+	from, to := win.From, win.To
+	asOf, err := c.Store.NewestActivity()
+	var sessions, installations []*VersionDayCount
+	if err == nil {
+		sessions, err = c.Store.SessionVersionsPerDay(from, to)
+	}
+	if err == nil {
+		installations, err = c.Store.InstallationVersionsPerDay(from, to)
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute version stats"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	writeStats(w, newVersionStats(from, to, sessions, installations), freshness(asOf, c.Config, now))
+}
+
+// platformStats is the distribution of the installations created over a
+// time window by operating system and architecture.
+type platformStats struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Systems counts the installations per system and release.
+	Systems []*platformShare `json:"systems"`
+	// Machines counts the installations per machine, the architecture.
+	Machines []*platformShare `json:"machines"`
+	// Platforms counts the installations per system, release and machine.
+	Platforms []*platformShare `json:"platforms"`
+}
+
+// platformShare is how many installations have a platform. Empty fields
+// are unknown, as for installations without machine_info.
+type platformShare struct {
+	System        string `json:"system,omitempty"`
+	Release       string `json:"release,omitempty"`
+	Machine       string `json:"machine,omitempty"`
+	Installations int    `json:"installations"`
+}
+
+// platformShares sorts by decreasing number of installations.
+type platformShares []*platformShare
+
+func (s platformShares) Len() int           { return len(s) }
+func (s platformShares) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s platformShares) Less(i, j int) bool { return s[i].Installations > s[j].Installations }
+
+func newPlatformStats(from, to time.Time, counts []*PlatformCount) *platformStats {
+	ps := &platformStats{From: from, To: to, Systems: []*platformShare{}, Machines: []*platformShare{}, Platforms: []*platformShare{}}
+	systems := make(map[[2]string]*platformShare)
+	machines := make(map[string]*platformShare)
+	for _, pc := range counts {
+		ps.Platforms = append(ps.Platforms, &platformShare{pc.System, pc.Release, pc.Machine, pc.Count})
+		s, ok := systems[[2]string{pc.System, pc.Release}]
+		if !ok {
+			s = &platformShare{System: pc.System, Release: pc.Release}
+			systems[[2]string{pc.System, pc.Release}] = s
+			ps.Systems = append(ps.Systems, s)
+		}
+		s.Installations += pc.Count
+		m, ok := machines[pc.Machine]
+		if !ok {
+			m = &platformShare{Machine: pc.Machine}
+			machines[pc.Machine] = m
+			ps.Machines = append(ps.Machines, m)
+		}
+		m.Installations += pc.Count
+	}
+	// Counts come ordered by name, which breaks ties.
+	sort.Stable(platformShares(ps.Systems))
+	sort.Stable(platformShares(ps.Machines))
+	sort.Stable(platformShares(ps.Platforms))
+	return ps
+}
+
+// PlatformStatsHandler reports how the installations are distributed by
+// operating system and architecture, to decide which platforms to support.
+func PlatformStatsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	win, errs := parseWindow(r, now, defaultStatsWindow, maxStatsWindow)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if checkModified(w, r, c) {
+		return
+	}
+	asOf, err := c.Store.NewestActivity()
+	var counts []*PlatformCount
+	if err == nil {
+		counts, err = c.Store.InstallationPlatforms(win.From, win.To)
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute platform stats"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	writeStats(w, newPlatformStats(win.From, win.To, counts), freshness(asOf, c.Config, now))
+}
+
+// dosvoxVersionStats is the distribution of the installations created over a
+// time window by DOSVOX version.
+type dosvoxVersionStats struct {
+	From     time.Time             `json:"from"`
+	To       time.Time             `json:"to"`
+	Versions []*dosvoxVersionShare `json:"versions"`
+}
+
+// dosvoxVersionShare is how many installations run a DOSVOX version. An
+// empty version is unknown, as for installations without dosvox_info.
+type dosvoxVersionShare struct {
+	Version       string `json:"version"`
+	Installations int    `json:"installations"`
+}
+
+// dosvoxVersionShares sorts by version, oldest first.
+type dosvoxVersionShares []*dosvoxVersionShare
+
+func (s dosvoxVersionShares) Len() int      { return len(s) }
+func (s dosvoxVersionShares) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s dosvoxVersionShares) Less(i, j int) bool {
+	return compareVersions(s[i].Version, s[j].Version) < 0
+}
+
+// DosvoxVersionStatsHandler reports how many installations run each DOSVOX
+// version, for its maintainers to decide when to drop compatibility.
+// Unlike the other stats, the window is open at the start by default,
+// since installations are only registered once.
+func DosvoxVersionStatsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	win, errs := parseWindow(r, now, 0, 0)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if checkModified(w, r, c) {
+		return
+	}
+	asOf, err := c.Store.NewestActivity()
+	var counts []*VersionCount
+	if err == nil {
+		counts, err = c.Store.InstallationDosvoxVersions(win.From, win.To)
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute DOSVOX version stats"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	stats := &dosvoxVersionStats{From: win.From, To: win.To, Versions: []*dosvoxVersionShare{}}
+	for _, vc := range counts {
+		stats.Versions = append(stats.Versions, &dosvoxVersionShare{vc.Version, vc.Count})
+	}
+	sort.Stable(dosvoxVersionShares(stats.Versions))
+	writeStats(w, stats, freshness(asOf, c.Config, now))
+}
+
+// installationStats counts the installations created over a time window by
+// machine id and by fingerprint, which tells reinstalls apart from new
+// machines, and how many are still installed.
+type installationStats struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Machines counts the distinct machine ids and Fingerprints the
+	// distinct machines, counting installations without a fingerprint once
+	// each. Reinstalls is how many installations are reinstalls of a machine.
+	Machines     int `json:"machines"`
+	Fingerprints int `json:"fingerprints"`
+	Reinstalls   int `json:"reinstalls"`
+	// Uninstalled is how many installations were reported uninstalled and
+	// Active how many machines have an installation that was not.
+	Uninstalled int `json:"uninstalled"`
+	Active      int `json:"active"`
+}
+
+// InstallationStatsHandler reports how many installations were registered
+// and how many distinct machines they run on, since reinstalls of Windows
+// register again with a new machine id. Like DosvoxVersionStatsHandler, the
+// window is open at the start by default.
+func InstallationStatsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	win, errs := parseWindow(r, now, 0, 0)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if checkModified(w, r, c) {
+		return
+	}
+	asOf, err := c.Store.NewestActivity()
+	var u *UniqueInstallations
+	if err == nil {
+		u, err = c.Store.InstallationUniques(win.From, win.To)
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to compute installation stats"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	writeStats(w, &installationStats{win.From, win.To, u.Machines, u.Fingerprints, u.Machines - u.Fingerprints,
+		u.Uninstalled, u.Active},
+		freshness(asOf, c.Config, now))
+}
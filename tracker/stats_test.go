@@ -0,0 +1,510 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatsFreshness(t *testing.T) {
+	now := time.Now()
+	if got, want := freshness(now.Add(-time.Minute), nil, now).Stale, false; got != want {
+		t.Errorf("freshness(now.Add(-time.Minute), nil, now).Stale = %v, want %v", got, want)
+	}
+	if got, want := freshness(now.Add(-2*time.Hour), nil, now).Stale, true; got != want {
+		t.Errorf("freshness(now.Add(-2*time.Hour), nil, now).Stale = %v, want %v", got, want)
+	}
+	conf := &Config{Stats: &StatsConfig{StaleAfter: Duration{3 * time.Hour}}}
+	if got, want := freshness(now.Add(-2*time.Hour), conf, now).Stale, false; got != want {
+		t.Errorf("freshness(now.Add(-2*time.Hour), conf, now).Stale = %v, want %v", got, want)
+	}
+	// No data at all is as stale as it gets
+	if got, want := freshness(time.Time{}, conf, now).Stale, true; got != want {
+		t.Errorf("freshness(time.Time{}, conf, now).Stale = %v, want %v", got, want)
+	}
+}
+
+func TestWriteStats(t *testing.T) {
+	asOf := time.Date(2014, 5, 1, 12, 0, 0, 0, time.UTC)
+	w := httptest.NewRecorder()
+	writeStats(w, map[string]int{"sessions": 3}, Freshness{asOf, true})
+	if got, want := w.Header().Get("X-Data-As-Of"), "Thu, 01 May 2014 12:00:00 GMT"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("X-Data-Stale"), "true"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	var body struct {
+		DataAsOf time.Time `json:"data_as_of"`
+		Stale    bool
+		Data     map[string]int
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.DataAsOf.Equal(asOf), true; got != want {
+		t.Errorf("body.DataAsOf.Equal(asOf) = %v, want %v", got, want)
+	}
+	if got, want := body.Stale, true; got != want {
+		t.Errorf("body.Stale = %v, want %v", got, want)
+	}
+	if got, want := body.Data["sessions"], 3; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestVersionStats(t *testing.T) {
+	store := NewMemoryStore()
+	day := func(d int) time.Time { return time.Date(2014, 5, d, 10, 0, 0, 0, time.UTC) }
+	for i, tc := range []struct {
+		version string
+		at      time.Time
+	}{{"1.0", day(1)}, {"1.0", day(1)}, {"1.1", day(2)}, {"1.1", day(3)}, {"0.9", day(9)}} {
+		sess := NewSession("user@example.com", "machine", tc.version, nil)
+		sess.CreatedAt = tc.at
+		if err := store.InsertSession(sess); err != nil {
+			t.Fatal(err)
+		}
+		inst := NewInstallation(fmt.Sprintf("machine-%d", i), tc.version, nil, nil)
+		inst.CreatedAt = tc.at
+		if err := store.InsertInstallation(inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, _ := http.NewRequest("GET", "/1/stats/versions?from=2014-05-01&to=2014-05-04", nil)
+	w := httptest.NewRecorder()
+	VersionStatsHandler(w, r, &Context{Store: store})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	var body struct {
+		Data *versionStats
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Data.Days, ([]string{"2014-05-01", "2014-05-02", "2014-05-03"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Data.Days = %v, want %v", got, want)
+	}
+	if got, want := len(body.Data.Versions), 2; got != want {
+		t.Fatalf("len(body.Data.Versions) = %d, want %d", got, want)
+	}
+	if got, want := body.Data.Versions[0], (&versionSeries{"1.0", 2, 2, []int{2, 0, 0}, []int{2, 0, 0}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Data.Versions[0] = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Versions[1], (&versionSeries{"1.1", 2, 2, []int{0, 1, 1}, []int{0, 1, 1}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Data.Versions[1] = %v, want %v", got, want)
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	p := parsePlatform(map[string]string{"system": "Windows", "release": "7", "machine": "AMD64", "node": "desktop"})
+	if got, want := *p, (Platform{System: "Windows", Release: "7", Machine: "AMD64"}); got != want {
+		t.Errorf("*p = %v, want %v", got, want)
+	}
+	if err := parsePlatform(map[string]string{"node": "desktop"}); err != nil {
+		t.Error(err)
+	}
+	if err := parsePlatform(nil); err != nil {
+		t.Error(err)
+	}
+	i := NewInstallation("machine", "1.0", nil, map[string]string{"system": "Windows", "release": "XP"})
+	if got, want := i.Platform.Release, "XP"; got != want {
+		t.Errorf("i.Platform.Release = %v, want %v", got, want)
+	}
+	if got, want := i.MachineInfo["release"], "XP"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPlatformStats(t *testing.T) {
+	store := NewMemoryStore()
+	at := time.Date(2014, 5, 2, 10, 0, 0, 0, time.UTC)
+	for i, info := range []map[string]string{
+		{"system": "Windows", "release": "7", "machine": "AMD64"},
+		{"system": "Windows", "release": "7", "machine": "x86"},
+		{"system": "Windows", "release": "XP", "machine": "x86"},
+		{"system": "Windows", "release": "7", "machine": "AMD64"},
+		nil,
+	} {
+		inst := NewInstallation(fmt.Sprintf("machine-%d", i), "1.0", nil, info)
+		inst.CreatedAt = at
+		if err := store.InsertInstallation(inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Registered before platforms were parsed.
+	old := &Installation{MachineId: "old", MachineInfo: map[string]string{"system": "Linux", "machine": "x86"}, CreatedAt: at}
+	if err := store.InsertInstallation(old); err != nil {
+		t.Fatal(err)
+	}
+	r, _ := http.NewRequest("GET", "/1/stats/platforms?from=2014-05-01&to=2014-05-04", nil)
+	w := httptest.NewRecorder()
+	PlatformStatsHandler(w, r, &Context{Store: store})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	var body struct {
+		Data platformStats
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Data.Systems, ([]*platformShare{
+		{System: "Windows", Release: "7", Installations: 3},
+		{Installations: 1},
+		{System: "Linux", Installations: 1},
+		{System: "Windows", Release: "XP", Installations: 1},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Data.Systems = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Machines, ([]*platformShare{
+		{Machine: "x86", Installations: 3},
+		{Machine: "AMD64", Installations: 2},
+		{Installations: 1},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Data.Machines = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Platforms[0], (&platformShare{"Windows", "7", "AMD64", 2}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Data.Platforms[0] = %v, want %v", got, want)
+	}
+	if got, want := len(body.Data.Platforms), 5; got != want {
+		t.Errorf("len(body.Data.Platforms) = %d, want %d", got, want)
+	}
+}
+
+func TestDosvoxVersionStats(t *testing.T) {
+	store := NewMemoryStore()
+	for i, version := range []string{"5.0", "4.0 BETA", "5.0", "", "10.1"} {
+		var info map[string]string
+		if version != "" {
+			info = map[string]string{"version": version}
+		}
+		inst := NewInstallation(fmt.Sprintf("machine-%d", i), "1.0", info, nil)
+		if err := store.InsertInstallation(inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := store.Installations["machine-0"].DosvoxVersion, "5.0"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	r, _ := http.NewRequest("GET", "/1/stats/dosvox-versions", nil)
+	w := httptest.NewRecorder()
+	DosvoxVersionStatsHandler(w, r, &Context{Store: store})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	var body struct {
+		Data dosvoxVersionStats
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Data.From.IsZero(), true; got != want {
+		t.Errorf("body.Data.From.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Versions, ([]*dosvoxVersionShare{
+		{"", 1}, {"4.0 BETA", 1}, {"5.0", 2}, {"10.1", 1},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Data.Versions = %v, want %v", got, want)
+	}
+}
+
+func TestInstallationStats(t *testing.T) {
+	store := NewMemoryStore()
+	desktop := map[string]string{"node": "Desktop", "processor": "x86 Family 6", "system": "Windows"}
+	for i, info := range []map[string]string{
+		desktop,
+		{"node": "desktop ", "processor": "x86 Family 6", "system": "Windows"},
+		{"node": "laptop", "processor": "x86 Family 6", "system": "Windows"},
+		{"processor": "x86 Family 6", "system": "Windows"},
+		{"processor": "x86 Family 6", "system": "Windows"},
+	} {
+		if err := store.InsertInstallation(NewInstallation(fmt.Sprintf("machine-%d", i), "1.0", nil, info)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, pattern := store.Installations["machine-0"].Fingerprint, "[0-9a-f]{32}"; !fullMatch(pattern, got) {
+		t.Errorf("got %q, want a match of %q", got, pattern)
+	}
+	if got, want := store.Installations["machine-3"].Fingerprint, ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	r, _ := http.NewRequest("GET", "/1/stats/installations", nil)
+	w := httptest.NewRecorder()
+	InstallationStatsHandler(w, r, &Context{Store: store})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	var body struct {
+		Data installationStats
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Data.Machines, 5; got != want {
+		t.Errorf("body.Data.Machines = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Fingerprints, 4; got != want {
+		t.Errorf("body.Data.Fingerprints = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Reinstalls, 1; got != want {
+		t.Errorf("body.Data.Reinstalls = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Uninstalled, 0; got != want {
+		t.Errorf("body.Data.Uninstalled = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Active, 4; got != want {
+		t.Errorf("body.Data.Active = %v, want %v", got, want)
+	}
+
+	// A machine stays active while one of its installations is.
+	if err := store.UninstallInstallation("machine-0", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UninstallInstallation("machine-3", "Not using it"); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	InstallationStatsHandler(w, r, &Context{Store: store})
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Data.Machines, 5; got != want {
+		t.Errorf("body.Data.Machines = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Uninstalled, 2; got != want {
+		t.Errorf("body.Data.Uninstalled = %v, want %v", got, want)
+	}
+	if got, want := body.Data.Active, 3; got != want {
+		t.Errorf("body.Data.Active = %v, want %v", got, want)
+	}
+}
+
+func TestConditionalStats(t *testing.T) {
+	store := NewMemoryStore()
+	sess := NewSession("user@example.com", "machine", "1.0", nil)
+	sess.CreatedAt = time.Date(2014, 5, 1, 10, 0, 0, 0, time.UTC)
+	if err := store.InsertSession(sess); err != nil {
+		t.Fatal(err)
+	}
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/1/stats/versions?from=2014-05-01&to=2014-05-04", nil)
+		r.Header = header
+		w := httptest.NewRecorder()
+		VersionStatsHandler(w, r, &Context{Store: store})
+		return w
+	}
+	w := get(http.Header{})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	etag := w.Header().Get("ETag")
+	if got, pattern := etag, `W/"[0-9a-f]{16}"`; !fullMatch(pattern, got) {
+		t.Errorf("etag = %q, want a match of %q", got, pattern)
+	}
+	if got, want := w.Header().Get("Last-Modified"), "Thu, 01 May 2014 10:00:00 GMT"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	w = get(http.Header{"If-None-Match": {`"other", ` + etag}})
+	if got, want := w.Code, http.StatusNotModified; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.Len(), 0; got != want {
+		t.Errorf("w.Body.Len() = %v, want %v", got, want)
+	}
+	w = get(http.Header{"If-Modified-Since": {"Thu, 01 May 2014 10:00:00 GMT"}})
+	if got, want := w.Code, http.StatusNotModified; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+
+	// New rollups change the tag, like new sessions.
+	rollup := NewRollup(RollupHour, time.Date(2014, 5, 1, 10, 0, 0, 0, time.UTC))
+	rollup.ComputedAt = time.Date(2014, 5, 1, 11, 0, 0, 0, time.UTC)
+	if err := store.UpsertRollup(rollup); err != nil {
+		t.Fatal(err)
+	}
+	w = get(http.Header{"If-None-Match": {etag}})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("ETag"), etag; got == want {
+		t.Errorf("got %v, want anything but %v", got, want)
+	}
+	w = get(http.Header{"If-Modified-Since": {"Thu, 01 May 2014 10:00:00 GMT"}})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+}
+
+func TestClientNetwork(t *testing.T) {
+	for addr, want := range map[string]string{
+		"203.0.113.7:51234":          "203.0.113.0/24",
+		"203.0.113.200":              "203.0.113.0/24",
+		"[2001:db8:1:2::7]:51234":    "2001:db8:1::/48",
+		"[::ffff:203.0.113.7]:51234": "203.0.113.0/24",
+		"localhost:51234":            "",
+	} {
+		if got, want := clientNetwork(addr), want; got != want {
+			t.Errorf("%s: clientNetwork(addr) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	store := NewMemoryStore()
+	for i, addr := range []string{"203.0.113.7:1", "203.0.113.8:1", "198.51.100.1:1"} {
+		r, _ := http.NewRequest("POST", "/1/installation/new?secret=x", nil)
+		r.Header.Set("User-Agent", "XMPPVOX/1.0")
+		r.Header.Set("Cookie", "session=secret")
+		r.RemoteAddr = addr
+		inst := NewInstallation(fmt.Sprintf("machine-%d", i), "1.0", nil, nil)
+		inst.Request, inst.Network = installationRequest(r), clientNetwork(addr)
+		inst.UserAgent = r.UserAgent()
+		if err := store.InsertInstallation(inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inst, _ := store.FindInstallation("machine-0")
+	if got, want := inst.Request.Header, (http.Header{"User-Agent": {"XMPPVOX/1.0"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("inst.Request.Header = %v, want %v", got, want)
+	}
+	if got, want := inst.Request.URL.String(), "/1/installation/new"; got != want {
+		t.Errorf("inst.Request.URL.String() = %v, want %v", got, want)
+	}
+	// Registered before requests were kept.
+	if err := store.InsertInstallation(NewInstallation("old", "1.0", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) *clientStats {
+		r, _ := http.NewRequest("GET", "/admin/1/stats/clients?"+query, nil)
+		w := httptest.NewRecorder()
+		ClientStatsHandler(w, r, &Context{Store: store})
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("w.Code = %v, want %v", got, want)
+		}
+		var body struct {
+			Data clientStats
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return &body.Data
+	}
+	if got, want := get("").Clients, ([]*clientShare{{"XMPPVOX/1.0", 3}, {"", 1}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	stats := get("by=network&limit=2")
+	if got, want := stats.By, ClientsByNetwork; got != want {
+		t.Errorf("stats.By = %v, want %v", got, want)
+	}
+	if got, want := stats.Clients, ([]*clientShare{{"203.0.113.0/24", 2}, {"", 1}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("stats.Clients = %v, want %v", got, want)
+	}
+
+	r, _ := http.NewRequest("GET", "/admin/1/stats/clients?by=jid&limit=0", nil)
+	w := httptest.NewRecorder()
+	ClientStatsHandler(w, r, &Context{Store: store})
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Invalid by, expected user_agent or network\nInvalid limit, expected a number from 1 to 1000\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+}
+
+func TestServerStats(t *testing.T) {
+	store := NewMemoryStore()
+	for _, x := range []struct{ jid, machineId, server string }{
+		{"a@jabber.org", "machine-a", "jabber.org"},
+		{"a@jabber.org", "machine-b", "jabber.org"},
+		{"a@jabber.org", "machine-b", "jabber.org"},
+		{"b@example.org", "machine-c", "xmpp.example.org"},
+		{"c@example.org", "machine-c", ""},
+	} {
+		session := NewSession(x.jid, x.machineId, "1.0", nil)
+		session.XMPPServer = x.server
+		if err := store.InsertSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		r, _ := http.NewRequest("GET", "/1/stats/servers?"+query, nil)
+		w := httptest.NewRecorder()
+		ServerStatsHandler(w, r, &Context{Store: store})
+		return w
+	}
+	w := get("limit=2")
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	var body struct {
+		Data serverStats
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Data.Servers, ([]*serverShare{{"jabber.org", 3, 1, 2}, {"", 1, 1, 1}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Data.Servers = %v, want %v", got, want)
+	}
+
+	w = get("limit=1001")
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "Invalid limit, expected a number from 1 to 1000\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	store := NewMemoryStore()
+	for _, x := range []struct {
+		region string
+		rtts   []int
+	}{
+		{"BR", []int{100, 200}},
+		{"BR", []int{50}},
+		{"BR", []int{400}},
+		{"PT", []int{30}},
+		{"BR", nil},
+		{"", []int{70}},
+	} {
+		session := NewSession("user@server.org", "machine", "1.0", nil)
+		session.Region = x.region
+		if err := store.InsertSession(session); err != nil {
+			t.Fatal(err)
+		}
+		for _, ms := range x.rtts {
+			if err := store.PingSession(&Session{Id: session.Id, MachineId: session.MachineId,
+				Activity: &SessionActivity{RTT: newRTTSummary(ms)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	r, _ := http.NewRequest("GET", "/1/stats/latency", nil)
+	w := httptest.NewRecorder()
+	LatencyStatsHandler(w, r, &Context{Store: store})
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("w.Code = %v, want %v", got, want)
+	}
+	var body struct {
+		Data latencyStats
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := body.Data.Regions, ([]*regionLatency{
+		{Region: "BR", Sessions: 3, Samples: 4, MeanMs: 187.5, P50Ms: 150, P90Ms: 400, MaxMs: 400},
+		{Region: "", Sessions: 1, Samples: 1, MeanMs: 70, P50Ms: 70, P90Ms: 70, MaxMs: 70},
+		{Region: "PT", Sessions: 1, Samples: 1, MeanMs: 30, P50Ms: 30, P90Ms: 30, MaxMs: 30},
+	}); !reflect.DeepEqual(got, want) {
+		t.Errorf("body.Data.Regions = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package tracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// statusCacheTTL is how long /status reuses what it read from storage.
+const statusCacheTTL = 5 * time.Second
+
+// trackerStatus is the state of the tracker reported by /status.
+type trackerStatus struct {
+	StartedAt time.Time `json:"started_at"`
+	Uptime    float64   `json:"uptime_seconds"`
+	Version   string    `json:"version"`
+	Commit    string    `json:"commit,omitempty"`
+	// Storage is the backend, "mongodb" or "memory" in mock mode.
+	Storage       string  `json:"storage"`
+	OpenSessions  int     `json:"open_sessions"`
+	LastReaperRun *JobRun `json:"last_reaper_run"`
+	// AsOf is when the figures from storage were read.
+	AsOf time.Time `json:"as_of"`
+}
+
+// statusCache holds the last status a Server read from storage, so that
+// probes polling /status do not hit the database on every request. It is
+// safe for concurrent use.
+type statusCache struct {
+	sync.Mutex
+	status *trackerStatus
+}
+
+// get returns the status cached, reading it from store once it is older
+// than statusCacheTTL.
+func (sc *statusCache) get(store Storage, now time.Time) (*trackerStatus, error) {
+	sc.Lock()
+	defer sc.Unlock()
+	if sc.status == nil || now.Sub(sc.status.AsOf) >= statusCacheTTL {
+		st, err := readStatus(store, now)
+		if err != nil {
+			return nil, err
+		}
+		sc.status = st
+	}
+	return sc.status, nil
+}
+
+// storageBackend names the backend under the wrappers of store.
+func storageBackend(store Storage) string {
+	for {
+		switch s := store.(type) {
+		case *meteredStore:
+			store = s.Storage
+		case *auditedStore:
+			store = s.Storage
+		case *cachedStore:
+			store = s.Storage
+		case *MongoStore:
+			return "mongodb"
+		case *MemoryStore:
+			return "memory"
+		default:
+			return "other"
+		}
+	}
+}
+
+// readStatus reads the status of the tracker from store.
+func readStatus(store Storage, now time.Time) (*trackerStatus, error) {
+	open, err := store.CountAllOpenSessions()
+	if err != nil {
+		return nil, err
+	}
+	runs, err := store.JobRuns(reaperJob.Name, 1)
+	if err != nil {
+		return nil, err
+	}
+	st := &trackerStatus{Version: Version, Commit: Commit, Storage: storageBackend(store), OpenSessions: open, AsOf: now}
+	if len(runs) > 0 {
+		st.LastReaperRun = runs[0]
+	}
+	return st, nil
+}
+
+// TrackerStatusHandler reports the build, uptime and storage of the
+// tracker as JSON, with what it reads from storage cached for
+// statusCacheTTL by its server, if any.
+func TrackerStatusHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	var st *trackerStatus
+	var err error
+	if c.Server != nil && c.Server.status != nil {
+		st, err = c.Server.status.get(c.Store, now)
+	} else {
+		st, err = readStatus(c.Store, now)
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to read the status"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	out := *st
+	out.StartedAt, out.Uptime = runtimeStats.started, now.Sub(runtimeStats.started).Seconds()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(&out)
+}
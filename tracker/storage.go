@@ -0,0 +1,1788 @@
+package tracker
+
+import (
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"time"
+)
+
+// Storage stores the models of the tracker. MongoStore is the storage of
+// production and MemoryStore that of tests and mock mode.
+type Storage interface {
+	InsertInstallation(*Installation) error
+	// PingInstallation records a heartbeat of the installation of a machine
+	// id running xmppvoxVersion, or returns mgo.ErrNotFound.
+	PingInstallation(machineId, xmppvoxVersion string) error
+	// UninstallInstallation marks the installation of a machine id as
+	// uninstalled for reason, or returns mgo.ErrNotFound.
+	UninstallInstallation(machineId, reason string) error
+	InsertSession(*Session) error
+	// CloseSession closes an open session of s.MachineId,
+	// filling s with the closed session.
+	CloseSession(*Session) error
+	// PingSession refreshes the last ping of an open session of
+	// s.MachineId to now, or to s.LastPing if set and later than the
+	// current one, as for pings replayed from the write queue.
+	PingSession(*Session) error
+	// TransferSession moves the open session of s.Id and s.MachineId to
+	// the machine to, recording the transfer and counting it as a ping,
+	// and fills s with the transferred session.
+	TransferSession(s *Session, to string) error
+	// UpdateSessionMetadata sets the keys of s.Metadata in the metadata of
+	// the open session of s.Id and s.MachineId, removing those with an empty
+	// value and counting it as a ping, or returns mgo.ErrNotFound.
+	UpdateSessionMetadata(s *Session) error
+	// ReopenSession reopens a session closed at or after closedSince,
+	// filling s with the session as it was before being reopened.
+	ReopenSession(s *Session, closedSince time.Time) error
+	// ResumeSession reopens the latest session of s.JID on s.MachineId if it
+	// is resumable since closedSince, filling s with the session as it was
+	// before being reopened.
+	ResumeSession(s *Session, closedSince time.Time) error
+	InsertAuditEntry(*AuditEntry) error
+	// EachSession calls fn for every session created within w after skipping skip,
+	// oldest first, stopping at the first error. fn can be slow, as when streaming to a slow client.
+	EachSession(w TimeWindow, skip int, fn func(*Session) error) error
+	// EachInstallation is like EachSession, for installations.
+	EachInstallation(w TimeWindow, skip int, fn func(*Installation) error) error
+	// ExpireSessions closes the open sessions not created nor pinged since before,
+	// returning those closed, see closeSessions.
+	ExpireSessions(before time.Time) ([]*Session, error)
+	// CloseSupersededSessions closes the open sessions of the jid and
+	// machine id of s, other than s, returning those closed.
+	CloseSupersededSessions(s *Session) ([]*Session, error)
+	// CountOpenSessions counts the open sessions of a machine.
+	CountOpenSessions(machineId string) (int, error)
+	// CountAllOpenSessions counts the open sessions of every machine.
+	CountAllOpenSessions() (int, error)
+	// CloseExcessSessions closes the open sessions of s.MachineId other
+	// than s, oldest first, until keep of them are left open, with reason
+	// ClosedOverLimit, returning those closed.
+	CloseExcessSessions(s *Session, keep int) ([]*Session, error)
+	// FindSession returns the session with an id or mgo.ErrNotFound.
+	FindSession(id SessionId) (*Session, error)
+	// FindSessionByAlias returns the session with an alias or mgo.ErrNotFound.
+	FindSessionByAlias(alias string) (*Session, error)
+	// RecordCrash adds a crash report to the group of its signature,
+	// creating the group with the traceback if it is the first report.
+	RecordCrash(signature, traceback string, c *Crash) error
+	// CrashGroups returns up to limit crash groups, most recently seen first.
+	CrashGroups(limit int) ([]*CrashGroup, error)
+	InsertEvent(*Event) error
+	InsertFeedback(*Feedback) error
+	// RecentFeedback returns up to limit feedback, newest first, after skipping skip.
+	RecentFeedback(skip, limit int) ([]*Feedback, error)
+	// EachFeedback is like EachSession, for feedback.
+	EachFeedback(w TimeWindow, skip int, fn func(*Feedback) error) error
+	// NewestActivity returns when the newest session or installation was created,
+	// the time as of which statistics computed from them are up to date.
+	NewestActivity() (time.Time, error)
+	InsertJobRun(*JobRun) error
+	// JobRuns returns up to limit runs of a job, most recent first.
+	JobRuns(job string, limit int) ([]*JobRun, error)
+	// OpenSessions returns up to limit open sessions, newest first, after skipping skip.
+	OpenSessions(skip, limit int) ([]*Session, error)
+	// RecentInstallations returns up to limit installations, newest first, after skipping skip.
+	RecentInstallations(skip, limit int) ([]*Installation, error)
+	// TaggedInstallations is like RecentInstallations, for the installations
+	// tagged with tag.
+	TaggedInstallations(tag string, skip, limit int) ([]*Installation, error)
+	// MachineSessions returns up to limit sessions of a machine, open or closed,
+	// newest first, after skipping skip. A tag other than "" only returns the
+	// sessions tagged with it.
+	MachineSessions(machineId, tag string, skip, limit int) ([]*Session, error)
+	// UserSessions returns up to limit sessions of a jid created within window,
+	// across machines, newest first, after skipping skip. A tag filters them
+	// like in MachineSessions.
+	UserSessions(jid string, window TimeWindow, tag string, skip, limit int) ([]*Session, error)
+	// SearchSessions returns up to limit sessions matching q, newest first,
+	// after skipping skip.
+	SearchSessions(q *SessionQuery, skip, limit int) ([]*Session, error)
+	// TagSession adds tag to a session, or with remove removes it, or returns
+	// mgo.ErrNotFound. Adding a tag twice keeps one.
+	TagSession(id SessionId, tag string, remove bool) error
+	// TagInstallation is like TagSession, for the installation of a machine id.
+	TagInstallation(machineId, tag string, remove bool) error
+	// AddSessionNote appends a note to a session or returns mgo.ErrNotFound.
+	AddSessionNote(id SessionId, n *Note) error
+	// AddInstallationNote is like AddSessionNote, for the installation of a machine id.
+	AddInstallationNote(machineId string, n *Note) error
+	// SessionsPerDay counts the sessions created on each UTC day since since,
+	// oldest first. Days without sessions are left out.
+	SessionsPerDay(since time.Time) ([]*DayCount, error)
+	// SessionVersionsPerDay counts the sessions of each xmppvox_version created
+	// on each UTC day from from until to, ordered by day and version.
+	SessionVersionsPerDay(from, to time.Time) ([]*VersionDayCount, error)
+	// InstallationVersionsPerDay is like SessionVersionsPerDay, for installations.
+	InstallationVersionsPerDay(from, to time.Time) ([]*VersionDayCount, error)
+	// InstallationPlatforms counts the installations created from from until
+	// to per system, release and machine, ordered by them. Installations
+	// registered before platforms were parsed count by their machine_info.
+	InstallationPlatforms(from, to time.Time) ([]*PlatformCount, error)
+	// InstallationDosvoxVersions is like InstallationPlatforms, per DOSVOX
+	// version, ordered by version as strings.
+	InstallationDosvoxVersions(from, to time.Time) ([]*VersionCount, error)
+	// InstallationUniques counts the distinct machines and fingerprints of
+	// the installations created from from until to.
+	InstallationUniques(from, to time.Time) (*UniqueInstallations, error)
+	// InstallationClients counts the installations created from from until
+	// to per value of by, ClientsByUserAgent or ClientsByNetwork, most
+	// first and then by value, up to limit of them. Installations
+	// registered before requests were kept count with an empty value.
+	InstallationClients(by string, from, to time.Time, limit int) ([]*ClientCount, error)
+	// SessionServers counts the sessions started from from until to per
+	// XMPP server, most first and then by server, up to limit of them.
+	// Sessions of clients that did not tell their server count with an
+	// empty server.
+	SessionServers(from, to time.Time, limit int) ([]*ServerCount, error)
+	// SessionLatencies returns the region and round-trip times of the
+	// sessions started from from until to whose pings reported any.
+	SessionLatencies(from, to time.Time) ([]*SessionLatency, error)
+	// ApplyRetention removes the data older than r allows as of now,
+	// returning how many documents it changed or removed. Backends that
+	// expire documents by themselves, like MongoDB with TTL indexes, are
+	// set up to do so and do not count them.
+	ApplyRetention(r Retention, now time.Time) (int, error)
+	// AggregateRollups computes from the sessions the rollups of the periods
+	// starting from from until to, ordered by start. Periods without
+	// sessions started or closed are left out.
+	AggregateRollups(period string, from, to time.Time) ([]*Rollup, error)
+	// UpsertRollup inserts a rollup or replaces the one with its id.
+	UpsertRollup(*Rollup) error
+	// Rollups returns the stored rollups of the periods starting from from
+	// until to, ordered by start.
+	Rollups(period string, from, to time.Time) ([]*Rollup, error)
+	// LatestRollup returns the stored rollup of a period with the latest
+	// start, or mgo.ErrNotFound.
+	LatestRollup(period string) (*Rollup, error)
+	InsertBlock(*Block) error
+	// RemoveBlock removes a block by id or returns mgo.ErrNotFound.
+	RemoveBlock(id bson.ObjectId) error
+	// Blocks returns all blocks, oldest first.
+	Blocks() ([]*Block, error)
+	// FindBlock returns the oldest block matching any of jid, machineId,
+	// xmppvoxVersion or remoteIP, or mgo.ErrNotFound.
+	FindBlock(jid, machineId, xmppvoxVersion, remoteIP string) (*Block, error)
+	// RaiseFlag inserts f, open, unless there is a flag with its kind,
+	// field and value, whose count, message and seen_at are updated
+	// instead, keeping its status.
+	RaiseFlag(f *Flag) error
+	// Flags returns the flags with a status, or all flags if status is
+	// empty, last seen first.
+	Flags(status string) ([]*Flag, error)
+	// FindFlag returns a flag by id or mgo.ErrNotFound.
+	FindFlag(id bson.ObjectId) (*Flag, error)
+	// ReviewFlag sets the status of an open flag and the block added from
+	// it, if any, or returns mgo.ErrNotFound if there is no such open flag.
+	ReviewFlag(id bson.ObjectId, status string, blockId bson.ObjectId) error
+	InsertAPIKey(*APIKey) error
+	// RevokeAPIKey revokes a key by id or returns mgo.ErrNotFound
+	// if there is no such key or it is already revoked.
+	RevokeAPIKey(id bson.ObjectId) error
+	// APIKeys returns all stored keys, oldest first.
+	APIKeys() ([]*APIKey, error)
+	// FindAPIKey returns the stored key, revoked or not, or mgo.ErrNotFound.
+	FindAPIKey(key string) (*APIKey, error)
+	InsertWebhook(*Webhook) error
+	// RemoveWebhook removes a webhook by id or returns mgo.ErrNotFound.
+	RemoveWebhook(id bson.ObjectId) error
+	// Webhooks returns all webhooks, oldest first.
+	Webhooks() ([]*Webhook, error)
+	// SetFeatureFlag stores f, replacing the flag of the same name if any.
+	SetFeatureFlag(f *FeatureFlag) error
+	// RemoveFeatureFlag removes a feature flag by name or returns mgo.ErrNotFound.
+	RemoveFeatureFlag(name string) error
+	// FeatureFlags returns all feature flags, by name.
+	FeatureFlags() ([]*FeatureFlag, error)
+	InsertRelease(*Release) error
+	// RemoveRelease removes a release by version or returns mgo.ErrNotFound.
+	RemoveRelease(version string) error
+	// Releases returns all releases, latest published first.
+	Releases() ([]*Release, error)
+	// FindRelease returns the release of a version or mgo.ErrNotFound.
+	FindRelease(version string) (*Release, error)
+	// AddReleaseArtifact adds a to the release of version, or returns
+	// mgo.ErrNotFound if there is no such release or it already has an
+	// artifact for the platform of a.
+	AddReleaseArtifact(version string, a *ReleaseArtifact) error
+	// CountDownload counts a download of the release of version as platform.
+	CountDownload(version, platform string) error
+	// FindInstallation returns the installation of a machine id or mgo.ErrNotFound.
+	FindInstallation(machineId string) (*Installation, error)
+	// ReplaceStubInstallation replaces the stub installation of
+	// i.MachineId with i, or returns mgo.ErrNotFound if there is none.
+	ReplaceStubInstallation(i *Installation) error
+	// SessionChanges returns the sessions created or closed within w.
+	SessionChanges(w TimeWindow) ([]*Session, error)
+	// OrphanSessions counts by machine id the sessions started from from
+	// until to by machines without an installation, most sessions first,
+	// returning at most limit machines.
+	OrphanSessions(from, to time.Time, limit int) ([]*OrphanCount, error)
+	InsertClaim(*Claim) error
+	// ClaimInstallation claims an unexpired code not claimed before for the
+	// installation of machineId, linking it to the ticket of the claim with
+	// debug capture until debugUntil. It returns the claim or mgo.ErrNotFound
+	// if there is no such code or installation.
+	ClaimInstallation(code, machineId string, debugUntil time.Time) (*Claim, error)
+	// CollectionStats returns the stats of every collection, by name.
+	CollectionStats() ([]*CollectionStats, error)
+	InsertStorageSnapshot(*StorageSnapshot) error
+	// StorageSnapshots returns the snapshots taken since since, oldest first.
+	StorageSnapshots(since time.Time) ([]*StorageSnapshot, error)
+	// EraseUser erases the sessions of a jid and the events and feedback of
+	// those sessions, or with anonymize strips them of what identifies the
+	// user, and removes their crash reports from the crash groups.
+	EraseUser(jid string, anonymize bool) (*Erasure, error)
+	// EraseMachine is like EraseUser, for the installation, sessions, events,
+	// feedback and crash reports of a machine. Anonymizing replaces the machine id
+	// with pseudonym.
+	EraseMachine(machineId string, anonymize bool, pseudonym string) (*Erasure, error)
+	// MissingIndexes lists the indexes needed by queries that do not exist yet.
+	MissingIndexes() ([]string, error)
+	EnsureIndexes() error
+	// BackfillClosedReason sets the closed_reason of the closed sessions
+	// without one to reason, or with dryRun only counts them.
+	BackfillClosedReason(reason string, dryRun bool) (int, error)
+	// BackfillFingerprints sets the fingerprint of the installations
+	// registered before fingerprints, with the keys needed, or with dryRun
+	// only counts them.
+	BackfillFingerprints(dryRun bool) (int, error)
+	// NormalizeSessionJIDs splits the resource out of the jids of sessions
+	// and lowercases their domain, as for new sessions, or with dryRun only
+	// counts the sessions to change. Invalid jids are left as they are.
+	NormalizeSessionJIDs(dryRun bool) (int, error)
+	// Migrations returns the applied migrations, by version.
+	Migrations() ([]*MigrationRun, error)
+	InsertMigration(*MigrationRun) error
+	// LegacySessions returns up to limit closed sessions after the given
+	// _id, by _id, without last_ping, closed_reason or duration.
+	LegacySessions(after SessionId, limit int) ([]*Session, error)
+	// BackfillSession sets the last_ping, closed_reason and duration of
+	// s, unless s was reopened since it was read.
+	BackfillSession(s *Session) error
+	// Checkpoint returns the checkpoint of name or mgo.ErrNotFound.
+	Checkpoint(name string) (*Checkpoint, error)
+	SaveCheckpoint(*Checkpoint) error
+	// AcquireLease gives the lease of name to holder until now+ttl, if it
+	// is free, expired or already held by holder, and reports whether it did.
+	AcquireLease(name, holder string, now time.Time, ttl time.Duration) (bool, error)
+	// CountRate adds an event to the counter of key in the window starting
+	// at start, which is forgotten after end, and returns the new count.
+	CountRate(key string, start, end time.Time) (int, error)
+}
+
+// Erasure counts the documents erased or anonymized by a data removal request.
+type Erasure struct {
+	Installations int `json:"installations"`
+	Sessions      int `json:"sessions"`
+	Events        int `json:"events"`
+	Feedback      int `json:"feedback"`
+	// CrashGroups is how many crash groups had crash reports removed.
+	CrashGroups int `json:"crash_groups"`
+}
+
+// anonymizedSessionFields are removed from anonymized sessions, leaving
+// the timing and version of the session for statistics. Notes are free
+// text, which may well name the user, as the resource may name their device.
+var anonymizedSessionFields = bson.M{"req": "", "alias": "", "secret": "", "notes": "", "resource": ""}
+
+type MongoStore struct {
+	*mgo.Database
+}
+
+// mongoIndexes lists, per collection, the indexes needed by MongoStore queries.
+var mongoIndexes = []struct {
+	Collection string
+	Index      mgo.Index
+}{
+	{"sessions", mgo.Index{Key: []string{"machine_id", "closed_at"}}},
+	{"sessions", mgo.Index{Key: []string{"created_at", "_id"}}},
+	{"sessions", mgo.Index{Key: []string{"jid"}}},
+	{"sessions", mgo.Index{Key: []string{"machine_id", "-created_at"}}},
+	{"sessions", mgo.Index{Key: []string{"jid", "-created_at"}}},
+	{"sessions", mgo.Index{Key: []string{"alias"}, Unique: true, Sparse: true}},
+	{"sessions", mgo.Index{Key: []string{"closed_at"}}},
+	{"installations", mgo.Index{Key: []string{"created_at", "_id"}}},
+	{"installations", mgo.Index{Key: []string{"dosvox_ver"}, Sparse: true}},
+	{"installations", mgo.Index{Key: []string{"tags", "-created_at"}}},
+	{"events", mgo.Index{Key: []string{"name", "created_at"}}},
+	{"feedback", mgo.Index{Key: []string{"created_at", "_id"}}},
+	{"feedback", mgo.Index{Key: []string{"machine_id"}}},
+	{"crashes", mgo.Index{Key: []string{"-last_seen"}}},
+	{"job_runs", mgo.Index{Key: []string{"job", "-started_at"}}},
+	{"blocks", mgo.Index{Key: []string{"field", "value"}}},
+	{"flags", mgo.Index{Key: []string{"kind", "field", "value"}, Unique: true}},
+	{"flags", mgo.Index{Key: []string{"status", "-seen_at"}}},
+	{"api_keys", mgo.Index{Key: []string{"key"}, Unique: true}},
+	{"storage_snapshots", mgo.Index{Key: []string{"at"}}},
+	{"rollups", mgo.Index{Key: []string{"period", "start"}}},
+	{"rate_counters", mgo.Index{Key: []string{"expires_at"}, ExpireAfter: time.Second}},
+}
+
+func (m *MongoStore) MissingIndexes() ([]string, error) {
+	var missing []string
+	existing := make(map[string]map[string]bool)
+	for _, ci := range mongoIndexes {
+		if existing[ci.Collection] == nil {
+			existing[ci.Collection] = make(map[string]bool)
+			indexes, err := m.C(ci.Collection).Indexes()
+			if err != nil {
+				return nil, err
+			}
+			for _, index := range indexes {
+				existing[ci.Collection][strings.Join(index.Key, ",")] = true
+			}
+		}
+		if !existing[ci.Collection][strings.Join(ci.Index.Key, ",")] {
+			missing = append(missing, fmt.Sprintf("%s %v", ci.Collection, ci.Index.Key))
+		}
+	}
+	return missing, nil
+}
+
+// EnsureIndexes creates missing indexes. Existing indexes are left untouched;
+// an existing index with the same key but different options is reported
+// in the returned error and the remaining indexes are still processed.
+func (m *MongoStore) EnsureIndexes() error {
+	var failed []string
+	for _, ci := range mongoIndexes {
+		index := ci.Index
+		// Build in the background so that a large collection is not locked.
+		index.Background = true
+		if err := m.C(ci.Collection).EnsureIndex(index); err != nil {
+			failed = append(failed, fmt.Sprintf("%s %v: %v", ci.Collection, ci.Index.Key, err))
+		}
+	}
+	if failed != nil {
+		return fmt.Errorf("failed to ensure indexes: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func (m *MongoStore) InsertInstallation(i *Installation) error {
+	return m.C("installations").Insert(i)
+}
+
+func (m *MongoStore) InsertSession(s *Session) error {
+	return m.C("sessions").Insert(s)
+}
+
+func (m *MongoStore) CloseSession(s *Session) error {
+	updateClosedTime := mgo.Change{
+		Update:    bson.M{"$set": bson.M{"closed_at": bson.Now(), "closed_reason": s.ClosedReason}},
+		ReturnNew: true,
+	}
+	_, err := m.C("sessions").Find(bson.M{
+		"_id":        s.Id,
+		"machine_id": s.MachineId,
+		"closed_at":  time.Time{},
+	}).Apply(updateClosedTime, &s)
+	return err
+}
+
+// pingUpdate is the update of a ping of s, refreshing last_ping and
+// accumulating the activity of s, if any.
+func pingUpdate(s *Session) bson.M {
+	set := bson.M{}
+	update := bson.M{"$set": set}
+	if s.LastPing.IsZero() {
+		set["last_ping"] = bson.Now()
+	} else {
+		update["$max"] = bson.M{"last_ping": s.LastPing}
+	}
+	if a := s.Activity; a != nil {
+		update["$inc"] = bson.M{
+			"activity.messages_sent":     a.MessagesSent,
+			"activity.messages_received": a.MessagesReceived,
+		}
+		if a.ContactsOnline != nil {
+			set["activity.contacts_online"] = *a.ContactsOnline
+		}
+		if rtt := a.RTT; rtt != nil {
+			inc := update["$inc"].(bson.M)
+			inc["activity.rtt.samples"], inc["activity.rtt.total_ms"] = rtt.Samples, rtt.TotalMs
+			update["$min"] = bson.M{"activity.rtt.min_ms": rtt.MinMs}
+			max, _ := update["$max"].(bson.M)
+			if max == nil {
+				max = bson.M{}
+				update["$max"] = max
+			}
+			max["activity.rtt.max_ms"] = rtt.MaxMs
+			set["activity.rtt.last_ms"] = rtt.LastMs
+		}
+	}
+	if len(set) == 0 {
+		delete(update, "$set")
+	}
+	return update
+}
+
+func (m *MongoStore) PingSession(s *Session) error {
+	updateLastPing := mgo.Change{
+		Update:    pingUpdate(s),
+		ReturnNew: true,
+	}
+	_, err := m.C("sessions").Find(bson.M{
+		"_id":        s.Id,
+		"machine_id": s.MachineId,
+		"closed_at":  time.Time{},
+	}).Apply(updateLastPing, &s)
+	return err
+}
+
+func (m *MongoStore) UpdateSessionMetadata(s *Session) error {
+	set, unset := bson.M{"last_ping": bson.Now()}, bson.M{}
+	for key, value := range s.Metadata {
+		if value == "" {
+			unset["metadata."+key] = ""
+		} else {
+			set["metadata."+key] = value
+		}
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return m.C("sessions").Update(bson.M{
+		"_id":        s.Id,
+		"machine_id": s.MachineId,
+		"closed_at":  time.Time{},
+	}, update)
+}
+
+func (m *MongoStore) TransferSession(s *Session, to string) error {
+	t := &SessionTransfer{From: s.MachineId, To: to, At: bson.Now()}
+	change := mgo.Change{
+		Update: bson.M{
+			"$set":  bson.M{"machine_id": to, "last_ping": t.At},
+			"$push": bson.M{"transfers": t},
+		},
+		ReturnNew: true,
+	}
+	_, err := m.C("sessions").Find(bson.M{
+		"_id":        s.Id,
+		"machine_id": s.MachineId,
+		"closed_at":  time.Time{},
+	}).Apply(change, s)
+	return err
+}
+
+// reopenChange reopens a closed session. Refreshing last_ping keeps a
+// reopened session from looking stale.
+func reopenChange() mgo.Change {
+	return mgo.Change{
+		Update: bson.M{
+			"$set":   bson.M{"closed_at": time.Time{}, "last_ping": bson.Now()},
+			"$unset": bson.M{"closed_reason": ""},
+		},
+	}
+}
+
+func (m *MongoStore) ReopenSession(s *Session, closedSince time.Time) error {
+	_, err := m.C("sessions").Find(bson.M{
+		"_id":       s.Id,
+		"closed_at": bson.M{"$gte": closedSince},
+	}).Apply(reopenChange(), s)
+	return err
+}
+
+func (m *MongoStore) ResumeSession(s *Session, closedSince time.Time) error {
+	latest := &Session{}
+	err := m.C("sessions").Find(bson.M{
+		"machine_id": s.MachineId,
+		"jid":        s.JID,
+	}).Sort("-created_at").One(latest)
+	if err != nil {
+		return err
+	}
+	if !resumable(latest, closedSince) {
+		return mgo.ErrNotFound
+	}
+	// Matching closed_at keeps a session reopened in the meantime from
+	// being resumed twice.
+	_, err = m.C("sessions").Find(bson.M{
+		"_id":       latest.Id,
+		"closed_at": latest.ClosedAt,
+	}).Apply(reopenChange(), s)
+	return err
+}
+
+func (m *MongoStore) InsertAuditEntry(a *AuditEntry) error {
+	return m.C("audit").Insert(a)
+}
+
+// iterBatchSize is how many documents MongoStore.each reads at a time.
+const iterBatchSize = 500
+
+// each calls fn with every document of a collection created within w,
+// ordered by created_at and _id, after skipping skip. Instead of keeping
+// a cursor open, which the server times out if fn is slow, it reads
+// batches of documents following the last one read.
+func (m *MongoStore) each(collection string, w TimeWindow, skip int, fn func(bson.Raw) error) error {
+	window := bson.M{}
+	if created := windowQuery(w); created != nil {
+		window["created_at"] = created
+	}
+	query := window
+	for first := true; ; first = false {
+		q := m.C(collection).Find(query).Sort("created_at", "_id").Limit(iterBatchSize)
+		if first {
+			q = q.Skip(skip)
+		}
+		var batch []bson.Raw
+		if err := q.All(&batch); err != nil {
+			return err
+		}
+		for _, raw := range batch {
+			if err := fn(raw); err != nil {
+				return err
+			}
+		}
+		if len(batch) < iterBatchSize {
+			return nil
+		}
+		var last struct {
+			Id        interface{} `bson:"_id"`
+			CreatedAt time.Time   `bson:"created_at"`
+		}
+		if err := batch[len(batch)-1].Unmarshal(&last); err != nil {
+			return err
+		}
+		query = bson.M{"$and": []bson.M{window, {"$or": []bson.M{
+			{"created_at": bson.M{"$gt": last.CreatedAt}},
+			{"created_at": last.CreatedAt, "_id": bson.M{"$gt": last.Id}},
+		}}}}
+	}
+}
+
+func (m *MongoStore) EachSession(w TimeWindow, skip int, fn func(*Session) error) error {
+	return m.each("sessions", w, skip, func(raw bson.Raw) error {
+		s := &Session{}
+		if err := raw.Unmarshal(s); err != nil {
+			return err
+		}
+		return fn(s)
+	})
+}
+
+func (m *MongoStore) EachInstallation(w TimeWindow, skip int, fn func(*Installation) error) error {
+	return m.each("installations", w, skip, func(raw bson.Raw) error {
+		i := &Installation{}
+		if err := raw.Unmarshal(i); err != nil {
+			return err
+		}
+		return fn(i)
+	})
+}
+
+// closedSessionFields are the fields of the sessions returned by
+// closeSessions, those of webhook deliveries.
+var closedSessionFields = bson.M{
+	"alias": 1, "jid": 1, "machine_id": 1, "xmppvox_ver": 1, "project": 1,
+	"created_at": 1, "closed_at": 1, "closed_reason": 1,
+}
+
+// closeSessions closes the open sessions matching query with reason,
+// returning them with closedSessionFields. They are closed at the same
+// time, which tells them apart from those closed meanwhile by others.
+func (m *MongoStore) closeSessions(query bson.M, reason string) ([]*Session, error) {
+	now := bson.Now()
+	query["closed_at"] = time.Time{}
+	info, err := m.C("sessions").UpdateAll(query, bson.M{"$set": bson.M{"closed_at": now, "closed_reason": reason}})
+	if err != nil || info.Updated == 0 {
+		return nil, err
+	}
+	query["closed_at"], query["closed_reason"] = now, reason
+	var closed []*Session
+	err = m.C("sessions").Find(query).Select(closedSessionFields).All(&closed)
+	return closed, err
+}
+
+func (m *MongoStore) ExpireSessions(before time.Time) ([]*Session, error) {
+	// last_ping is either zero or later than created_at, so both being
+	// before the deadline means no sign of life since then.
+	return m.closeSessions(bson.M{
+		"created_at": bson.M{"$lt": before},
+		"last_ping":  bson.M{"$lt": before},
+	}, ClosedExpired)
+}
+
+func (m *MongoStore) CloseSupersededSessions(s *Session) ([]*Session, error) {
+	return m.closeSessions(bson.M{
+		"_id":        bson.M{"$ne": s.Id},
+		"machine_id": s.MachineId,
+		"jid":        s.JID,
+	}, ClosedSuperseded)
+}
+
+func (m *MongoStore) CountOpenSessions(machineId string) (int, error) {
+	return m.C("sessions").Find(bson.M{"machine_id": machineId, "closed_at": time.Time{}}).Count()
+}
+
+func (m *MongoStore) CountAllOpenSessions() (int, error) {
+	return m.C("sessions").Find(bson.M{"closed_at": time.Time{}}).Count()
+}
+
+func (m *MongoStore) CloseExcessSessions(s *Session, keep int) ([]*Session, error) {
+	var docs []struct {
+		Id SessionId `bson:"_id"`
+	}
+	err := m.C("sessions").Find(bson.M{
+		"_id":        bson.M{"$ne": s.Id},
+		"machine_id": s.MachineId,
+		"closed_at":  time.Time{},
+	}).Sort("-created_at").Skip(keep).Select(bson.M{"_id": 1}).All(&docs)
+	if err != nil || len(docs) == 0 {
+		return nil, err
+	}
+	ids := make([]SessionId, len(docs))
+	for i, d := range docs {
+		ids[i] = d.Id
+	}
+	return m.closeSessions(bson.M{"_id": bson.M{"$in": ids}}, ClosedOverLimit)
+}
+
+func (m *MongoStore) InsertJobRun(run *JobRun) error {
+	return m.C("job_runs").Insert(run)
+}
+
+func (m *MongoStore) JobRuns(job string, limit int) ([]*JobRun, error) {
+	var runs []*JobRun
+	err := m.C("job_runs").Find(bson.M{"job": job}).Sort("-started_at").Limit(limit).All(&runs)
+	return runs, err
+}
+
+func (m *MongoStore) FindSession(id SessionId) (*Session, error) {
+	s := &Session{}
+	if err := m.C("sessions").FindId(id).One(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (m *MongoStore) FindSessionByAlias(alias string) (*Session, error) {
+	s := &Session{}
+	err := m.C("sessions").Find(bson.M{"alias": alias}).One(s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (m *MongoStore) RecordCrash(signature, traceback string, c *Crash) error {
+	_, err := m.C("crashes").UpsertId(signature, bson.M{
+		"$setOnInsert": bson.M{"traceback": traceback, "first_seen": c.At},
+		"$set":         bson.M{"last_seen": c.At},
+		"$inc":         bson.M{"count": 1},
+		"$addToSet":    bson.M{"versions": c.XMPPVOXVersion},
+		"$push":        bson.M{"recent": bson.M{"$each": []*Crash{c}, "$slice": -maxCrashOccurrences}},
+	})
+	return err
+}
+
+func (m *MongoStore) CrashGroups(limit int) ([]*CrashGroup, error) {
+	var groups []*CrashGroup
+	err := m.C("crashes").Find(nil).Sort("-last_seen").Limit(limit).All(&groups)
+	return groups, err
+}
+
+func (m *MongoStore) InsertEvent(e *Event) error {
+	return m.C("events").Insert(e)
+}
+
+func (m *MongoStore) InsertFeedback(f *Feedback) error {
+	return m.C("feedback").Insert(f)
+}
+
+func (m *MongoStore) RecentFeedback(skip, limit int) ([]*Feedback, error) {
+	var feedback []*Feedback
+	err := m.C("feedback").Find(nil).Sort("-created_at").Skip(skip).Limit(limit).All(&feedback)
+	return feedback, err
+}
+
+func (m *MongoStore) EachFeedback(w TimeWindow, skip int, fn func(*Feedback) error) error {
+	return m.each("feedback", w, skip, func(raw bson.Raw) error {
+		f := &Feedback{}
+		if err := raw.Unmarshal(f); err != nil {
+			return err
+		}
+		return fn(f)
+	})
+}
+
+func (m *MongoStore) NewestActivity() (time.Time, error) {
+	var newest time.Time
+	for _, name := range []string{"sessions", "installations"} {
+		var doc struct {
+			CreatedAt time.Time `bson:"created_at"`
+		}
+		err := m.C(name).Find(nil).Select(bson.M{"created_at": 1}).Sort("-created_at").One(&doc)
+		if err != nil && err != mgo.ErrNotFound {
+			return time.Time{}, err
+		}
+		if doc.CreatedAt.After(newest) {
+			newest = doc.CreatedAt
+		}
+	}
+	return newest, nil
+}
+
+func (m *MongoStore) OpenSessions(skip, limit int) ([]*Session, error) {
+	var sessions []*Session
+	err := m.C("sessions").Find(bson.M{"closed_at": time.Time{}}).
+		Sort("-created_at").Skip(skip).Limit(limit).All(&sessions)
+	return sessions, err
+}
+
+func (m *MongoStore) RecentInstallations(skip, limit int) ([]*Installation, error) {
+	var installations []*Installation
+	err := m.C("installations").Find(nil).Sort("-created_at").Skip(skip).Limit(limit).All(&installations)
+	return installations, err
+}
+
+func (m *MongoStore) TaggedInstallations(tag string, skip, limit int) ([]*Installation, error) {
+	var installations []*Installation
+	err := m.C("installations").Find(bson.M{"tags": tag}).
+		Sort("-created_at").Skip(skip).Limit(limit).All(&installations)
+	return installations, err
+}
+
+func (m *MongoStore) MachineSessions(machineId, tag string, skip, limit int) ([]*Session, error) {
+	query := bson.M{"machine_id": machineId}
+	if tag != "" {
+		query["tags"] = tag
+	}
+	var sessions []*Session
+	err := m.C("sessions").Find(query).Sort("-created_at").Skip(skip).Limit(limit).All(&sessions)
+	return sessions, err
+}
+
+func (m *MongoStore) UserSessions(jid string, window TimeWindow, tag string, skip, limit int) ([]*Session, error) {
+	query := bson.M{"jid": jid}
+	if tag != "" {
+		query["tags"] = tag
+	}
+	if created := windowQuery(window); created != nil {
+		query["created_at"] = created
+	}
+	var sessions []*Session
+	err := m.C("sessions").Find(query).Sort("-created_at").Skip(skip).Limit(limit).All(&sessions)
+	return sessions, err
+}
+
+func (m *MongoStore) SessionChanges(w TimeWindow) ([]*Session, error) {
+	q := windowQuery(w)
+	var sessions []*Session
+	err := m.C("sessions").Find(bson.M{"$or": []bson.M{{"created_at": q}, {"closed_at": q}}}).All(&sessions)
+	return sessions, err
+}
+
+// windowQuery returns the query of the times within w, or nil if w is open
+// at both ends.
+func windowQuery(w TimeWindow) bson.M {
+	q := bson.M{}
+	if !w.From.IsZero() {
+		q["$gte"] = w.From
+	}
+	if !w.To.IsZero() {
+		q["$lt"] = w.To
+	}
+	if len(q) == 0 {
+		return nil
+	}
+	return q
+}
+
+// sessionSearch translates q into the query of MongoStore.SearchSessions.
+func sessionSearch(q *SessionQuery) bson.M {
+	query := bson.M{}
+	if strings.Contains(q.JID, "*") {
+		query["jid"] = bson.RegEx{Pattern: wildcardPattern(q.JID)}
+	} else if q.JID != "" {
+		query["jid"] = q.JID
+	}
+	for field, v := range map[string]string{
+		"machine_id":    q.MachineId,
+		"xmppvox_ver":   q.XMPPVOXVersion,
+		"closed_reason": q.ClosedReason,
+		"project":       q.Project,
+		"tags":          q.Tag,
+	} {
+		if v != "" {
+			query[field] = v
+		}
+	}
+	if created := windowQuery(q.Created); created != nil {
+		query["created_at"] = created
+	}
+	switch {
+	case q.Closed != (TimeWindow{}):
+		closed := windowQuery(q.Closed)
+		if _, ok := closed["$gte"]; !ok {
+			closed["$gt"] = time.Time{}
+		}
+		query["closed_at"] = closed
+	case q.Open != nil && *q.Open:
+		query["closed_at"] = time.Time{}
+	case q.Open != nil:
+		query["closed_at"] = bson.M{"$ne": time.Time{}}
+	}
+	return query
+}
+
+func (m *MongoStore) SearchSessions(q *SessionQuery, skip, limit int) ([]*Session, error) {
+	var sessions []*Session
+	err := m.C("sessions").Find(sessionSearch(q)).Sort("-created_at").Skip(skip).Limit(limit).All(&sessions)
+	return sessions, err
+}
+
+// tagUpdate adds tag to the tags of a document, or with remove removes it.
+func tagUpdate(tag string, remove bool) bson.M {
+	if remove {
+		return bson.M{"$pull": bson.M{"tags": tag}}
+	}
+	return bson.M{"$addToSet": bson.M{"tags": tag}}
+}
+
+func (m *MongoStore) TagSession(id SessionId, tag string, remove bool) error {
+	return m.C("sessions").UpdateId(id, tagUpdate(tag, remove))
+}
+
+func (m *MongoStore) TagInstallation(machineId, tag string, remove bool) error {
+	return m.C("installations").UpdateId(machineId, tagUpdate(tag, remove))
+}
+
+func (m *MongoStore) AddSessionNote(id SessionId, n *Note) error {
+	return m.C("sessions").UpdateId(id, bson.M{"$push": bson.M{"notes": n}})
+}
+
+func (m *MongoStore) AddInstallationNote(machineId string, n *Note) error {
+	return m.C("installations").UpdateId(machineId, bson.M{"$push": bson.M{"notes": n}})
+}
+
+func (m *MongoStore) EraseUser(jid string, anonymize bool) (*Erasure, error) {
+	var docs []struct {
+		Id SessionId `bson:"_id"`
+	}
+	if err := m.C("sessions").Find(bson.M{"jid": jid}).Select(bson.M{"_id": 1}).All(&docs); err != nil {
+		return nil, err
+	}
+	e := &Erasure{}
+	if len(docs) == 0 {
+		return e, nil
+	}
+	ids := make([]SessionId, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.Id
+	}
+	// Sessions go last, so that a failed erasure finds them again when retried.
+	info, err := m.C("crashes").UpdateAll(bson.M{"recent.session_id": bson.M{"$in": ids}},
+		bson.M{"$pull": bson.M{"recent": bson.M{"session_id": bson.M{"$in": ids}}}})
+	if err != nil {
+		return e, err
+	}
+	e.CrashGroups = info.Updated
+	events, sessions := m.C("events"), m.C("sessions")
+	if anonymize {
+		info, err = events.UpdateAll(bson.M{"session_id": bson.M{"$in": ids}}, bson.M{"$unset": bson.M{"props": ""}})
+	} else {
+		info, err = events.RemoveAll(bson.M{"session_id": bson.M{"$in": ids}})
+	}
+	if err != nil {
+		return e, err
+	}
+	e.Events = info.Updated + info.Removed
+	if anonymize {
+		info, err = m.C("feedback").UpdateAll(bson.M{"session_id": bson.M{"$in": ids}}, bson.M{"$unset": bson.M{"text": ""}})
+	} else {
+		info, err = m.C("feedback").RemoveAll(bson.M{"session_id": bson.M{"$in": ids}})
+	}
+	if err != nil {
+		return e, err
+	}
+	e.Feedback = info.Updated + info.Removed
+	if anonymize {
+		info, err = sessions.UpdateAll(bson.M{"_id": bson.M{"$in": ids}},
+			bson.M{"$set": bson.M{"jid": ""}, "$unset": anonymizedSessionFields})
+	} else {
+		info, err = sessions.RemoveAll(bson.M{"_id": bson.M{"$in": ids}})
+	}
+	if err != nil {
+		return e, err
+	}
+	e.Sessions = info.Updated + info.Removed
+	return e, nil
+}
+
+func (m *MongoStore) EraseMachine(machineId string, anonymize bool, pseudonym string) (*Erasure, error) {
+	e := &Erasure{}
+	info, err := m.C("crashes").UpdateAll(bson.M{"recent.machine_id": machineId},
+		bson.M{"$pull": bson.M{"recent": bson.M{"machine_id": machineId}}})
+	if err != nil {
+		return e, err
+	}
+	e.CrashGroups = info.Updated
+	events, sessions := m.C("events"), m.C("sessions")
+	if anonymize {
+		info, err = events.UpdateAll(bson.M{"machine_id": machineId},
+			bson.M{"$set": bson.M{"machine_id": pseudonym}, "$unset": bson.M{"props": ""}})
+	} else {
+		info, err = events.RemoveAll(bson.M{"machine_id": machineId})
+	}
+	if err != nil {
+		return e, err
+	}
+	e.Events = info.Updated + info.Removed
+	if anonymize {
+		info, err = m.C("feedback").UpdateAll(bson.M{"machine_id": machineId},
+			bson.M{"$set": bson.M{"machine_id": pseudonym}, "$unset": bson.M{"text": ""}})
+	} else {
+		info, err = m.C("feedback").RemoveAll(bson.M{"machine_id": machineId})
+	}
+	if err != nil {
+		return e, err
+	}
+	e.Feedback = info.Updated + info.Removed
+	if anonymize {
+		info, err = sessions.UpdateAll(bson.M{"machine_id": machineId},
+			bson.M{"$set": bson.M{"machine_id": pseudonym}, "$unset": anonymizedSessionFields})
+	} else {
+		info, err = sessions.RemoveAll(bson.M{"machine_id": machineId})
+	}
+	if err != nil {
+		return e, err
+	}
+	e.Sessions = info.Updated + info.Removed
+	// The sessions moved away from the machine keep no trace of it.
+	_, err = sessions.UpdateAll(bson.M{"$or": []bson.M{{"transfers.from": machineId}, {"transfers.to": machineId}}},
+		bson.M{"$unset": bson.M{"transfers": ""}})
+	if err != nil {
+		return e, err
+	}
+	installations := m.C("installations")
+	if anonymize {
+		// The machine id is the _id of installations, which cannot be
+		// updated, so the installation is inserted again under the pseudonym.
+		var i Installation
+		err = installations.FindId(machineId).One(&i)
+		if err == nil {
+			err = installations.Insert(anonymizedInstallation(&i, pseudonym))
+		}
+	}
+	if err == nil {
+		err = installations.RemoveId(machineId)
+	}
+	switch err {
+	case nil:
+		e.Installations = 1
+	case mgo.ErrNotFound:
+	default:
+		return e, err
+	}
+	return e, nil
+}
+
+// anonymizedInstallation returns a copy of i under pseudonym, without the
+// dosvox_info and machine_info that identify the user and the machine.
+func anonymizedInstallation(i *Installation, pseudonym string) *Installation {
+	return &Installation{
+		MachineId:      pseudonym,
+		XMPPVOXVersion: i.XMPPVOXVersion,
+		DosvoxVersion:  i.DosvoxVersion,
+		Platform:       i.Platform,
+		CreatedAt:      i.CreatedAt,
+		Tags:           i.Tags,
+	}
+}
+
+func (m *MongoStore) SessionsPerDay(since time.Time) ([]*DayCount, error) {
+	rows, err := m.countPerDay("sessions", bson.M{"$gte": since}, false)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]*DayCount, len(rows))
+	for i, row := range rows {
+		counts[i] = &DayCount{row.Day, row.Count}
+	}
+	return counts, nil
+}
+
+func (m *MongoStore) SessionVersionsPerDay(from, to time.Time) ([]*VersionDayCount, error) {
+	return m.countPerDay("sessions", bson.M{"$gte": from, "$lt": to}, true)
+}
+
+func (m *MongoStore) InstallationVersionsPerDay(from, to time.Time) ([]*VersionDayCount, error) {
+	return m.countPerDay("installations", bson.M{"$gte": from, "$lt": to}, true)
+}
+
+func (m *MongoStore) InstallationPlatforms(from, to time.Time) ([]*PlatformCount, error) {
+	field := func(name string) bson.M {
+		return bson.M{"$ifNull": []interface{}{"$platform." + name, "$machine_info." + name, ""}}
+	}
+	var rows []struct {
+		Id struct {
+			System  string `bson:"s"`
+			Release string `bson:"r"`
+			Machine string `bson:"m"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	err := m.C("installations").Pipe([]bson.M{
+		{"$match": bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": bson.M{
+			"_id":   bson.M{"s": field("system"), "r": field("release"), "m": field("machine")},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$sort": bson.D{{Name: "_id.s", Value: 1}, {Name: "_id.r", Value: 1}, {Name: "_id.m", Value: 1}}},
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]*PlatformCount, len(rows))
+	for i, row := range rows {
+		counts[i] = &PlatformCount{row.Id.System, row.Id.Release, row.Id.Machine, row.Count}
+	}
+	return counts, nil
+}
+
+func (m *MongoStore) InstallationUniques(from, to time.Time) (*UniqueInstallations, error) {
+	var rows []*UniqueInstallations
+	err := m.C("installations").Pipe([]bson.M{
+		{"$match": bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": bson.M{
+			"_id": bson.M{"$ifNull": []interface{}{"$fingerprint", "$_id"}},
+			"n":   bson.M{"$sum": 1},
+			"live": bson.M{"$sum": bson.M{"$cond": []interface{}{
+				bson.M{"$eq": []interface{}{bson.M{"$ifNull": []interface{}{"$uninstalled_at", false}}, false}}, 1, 0,
+			}}},
+		}},
+		{"$group": bson.M{
+			"_id":          nil,
+			"machines":     bson.M{"$sum": "$n"},
+			"fingerprints": bson.M{"$sum": 1},
+			"uninstalled":  bson.M{"$sum": bson.M{"$subtract": []interface{}{"$n", "$live"}}},
+			"active":       bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$gt": []interface{}{"$live", 0}}, 1, 0}}},
+		}},
+	}).All(&rows)
+	if err != nil || len(rows) == 0 {
+		return &UniqueInstallations{}, err
+	}
+	return rows[0], nil
+}
+
+func (m *MongoStore) InstallationClients(by string, from, to time.Time, limit int) ([]*ClientCount, error) {
+	var rows []struct {
+		Value string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	err := m.C("installations").Pipe([]bson.M{
+		{"$match": bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": bson.M{"_id": bson.M{"$ifNull": []interface{}{"$" + by, ""}}, "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.D{{Name: "count", Value: -1}, {Name: "_id", Value: 1}}},
+		{"$limit": limit},
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]*ClientCount, len(rows))
+	for i, row := range rows {
+		counts[i] = &ClientCount{row.Value, row.Count}
+	}
+	return counts, nil
+}
+
+func (m *MongoStore) SessionServers(from, to time.Time, limit int) ([]*ServerCount, error) {
+	var rows []struct {
+		Server   string   `bson:"_id"`
+		Count    int      `bson:"count"`
+		JIDs     []string `bson:"jids"`
+		Machines []string `bson:"machines"`
+	}
+	err := m.C("sessions").Pipe([]bson.M{
+		{"$match": bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": bson.M{
+			"_id":      bson.M{"$ifNull": []interface{}{"$xmpp_server", ""}},
+			"count":    bson.M{"$sum": 1},
+			"jids":     bson.M{"$addToSet": "$jid"},
+			"machines": bson.M{"$addToSet": "$machine_id"},
+		}},
+		{"$sort": bson.D{{Name: "count", Value: -1}, {Name: "_id", Value: 1}}},
+		{"$limit": limit},
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]*ServerCount, len(rows))
+	for i, row := range rows {
+		counts[i] = &ServerCount{row.Server, row.Count, len(row.JIDs), len(row.Machines)}
+	}
+	return counts, nil
+}
+
+func (m *MongoStore) SessionLatencies(from, to time.Time) ([]*SessionLatency, error) {
+	var sessions []*Session
+	err := m.C("sessions").Find(bson.M{
+		"created_at":           bson.M{"$gte": from, "$lt": to},
+		"activity.rtt.samples": bson.M{"$gt": 0},
+	}).Select(bson.M{"region": 1, "activity.rtt": 1}).All(&sessions)
+	if err != nil {
+		return nil, err
+	}
+	latencies := make([]*SessionLatency, len(sessions))
+	for i, s := range sessions {
+		latencies[i] = &SessionLatency{s.Region, *s.Activity.RTT}
+	}
+	return latencies, nil
+}
+
+func (m *MongoStore) InstallationDosvoxVersions(from, to time.Time) ([]*VersionCount, error) {
+	var rows []struct {
+		Version string `bson:"_id"`
+		Count   int    `bson:"count"`
+	}
+	err := m.C("installations").Pipe([]bson.M{
+		{"$match": bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": bson.M{
+			"_id":   bson.M{"$ifNull": []interface{}{"$dosvox_ver", "$dosvox_info.version", ""}},
+			"count": bson.M{"$sum": 1},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]*VersionCount, len(rows))
+	for i, row := range rows {
+		counts[i] = &VersionCount{row.Version, row.Count}
+	}
+	return counts, nil
+}
+
+// countPerDay counts the documents of a collection created on each UTC day
+// in the created range, also grouping by xmppvox_ver if byVersion is set.
+func (m *MongoStore) countPerDay(collection string, created bson.M, byVersion bool) ([]*VersionDayCount, error) {
+	group := bson.M{
+		"y": bson.M{"$year": "$created_at"},
+		"m": bson.M{"$month": "$created_at"},
+		"d": bson.M{"$dayOfMonth": "$created_at"},
+	}
+	if byVersion {
+		group["v"] = "$xmppvox_ver"
+	}
+	var rows []struct {
+		Id struct {
+			Year    int    `bson:"y"`
+			Month   int    `bson:"m"`
+			Day     int    `bson:"d"`
+			Version string `bson:"v"`
+		} `bson:"_id"`
+		Count int `bson:"count"`
+	}
+	err := m.C(collection).Pipe([]bson.M{
+		{"$match": bson.M{"created_at": created}},
+		{"$group": bson.M{"_id": group, "count": bson.M{"$sum": 1}}},
+		// The order of the sort keys matters, so they cannot go in a bson.M.
+		{"$sort": bson.D{{Name: "_id.y", Value: 1}, {Name: "_id.m", Value: 1}, {Name: "_id.d", Value: 1}, {Name: "_id.v", Value: 1}}},
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]*VersionDayCount, len(rows))
+	for i, row := range rows {
+		day := time.Date(row.Id.Year, time.Month(row.Id.Month), row.Id.Day, 0, 0, 0, 0, time.UTC)
+		counts[i] = &VersionDayCount{row.Id.Version, DayCount{day, row.Count}}
+	}
+	return counts, nil
+}
+
+// ensureTTL makes the TTL index on field of collection expire documents
+// after expireAfter, creating, changing or, with 0, dropping the index.
+func (m *MongoStore) ensureTTL(collection, field string, expireAfter time.Duration) error {
+	indexes, err := m.C(collection).Indexes()
+	if err != nil {
+		return err
+	}
+	var current *mgo.Index
+	for i, index := range indexes {
+		if len(index.Key) == 1 && index.Key[0] == field {
+			current = &indexes[i]
+		}
+	}
+	expireAfter = expireAfter / time.Second * time.Second
+	switch {
+	case current == nil && expireAfter <= 0:
+		return nil
+	case current == nil:
+		return m.C(collection).EnsureIndex(mgo.Index{Key: []string{field}, ExpireAfter: expireAfter, Background: true})
+	case expireAfter <= 0:
+		return m.C(collection).DropIndex(field)
+	case current.ExpireAfter != expireAfter:
+		// The order of the command keys matters, so they cannot go in a bson.M.
+		return m.Run(bson.D{
+			{Name: "collMod", Value: collection},
+			{Name: "index", Value: bson.M{
+				"keyPattern":         bson.M{field: 1},
+				"expireAfterSeconds": int(expireAfter / time.Second),
+			}},
+		}, nil)
+	}
+	return nil
+}
+
+// ApplyRetention expires events and crash groups with TTL indexes on
+// created_at and last_seen, and removes the request data of sessions and
+// the old reports of the crash groups still seen.
+func (m *MongoStore) ApplyRetention(r Retention, now time.Time) (int, error) {
+	if err := m.ensureTTL("events", "created_at", r.Events); err != nil {
+		return 0, err
+	}
+	if err := m.ensureTTL("crashes", "last_seen", r.Crashes); err != nil {
+		return 0, err
+	}
+	n := 0
+	if r.SessionRequests > 0 {
+		info, err := m.C("sessions").UpdateAll(
+			bson.M{"created_at": bson.M{"$lt": now.Add(-r.SessionRequests)}, "req": bson.M{"$ne": nil}},
+			bson.M{"$unset": bson.M{"req": ""}})
+		if err != nil {
+			return n, err
+		}
+		n += info.Updated
+	}
+	if r.Crashes > 0 {
+		old := bson.M{"$lt": now.Add(-r.Crashes)}
+		info, err := m.C("crashes").UpdateAll(bson.M{"recent.at": old},
+			bson.M{"$pull": bson.M{"recent": bson.M{"at": old}}})
+		if err != nil {
+			return n, err
+		}
+		n += info.Updated
+	}
+	return n, nil
+}
+
+func (m *MongoStore) AggregateRollups(period string, from, to time.Time) ([]*Rollup, error) {
+	// groupBy truncates the times of field to the period.
+	groupBy := func(field string) bson.M {
+		group := bson.M{
+			"y": bson.M{"$year": field},
+			"m": bson.M{"$month": field},
+			"d": bson.M{"$dayOfMonth": field},
+		}
+		if period == RollupHour {
+			group["h"] = bson.M{"$hour": field}
+		}
+		return group
+	}
+	type rollupId struct {
+		Year  int `bson:"y"`
+		Month int `bson:"m"`
+		Day   int `bson:"d"`
+		Hour  int `bson:"h"`
+	}
+	startOf := func(id rollupId) time.Time {
+		return time.Date(id.Year, time.Month(id.Month), id.Day, id.Hour, 0, 0, 0, time.UTC)
+	}
+	window := bson.M{"$gte": from, "$lt": to}
+	var started []struct {
+		Id       rollupId `bson:"_id"`
+		Count    int      `bson:"count"`
+		JIDs     []string `bson:"jids"`
+		Machines []string `bson:"machines"`
+	}
+	err := m.C("sessions").Pipe([]bson.M{
+		{"$match": bson.M{"created_at": window}},
+		{"$group": bson.M{
+			"_id":      groupBy("$created_at"),
+			"count":    bson.M{"$sum": 1},
+			"jids":     bson.M{"$addToSet": "$jid"},
+			"machines": bson.M{"$addToSet": "$machine_id"},
+		}},
+	}).All(&started)
+	if err != nil {
+		return nil, err
+	}
+	var closed []struct {
+		Id      rollupId `bson:"_id"`
+		Count   int      `bson:"count"`
+		Crashed int      `bson:"crashed"`
+	}
+	err = m.C("sessions").Pipe([]bson.M{
+		{"$match": bson.M{"closed_at": window}},
+		{"$group": bson.M{
+			"_id":     groupBy("$closed_at"),
+			"count":   bson.M{"$sum": 1},
+			"crashed": bson.M{"$sum": bson.M{"$cond": []interface{}{bson.M{"$eq": []string{"$closed_reason", ClosedCrash}}, 1, 0}}},
+		}},
+	}).All(&closed)
+	if err != nil {
+		return nil, err
+	}
+	rollups := make(map[time.Time]*Rollup)
+	get := func(start time.Time) *Rollup {
+		r, ok := rollups[start]
+		if !ok {
+			r = NewRollup(period, start)
+			rollups[start] = r
+		}
+		return r
+	}
+	for _, row := range started {
+		r := get(startOf(row.Id))
+		r.SessionsStarted, r.UniqueJIDs, r.UniqueMachines = row.Count, len(row.JIDs), len(row.Machines)
+	}
+	for _, row := range closed {
+		r := get(startOf(row.Id))
+		r.SessionsClosed, r.SessionsCrashed = row.Count, row.Crashed
+	}
+	return sortedRollups(rollups), nil
+}
+
+func (m *MongoStore) UpsertRollup(r *Rollup) error {
+	_, err := m.C("rollups").UpsertId(r.Id, r)
+	return err
+}
+
+func (m *MongoStore) Rollups(period string, from, to time.Time) ([]*Rollup, error) {
+	var rollups []*Rollup
+	err := m.C("rollups").Find(bson.M{"period": period, "start": bson.M{"$gte": from, "$lt": to}}).
+		Sort("start").All(&rollups)
+	return rollups, err
+}
+
+func (m *MongoStore) LatestRollup(period string) (*Rollup, error) {
+	r := &Rollup{}
+	err := m.C("rollups").Find(bson.M{"period": period}).Sort("-start").One(r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (m *MongoStore) InsertBlock(b *Block) error {
+	return m.C("blocks").Insert(b)
+}
+
+func (m *MongoStore) RemoveBlock(id bson.ObjectId) error {
+	return m.C("blocks").RemoveId(id)
+}
+
+func (m *MongoStore) Blocks() ([]*Block, error) {
+	var blocks []*Block
+	err := m.C("blocks").Find(nil).Sort("created_at").All(&blocks)
+	return blocks, err
+}
+
+func (m *MongoStore) FindBlock(jid, machineId, xmppvoxVersion, remoteIP string) (*Block, error) {
+	b := &Block{}
+	err := m.C("blocks").Find(bson.M{"$or": []bson.M{
+		{"field": BlockJID, "value": jid},
+		{"field": BlockMachineId, "value": machineId},
+		{"field": BlockXMPPVOXVersion, "value": xmppvoxVersion},
+		{"field": BlockRemoteIP, "value": remoteIP},
+	}}).Sort("created_at").One(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (m *MongoStore) RaiseFlag(f *Flag) error {
+	_, err := m.C("flags").Upsert(bson.M{"kind": f.Kind, "field": f.Field, "value": f.Value}, bson.M{
+		"$set":         bson.M{"count": f.Count, "message": f.Message, "seen_at": f.SeenAt},
+		"$setOnInsert": bson.M{"_id": f.Id, "created_at": f.CreatedAt, "status": FlagOpen},
+	})
+	return err
+}
+
+func (m *MongoStore) Flags(status string) ([]*Flag, error) {
+	var query bson.M
+	if status != "" {
+		query = bson.M{"status": status}
+	}
+	var flags []*Flag
+	err := m.C("flags").Find(query).Sort("-seen_at").All(&flags)
+	return flags, err
+}
+
+func (m *MongoStore) FindFlag(id bson.ObjectId) (*Flag, error) {
+	f := &Flag{}
+	if err := m.C("flags").FindId(id).One(f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (m *MongoStore) ReviewFlag(id bson.ObjectId, status string, blockId bson.ObjectId) error {
+	set := bson.M{"status": status, "reviewed_at": bson.Now()}
+	if blockId != "" {
+		set["block_id"] = blockId
+	}
+	return m.C("flags").Update(bson.M{"_id": id, "status": FlagOpen}, bson.M{"$set": set})
+}
+
+func (m *MongoStore) InsertAPIKey(k *APIKey) error {
+	return m.C("api_keys").Insert(k)
+}
+
+func (m *MongoStore) RevokeAPIKey(id bson.ObjectId) error {
+	return m.C("api_keys").Update(bson.M{"_id": id, "revoked_at": time.Time{}},
+		bson.M{"$set": bson.M{"revoked_at": bson.Now()}})
+}
+
+func (m *MongoStore) APIKeys() ([]*APIKey, error) {
+	var keys []*APIKey
+	err := m.C("api_keys").Find(nil).Sort("created_at").All(&keys)
+	return keys, err
+}
+
+func (m *MongoStore) FindAPIKey(key string) (*APIKey, error) {
+	k := &APIKey{}
+	if err := m.C("api_keys").Find(bson.M{"key": key}).One(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (m *MongoStore) InsertWebhook(h *Webhook) error {
+	return m.C("webhooks").Insert(h)
+}
+
+func (m *MongoStore) RemoveWebhook(id bson.ObjectId) error {
+	return m.C("webhooks").RemoveId(id)
+}
+
+func (m *MongoStore) Webhooks() ([]*Webhook, error) {
+	var hooks []*Webhook
+	err := m.C("webhooks").Find(nil).Sort("created_at").All(&hooks)
+	return hooks, err
+}
+
+func (m *MongoStore) InsertRelease(x *Release) error {
+	return m.C("releases").Insert(x)
+}
+
+func (m *MongoStore) RemoveRelease(version string) error {
+	return m.C("releases").RemoveId(version)
+}
+
+func (m *MongoStore) Releases() ([]*Release, error) {
+	var releases []*Release
+	err := m.C("releases").Find(nil).Sort("-published_at").All(&releases)
+	return releases, err
+}
+
+func (m *MongoStore) FindRelease(version string) (*Release, error) {
+	x := &Release{}
+	if err := m.C("releases").FindId(version).One(x); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (m *MongoStore) AddReleaseArtifact(version string, a *ReleaseArtifact) error {
+	return m.C("releases").Update(bson.M{"_id": version, "artifacts.platform": bson.M{"$ne": a.Platform}},
+		bson.M{"$push": bson.M{"artifacts": a}})
+}
+
+// CountDownload relies on platforms being valid field names, see
+// artifactPlatformPattern.
+func (m *MongoStore) CountDownload(version, platform string) error {
+	return m.C("releases").UpdateId(version, bson.M{"$inc": bson.M{"downloads." + platform: 1}})
+}
+
+func (m *MongoStore) SetFeatureFlag(f *FeatureFlag) error {
+	_, err := m.C("feature_flags").UpsertId(f.Name, f)
+	return err
+}
+
+func (m *MongoStore) RemoveFeatureFlag(name string) error {
+	return m.C("feature_flags").RemoveId(name)
+}
+
+func (m *MongoStore) FeatureFlags() ([]*FeatureFlag, error) {
+	var flags []*FeatureFlag
+	err := m.C("feature_flags").Find(nil).Sort("_id").All(&flags)
+	return flags, err
+}
+
+func (m *MongoStore) PingInstallation(machineId, xmppvoxVersion string) error {
+	return m.C("installations").UpdateId(machineId, bson.M{"$set": bson.M{
+		"last_seen":       bson.Now(),
+		"current_version": xmppvoxVersion,
+	}})
+}
+
+func (m *MongoStore) UninstallInstallation(machineId, reason string) error {
+	set := bson.M{"uninstalled_at": bson.Now()}
+	update := bson.M{"$set": set}
+	if reason != "" {
+		set["uninstall_reason"] = reason
+	} else {
+		update["$unset"] = bson.M{"uninstall_reason": ""}
+	}
+	return m.C("installations").UpdateId(machineId, update)
+}
+
+func (m *MongoStore) FindInstallation(machineId string) (*Installation, error) {
+	i := &Installation{}
+	if err := m.C("installations").FindId(machineId).One(i); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (m *MongoStore) ReplaceStubInstallation(i *Installation) error {
+	return m.C("installations").Update(bson.M{"_id": i.MachineId, "stub": true}, i)
+}
+
+func (m *MongoStore) OrphanSessions(from, to time.Time, limit int) ([]*OrphanCount, error) {
+	var rows []struct {
+		MachineId string    `bson:"_id"`
+		Count     int       `bson:"count"`
+		Last      time.Time `bson:"last"`
+	}
+	err := m.C("sessions").Pipe([]bson.M{
+		{"$match": bson.M{"created_at": bson.M{"$gte": from, "$lt": to}}},
+		{"$group": bson.M{"_id": "$machine_id", "count": bson.M{"$sum": 1}, "last": bson.M{"$max": "$created_at"}}},
+		{"$sort": bson.D{{Name: "count", Value: -1}, {Name: "_id", Value: 1}}},
+	}).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(rows))
+	for n, row := range rows {
+		ids[n] = row.MachineId
+	}
+	var known []struct {
+		MachineId string `bson:"_id"`
+	}
+	err = m.C("installations").Find(bson.M{"_id": bson.M{"$in": ids}}).Select(bson.M{"_id": 1}).All(&known)
+	if err != nil {
+		return nil, err
+	}
+	registered := make(map[string]bool, len(known))
+	for _, k := range known {
+		registered[k.MachineId] = true
+	}
+	var orphans []*OrphanCount
+	for _, row := range rows {
+		if len(orphans) == limit {
+			break
+		}
+		if !registered[row.MachineId] {
+			orphans = append(orphans, &OrphanCount{row.MachineId, row.Count, row.Last})
+		}
+	}
+	return orphans, nil
+}
+
+func (m *MongoStore) InsertClaim(c *Claim) error {
+	return m.C("claims").Insert(c)
+}
+
+func (m *MongoStore) ClaimInstallation(code, machineId string, debugUntil time.Time) (*Claim, error) {
+	if n, err := m.C("installations").FindId(machineId).Count(); err != nil || n == 0 {
+		if err == nil {
+			err = mgo.ErrNotFound
+		}
+		return nil, err
+	}
+	now := bson.Now()
+	c := &Claim{}
+	_, err := m.C("claims").Find(bson.M{
+		"_id":        code,
+		"claimed_at": time.Time{},
+		"expires_at": bson.M{"$gt": now},
+	}).Apply(mgo.Change{
+		Update:    bson.M{"$set": bson.M{"machine_id": machineId, "claimed_at": now}},
+		ReturnNew: true,
+	}, c)
+	if err != nil {
+		return nil, err
+	}
+	err = m.C("installations").UpdateId(machineId,
+		bson.M{"$set": bson.M{"ticket": c.Ticket, "debug_until": debugUntil}})
+	return c, err
+}
+
+func (m *MongoStore) CollectionStats() ([]*CollectionStats, error) {
+	names, err := m.CollectionNames()
+	if err != nil {
+		return nil, err
+	}
+	var stats []*CollectionStats
+	for _, name := range names {
+		if strings.HasPrefix(name, "system.") {
+			continue
+		}
+		var res struct {
+			Count          int64   `bson:"count"`
+			AvgObjSize     float64 `bson:"avgObjSize"`
+			Size           int64   `bson:"size"`
+			TotalIndexSize int64   `bson:"totalIndexSize"`
+		}
+		if err := m.Run(bson.D{{Name: "collStats", Value: name}}, &res); err != nil {
+			return nil, fmt.Errorf("collStats %s: %v", name, err)
+		}
+		stats = append(stats, &CollectionStats{name, res.Count, int64(res.AvgObjSize), res.Size, res.TotalIndexSize})
+	}
+	return stats, nil
+}
+
+func (m *MongoStore) InsertStorageSnapshot(x *StorageSnapshot) error {
+	return m.C("storage_snapshots").Insert(x)
+}
+
+func (m *MongoStore) StorageSnapshots(since time.Time) ([]*StorageSnapshot, error) {
+	var snapshots []*StorageSnapshot
+	err := m.C("storage_snapshots").Find(bson.M{"at": bson.M{"$gte": since}}).Sort("at").All(&snapshots)
+	return snapshots, err
+}
+
+func (m *MongoStore) BackfillClosedReason(reason string, dryRun bool) (int, error) {
+	q := bson.M{
+		"closed_at":     bson.M{"$ne": time.Time{}},
+		"closed_reason": bson.M{"$in": []interface{}{nil, ""}},
+	}
+	if dryRun {
+		return m.C("sessions").Find(q).Count()
+	}
+	info, err := m.C("sessions").UpdateAll(q, bson.M{"$set": bson.M{"closed_reason": reason}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Updated, nil
+}
+
+func (m *MongoStore) BackfillFingerprints(dryRun bool) (int, error) {
+	iter := m.C("installations").Find(bson.M{
+		"fingerprint":            bson.M{"$exists": false},
+		"machine_info.node":      bson.M{"$exists": true},
+		"machine_info.processor": bson.M{"$exists": true},
+		"machine_info.system":    bson.M{"$exists": true},
+	}).Select(bson.M{"machine_info": 1}).Iter()
+	var i Installation
+	n := 0
+	for iter.Next(&i) {
+		f := fingerprint(i.MachineInfo)
+		if f == "" {
+			continue
+		}
+		if !dryRun {
+			if err := m.C("installations").UpdateId(i.MachineId, bson.M{"$set": bson.M{"fingerprint": f}}); err != nil {
+				iter.Close()
+				return n, err
+			}
+		}
+		n++
+	}
+	return n, iter.Close()
+}
+
+func (m *MongoStore) NormalizeSessionJIDs(dryRun bool) (int, error) {
+	// Only jids with a resource or an uppercase letter after the @ may change.
+	iter := m.C("sessions").Find(bson.M{"jid": bson.RegEx{Pattern: "/|@.*[A-Z]"}}).
+		Select(bson.M{"jid": 1, "resource": 1}).Iter()
+	var s Session
+	n := 0
+	for iter.Next(&s) {
+		j, err := parseJID(s.JID)
+		if err != nil || (j.Bare() == s.JID && j.Resource == "") {
+			continue
+		}
+		if !dryRun {
+			update := bson.M{"jid": j.Bare()}
+			if j.Resource != "" {
+				update["resource"] = j.Resource
+			}
+			if err := m.C("sessions").UpdateId(s.Id, bson.M{"$set": update}); err != nil {
+				iter.Close()
+				return n, err
+			}
+		}
+		n++
+	}
+	return n, iter.Close()
+}
+
+func (m *MongoStore) Migrations() ([]*MigrationRun, error) {
+	var runs []*MigrationRun
+	err := m.C("migrations").Find(nil).Sort("_id").All(&runs)
+	return runs, err
+}
+
+func (m *MongoStore) InsertMigration(x *MigrationRun) error {
+	return m.C("migrations").Insert(x)
+}
+
+func (m *MongoStore) LegacySessions(after SessionId, limit int) ([]*Session, error) {
+	q := bson.M{
+		"closed_at": bson.M{"$ne": time.Time{}},
+		"$or": []bson.M{
+			{"last_ping": bson.M{"$in": []interface{}{nil, time.Time{}}}},
+			{"closed_reason": bson.M{"$in": []interface{}{nil, ""}}},
+			{"duration": bson.M{"$exists": false}},
+		},
+	}
+	if after != "" {
+		q["_id"] = bson.M{"$gt": after}
+	}
+	var sessions []*Session
+	err := m.C("sessions").Find(q).Sort("_id").Limit(limit).All(&sessions)
+	return sessions, err
+}
+
+func (m *MongoStore) BackfillSession(s *Session) error {
+	err := m.C("sessions").Update(bson.M{"_id": s.Id, "closed_at": s.ClosedAt}, bson.M{"$set": bson.M{
+		"last_ping":     s.LastPing,
+		"closed_reason": s.ClosedReason,
+		"duration":      s.Duration,
+	}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (m *MongoStore) Checkpoint(name string) (*Checkpoint, error) {
+	cp := &Checkpoint{}
+	if err := m.C("checkpoints").FindId(name).One(cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (m *MongoStore) SaveCheckpoint(cp *Checkpoint) error {
+	_, err := m.C("checkpoints").UpsertId(cp.Name, cp)
+	return err
+}
+
+func (m *MongoStore) AcquireLease(name, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	_, err := m.C("leases").Find(bson.M{
+		"_id": name,
+		"$or": []bson.M{{"holder": holder}, {"expires_at": bson.M{"$lte": now}}},
+	}).Apply(mgo.Change{
+		Update: bson.M{"$set": bson.M{"holder": holder, "expires_at": now.Add(ttl)}},
+		Upsert: true,
+	}, nil)
+	// Held by another: the upsert collides with its lease.
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *MongoStore) CountRate(key string, start, end time.Time) (int, error) {
+	var counter struct {
+		Count int `bson:"count"`
+	}
+	_, err := m.C("rate_counters").FindId(fmt.Sprintf("%s@%d", key, start.UnixNano())).Apply(mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"count": 1}, "$set": bson.M{"expires_at": end}},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &counter)
+	return counter.Count, err
+}
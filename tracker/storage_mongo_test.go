@@ -0,0 +1,51 @@
+//go:build integration
+// +build integration
+
+package tracker
+
+import (
+	"labix.org/v2/mgo"
+	"os"
+	"testing"
+	"time"
+)
+
+// The integration tests run the storage contract tests and benchmarks
+// against the MongoDB server at $ET_TEST_MONGO_URL, localhost by default,
+// in a scratch database:
+//   go test -tags integration
+//   go test -tags integration -run NONE -bench Storage
+
+const testMongoDB = "elephant_tracker_test"
+
+func init() {
+	openMongoStore = testMongoStore
+}
+
+func TestMongoStoreContract(t *testing.T) {
+	testStorageContract(t, testMongoStore)
+}
+
+// testMongoStore returns a MongoStore with indexes in an empty scratch
+// database, and a func dropping it.
+func testMongoStore() (Storage, func(), error) {
+	u := os.Getenv("ET_TEST_MONGO_URL")
+	if u == "" {
+		u = "localhost"
+	}
+	session, err := mgo.DialWithTimeout(u, 5*time.Second)
+	if err != nil {
+		return nil, nil, err
+	}
+	db := session.DB(testMongoDB)
+	db.DropDatabase()
+	store := &MongoStore{db}
+	if err := store.EnsureIndexes(); err != nil {
+		session.Close()
+		return nil, nil, err
+	}
+	return store, func() {
+		db.DropDatabase()
+		session.Close()
+	}, nil
+}
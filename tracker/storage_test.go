@@ -0,0 +1,784 @@
+package tracker
+
+import (
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The storage contract tests and benchmarks run against MemoryStore, and
+// against MongoStore too with the integration build tag, see
+// storage_mongo_test.go.
+
+// The backends and the wrappers handlers get around them all implement
+// Storage, checked at build time.
+var (
+	_ Storage = (*MongoStore)(nil)
+	_ Storage = (*MemoryStore)(nil)
+	_ Storage = (*auditedStore)(nil)
+	_ Storage = (*meteredStore)(nil)
+	_ Storage = (*cachedStore)(nil)
+)
+
+// openMongoStore opens the MongoStore of the integration tests, if built.
+var openMongoStore func() (Storage, func(), error)
+
+// storageContractTest checks the behaviors every Storage backend must
+// share, the ones handlers rely on, so that a new backend cannot diverge
+// in semantics. Add backends by running testStorageContract for them.
+type storageContractTest struct {
+	store Storage
+}
+
+// testStorageContract runs the contract tests against fresh stores from
+// open, which returns an empty store and a func releasing it.
+func testStorageContract(t *testing.T, open func() (Storage, func(), error)) {
+	for _, tc := range []struct {
+		name string
+		test func(*storageContractTest, *testing.T)
+	}{
+		{"Duplicates", (*storageContractTest).testDuplicates},
+		{"NotFound", (*storageContractTest).testNotFound},
+		{"CloseTwice", (*storageContractTest).testCloseTwice},
+		{"OtherMachine", (*storageContractTest).testOtherMachine},
+		{"Ping", (*storageContractTest).testPing},
+		{"PingAt", (*storageContractTest).testPingAt},
+		{"PingInstallation", (*storageContractTest).testPingInstallation},
+		{"Uninstall", (*storageContractTest).testUninstall},
+		{"ReplaceStubInstallation", (*storageContractTest).testReplaceStubInstallation},
+		{"OrphanSessions", (*storageContractTest).testOrphanSessions},
+		{"SessionChanges", (*storageContractTest).testSessionChanges},
+		{"UpdateSessionMetadata", (*storageContractTest).testUpdateSessionMetadata},
+		{"Transfer", (*storageContractTest).testTransfer},
+		{"Reopen", (*storageContractTest).testReopen},
+		{"Resume", (*storageContractTest).testResume},
+		{"CloseMany", (*storageContractTest).testCloseMany},
+		{"ClosedSessionFields", (*storageContractTest).testClosedSessionFields},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store, release, err := open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer release()
+			tc.test(&storageContractTest{store}, t)
+		})
+	}
+}
+
+func TestMemoryStoreContract(t *testing.T) {
+	testStorageContract(t, func() (Storage, func(), error) {
+		return NewMemoryStore(), func() {}, nil
+	})
+}
+
+func (s *storageContractTest) insertSession(t *testing.T, jid, machineId string) *Session {
+	x := NewSession(jid, machineId, "1.0", nil)
+	if err := s.store.InsertSession(x); err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func (s *storageContractTest) testDuplicates(t *testing.T) {
+	if err := s.store.InsertInstallation(NewInstallation("machine", "1.0", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	err := s.store.InsertInstallation(NewInstallation("machine", "1.1", nil, nil))
+	if got, want := mgo.IsDup(err), true; got != want {
+		t.Errorf("%v: mgo.IsDup(err) = %v, want %v", err, got, want)
+	}
+
+	x := s.insertSession(t, "user@server.org", "machine")
+	dup := *x
+	dup.Alias = ""
+	err = s.store.InsertSession(&dup)
+	if got, want := mgo.IsDup(err), true; got != want {
+		t.Errorf("%v: mgo.IsDup(err) = %v, want %v", err, got, want)
+	}
+	other := NewSession("user@server.org", "machine", "1.0", nil)
+	other.Alias = x.Alias
+	err = s.store.InsertSession(other)
+	if got, want := mgo.IsDup(err), true; got != want {
+		t.Errorf("%v: mgo.IsDup(err) = %v, want %v", err, got, want)
+	}
+}
+
+func (s *storageContractTest) testNotFound(t *testing.T) {
+	id := NewSessionId()
+	for name, call := range map[string]func() error{
+		"FindSession":        func() error { _, err := s.store.FindSession(id); return err },
+		"FindSessionByAlias": func() error { _, err := s.store.FindSessionByAlias("ABC-123"); return err },
+		"CloseSession":       func() error { return s.store.CloseSession(&Session{Id: id, MachineId: "machine"}) },
+		"PingSession":        func() error { return s.store.PingSession(&Session{Id: id, MachineId: "machine"}) },
+		"ReopenSession":      func() error { return s.store.ReopenSession(&Session{Id: id}, time.Time{}) },
+		"ResumeSession": func() error {
+			return s.store.ResumeSession(&Session{JID: "user@server.org", MachineId: "machine"}, time.Time{})
+		},
+	} {
+		if got, want := call(), mgo.ErrNotFound; got != want {
+			t.Errorf("%s: call() = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func (s *storageContractTest) testCloseTwice(t *testing.T) {
+	x := s.insertSession(t, "user@server.org", "machine")
+	closed := &Session{Id: x.Id, MachineId: x.MachineId, ClosedReason: ClosedByClient}
+	if err := s.store.CloseSession(closed); err != nil {
+		t.Fatal(err)
+	}
+	// The closed session is filled in.
+	if got, want := closed.JID, "user@server.org"; got != want {
+		t.Errorf("closed.JID = %v, want %v", got, want)
+	}
+	if got, want := closed.ClosedAt.IsZero(), false; got != want {
+		t.Errorf("closed.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := closed.ClosedReason, ClosedByClient; got != want {
+		t.Errorf("closed.ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := s.store.CloseSession(&Session{Id: x.Id, MachineId: x.MachineId}), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	found, err := s.store.FindSession(x.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := found.ClosedReason, ClosedByClient; got != want {
+		t.Errorf("found.ClosedReason = %v, want %v", got, want)
+	}
+}
+
+func (s *storageContractTest) testOtherMachine(t *testing.T) {
+	x := s.insertSession(t, "user@server.org", "machine")
+	if got, want := s.store.PingSession(&Session{Id: x.Id, MachineId: "other"}), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := s.store.CloseSession(&Session{Id: x.Id, MachineId: "other"}), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	found, err := s.store.FindSession(x.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := found.ClosedAt.IsZero(), true; got != want {
+		t.Errorf("found.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+}
+
+func (s *storageContractTest) testPing(t *testing.T) {
+	x := s.insertSession(t, "user@server.org", "machine")
+	online := 3
+	for _, a := range []*SessionActivity{{MessagesSent: 2}, {MessagesSent: 1, MessagesReceived: 5, ContactsOnline: &online}} {
+		if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId, Activity: a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := s.store.FindSession(x.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := found.LastPing.IsZero(), false; got != want {
+		t.Errorf("found.LastPing.IsZero() = %v, want %v", got, want)
+	}
+	if found.Activity == nil {
+		t.Fatal("found.Activity is nil")
+	}
+	if got, want := found.Activity.MessagesSent, int64(3); got != want {
+		t.Errorf("found.Activity.MessagesSent = %v, want %v", got, want)
+	}
+	if got, want := found.Activity.MessagesReceived, int64(5); got != want {
+		t.Errorf("found.Activity.MessagesReceived = %v, want %v", got, want)
+	}
+	if got, want := *found.Activity.ContactsOnline, 3; got != want {
+		t.Errorf("*found.Activity.ContactsOnline = %v, want %v", got, want)
+	}
+
+	// Closed sessions are not pinged.
+	if err := s.store.CloseSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func (s *storageContractTest) testPingAt(t *testing.T) {
+	x := s.insertSession(t, "user@server.org", "machine")
+	at := x.CreatedAt.Add(time.Minute)
+	for _, ping := range []time.Time{at, at.Add(-30 * time.Second)} {
+		if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId, LastPing: ping}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := s.store.FindSession(x.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An older ping does not move last_ping back.
+	if got, want := found.LastPing, at; !got.Equal(want) {
+		t.Errorf("found.LastPing = %v, want %v", got, want)
+	}
+}
+
+func (s *storageContractTest) testPingInstallation(t *testing.T) {
+	if got, want := s.store.PingInstallation("machine", "1.1"), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := s.store.InsertInstallation(NewInstallation("machine", "1.0", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.PingInstallation("machine", "1.1"); err != nil {
+		t.Fatal(err)
+	}
+	i, err := s.store.FindInstallation("machine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := i.XMPPVOXVersion, "1.0"; got != want {
+		t.Errorf("i.XMPPVOXVersion = %v, want %v", got, want)
+	}
+	if got, want := i.CurrentVersion, "1.1"; got != want {
+		t.Errorf("i.CurrentVersion = %v, want %v", got, want)
+	}
+	if got, want := i.LastSeen.IsZero(), false; got != want {
+		t.Errorf("i.LastSeen.IsZero() = %v, want %v", got, want)
+	}
+}
+
+func (s *storageContractTest) testUninstall(t *testing.T) {
+	if got, want := s.store.UninstallInstallation("machine", ""), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := s.store.InsertInstallation(NewInstallation("machine", "1.0", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.InsertInstallation(NewInstallation("other", "1.0", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.store.UninstallInstallation("machine", "Too slow"); err != nil {
+		t.Fatal(err)
+	}
+	i, err := s.store.FindInstallation("machine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := i.UninstalledAt.IsZero(), false; got != want {
+		t.Errorf("i.UninstalledAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := i.UninstallReason, "Too slow"; got != want {
+		t.Errorf("i.UninstallReason = %v, want %v", got, want)
+	}
+	u, err := s.store.InstallationUniques(time.Time{}, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := u, (&UniqueInstallations{Machines: 2, Fingerprints: 2, Uninstalled: 1, Active: 1}); !reflect.DeepEqual(got, want) {
+		t.Errorf("u = %v, want %v", got, want)
+	}
+}
+
+func (s *storageContractTest) testReplaceStubInstallation(t *testing.T) {
+	i := NewInstallation("machine", "1.1", map[string]string{"version": "4.0"}, nil)
+	if got, want := s.store.ReplaceStubInstallation(i), mgo.ErrNotFound; got != want {
+		t.Errorf("s.store.ReplaceStubInstallation(i) = %v, want %v", got, want)
+	}
+	if err := s.store.InsertInstallation(NewInstallation("machine", "1.0", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.store.ReplaceStubInstallation(i), mgo.ErrNotFound; got != want {
+		t.Errorf("s.store.ReplaceStubInstallation(i) = %v, want %v", got, want)
+	}
+
+	stub := NewInstallation("other", "1.0", nil, nil)
+	stub.Stub = true
+	if err := s.store.InsertInstallation(stub); err != nil {
+		t.Fatal(err)
+	}
+	i.MachineId = "other"
+	if err := s.store.ReplaceStubInstallation(i); err != nil {
+		t.Fatal(err)
+	}
+	found, err := s.store.FindInstallation("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := found.Stub, false; got != want {
+		t.Errorf("found.Stub = %v, want %v", got, want)
+	}
+	if got, want := found.DosvoxVersion, "4.0"; got != want {
+		t.Errorf("found.DosvoxVersion = %v, want %v", got, want)
+	}
+	if got, want := s.store.ReplaceStubInstallation(i), mgo.ErrNotFound; got != want {
+		t.Errorf("s.store.ReplaceStubInstallation(i) = %v, want %v", got, want)
+	}
+}
+
+func (s *storageContractTest) testOrphanSessions(t *testing.T) {
+	if err := s.store.InsertInstallation(NewInstallation("machine", "1.0", nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	s.insertSession(t, "user@server.org", "machine")
+	s.insertSession(t, "user@server.org", "orphan")
+	s.insertSession(t, "user@server.org", "other")
+	last := s.insertSession(t, "user@server.org", "other")
+	from, to := time.Now().Add(-time.Minute), time.Now().Add(time.Minute)
+	orphans, err := s.store.OrphanSessions(from, to, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(orphans), 2; got != want {
+		t.Fatalf("len(orphans) = %d, want %d", got, want)
+	}
+	if got, want := orphans[0].MachineId, "other"; got != want {
+		t.Errorf("orphans[0].MachineId = %v, want %v", got, want)
+	}
+	if got, want := orphans[0].Sessions, 2; got != want {
+		t.Errorf("orphans[0].Sessions = %v, want %v", got, want)
+	}
+	if got, want := orphans[0].LastSessionAt.Equal(last.CreatedAt), true; got != want {
+		t.Errorf("orphans[0].LastSessionAt.Equal(last.CreatedAt) = %v, want %v", got, want)
+	}
+	if got, want := orphans[1].MachineId, "orphan"; got != want {
+		t.Errorf("orphans[1].MachineId = %v, want %v", got, want)
+	}
+
+	orphans, err = s.store.OrphanSessions(from, to, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(orphans), 1; got != want {
+		t.Errorf("len(orphans) = %d, want %d", got, want)
+	}
+	orphans, err = s.store.OrphanSessions(to, to.Add(time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(orphans), 0; got != want {
+		t.Errorf("len(orphans) = %d, want %d", got, want)
+	}
+}
+
+func (s *storageContractTest) testSessionChanges(t *testing.T) {
+	created := s.insertSession(t, "user@server.org", "machine")
+	closed := s.insertSession(t, "user@server.org", "other")
+	time.Sleep(10 * time.Millisecond)
+	// Stored times are in milliseconds.
+	from := time.Now().Truncate(time.Millisecond)
+	if err := s.store.CloseSession(&Session{Id: closed.Id, MachineId: closed.MachineId}); err != nil {
+		t.Fatal(err)
+	}
+	later := s.insertSession(t, "user@server.org", "machine")
+	to := time.Now().Add(time.Second)
+
+	sessions, err := s.store.SessionChanges(TimeWindow{from, to})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[SessionId]bool{}
+	for _, x := range sessions {
+		ids[x.Id] = true
+	}
+	if got, want := ids, (map[SessionId]bool{closed.Id: true, later.Id: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ids = %v, want %v", got, want)
+	}
+	sessions, err = s.store.SessionChanges(TimeWindow{created.CreatedAt.Add(-time.Second), from})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(sessions), 2; got != want {
+		t.Errorf("len(sessions) = %d, want %d", got, want)
+	}
+}
+
+func (s *storageContractTest) testUpdateSessionMetadata(t *testing.T) {
+	x := s.insertSession(t, "user@server.org", "machine")
+	for _, metadata := range []map[string]string{
+		{"screen_reader": "NVDA 2014.1", "tts_voice": "Raquel"},
+		{"tts_voice": "", "dosvox_program": "cartavox"},
+	} {
+		if err := s.store.UpdateSessionMetadata(&Session{Id: x.Id, MachineId: x.MachineId, Metadata: metadata}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	found, err := s.store.FindSession(x.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := found.Metadata, (map[string]string{"screen_reader": "NVDA 2014.1", "dosvox_program": "cartavox"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("found.Metadata = %v, want %v", got, want)
+	}
+	if got, want := found.LastPing.IsZero(), false; got != want {
+		t.Errorf("found.LastPing.IsZero() = %v, want %v", got, want)
+	}
+
+	update := &Session{Id: x.Id, MachineId: "other", Metadata: map[string]string{"tts_voice": "Raquel"}}
+	if got, want := s.store.UpdateSessionMetadata(update), mgo.ErrNotFound; got != want {
+		t.Errorf("s.store.UpdateSessionMetadata(update) = %v, want %v", got, want)
+	}
+	if err := s.store.CloseSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Fatal(err)
+	}
+	update.MachineId = x.MachineId
+	if got, want := s.store.UpdateSessionMetadata(update), mgo.ErrNotFound; got != want {
+		t.Errorf("s.store.UpdateSessionMetadata(update) = %v, want %v", got, want)
+	}
+}
+
+func (s *storageContractTest) testTransfer(t *testing.T) {
+	x := s.insertSession(t, "user@server.org", "machine")
+	if got, want := s.store.TransferSession(&Session{Id: x.Id, MachineId: "other"}, "new"), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	moved := &Session{Id: x.Id, MachineId: x.MachineId}
+	if err := s.store.TransferSession(moved, "new"); err != nil {
+		t.Fatal(err)
+	}
+	// The transferred session is filled in.
+	if got, want := moved.MachineId, "new"; got != want {
+		t.Errorf("moved.MachineId = %v, want %v", got, want)
+	}
+	if got, want := moved.JID, "user@server.org"; got != want {
+		t.Errorf("moved.JID = %v, want %v", got, want)
+	}
+	if got, want := len(moved.Transfers), 1; got != want {
+		t.Fatalf("len(moved.Transfers) = %d, want %d", got, want)
+	}
+	if got, want := moved.Transfers[0].From, "machine"; got != want {
+		t.Errorf("moved.Transfers[0].From = %v, want %v", got, want)
+	}
+	if got, want := moved.Transfers[0].To, "new"; got != want {
+		t.Errorf("moved.Transfers[0].To = %v, want %v", got, want)
+	}
+	if got, want := moved.LastPing.IsZero(), false; got != want {
+		t.Errorf("moved.LastPing.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := s.store.PingSession(&Session{Id: x.Id, MachineId: "machine"}), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: "new"}); err != nil {
+		t.Error(err)
+	}
+
+	if err := s.store.CloseSession(&Session{Id: x.Id, MachineId: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.store.TransferSession(&Session{Id: x.Id, MachineId: "new"}, "other"), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Erasing a machine erases it from the transfers of other machines' sessions.
+	_, err := s.store.EraseMachine("machine", false, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, err := s.store.FindSession(x.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(found.Transfers), 0; got != want {
+		t.Errorf("len(found.Transfers) = %d, want %d", got, want)
+	}
+}
+
+func (s *storageContractTest) testReopen(t *testing.T) {
+	x := s.insertSession(t, "user@server.org", "machine")
+	if got, want := s.store.ReopenSession(&Session{Id: x.Id}, time.Now().Add(-time.Hour)), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := s.store.CloseSession(&Session{Id: x.Id, MachineId: x.MachineId, ClosedReason: ClosedByClient}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.store.ReopenSession(&Session{Id: x.Id}, time.Now().Add(time.Hour)), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	before := &Session{Id: x.Id}
+	if err := s.store.ReopenSession(before, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// Filled in as it was before being reopened.
+	if got, want := before.ClosedReason, ClosedByClient; got != want {
+		t.Errorf("before.ClosedReason = %v, want %v", got, want)
+	}
+	found, err := s.store.FindSession(x.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := found.ClosedAt.IsZero(), true; got != want {
+		t.Errorf("found.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := found.ClosedReason, ""; got != want {
+		t.Errorf("found.ClosedReason = %v, want %v", got, want)
+	}
+	if err := s.store.PingSession(&Session{Id: x.Id, MachineId: x.MachineId}); err != nil {
+		t.Error(err)
+	}
+}
+
+func (s *storageContractTest) testResume(t *testing.T) {
+	older := NewSession("user@server.org", "machine", "1.0", nil)
+	older.CreatedAt = older.CreatedAt.Add(-time.Minute)
+	if err := s.store.InsertSession(older); err != nil {
+		t.Fatal(err)
+	}
+	x := s.insertSession(t, "user@server.org", "machine")
+	if err := s.store.CloseSession(&Session{Id: x.Id, MachineId: x.MachineId, ClosedReason: ClosedExpired}); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := &Session{JID: "user@server.org", MachineId: "machine"}
+	if err := s.store.ResumeSession(resumed, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resumed.Id, x.Id; got != want {
+		t.Errorf("resumed.Id = %v, want %v", got, want)
+	}
+	if got, want := resumed.ClosedReason, ClosedExpired; got != want {
+		t.Errorf("resumed.ClosedReason = %v, want %v", got, want)
+	}
+	found, err := s.store.FindSession(x.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := found.ClosedAt.IsZero(), true; got != want {
+		t.Errorf("found.ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	// Open sessions are not resumed again.
+	if got, want := s.store.ResumeSession(&Session{JID: "user@server.org", MachineId: "machine"}, time.Now().Add(-time.Hour)), mgo.ErrNotFound; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func (s *storageContractTest) testCloseMany(t *testing.T) {
+	stale := s.insertSession(t, "user@server.org", "machine")
+	closed, err := s.store.ExpireSessions(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(closed), 0; got != want {
+		t.Errorf("len(closed) = %d, want %d", got, want)
+	}
+	closed, err = s.store.ExpireSessions(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(closed), 1; got != want {
+		t.Fatalf("len(closed) = %d, want %d", got, want)
+	}
+	// Closed sessions are returned as closed, for webhooks.
+	if got, want := closed[0].Id, stale.Id; got != want {
+		t.Errorf("closed[0].Id = %v, want %v", got, want)
+	}
+	if got, want := closed[0].JID, "user@server.org"; got != want {
+		t.Errorf("closed[0].JID = %v, want %v", got, want)
+	}
+	if got, want := closed[0].ClosedReason, ClosedExpired; got != want {
+		t.Errorf("closed[0].ClosedReason = %v, want %v", got, want)
+	}
+	if got, want := closed[0].ClosedAt.IsZero(), false; got != want {
+		t.Errorf("closed[0].ClosedAt.IsZero() = %v, want %v", got, want)
+	}
+	found, err := s.store.FindSession(stale.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := found.ClosedReason, ClosedExpired; got != want {
+		t.Errorf("found.ClosedReason = %v, want %v", got, want)
+	}
+
+	var open []*Session
+	for i := 0; i < 4; i++ {
+		open = append(open, s.insertSession(t, "user@server.org", "machine"))
+	}
+	s.insertSession(t, "other@server.org", "machine")
+	s.insertSession(t, "user@server.org", "other")
+	count, err := s.store.CountOpenSessions("machine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, 5; got != want {
+		t.Errorf("count = %v, want %v", got, want)
+	}
+	closed, err = s.store.CloseSupersededSessions(open[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(closed), 3; got != want {
+		t.Errorf("len(closed) = %d, want %d", got, want)
+	}
+	for _, x := range closed {
+		if got, want := x.ClosedReason, ClosedSuperseded; got != want {
+			t.Errorf("x.ClosedReason = %v, want %v", got, want)
+		}
+	}
+	closed, err = s.store.CloseExcessSessions(open[3], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(closed), 1; got != want {
+		t.Fatalf("len(closed) = %d, want %d", got, want)
+	}
+	if got, want := closed[0].JID, "other@server.org"; got != want {
+		t.Errorf("closed[0].JID = %v, want %v", got, want)
+	}
+	if got, want := closed[0].ClosedReason, ClosedOverLimit; got != want {
+		t.Errorf("closed[0].ClosedReason = %v, want %v", got, want)
+	}
+	count, err = s.store.CountOpenSessions("machine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, 1; got != want {
+		t.Errorf("count = %v, want %v", got, want)
+	}
+	count, err = s.store.CountOpenSessions("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := count, 1; got != want {
+		t.Errorf("count = %v, want %v", got, want)
+	}
+}
+
+// testClosedSessionFields checks that the sessions closed many at once are
+// returned with what webhook filters match them by.
+func (s *storageContractTest) testClosedSessionFields(t *testing.T) {
+	insert := func(jid string) *Session {
+		x := NewSession(jid, "machine", "1.10", nil)
+		x.Project = "partner"
+		if err := s.store.InsertSession(x); err != nil {
+			t.Fatal(err)
+		}
+		return x
+	}
+	check := func(name string, closed []*Session, err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(closed), 1; got != want {
+			t.Fatalf("%s: len(closed) = %d, want %d", name, got, want)
+		}
+		if got, want := closed[0].XMPPVOXVersion, "1.10"; got != want {
+			t.Errorf("%s: closed[0].XMPPVOXVersion = %v, want %v", name, got, want)
+		}
+		if got, want := closed[0].Project, "partner"; got != want {
+			t.Errorf("%s: closed[0].Project = %v, want %v", name, got, want)
+		}
+	}
+	insert("user@server.org")
+	closed, err := s.store.ExpireSessions(time.Now().Add(time.Hour))
+	check("ExpireSessions", closed, err)
+	insert("user@server.org")
+	closed, err = s.store.CloseSupersededSessions(insert("user@server.org"))
+	check("CloseSupersededSessions", closed, err)
+	closed, err = s.store.CloseExcessSessions(insert("other@server.org"), 0)
+	check("CloseExcessSessions", closed, err)
+}
+
+// benchStores calls fn with a MemoryStore and, in integration builds, a MongoStore.
+func benchStores(b *testing.B, fn func(b *testing.B, store Storage)) {
+	b.Run("memory", func(b *testing.B) { fn(b, NewMemoryStore()) })
+	if openMongoStore == nil {
+		return
+	}
+	b.Run("mongo", func(b *testing.B) {
+		store, release, err := openMongoStore()
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer release()
+		fn(b, store)
+	})
+}
+
+// benchSessions inserts n open sessions over 10 machines.
+func benchSessions(b *testing.B, store Storage, n int) []*Session {
+	sessions := make([]*Session, n)
+	for i := range sessions {
+		s := NewSession(fmt.Sprintf("user%d@server.org", i%10), fmt.Sprintf("bench-%d", i%10), "1.0", nil)
+		if err := store.InsertSession(s); err != nil {
+			b.Fatal(err)
+		}
+		sessions[i] = s
+	}
+	return sessions
+}
+
+func BenchmarkStorageInsertInstallation(b *testing.B) {
+	benchStores(b, func(b *testing.B, store Storage) {
+		info := map[string]string{"node": "bench", "system": "Windows", "processor": "x86"}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := store.InsertInstallation(NewInstallation(bson.NewObjectId().Hex(), "1.0", nil, info)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkStorageInsertSession(b *testing.B) {
+	benchStores(b, func(b *testing.B, store Storage) {
+		for i := 0; i < b.N; i++ {
+			s := NewSession("user@server.org", fmt.Sprintf("bench-%d", i), "1.0", nil)
+			if err := store.InsertSession(s); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkStoragePingSession(b *testing.B) {
+	benchStores(b, func(b *testing.B, store Storage) {
+		sessions := benchSessions(b, store, 100)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s := sessions[i%len(sessions)]
+			if err := store.PingSession(&Session{Id: s.Id, MachineId: s.MachineId}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkStorageCloseSession(b *testing.B) {
+	benchStores(b, func(b *testing.B, store Storage) {
+		b.StopTimer()
+		sessions := benchSessions(b, store, b.N)
+		b.StartTimer()
+		for _, s := range sessions {
+			if err := store.CloseSession(&Session{Id: s.Id, MachineId: s.MachineId}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkStorageCountOpenSessions(b *testing.B) {
+	benchStores(b, func(b *testing.B, store Storage) {
+		benchSessions(b, store, 1000)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := store.CountOpenSessions(fmt.Sprintf("bench-%d", i%10)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkStorageExpireSessions(b *testing.B) {
+	benchStores(b, func(b *testing.B, store Storage) {
+		benchSessions(b, store, 1000)
+		b.ResetTimer()
+		// Nothing expires, as in most runs of the reaper.
+		for i := 0; i < b.N; i++ {
+			if _, err := store.ExpireSessions(time.Now().Add(-time.Hour)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
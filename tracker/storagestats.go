@@ -0,0 +1,107 @@
+package tracker
+
+import (
+	"encoding/json"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"time"
+)
+
+// defaultSnapshotInterval is used when storage_stats.interval is not configured.
+const defaultSnapshotInterval = 24 * time.Hour
+
+// trendPeriods are how far back /admin/storage compares the stats with.
+var trendPeriods = []struct {
+	Name string
+	Ago  time.Duration
+}{
+	{"1d", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// snapshotJob records the stats of every collection, for /admin/storage to
+// tell how fast they grow.
+var snapshotJob = &Job{
+	Name: "storage_stats",
+	Interval: func(c *Config) time.Duration {
+		if c != nil && c.StorageStats != nil && c.StorageStats.Interval.Duration > 0 {
+			return c.StorageStats.Interval.Duration
+		}
+		return defaultSnapshotInterval
+	},
+	Run: func(c *Context) (int, error) {
+		stats, err := c.Store.CollectionStats()
+		if err != nil {
+			return 0, err
+		}
+		return len(stats), c.Store.InsertStorageSnapshot(&StorageSnapshot{bson.NewObjectId(), bson.Now(), stats})
+	},
+}
+
+// collectionTrend is how much a collection grew since a snapshot.
+type collectionTrend struct {
+	Since      time.Time `json:"since"`
+	Count      int64     `json:"count"`
+	DataBytes  int64     `json:"data_bytes"`
+	IndexBytes int64     `json:"index_bytes"`
+}
+
+type collectionReport struct {
+	*CollectionStats
+	// Trend maps trendPeriods to the growth since the newest snapshot that old,
+	// leaving out the periods without snapshots.
+	Trend map[string]*collectionTrend `json:"trend"`
+}
+
+type storageReport struct {
+	At          time.Time           `json:"at"`
+	Collections []*collectionReport `json:"collections"`
+}
+
+// StorageStatsHandler reports the stats of every collection and their trend.
+func StorageStatsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := bson.Now()
+	stats, err := c.Store.CollectionStats()
+	if err != nil {
+		writeError(w, r, internalError("Failed to get storage stats"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	oldest := trendPeriods[len(trendPeriods)-1].Ago + snapshotJob.Interval(c.Config)
+	snapshots, err := c.Store.StorageSnapshots(now.Add(-oldest))
+	if err != nil {
+		writeError(w, r, internalError("Failed to list storage snapshots"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	rep := &storageReport{At: now, Collections: make([]*collectionReport, len(stats))}
+	for i, cs := range stats {
+		rep.Collections[i] = &collectionReport{cs, map[string]*collectionTrend{}}
+	}
+	for _, p := range trendPeriods {
+		var base *StorageSnapshot
+		for _, x := range snapshots {
+			if x.At.After(now.Add(-p.Ago)) {
+				break
+			}
+			base = x
+		}
+		if base == nil {
+			continue
+		}
+		for _, cr := range rep.Collections {
+			t := &collectionTrend{Since: base.At, Count: cr.Count, DataBytes: cr.DataBytes, IndexBytes: cr.IndexBytes}
+			for _, old := range base.Collections {
+				if old.Name == cr.Name {
+					t.Count -= old.Count
+					t.DataBytes -= old.DataBytes
+					t.IndexBytes -= old.IndexBytes
+				}
+			}
+			cr.Trend[p.Name] = t
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(rep)
+}
@@ -0,0 +1,123 @@
+package tracker
+
+import (
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"time"
+)
+
+// Claim codes look like session aliases, as in "K7QX-4M2P", since they
+// are also read aloud and typed by people.
+const (
+	defaultClaimTTL = 24 * time.Hour
+	defaultDebugFor = 72 * time.Hour
+)
+
+func claimTTL(conf *Config) time.Duration {
+	if conf != nil && conf.Support != nil && conf.Support.ClaimTTL.Duration > 0 {
+		return conf.Support.ClaimTTL.Duration
+	}
+	return defaultClaimTTL
+}
+
+func debugFor(conf *Config) time.Duration {
+	if conf != nil && conf.Support != nil && conf.Support.DebugFor.Duration > 0 {
+		return conf.Support.DebugFor.Duration
+	}
+	return defaultDebugFor
+}
+
+// NewClaimHandler creates a claim code for a support ticket,
+// replying with the code to give the user.
+func NewClaimHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	if errs := checkParams(r, []string{"ticket"}); errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	claim := NewClaim(newSessionAlias(), r.PostFormValue("ticket"), claimTTL(c.Config))
+	err := c.Store.InsertClaim(claim)
+	for retries := 0; mgo.IsDup(err) && retries < 3; retries++ {
+		claim.Code = newSessionAlias()
+		err = c.Store.InsertClaim(claim)
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to create claim code"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	a := NewAuditEntry("claim.new", claim.Code, c.actor(r), "", bson.M{
+		"ticket":     claim.Ticket,
+		"expires_at": claim.ExpiresAt,
+	})
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	fmt.Fprintln(w, formatAlias(claim.Code))
+}
+
+// ClaimInstallationHandler links the installation of machine_id to the
+// ticket of a claim code entered by the user, starting its debug capture.
+// It replies with the ticket and, in the next line, when the capture ends.
+func ClaimInstallationHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"machine_id", "code"})
+	machineId, e := machineIdParam(r, c.Config)
+	if e != nil {
+		errs = append(errs, e)
+	}
+	s := r.PostFormValue("code")
+	code, ok := normalizeAlias(s)
+	if s != "" && !ok {
+		errs = append(errs, &APIError{"invalid_claim_code", "code", "",
+			fmt.Sprintf("Invalid claim code %s, expected a code like K7QX-4M2P", s)})
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	until := time.Now().Add(debugFor(c.Config)).UTC()
+	claim, err := c.Store.ClaimInstallation(code, machineId, until)
+	switch err {
+	case nil:
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"claim_not_found", "code", "",
+			fmt.Sprintf("Claim code %s is unknown, expired or already used, or the installation is not registered", s)},
+			http.StatusBadRequest)
+		return
+	default:
+		writeError(w, r, internalError("Failed to claim installation"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	a := NewAuditEntry("installation.claim", machineId, c.actor(r), "", bson.M{
+		"code":        claim.Code,
+		"ticket":      claim.Ticket,
+		"debug_until": until,
+	})
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	w.Header().Set("X-Debug-Until", until.Format(time.RFC3339))
+	fmt.Fprintln(w, claim.Ticket)
+	fmt.Fprintln(w, until.Format(time.RFC3339))
+}
+
+// debugCapture tells clients of a machine under debug capture, in the
+// X-Debug-Until header, to keep raising their log level, and logs the
+// request with the ticket of the machine.
+func debugCapture(w http.ResponseWriter, r *http.Request, c *Context, machineId string) {
+	i, err := c.Store.FindInstallation(machineId)
+	switch {
+	case err == mgo.ErrNotFound:
+		return
+	case err != nil:
+		c.Log.Error(err)
+		return
+	}
+	if !i.DebugUntil.After(time.Now()) {
+		return
+	}
+	w.Header().Set("X-Debug-Until", i.DebugUntil.UTC().Format(time.RFC3339))
+	c.Log.Infof("[debug] ticket %s machine %s: %s %s %v", i.Ticket, machineId, r.Method, r.URL.Path, r.PostForm)
+}
@@ -0,0 +1,118 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// tailBacklog is how far back the since param of a tail can go.
+	tailBacklog = time.Hour
+	// tailLag is how long a tail waits for a change to be written before
+	// reading it, since created_at and closed_at are set before the write.
+	tailLag = 2 * time.Second
+)
+
+// tailInterval is how often a tail polls the storage for changes.
+var tailInterval = time.Second
+
+// tailEvents returns the new and closed events of the sessions created or
+// closed within w, oldest first, in the format of webhook payloads.
+func tailEvents(store Storage, w TimeWindow) ([]*webhookPayload, error) {
+	sessions, err := store.SessionChanges(w)
+	if err != nil {
+		return nil, err
+	}
+	var events []*webhookPayload
+	for _, s := range sessions {
+		if w.Contains(s.CreatedAt) {
+			e := newWebhookPayload(WebhookSessionNew, s)
+			e.At = s.CreatedAt
+			events = append(events, e)
+		}
+		if !s.ClosedAt.IsZero() && w.Contains(s.ClosedAt) {
+			e := newWebhookPayload(WebhookSessionClose, s)
+			e.At = s.ClosedAt
+			events = append(events, e)
+		}
+	}
+	sort.Stable(eventsByTime(events))
+	return events, nil
+}
+
+// eventsByTime sorts by the time of the events, oldest first.
+type eventsByTime []*webhookPayload
+
+func (s eventsByTime) Len() int           { return len(s) }
+func (s eventsByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s eventsByTime) Less(i, j int) bool { return s[i].At.Before(s[j].At) }
+
+// TailSessionsHandler streams the sessions created and closed as
+// newline-delimited JSON, one event per line, until the client goes away or
+// the timeout param is over, so that scripts can follow the activity with
+// curl. Events are polled from the storage every tailInterval and lag
+// tailLag behind.
+func TailSessionsHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	now := time.Now()
+	var errs APIErrors
+	from := now.Add(-tailLag)
+	if s := r.FormValue("since"); s != "" {
+		t, ok := parseWindowTime(s, now, time.UTC)
+		switch {
+		case !ok:
+			errs = append(errs, invalidWindowTime("since", s))
+		case now.Sub(t) > tailBacklog:
+			errs = append(errs, &APIError{"invalid_value", "since", "",
+				fmt.Sprintf("Invalid since %s, expected a time within the last %s", s, tailBacklog)})
+		default:
+			from = t
+		}
+	}
+	var end <-chan time.Time
+	if s := r.FormValue("timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			errs = append(errs, &APIError{"invalid_value", "timeout", "",
+				fmt.Sprintf("Invalid timeout %s, expected a duration like 5m", s)})
+		}
+		end = time.After(d)
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for {
+		if to := time.Now().Add(-tailLag); to.After(from) {
+			events, err := tailEvents(c.Store, TimeWindow{from, to})
+			if err != nil {
+				// Headers are sent already, ending the stream is all the client gets.
+				c.Log.Error("[tail]", err)
+				return
+			}
+			for _, e := range events {
+				if err := enc.Encode(e); err != nil {
+					return
+				}
+			}
+			from = to
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-end:
+			return
+		case <-time.After(tailInterval):
+		}
+	}
+}
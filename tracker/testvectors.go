@@ -0,0 +1,277 @@
+package tracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// TestVector is a canonical request to API v1 and the response of the server.
+// Vectors are generated in order against empty storage, and values that vary
+// from run to run, like session ids, are captured by a vector and referred to
+// as {name} in the params and bodies of later vectors.
+type TestVector struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Request     TestVectorRequest  `json:"request"`
+	Response    TestVectorResponse `json:"response"`
+	// Capture names the variable set to the first line of the response body.
+	Capture string `json:"capture,omitempty"`
+}
+
+type TestVectorRequest struct {
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Header map[string]string `json:"header,omitempty"`
+	Params map[string]string `json:"params"`
+}
+
+type TestVectorResponse struct {
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+const testMachineId = "00:26:cc:18:be:14"
+
+// testVectors lists the requests of the vectors; responses are filled in by generateTestVectors.
+var testVectors = []*TestVector{
+	{
+		Name:        "installation_new",
+		Description: "Registers an installation; returns the machine_id.",
+		Request: TestVectorRequest{Path: "/installation/new", Params: map[string]string{
+			"machine_id":      testMachineId,
+			"xmppvox_version": "1.0",
+			"dosvox_info":     `{"version": "4.5"}`,
+			"machine_info":    "null",
+		}},
+	},
+	{
+		Name:        "installation_new_duplicate",
+		Description: "An installation can only be registered once.",
+		Request: TestVectorRequest{Path: "/installation/new", Params: map[string]string{
+			"machine_id":      testMachineId,
+			"xmppvox_version": "1.0",
+			"dosvox_info":     "null",
+			"machine_info":    "null",
+		}},
+	},
+	{
+		Name:        "installation_new_invalid",
+		Description: "All invalid params are reported at once, one per line.",
+		Request: TestVectorRequest{Path: "/installation/new", Params: map[string]string{
+			"machine_id":   "11:26:cc:18:be:14",
+			"dosvox_info":  `{"version": 4.5}`,
+			"machine_info": "[]",
+			"extra":        "x",
+		}},
+	},
+	{
+		Name:        "installation_new_invalid_json",
+		Description: "Errors are reported as JSON when asked to.",
+		Request: TestVectorRequest{Path: "/installation/new",
+			Header: map[string]string{"Accept": "application/json"},
+			Params: map[string]string{
+				"machine_id":  "11:26:cc:18:be:14",
+				"dosvox_info": `{"version": 4.5}`,
+			}},
+	},
+	{
+		Name:        "session_new",
+		Description: "Starts a session; the first line of the body is the session id.",
+		Request: TestVectorRequest{Path: "/session/new", Params: map[string]string{
+			"jid":             "testuser@server.org",
+			"machine_id":      testMachineId,
+			"xmppvox_version": "1.0",
+		}},
+		Capture: "session_id",
+	},
+	{
+		Name:        "session_new_missing_param",
+		Description: "jid, machine_id and xmppvox_version are required.",
+		Request: TestVectorRequest{Path: "/session/new", Params: map[string]string{
+			"jid":        "testuser@server.org",
+			"machine_id": testMachineId,
+		}},
+	},
+	{
+		Name:        "session_new_invalid_jid",
+		Description: "The jid must be a valid user@domain, with an optional /resource.",
+		Request: TestVectorRequest{Path: "/session/new", Params: map[string]string{
+			"jid":             "testuser",
+			"machine_id":      testMachineId,
+			"xmppvox_version": "1.0",
+		}},
+	},
+	{
+		Name:        "session_ping",
+		Description: "Pings an open session; returns the session id.",
+		Request: TestVectorRequest{Path: "/session/ping", Params: map[string]string{
+			"session_id": "{session_id}",
+			"machine_id": testMachineId,
+		}},
+	},
+	{
+		Name:        "session_ping_other_machine",
+		Description: "Sessions can only be pinged from the machine that started them.",
+		Request: TestVectorRequest{Path: "/session/ping", Params: map[string]string{
+			"session_id": "{session_id}",
+			"machine_id": "11:26:cc:18:be:14",
+		}},
+	},
+	{
+		Name:        "session_transfer_not_owner",
+		Description: "Sessions can only be transferred to another machine by the machine that holds them.",
+		Request: TestVectorRequest{Path: "/session/transfer", Params: map[string]string{
+			"session_id":     "{session_id}",
+			"machine_id":     "11:26:cc:18:be:14",
+			"new_machine_id": "22:26:cc:18:be:14",
+		}},
+	},
+	{
+		Name:        "event",
+		Description: "Records a telemetry event; returns the event id.",
+		Request: TestVectorRequest{Path: "/event", Params: map[string]string{
+			"machine_id": testMachineId,
+			"session_id": "{session_id}",
+			"event":      "speech.command",
+			"properties": `{"command": "read_contacts"}`,
+		}},
+		Capture: "event_id",
+	},
+	{
+		Name:        "event_invalid_name",
+		Description: "Event names are lowercase letters, digits, dots and underscores.",
+		Request: TestVectorRequest{Path: "/event", Params: map[string]string{
+			"machine_id": testMachineId,
+			"session_id": "{session_id}",
+			"event":      "Speech Command",
+		}},
+	},
+	{
+		Name: "crash_new",
+		Description: "Reports a crash; returns the signature of the traceback. " +
+			"Signatures ignore memory addresses, line numbers and install paths.",
+		Request: TestVectorRequest{Path: "/crash/new", Params: map[string]string{
+			"machine_id":      testMachineId,
+			"session_id":      "{session_id}",
+			"xmppvox_version": "1.0",
+			"traceback": "Traceback (most recent call last):\n" +
+				"  File \"C:\\Program Files\\XMPPVOX\\xmppvox\\server.py\", line 42, in handle\n" +
+				"    self.send(msg)\n" +
+				"AttributeError: 'NoneType' object at 0x01d2f3a0 has no attribute 'send'\n",
+			"context": `{"screen_reader": "nvda"}`,
+		}},
+	},
+	{
+		Name:        "crash_new_same_signature",
+		Description: "The same bug from another version and install path has the same signature.",
+		Request: TestVectorRequest{Path: "/crash/new", Params: map[string]string{
+			"machine_id":      "11:26:cc:18:be:14",
+			"xmppvox_version": "1.1",
+			"traceback": "Traceback (most recent call last):\n" +
+				"  File \"D:\\XMPPVOX\\xmppvox\\server.py\", line 47, in handle\n" +
+				"    self.send(msg)\n" +
+				"AttributeError: 'NoneType' object at 0x02a0b7c8 has no attribute 'send'\n",
+		}},
+	},
+	{
+		Name:        "session_close",
+		Description: "Closes an open session; returns the session id.",
+		Request: TestVectorRequest{Path: "/session/close", Params: map[string]string{
+			"session_id": "{session_id}",
+			"machine_id": testMachineId,
+		}},
+	},
+	{
+		Name:        "session_close_closed",
+		Description: "A session can only be closed once.",
+		Request: TestVectorRequest{Path: "/session/close", Params: map[string]string{
+			"session_id": "{session_id}",
+			"machine_id": testMachineId,
+		}},
+	},
+	{
+		Name:        "session_close_invalid_id",
+		Description: "Session ids are 24 hexadecimal digits.",
+		Request: TestVectorRequest{Path: "/session/close", Params: map[string]string{
+			"session_id": "not-a-session",
+			"machine_id": testMachineId,
+		}},
+	},
+}
+
+// generateTestVectors runs the vectors through the handlers of the API,
+// with a MemoryStore and the default configuration, and records the responses.
+func generateTestVectors() ([]*TestVector, error) {
+	c := &Context{Store: NewMemoryStore(), Config: &Config{}}
+	vars := make(map[string]string)
+	// expand replaces {name} with the value of the variable and unexpand does the opposite.
+	expand := func(s string) string {
+		for name, value := range vars {
+			s = strings.Replace(s, "{"+name+"}", value, -1)
+		}
+		return s
+	}
+	unexpand := func(s string) string {
+		for name, value := range vars {
+			s = strings.Replace(s, value, "{"+name+"}", -1)
+		}
+		return s
+	}
+	vectors := make([]*TestVector, len(testVectors))
+	for i, spec := range testVectors {
+		tv := *spec
+		tv.Request.Method = "POST"
+		form := url.Values{}
+		for name, value := range tv.Request.Params {
+			form.Set(name, expand(value))
+		}
+		r, err := http.NewRequest("POST", "/1"+tv.Request.Path, strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for name, value := range tv.Request.Header {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		clientHandlers[tv.Request.Path](w, r, c)
+		tv.Request.Path = "/1" + tv.Request.Path
+		body := w.Body.String()
+		if tv.Capture != "" {
+			vars[tv.Capture] = strings.SplitN(body, "\n", 2)[0]
+		}
+		tv.Response = TestVectorResponse{w.Code, unexpand(body)}
+		vectors[i] = &tv
+	}
+	return vectors, nil
+}
+
+var testVectorsJSON struct {
+	sync.Once
+	b   []byte
+	err error
+}
+
+// TestVectorsHandler serves the protocol test vectors as JSON,
+// for third-party clients to check their conformance.
+func TestVectorsHandler(w http.ResponseWriter, r *http.Request) {
+	testVectorsJSON.Do(func() {
+		vectors, err := generateTestVectors()
+		if err != nil {
+			testVectorsJSON.err = err
+			return
+		}
+		testVectorsJSON.b, testVectorsJSON.err = json.MarshalIndent(vectors, "", "  ")
+	})
+	if err := testVectorsJSON.err; err != nil {
+		writeError(w, r, internalError("Failed to generate test vectors"), http.StatusInternalServerError)
+		logger.Error(err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(testVectorsJSON.b)
+}
@@ -0,0 +1,47 @@
+package tracker
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateIsReproducible(t *testing.T) {
+	a, err := generateTestVectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := generateTestVectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := a, b; !reflect.DeepEqual(got, want) {
+		t.Errorf("a = %v, want %v", got, want)
+	}
+}
+
+func TestVectors(t *testing.T) {
+	vectors, err := generateTestVectors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := make(map[string]*TestVector)
+	for _, tv := range vectors {
+		byName[tv.Name] = tv
+		if got, pattern := tv.Request.Path, "/1/.*"; !fullMatch(pattern, got) {
+			t.Errorf("tv.Request.Path = %q, want a match of %q", got, pattern)
+		}
+	}
+	if got, want := byName["session_new"].Response, (TestVectorResponse{http.StatusOK, "{session_id}\n"}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := byName["session_close"].Request.Params["session_id"], "{session_id}"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := byName["session_close_closed"].Response.Status, http.StatusBadRequest; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := byName["crash_new"].Response.Body, byName["crash_new_same_signature"].Response.Body; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,118 @@
+package tracker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRequestTimeout is used when limits.request_timeout is not configured.
+const defaultRequestTimeout = 30 * time.Second
+
+var errRequestTimeout = errors.New("request timed out")
+
+// requestTimeout returns the deadline of requests to path, 0 for none.
+// Exports and tails stream for as long as the client reads, and profiles run
+// for as long as they were asked, so they have no deadline unless one is
+// given for their path in limits.request_timeouts.
+func requestTimeout(conf *Config, path string) time.Duration {
+	var limits *LimitsConfig
+	if conf != nil {
+		limits = conf.Limits
+	}
+	if limits != nil {
+		if d, ok := limits.RequestTimeouts[path]; ok {
+			return d.Duration
+		}
+	}
+	if strings.HasPrefix(path, "/admin/1/export/") || strings.HasPrefix(path, "/admin/1/debug/pprof/") ||
+		path == "/admin/1/sessions/tail" {
+		return 0
+	}
+	if limits != nil && limits.RequestTimeout.Duration > 0 {
+		return limits.RequestTimeout.Duration
+	}
+	return defaultRequestTimeout
+}
+
+// timeoutWriter buffers the response of a handler running with a deadline,
+// discarding what it writes after the deadline.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	h        http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, errRequestTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.timedOut && tw.code == 0 {
+		tw.code = code
+	}
+}
+
+// serveWithTimeout calls fn, replying with its response if it is done
+// within d, and with 503 and code timeout otherwise. fn keeps running after
+// the deadline, until the storage calls it waits on time out themselves.
+func serveWithTimeout(w http.ResponseWriter, r *http.Request, d time.Duration, fn func(http.ResponseWriter)) {
+	tw := &timeoutWriter{h: make(http.Header)}
+	done := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			// Panics are raised again, with their stack, in the goroutine of
+			// the server, where recoverHandler recovers them.
+			var p interface{}
+			if v := recover(); v != nil {
+				p = &handlerPanic{v, debug.Stack()}
+			}
+			done <- p
+		}()
+		fn(tw)
+	}()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case p := <-done:
+		if p != nil {
+			panic(p)
+		}
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		dst := w.Header()
+		for k, vv := range tw.h {
+			dst[k] = vv
+		}
+		if tw.code == 0 {
+			tw.code = http.StatusOK
+		}
+		w.WriteHeader(tw.code)
+		w.Write(tw.buf.Bytes())
+	case <-timer.C:
+		tw.mu.Lock()
+		tw.timedOut = true
+		tw.mu.Unlock()
+		writeError(w, r, &APIError{"timeout", "", "", fmt.Sprintf("Request not served within %s, retry later", d)},
+			http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,372 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// uiPageSize is how many sessions or installations are listed per page.
+	uiPageSize = 20
+	// uiChartDays is how many days the chart of session counts spans by default.
+	uiChartDays = 30
+)
+
+// requireUI wraps h like requireAdmin, but takes the admin token as the
+// password of HTTP basic authentication, which browsers know how to ask for.
+// Since browsers send the credentials along with any request, even those made
+// from other sites, POST requests must also carry the csrf_token of the page.
+func requireUI(h contextualHandlerFunc) contextualHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, c *Context) {
+		token := basicAuthPassword(r)
+		t := adminToken(token, c.Config)
+		if t == nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="elephant-tracker admin"`)
+			writeError(w, r, &APIError{"unauthorized", "", "", "Unauthorized"}, http.StatusUnauthorized)
+			return
+		}
+		if t.Role != RoleOperator {
+			writeError(w, r, forbidden(), http.StatusForbidden)
+			return
+		}
+		if r.Method == "POST" {
+			got := r.PostFormValue("csrf_token")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(csrfToken(token))) != 1 {
+				writeError(w, r, &APIError{"invalid_csrf_token", "csrf_token", "",
+					"Invalid or missing csrf_token, reload the page and retry"}, http.StatusForbidden)
+				return
+			}
+		}
+		c.Role, c.Actor = t.Role, t.actor()
+		h(w, r, c)
+	}
+}
+
+// basicAuthPassword returns the password of the HTTP basic authentication
+// of r, ignoring the user name.
+func basicAuthPassword(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Basic ") {
+		return ""
+	}
+	b, err := base64.StdEncoding.DecodeString(auth[len("Basic "):])
+	if err != nil {
+		return ""
+	}
+	credentials := string(b)
+	i := strings.Index(credentials, ":")
+	if i < 0 {
+		return ""
+	}
+	return credentials[i+1:]
+}
+
+// csrfToken derives from an admin token the value that forms must post back.
+func csrfToken(token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte("admin ui"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// uiPager links the pages of a list, keeping the rest of the query intact.
+// Label names the list for screen readers, as in "Next page of open sessions".
+type uiPager struct {
+	Label      string
+	Page       int
+	Prev, Next string
+}
+
+func newPager(u *url.URL, param, label string, page int, more bool) uiPager {
+	p := uiPager{Label: label, Page: page}
+	link := func(page int) string {
+		q := u.Query()
+		q.Set(param, strconv.Itoa(page))
+		return "?" + q.Encode()
+	}
+	if page > 1 {
+		p.Prev = link(page - 1)
+	}
+	if more {
+		p.Next = link(page + 1)
+	}
+	return p
+}
+
+// pageParam parses a page number, 1 when not given.
+func pageParam(r *http.Request, name string) (int, *APIError) {
+	s := r.FormValue(name)
+	if s == "" {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, &APIError{"invalid_value", name, "", fmt.Sprintf("Invalid %s, expected a positive number", name)}
+	}
+	return n, nil
+}
+
+// uiDay is a bar of the chart of session counts.
+type uiDay struct {
+	Day     time.Time
+	Count   int
+	Percent int
+}
+
+// chartDays fills in the UTC days of w without sessions and scales the bars to the busiest day.
+func chartDays(counts []*DayCount, w TimeWindow) []*uiDay {
+	byDay := make(map[time.Time]int)
+	max := 0
+	for _, dc := range counts {
+		byDay[dc.Day] = dc.Count
+		if dc.Count > max {
+			max = dc.Count
+		}
+	}
+	var days []*uiDay
+	for day := utcDay(w.From); day.Before(w.To); day = day.AddDate(0, 0, 1) {
+		d := &uiDay{Day: day, Count: byDay[day]}
+		if max > 0 {
+			d.Percent = d.Count * 100 / max
+		}
+		days = append(days, d)
+	}
+	return days
+}
+
+// chartSummary sums up the chart for those who cannot see the bars.
+func chartSummary(days []*uiDay) (total int, busiest *uiDay) {
+	for _, d := range days {
+		total += d.Count
+		if busiest == nil || d.Count > busiest.Count {
+			busiest = d
+		}
+	}
+	return total, busiest
+}
+
+type uiPage struct {
+	Freshness
+	Sessions           []*Session
+	SessionsPager      uiPager
+	Installations      []*Installation
+	InstallationsPager uiPager
+	Days               []*uiDay
+	ChartTotal         int
+	ChartBusiest       *uiDay
+	Blocks             []*Block
+	BlockFields        []string
+	CSRFToken          string
+}
+
+// UIHandler serves the admin dashboard.
+func UIHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	var errs APIErrors
+	sessionsPage, e := pageParam(r, "sessions_page")
+	if e != nil {
+		errs = append(errs, e)
+	}
+	installationsPage, e := pageParam(r, "installations_page")
+	if e != nil {
+		errs = append(errs, e)
+	}
+	now := time.Now().UTC()
+	// With today, the chart has uiChartDays bars by default.
+	chart, windowErrs := parseWindow(r, now, (uiChartDays-1)*24*time.Hour, maxStatsWindow)
+	errs = append(errs, windowErrs...)
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	p := &uiPage{
+		BlockFields: blockFields,
+		CSRFToken:   csrfToken(basicAuthPassword(r)),
+	}
+	asOf, err := c.Store.NewestActivity()
+	if err == nil {
+		p.Freshness = freshness(asOf, c.Config, now)
+		// Fetch one more than shown to know whether there is a next page.
+		p.Sessions, err = c.Store.OpenSessions((sessionsPage-1)*uiPageSize, uiPageSize+1)
+	}
+	if err == nil {
+		p.SessionsPager = newPager(r.URL, "sessions_page", "open sessions", sessionsPage, len(p.Sessions) > uiPageSize)
+		if len(p.Sessions) > uiPageSize {
+			p.Sessions = p.Sessions[:uiPageSize]
+		}
+		p.Installations, err = c.Store.RecentInstallations((installationsPage-1)*uiPageSize, uiPageSize+1)
+	}
+	if err == nil {
+		p.InstallationsPager = newPager(r.URL, "installations_page", "recent installations", installationsPage, len(p.Installations) > uiPageSize)
+		if len(p.Installations) > uiPageSize {
+			p.Installations = p.Installations[:uiPageSize]
+		}
+		var counts []*DayCount
+		counts, err = c.Store.SessionsPerDay(utcDay(chart.From))
+		p.Days = chartDays(counts, chart)
+		p.ChartTotal, p.ChartBusiest = chartSummary(p.Days)
+	}
+	if err == nil {
+		p.Blocks, err = c.Store.Blocks()
+	}
+	if err != nil {
+		writeError(w, r, internalError("Failed to load the dashboard"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := uiTemplate.Execute(w, p); err != nil {
+		c.Log.Error(err)
+	}
+}
+
+// UINewBlockHandler adds a block from the dashboard form.
+func UINewBlockHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	b, errs := blockFromForm(r, c.Config, "csrf_token")
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	if err := addBlock(r, c, b); err != nil {
+		writeError(w, r, internalError("Failed to add block"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	http.Redirect(w, r, "/admin/ui#blocks", http.StatusSeeOther)
+}
+
+// UIRemoveBlockHandler lifts a block from the dashboard form.
+func UIRemoveBlockHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	if removeBlock(w, r, c) {
+		http.Redirect(w, r, "/admin/ui#blocks", http.StatusSeeOther)
+	}
+}
+
+var uiTemplate = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.UTC().Format("2006-01-02 15:04 MST")
+	},
+	"day":     func(t time.Time) string { return t.Format("Jan 2") },
+	"longDay": func(t time.Time) string { return t.Format("Monday, January 2, 2006") },
+	"iso":     func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}).Parse(uiHTML))
+
+// The dashboard is used with screen readers by many operators: every table
+// has a caption and header cells with a scope, the chart is a data table
+// whose bars are hidden from assistive technology, controls are native and
+// reachable with the keyboard, with a visible focus and a link to skip to
+// the content.
+const uiHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Elephant Tracker</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; margin-bottom: 0.5em; }
+caption { font-weight: bold; text-align: left; padding: 0.2em 0; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+.warning { background: #fe9; border: 1px solid #c90; padding: 0.5em; }
+.bar { background: #369; height: 1em; }
+.chart td, .chart th { border: none; padding: 0 0.6em; }
+.chart td.bar-cell { width: 30em; }
+a:focus, button:focus, input:focus, select:focus { outline: 3px solid #c60; outline-offset: 2px; }
+.skip { position: absolute; left: -999em; }
+.skip:focus { position: static; }
+</style>
+</head>
+<body>
+<a class="skip" href="#main">Skip to content</a>
+<header>
+<h1>Elephant Tracker</h1>
+<nav aria-label="Sections">
+<ul>
+<li><a href="#sessions">Open sessions</a></li>
+<li><a href="#installations">Recent installations</a></li>
+<li><a href="#chart">Sessions per day</a></li>
+<li><a href="#blocks">Blocks</a></li>
+</ul>
+</nav>
+</header>
+<main id="main" tabindex="-1">
+{{if .Stale}}<p class="warning" role="alert">Data may be stale: nothing was registered since {{time .DataAsOf}}.</p>{{end}}
+
+<section aria-labelledby="sessions">
+<h2 id="sessions">Open sessions</h2>
+<table>
+<caption>Open sessions, newest first, page {{.SessionsPager.Page}}</caption>
+<thead><tr><th scope="col">Alias</th><th scope="col">Created</th><th scope="col">Last ping</th><th scope="col">JID</th><th scope="col">Machine</th><th scope="col">Version</th></tr></thead>
+<tbody>
+{{range .Sessions}}<tr><th scope="row">{{.Alias}}</th><td>{{template "time" .CreatedAt}}</td><td>{{template "time" .LastPing}}</td><td>{{.JID}}</td><td>{{.MachineId}}</td><td>{{.XMPPVOXVersion}}</td></tr>
+{{else}}<tr><td colspan="6">No open sessions.</td></tr>
+{{end}}</tbody>
+</table>
+{{template "pager" .SessionsPager}}
+</section>
+
+<section aria-labelledby="installations">
+<h2 id="installations">Recent installations</h2>
+<table>
+<caption>Recent installations, newest first, page {{.InstallationsPager.Page}}</caption>
+<thead><tr><th scope="col">Machine</th><th scope="col">Created</th><th scope="col">Version</th></tr></thead>
+<tbody>
+{{range .Installations}}<tr><th scope="row">{{.MachineId}}</th><td>{{template "time" .CreatedAt}}</td><td>{{.XMPPVOXVersion}}</td></tr>
+{{else}}<tr><td colspan="3">No installations.</td></tr>
+{{end}}</tbody>
+</table>
+{{template "pager" .InstallationsPager}}
+</section>
+
+<section aria-labelledby="chart">
+<h2 id="chart">Sessions per day</h2>
+{{with .ChartBusiest}}<p>{{$.ChartTotal}} sessions in {{len $.Days}} days. The busiest day was {{longDay .Day}}, with {{.Count}} sessions.</p>{{end}}
+<table class="chart">
+<caption>Sessions per UTC day, oldest first</caption>
+<thead><tr><th scope="col">Day</th><th scope="col">Sessions</th><td aria-hidden="true"></td></tr></thead>
+<tbody>
+{{range .Days}}<tr><th scope="row"><time datetime="{{.Day.Format "2006-01-02"}}" title="{{longDay .Day}}">{{day .Day}}</time></th><td>{{.Count}}</td><td class="bar-cell" aria-hidden="true"><div class="bar" style="width: {{.Percent}}%"></div></td></tr>
+{{end}}</tbody>
+</table>
+</section>
+
+<section aria-labelledby="blocks">
+<h2 id="blocks">Blocks</h2>
+<table>
+<caption>Blocks, oldest first</caption>
+<thead><tr><th scope="col">Field</th><th scope="col">Value</th><th scope="col">Message</th><th scope="col">Created</th><th scope="col">Action</th></tr></thead>
+<tbody>
+{{$csrf := .CSRFToken}}{{range .Blocks}}<tr><td>{{.Field}}</td><th scope="row">{{.Value}}</th><td>{{.Message}}</td><td>{{template "time" .CreatedAt}}</td><td>
+<form method="post" action="/admin/ui/blocks/remove"><input type="hidden" name="csrf_token" value="{{$csrf}}"><input type="hidden" name="block_id" value="{{.Id.Hex}}"><button type="submit" aria-label="Remove block of {{.Field}} {{.Value}}">Remove</button></form>
+</td></tr>
+{{else}}<tr><td colspan="5">No blocks.</td></tr>
+{{end}}</tbody>
+</table>
+<form method="post" action="/admin/ui/blocks/new">
+<fieldset>
+<legend>Add a block</legend>
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<label for="block-field">Block</label> <select id="block-field" name="field">{{range .BlockFields}}<option>{{.}}</option>{{end}}</select>
+<label for="block-value">Value</label> <input id="block-value" name="value" required aria-required="true">
+<label for="block-message">Message to the user</label> <input id="block-message" name="message" size="40" required aria-required="true">
+<button type="submit">Add block</button>
+</fieldset>
+</form>
+</section>
+</main>
+</body>
+</html>
+{{define "time"}}{{if .IsZero}}-{{else}}<time datetime="{{iso .}}">{{time .}}</time>{{end}}{{end}}
+{{define "pager"}}<nav aria-label="Pages of {{.Label}}"><p>Page {{.Page}}{{if .Prev}} <a href="{{.Prev}}" aria-label="Previous page of {{.Label}}">Previous</a>{{end}}{{if .Next}} <a href="{{.Next}}" aria-label="Next page of {{.Label}}">Next</a>{{end}}</p></nav>{{end}}
+`
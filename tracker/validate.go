@@ -0,0 +1,398 @@
+package tracker
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ConfigErrors lists every problem found in a configuration.
+type ConfigErrors []string
+
+func (errs ConfigErrors) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(errs, "\n  - ")
+}
+
+// Validate checks the configuration needed to run the server.
+func (c *Config) Validate() error {
+	return c.validate(true)
+}
+
+// validate reports all problems at once, so that they can be fixed in one go.
+// Without requireServer, the http and mongo sections are optional, as in mock mode.
+func (c *Config) validate(requireServer bool) error {
+	var errs ConfigErrors
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+	switch {
+	case c.Http != nil:
+		if c.Http.Port < 1 || c.Http.Port > 65535 {
+			add("http.port must be between 1 and 65535, got %d", c.Http.Port)
+		}
+		if _, err := parseTrustedProxies(c.Http.TrustedProxies); err != nil {
+			add("http.trusted_proxies: %v", err)
+		}
+		if c.Http.RegionHeader != "" && len(c.Http.TrustedProxies) == 0 {
+			add("http.region_header requires http.trusted_proxies, which set it")
+		}
+	case requireServer:
+		add("missing http section")
+	}
+	switch {
+	case c.Mongo != nil:
+		if c.Mongo.URL == "" {
+			add("mongo.url is required")
+		} else if err := validateMongoURL(c.Mongo.URL); err != nil {
+			add("mongo.url: %v", err)
+		}
+		if c.Mongo.DB == "" {
+			add("mongo.db is required")
+		} else if strings.ContainsAny(c.Mongo.DB, `/\. "$`) {
+			add("mongo.db %q contains characters not allowed in database names", c.Mongo.DB)
+		}
+		switch c.Mongo.Mode {
+		case "", "strong", "monotonic", "eventual":
+		default:
+			add("mongo.mode must be empty, %q, %q or %q, got %q", "strong", "monotonic", "eventual", c.Mongo.Mode)
+		}
+		if c.Mongo.PoolLimit < 0 || c.Mongo.SyncTimeout.Duration < 0 || c.Mongo.SocketTimeout.Duration < 0 ||
+			c.Mongo.MaxBackoff.Duration < 0 {
+			add("mongo.pool_limit, mongo.sync_timeout, mongo.socket_timeout and mongo.max_backoff must not be negative")
+		}
+	case requireServer:
+		add("missing mongo section")
+	}
+	if c.GRPC != nil {
+		if c.GRPC.Port < 1 || c.GRPC.Port > 65535 {
+			add("grpc.port must be between 1 and 65535, got %d", c.GRPC.Port)
+		} else if c.Http != nil && c.GRPC.Port == c.Http.Port && c.GRPC.Host == c.Http.Host {
+			add("grpc.port must differ from http.port on the same host, got %d for both", c.GRPC.Port)
+		}
+	}
+	if c.Admin != nil {
+		for i, t := range c.Admin.Tokens {
+			if t.Token == "" {
+				add("admin.tokens[%d] is empty", i)
+			}
+			if t.Role != RoleOperator && t.Role != RoleResearcher {
+				add("admin.tokens[%d] has unknown role %q", i, t.Role)
+			}
+		}
+		if c.Admin.ReopenWindow.Duration < 0 {
+			add("admin.reopen_window must not be negative")
+		}
+	}
+	if c.Export != nil {
+		for role, profile := range c.Export.Profiles {
+			switch profile {
+			case ProfileFull, ProfileAggregateOnly:
+			case ProfilePseudonymized:
+				if c.Export.Salt == "" {
+					add("export.profiles[%q] is pseudonymized, which requires export.salt", role)
+				}
+			default:
+				add("export.profiles[%q] has unknown profile %q", role, profile)
+			}
+		}
+	}
+	if c.Ops != nil {
+		if c.Ops.CapacityRPS < 0 || c.Ops.MaxShedRate < 0 || c.Ops.LatencyTarget.Duration < 0 {
+			add("ops.capacity_rps, ops.max_shed_rate and ops.latency_target must not be negative")
+		}
+		if c.Ops.ScaleUpAt < 0 || c.Ops.ScaleUpAt > 1 || c.Ops.ScaleDownAt < 0 || c.Ops.ScaleDownAt > 1 {
+			add("ops.scale_up_at and ops.scale_down_at must be between 0 and 1")
+		} else if c.Ops.ScaleUpAt > 0 && c.Ops.ScaleDownAt >= c.Ops.ScaleUpAt {
+			add("ops.scale_down_at must be lower than ops.scale_up_at")
+		}
+	}
+	if c.Reaper != nil && (c.Reaper.ExpireAfter.Duration < 0 || c.Reaper.Interval.Duration < 0) {
+		add("reaper.expire_after and reaper.interval must not be negative")
+	}
+	if c.Sessions != nil && c.Sessions.MinPingInterval.Duration < 0 {
+		add("sessions.min_ping_interval must not be negative")
+	}
+	if c.Sessions != nil && c.Sessions.ResumeWindow.Duration < 0 {
+		add("sessions.resume_window must not be negative")
+	}
+	if c.Stats != nil && c.Stats.StaleAfter.Duration < 0 {
+		add("stats.stale_after must not be negative")
+	}
+	if p := c.Privacy; p != nil {
+		switch {
+		case p.JIDSalt != "" && p.JIDSaltFile != "":
+			add("privacy.jid_salt and privacy.jid_salt_file exclude each other")
+		case p.HashJIDs && p.JIDSalt == "" && p.JIDSaltFile == "":
+			add("privacy.hash_jids requires privacy.jid_salt or privacy.jid_salt_file")
+		}
+	}
+	if c.Rollups != nil && c.Rollups.SampleInterval.Duration < 0 {
+		add("rollups.sample_interval must not be negative")
+	}
+	if c.Stats != nil && c.Stats.Timezone != "" {
+		if _, err := time.LoadLocation(c.Stats.Timezone); err != nil {
+			add("stats.timezone must be a time zone like America/Sao_Paulo, got %q", c.Stats.Timezone)
+		}
+	}
+	if c.Support != nil && (c.Support.ClaimTTL.Duration < 0 || c.Support.DebugFor.Duration < 0) {
+		add("support.claim_ttl and support.debug_for must not be negative")
+	}
+	if c.StorageStats != nil && c.StorageStats.Interval.Duration < 0 {
+		add("storage_stats.interval must not be negative")
+	}
+	if r := c.Retention; r != nil && (r.SessionRequests.Duration < 0 || r.Events.Duration < 0 ||
+		r.Crashes.Duration < 0 || r.Interval.Duration < 0) {
+		add("retention.session_requests, retention.events, retention.crashes and retention.interval must not be negative")
+	}
+	if c.Queue != nil && (c.Queue.MaxEntries < 0 || c.Queue.ReplayInterval.Duration < 0) {
+		add("queue.max_entries and queue.replay_interval must not be negative")
+	}
+	if c.CORS != nil {
+		for i, origin := range c.CORS.AllowedOrigins {
+			if origin == "*" {
+				continue
+			}
+			if u, err := url.Parse(origin); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+				(u.Path != "" && u.Path != "/") {
+				add("cors.allowed_origins[%d] must be \"*\" or an origin like https://dashboard.example.org, got %q", i, origin)
+			}
+		}
+		if c.CORS.MaxAge.Duration < 0 {
+			add("cors.max_age must not be negative")
+		}
+	}
+	if c.Compression != nil {
+		if c.Compression.MinBytes < 0 {
+			add("compression.min_bytes must not be negative")
+		}
+		if c.Compression.Level < 0 || c.Compression.Level > 9 {
+			add("compression.level must be between 0 and 9, got %d", c.Compression.Level)
+		}
+	}
+	if a := c.Alerts; a != nil {
+		if a.Interval.Duration < 0 || a.Cooldown.Duration < 0 || a.NoSessionsFor.Duration < 0 ||
+			a.StorageDownFor.Duration < 0 || a.MinRequests < 0 {
+			add("alerts.interval, alerts.cooldown, alerts.min_requests, alerts.no_sessions_for and alerts.storage_down_for must not be negative")
+		}
+		if a.ErrorRate < 0 || a.ErrorRate >= 1 {
+			add("alerts.error_rate must be at least 0 and below 1, got %g", a.ErrorRate)
+		}
+		if a.AnomalyThreshold < 0 || a.AnomalyMinSessions < 0 {
+			add("alerts.anomaly_threshold and alerts.anomaly_min_sessions must not be negative")
+		}
+		if a.AnomalyDays < 0 || a.AnomalyDays > maxAnomalyDays {
+			add("alerts.anomaly_days must be between 0 and %d, got %d", maxAnomalyDays, a.AnomalyDays)
+		}
+		if e := a.Email; e != nil {
+			if _, _, err := net.SplitHostPort(e.SMTP); err != nil {
+				add("alerts.email.smtp must be a host:port, got %q", e.SMTP)
+			}
+			if e.From == "" || len(e.To) == 0 {
+				add("alerts.email.from and alerts.email.to are required")
+			}
+		}
+		if a.Slack != nil {
+			if u, err := url.Parse(a.Slack.WebhookURL); err != nil || u.Scheme != "https" || u.Host == "" {
+				add("alerts.slack.webhook_url must be a https URL")
+			}
+		}
+		if a.Telegram != nil && (a.Telegram.BotToken == "" || a.Telegram.ChatID == "") {
+			add("alerts.telegram.bot_token and alerts.telegram.chat_id are required")
+		}
+	}
+	if cc := c.Cache; cc != nil {
+		if _, _, err := net.SplitHostPort(cc.Redis); err != nil {
+			add("cache.redis must be a host:port, got %q", cc.Redis)
+		}
+		if cc.DB < 0 || cc.TTL.Duration < 0 || cc.PingWriteInterval.Duration < 0 {
+			add("cache.db, cache.ttl and cache.ping_write_interval must not be negative")
+		}
+		if w := cc.PingWriteInterval.Duration; w > 0 && c.Reaper != nil && c.Reaper.ExpireAfter.Duration > 0 &&
+			w >= c.Reaper.ExpireAfter.Duration {
+			add("cache.ping_write_interval must be shorter than reaper.expire_after, got %s", w)
+		}
+	}
+	if l := c.Log; l != nil {
+		if _, ok := parseLevel(l.Level); !ok {
+			add("log.level must be debug, info, warn or error, got %q", l.Level)
+		}
+		if l.SlowStorage.Duration < 0 {
+			add("log.slow_storage must not be negative")
+		}
+		switch l.Output {
+		case "", LogStderr, LogSyslog:
+		case LogFile:
+			if l.File == "" {
+				add("log.file is required with the file output")
+			}
+		default:
+			add("log.output must be stderr, file or syslog, got %q", l.Output)
+		}
+		if l.MaxSizeMB < 0 || l.MaxBackups < 0 {
+			add("log.max_size_mb and log.max_backups must not be negative")
+		}
+		if l.Syslog != "" {
+			if _, _, err := net.SplitHostPort(l.Syslog); err != nil {
+				add("log.syslog must be a host:port, got %q", l.Syslog)
+			}
+		}
+	}
+	if c.Sentry != nil && c.Sentry.DSN != "" {
+		if _, err := parseSentryDSN(c.Sentry.DSN); err != nil {
+			add("sentry.dsn must be like https://<key>@sentry.example.org/<project>")
+		}
+	}
+	if a := c.Abuse; a != nil && (a.Interval.Duration < 0 || a.Window.Duration < 0 ||
+		a.MaxMachinesPerIP < 0 || a.MaxSessionsPerMachine < 0) {
+		add("abuse.interval, abuse.window, abuse.max_machines_per_ip and abuse.max_sessions_per_machine must not be negative")
+	}
+	if c.MachineIds != nil {
+		for _, f := range c.MachineIds.Formats {
+			if !contains(machineIdFormats, f) {
+				add("machine_ids.formats must be among %v, got %q", machineIdFormats, f)
+			}
+		}
+	}
+	if c.Client != nil {
+		var expireAfter, minPing time.Duration
+		if c.Reaper != nil {
+			expireAfter = c.Reaper.ExpireAfter.Duration
+		}
+		if c.Sessions != nil {
+			minPing = c.Sessions.MinPingInterval.Duration
+		}
+		checkClient := func(name string, ping Duration, maxMessages int) {
+			switch {
+			case ping.Duration < 0:
+				add("%s.ping_interval must not be negative", name)
+			case ping.Duration > 0 && expireAfter > 0 && ping.Duration >= expireAfter:
+				add("%s.ping_interval must be shorter than reaper.expire_after, got %s", name, ping)
+			case ping.Duration > 0 && ping.Duration < minPing:
+				add("%s.ping_interval must not be shorter than sessions.min_ping_interval, got %s", name, ping)
+			}
+			if maxMessages < 0 {
+				add("%s.max_messages_per_minute must not be negative", name)
+			}
+		}
+		checkClient("client", c.Client.PingInterval, c.Client.MaxMessagesPerMinute)
+		for i, o := range c.Client.Overrides {
+			name := fmt.Sprintf("client.overrides[%d]", i)
+			if o == nil {
+				add("%s is empty", name)
+				continue
+			}
+			checkClient(name, o.PingInterval, o.MaxMessagesPerMinute)
+			if o.MinVersion != "" && o.MaxVersion != "" && compareVersions(o.MinVersion, o.MaxVersion) > 0 {
+				add("%s.min_version must not be after max_version", name)
+			}
+		}
+	}
+	if c.Events != nil && c.Events.MaxPerMinute < 0 {
+		add("events.max_per_minute must not be negative")
+	}
+	if c.Feedback != nil && c.Feedback.MaxPerHour < 0 {
+		add("feedback.max_per_hour must not be negative")
+	}
+	if c.Limits != nil {
+		if c.Limits.MaxBodyBytes < 0 || c.Limits.MaxHeaderBytes < 0 || c.Limits.MaxInfoKeys < 0 {
+			add("limits.max_body_bytes, limits.max_header_bytes and limits.max_info_keys must not be negative")
+		}
+		if c.Limits.RequestTimeout.Duration < 0 {
+			add("limits.request_timeout must not be negative")
+		}
+		for path, d := range c.Limits.RequestTimeouts {
+			if d.Duration < 0 {
+				add("limits.request_timeouts[%q] must not be negative", path)
+			}
+		}
+	}
+	if c.Sessions != nil {
+		switch c.Sessions.Signing {
+		case "", SigningOptional, SigningRequired:
+		default:
+			add("sessions.signing must be empty, %q or %q, got %q", SigningOptional, SigningRequired, c.Sessions.Signing)
+		}
+		switch c.Sessions.OverMaxOpen {
+		case "", OverMaxOpenCloseOldest, OverMaxOpenReject:
+		default:
+			add("sessions.over_max_open must be empty, %q or %q, got %q",
+				OverMaxOpenCloseOldest, OverMaxOpenReject, c.Sessions.OverMaxOpen)
+		}
+		switch c.Sessions.IdFormat {
+		case "", SessionIdObjectId, SessionIdUUID, SessionIdULID:
+		default:
+			add("sessions.id_format must be empty, %q, %q or %q, got %q",
+				SessionIdObjectId, SessionIdUUID, SessionIdULID, c.Sessions.IdFormat)
+		}
+		for _, key := range c.Sessions.MetadataKeys {
+			if !metadataKey.MatchString(key) {
+				add("sessions.metadata_keys must be lowercase letters, digits and underscores, got %q", key)
+			}
+		}
+		switch c.Sessions.UnknownInstallation {
+		case "", UnknownInstallationReject, UnknownInstallationRegister:
+		default:
+			add("sessions.unknown_installation must be empty, %q or %q, got %q",
+				UnknownInstallationReject, UnknownInstallationRegister, c.Sessions.UnknownInstallation)
+		}
+	}
+	if c.APIKeys != nil {
+		switch c.APIKeys.Mode {
+		case "", APIKeysGrace, APIKeysRequired:
+		default:
+			add("api_keys.mode must be empty, %q or %q, got %q", APIKeysGrace, APIKeysRequired, c.APIKeys.Mode)
+		}
+		for i, k := range c.APIKeys.Keys {
+			if k.Key == "" {
+				add("api_keys.keys[%d] is empty", i)
+			}
+			if k.MaxPerMinute < 0 {
+				add("api_keys.keys[%d].max_per_minute must not be negative", i)
+			}
+			if k.App != "" && k.App != defaultApp && findApp(c, k.App) == nil {
+				add("api_keys.keys[%d].app is not in apps.list, got %q", i, k.App)
+			}
+		}
+		if c.APIKeys.MaxPerMinute < 0 {
+			add("api_keys.max_per_minute must not be negative")
+		}
+	}
+	if c.Apps != nil {
+		seen := make(map[string]bool)
+		for i, a := range c.Apps.List {
+			switch {
+			case a == nil:
+				add("apps.list[%d] is empty", i)
+				continue
+			case !appIdPattern.MatchString(a.Id):
+				add("apps.list[%d].id must be lowercase letters, digits and underscores, got %q", i, a.Id)
+			case a.Id == defaultApp:
+				add("apps.list[%d].id must not be %q, the default app", i, defaultApp)
+			case seen[a.Id]:
+				add("apps.list[%d].id %q is repeated", i, a.Id)
+			}
+			seen[a.Id] = true
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// validateMongoURL checks the syntax of a MongoDB URL, with or without the mongodb:// prefix.
+func validateMongoURL(s string) error {
+	if !strings.HasPrefix(s, "mongodb://") {
+		s = "mongodb://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in %q", s)
+	}
+	return nil
+}
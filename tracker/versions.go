@@ -0,0 +1,31 @@
+package tracker
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// deprecatedV1 wraps a handler of API v1, telling clients in the
+// Deprecation, Sunset and Link headers that v1 is deprecated, when it stops
+// being served and where its successor is, and refusing requests with 410
+// and code api_disabled once api.disable_v1 is set.
+func deprecatedV1(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conf := requestConfig(r)
+		successor := "/2" + strings.TrimPrefix(r.URL.Path, "/1")
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		if conf != nil && conf.API != nil {
+			if !conf.API.V1Sunset.IsZero() {
+				w.Header().Set("Sunset", conf.API.V1Sunset.UTC().Format(http.TimeFormat))
+			}
+			if conf.API.DisableV1 {
+				writeError(w, r, &APIError{"api_disabled", "", "",
+					fmt.Sprintf("API v1 is no longer served, use %s", successor)}, http.StatusGone)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
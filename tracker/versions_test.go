@@ -0,0 +1,80 @@
+package tracker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type versionsTest struct {
+	old *Config
+}
+
+func newVersionsTest(t *testing.T) *versionsTest {
+	s := &versionsTest{}
+	s.old = currentConfig()
+	store := NewMemoryStore()
+	openStore = func() (Storage, func()) {
+		return store, func() {}
+	}
+	t.Cleanup(func() {
+		setConfig(s.old)
+	})
+	return s
+}
+
+func (s *versionsTest) newSession(version string) *httptest.ResponseRecorder {
+	form := url.Values{"jid": {"testuser@server.org"}, "machine_id": {"00:26:cc:18:be:14"}, "xmppvox_version": {"1.0"}}
+	req, _ := http.NewRequest("POST", "/"+version+"/session/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	APIHandler().ServeHTTP(w, req)
+	return w
+}
+
+func TestV1IsDeprecated(t *testing.T) {
+	s := newVersionsTest(t)
+	setConfig(&Config{API: &APIConfig{V1Sunset: time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)}})
+	w := s.newSession("1")
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Deprecation"), "true"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Sunset"), "Thu, 01 Jan 2015 00:00:00 GMT"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Link"), `</2/session/new>; rel="successor-version"`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	w = s.newSession("2")
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Deprecation"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := w.Header().Get("Sunset"), ""; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDisableV1(t *testing.T) {
+	s := newVersionsTest(t)
+	setConfig(&Config{API: &APIConfig{DisableV1: true}})
+	w := s.newSession("1")
+	if got, want := w.Code, http.StatusGone; got != want {
+		t.Errorf("w.Code = %v, want %v", got, want)
+	}
+	if got, want := w.Body.String(), "API v1 is no longer served, use /2/session/new\n"; got != want {
+		t.Errorf("w.Body.String() = %v, want %v", got, want)
+	}
+	if got, want := s.newSession("2").Code, http.StatusOK; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
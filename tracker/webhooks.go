@@ -0,0 +1,411 @@
+package tracker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session events delivered to webhooks.
+const (
+	WebhookSessionNew      = "session.new"
+	WebhookSessionClose    = "session.close"
+	WebhookSessionReopen   = "session.reopen"
+	WebhookSessionTransfer = "session.transfer"
+)
+
+var webhookEvents = []string{WebhookSessionNew, WebhookSessionClose, WebhookSessionReopen, WebhookSessionTransfer}
+
+const (
+	// maxWebhookDeliveries bounds the deliveries in flight; more are dropped.
+	maxWebhookDeliveries = 32
+	webhookTimeout       = 10 * time.Second
+	// webhookCacheTTL is how long the webhooks of an app are kept by a
+	// webhookCache, and so how long the other trackers of a cluster take
+	// to notice a webhook added or removed.
+	webhookCacheTTL = time.Minute
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// jidDomain returns the domain of a jid like user@domain/resource, in lowercase.
+func jidDomain(jid string) string {
+	if i := strings.Index(jid, "/"); i >= 0 {
+		jid = jid[:i]
+	}
+	if i := strings.LastIndex(jid, "@"); i >= 0 {
+		jid = jid[i+1:]
+	}
+	return strings.ToLower(jid)
+}
+
+// compareVersions compares dotted versions like 1.10 and 1.9 part by part,
+// numerically when both parts start with numbers, as in 11-beta1, and then
+// by the rest of the parts, returning -1, 0 or +1.
+func compareVersions(a, b string) int {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y string
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		nx, rx := leadingNumber(x)
+		ny, ry := leadingNumber(y)
+		if rx != x && ry != y {
+			// Both parts start with numbers.
+			if nx != ny {
+				if nx < ny {
+					return -1
+				}
+				return 1
+			}
+			x, y = rx, ry
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// leadingNumber splits the number at the start of s, like 11 of 11-beta1,
+// from the rest. rest is s when s does not start with a digit.
+func leadingNumber(s string) (n int, rest string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil {
+		return 0, s
+	}
+	return n, s[i:]
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether the event about s passes the filter.
+func (f *WebhookFilter) Matches(event string, s *Session) bool {
+	if len(f.Events) > 0 && !contains(f.Events, event) {
+		return false
+	}
+	if len(f.JIDDomains) > 0 && !contains(f.JIDDomains, jidDomain(s.JID)) {
+		return false
+	}
+	if len(f.Projects) > 0 && !contains(f.Projects, s.Project) {
+		return false
+	}
+	if f.MinVersion != "" && compareVersions(s.XMPPVOXVersion, f.MinVersion) < 0 {
+		return false
+	}
+	if f.MaxVersion != "" && compareVersions(s.XMPPVOXVersion, f.MaxVersion) > 0 {
+		return false
+	}
+	return true
+}
+
+// webhookPayload is the JSON body of a delivery.
+type webhookPayload struct {
+	Event   string          `json:"event"`
+	At      time.Time       `json:"at"`
+	Session *webhookSession `json:"session"`
+}
+
+type webhookSession struct {
+	Id             string    `json:"id"`
+	Alias          string    `json:"alias"`
+	JID            string    `json:"jid"`
+	MachineId      string    `json:"machine_id"`
+	XMPPVOXVersion string    `json:"xmppvox_version"`
+	Project        string    `json:"project,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	ClosedAt       time.Time `json:"closed_at"`
+	ClosedReason   string    `json:"closed_reason,omitempty"`
+}
+
+func newWebhookPayload(event string, s *Session) *webhookPayload {
+	return &webhookPayload{event, time.Now().UTC(), &webhookSession{
+		Id:             s.Id.String(),
+		Alias:          formatAlias(s.Alias),
+		JID:            s.JID,
+		MachineId:      s.MachineId,
+		XMPPVOXVersion: s.XMPPVOXVersion,
+		Project:        s.Project,
+		CreatedAt:      s.CreatedAt,
+		ClosedAt:       s.ClosedAt,
+		ClosedReason:   s.ClosedReason,
+	}}
+}
+
+// webhookSignature is the X-Webhook-Signature of a delivery, so that
+// receivers can check it comes from us.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook delivers body to h. It is a variable for tests.
+var postWebhook = func(h *Webhook, body []byte) error {
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-Webhook-Signature", webhookSignature(h.Secret, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookCache keeps the webhooks of each app for webhookCacheTTL, so that
+// events are not each a read of storage. Adding or removing a webhook drops
+// those of its app. It is safe for concurrent use.
+type webhookCache struct {
+	sync.Mutex
+	apps map[string]*cachedWebhooks
+}
+
+type cachedWebhooks struct {
+	hooks []*Webhook
+	at    time.Time
+}
+
+func newWebhookCache() *webhookCache {
+	return &webhookCache{apps: make(map[string]*cachedWebhooks)}
+}
+
+// get returns the webhooks of the app of c, reading them from c.Store once
+// those cached are older than webhookCacheTTL.
+func (wc *webhookCache) get(c *Context, now time.Time) ([]*Webhook, error) {
+	wc.Lock()
+	cached := wc.apps[c.App]
+	wc.Unlock()
+	if cached != nil && now.Sub(cached.at) < webhookCacheTTL {
+		return cached.hooks, nil
+	}
+	hooks, err := c.Store.Webhooks()
+	if err != nil {
+		return nil, err
+	}
+	wc.Lock()
+	wc.apps[c.App] = &cachedWebhooks{hooks, now}
+	wc.Unlock()
+	return hooks, nil
+}
+
+func (wc *webhookCache) forget(app string) {
+	wc.Lock()
+	defer wc.Unlock()
+	delete(wc.apps, app)
+}
+
+// webhooks returns the webhooks of the app of c, from the cache of its
+// server if any.
+func (c *Context) webhooks() ([]*Webhook, error) {
+	if c.Server != nil && c.Server.webhooks != nil {
+		return c.Server.webhooks.get(c, time.Now())
+	}
+	return c.Store.Webhooks()
+}
+
+// forgetWebhooks drops the cached webhooks of the app of c, once one was
+// added or removed.
+func (c *Context) forgetWebhooks() {
+	if c.Server != nil && c.Server.webhooks != nil {
+		c.Server.webhooks.forget(c.App)
+	}
+}
+
+// webhookDeliveries bounds to maxWebhookDeliveries and tracks the
+// deliveries in flight of a Server.
+type webhookDeliveries struct {
+	slots    chan struct{}
+	inFlight sync.WaitGroup
+}
+
+func newWebhookDeliveries() *webhookDeliveries {
+	return &webhookDeliveries{slots: make(chan struct{}, maxWebhookDeliveries)}
+}
+
+// processDeliveries are the deliveries of the events notified without a
+// Server, as in tests.
+var processDeliveries = newWebhookDeliveries()
+
+// deliveries returns the webhook deliveries of the server of c, if any.
+func (c *Context) deliveries() *webhookDeliveries {
+	if c.Server != nil && c.Server.deliveries != nil {
+		return c.Server.deliveries
+	}
+	return processDeliveries
+}
+
+// notifyWebhooks delivers an event about s to the webhooks whose filter
+// matches it, in the background and without retries. Failures are logged.
+func notifyWebhooks(c *Context, event string, s *Session) {
+	hooks, err := c.webhooks()
+	if err != nil {
+		c.Log.Error("[webhook]", err)
+		return
+	}
+	d := c.deliveries()
+	var body []byte
+	for _, h := range hooks {
+		if !h.Filter.Matches(event, s) {
+			continue
+		}
+		if body == nil {
+			if body, err = json.Marshal(newWebhookPayload(event, s)); err != nil {
+				c.Log.Error("[webhook]", err)
+				return
+			}
+		}
+		select {
+		case d.slots <- struct{}{}:
+		default:
+			c.Log.Warn("[webhook] too many deliveries in flight, dropped", event, "for", h.URL)
+			continue
+		}
+		d.inFlight.Add(1)
+		go func(h *Webhook) {
+			defer d.inFlight.Done()
+			defer func() { <-d.slots }()
+			if err := postWebhook(h, body); err != nil {
+				c.Log.Error("[webhook]", event, h.URL, err)
+			}
+		}(h)
+	}
+}
+
+// wait waits for the deliveries in flight up to timeout, reporting whether
+// they all ended.
+func (d *webhookDeliveries) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		d.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// NewWebhookHandler subscribes a URL to session events,
+// replying with the id and the signing secret of the webhook.
+func NewWebhookHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"url"}, "events", "jid_domains", "projects", "min_version", "max_version")
+	if s := r.PostFormValue("url"); s != "" {
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, &APIError{"invalid_value", "url", "", fmt.Sprintf("Invalid url %s, expected a http or https URL", s)})
+		}
+	}
+	filter := WebhookFilter{
+		Events:     splitList(r.PostFormValue("events")),
+		JIDDomains: splitList(strings.ToLower(r.PostFormValue("jid_domains"))),
+		Projects:   splitList(r.PostFormValue("projects")),
+		MinVersion: r.PostFormValue("min_version"),
+		MaxVersion: r.PostFormValue("max_version"),
+	}
+	for _, event := range filter.Events {
+		if !contains(webhookEvents, event) {
+			errs = append(errs, &APIError{"invalid_value", "events", "",
+				fmt.Sprintf("Invalid event %s, expected some of %v", event, webhookEvents)})
+		}
+	}
+	if filter.MinVersion != "" && filter.MaxVersion != "" && compareVersions(filter.MinVersion, filter.MaxVersion) > 0 {
+		errs = append(errs, &APIError{"invalid_value", "min_version", "", "Invalid version range, min_version is after max_version"})
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	h := NewWebhook(r.PostFormValue("url"), newSecret(), filter)
+	if err := c.Store.InsertWebhook(h); err != nil {
+		writeError(w, r, internalError("Failed to add webhook"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	c.forgetWebhooks()
+	a := NewAuditEntry("webhook.add", h.Id.Hex(), c.actor(r), "", bson.M{"url": h.URL, "filter": h.Filter})
+	if err := c.Store.InsertAuditEntry(a); err != nil {
+		c.Log.Error(err)
+	}
+	fmt.Fprintln(w, h.Id.Hex())
+	fmt.Fprintln(w, h.Secret)
+}
+
+// RemoveWebhookHandler unsubscribes a webhook.
+func RemoveWebhookHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	errs := checkParams(r, []string{"webhook_id"})
+	idHex := r.PostFormValue("webhook_id")
+	if idHex != "" && !bson.IsObjectIdHex(idHex) {
+		errs = append(errs, &APIError{"invalid_webhook_id", "webhook_id", "", fmt.Sprintf("Invalid webhook id %s", idHex)})
+	}
+	if errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	switch err := c.Store.RemoveWebhook(bson.ObjectIdHex(idHex)); err {
+	case nil:
+		c.forgetWebhooks()
+		a := NewAuditEntry("webhook.remove", idHex, c.actor(r), "", nil)
+		if err := c.Store.InsertAuditEntry(a); err != nil {
+			c.Log.Error(err)
+		}
+		fmt.Fprintln(w, idHex)
+	case mgo.ErrNotFound:
+		writeError(w, r, &APIError{"webhook_not_found", "webhook_id", "", fmt.Sprintf("Webhook %s does not exist", idHex)},
+			http.StatusBadRequest)
+	default:
+		writeError(w, r, internalError(fmt.Sprintf("Failed to remove webhook %s", idHex)), http.StatusInternalServerError)
+		c.Log.Error(err)
+	}
+}
+
+// WebhooksHandler lists all webhooks, oldest first, without their secrets.
+func WebhooksHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	hooks, err := c.Store.Webhooks()
+	if err != nil {
+		writeError(w, r, internalError("Failed to list webhooks"), http.StatusInternalServerError)
+		c.Log.Error(err)
+		return
+	}
+	if hooks == nil {
+		hooks = []*Webhook{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(hooks)
+}
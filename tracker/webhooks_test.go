@@ -0,0 +1,131 @@
+package tracker
+
+import (
+	"labix.org/v2/mgo/bson"
+	"testing"
+	"time"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.9", "1.10", -1},
+		{"2.0", "1.10", 1},
+		{"1.0", "1.0.1", -1},
+		{"1.0-beta", "1.0-rc", -1},
+		{"1.9", "1.11-beta1", -1},
+		{"1.11-beta1", "1.11-beta2", -1},
+		{"1.01", "1.1", 0},
+	} {
+		if got, want := compareVersions(tc.a, tc.b), tc.want; got != want {
+			t.Errorf("%s vs %s: compareVersions(tc.a, tc.b) = %v, want %v", tc.a, tc.b, got, want)
+		}
+		if got, want := compareVersions(tc.b, tc.a), -tc.want; got != want {
+			t.Errorf("%s vs %s: compareVersions(tc.b, tc.a) = %v, want %v", tc.b, tc.a, got, want)
+		}
+	}
+}
+
+func TestJIDDomain(t *testing.T) {
+	if got, want := jidDomain("user@Server.org/XMPPVOX"), "server.org"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, want := jidDomain("server.org"), "server.org"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	session := &Session{JID: "user@partner.org", XMPPVOXVersion: "1.2", Project: "partner"}
+	for _, tc := range []struct {
+		filter WebhookFilter
+		want   bool
+	}{
+		{WebhookFilter{}, true},
+		{WebhookFilter{Events: []string{WebhookSessionNew}}, true},
+		{WebhookFilter{Events: []string{WebhookSessionClose}}, false},
+		{WebhookFilter{JIDDomains: []string{"other.org", "partner.org"}}, true},
+		{WebhookFilter{JIDDomains: []string{"other.org"}}, false},
+		{WebhookFilter{Projects: []string{"partner"}}, true},
+		{WebhookFilter{Projects: []string{"other"}}, false},
+		{WebhookFilter{MinVersion: "1.2", MaxVersion: "1.10"}, true},
+		{WebhookFilter{MinVersion: "1.3"}, false},
+		{WebhookFilter{MaxVersion: "1.1"}, false},
+	} {
+		if got, want := tc.filter.Matches(WebhookSessionNew, session), tc.want; got != want {
+			t.Errorf("%+v: tc.filter.Matches(WebhookSessionNew, session) = %v, want %v", tc.filter, got, want)
+		}
+	}
+}
+
+func TestWaitWebhooks(t *testing.T) {
+	d := newWebhookDeliveries()
+	d.inFlight.Add(1)
+	if got, want := d.wait(10*time.Millisecond), false; got != want {
+		t.Errorf("d.wait(10*time.Millisecond) = %v, want %v", got, want)
+	}
+	d.inFlight.Done()
+	if got, want := d.wait(time.Second), true; got != want {
+		t.Errorf("d.wait(time.Second) = %v, want %v", got, want)
+	}
+}
+
+// webhooksStore counts the reads of webhooks.
+type webhooksStore struct {
+	*MemoryStore
+	reads int
+}
+
+func (s *webhooksStore) Webhooks() ([]*Webhook, error) {
+	s.reads++
+	return s.MemoryStore.Webhooks()
+}
+
+func TestWebhookCache(t *testing.T) {
+	store := &webhooksStore{MemoryStore: NewMemoryStore()}
+	if err := store.InsertWebhook(&Webhook{Id: bson.NewObjectId(), URL: "https://partner.org/hooks"}); err != nil {
+		t.Fatal(err)
+	}
+	wc := newWebhookCache()
+	c := &Context{Store: store}
+	now := time.Now()
+	for _, at := range []time.Time{now, now.Add(webhookCacheTTL / 2)} {
+		hooks, err := wc.get(c, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(hooks), 1; got != want {
+			t.Errorf("len(hooks) = %d, want %d", got, want)
+		}
+	}
+	if got, want := store.reads, 1; got != want {
+		t.Errorf("store.reads = %v, want %v", got, want)
+	}
+	if _, err := wc.get(c, now.Add(webhookCacheTTL)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := store.reads, 2; got != want {
+		t.Errorf("store.reads = %v, want %v", got, want)
+	}
+
+	// Apps are cached apart, and forgotten when their webhooks change.
+	if _, err := wc.get(&Context{Store: store, App: "other"}, now); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := store.reads, 3; got != want {
+		t.Errorf("store.reads = %v, want %v", got, want)
+	}
+	wc.forget("")
+	if _, err := wc.get(c, now.Add(webhookCacheTTL)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wc.get(&Context{Store: store, App: "other"}, now); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := store.reads, 4; got != want {
+		t.Errorf("store.reads = %v, want %v", got, want)
+	}
+}
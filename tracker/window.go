@@ -0,0 +1,155 @@
+package tracker
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// TimeWindow is the span of time of a stats, export or chart request,
+// including From and excluding To. A zero From or To leaves that end open.
+type TimeWindow struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// Contains reports whether t is within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	return (w.From.IsZero() || !t.Before(w.From)) && (w.To.IsZero() || t.Before(w.To))
+}
+
+// windowRanges are the named windows of the range parameter.
+// Weeks start on Monday.
+var windowRanges = map[string]func(today time.Time) (from, to time.Time){
+	"today": func(today time.Time) (time.Time, time.Time) {
+		return today, today.AddDate(0, 0, 1)
+	},
+	"yesterday": func(today time.Time) (time.Time, time.Time) {
+		return today.AddDate(0, 0, -1), today
+	},
+	"this_week": func(today time.Time) (time.Time, time.Time) {
+		monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return monday, monday.AddDate(0, 0, 7)
+	},
+	"last_week": func(today time.Time) (time.Time, time.Time) {
+		monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		return monday.AddDate(0, 0, -7), monday
+	},
+	"this_month": func(today time.Time) (time.Time, time.Time) {
+		first := today.AddDate(0, 0, 1-today.Day())
+		return first, first.AddDate(0, 1, 0)
+	},
+	"last_month": func(today time.Time) (time.Time, time.Time) {
+		first := today.AddDate(0, 0, 1-today.Day())
+		return first.AddDate(0, -1, 0), first
+	},
+}
+
+var relativeTime = regexp.MustCompile(`^(\d+)([mhdw])$`)
+
+var relativeUnits = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// parseWindowTime parses a date like 2014-05-01, which is midnight in loc,
+// a RFC 3339 time, "now" or a duration before now like 90m, 24h, 7d or 2w.
+func parseWindowTime(s string, now time.Time, loc *time.Location) (time.Time, bool) {
+	if s == "now" {
+		return now, true
+	}
+	if m := relativeTime.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			return time.Time{}, false
+		}
+		return now.Add(-time.Duration(n) * relativeUnits[m[2]]), true
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		return t, true
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return t, err == nil
+}
+
+// parseWindow parses the from, to, range and tz parameters of r:
+//
+//	from, to  a time as understood by parseWindowTime
+//	range     one of windowRanges, instead of from and to
+//	tz        the time zone of dates and ranges, like America/Sao_Paulo, UTC by default
+//
+// to defaults to now and from to def before to, or the window is open at
+// the start when def is 0. Windows longer than max are refused, unless max is 0.
+func parseWindow(r *http.Request, now time.Time, def, max time.Duration) (TimeWindow, APIErrors) {
+	w, _, errs := parseWindowIn(r, now, def, max, time.UTC)
+	return w, errs
+}
+
+// parseWindowIn is like parseWindow, with tz defaulting to zone, and also
+// returns the time zone of the window.
+func parseWindowIn(r *http.Request, now time.Time, def, max time.Duration, zone *time.Location) (w TimeWindow,
+	loc *time.Location, errs APIErrors) {
+	loc = zone
+	if s := r.FormValue("tz"); s != "" {
+		l, err := time.LoadLocation(s)
+		if err != nil {
+			errs = append(errs, &APIError{"invalid_value", "tz", "",
+				fmt.Sprintf("Invalid tz %s, expected a time zone like America/Sao_Paulo", s)})
+			return
+		}
+		loc = l
+	}
+	if name := r.FormValue("range"); name != "" {
+		fn, ok := windowRanges[name]
+		switch {
+		case !ok:
+			errs = append(errs, &APIError{"invalid_value", "range", "",
+				fmt.Sprintf("Invalid range %s, expected one of today, yesterday, this_week, last_week, this_month, last_month", name)})
+		case r.FormValue("from") != "" || r.FormValue("to") != "":
+			errs = append(errs, &APIError{"invalid_window", "range", "", "Invalid window, range excludes from and to"})
+		default:
+			local := now.In(loc)
+			w.From, w.To = fn(time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc))
+		}
+	} else {
+		w.To = now
+		if s := r.FormValue("to"); s != "" {
+			t, ok := parseWindowTime(s, now, loc)
+			if !ok {
+				errs = append(errs, invalidWindowTime("to", s))
+			}
+			w.To = t
+		}
+		if def > 0 {
+			w.From = w.To.Add(-def)
+		}
+		if s := r.FormValue("from"); s != "" {
+			t, ok := parseWindowTime(s, now, loc)
+			if !ok {
+				errs = append(errs, invalidWindowTime("from", s))
+			}
+			w.From = t
+		}
+	}
+	if errs != nil {
+		return
+	}
+	switch {
+	case w.From.IsZero():
+	case !w.From.Before(w.To):
+		errs = append(errs, &APIError{"invalid_window", "from", "", "Invalid window, from must be before to"})
+	case max > 0 && w.To.Sub(w.From) > max:
+		errs = append(errs, &APIError{"invalid_window", "from", "",
+			fmt.Sprintf("Invalid window, at most %d days are allowed", max/(24*time.Hour))})
+	}
+	return
+}
+
+func invalidWindowTime(field, s string) *APIError {
+	return &APIError{"invalid_value", field, "",
+		fmt.Sprintf("Invalid %s %s, expected a date like 2014-05-01, a RFC 3339 time or a duration like 7d", field, s)}
+}
@@ -0,0 +1,120 @@
+package tracker
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func windowOf(query string, now time.Time, def, max time.Duration) (TimeWindow, APIErrors) {
+	r, _ := http.NewRequest("GET", "/1/stats/versions?"+query, nil)
+	return parseWindow(r, now, def, max)
+}
+
+func TestParseWindow(t *testing.T) {
+	// A Saturday
+	now := time.Date(2014, 5, 31, 12, 0, 0, 0, time.UTC)
+	day := func(m time.Month, d int) time.Time { return time.Date(2014, m, d, 0, 0, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		query    string
+		from, to time.Time
+	}{
+		{"", now.Add(-defaultStatsWindow), now},
+		{"from=2014-05-01&to=2014-05-08T00:00:00Z", day(5, 1), day(5, 8)},
+		{"from=7d", now.Add(-7 * 24 * time.Hour), now},
+		{"from=2w&to=90m", now.Add(-14 * 24 * time.Hour), now.Add(-90 * time.Minute)},
+		{"to=24h", now.Add(-24*time.Hour - defaultStatsWindow), now.Add(-24 * time.Hour)},
+		{"from=2014-05-30&to=now", day(5, 30), now},
+		{"range=today", day(5, 31), day(6, 1)},
+		{"range=yesterday", day(5, 30), day(5, 31)},
+		{"range=this_week", day(5, 26), day(6, 2)},
+		{"range=last_week", day(5, 19), day(5, 26)},
+		{"range=this_month", day(5, 1), day(6, 1)},
+		{"range=last_month", day(4, 1), day(5, 1)},
+	} {
+		w, errs := windowOf(tc.query, now, defaultStatsWindow, maxStatsWindow)
+		if got := errs; got != nil {
+			t.Errorf("%s: errs = %v, want nil", tc.query, got)
+		}
+		if got, want := w.From.Equal(tc.from), true; got != want {
+			t.Errorf("%s: from %s: w.From.Equal(tc.from) = %v, want %v", tc.query, w.From, got, want)
+		}
+		if got, want := w.To.Equal(tc.to), true; got != want {
+			t.Errorf("%s: to %s: w.To.Equal(tc.to) = %v, want %v", tc.query, w.To, got, want)
+		}
+	}
+}
+
+func TestParseWindowTimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	// Still May 30 in Sao Paulo
+	now := time.Date(2014, 5, 31, 1, 0, 0, 0, time.UTC)
+	w, errs := windowOf("range=today&tz=America/Sao_Paulo", now, defaultStatsWindow, maxStatsWindow)
+	if got := errs; got != nil {
+		t.Errorf("errs = %v, want nil", got)
+	}
+	if got, want := w.From.Equal(time.Date(2014, 5, 30, 0, 0, 0, 0, loc)), true; got != want {
+		t.Errorf("w.From.Equal(time.Date(2014, 5, 30, 0, 0, 0, 0, loc)) = %v, want %v", got, want)
+	}
+	w, errs = windowOf("from=2014-05-01&tz=America/Sao_Paulo", now, defaultStatsWindow, maxStatsWindow)
+	if got := errs; got != nil {
+		t.Errorf("errs = %v, want nil", got)
+	}
+	if got, want := w.From.Equal(time.Date(2014, 5, 1, 3, 0, 0, 0, time.UTC)), true; got != want {
+		t.Errorf("w.From.Equal(time.Date(2014, 5, 1, 3, 0, 0, 0, time.UTC)) = %v, want %v", got, want)
+	}
+}
+
+func TestParseWindowOpen(t *testing.T) {
+	now := time.Now()
+	w, errs := windowOf("", now, 0, 0)
+	if got := errs; got != nil {
+		t.Errorf("errs = %v, want nil", got)
+	}
+	if got, want := w.From.IsZero(), true; got != want {
+		t.Errorf("w.From.IsZero() = %v, want %v", got, want)
+	}
+	if got, want := w.Contains(time.Time{}.Add(time.Hour)), true; got != want {
+		t.Errorf("w.Contains(time.Time{}.Add(time.Hour)) = %v, want %v", got, want)
+	}
+	if got, want := w.Contains(now), false; got != want {
+		t.Errorf("w.Contains(now) = %v, want %v", got, want)
+	}
+	w, errs = windowOf("from=2000-01-01", now, 0, 0)
+	if got := errs; got != nil {
+		t.Errorf("errs = %v, want nil", got)
+	}
+	if got, want := w.Contains(time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC)), false; got != want {
+		t.Errorf("w.Contains(time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC)) = %v, want %v", got, want)
+	}
+}
+
+func TestParseWindowInvalid(t *testing.T) {
+	now := time.Date(2014, 5, 31, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		query, code, field string
+	}{
+		{"from=yesterday", "invalid_value", "from"},
+		{"to=7x", "invalid_value", "to"},
+		{"from=2014-05-08&to=2014-05-01", "invalid_window", "from"},
+		{"from=2012-01-01", "invalid_window", "from"},
+		{"range=forever", "invalid_value", "range"},
+		{"range=today&from=7d", "invalid_window", "range"},
+		{"tz=Mars/Olympus_Mons", "invalid_value", "tz"},
+	} {
+		_, errs := windowOf(tc.query, now, defaultStatsWindow, maxStatsWindow)
+		if len(errs) != 1 {
+			t.Errorf("%s: errs = %v, want 1 error", tc.query, errs)
+			continue
+		}
+		if got, want := errs[0].Code, tc.code; got != want {
+			t.Errorf("%s: errs[0].Code = %v, want %v", tc.query, got, want)
+		}
+		if got, want := errs[0].Field, tc.field; got != want {
+			t.Errorf("%s: errs[0].Field = %v, want %v", tc.query, got, want)
+		}
+	}
+}
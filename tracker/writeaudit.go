@@ -0,0 +1,439 @@
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultWriteAuditWindow is how long a write audit samples unless told otherwise.
+	defaultWriteAuditWindow = 10 * time.Minute
+	// maxWriteAuditWindow keeps a forgotten write audit from running for ever.
+	maxWriteAuditWindow = 24 * time.Hour
+)
+
+// WriteAudit tallies the MongoDB documents and bytes written per API call
+// over a sampling window. Outside a window it costs nothing but a check.
+// It is safe for concurrent use.
+type WriteAudit struct {
+	sync.Mutex
+	started, until time.Time
+	calls          map[string]*writeTally
+}
+
+type writeTally struct {
+	Requests int64
+	Docs     int64
+	Bytes    int64
+}
+
+// writeAudit samples the writes of this process.
+var writeAudit = &WriteAudit{}
+
+// Start starts a new sampling window, discarding the previous tallies.
+func (a *WriteAudit) Start(window time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	a.started = time.Now()
+	a.until = a.started.Add(window)
+	a.calls = make(map[string]*writeTally)
+}
+
+func (a *WriteAudit) active(now time.Time) bool {
+	a.Lock()
+	defer a.Unlock()
+	return now.Before(a.until)
+}
+
+func (a *WriteAudit) tally(call string, fn func(*writeTally)) {
+	a.Lock()
+	defer a.Unlock()
+	if !time.Now().Before(a.until) {
+		return
+	}
+	t, ok := a.calls[call]
+	if !ok {
+		t = &writeTally{}
+		a.calls[call] = t
+	}
+	fn(t)
+}
+
+// Wrap returns a Storage tallying the writes of call to store,
+// counting a request of call, or store itself outside a sampling window.
+func (a *WriteAudit) Wrap(store Storage, call string) Storage {
+	if !a.active(time.Now()) {
+		return store
+	}
+	a.tally(call, func(t *writeTally) { t.Requests++ })
+	return &auditedStore{store, a, call}
+}
+
+// WriteAuditReport sums up a sampling window. Amplification factors are
+// the documents and bytes written per request of each call.
+type WriteAuditReport struct {
+	Started time.Time          `json:"started"`
+	Until   time.Time          `json:"until"`
+	Active  bool               `json:"active"`
+	Calls   []*WriteAuditCalls `json:"calls"`
+}
+
+type WriteAuditCalls struct {
+	Call            string  `json:"call"`
+	Requests        int64   `json:"requests"`
+	Docs            int64   `json:"docs"`
+	Bytes           int64   `json:"bytes"`
+	DocsPerRequest  float64 `json:"docs_per_request"`
+	BytesPerRequest float64 `json:"bytes_per_request"`
+}
+
+func (a *WriteAudit) Report() *WriteAuditReport {
+	a.Lock()
+	defer a.Unlock()
+	rep := &WriteAuditReport{
+		Started: a.started,
+		Until:   a.until,
+		Active:  time.Now().Before(a.until),
+		Calls:   []*WriteAuditCalls{},
+	}
+	names := make([]string, 0, len(a.calls))
+	for name := range a.calls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := a.calls[name]
+		wc := &WriteAuditCalls{Call: name, Requests: t.Requests, Docs: t.Docs, Bytes: t.Bytes}
+		if t.Requests > 0 {
+			wc.DocsPerRequest = float64(t.Docs) / float64(t.Requests)
+			wc.BytesPerRequest = float64(t.Bytes) / float64(t.Requests)
+		}
+		rep.Calls = append(rep.Calls, wc)
+	}
+	return rep
+}
+
+// bsonSize is the size of v encoded as BSON, the bytes MongoDB is sent to write.
+func bsonSize(v interface{}) int64 {
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return int64(len(b))
+}
+
+// auditedStore is a Storage that tallies in a WriteAudit the documents
+// it writes: whole documents for inserts and the fields set for updates.
+type auditedStore struct {
+	Storage
+	a    *WriteAudit
+	call string
+}
+
+func (s *auditedStore) wrote(err error, docs int, size int64) {
+	if err != nil || docs == 0 {
+		return
+	}
+	s.a.tally(s.call, func(t *writeTally) {
+		t.Docs += int64(docs)
+		t.Bytes += int64(docs) * size
+	})
+}
+
+// closedFields are the fields set when closing a session.
+func closedFields(reason string) bson.M {
+	return bson.M{"closed_at": bson.Now(), "closed_reason": reason}
+}
+
+func (s *auditedStore) InsertInstallation(i *Installation) error {
+	err := s.Storage.InsertInstallation(i)
+	s.wrote(err, 1, bsonSize(i))
+	return err
+}
+
+func (s *auditedStore) ReplaceStubInstallation(i *Installation) error {
+	err := s.Storage.ReplaceStubInstallation(i)
+	s.wrote(err, 1, bsonSize(i))
+	return err
+}
+
+func (s *auditedStore) InsertSession(x *Session) error {
+	err := s.Storage.InsertSession(x)
+	s.wrote(err, 1, bsonSize(x))
+	return err
+}
+
+func (s *auditedStore) CloseSession(x *Session) error {
+	err := s.Storage.CloseSession(x)
+	s.wrote(err, 1, bsonSize(closedFields(x.ClosedReason)))
+	return err
+}
+
+func (s *auditedStore) PingSession(x *Session) error {
+	err := s.Storage.PingSession(x)
+	s.wrote(err, 1, bsonSize(pingUpdate(x)))
+	return err
+}
+
+func (s *auditedStore) UpdateSessionMetadata(x *Session) error {
+	err := s.Storage.UpdateSessionMetadata(x)
+	s.wrote(err, 1, bsonSize(x.Metadata))
+	return err
+}
+
+func (s *auditedStore) TransferSession(x *Session, to string) error {
+	err := s.Storage.TransferSession(x, to)
+	s.wrote(err, 1, bsonSize(bson.M{"machine_id": to, "last_ping": bson.Now(),
+		"transfers": &SessionTransfer{From: x.MachineId, To: to, At: bson.Now()}}))
+	return err
+}
+
+func (s *auditedStore) ReopenSession(x *Session, closedSince time.Time) error {
+	err := s.Storage.ReopenSession(x, closedSince)
+	s.wrote(err, 1, bsonSize(bson.M{"closed_at": time.Time{}, "last_ping": bson.Now()}))
+	return err
+}
+
+func (s *auditedStore) ResumeSession(x *Session, closedSince time.Time) error {
+	err := s.Storage.ResumeSession(x, closedSince)
+	s.wrote(err, 1, bsonSize(bson.M{"closed_at": time.Time{}, "last_ping": bson.Now()}))
+	return err
+}
+
+func (s *auditedStore) CloseSupersededSessions(x *Session) ([]*Session, error) {
+	closed, err := s.Storage.CloseSupersededSessions(x)
+	s.wrote(err, len(closed), bsonSize(closedFields(ClosedSuperseded)))
+	return closed, err
+}
+
+func (s *auditedStore) ExpireSessions(before time.Time) ([]*Session, error) {
+	closed, err := s.Storage.ExpireSessions(before)
+	s.wrote(err, len(closed), bsonSize(closedFields(ClosedExpired)))
+	return closed, err
+}
+
+func (s *auditedStore) InsertAuditEntry(x *AuditEntry) error {
+	err := s.Storage.InsertAuditEntry(x)
+	s.wrote(err, 1, bsonSize(x))
+	return err
+}
+
+func (s *auditedStore) RecordCrash(signature, traceback string, c *Crash) error {
+	err := s.Storage.RecordCrash(signature, traceback, c)
+	// The traceback is only written with the first report of a signature,
+	// count it every time to stay on the safe side.
+	s.wrote(err, 1, bsonSize(bson.M{"_id": signature, "traceback": traceback, "recent": c}))
+	return err
+}
+
+func (s *auditedStore) InsertEvent(e *Event) error {
+	err := s.Storage.InsertEvent(e)
+	s.wrote(err, 1, bsonSize(e))
+	return err
+}
+
+func (s *auditedStore) InsertFeedback(f *Feedback) error {
+	err := s.Storage.InsertFeedback(f)
+	s.wrote(err, 1, bsonSize(f))
+	return err
+}
+
+func (s *auditedStore) InsertJobRun(run *JobRun) error {
+	err := s.Storage.InsertJobRun(run)
+	s.wrote(err, 1, bsonSize(run))
+	return err
+}
+
+func (s *auditedStore) InsertBlock(b *Block) error {
+	err := s.Storage.InsertBlock(b)
+	s.wrote(err, 1, bsonSize(b))
+	return err
+}
+
+func (s *auditedStore) RemoveBlock(id bson.ObjectId) error {
+	err := s.Storage.RemoveBlock(id)
+	s.wrote(err, 1, 0)
+	return err
+}
+
+func (s *auditedStore) InsertAPIKey(k *APIKey) error {
+	err := s.Storage.InsertAPIKey(k)
+	s.wrote(err, 1, bsonSize(k))
+	return err
+}
+
+func (s *auditedStore) RevokeAPIKey(id bson.ObjectId) error {
+	err := s.Storage.RevokeAPIKey(id)
+	s.wrote(err, 1, bsonSize(bson.M{"revoked_at": bson.Now()}))
+	return err
+}
+
+func (s *auditedStore) InsertWebhook(h *Webhook) error {
+	err := s.Storage.InsertWebhook(h)
+	s.wrote(err, 1, bsonSize(h))
+	return err
+}
+
+func (s *auditedStore) RemoveWebhook(id bson.ObjectId) error {
+	err := s.Storage.RemoveWebhook(id)
+	s.wrote(err, 1, 0)
+	return err
+}
+
+func (s *auditedStore) InsertRelease(x *Release) error {
+	err := s.Storage.InsertRelease(x)
+	s.wrote(err, 1, bsonSize(x))
+	return err
+}
+
+func (s *auditedStore) RemoveRelease(version string) error {
+	err := s.Storage.RemoveRelease(version)
+	s.wrote(err, 1, 0)
+	return err
+}
+
+func (s *auditedStore) AddReleaseArtifact(version string, a *ReleaseArtifact) error {
+	err := s.Storage.AddReleaseArtifact(version, a)
+	s.wrote(err, 1, bsonSize(a))
+	return err
+}
+
+func (s *auditedStore) CountDownload(version, platform string) error {
+	err := s.Storage.CountDownload(version, platform)
+	s.wrote(err, 1, bsonSize(bson.M{"downloads." + platform: 1}))
+	return err
+}
+
+func (s *auditedStore) SetFeatureFlag(f *FeatureFlag) error {
+	err := s.Storage.SetFeatureFlag(f)
+	s.wrote(err, 1, bsonSize(f))
+	return err
+}
+
+func (s *auditedStore) RemoveFeatureFlag(name string) error {
+	err := s.Storage.RemoveFeatureFlag(name)
+	s.wrote(err, 1, 0)
+	return err
+}
+
+func (s *auditedStore) InsertClaim(x *Claim) error {
+	err := s.Storage.InsertClaim(x)
+	s.wrote(err, 1, bsonSize(x))
+	return err
+}
+
+func (s *auditedStore) PingInstallation(machineId, xmppvoxVersion string) error {
+	err := s.Storage.PingInstallation(machineId, xmppvoxVersion)
+	s.wrote(err, 1, bsonSize(bson.M{"last_seen": bson.Now(), "current_version": xmppvoxVersion}))
+	return err
+}
+
+func (s *auditedStore) UninstallInstallation(machineId, reason string) error {
+	err := s.Storage.UninstallInstallation(machineId, reason)
+	s.wrote(err, 1, bsonSize(bson.M{"uninstalled_at": bson.Now(), "uninstall_reason": reason}))
+	return err
+}
+
+func (s *auditedStore) ClaimInstallation(code, machineId string, debugUntil time.Time) (*Claim, error) {
+	x, err := s.Storage.ClaimInstallation(code, machineId, debugUntil)
+	// The claim and the installation.
+	s.wrote(err, 2, bsonSize(bson.M{"machine_id": machineId, "claimed_at": bson.Now(), "debug_until": debugUntil}))
+	return x, err
+}
+
+func (s *auditedStore) InsertStorageSnapshot(x *StorageSnapshot) error {
+	err := s.Storage.InsertStorageSnapshot(x)
+	s.wrote(err, 1, bsonSize(x))
+	return err
+}
+
+func (s *auditedStore) TagSession(id SessionId, tag string, remove bool) error {
+	err := s.Storage.TagSession(id, tag, remove)
+	s.wrote(err, 1, bsonSize(tagUpdate(tag, remove)))
+	return err
+}
+
+func (s *auditedStore) TagInstallation(machineId, tag string, remove bool) error {
+	err := s.Storage.TagInstallation(machineId, tag, remove)
+	s.wrote(err, 1, bsonSize(tagUpdate(tag, remove)))
+	return err
+}
+
+func (s *auditedStore) AddSessionNote(id SessionId, n *Note) error {
+	err := s.Storage.AddSessionNote(id, n)
+	s.wrote(err, 1, bsonSize(n))
+	return err
+}
+
+func (s *auditedStore) AddInstallationNote(machineId string, n *Note) error {
+	err := s.Storage.AddInstallationNote(machineId, n)
+	s.wrote(err, 1, bsonSize(n))
+	return err
+}
+
+func (s *auditedStore) ApplyRetention(r Retention, now time.Time) (int, error) {
+	n, err := s.Storage.ApplyRetention(r, now)
+	s.wrote(err, n, 0)
+	return n, err
+}
+
+func (s *auditedStore) UpsertRollup(x *Rollup) error {
+	err := s.Storage.UpsertRollup(x)
+	s.wrote(err, 1, bsonSize(x))
+	return err
+}
+
+// erasedDocs counts the documents written by an erasure.
+func erasedDocs(e *Erasure) int {
+	if e == nil {
+		return 0
+	}
+	return e.Installations + e.Sessions + e.Events + e.Feedback + e.CrashGroups
+}
+
+func (s *auditedStore) EraseUser(jid string, anonymize bool) (*Erasure, error) {
+	e, err := s.Storage.EraseUser(jid, anonymize)
+	s.wrote(err, erasedDocs(e), 0)
+	return e, err
+}
+
+func (s *auditedStore) EraseMachine(machineId string, anonymize bool, pseudonym string) (*Erasure, error) {
+	e, err := s.Storage.EraseMachine(machineId, anonymize, pseudonym)
+	s.wrote(err, erasedDocs(e), 0)
+	return e, err
+}
+
+// WriteAuditHandler reports the tallies of the current or last write audit.
+func WriteAuditHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(writeAudit.Report())
+}
+
+// StartWriteAuditHandler starts a write audit of window seconds, 600 by default.
+func StartWriteAuditHandler(w http.ResponseWriter, r *http.Request, c *Context) {
+	s := r.PostFormValue("window")
+	if errs := checkParams(r, nil, "window"); errs != nil {
+		writeErrors(w, r, errs, http.StatusBadRequest)
+		return
+	}
+	window := defaultWriteAuditWindow
+	if s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || time.Duration(n)*time.Second > maxWriteAuditWindow {
+			writeError(w, r, &APIError{"invalid_window", "window", "",
+				fmt.Sprintf("Invalid window, expected a number of seconds from 1 to %d", int(maxWriteAuditWindow.Seconds()))},
+				http.StatusBadRequest)
+			return
+		}
+		window = time.Duration(n) * time.Second
+	}
+	writeAudit.Start(window)
+	c.Log.Infof("[write_audit] sampling for %s on request from %s", window, r.RemoteAddr)
+	fmt.Fprintf(w, "Write audit running until %s\n", time.Now().Add(window).UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInactiveDoesNotWrap(t *testing.T) {
+	a := &WriteAudit{}
+	store := NewMemoryStore()
+	if got, want := a.Wrap(store, "/1/session/new"), Storage(store); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTalliesPerCall(t *testing.T) {
+	a := &WriteAudit{}
+	a.Start(time.Minute)
+	store := NewMemoryStore()
+	session := NewSession("testuser@server.org", "00:26:cc:18:be:14", "1.0", nil)
+	if err := a.Wrap(store, "/1/session/new").InsertSession(session); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := a.Wrap(store, "/1/session/ping").PingSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A failed write writes nothing
+	if a.Wrap(store, "/1/session/ping").PingSession(&Session{}) == nil {
+		t.Fatal("got nil")
+	}
+
+	rep := a.Report()
+	if got, want := rep.Active, true; got != want {
+		t.Errorf("rep.Active = %v, want %v", got, want)
+	}
+	if got, want := len(rep.Calls), 2; got != want {
+		t.Fatalf("len(rep.Calls) = %d, want %d", got, want)
+	}
+	if got, want := rep.Calls[0].Call, "/1/session/new"; got != want {
+		t.Errorf("rep.Calls[0].Call = %v, want %v", got, want)
+	}
+	if got, want := rep.Calls[0].Docs, int64(1); got != want {
+		t.Errorf("rep.Calls[0].Docs = %v, want %v", got, want)
+	}
+	if got, want := rep.Calls[0].Bytes, bsonSize(session); got != want {
+		t.Errorf("rep.Calls[0].Bytes = %v, want %v", got, want)
+	}
+	ping := rep.Calls[1]
+	if got, want := ping.Call, "/1/session/ping"; got != want {
+		t.Errorf("ping.Call = %v, want %v", got, want)
+	}
+	if got, want := ping.Requests, int64(3); got != want {
+		t.Errorf("ping.Requests = %v, want %v", got, want)
+	}
+	if got, want := ping.Docs, int64(2); got != want {
+		t.Errorf("ping.Docs = %v, want %v", got, want)
+	}
+	if got, want := ping.DocsPerRequest, 2.0/3; got != want {
+		t.Errorf("ping.DocsPerRequest = %v, want %v", got, want)
+	}
+	if got, want := ping.Bytes > 0, true; got != want {
+		t.Errorf("ping.Bytes > 0 = %v, want %v", got, want)
+	}
+}